@@ -0,0 +1,377 @@
+// Package armor implements the PEM encoding of encrypted data. The data is
+// encoded and decoded one line at a time, so armoring large files doesn't
+// require holding them in memory. The format is the one used by age, which
+// allows age to read armored tlock data and the other way around.
+package armor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// These constants define the lines that enclose armored data with the
+// default label. Prefix starts the first line whatever the label is.
+const (
+	Header = "-----BEGIN " + DefaultLabel + "-----"
+	Footer = "-----END " + DefaultLabel + "-----"
+	Prefix = "-----BEGIN "
+)
+
+// These constants define the default label and line width, which match the
+// format used by age, and the widest lines accepted.
+const (
+	DefaultLabel = "AGE ENCRYPTED FILE"
+	DefaultWidth = 64
+	MaxWidth     = 1024
+)
+
+// MaxPreambleSize is the size of the longest explanatory text accepted before
+// the header line.
+const MaxPreambleSize = 1024
+
+// ErrInvalidArmor represents an error when armored data can't be decoded.
+var ErrInvalidArmor = errors.New("invalid armor")
+
+// =============================================================================
+
+// Option represents a setting of a Writer.
+type Option func(*Writer)
+
+// WithWidth sets the number of columns of the base64 lines. It has to be a
+// multiple of 4 no larger than MaxWidth, so every line encodes whole bytes.
+// Data armored with a width other than DefaultWidth can't be read by age.
+func WithWidth(columns int) Option {
+	return func(w *Writer) {
+		w.width = columns
+	}
+}
+
+// WithLabel sets the label of the header and footer lines, such as
+// "TLOCK ENCRYPTED FILE" for tools that expect classic PEM labels. Data
+// armored with a label other than DefaultLabel can't be read by age.
+func WithLabel(label string) Option {
+	return func(w *Writer) {
+		w.label = label
+	}
+}
+
+// WithPreamble sets explanatory text written before the header line, such as
+// when the data can be decrypted, for data pasted into an email or a chat. The
+// text is made of printable ASCII lines, none of which starts with "-----",
+// and is at most MaxPreambleSize long. Readers skip it with SkipPreamble, but
+// age can't read armored data with a preamble.
+func WithPreamble(text string) Option {
+	return func(w *Writer) {
+		w.preamble = text
+	}
+}
+
+// Writer encodes the data written to it and writes the armored result to the
+// destination as soon as a line is complete.
+type Writer struct {
+	dst      io.Writer
+	width    int
+	label    string
+	preamble string
+	buf      []byte
+	line     []byte
+	started  bool
+	closed   bool
+}
+
+// NewWriter constructs a writer that armors the data written to it. Close
+// must be called to write the last line and the footer.
+func NewWriter(dst io.Writer, opts ...Option) *Writer {
+	w := Writer{
+		dst:   dst,
+		width: DefaultWidth,
+		label: DefaultLabel,
+	}
+
+	for _, opt := range opts {
+		opt(&w)
+	}
+
+	return &w
+}
+
+// Write implements the io.Writer interface.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("armor writer already closed")
+	}
+
+	if err := w.start(); err != nil {
+		return 0, err
+	}
+
+	var n int
+	for len(p) > 0 {
+		c := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		p = p[c:]
+		n += c
+
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flushLine(); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// Close writes the last line and the footer. It doesn't close the
+// destination.
+func (w *Writer) Close() error {
+	if w.closed {
+		return errors.New("armor writer already closed")
+	}
+	w.closed = true
+
+	if err := w.start(); err != nil {
+		return err
+	}
+
+	if len(w.buf) > 0 {
+		if err := w.flushLine(); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w.dst, footer(w.label)+"\n")
+	return err
+}
+
+// start validates the settings and writes the header before the first line.
+func (w *Writer) start() error {
+	if w.started {
+		return nil
+	}
+
+	if w.width <= 0 || w.width%4 != 0 || w.width > MaxWidth {
+		return fmt.Errorf("invalid line width %d: should be a multiple of 4 up to %d", w.width, MaxWidth)
+	}
+
+	if err := validLabel(w.label); err != nil {
+		return err
+	}
+
+	if err := validPreamble(w.preamble); err != nil {
+		return err
+	}
+
+	w.started = true
+	w.buf = make([]byte, 0, w.width/4*3)
+	w.line = make([]byte, w.width+1)
+
+	var start string
+	if w.preamble != "" {
+		start = strings.TrimSuffix(w.preamble, "\n") + "\n"
+	}
+
+	_, err := io.WriteString(w.dst, start+header(w.label)+"\n")
+	return err
+}
+
+// flushLine encodes and writes the buffered bytes as a line.
+func (w *Writer) flushLine() error {
+	n := base64.StdEncoding.EncodedLen(len(w.buf))
+	base64.StdEncoding.Encode(w.line, w.buf)
+	w.line[n] = '\n'
+
+	w.buf = w.buf[:0]
+	_, err := w.dst.Write(w.line[:n+1])
+	return err
+}
+
+// =============================================================================
+
+// Reader decodes armored data one line at a time. Lines can end with CRLF
+// and surrounding whitespace is ignored, but the base64 encoding is strict.
+// The label and the line width are taken from the data, so data armored with
+// any of the writer's options can be read.
+type Reader struct {
+	src    *bufio.Reader
+	footer string
+	width  int
+	buf    []byte
+	unread []byte
+	err    error
+}
+
+// NewReader constructs a reader that decodes the armored data read from the
+// source. Reading stops at the footer.
+func NewReader(src io.Reader) *Reader {
+	return &Reader{
+		src: bufio.NewReaderSize(src, MaxWidth+2),
+	}
+}
+
+// Read implements the io.Reader interface.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.unread) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		if err := r.readLine(); err != nil {
+			r.err = err
+		}
+	}
+
+	n := copy(p, r.unread)
+	r.unread = r.unread[n:]
+
+	return n, nil
+}
+
+// readLine decodes the next line. Once the footer was read the reader's
+// error is set to io.EOF.
+func (r *Reader) readLine() error {
+	if r.footer == "" {
+		line, err := r.line()
+		if err != nil {
+			return err
+		}
+
+		label := strings.TrimSuffix(strings.TrimPrefix(line, Prefix), "-----")
+		if line != header(label) || validLabel(label) != nil {
+			return fmt.Errorf("%w: unexpected first line %q", ErrInvalidArmor, line)
+		}
+		r.footer = footer(label)
+	}
+
+	line, err := r.line()
+	if err != nil {
+		return err
+	}
+
+	if line == r.footer {
+		return io.EOF
+	}
+
+	// The first line sets the width of all but the last line.
+	if r.width == 0 {
+		if len(line) > MaxWidth || len(line)%4 != 0 {
+			return fmt.Errorf("%w: invalid line width %d", ErrInvalidArmor, len(line))
+		}
+		r.width = len(line)
+		r.buf = make([]byte, r.width/4*3)
+	}
+
+	if len(line) > r.width {
+		return fmt.Errorf("%w: line longer than %d columns", ErrInvalidArmor, r.width)
+	}
+
+	n, err := base64.StdEncoding.Strict().Decode(r.buf, []byte(line))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArmor, err)
+	}
+	r.unread = r.buf[:n]
+
+	// Only the last line can be shorter than a full line, and it has to be
+	// followed by the footer.
+	if len(line) < r.width {
+		line, err := r.line()
+		if err != nil {
+			return err
+		}
+		if line != r.footer {
+			return fmt.Errorf("%w: unexpected closing line %q", ErrInvalidArmor, line)
+		}
+		r.err = io.EOF
+	}
+
+	return nil
+}
+
+// line reads the next line without the surrounding whitespace. Lines are
+// limited to the size of the reader's buffer.
+func (r *Reader) line() (string, error) {
+	line, err := r.src.ReadSlice('\n')
+	switch {
+	case errors.Is(err, bufio.ErrBufferFull):
+		return "", fmt.Errorf("%w: line too long", ErrInvalidArmor)
+	case errors.Is(err, io.EOF) && len(line) == 0:
+		return "", fmt.Errorf("%w: %v", ErrInvalidArmor, io.ErrUnexpectedEOF)
+	case err != nil && !errors.Is(err, io.EOF):
+		return "", err
+	}
+
+	return string(bytes.TrimSpace(line)), nil
+}
+
+// =============================================================================
+
+// header returns the first line of armored data with the label.
+func header(label string) string {
+	return Prefix + label + "-----"
+}
+
+// footer returns the last line of armored data with the label.
+func footer(label string) string {
+	return "-----END " + label + "-----"
+}
+
+// validLabel checks that the label only holds uppercase letters, digits and
+// single spaces, as PEM labels do.
+func validLabel(label string) error {
+	if label == "" || strings.HasPrefix(label, " ") || strings.HasSuffix(label, " ") || strings.Contains(label, "  ") {
+		return fmt.Errorf("invalid label %q", label)
+	}
+
+	for _, c := range label {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != ' ' {
+			return fmt.Errorf("invalid label %q", label)
+		}
+	}
+
+	return nil
+}
+
+// SkipPreamble discards the explanatory text written with WithPreamble before
+// the header line and reports whether the source then starts with a header
+// line. Nothing is discarded unless the text before the header line is a
+// valid preamble, so other data is left for the caller to read.
+func SkipPreamble(r *bufio.Reader) bool {
+	if start, _ := r.Peek(len(Prefix)); string(start) == Prefix {
+		return true
+	}
+
+	peeked, _ := r.Peek(MaxPreambleSize + len(Prefix) + 1)
+	i := bytes.Index(peeked, []byte("\n"+Prefix))
+	if i <= 0 || validPreamble(string(peeked[:i])) != nil {
+		return false
+	}
+
+	_, err := r.Discard(i + 1)
+	return err == nil
+}
+
+// validPreamble checks that the explanatory text can't be mistaken for the
+// header line or carry control characters, allowing CRLF line endings.
+func validPreamble(text string) error {
+	if len(text) > MaxPreambleSize {
+		return fmt.Errorf("preamble of %d bytes is larger than %d bytes", len(text), MaxPreambleSize)
+	}
+
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		if strings.HasPrefix(line, "-----") {
+			return fmt.Errorf("invalid preamble line %q", line)
+		}
+		for _, c := range strings.TrimSuffix(line, "\r") {
+			if (c < ' ' || c > '~') && c != '\t' {
+				return fmt.Errorf("invalid preamble line %q", line)
+			}
+		}
+	}
+
+	return nil
+}
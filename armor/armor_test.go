@@ -0,0 +1,225 @@
+package armor_test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	ageArmor "filippo.io/age/armor"
+	"github.com/drand/tlock/armor"
+)
+
+func Test_RoundTrip(t *testing.T) {
+	sizes := []int{0, 1, 47, 48, 49, 96, 48*1000 + 5}
+
+	for _, size := range sizes {
+		data := make([]byte, size)
+		if _, err := rand.Read(data); err != nil {
+			t.Fatalf("rand error %s", err)
+		}
+
+		var armored bytes.Buffer
+		w := armor.NewWriter(&armored)
+		if _, err := io.Copy(w, iotest.OneByteReader(bytes.NewReader(data))); err != nil {
+			t.Fatalf("size %d: write error %s", size, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("size %d: close error %s", size, err)
+		}
+
+		for _, line := range strings.Split(strings.TrimSuffix(armored.String(), "\n"), "\n") {
+			if len(line) > 64 {
+				t.Fatalf("size %d: line longer than 64 columns: %q", size, line)
+			}
+		}
+
+		b, err := io.ReadAll(iotest.OneByteReader(armor.NewReader(&armored)))
+		if err != nil {
+			t.Fatalf("size %d: read error %s", size, err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Fatalf("size %d: decoded data is invalid; got %d bytes", size, len(b))
+		}
+	}
+}
+
+func Test_AgeCompatibility(t *testing.T) {
+	data := bytes.Repeat([]byte("armored data\n"), 1000)
+
+	var ours bytes.Buffer
+	w := armor.NewWriter(&ours)
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("write error %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close error %s", err)
+	}
+
+	var theirs bytes.Buffer
+	aw := ageArmor.NewWriter(&theirs)
+	if _, err := aw.Write(data); err != nil {
+		t.Fatalf("age write error %s", err)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatalf("age close error %s", err)
+	}
+
+	if !bytes.Equal(ours.Bytes(), theirs.Bytes()) {
+		t.Fatal("expecting the same encoding as age")
+	}
+
+	b, err := io.ReadAll(ageArmor.NewReader(bytes.NewReader(ours.Bytes())))
+	if err != nil || !bytes.Equal(b, data) {
+		t.Fatalf("expecting age to decode the data; got %v", err)
+	}
+}
+
+func Test_ReaderCRLF(t *testing.T) {
+	var armored bytes.Buffer
+	w := armor.NewWriter(&armored)
+	w.Write([]byte("windows line endings"))
+	w.Close()
+
+	crlf := strings.ReplaceAll(armored.String(), "\n", "\r\n")
+
+	b, err := io.ReadAll(armor.NewReader(strings.NewReader(crlf)))
+	if err != nil {
+		t.Fatalf("read error %s", err)
+	}
+	if string(b) != "windows line endings" {
+		t.Fatalf("decoded data is invalid; got %q", b)
+	}
+}
+
+func Test_ReaderErrors(t *testing.T) {
+	tests := map[string]string{
+		"missing header": "Zm9v\n" + armor.Footer + "\n",
+		"missing footer": armor.Header + "\nZm9v\n",
+		"bad base64":     armor.Header + "\nZm9v!\n" + armor.Footer + "\n",
+		"long line":      armor.Header + "\n" + strings.Repeat("A", 64) + "\n" + strings.Repeat("A", 68) + "\n" + armor.Footer + "\n",
+		"short line":     armor.Header + "\n" + strings.Repeat("A", 64) + "\nZm9v\n" + strings.Repeat("A", 64) + "\n" + armor.Footer + "\n",
+		"wrong footer":   armor.Header + "\nZm9v\n-----END TLOCK ENCRYPTED FILE-----\n",
+		"bad label":      "-----BEGIN age file-----\nZm9v\n-----END age file-----\n",
+		"truncated":      armor.Header + "\n",
+	}
+
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := io.ReadAll(armor.NewReader(strings.NewReader(input)))
+			if !errors.Is(err, armor.ErrInvalidArmor) {
+				t.Fatalf("expecting error to contain '%s'; got %v", armor.ErrInvalidArmor, err)
+			}
+		})
+	}
+}
+
+func Test_Options(t *testing.T) {
+	data := bytes.Repeat([]byte("custom armor\n"), 100)
+
+	var armored bytes.Buffer
+	w := armor.NewWriter(&armored, armor.WithWidth(76), armor.WithLabel("TLOCK ENCRYPTED FILE"))
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("write error %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close error %s", err)
+	}
+
+	lines := strings.Split(armored.String(), "\n")
+	if lines[0] != "-----BEGIN TLOCK ENCRYPTED FILE-----" || len(lines[1]) != 76 {
+		t.Fatalf("unexpected armor %q", lines[:2])
+	}
+	if lines[len(lines)-2] != "-----END TLOCK ENCRYPTED FILE-----" {
+		t.Fatalf("unexpected footer %q", lines[len(lines)-2])
+	}
+
+	b, err := io.ReadAll(armor.NewReader(&armored))
+	if err != nil {
+		t.Fatalf("read error %s", err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Fatalf("decoded data is invalid; got %d bytes", len(b))
+	}
+}
+
+func Test_InvalidOptions(t *testing.T) {
+	tests := map[string]armor.Option{
+		"zero width":      armor.WithWidth(0),
+		"unaligned width": armor.WithWidth(70),
+		"wide width":      armor.WithWidth(armor.MaxWidth + 4),
+		"empty label":     armor.WithLabel(""),
+		"lowercase label": armor.WithLabel("tlock"),
+		"header preamble": armor.WithPreamble("-----BEGIN AGE ENCRYPTED FILE-----"),
+		"binary preamble": armor.WithPreamble("opens\x00later"),
+		"long preamble":   armor.WithPreamble(strings.Repeat("a", armor.MaxPreambleSize+1)),
+	}
+
+	for name, opt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := armor.NewWriter(io.Discard, opt)
+			if _, err := w.Write([]byte("data")); err == nil {
+				t.Fatal("expecting an error")
+			}
+		})
+	}
+}
+
+func Test_Preamble(t *testing.T) {
+	data := []byte("pasted into a chat")
+
+	var armored bytes.Buffer
+	w := armor.NewWriter(&armored, armor.WithPreamble("Opens after 2025-08-01 12:00:00 UTC."))
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("write error %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close error %s", err)
+	}
+
+	if !strings.HasPrefix(armored.String(), "Opens after 2025-08-01 12:00:00 UTC.\n"+armor.Header+"\n") {
+		t.Fatalf("unexpected armor %q", armored.String())
+	}
+
+	// The preamble survives a round trip through a mail client.
+	crlf := strings.ReplaceAll(armored.String(), "\n", "\r\n")
+
+	br := bufio.NewReader(strings.NewReader(crlf))
+	if !armor.SkipPreamble(br) {
+		t.Fatal("expecting the preamble to be skipped")
+	}
+
+	b, err := io.ReadAll(armor.NewReader(br))
+	if err != nil {
+		t.Fatalf("read error %s", err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Fatalf("expecting %q; got %q", data, b)
+	}
+}
+
+func Test_SkipPreambleRejects(t *testing.T) {
+	tests := map[string]string{
+		"no header":      "Opens after 2025-08-01.\n",
+		"binary":         "age\x00\x01\n" + armor.Header + "\n",
+		"too long":       strings.Repeat("a", armor.MaxPreambleSize+1) + "\n" + armor.Header + "\n",
+		"other armor":    "-----END X-----\n" + armor.Header + "\n",
+		"not at a start": "text " + armor.Header + "\n",
+	}
+
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			br := bufio.NewReader(strings.NewReader(input))
+			if armor.SkipPreamble(br) {
+				t.Fatal("expecting no preamble")
+			}
+			if rest, _ := io.ReadAll(br); string(rest) != input {
+				t.Fatalf("expecting nothing discarded; got %q", rest)
+			}
+		})
+	}
+}
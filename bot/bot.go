@@ -0,0 +1,299 @@
+// Package bot runs a chat bot that accepts time lock encrypted messages and
+// posts their plain text to a channel once their rounds are reached, such as
+// predictions revealed after the fact or sealed votes counted in the open.
+// Users send armored encrypted messages to the bot, which stores them, tells
+// the senders when they are released, and posts them when their round
+// arrives. The messages are scheduled and decrypted with the queue package,
+// with any tlock options, such as a beacon source, so the bot can run where
+// drand can't be reached. Adapting a chat protocol only requires implementing
+// the Chat interface, which Matrix implements for Matrix rooms.
+package bot
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/queue"
+	"github.com/drand/tlock/relay"
+)
+
+// messagesDir is the prefix of the keys of the stored messages.
+const messagesDir = "messages/"
+
+// Message is a message sent to the bot.
+type Message struct {
+	// ID identifies the message in the chat, so a message delivered twice
+	// is only stored once.
+	ID     string
+	Sender string
+	Text   string
+}
+
+// Chat is the chat protocol the bot runs on, such as Matrix or Nostr.
+type Chat interface {
+	// Receive blocks until a message is sent to the bot, and returns io.EOF
+	// once the chat is closed. Messages of the bot itself have to be
+	// skipped.
+	Receive(ctx context.Context) (Message, error)
+
+	// Post posts the text to the channel the messages are released to.
+	Post(ctx context.Context, text string) error
+}
+
+// Replier is implemented by chats that can answer a message, which the bot
+// uses to tell the sender when the message is released, or why it can't be.
+type Replier interface {
+	Reply(ctx context.Context, m Message, text string) error
+}
+
+// =============================================================================
+
+// Option configures a bot constructed with New.
+type Option func(b *Bot)
+
+// WithTlockOptions sets the options of the tlock decrypting the messages,
+// such as a beacon source or a signature cache.
+func WithTlockOptions(opts ...tlock.Option) Option {
+	return func(b *Bot) {
+		b.opts = opts
+	}
+}
+
+// WithFormat sets the function formatting the text posted for a released
+// message. The default names the sender and the round.
+func WithFormat(fn func(m Message, roundNumber uint64, plain []byte) string) Option {
+	return func(b *Bot) {
+		b.format = fn
+	}
+}
+
+// Bot accepts time lock encrypted messages from a chat and posts them once
+// decrypted.
+type Bot struct {
+	network   queue.Network
+	chainHash string
+	chat      Chat
+	storage   relay.Storage
+	opts      []tlock.Option
+	format    func(m Message, roundNumber uint64, plain []byte) string
+
+	mu      sync.Mutex
+	pending map[string]stored
+}
+
+// stored is the JSON encoding of a message kept until it's released.
+type stored struct {
+	Message     Message `json:"message"`
+	RoundNumber uint64  `json:"round"`
+}
+
+// New constructs a bot for the messages of the chat locked to the network,
+// which keeps the messages in the storage until they are released.
+func New(network queue.Network, chat Chat, storage relay.Storage, opts ...Option) *Bot {
+	b := Bot{
+		network:   network,
+		chainHash: network.ChainHash(),
+		chat:      chat,
+		storage:   storage,
+		format:    defaultFormat,
+		pending:   make(map[string]stored),
+	}
+
+	for _, opt := range opts {
+		opt(&b)
+	}
+
+	return &b
+}
+
+// defaultFormat names the sender and the round of the message before its
+// plain text.
+func defaultFormat(m Message, roundNumber uint64, plain []byte) string {
+	return fmt.Sprintf("%s sealed this message until round %d:\n%s", m.Sender, roundNumber, plain)
+}
+
+// Run receives messages until the context is canceled or the chat fails.
+// The messages stored by a previous run are scheduled again first. Once the
+// chat returned io.EOF, Run waits for the stored messages to be posted and
+// returns nil.
+func (b *Bot) Run(ctx context.Context) error {
+	keys, err := b.storage.List(ctx, messagesDir)
+	if err != nil {
+		return fmt.Errorf("list messages: %w", err)
+	}
+
+	c := queue.NewConsumer(b.network, &source{bot: b, keys: keys}, sink{bot: b},
+		queue.WithTlockOptions(b.opts...),
+		queue.WithErrorHandler(func(m queue.Message, err error) {
+			b.reply(ctx, b.release(m.Key).Message, fmt.Sprintf("your message can't be decrypted: %v", err))
+		}),
+	)
+
+	return c.Run(ctx)
+}
+
+// accept stores the message if it's locked to the chain of the network,
+// returning the message to schedule. Messages that don't look encrypted are
+// ignored, and the senders of invalid ones are told why.
+func (b *Bot) accept(ctx context.Context, m Message) (queue.Message, bool, error) {
+	text := strings.TrimSpace(m.Text)
+	if !tlock.IsEncrypted([]byte(text)) {
+		return queue.Message{}, false, nil
+	}
+
+	header, err := tlock.ReadHeader(strings.NewReader(text))
+	if err == nil && header.ChainHash != b.chainHash {
+		err = fmt.Errorf("it's locked to chain %s instead of %s", header.ChainHash, b.chainHash)
+	}
+	if err != nil {
+		b.reply(ctx, m, fmt.Sprintf("your message can't be accepted: %v", err))
+		return queue.Message{}, false, nil
+	}
+
+	m.Text = text
+	s := stored{Message: m, RoundNumber: header.RoundNumber}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return queue.Message{}, false, err
+	}
+
+	key := messageKey(m.ID)
+	if b.holding(key) {
+		return queue.Message{}, false, nil
+	}
+
+	if err := b.storage.Put(ctx, key, bytes.NewReader(data)); err != nil {
+		return queue.Message{}, false, fmt.Errorf("store message: %w", err)
+	}
+
+	b.hold(key, s)
+	b.reply(ctx, m, fmt.Sprintf("your message is sealed until round %d, around %s", header.RoundNumber, b.network.RoundTime(header.RoundNumber).UTC().Format(time.RFC3339)))
+
+	return queue.Message{Key: key, Data: []byte(text)}, true, nil
+}
+
+// load returns the stored message to schedule again.
+func (b *Bot) load(ctx context.Context, key string) (queue.Message, error) {
+	r, err := b.storage.Get(ctx, key)
+	if err != nil {
+		return queue.Message{}, fmt.Errorf("load message: %w", err)
+	}
+	defer r.Close()
+
+	var s stored
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return queue.Message{}, fmt.Errorf("load message %s: %w", key, err)
+	}
+
+	b.hold(key, s)
+	return queue.Message{Key: key, Data: []byte(s.Message.Text)}, nil
+}
+
+// hold keeps the message in memory until it's released.
+func (b *Bot) hold(key string, s stored) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pending[key] = s
+}
+
+// holding reports whether the message is already scheduled, as when the chat
+// delivers it twice.
+func (b *Bot) holding(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, ok := b.pending[key]
+	return ok
+}
+
+// release forgets the message, returning it.
+func (b *Bot) release(key string) stored {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.pending[key]
+	delete(b.pending, key)
+	return s
+}
+
+// reply answers the message if the chat can. Failing to answer doesn't stop
+// the bot.
+func (b *Bot) reply(ctx context.Context, m Message, text string) {
+	if r, ok := b.chat.(Replier); ok && m.ID != "" {
+		r.Reply(ctx, m, text)
+	}
+}
+
+// messageKey returns the key of the stored message with the ID, which can be
+// any string.
+func messageKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return messagesDir + hex.EncodeToString(sum[:16])
+}
+
+// =============================================================================
+
+// source implements the Source and Acker interfaces of the queue package,
+// with the stored messages followed by the messages of the chat.
+type source struct {
+	bot  *Bot
+	keys []string
+}
+
+// Receive returns the next stored message, or the next message of the chat
+// that is accepted.
+func (s *source) Receive(ctx context.Context) (queue.Message, error) {
+	if len(s.keys) > 0 {
+		key := s.keys[0]
+		s.keys = s.keys[1:]
+		return s.bot.load(ctx, key)
+	}
+
+	for {
+		m, err := s.bot.chat.Receive(ctx)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return queue.Message{}, err
+			}
+			return queue.Message{}, fmt.Errorf("receive: %w", err)
+		}
+
+		qm, ok, err := s.bot.accept(ctx, m)
+		if err != nil || ok {
+			return qm, err
+		}
+	}
+}
+
+// Ack deletes the message once posted or dropped.
+func (s *source) Ack(ctx context.Context, m queue.Message) error {
+	return s.bot.storage.Delete(ctx, m.Key)
+}
+
+// sink implements the Sink interface of the queue package, posting the
+// decrypted messages to the chat.
+type sink struct {
+	bot *Bot
+}
+
+// Publish posts the decrypted message.
+func (s sink) Publish(ctx context.Context, m queue.Message) error {
+	st := s.bot.release(m.Key)
+	if err := s.bot.chat.Post(ctx, s.bot.format(st.Message, st.RoundNumber, m.Data)); err != nil {
+		return fmt.Errorf("post: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,270 @@
+package bot_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/armor"
+	"github.com/drand/tlock/bot"
+	"github.com/drand/tlock/internal/fakenet"
+	tlockhttp "github.com/drand/tlock/networks/http"
+	"github.com/drand/tlock/relay"
+)
+
+func Test_Bot(t *testing.T) {
+	network := fakenet.NewChain(time.Second)
+	next := network.RoundNumber(time.Now()) + 1
+
+	c := &chat{messages: []bot.Message{
+		{ID: "hello", Sender: "alice", Text: "hello"},
+		{ID: "later", Sender: "alice", Text: encrypt(t, network, "reveal", next)},
+		{ID: "other", Sender: "bob", Text: encrypt(t, fakenet.NewChain(time.Second), "elsewhere", 1)},
+		{ID: "now", Sender: "bob", Text: encrypt(t, network, "commit", 1)},
+		{ID: "later", Sender: "alice", Text: encrypt(t, network, "reveal", next)},
+	}}
+	storage := relay.Memory()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	format := func(m bot.Message, roundNumber uint64, plain []byte) string {
+		return m.Sender + ": " + string(plain)
+	}
+	if err := bot.New(network, c, storage, bot.WithFormat(format)).Run(ctx); err != nil {
+		t.Fatalf("run error %s", err)
+	}
+
+	if len(c.posts) != 2 || c.posts[0] != "bob: commit" || c.posts[1] != "alice: reveal" {
+		t.Fatalf("expecting the messages to be posted as their rounds are reached; got %q", c.posts)
+	}
+	if len(c.replies["hello"]) != 0 {
+		t.Fatalf("expecting messages that aren't encrypted to be ignored; got %q", c.replies["hello"])
+	}
+	if len(c.replies["other"]) != 1 || !strings.Contains(c.replies["other"][0], "can't be accepted") {
+		t.Fatalf("expecting the message locked to another chain to be rejected; got %q", c.replies["other"])
+	}
+	if len(c.replies["later"]) != 1 || !strings.Contains(c.replies["later"][0], "sealed until round") {
+		t.Fatalf("expecting accepted messages to be answered once; got %q", c.replies)
+	}
+
+	keys, err := storage.List(ctx, "messages/")
+	if err != nil || len(keys) != 0 {
+		t.Fatalf("expecting the posted messages to be deleted; got %v, %v", keys, err)
+	}
+}
+
+func Test_BotRestart(t *testing.T) {
+	network := fakenet.NewChain(time.Second)
+	storage := relay.Memory()
+	errChat := errors.New("chat unavailable")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The round of the message is far enough for the chat to fail before
+	// it's reached.
+	first := &chat{
+		messages: []bot.Message{{ID: "later", Sender: "alice", Text: encrypt(t, network, "reveal", network.RoundNumber(time.Now())+3)}},
+		err:      errChat,
+	}
+	if err := bot.New(network, first, storage).Run(ctx); !errors.Is(err, errChat) {
+		t.Fatalf("expecting error %v; got %v", errChat, err)
+	}
+	if len(first.posts) != 0 {
+		t.Fatalf("expecting no message to be posted; got %q", first.posts)
+	}
+
+	second := &chat{}
+	if err := bot.New(network, second, storage).Run(ctx); err != nil {
+		t.Fatalf("run error %s", err)
+	}
+	if len(second.posts) != 1 || !strings.HasSuffix(second.posts[0], "\nreveal") {
+		t.Fatalf("expecting the stored message to be posted after a restart; got %q", second.posts)
+	}
+}
+
+func Test_Matrix(t *testing.T) {
+	var mu sync.Mutex
+	var sent []map[string]interface{}
+	var since []string
+	syncs := 0
+
+	events := func(events ...string) string {
+		return `{"next_batch":"s` + string(rune('0'+syncs)) + `","rooms":{"join":{"!room:example.org":{"timeline":{"events":[` + strings.Join(events, ",") + `]}}}}}`
+	}
+	event := func(id, sender, body string) string {
+		return `{"event_id":"` + id + `","sender":"` + sender + `","type":"m.room.message","content":{"msgtype":"m.text","body":"` + body + `"}}`
+	}
+
+	homeserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"errcode":"M_UNKNOWN_TOKEN","error":"Invalid access token"}`)
+			return
+		}
+
+		switch {
+		case r.URL.Path == "/_matrix/client/v3/account/whoami":
+			io.WriteString(w, `{"user_id":"@tlock:example.org"}`)
+
+		case r.URL.Path == "/_matrix/client/v3/sync":
+			since = append(since, r.URL.Query().Get("since"))
+			switch syncs {
+			case 0:
+				io.WriteString(w, events(event("$old", "@alice:example.org", "before the bot")))
+			case 1:
+				io.WriteString(w, events())
+			default:
+				io.WriteString(w, events(event("$own", "@tlock:example.org", "released"), event("$new", "@alice:example.org", "sealed")))
+			}
+			syncs++
+
+		case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/_matrix/client/v3/rooms/!room:example.org/send/m.room.message/"):
+			var content map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&content)
+			sent = append(sent, content)
+			io.WriteString(w, `{"event_id":"$sent"}`)
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer homeserver.Close()
+
+	ctx := context.Background()
+
+	if _, err := bot.NewMatrix(ctx, homeserver.URL, "wrong", "!room:example.org"); err == nil || !strings.Contains(err.Error(), "M_UNKNOWN_TOKEN") {
+		t.Fatalf("expecting the error of the homeserver; got %v", err)
+	}
+
+	m, err := bot.NewMatrix(ctx, homeserver.URL, "token", "!room:example.org")
+	if err != nil {
+		t.Fatalf("new matrix error %s", err)
+	}
+
+	msg, err := m.Receive(ctx)
+	if err != nil {
+		t.Fatalf("receive error %s", err)
+	}
+	if msg != (bot.Message{ID: "$new", Sender: "@alice:example.org", Text: "sealed"}) {
+		t.Fatalf("expecting the new message of another user; got %+v", msg)
+	}
+
+	if err := m.Reply(ctx, msg, "accepted"); err != nil {
+		t.Fatalf("reply error %s", err)
+	}
+	if err := m.Post(ctx, "released"); err != nil {
+		t.Fatalf("post error %s", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(since) != 3 || since[0] != "" || since[1] != "s0" || since[2] != "s1" {
+		t.Fatalf("expecting every sync to be made since the previous one; got %q", since)
+	}
+	if len(sent) != 2 || sent[0]["body"] != "accepted" || sent[1]["body"] != "released" {
+		t.Fatalf("unexpected messages sent %v", sent)
+	}
+	if relation, ok := sent[0]["m.relates_to"].(map[string]interface{}); !ok || relation["m.in_reply_to"].(map[string]interface{})["event_id"] != "$new" {
+		t.Fatalf("expecting the reply to relate to the message; got %v", sent[0])
+	}
+	if _, ok := sent[1]["m.relates_to"]; ok {
+		t.Fatalf("expecting the post not to relate to a message; got %v", sent[1])
+	}
+}
+
+// Example runs a bot posting the messages locked to the quicknet chain of
+// the League of Entropy to a Matrix room once released.
+func Example() {
+	ctx := context.Background()
+
+	network, err := tlockhttp.NewNetwork("https://api.drand.sh", "52db9ba70e0cc0f6eaf7803dd07447a1f5477735fd3f661792ba94600c84e971")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	room, err := bot.NewMatrix(ctx, "https://matrix.example.org", os.Getenv("MATRIX_TOKEN"), "!room:example.org")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := bot.New(network, room, relay.Dir("messages")).Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// =============================================================================
+
+// encrypt returns the armored encryption of the data for the round.
+func encrypt(t *testing.T, network *fakenet.Chain, data string, roundNumber uint64) string {
+	var armored bytes.Buffer
+	a := armor.NewWriter(&armored)
+	if err := tlock.New(network).Encrypt(a, strings.NewReader(data), roundNumber); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("armor error %s", err)
+	}
+	return armored.String()
+}
+
+// chat provides the messages in order, then fails with its error or io.EOF,
+// and records what the bot posts and replies.
+type chat struct {
+	mu       sync.Mutex
+	messages []bot.Message
+	err      error
+	posts    []string
+	replies  map[string][]string
+}
+
+func (c *chat) Receive(ctx context.Context) (bot.Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.messages) == 0 {
+		if c.err != nil {
+			return bot.Message{}, c.err
+		}
+		return bot.Message{}, io.EOF
+	}
+
+	m := c.messages[0]
+	c.messages = c.messages[1:]
+	return m, nil
+}
+
+func (c *chat) Post(ctx context.Context, text string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.posts = append(c.posts, text)
+	return nil
+}
+
+func (c *chat) Reply(ctx context.Context, m bot.Message, text string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.replies == nil {
+		c.replies = make(map[string][]string)
+	}
+	c.replies[m.ID] = append(c.replies[m.ID], text)
+	return nil
+}
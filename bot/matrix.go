@@ -0,0 +1,231 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// matrixTimeout is how long the homeserver holds a sync request when there
+// are no new events.
+const matrixTimeout = 30 * time.Second
+
+// This assertion checks that Matrix keeps satisfying the interfaces of the
+// bot.
+var _ interface {
+	Chat
+	Replier
+} = (*Matrix)(nil)
+
+// =============================================================================
+
+// MatrixOption configures a Matrix chat constructed with NewMatrix.
+type MatrixOption func(m *Matrix)
+
+// WithHTTPClient sets the HTTP client the requests to the homeserver are made
+// with. The default is http.DefaultClient.
+func WithHTTPClient(client *http.Client) MatrixOption {
+	return func(m *Matrix) {
+		m.client = client
+	}
+}
+
+// Matrix implements the Chat and Replier interfaces with a room of a Matrix
+// homeserver, through its client-server API. The bot receives the text
+// messages of the room, answers them in their thread and posts the released
+// messages to the room. Messages sent to the room while the bot is down
+// aren't received.
+type Matrix struct {
+	homeserver string
+	token      string
+	roomID     string
+	client     *http.Client
+
+	userID string
+	since  string
+	events []Message
+	txnID  string
+	txn    atomic.Uint64
+}
+
+// NewMatrix constructs a chat for the room with the ID, which the user of the
+// access token has to have joined on the homeserver at the URL.
+func NewMatrix(ctx context.Context, homeserver string, token string, roomID string, opts ...MatrixOption) (*Matrix, error) {
+	m := Matrix{
+		homeserver: strings.TrimSuffix(homeserver, "/"),
+		token:      token,
+		roomID:     roomID,
+		client:     http.DefaultClient,
+		txnID:      fmt.Sprintf("tlock%d", time.Now().UnixNano()),
+	}
+
+	for _, opt := range opts {
+		opt(&m)
+	}
+
+	var whoami struct {
+		UserID string `json:"user_id"`
+	}
+	if err := m.do(ctx, http.MethodGet, "/account/whoami", nil, &whoami); err != nil {
+		return nil, err
+	}
+	m.userID = whoami.UserID
+
+	// The first sync returns the latest messages of the room, which were
+	// sent before the bot joined or while it was down, so they are skipped.
+	if _, err := m.sync(ctx, 0); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// Receive returns the next text message of the room sent by another user.
+func (m *Matrix) Receive(ctx context.Context) (Message, error) {
+	for len(m.events) == 0 {
+		events, err := m.sync(ctx, matrixTimeout)
+		if err != nil {
+			return Message{}, err
+		}
+		m.events = events
+	}
+
+	msg := m.events[0]
+	m.events = m.events[1:]
+	return msg, nil
+}
+
+// Post posts the text to the room.
+func (m *Matrix) Post(ctx context.Context, text string) error {
+	return m.send(ctx, matrixContent{MsgType: "m.text", Body: text})
+}
+
+// Reply answers the message in the room.
+func (m *Matrix) Reply(ctx context.Context, msg Message, text string) error {
+	content := matrixContent{MsgType: "m.text", Body: text}
+	content.RelatesTo = &matrixRelation{}
+	content.RelatesTo.InReplyTo.EventID = msg.ID
+
+	return m.send(ctx, content)
+}
+
+// =============================================================================
+
+// matrixContent is the content of a message event.
+type matrixContent struct {
+	MsgType   string          `json:"msgtype"`
+	Body      string          `json:"body"`
+	RelatesTo *matrixRelation `json:"m.relates_to,omitempty"`
+}
+
+// matrixRelation relates a message to the message it answers.
+type matrixRelation struct {
+	InReplyTo struct {
+		EventID string `json:"event_id"`
+	} `json:"m.in_reply_to"`
+}
+
+// matrixSync is the part of the response of a sync request the bot reads.
+type matrixSync struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []struct {
+					EventID string        `json:"event_id"`
+					Sender  string        `json:"sender"`
+					Type    string        `json:"type"`
+					Content matrixContent `json:"content"`
+				} `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+	} `json:"rooms"`
+}
+
+// sync returns the text messages of the room sent by other users since the
+// previous sync, waiting up to the timeout for some.
+func (m *Matrix) sync(ctx context.Context, timeout time.Duration) ([]Message, error) {
+	filter := fmt.Sprintf(`{"room":{"rooms":[%q],"timeline":{"types":["m.room.message"]}}}`, m.roomID)
+
+	query := url.Values{}
+	query.Set("filter", filter)
+	query.Set("timeout", fmt.Sprint(timeout.Milliseconds()))
+	if m.since != "" {
+		query.Set("since", m.since)
+	}
+
+	var resp matrixSync
+	if err := m.do(ctx, http.MethodGet, "/sync?"+query.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+	m.since = resp.NextBatch
+
+	var messages []Message
+	for _, event := range resp.Rooms.Join[m.roomID].Timeline.Events {
+		if event.Type != "m.room.message" || event.Content.MsgType != "m.text" || event.Sender == m.userID {
+			continue
+		}
+		messages = append(messages, Message{ID: event.EventID, Sender: event.Sender, Text: event.Content.Body})
+	}
+
+	return messages, nil
+}
+
+// send sends a message event to the room.
+func (m *Matrix) send(ctx context.Context, content matrixContent) error {
+	path := fmt.Sprintf("/rooms/%s/send/m.room.message/%s.%d", url.PathEscape(m.roomID), m.txnID, m.txn.Add(1))
+	return m.do(ctx, http.MethodPut, path, content, nil)
+}
+
+// do makes the request to the client-server API, decoding the response into
+// out if it isn't nil.
+func (m *Matrix) do(ctx context.Context, method string, path string, in interface{}, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, m.homeserver+"/_matrix/client/v3"+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("matrix: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			ErrCode string `json:"errcode"`
+			Error   string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.ErrCode != "" {
+			return fmt.Errorf("matrix: homeserver responded %s: %s (%s)", resp.Status, e.Error, e.ErrCode)
+		}
+		return fmt.Errorf("matrix: homeserver responded %s", resp.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("matrix: decode response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/drand/tlock"
+)
+
+// AuditRecord describes a completed operation in the audit log. The digest
+// is the SHA-256 of the encrypted data, the output of an encryption or the
+// input of a decryption, so the records of sealing and opening the same data
+// can be matched.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Input     string    `json:"input"`
+	Output    string    `json:"output"`
+	SHA256    string    `json:"sha256"`
+	Round     uint64    `json:"round"`
+	ChainHash string    `json:"chain_hash"`
+	Endpoint  string    `json:"endpoint,omitempty"`
+}
+
+// AppendAudit appends the record to the audit log at the specified path as a
+// line of JSON. The log is only ever appended to, and the record is flushed
+// to disk before returning.
+func AppendAudit(path string, r AuditRecord) error {
+	path = localPath(path)
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("write audit log: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("write audit log: %w", err)
+	}
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("write audit log: %w", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("write audit log: %w", err)
+	}
+
+	return f.Close()
+}
+
+// =============================================================================
+
+// Digest computes the SHA-256 of the encrypted data while an operation reads
+// or writes it.
+type Digest struct {
+	h hash.Hash
+}
+
+// NewDigest constructs a digest of no data.
+func NewDigest() *Digest {
+	return &Digest{h: sha256.New()}
+}
+
+// Reader returns a reader that adds the data read from r to the digest.
+func (d *Digest) Reader(r io.Reader) io.Reader {
+	return io.TeeReader(r, d.h)
+}
+
+// Writer returns a writer that adds the data written to w to the digest.
+func (d *Digest) Writer(w io.Writer) io.Writer {
+	return io.MultiWriter(w, d.h)
+}
+
+// Sum returns the hex encoded digest.
+func (d *Digest) Sum() string {
+	return hex.EncodeToString(d.h.Sum(nil))
+}
+
+// DigestFile returns the digest and header of the encrypted file, for
+// operations that didn't process the whole file at once, like resumed ones.
+func DigestFile(path string) (string, tlock.Header, error) {
+	f, err := os.Open(localPath(path))
+	if err != nil {
+		return "", tlock.Header{}, err
+	}
+	defer f.Close()
+
+	header, err := tlock.ReadHeader(f)
+	if err != nil {
+		return "", tlock.Header{}, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", tlock.Header{}, err
+	}
+
+	d := NewDigest()
+	if _, err := io.Copy(d.h, f); err != nil {
+		return "", tlock.Header{}, err
+	}
+
+	return d.Sum(), header, nil
+}
@@ -0,0 +1,494 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/networks/http"
+	"gopkg.in/yaml.v2"
+)
+
+// Manifest describes a set of operations run by the batch subcommand. The
+// networks apply to every entry. The chain is used to encrypt unless an
+// entry sets its own, while decryption uses the chain recorded in the input.
+// The output template names the outputs of the entries that don't set one.
+type Manifest struct {
+	Networks       []string        `yaml:"networks"`
+	Chain          string          `yaml:"chain"`
+	OutputTemplate string          `yaml:"output_template"`
+	Entries        []ManifestEntry `yaml:"entries"`
+}
+
+// ManifestEntry describes a single encryption or decryption. Relative paths
+// are resolved against the directory of the manifest.
+type ManifestEntry struct {
+	Input      string `yaml:"input"`
+	Output     string `yaml:"output"`
+	Decrypt    bool   `yaml:"decrypt"`
+	Chain      string `yaml:"chain"`
+	Round      string `yaml:"round"`
+	Duration   string `yaml:"duration"`
+	At         string `yaml:"at"`
+	TZ         string `yaml:"tz"`
+	Armor      bool   `yaml:"armor"`
+	ArmorWidth int    `yaml:"armor_width"`
+	ArmorLabel string `yaml:"armor_label"`
+	AAD        string `yaml:"aad"`
+}
+
+// ReadManifest reads and validates the manifest at the specified path.
+func ReadManifest(path string) (Manifest, error) {
+	b, err := os.ReadFile(localPath(path))
+	if err != nil {
+		return Manifest{}, fmt.Errorf("read manifest: %w", err)
+	}
+
+	var m Manifest
+	if err := yaml.UnmarshalStrict(b, &m); err != nil {
+		return Manifest{}, fmt.Errorf("parse manifest: %w", err)
+	}
+
+	if len(m.Entries) == 0 {
+		return Manifest{}, errors.New("manifest has no entries")
+	}
+
+	dir := filepath.Dir(path)
+	for i := range m.Entries {
+		e := &m.Entries[i]
+		if e.Input == "" || e.Output == "-" {
+			return Manifest{}, fmt.Errorf("entry %d: input and output files are required", i+1)
+		}
+		if e.Decrypt && (e.Round != "" || e.Duration != "" || e.At != "" || e.Armor) {
+			return Manifest{}, fmt.Errorf("entry %d: round, duration, at and armor can't be used to decrypt", i+1)
+		}
+		if countSet(e.Round, e.Duration, e.At) > 1 {
+			return Manifest{}, fmt.Errorf("entry %d: only one of round, duration and at can be used", i+1)
+		}
+
+		e.Input = resolvePath(dir, e.Input)
+		if e.Output != "" {
+			e.Output = resolvePath(dir, e.Output)
+		}
+	}
+
+	return m, nil
+}
+
+// countSet returns how many of the values aren't empty.
+func countSet(values ...string) int {
+	var n int
+	for _, v := range values {
+		if v != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// resolvePath resolves a path relative to the manifest directory. URLs and
+// absolute paths are returned as is.
+func resolvePath(dir string, name string) string {
+	if !IsLocalFile(name) || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(dir, name)
+}
+
+// =============================================================================
+
+// batchCacheSize is the number of rounds whose signature is kept while
+// running a manifest, so entries locked to the same round don't retrieve it
+// again.
+const batchCacheSize = 128
+
+// Batch runs the encryptions and decryptions listed in a manifest. The
+// networks are shared between the entries, and a summary of every entry is
+// displayed once all of them ran.
+func Batch(ctx context.Context, out io.Writer, args []string) error {
+	var networks listFlag
+
+	fs := flag.NewFlagSet("batch", flag.ContinueOnError)
+	fs.Var(&networks, "n", "the drand API endpoint; can be repeated")
+	fs.Var(&networks, "network", "the drand API endpoint; can be repeated")
+	chain := fs.String("c", "", "the chain to use unless an entry sets its own")
+	fs.StringVar(chain, "chain", "", "the chain to use unless an entry sets its own")
+	pinFile := fs.String("pin-file", defaultPinFile(), "the file recording the public key of each chain")
+	policyFile := fs.String("policy-file", os.Getenv("TLE_POLICYFILE"), "the file holding the policy enforced when encrypting")
+	auditLog := fs.String("audit-log", os.Getenv("TLE_AUDITLOG"), "the file completed operations are appended to")
+	outputTemplate := fs.String("output-template", "", "the template naming the outputs of the entries without one")
+	asJSON := jsonFlag(fs)
+	var v verbosity
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := NewLogger(os.Stderr, v.level())
+
+	if fs.NArg() != 1 {
+		return errors.New("batch requires a single manifest file")
+	}
+
+	m, err := ReadManifest(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	policy, err := LoadPolicy(*policyFile, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if len(networks) > 0 {
+		m.Networks = networks
+	}
+	if len(m.Networks) == 0 {
+		m.Networks = []string{defaultNetwork}
+	}
+	if *chain != "" {
+		m.Chain = *chain
+	}
+	if m.Chain == "" {
+		m.Chain = defaultChain
+	}
+	if *outputTemplate != "" {
+		m.OutputTemplate = *outputTemplate
+	}
+
+	var names *template.Template
+	if m.OutputTemplate != "" {
+		if names, err = parseOutputTemplate(m.OutputTemplate); err != nil {
+			return err
+		}
+	}
+
+	for i, e := range m.Entries {
+		if e.Output == "" && names == nil {
+			return fmt.Errorf("entry %d: input and output files are required", i+1)
+		}
+	}
+
+	b := batch{
+		log:      log,
+		clock:    SystemClock{},
+		hosts:    m.Networks,
+		pinFile:  *pinFile,
+		policy:   policy,
+		auditLog: *auditLog,
+		names:    names,
+		dir:      filepath.Dir(fs.Arg(0)),
+		cache:    tlock.NewSignatureCache(batchCacheSize),
+		networks: make(map[string]*http.Network),
+	}
+
+	results := make([]batchResult, 0, len(m.Entries))
+	var failed int
+	for i, e := range m.Entries {
+		log.Debugf("running entry %d: %s", i+1, e.Input)
+
+		r := b.run(ctx, e, m.Chain)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if r.Error != "" {
+			failed++
+			log.Errorf("entry %d: %s", i+1, r.Error)
+		}
+		results = append(results, r)
+	}
+
+	stats := b.cache.Stats()
+	log.Debugf("signature cache: %d hits, %d misses", stats.Hits, stats.Misses)
+
+	if err := writeResults(out, *asJSON, results); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d entries failed", failed, len(results))
+	}
+
+	return nil
+}
+
+// batch holds the state shared by the entries of a manifest.
+type batch struct {
+	log      *Logger
+	clock    Clock
+	hosts    []string
+	pinFile  string
+	policy   *tlock.Policy
+	auditLog string
+	names    *template.Template
+	dir      string
+	cache    *tlock.SignatureCache
+	networks map[string]*http.Network
+}
+
+// batchResult describes the outcome of a manifest entry.
+type batchResult struct {
+	Input      string     `json:"input"`
+	Output     string     `json:"output"`
+	Operation  string     `json:"operation"`
+	Round      uint64     `json:"round,omitempty"`
+	UnlockTime *time.Time `json:"unlock_time,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// run performs the operation of an entry.
+func (b *batch) run(ctx context.Context, e ManifestEntry, chainHash string) batchResult {
+	r := batchResult{
+		Input:     e.Input,
+		Output:    e.Output,
+		Operation: "encrypt",
+	}
+	if e.Decrypt {
+		r.Operation = "decrypt"
+	}
+
+	roundNumber, network, err := b.process(ctx, &e, chainHash)
+	r.Output = e.Output
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+
+	unlock := network.RoundTime(roundNumber).UTC()
+	r.Round = roundNumber
+	r.UnlockTime = &unlock
+
+	return r
+}
+
+// process encrypts or decrypts the input of the entry into its output and
+// returns the round the data is locked to. An output named by the template
+// is set in the entry once the round is known.
+func (b *batch) process(ctx context.Context, e *ManifestEntry, chainHash string) (uint64, *http.Network, error) {
+	src, err := OpenInput(ctx, e.Input)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer src.Close()
+
+	flags := Flags{
+		Input:      e.Input,
+		Round:      e.Round,
+		Duration:   e.Duration,
+		At:         e.At,
+		TZ:         e.TZ,
+		Armor:      e.Armor,
+		ArmorWidth: e.ArmorWidth,
+		ArmorLabel: e.ArmorLabel,
+		AAD:        e.AAD,
+		policy:     b.policy,
+	}
+	if flags.Round == "" && flags.At == "" && flags.Duration == "" {
+		flags.Duration = defaultDuration
+	}
+
+	var roundNumber uint64
+	var network *http.Network
+	var in io.Reader = src
+
+	switch {
+	case e.Decrypt:
+		var header tlock.Header
+		if header, in, err = PeekHeader(in); err != nil {
+			return 0, nil, err
+		}
+
+		if e.Chain != "" {
+			chainHash = e.Chain
+		} else {
+			chainHash = header.ChainHash
+		}
+
+		if network, err = b.network(ctx, chainHash); err != nil {
+			return 0, nil, err
+		}
+		roundNumber = header.RoundNumber
+
+	default:
+		if e.Chain != "" {
+			chainHash = e.Chain
+		}
+
+		if network, err = b.network(ctx, chainHash); err != nil {
+			return 0, nil, err
+		}
+
+		if roundNumber, err = selectRound(b.clock.Now(), flags, network); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if e.Output == "" {
+		if e.Output, err = b.outputName(e.Input, roundNumber, network); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	out, err := CreateOutput(e.Output)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to open output file %q: %w", e.Output, err)
+	}
+	defer out.Abort()
+
+	var dst io.Writer = out
+	digest := NewDigest()
+
+	switch {
+	case e.Decrypt:
+		in = digest.Reader(in)
+		opts := append(Options(b.log, b.clock, flags), tlock.WithSignatureCache(b.cache))
+		if err := tlock.New(network, opts...).DecryptContext(ctx, dst, in); err != nil {
+			return 0, nil, err
+		}
+
+	default:
+		dst = digest.Writer(dst)
+		if err := encryptRound(ctx, b.log, b.clock, flags, dst, in, network, roundNumber); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if err := out.Commit(); err != nil {
+		return 0, nil, err
+	}
+
+	if b.auditLog != "" {
+		r := AuditRecord{
+			Time:      b.clock.Now().UTC(),
+			Operation: "encrypt",
+			Input:     e.Input,
+			Output:    e.Output,
+			SHA256:    digest.Sum(),
+			Round:     roundNumber,
+			ChainHash: network.ChainHash(),
+			Endpoint:  network.Host(),
+		}
+		if e.Decrypt {
+			r.Operation = "decrypt"
+		}
+
+		if err := AppendAudit(b.auditLog, r); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	return roundNumber, network, nil
+}
+
+// outputName returns the output of an entry named by the template, relative
+// to the directory of the manifest.
+func (b *batch) outputName(input string, roundNumber uint64, network *http.Network) (string, error) {
+	name := path.Base(input)
+	if IsLocalFile(input) {
+		name = filepath.Base(input)
+	} else if u, err := url.Parse(input); err == nil {
+		name = path.Base(u.Path)
+	}
+
+	ext := filepath.Ext(name)
+	fields := outputFields{
+		Name:   name,
+		Stem:   strings.TrimSuffix(name, ext),
+		Ext:    ext,
+		Round:  roundNumber,
+		Chain:  network.ChainHash(),
+		Unlock: network.RoundTime(roundNumber).UTC(),
+	}
+
+	var buf strings.Builder
+	if err := b.names.Execute(&buf, fields); err != nil {
+		return "", fmt.Errorf("output template: %w", err)
+	}
+
+	output := buf.String()
+	if output == "" || output == "-" {
+		return "", fmt.Errorf("output template names the output of %s %q", input, output)
+	}
+
+	return resolvePath(b.dir, output), nil
+}
+
+// network returns the network for the chain, connecting to it the first time
+// the chain is used.
+func (b *batch) network(ctx context.Context, chainHash string) (*http.Network, error) {
+	if network, exists := b.networks[chainHash]; exists {
+		return network, nil
+	}
+
+	network, err := NetworkForChain(ctx, b.log, b.hosts, b.pinFile, chainHash, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	b.networks[chainHash] = network
+	return network, nil
+}
+
+// outputFields holds the fields available to output templates.
+type outputFields struct {
+	Name   string
+	Stem   string
+	Ext    string
+	Round  uint64
+	Chain  string
+	Unlock time.Time
+}
+
+// parseOutputTemplate parses an output template, which is checked against
+// the available fields right away.
+func parseOutputTemplate(text string) (*template.Template, error) {
+	t, err := template.New("output").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("output template: %w", err)
+	}
+
+	if err := t.Execute(io.Discard, outputFields{}); err != nil {
+		return nil, fmt.Errorf("output template: %w", err)
+	}
+
+	return t, nil
+}
+
+// writeResults displays the summary of the manifest entries.
+func writeResults(out io.Writer, asJSON bool, results []batchResult) error {
+	if asJSON {
+		return json.NewEncoder(out).Encode(results)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "INPUT\tOUTPUT\tOPERATION\tROUND\tUNLOCKS\tSTATUS\n")
+
+	for _, r := range results {
+		output, round, unlock, status := r.Output, "-", "-", "ok"
+		if output == "" {
+			output = "-"
+		}
+		if r.UnlockTime != nil {
+			round = fmt.Sprint(r.Round)
+			unlock = r.UnlockTime.Format(time.RFC3339)
+		}
+		if r.Error != "" {
+			status = "failed: " + r.Error
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Input, output, r.Operation, round, unlock, status)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,290 @@
+package commands
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/drand/drand/chain"
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/networks/beacon"
+)
+
+// Beacon runs the commands that manage beacon files. A beacon file holds the
+// signature of a round and the information of its chain, which allows data
+// to be decrypted on machines without network access.
+func Beacon(ctx context.Context, out io.Writer, args []string) error {
+	var v verbosity
+
+	fs := flag.NewFlagSet("beacon", flag.ContinueOnError)
+	asJSON := jsonFlag(fs)
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch fs.Arg(0) {
+	case "export":
+		if *asJSON {
+			return errors.New("--json can't be used with beacon export")
+		}
+		return exportBeacon(ctx, out, v, fs.Args()[1:])
+
+	case "verify":
+		return verifyBeacon(ctx, out, v, *asJSON, fs.Args()[1:])
+	}
+
+	return errors.New("beacon requires a command: export or verify")
+}
+
+// exportBeacon fetches and verifies the beacon of a round and writes it to a
+// beacon file. The round and chain are taken from the flags or from the
+// header of an encrypted input.
+func exportBeacon(ctx context.Context, out io.Writer, v verbosity, args []string) error {
+	var networks listFlag
+
+	fs := flag.NewFlagSet("beacon export", flag.ContinueOnError)
+	fs.Var(&networks, "n", "the drand API endpoint; can be repeated")
+	fs.Var(&networks, "network", "the drand API endpoint; can be repeated")
+	chainHash := fs.String("c", "", "the chain of the beacon")
+	fs.StringVar(chainHash, "chain", "", "the chain of the beacon")
+	round := fs.String("r", "", "the round of the beacon")
+	fs.StringVar(round, "round", "", "the round of the beacon")
+	output := fs.String("o", "", "the path to the beacon file")
+	fs.StringVar(output, "output", "", "the path to the beacon file")
+	pinFile := fs.String("pin-file", defaultPinFile(), "the file recording the public key of each chain")
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := NewLogger(os.Stderr, v.level())
+
+	var header tlock.Header
+	switch {
+	case fs.NArg() > 1:
+		return errors.New("beacon export accepts a single input")
+
+	case fs.NArg() == 1:
+		if *round != "" {
+			return errors.New("-r/--round can't be used with INPUT")
+		}
+
+		src, err := OpenInput(ctx, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		if header, err = tlock.ReadHeader(src); err != nil {
+			return err
+		}
+
+		if *chainHash == "" {
+			*chainHash = header.ChainHash
+		}
+
+	case *round == "":
+		return errors.New("beacon export requires -r/--round or INPUT")
+	}
+
+	if *chainHash == "" {
+		*chainHash = defaultChain
+	}
+	if len(networks) == 0 {
+		networks = listFlag{defaultNetwork}
+	}
+
+	network, err := NetworkForChain(ctx, log, networks, *pinFile, *chainHash, header.RoundNumber)
+	if err != nil {
+		return err
+	}
+
+	roundNumber := header.RoundNumber
+	if *round != "" {
+		if roundNumber, err = tlock.ParseRound(*round, time.Now(), network); err != nil {
+			return err
+		}
+	}
+
+	signature, err := network.SignatureContext(ctx, roundNumber)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("fetch beacon of round %d: %w", roundNumber, tlock.ErrTooEarly)
+	}
+
+	b, err := beacon.New(network.Info(), roundNumber, signature)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" || *output == "-" {
+		return b.Write(out)
+	}
+
+	f, err := CreateOutput(*output)
+	if err != nil {
+		return fmt.Errorf("failed to open output file %q: %v", *output, err)
+	}
+	defer f.Abort()
+
+	if err := b.Write(f); err != nil {
+		return err
+	}
+
+	if err := f.Commit(); err != nil {
+		return err
+	}
+
+	log.Infof("exported the beacon of round %d of chain %s", roundNumber, b.ChainHash())
+	return nil
+}
+
+// verification describes the outcome of beacon verify.
+type verification struct {
+	RoundNumber uint64     `json:"round"`
+	ChainHash   string     `json:"chain_hash"`
+	Valid       bool       `json:"valid"`
+	Published   *time.Time `json:"published,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// verifyBeacon checks a signature received out of band against the
+// information of its chain, whose public key has to match the pinned one,
+// and reports when the chain published the round.
+func verifyBeacon(ctx context.Context, out io.Writer, v verbosity, asJSON bool, args []string) error {
+	var networks listFlag
+
+	fs := flag.NewFlagSet("beacon verify", flag.ContinueOnError)
+	fs.Var(&networks, "n", "the drand API endpoint; can be repeated")
+	fs.Var(&networks, "network", "the drand API endpoint; can be repeated")
+	chainHash := fs.String("c", "", "the chain of the beacon")
+	fs.StringVar(chainHash, "chain", "", "the chain of the beacon")
+	round := fs.Uint64("r", 0, "the round of the beacon")
+	fs.Uint64Var(round, "round", 0, "the round of the beacon")
+	infoFile := fs.String("chain-info", "", "the file holding the chain information")
+	pinFile := fs.String("pin-file", defaultPinFile(), "the file recording the public key of each chain")
+	fs.BoolVar(&asJSON, "json", asJSON, "print machine readable JSON output")
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := NewLogger(os.Stderr, v.level())
+
+	if fs.NArg() != 1 || *round == 0 {
+		return errors.New("beacon verify requires -r/--round and a hex encoded SIGNATURE")
+	}
+
+	signature, err := hex.DecodeString(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+
+	info, err := chainInfo(ctx, log, networks, *pinFile, *chainHash, *infoFile)
+	if err != nil {
+		return err
+	}
+
+	r := verification{
+		RoundNumber: *round,
+		ChainHash:   info.HashString(),
+	}
+
+	published, verr := beacon.Verify(info, *round, signature)
+	if verr == nil {
+		published = published.UTC()
+		r.Valid = true
+		r.Published = &published
+	} else {
+		r.Error = verr.Error()
+	}
+
+	switch {
+	case asJSON:
+		if err := json.NewEncoder(out).Encode(r); err != nil {
+			return err
+		}
+	case r.Valid:
+		fmt.Fprintf(out, "round %d of chain %s is valid, published at %s\n", r.RoundNumber, r.ChainHash, published.Format(time.RFC3339))
+	}
+
+	if verr != nil {
+		return fmt.Errorf("round %d of chain %s is invalid: %w", r.RoundNumber, r.ChainHash, verr)
+	}
+
+	return nil
+}
+
+// chainInfo returns the chain information from the file if one is given,
+// or from the network otherwise, once its public key is checked against the
+// pin file.
+func chainInfo(ctx context.Context, log *Logger, hosts []string, pinFile string, chainHash string, path string) (*chain.Info, error) {
+	if path == "" {
+		if chainHash == "" {
+			chainHash = defaultChain
+		}
+		if len(hosts) == 0 {
+			hosts = []string{defaultNetwork}
+		}
+
+		network, err := NetworkForChain(ctx, log, hosts, pinFile, chainHash, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		return network.Info(), nil
+	}
+
+	f, err := os.Open(localPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chain information %q: %v", path, err)
+	}
+	defer f.Close()
+
+	info, err := chain.InfoFromJSON(f)
+	if err != nil {
+		return nil, fmt.Errorf("chain information %q: %w", path, err)
+	}
+
+	if chainHash != "" && info.HashString() != chainHash {
+		return nil, fmt.Errorf("chain information %q is for chain %s, not %s", path, info.HashString(), chainHash)
+	}
+
+	if err := VerifyPin(pinFile, info.HashString(), info.PublicKey); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// ReadBeacon reads a beacon file and checks that it can decrypt data with
+// the header.
+func ReadBeacon(path string, header tlock.Header) (*beacon.Bundle, error) {
+	f, err := os.Open(localPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open beacon file %q: %v", path, err)
+	}
+	defer f.Close()
+
+	b, err := beacon.Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("beacon file %q: %w", path, err)
+	}
+
+	if b.ChainHash() != header.ChainHash {
+		return nil, fmt.Errorf("beacon file %q is for chain %s but the data is locked to chain %s", path, b.ChainHash(), header.ChainHash)
+	}
+
+	if b.Round() != header.RoundNumber {
+		return nil, fmt.Errorf("beacon file %q is for round %d but the data is locked to round %d", path, b.Round(), header.RoundNumber)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,73 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"os"
+
+	"github.com/drand/tlock/bot"
+)
+
+// Bot runs a chat bot in a Matrix room, which posts the time locked messages
+// sent to it once their round is reached, keeping them in a directory, an S3
+// bucket or memory, until the context is canceled.
+func Bot(ctx context.Context, out io.Writer, args []string) error {
+	var networks listFlag
+	var v verbosity
+
+	fs := flag.NewFlagSet("bot", flag.ContinueOnError)
+	fs.Var(&networks, "n", "the drand API endpoint; can be repeated")
+	fs.Var(&networks, "network", "the drand API endpoint; can be repeated")
+	chainHash := fs.String("c", defaultChain, "the chain messages are locked to")
+	fs.StringVar(chainHash, "chain", defaultChain, "the chain messages are locked to")
+	homeserver := fs.String("homeserver", "", "the URL of the Matrix homeserver")
+	room := fs.String("room", "", "the ID of the Matrix room the bot runs in")
+	stateKey := fs.String("state-key", "", "the file holding the key encrypting the messages in STORAGE")
+	pinFile := fs.String("pin-file", defaultPinFile(), "the file recording the public key of each chain")
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := NewLogger(os.Stderr, v.level())
+
+	if fs.NArg() != 1 {
+		return errors.New("bot requires a single STORAGE")
+	}
+	if *homeserver == "" || *room == "" {
+		return errors.New("bot requires --homeserver and --room")
+	}
+
+	token := os.Getenv("TLE_MATRIX_TOKEN")
+	if token == "" {
+		return errors.New("bot requires the access token of its Matrix account in $TLE_MATRIX_TOKEN")
+	}
+
+	storage, err := openStateStorage(fs.Arg(0), *stateKey)
+	if err != nil {
+		return err
+	}
+
+	if len(networks) == 0 {
+		networks = listFlag{defaultNetwork}
+	}
+
+	network, err := NetworkForChain(ctx, log, networks, *pinFile, *chainHash, 0)
+	if err != nil {
+		return err
+	}
+
+	chat, err := bot.NewMatrix(ctx, *homeserver, token, *room)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("bot running in %s for chain %s", *room, network.ChainHash())
+
+	if err := bot.New(network, chat, storage).Run(ctx); !errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,658 @@
+package commands
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/networks/http"
+	"gopkg.in/yaml.v2"
+)
+
+// These are the names of the entries of a capsule besides its files.
+const (
+	capsuleManifest  = "manifest.json"
+	capsuleSignature = "manifest.sig"
+	capsuleFiles     = "files/"
+)
+
+// CapsuleManifest describes the files of a capsule. It is signed by the
+// creator of the capsule, so the files can't be replaced or their rounds
+// changed without invalidating the signature.
+type CapsuleManifest struct {
+	Version   int           `json:"version"`
+	ChainHash string        `json:"chain_hash"`
+	Created   time.Time     `json:"created"`
+	PublicKey string        `json:"public_key"`
+	Files     []CapsuleFile `json:"files"`
+}
+
+// CapsuleFile describes a file of a capsule. The size and digest are the
+// ones of the encrypted file.
+type CapsuleFile struct {
+	Name   string `json:"name"`
+	Round  uint64 `json:"round"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Schedule describes the files sealed in a capsule by capsule create.
+type Schedule struct {
+	Files []ScheduleEntry `yaml:"files"`
+}
+
+// ScheduleEntry describes when a file of a capsule can be opened. The name
+// defaults to the base name of the input. Relative paths are resolved against
+// the directory of the schedule.
+type ScheduleEntry struct {
+	Input    string `yaml:"input"`
+	Name     string `yaml:"name"`
+	Round    string `yaml:"round"`
+	Duration string `yaml:"duration"`
+	At       string `yaml:"at"`
+	TZ       string `yaml:"tz"`
+}
+
+// ReadSchedule reads and validates the schedule at the specified path.
+func ReadSchedule(path string) (Schedule, error) {
+	b, err := os.ReadFile(localPath(path))
+	if err != nil {
+		return Schedule{}, fmt.Errorf("read schedule: %w", err)
+	}
+
+	var s Schedule
+	if err := yaml.UnmarshalStrict(b, &s); err != nil {
+		return Schedule{}, fmt.Errorf("parse schedule: %w", err)
+	}
+
+	if len(s.Files) == 0 {
+		return Schedule{}, errors.New("schedule has no files")
+	}
+
+	dir := filepath.Dir(path)
+	names := make(map[string]bool)
+	for i := range s.Files {
+		e := &s.Files[i]
+		if e.Input == "" || e.Input == "-" {
+			return Schedule{}, fmt.Errorf("file %d: an input file is required", i+1)
+		}
+		if countSet(e.Round, e.Duration, e.At) != 1 {
+			return Schedule{}, fmt.Errorf("file %d: one of round, duration and at is required", i+1)
+		}
+
+		if e.Name == "" {
+			e.Name = filepath.Base(filepath.FromSlash(e.Input))
+		}
+		if !validCapsuleName(e.Name) {
+			return Schedule{}, fmt.Errorf("file %d: invalid name %q", i+1, e.Name)
+		}
+		if names[e.Name] {
+			return Schedule{}, fmt.Errorf("file %d: duplicate name %q", i+1, e.Name)
+		}
+		names[e.Name] = true
+
+		e.Input = resolvePath(dir, e.Input)
+	}
+
+	return s, nil
+}
+
+// validCapsuleName reports whether the name can be used for a file of a
+// capsule, which is opened inside a single directory.
+func validCapsuleName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// =============================================================================
+
+// CapsuleWriter writes a capsule, a tar archive holding time lock encrypted
+// files followed by their signed manifest.
+type CapsuleWriter struct {
+	tw       *tar.Writer
+	key      ed25519.PrivateKey
+	manifest CapsuleManifest
+}
+
+// NewCapsuleWriter constructs a writer of a capsule whose files are locked to
+// the chain and whose manifest is signed with the key.
+func NewCapsuleWriter(w io.Writer, key ed25519.PrivateKey, chainHash string, created time.Time) *CapsuleWriter {
+	return &CapsuleWriter{
+		tw:  tar.NewWriter(w),
+		key: key,
+		manifest: CapsuleManifest{
+			Version:   1,
+			ChainHash: chainHash,
+			Created:   created.UTC(),
+			PublicKey: hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+		},
+	}
+}
+
+// Add writes the encrypted file of the specified size, locked to the round.
+func (cw *CapsuleWriter) Add(name string, roundNumber uint64, encrypted io.Reader, size int64) error {
+	hdr := tar.Header{
+		Name:     capsuleFiles + name,
+		Mode:     0600,
+		Size:     size,
+		Typeflag: tar.TypeReg,
+		ModTime:  cw.manifest.Created,
+	}
+	if err := cw.tw.WriteHeader(&hdr); err != nil {
+		return fmt.Errorf("write capsule: %w", err)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(cw.tw, h), encrypted); err != nil {
+		return fmt.Errorf("write capsule: %w", err)
+	}
+
+	cw.manifest.Files = append(cw.manifest.Files, CapsuleFile{
+		Name:   name,
+		Round:  roundNumber,
+		Size:   size,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	})
+
+	return nil
+}
+
+// Close writes the signed manifest and the end of the archive.
+func (cw *CapsuleWriter) Close() error {
+	manifest, err := json.MarshalIndent(cw.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	signature := hex.EncodeToString(ed25519.Sign(cw.key, manifest))
+
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{
+		{capsuleManifest, manifest},
+		{capsuleSignature, []byte(signature + "\n")},
+	} {
+		hdr := tar.Header{
+			Name:     entry.name,
+			Mode:     0600,
+			Size:     int64(len(entry.data)),
+			Typeflag: tar.TypeReg,
+			ModTime:  cw.manifest.Created,
+		}
+		if err := cw.tw.WriteHeader(&hdr); err != nil {
+			return fmt.Errorf("write capsule: %w", err)
+		}
+		if _, err := cw.tw.Write(entry.data); err != nil {
+			return fmt.Errorf("write capsule: %w", err)
+		}
+	}
+
+	return cw.tw.Close()
+}
+
+// ReadCapsule reads the manifest of the capsule at the specified path and
+// verifies its signature and the files it describes. If signer isn't empty,
+// the manifest has to be signed by that hex encoded public key.
+func ReadCapsule(path string, signer string) (CapsuleManifest, error) {
+	f, err := os.Open(localPath(path))
+	if err != nil {
+		return CapsuleManifest{}, fmt.Errorf("read capsule: %w", err)
+	}
+	defer f.Close()
+
+	var manifest, signature []byte
+	files := make(map[string]CapsuleFile)
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return CapsuleManifest{}, fmt.Errorf("read capsule: %w", err)
+		}
+
+		switch {
+		case hdr.Name == capsuleManifest:
+			if manifest, err = io.ReadAll(tr); err != nil {
+				return CapsuleManifest{}, fmt.Errorf("read capsule: %w", err)
+			}
+
+		case hdr.Name == capsuleSignature:
+			b, err := io.ReadAll(tr)
+			if err != nil {
+				return CapsuleManifest{}, fmt.Errorf("read capsule: %w", err)
+			}
+			if signature, err = hex.DecodeString(strings.TrimSpace(string(b))); err != nil {
+				return CapsuleManifest{}, fmt.Errorf("decoding capsule signature: %w", err)
+			}
+
+		case strings.HasPrefix(hdr.Name, capsuleFiles) && hdr.Typeflag == tar.TypeReg:
+			h := sha256.New()
+			size, err := io.Copy(h, tr)
+			if err != nil {
+				return CapsuleManifest{}, fmt.Errorf("read capsule: %w", err)
+			}
+			name := strings.TrimPrefix(hdr.Name, capsuleFiles)
+			if _, exists := files[name]; exists {
+				return CapsuleManifest{}, fmt.Errorf("capsule file %q is duplicated", name)
+			}
+			files[name] = CapsuleFile{Name: name, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}
+
+		default:
+			return CapsuleManifest{}, fmt.Errorf("capsule entry %q is unexpected", hdr.Name)
+		}
+	}
+
+	if manifest == nil || signature == nil {
+		return CapsuleManifest{}, errors.New("capsule has no signed manifest")
+	}
+
+	var m CapsuleManifest
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return CapsuleManifest{}, fmt.Errorf("parse capsule manifest: %w", err)
+	}
+
+	publicKey, err := hex.DecodeString(m.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return CapsuleManifest{}, fmt.Errorf("capsule public key %q is invalid", m.PublicKey)
+	}
+	if !ed25519.Verify(publicKey, manifest, signature) {
+		return CapsuleManifest{}, errors.New("capsule signature is invalid")
+	}
+	if signer != "" && !strings.EqualFold(signer, m.PublicKey) {
+		return CapsuleManifest{}, fmt.Errorf("capsule is signed by %s, not %s", m.PublicKey, signer)
+	}
+
+	if len(files) != len(m.Files) {
+		return CapsuleManifest{}, errors.New("capsule files don't match the manifest")
+	}
+	for _, mf := range m.Files {
+		if !validCapsuleName(mf.Name) {
+			return CapsuleManifest{}, fmt.Errorf("capsule file name %q is invalid", mf.Name)
+		}
+		f, exists := files[mf.Name]
+		if !exists || f.Size != mf.Size || f.SHA256 != mf.SHA256 {
+			return CapsuleManifest{}, fmt.Errorf("capsule file %q doesn't match the manifest", mf.Name)
+		}
+	}
+
+	return m, nil
+}
+
+// ReadSigningKey reads the PEM encoded PKCS #8 Ed25519 private key at the
+// specified path, as generated by "openssl genpkey -algorithm ed25519".
+func ReadSigningKey(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(localPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("read signing key: %w", err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("signing key %q isn't a PEM encoded private key", path)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse signing key: %w", err)
+	}
+
+	ed, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %q isn't an Ed25519 key", path)
+	}
+
+	return ed, nil
+}
+
+// =============================================================================
+
+// Capsule runs the commands that manage time capsules. A capsule bundles
+// files that are each locked to their own round with a signed manifest, and
+// opening it decrypts whichever files are available.
+func Capsule(ctx context.Context, out io.Writer, args []string) error {
+	var v verbosity
+
+	fs := flag.NewFlagSet("capsule", flag.ContinueOnError)
+	asJSON := jsonFlag(fs)
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch fs.Arg(0) {
+	case "create":
+		return createCapsule(ctx, out, v, *asJSON, fs.Args()[1:])
+
+	case "open":
+		return openCapsule(ctx, out, v, *asJSON, fs.Args()[1:])
+	}
+
+	return errors.New("capsule requires a command: create or open")
+}
+
+// capsuleSummary describes the outcome of capsule create and capsule open.
+type capsuleSummary struct {
+	Signer string          `json:"signer"`
+	Files  []capsuleResult `json:"files"`
+}
+
+// capsuleResult describes the state of a file of a capsule.
+type capsuleResult struct {
+	Name       string    `json:"name"`
+	Round      uint64    `json:"round"`
+	UnlockTime time.Time `json:"unlock_time"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// createCapsule encrypts the files of a schedule into a capsule.
+func createCapsule(ctx context.Context, out io.Writer, v verbosity, asJSON bool, args []string) error {
+	var networks listFlag
+
+	fs := flag.NewFlagSet("capsule create", flag.ContinueOnError)
+	fs.Var(&networks, "n", "the drand API endpoint; can be repeated")
+	fs.Var(&networks, "network", "the drand API endpoint; can be repeated")
+	chainHash := fs.String("c", defaultChain, "the chain the files are locked to")
+	fs.StringVar(chainHash, "chain", defaultChain, "the chain the files are locked to")
+	output := fs.String("o", "", "the path to the capsule")
+	fs.StringVar(output, "output", "", "the path to the capsule")
+	signingKey := fs.String("signing-key", "", "the Ed25519 private key signing the manifest")
+	pinFile := fs.String("pin-file", defaultPinFile(), "the file recording the public key of each chain")
+	policyFile := fs.String("policy-file", os.Getenv("TLE_POLICYFILE"), "the file holding the policy enforced when encrypting")
+	fs.BoolVar(&asJSON, "json", asJSON, "print machine readable JSON output")
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := NewLogger(os.Stderr, v.level())
+
+	if fs.NArg() != 1 || *output == "" || *output == "-" {
+		return errors.New("capsule create requires -o/--output and a single SCHEDULE")
+	}
+
+	s, err := ReadSchedule(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if len(networks) == 0 {
+		networks = listFlag{defaultNetwork}
+	}
+
+	network, err := NetworkForChain(ctx, log, networks, *pinFile, *chainHash, 0)
+	if err != nil {
+		return err
+	}
+
+	return sealCapsule(ctx, out, log, asJSON, s, network, *output, *signingKey, *policyFile)
+}
+
+// sealCapsule encrypts the files of the schedule into a capsule written to
+// the output, signed with the key at the signing key path or a new key.
+func sealCapsule(ctx context.Context, out io.Writer, log *Logger, asJSON bool, s Schedule, network *http.Network, output string, signingKey string, policyFile string) error {
+	key, err := SigningKey(signingKey)
+	if err != nil {
+		return err
+	}
+
+	clock := SystemClock{}
+	policy, err := LoadPolicy(policyFile, clock.Now())
+	if err != nil {
+		return err
+	}
+
+	f, err := CreateOutput(output)
+	if err != nil {
+		return fmt.Errorf("failed to open output file %q: %w", output, err)
+	}
+	defer f.Abort()
+
+	cw := NewCapsuleWriter(f, key, network.ChainHash(), clock.Now())
+	summary := capsuleSummary{Signer: cw.manifest.PublicKey}
+
+	for _, e := range s.Files {
+		log.Debugf("sealing %s as %s", e.Input, e.Name)
+
+		flags := Flags{
+			Input:    e.Input,
+			Round:    e.Round,
+			Duration: e.Duration,
+			At:       e.At,
+			TZ:       e.TZ,
+			policy:   policy,
+		}
+
+		roundNumber, err := sealFile(ctx, log, clock, flags, cw, e.Name, network)
+		if err != nil {
+			return fmt.Errorf("file %s: %w", e.Name, err)
+		}
+
+		summary.Files = append(summary.Files, capsuleResult{
+			Name:       e.Name,
+			Round:      roundNumber,
+			UnlockTime: network.RoundTime(roundNumber).UTC(),
+			Status:     "sealed",
+		})
+	}
+
+	if err := cw.Close(); err != nil {
+		return err
+	}
+
+	if err := f.Commit(); err != nil {
+		return err
+	}
+
+	return writeCapsuleSummary(out, asJSON, summary)
+}
+
+// sealFile encrypts the input of the flags and adds it to the capsule. The
+// encrypted file is staged in a temporary file, since the archive needs its
+// size upfront.
+func sealFile(ctx context.Context, log *Logger, clock Clock, flags Flags, cw *CapsuleWriter, name string, network *http.Network) (uint64, error) {
+	src, err := OpenInput(ctx, flags.Input)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp("", "tle-capsule-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	roundNumber, err := encrypt(ctx, log, clock, flags, tmp, src, network)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	if err := cw.Add(name, roundNumber, tmp, size); err != nil {
+		return 0, err
+	}
+
+	return roundNumber, nil
+}
+
+// openCapsule decrypts the files of a capsule whose round is reached into a
+// directory. Files that were already opened are left as is, so a capsule can
+// be opened again as more files become available.
+func openCapsule(ctx context.Context, out io.Writer, v verbosity, asJSON bool, args []string) error {
+	var networks listFlag
+
+	fs := flag.NewFlagSet("capsule open", flag.ContinueOnError)
+	fs.Var(&networks, "n", "the drand API endpoint; can be repeated")
+	fs.Var(&networks, "network", "the drand API endpoint; can be repeated")
+	output := fs.String("o", ".", "the directory the files are written to")
+	fs.StringVar(output, "output", ".", "the directory the files are written to")
+	signer := fs.String("signer", "", "the hex encoded public key that has to sign the manifest")
+	pinFile := fs.String("pin-file", defaultPinFile(), "the file recording the public key of each chain")
+	fs.BoolVar(&asJSON, "json", asJSON, "print machine readable JSON output")
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := NewLogger(os.Stderr, v.level())
+
+	if fs.NArg() != 1 {
+		return errors.New("capsule open requires a single CAPSULE")
+	}
+
+	m, err := ReadCapsule(fs.Arg(0), *signer)
+	if err != nil {
+		return err
+	}
+
+	if len(networks) == 0 {
+		networks = listFlag{defaultNetwork}
+	}
+
+	network, err := NetworkForChain(ctx, log, networks, *pinFile, m.ChainHash, 0)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(localPath(*output), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.Open(localPath(fs.Arg(0)))
+	if err != nil {
+		return fmt.Errorf("read capsule: %w", err)
+	}
+	defer f.Close()
+
+	rounds := make(map[string]uint64)
+	for _, mf := range m.Files {
+		rounds[mf.Name] = mf.Round
+	}
+
+	clock := SystemClock{}
+	tl := tlock.New(network, Options(log, clock, Flags{})...)
+	summary := capsuleSummary{Signer: m.PublicKey}
+	var failed int
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read capsule: %w", err)
+		}
+		if !strings.HasPrefix(hdr.Name, capsuleFiles) {
+			continue
+		}
+
+		name := strings.TrimPrefix(hdr.Name, capsuleFiles)
+		r := capsuleResult{
+			Name:       name,
+			Round:      rounds[name],
+			UnlockTime: network.RoundTime(rounds[name]).UTC(),
+		}
+
+		r.Status, err = openFile(ctx, tl, clock, filepath.Join(*output, name), tr, r)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			failed++
+			r.Error = err.Error()
+			log.Errorf("file %s: %s", name, err)
+		}
+
+		summary.Files = append(summary.Files, r)
+	}
+
+	if err := writeCapsuleSummary(out, asJSON, summary); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed", failed, len(summary.Files))
+	}
+
+	return nil
+}
+
+// openFile decrypts a file of a capsule to the path unless it was already
+// opened or its round isn't reached, and returns the state of the file.
+func openFile(ctx context.Context, tl tlock.Tlock, clock Clock, path string, src io.Reader, r capsuleResult) (string, error) {
+	if _, err := os.Stat(localPath(path)); err == nil {
+		return "already opened", nil
+	}
+
+	if r.UnlockTime.After(clock.Now()) {
+		return "locked", nil
+	}
+
+	out, err := CreateOutput(path)
+	if err != nil {
+		return "failed", fmt.Errorf("failed to open output file %q: %w", path, err)
+	}
+	defer out.Abort()
+
+	if err := tl.DecryptContext(ctx, out, src); err != nil {
+		if errors.Is(err, tlock.ErrTooEarly) {
+			return "locked", nil
+		}
+		return "failed", err
+	}
+
+	if err := out.Commit(); err != nil {
+		return "failed", err
+	}
+
+	return "opened", nil
+}
+
+// writeCapsuleSummary displays the signer and the state of every file of a
+// capsule.
+func writeCapsuleSummary(out io.Writer, asJSON bool, summary capsuleSummary) error {
+	if asJSON {
+		return json.NewEncoder(out).Encode(summary)
+	}
+
+	fmt.Fprintf(out, "signer: %s\n", summary.Signer)
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "NAME\tROUND\tUNLOCKS\tSTATUS\n")
+
+	for _, r := range summary.Files {
+		status := r.Status
+		if r.Error != "" {
+			status = "failed: " + r.Error
+		}
+
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", r.Name, r.Round, r.UnlockTime.Format(time.RFC3339), status)
+	}
+
+	return tw.Flush()
+}
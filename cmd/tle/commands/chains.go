@@ -0,0 +1,103 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/drand/drand/common/scheme"
+	"github.com/drand/tlock/networks/http"
+)
+
+// Chains lists the chains served by each of the specified drand endpoints
+// along with whether they can be used for time lock encryption. This helps
+// users pick the right value for the --chain flag.
+func Chains(ctx context.Context, out io.Writer, args []string) error {
+	var networks listFlag
+
+	fs := flag.NewFlagSet("chains", flag.ContinueOnError)
+	fs.Var(&networks, "n", "the drand API endpoint to query; can be repeated")
+	fs.Var(&networks, "network", "the drand API endpoint to query; can be repeated")
+	asJSON := jsonFlag(fs)
+	var v verbosity
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := NewLogger(os.Stderr, v.level())
+
+	if len(networks) == 0 {
+		networks = listFlag{defaultNetwork}
+	}
+
+	var endpoints []endpointChains
+	for _, host := range networks {
+		log.Debugf("listing chains of %s", host)
+		chainHashes, err := http.Chains(ctx, host)
+		if err != nil {
+			return fmt.Errorf("list chains: %w", err)
+		}
+
+		ec := endpointChains{Endpoint: host}
+		for _, chainHash := range chainHashes {
+			log.Debugf("fetching chain info of %s", chainHash)
+			info, err := http.ChainInfo(ctx, host, chainHash)
+			if err != nil {
+				return fmt.Errorf("chain info of %s: %w", chainHash, err)
+			}
+
+			ec.Chains = append(ec.Chains, chainSummary{
+				Hash:      chainHash,
+				Scheme:    info.Scheme.ID,
+				Period:    int64(info.Period / time.Second),
+				Genesis:   time.Unix(info.GenesisTime, 0).UTC(),
+				Supported: info.Scheme.ID == scheme.UnchainedSchemeID,
+			})
+		}
+		endpoints = append(endpoints, ec)
+	}
+
+	if *asJSON {
+		return json.NewEncoder(out).Encode(endpoints)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, ec := range endpoints {
+		fmt.Fprintf(tw, "%s\n", ec.Endpoint)
+		fmt.Fprintf(tw, "HASH\tSCHEME\tPERIOD\tGENESIS\tTLOCK\n")
+
+		for _, c := range ec.Chains {
+			supported := "no"
+			if c.Supported {
+				supported = "yes"
+			}
+
+			period := time.Duration(c.Period) * time.Second
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.Hash, c.Scheme, period, c.Genesis.Format(time.RFC3339), supported)
+		}
+
+		fmt.Fprintln(tw)
+	}
+
+	return tw.Flush()
+}
+
+// endpointChains describes the chains served by a drand endpoint.
+type endpointChains struct {
+	Endpoint string         `json:"endpoint"`
+	Chains   []chainSummary `json:"chains"`
+}
+
+// chainSummary describes a chain and whether it supports time lock encryption.
+type chainSummary struct {
+	Hash      string    `json:"hash"`
+	Scheme    string    `json:"scheme"`
+	Period    int64     `json:"period_seconds"`
+	Genesis   time.Time `json:"genesis"`
+	Supported bool      `json:"tlock_supported"`
+}
@@ -0,0 +1,78 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Clock represents the source of time used to calculate rounds, parse
+// durations, and wait for rounds to be reached. Tests can provide a clock
+// that moves forward without sleeping.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock implements the Clock interface using the system time.
+type SystemClock struct{}
+
+// Now returns the current system time.
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// After waits for the duration to elapse and then sends the current time on
+// the returned channel.
+func (SystemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// =============================================================================
+
+// waitPollInterval is how often the network is asked whether a signature is
+// available once a round should have been reached.
+const waitPollInterval = time.Second
+
+// RoundWaiter represents a network that can tell when a round is reached and
+// whether its signature is available.
+type RoundWaiter interface {
+	RoundTime(roundNumber uint64) time.Time
+	IsAvailableContext(ctx context.Context, roundNumber uint64) (bool, time.Time, error)
+}
+
+// WaitForRound blocks until the network serves the signature of the round.
+// It sleeps until the round is expected and then polls the network, since
+// signatures can take a moment to be published. Failures to reach the network
+// are retried like a signature that isn't available yet.
+func WaitForRound(ctx context.Context, log *Logger, clock Clock, network RoundWaiter, roundNumber uint64) error {
+	if remaining := network.RoundTime(roundNumber).Sub(clock.Now()); remaining > 0 {
+		log.Infof("waiting %s for round %d", formatRemaining(remaining), roundNumber)
+
+		select {
+		case <-clock.After(remaining):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for {
+		available, _, err := network.IsAvailableContext(ctx, roundNumber)
+		switch {
+		case available:
+			return nil
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case err != nil:
+			log.Debugf("checking round %d: %v", roundNumber, err)
+		default:
+			log.Debugf("signature of round %d not available yet", roundNumber)
+		}
+
+		select {
+		case <-clock.After(waitPollInterval):
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for round %d: %w", roundNumber, ctx.Err())
+		}
+	}
+}
@@ -3,11 +3,16 @@
 package commands
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/drand/tlock"
 	"github.com/kelseyhightower/envconfig"
 )
 
@@ -21,28 +26,384 @@ const (
 // =============================================================================
 
 const usage = `Usage:
-	tle [--encrypt] (-r round)... [--armor] [-o OUTPUT] [INPUT]
-	tle --decrypt [-o OUTPUT] [INPUT]
+	tle [--encrypt] (-r round)... [--armor [--armor-width N] [--armor-label LABEL] [--armor-preamble]] [--aad AAD] [--content-digest | --digest-key DIGEST-KEY] [--receipt RECEIPT [--signing-key KEY]] [--endpoint-hint URL]... [--force] [--mmap] [--compact] [--deterministic-seed SEED] [-o OUTPUT] [INPUT | --dir DIR [--compress]]
+	tle [--encrypt] (-r round)... [--aad AAD] [--force] --resume -o OUTPUT INPUT
+	tle [--encrypt | --decrypt] --records FORMAT [-o OUTPUT] [INPUT]
+	tle --decrypt [--wait | --beacon-file FILE] [--chain-from-header] [--lenient] [--aad AAD] [--digest-key DIGEST-KEY] [--resume | --mmap] [-o OUTPUT] [INPUT]
+	tle [--json] [-q|-v] chains [-n NETWORK]...
+	tle [--json] [-q|-v] status [-n NETWORK] [INPUT]
+	tle [--json] [-q|-v] inspect [-n NETWORK]... [INPUT]
+	tle [--json] [-q|-v] round [-n NETWORK]... [-c CHAIN] SPEC
+	tle [--json] [-q|-v] batch [-n NETWORK]... [-c CHAIN] [--output-template TEMPLATE] MANIFEST
+	tle [-q|-v] beacon export [-n NETWORK]... [-c CHAIN] [-o FILE] (-r ROUND | INPUT)
+	tle [--json] [-q|-v] beacon verify [-n NETWORK]... [-c CHAIN | --chain-info INFO] -r ROUND SIGNATURE
+	tle [--json] [-q|-v] capsule create [-n NETWORK]... [-c CHAIN] [--signing-key KEY] -o CAPSULE SCHEDULE
+	tle [--json] [-q|-v] capsule open [-n NETWORK]... [--signer PUBLIC-KEY] [-o DIR] CAPSULE
+	tle [--json] [-q|-v] hints create [-n NETWORK]... [-c CHAIN] [--signing-key KEY] [--start ROUND] --every DURATION -o BUNDLE HINT...
+	tle [--json] [-q|-v] hints list [-n NETWORK]... [--signer PUBLIC-KEY] BUNDLE
+	tle [-q|-v] relay [-n NETWORK]... [-c CHAIN]... [--listen ADDR] [--max-size BYTES] [--max-uploads N [--upload-queue N]] [--keys KEYS] [--metrics ADDR] STORAGE
+	tle [-q|-v] mail [-n NETWORK]... [-c CHAIN] [--listen ADDR] [--keys KEYS] [--ciphertext] [--state-key STATE-KEY] --smtp HOST:PORT --from ADDRESS STORAGE
+	tle [-q|-v] bot [-n NETWORK]... [-c CHAIN] [--state-key STATE-KEY] --homeserver URL --room ROOM STORAGE
+	tle [-q|-v] mount [-n NETWORK]... ARCHIVE MOUNTPOINT
+	tle [-q|-v] tui [-n NETWORK]... [--refresh DURATION] DIR
+	tle [--json] [-q|-v] push [--relay URL] [--token TOKEN] INPUT
+	tle [--json] [-q|-v] pull [--relay URL] [--token TOKEN] (--list | [-o OUTPUT] ID)
+	tle [--json] [-q|-v] receipt verify [-n NETWORK]... [--chain-info INFO] [--signer PUBLIC-KEY] RECEIPT [INPUT]
+	tle [--json] vault register [INPUT]
+	tle [--json] [-q|-v] vault verify [-n NETWORK]... [--chain-info INFO] CALLDATA [INPUT]
+	tle [--json] version
 
 Options:
 	-e, --encrypt  Encrypt the input to the output. Default if omitted.
 	-d, --decrypt  Decrypt the input to the output.
-	-n, --network  The drand API endpoint to use.
+	    --wait     Wait until the round of the input is reached instead of failing when decrypting too early.
+	    --chain-from-header Decrypt using the chain recorded in the input, served by --network or a known public endpoint. Default when decrypting without -c/--chain.
+	    --beacon-file Decrypt with the beacon in FILE, written by beacon export, instead of contacting the network.
+	    --lenient  Decrypt inputs whose header holds unknown arguments or values in a form tle doesn't write.
+	    --aad      Associated data, such as a document ID, that has to be provided again to decrypt.
+	    --content-digest Record the SHA-256 of the input in the header, which decryption verifies.
+	    --digest-key Record a digest keyed with the key in the file DIGEST-KEY instead, and verify it when decrypting.
+	    --endpoint-hint Record the URL of a drand endpoint serving the chain in the header. Can be repeated.
+	-n, --network  The drand API endpoint to use. Can be repeated to try several endpoints in order.
 	-c, --chain    The chain to use. Can use either beacon ID name or beacon hash. Use beacon hash in order to ensure public key integrity.
-	-r, --round    The specific round to use to encrypt the message. Cannot be used with --duration.
+	-r, --round    The round to use to encrypt the message. Cannot be used with --duration.
 	-D, --duration How long to wait before the message can be decrypted. Defaults to 120d (120 days).
+	    --at       The local date and time after which the message can be decrypted. Cannot be used with --round or --duration.
+	    --tz       The IANA time zone of --at, such as Europe/Paris. Defaults to the local time zone.
 	-o, --output   Write the result to the file at path OUTPUT.
+	    --dir      Encrypt the directory DIR as a tar archive instead of INPUT.
+	    --compress Compress the directory archive with gzip.
 	-a, --armor    Encrypt using the PEM encoded format.
-
-If the OUTPUT exists, it will be overwritten.
+	    --armor-width The number of columns of the armored lines, a multiple of 4. Defaults to 64.
+	    --armor-label The label of the armored header and footer, like "TLOCK ENCRYPTED FILE". Defaults to "AGE ENCRYPTED FILE".
+	    --armor-preamble Write a line stating the unlock time and chain before the armored data, for email or chat. age can't read it.
+	    --preserve-mode Record the permissions of the input file when encrypting and restore them when decrypting.
+	    --rm       Remove the input file once the output has been encrypted and flushed to disk.
+	    --shred    Overwrite the input file with random data before removing it. Best effort only.
+	    --force    Encrypt the input even if it already looks encrypted with tlock or age.
+	    --mmap     Map the input file into memory instead of reading it, falling back to reading it where mapping is unavailable.
+	    --compact  Encrypt a local input of up to 1 KiB as a single chunk without a nonce, which age can't decrypt.
+	    --deterministic-seed Derive every random value from SEED, so the output is reproducible. For test vectors only.
+	-q, --quiet    Only display errors. Suppresses the round and unlock time printed after encrypting.
+	-v, --verbose  Display debug information.
+	    --json     Print the output of status, inspect, round, chains, beacon verify, capsule, hints, push, pull, receipt verify, vault, version, the encryption summary and --stats as JSON.
+	    --stats    Report the input and output sizes, overhead, elapsed time and throughput once done.
+	    --resume   Keep the partial output of an interrupted operation and continue it when running the same command again.
+	    --pin-file The file recording the public key first seen for each chain. Use an empty value to disable pinning.
+	    --policy-file The file holding the policy enforced when encrypting.
+	    --audit-log Append a JSON line describing every completed operation to the file.
+	    --receipt  Write a signed receipt of the encrypted data to the file.
+	    --signing-key The PEM encoded Ed25519 private key signing the receipt, capsule or hints.
+	    --records  Encrypt or decrypt every record of the input separately, in FORMAT lines or binary.
+
+INPUT can be a file path, "-" for stdin, or an http:// or https:// URL that is
+streamed while encrypting or decrypting.
+
+If the OUTPUT exists, it will be overwritten. New outputs are created with 0600 permissions.
+When decrypting a directory encrypted with --dir, OUTPUT is the directory the
+tree is restored to, which must not exist yet. Without OUTPUT, the tar archive
+is written to stdout.
+
+Without OUTPUT, the result is written to stdout, unless stdout is a terminal
+and INPUT is a file. Encrypting then writes to INPUT.tle, and decrypting
+NAME.tle writes to NAME, like gzip does. An existing file is never replaced
+this way.
+
+With --resume, the output is written to OUTPUT.partial and the last complete
+chunk is recorded in OUTPUT.progress every second. Running the same command
+again after an interruption continues from there, and both files are replaced
+by OUTPUT once done. The progress of an encryption holds the key of the
+data until the encryption completes, so keep it as safe as the input.
 
 NETWORK defaults to the Drand test network http://pl-us.testnet.drand.sh/.
+When several endpoints are given, the first one serving the chain is used,
+and when decrypting, the first one that already serves the round. Errors
+name the endpoint that failed, and when no endpoint serves the chain, the
+failure of each one is listed.
 
 CHAIN defaults to the "unchained" hash in the default test network:
 7672797f548f3f4748ac4bf3352fc6c6b6468c9ad40ad456a397545c6e2df5bf
 
+When decrypting without -c/--chain, the chain is read from the input and
+served by NETWORK or by the public endpoints of the matching known network
+(mainnet, quicknet or testnet).
+
+PIN-FILE defaults to tlock/known_chains inside the user's configuration
+directory. If the network ever serves a different public key for a chain
+already recorded there, tle refuses to continue. Every command contacting a
+network checks it, and honors --pin-file.
+
+POLICY-FILE defaults to tlock/policy.yaml inside the user's configuration
+directory, which is ignored if it doesn't exist. It can bound how far ahead
+data is locked, list the allowed chains, and require armor or future rounds:
+
+    min_duration: 1h
+    max_duration: 5y
+    allowed_chains: [52db9ba70e0cc0f6eaf7803dd07447a1f5477735fd3f661792ba94600c84e971]
+    require_armor: true
+    forbid_past_rounds: true
+
+AUDIT-LOG records the time, operation, input, output, round, chain and
+endpoint of every completed operation, with the SHA-256 of the encrypted data
+so sealing and opening the same data can be matched. It can also be set with
+the TLE_AUDITLOG environment variable, and applies to batch.
+
+ROUND accepts an absolute round number (1234567), a number of rounds after the
+current round (+1000), the round available at a given time
+(time:2025-07-01T00:00Z), or the round available after a given duration
+(dur:45d).
+
 DURATION has a default value of 120d. When it is specified, it expects a number
-followed by one of these units: "ns", "us" (or "µs"), "ms", "s", "m", "h", "d", "M", "y").
+followed by one of these units: "ns", "us" (or "µs"), "ms", "s", "m", "h", "d",
+"w", "M", "y". Units can be combined, like 1y6M or 2w3d12h, and ISO-8601
+durations like P1Y2M3D are accepted as well.
+
+AAD is authenticated with the encrypted data but not stored in it. Data
+encrypted with --aad can only be decrypted with the same value, which prevents
+it from being swapped with data encrypted for another context.
+
+--content-digest records the SHA-256 of INPUT in the authenticated header,
+and decryption fails once all the data is written if it doesn't match, which
+detects corruption end to end. The digest can be read before the round is
+reached, letting anyone confirm a guess of INPUT, so --digest-key records an
+HMAC-SHA256 with the key held in DIGEST-KEY instead, which is only verified
+when decrypting with the same key. Both require a local INPUT, which is read
+twice, and format v4 to decrypt.
+
+Decryption parses the header strictly, rejecting the arguments it doesn't know
+and the values in a form tle doesn't write, such as numbers with leading zeros.
+--lenient ignores unknown arguments instead, so that data written by newer
+versions or other implementations can be decrypted.
+
+Encryption fails if INPUT already looks encrypted with tlock or age, armored or
+not, since pipelines tend to encrypt data twice by accident; --force encrypts
+it anyway. Likewise, decryption points out decrypted data that still looks
+encrypted, so that it can be decrypted again.
+
+Encrypting a local INPUT processes its chunks in parallel on all cores. With
+--compact, inputs up to 1 KiB are encrypted as a single chunk without a nonce,
+which saves a few bytes but can't be decrypted with age or tle before format
+v3 either.
+
+--mmap maps INPUT into memory, which avoids copying it through buffers, and
+falls back to reading it on platforms and file systems that can't map it.
+INPUT must not be truncated while it is mapped.
+
+--deterministic-seed derives the key of the data and every other random value
+from SEED, so encrypting the same INPUT for the same round with the same flags
+writes the same output every time. It's meant for test vectors and
+reproducible builds only: anyone who knows SEED, or sees two inputs encrypted
+with it for the same round, can decrypt them right away.
+
+MANIFEST is a YAML file listing the operations run by batch. Every entry
+needs an input and an output, unless TEMPLATE names it, and accepts decrypt,
+chain, round, duration, at, tz, armor, armor_width, armor_label and aad,
+which work like the flags of the same name. The networks and chain given at
+the top level, or with -n and -c, apply to every entry, and relative paths
+are resolved against the manifest's directory. A summary of every entry is
+displayed once all of them ran:
+
+    networks: [https://api.drand.sh/]
+    entries:
+      - input: release.tar.gz
+        output: release.tar.gz.tle
+        at: "2025-07-01 09:00"
+        tz: Europe/Paris
+
+TEMPLATE names the outputs of the entries without one, like
+"{{.Stem}}.{{.Round}}.tle", and can also be set with output_template in the
+manifest. It is a Go template given the Name, Stem and Ext of the input, the
+Round and Chain of the data, and its Unlock time, so outputs can encode when
+they unlock.
+
+FILE written by beacon export holds the signature of a round and the
+information of its chain, verified against the pinned public key. It lets
+machines without network access decrypt data locked to that round:
+
+    online$  tle beacon export -o beacon.json data.tle
+    offline$ tle -d --beacon-file beacon.json -o data data.tle
+
+beacon verify checks a hex encoded SIGNATURE received out of band against the
+chain served by NETWORK, or described by the drand /info JSON in INFO, and
+reports when the chain published ROUND. It fails if the signature is invalid.
+
+CAPSULE is a tar archive bundling files that are each locked to their own
+round with a manifest signed by KEY, a PEM encoded Ed25519 private key as
+generated by "openssl genpkey -algorithm ed25519". Without KEY, a new key is
+used and its PUBLIC-KEY is displayed, to be shared with whoever opens the
+capsule. SCHEDULE is a YAML file listing the files, whose input and one of
+round, duration and at are required, along with an optional name and tz.
+capsule open verifies the manifest, signed by PUBLIC-KEY if given, and
+decrypts the files that are available to DIR, so it can be run again as
+more files unlock:
+
+    files:
+      - input: letter.txt
+        at: "2030-01-01 09:00"
+      - input: will.pdf
+        duration: 10y
+
+BUNDLE is a capsule holding HINT files, such as the hints of a puzzle, that
+unlock one after the other. The first one unlocks at ROUND, the next round by
+default, and every other one DURATION after the previous one. hints list
+shows which hints can be opened without decrypting them, and capsule open
+decrypts them:
+
+    $ tle hints create --every 1h -o hints.tar hint1.txt hint2.txt hint3.txt
+    $ tle hints list hints.tar
+
+STORAGE is where relay stores the encrypted items uploaded to it, which it
+only serves once their round is reached, so tlock can be used for embargoed
+publishing. Items are uploaded with POST /items, described by GET /items/ID
+and downloaded with GET /items/ID/data, which fails with 425 Too Early until
+then, with a Retry-After header and a JSON body telling the round of the item
+and when it's released. GET /events streams the items as they are released,
+and /docs describes the protocol, whose OpenAPI document is served at
+/openapi.json for generating clients in other languages. The relay accepts
+the chains served by NETWORK, or only CHAIN if given, and listens on ADDR,
+127.0.0.1:8080 by default. It refuses to listen on another ADDR without
+KEYS, which would let anyone upload items to it. Items are limited to BYTES, 64 MiB by default, and at most
+--max-uploads uploads are handled at once, 16 by default, while up to
+--upload-queue more wait for their turn, 64 by default. Other uploads fail
+with 429 Too Many Requests until the load goes down.
+
+STORAGE is a directory, s3://BUCKET/PREFIX to store the items in an S3
+bucket, or memory: to keep them in memory until the relay exits. A bucket is
+accessed with the credentials of $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY
+and $AWS_SESSION_TOKEN, in the region of $AWS_REGION, or through another
+service implementing the S3 API at $AWS_ENDPOINT_URL. Storing the items and
+uploads in a bucket lets a relay run with an ephemeral disk:
+
+    $ AWS_REGION=eu-west-1 tle relay s3://newsroom-relay/items
+
+KEYS is a YAML file listing the API keys of the relay. With it, uploading and
+listing items requires the token of a key as TOKEN, while items are still
+served to anyone. Every key can limit its requests per minute, the size of
+its items and their total size in bytes:
+
+    - name: newsroom
+      token: 5f0c6a...
+      rate_limit: 60
+      max_size: 10485760
+      quota: 1073741824
+
+On SIGHUP, the relay reloads KEYS and fetches the chains from NETWORK again,
+without interrupting the event streams and uploads in progress. A KEYS file
+that isn't valid is reported and the previous keys are kept.
+
+With --metrics, the relay serves Prometheus metrics at /metrics on ADDR,
+covering the items stored and pending, the requests served and throttled,
+and the failures to fetch chains from NETWORK.
+
+mail runs a gateway scheduling emails for the future. POST /messages takes a
+JSON object holding the recipient as "to", an optional "subject" and an
+armored "ciphertext" locked to CHAIN, and the gateway emails the message
+through the SMTP server at HOST:PORT, from ADDRESS, once its round is reached,
+so it's provably unreadable until then. With --ciphertext, the email holds
+the encrypted message and the beacon of its round instead of the plain text,
+which the gateway never sees. The messages and the emails waiting to be sent
+are kept in STORAGE, so they survive restarts, and emails that can't be sent
+are retried with an exponential backoff for about two hours before they are
+dropped. The plain text of an email waiting to be sent is kept in STORAGE,
+and deleted once the email is sent or dropped, so every object of STORAGE is
+encrypted with a key derived from STATE-KEY, a file holding at least 32
+random bytes, which is required unless --ciphertext is set or STORAGE is
+memory:. With KEYS, scheduling
+requires the token of a key as bearer token. The gateway listens on
+127.0.0.1:8080 by default, and refuses to listen on another ADDR without
+KEYS, which would let anyone send emails through it.
+The SMTP server is authenticated with $TLE_SMTP_USERNAME and
+$TLE_SMTP_PASSWORD if set:
+
+    $ head -c 32 /dev/urandom > state.key
+    $ tle mail --state-key state.key --smtp smtp.example.com:587 --from vault@example.com messages
+    $ curl -H "Authorization: Bearer $TOKEN" -d @message.json localhost:8080/messages
+
+relay and mail stop gracefully on SIGTERM. Run by systemd, they use the
+socket passed by socket activation instead of listening on ADDR, and with
+Type=notify they tell systemd once they are ready and when they stop, feeding
+its watchdog if WatchdogSec is set. The relay serves /healthz, which succeeds
+while it runs, and /readyz, which fails until STORAGE can be listed and
+NETWORK reached, for the probes of orchestrators such as Kubernetes.
+
+bot runs a chat bot in the Matrix room ROOM of the homeserver at URL, logged
+in with the access token in $TLE_MATRIX_TOKEN. Armored messages locked to
+CHAIN that are sent to the room are kept in STORAGE, their senders are told
+when they are released, and their plain text is posted to the room once their
+round is reached, including after a restart. With STATE-KEY, the messages and
+their senders are encrypted in STORAGE like with mail:
+
+    $ TLE_MATRIX_TOKEN=syt_... tle bot --homeserver https://matrix.example.org --room '!predictions:example.org' messages
+
+mount exposes the plain data of ARCHIVE, a local file in the binary format,
+as a read-only filesystem at MOUNTPOINT until it's interrupted. It holds a
+single file, named after ARCHIVE without its .tle extension, or a tar archive
+for an encrypted directory, whose modification time is the round's unlock
+time. Opening it fails with EPERM until the round is reached, then only the
+chunks covering each read are decrypted. mount requires FUSE, so it's only
+supported on Linux:
+
+    $ tle mount disk.img.tle /mnt/disk
+
+tui lists the encrypted files of DIR with the time left until they unlock,
+and reads commands from the standard input: inspect shows the header of a
+file, open decrypts it next to it once its round is reached, checking every
+DURATION, and extend locks it until a later round by encrypting it again,
+which open undoes layer by layer. The list is drawn again after every
+command:
+
+    $ tle tui ~/capsules
+
+URL is the relay push uploads INPUT to and pull downloads the item ID from,
+defaulting to $TLE_RELAY. push prints the ID of the item and records the
+upload in INPUT.upload until it completes, so running it again after an
+interruption resumes the upload. The relay checks that INPUT is locked to the
+round it claims. TOKEN, defaulting to $TLE_RELAY_TOKEN, identifies the owner
+of the items, and pull --list shows the items pushed with it that aren't
+released yet:
+
+    $ id=$(tle push --relay https://relay.example.com report.tle)
+    $ tle pull --relay https://relay.example.com -o report.tle $id
+
+RECEIPT written by --receipt holds the SHA-256 of the encrypted data, its
+round and chain, and the latest beacon of the chain when it was written,
+signed by KEY or by a new key. It lets third parties check what was locked
+and until when without the encrypted data. receipt verify checks the
+signature, verifies the beacon against the chain, and checks that INPUT is
+the encrypted data if given:
+
+    $ tle -D 1y --receipt receipt.json --signing-key key.pem -o data.tle data
+    $ tle receipt verify --signer 03a107bf... receipt.json data.tle
+
+vault bridges encrypted files and timevault contracts, which hold blobs in
+escrow until they are revealed with the signature of their round, as
+described by evm/Timevault.sol. vault register prints the commitment to the
+round and chain INPUT is locked to, with the keccak256 of its binary form,
+and the calldata registering it. vault verify checks the CALLDATA of a reveal
+transaction: its signature has to be the one of the round it reveals,
+verified against the chain served by NETWORK or described by INFO, and INPUT
+has to be the blob it reveals if given:
+
+    $ tle vault register bid.tle
+    $ tle vault verify 0x... bid.tle
+
+version reports the release and commit tle was built from, the format
+versions it can decrypt, the drand schemes and payload algorithms it
+supports and the chains it knows, to be attached to bug reports:
+
+    $ tle --json version
+
+FORMAT selects how --records splits the input. With lines, every line is
+encrypted as it's read and written as a line of base64; with binary, records
+are prefixed with their length as a 4 byte big endian integer. Every record
+is locked for the duration given when it's read, so a stream like a log can
+be released gradually. Decrypting skips the records that are still locked:
+
+    $ app | tle -D 30d --records lines -o events.tle
+    $ tle -d --records lines events.tle
+
+AT accepts a date and time like "2025-12-25 09:00", which is interpreted in the
+time zone given by --tz.
 
 Example:
     $ ./tle -D 10d -o encrypted_file data_to_encrypt
@@ -57,16 +418,141 @@ func PrintUsage(log *log.Logger) {
 
 // =============================================================================
 
+// Subcommand represents a named tle operation that parses its own arguments.
+type Subcommand func(ctx context.Context, out io.Writer, args []string) error
+
+// subcommands maps the name of each subcommand to its implementation.
+var subcommands = map[string]Subcommand{
+	"batch":   Batch,
+	"beacon":  Beacon,
+	"capsule": Capsule,
+	"hints":   Hints,
+	"relay":   Relay,
+	"mail":    Mail,
+	"bot":     Bot,
+	"mount":   Mount,
+	"tui":     TUI,
+	"push":    Push,
+	"pull":    Pull,
+	"chains":  Chains,
+	"receipt": Receipt,
+	"vault":   Vault,
+	"status":  Status,
+	"inspect": Inspect,
+	"round":   Round,
+	"version": Version,
+}
+
+// globalFlags lists the flags that can be given before a subcommand name and
+// are forwarded to the subcommand.
+var globalFlags = map[string]bool{
+	"-json":     true,
+	"--json":    true,
+	"-q":        true,
+	"-quiet":    true,
+	"--quiet":   true,
+	"-v":        true,
+	"-verbose":  true,
+	"--verbose": true,
+}
+
+// Dispatch runs the subcommand named by the first argument that isn't a
+// global flag. It reports false if the arguments don't name a subcommand.
+func Dispatch(ctx context.Context, out io.Writer, args []string) (bool, error) {
+	var global []string
+	for len(args) > 0 && globalFlags[args[0]] {
+		global = append(global, args[0])
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	sub, exists := subcommands[args[0]]
+	if !exists {
+		return false, nil
+	}
+
+	return true, sub(ctx, out, append(global, args[1:]...))
+}
+
+// jsonFlag registers the --json flag with the flag set.
+func jsonFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("json", false, "print machine readable JSON output")
+}
+
+// listFlag is a flag value that accumulates the values of a repeated flag.
+// Each value can also hold a comma separated list.
+type listFlag []string
+
+// String implements the flag.Value interface.
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set implements the flag.Value interface.
+func (l *listFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// =============================================================================
+
 // Flags represent the values from the command line.
 type Flags struct {
-	Encrypt  bool
-	Decrypt  bool
-	Network  string
-	Chain    string
-	Round    uint64
-	Duration string
-	Output   string
-	Armor    bool
+	Encrypt         bool
+	Decrypt         bool
+	Network         []string
+	Chain           string
+	Round           string
+	Duration        string
+	Output          string
+	Armor           bool
+	ArmorWidth      int
+	ArmorLabel      string
+	ArmorPreamble   bool
+	Remove          bool
+	Shred           bool
+	Mode            bool
+	Wait            bool
+	AAD             string
+	ChainFromHeader bool
+	Lenient         bool
+	BeaconFile      string
+	Input           string
+	Dir             string
+	Compress        bool
+	Quiet           bool
+	Verbose         bool
+	JSON            bool
+	Stats           bool
+	Resume          bool
+	At              string
+	TZ              string
+	PinFile         string
+	PolicyFile      string
+	AuditLog        string
+	Receipt         string
+	SigningKey      string
+	Records         string
+	ContentDigest   bool
+	DigestKey       string
+	Force           bool
+	EndpointHints   []string
+	Mmap            bool
+	Compact         bool
+
+	// DeterministicSeed can only be set on the command line, so that an
+	// environment variable can't make the output reproducible by accident.
+	DeterministicSeed string `ignored:"true"`
+
+	policy    *tlock.Policy
+	digestKey []byte
 }
 
 // Parse will parse the environment variables and command line flags. The command
@@ -75,9 +561,9 @@ func Parse() (Flags, error) {
 	flag.Usage = func() { fmt.Fprintf(os.Stderr, "%s\n", usage) }
 
 	f := Flags{
-		Network:  defaultNetwork,
 		Chain:    defaultChain,
 		Duration: defaultDuration,
+		PinFile:  defaultPinFile(),
 	}
 
 	envconfig.Process("tle", &f)
@@ -87,9 +573,52 @@ func Parse() (Flags, error) {
 		return Flags{}, err
 	}
 
+	if !f.Decrypt {
+		policy, err := LoadPolicy(f.PolicyFile, time.Now())
+		if err != nil {
+			return Flags{}, err
+		}
+		f.policy = policy
+	}
+
+	if f.DigestKey != "" {
+		key, err := os.ReadFile(localPath(f.DigestKey))
+		if err != nil {
+			return Flags{}, fmt.Errorf("read digest key: %w", err)
+		}
+		if len(key) == 0 {
+			return Flags{}, fmt.Errorf("digest key %q is empty", f.DigestKey)
+		}
+		f.digestKey = key
+	}
+
+	// Without an explicit chain, decryption uses the chain recorded in the
+	// input, so data encrypted for any known network can be decrypted
+	// without matching flags.
+	if f.Decrypt && f.Records == "" && !chainIsSet() {
+		f.ChainFromHeader = true
+	}
+
 	return f, nil
 }
 
+// chainIsSet reports whether the chain was given on the command line or in
+// the environment.
+func chainIsSet() bool {
+	if os.Getenv("TLE_CHAIN") != "" {
+		return true
+	}
+
+	var set bool
+	flag.Visit(func(fl *flag.Flag) {
+		if fl.Name == "c" || fl.Name == "chain" {
+			set = true
+		}
+	})
+
+	return set
+}
+
 // parseCmdline will parse all the command line flags.
 // The default value is set to the values parsed by the environment variables.
 func parseCmdline(f *Flags) *Flags {
@@ -99,31 +628,135 @@ func parseCmdline(f *Flags) *Flags {
 	flag.BoolVar(&f.Decrypt, "d", f.Decrypt, "decrypt the input to the output")
 	flag.BoolVar(&f.Decrypt, "decrypt", f.Decrypt, "decrypt the input to the output")
 
-	flag.StringVar(&f.Network, "n", f.Network, "the drand API endpoint")
-	flag.StringVar(&f.Network, "network", f.Network, "the drand API endpoint")
+	var networks listFlag
+	flag.Var(&networks, "n", "the drand API endpoint; can be repeated")
+	flag.Var(&networks, "network", "the drand API endpoint; can be repeated")
 
 	flag.StringVar(&f.Chain, "c", f.Chain, "chain to use")
 	flag.StringVar(&f.Chain, "chain", f.Chain, "chain to use")
 
-	flag.Uint64Var(&f.Round, "r", f.Round, "the specific round to use; cannot be used with --duration")
-	flag.Uint64Var(&f.Round, "round", f.Round, "the specific round to use; cannot be used with --duration")
+	flag.StringVar(&f.Round, "r", f.Round, "the round to use; cannot be used with --duration")
+	flag.StringVar(&f.Round, "round", f.Round, "the round to use; cannot be used with --duration")
 
 	flag.StringVar(&f.Duration, "D", f.Duration, "how long to wait before being able to decrypt")
 	flag.StringVar(&f.Duration, "duration", f.Duration, "how long to wait before being able to decrypt")
 
+	flag.StringVar(&f.At, "at", f.At, "the local date and time after which decryption is possible")
+	flag.StringVar(&f.TZ, "tz", f.TZ, "the IANA time zone of --at")
+
 	flag.StringVar(&f.Output, "o", f.Output, "the path to the output file")
 	flag.StringVar(&f.Output, "output", f.Output, "the path to the output file")
 
 	flag.BoolVar(&f.Armor, "a", f.Armor, "encrypt to a PEM encoded format")
 	flag.BoolVar(&f.Armor, "armor", f.Armor, "encrypt to a PEM encoded format")
 
+	flag.IntVar(&f.ArmorWidth, "armor-width", f.ArmorWidth, "the width of the armored lines")
+	flag.StringVar(&f.ArmorLabel, "armor-label", f.ArmorLabel, "the label of the armored header and footer")
+	flag.BoolVar(&f.ArmorPreamble, "armor-preamble", f.ArmorPreamble, "write the unlock time before the armored data")
+
+	flag.StringVar(&f.Dir, "dir", f.Dir, "encrypt the directory as a tar archive")
+	flag.BoolVar(&f.Compress, "compress", f.Compress, "compress the directory archive with gzip")
+
+	flag.BoolVar(&f.Mode, "preserve-mode", f.Mode, "record the permissions of the input and restore them on decryption")
+
+	flag.BoolVar(&f.Wait, "wait", f.Wait, "wait until the round is reached when decrypting")
+
+	flag.BoolVar(&f.ChainFromHeader, "chain-from-header", f.ChainFromHeader, "use the chain recorded in the input when decrypting")
+
+	flag.StringVar(&f.BeaconFile, "beacon-file", f.BeaconFile, "decrypt with the beacon in the file instead of the network")
+
+	flag.BoolVar(&f.Lenient, "lenient", f.Lenient, "accept unknown header arguments and non-canonical values when decrypting")
+
+	flag.StringVar(&f.AAD, "aad", f.AAD, "associated data that has to be provided again to decrypt")
+
+	flag.BoolVar(&f.ContentDigest, "content-digest", f.ContentDigest, "record the SHA-256 of the input in the header")
+	flag.StringVar(&f.DigestKey, "digest-key", f.DigestKey, "the file holding the key of a keyed content digest")
+
+	flag.BoolVar(&f.Force, "force", f.Force, "encrypt the input even if it looks encrypted already")
+
+	flag.BoolVar(&f.Mmap, "mmap", f.Mmap, "map the input file into memory instead of reading it")
+	flag.BoolVar(&f.Compact, "compact", f.Compact, "encrypt a local input of up to 1 KiB as a compact payload")
+
+	var hints listFlag
+	flag.Var(&hints, "endpoint-hint", "the URL of a drand endpoint recorded in the header; can be repeated")
+
+	flag.BoolVar(&f.Remove, "rm", f.Remove, "remove the input file after encrypting")
+	flag.BoolVar(&f.Shred, "shred", f.Shred, "overwrite and remove the input file after encrypting")
+
+	flag.BoolVar(&f.Quiet, "q", f.Quiet, "only display errors")
+	flag.BoolVar(&f.Quiet, "quiet", f.Quiet, "only display errors")
+
+	flag.BoolVar(&f.Verbose, "v", f.Verbose, "display debug information")
+	flag.BoolVar(&f.Verbose, "verbose", f.Verbose, "display debug information")
+
+	flag.BoolVar(&f.JSON, "json", f.JSON, "print machine readable JSON output")
+
+	flag.BoolVar(&f.Stats, "stats", f.Stats, "report the sizes, overhead and throughput of the operation")
+
+	flag.BoolVar(&f.Resume, "resume", f.Resume, "keep the progress so an interrupted operation can be continued")
+
+	flag.StringVar(&f.PinFile, "pin-file", f.PinFile, "the file recording the public key of each chain")
+
+	flag.StringVar(&f.PolicyFile, "policy-file", f.PolicyFile, "the file holding the policy enforced when encrypting")
+
+	flag.StringVar(&f.AuditLog, "audit-log", f.AuditLog, "the file completed operations are appended to")
+
+	flag.StringVar(&f.Receipt, "receipt", f.Receipt, "write a signed receipt of the encryption to the file")
+	flag.StringVar(&f.SigningKey, "signing-key", f.SigningKey, "the Ed25519 private key signing the receipt")
+
+	flag.StringVar(&f.Records, "records", f.Records, "encrypt or decrypt every record of the input separately: lines or binary")
+	flag.StringVar(&f.DeterministicSeed, "deterministic-seed", f.DeterministicSeed, "derive every random value from the seed, for test vectors only")
+
 	flag.Parse()
+	f.Input = flag.Arg(0)
+	f.EndpointHints = hints
+
+	if len(networks) > 0 {
+		f.Network = networks
+	}
+	if len(f.Network) == 0 {
+		f.Network = []string{defaultNetwork}
+	}
 
 	return f
 }
 
+// Level returns the logging level selected by the flags.
+func (f Flags) Level() Level {
+	return levelOf(f.Quiet, f.Verbose)
+}
+
 // validateFlags performs a sanity check of the provided flag information.
 func validateFlags(f Flags) error {
+	if f.Quiet && f.Verbose {
+		return fmt.Errorf("-q/--quiet can't be used with -v/--verbose")
+	}
+
+	if f.Resume {
+		if !IsLocalFile(f.Input) || f.Output == "" || f.Output == "-" {
+			return fmt.Errorf("--resume requires a local input file and -o/--output")
+		}
+		if f.Armor || f.Stats {
+			return fmt.Errorf("--resume can't be used with -a/--armor or --stats")
+		}
+	}
+
+	if f.Mmap && (!IsLocalFile(f.Input) || f.Resume) {
+		return fmt.Errorf("--mmap requires a local input file and can't be used with --resume")
+	}
+
+	if f.Records != "" {
+		if !validRecords(f.Records) {
+			return fmt.Errorf("--records must be %s or %s", RecordLines, RecordBinary)
+		}
+		if f.Armor || f.Dir != "" || f.Resume || f.Mode || f.Receipt != "" || f.DeterministicSeed != "" {
+			return fmt.Errorf("--records can't be used with -a/--armor, --dir, --resume, --preserve-mode, --receipt or --deterministic-seed")
+		}
+		if f.Wait || f.ChainFromHeader || f.BeaconFile != "" {
+			return fmt.Errorf("--records can't be used with --wait, --chain-from-header or --beacon-file")
+		}
+	}
+
 	switch {
 	case f.Decrypt:
 		if f.Encrypt {
@@ -135,17 +768,98 @@ func validateFlags(f Flags) error {
 		if f.Armor {
 			return fmt.Errorf("-a/--armor can't be used with -d/--decrypt")
 		}
+		if f.ArmorWidth != 0 || f.ArmorLabel != "" || f.ArmorPreamble {
+			return fmt.Errorf("--armor-width, --armor-label and --armor-preamble can't be used with -d/--decrypt")
+		}
+		if f.Dir != "" || f.Compress {
+			return fmt.Errorf("--dir and --compress can't be used with -d/--decrypt; use -o/--output to restore a directory")
+		}
+		if f.At != "" {
+			return fmt.Errorf("--at can't be used with -d/--decrypt")
+		}
+		if f.Remove || f.Shred {
+			return fmt.Errorf("--rm and --shred can't be used with -d/--decrypt")
+		}
+		if f.Mode && (!IsLocalFile(f.Input) || f.Output == "" || f.Output == "-") {
+			return fmt.Errorf("--preserve-mode requires a local input file and -o/--output")
+		}
+		if f.BeaconFile != "" && f.Wait {
+			return fmt.Errorf("--beacon-file can't be used with --wait")
+		}
+		if f.Receipt != "" || f.SigningKey != "" {
+			return fmt.Errorf("--receipt and --signing-key can't be used with -d/--decrypt")
+		}
+		if f.ContentDigest {
+			return fmt.Errorf("--content-digest can't be used with -d/--decrypt, which verifies the digest of the input")
+		}
+		if f.Force {
+			return fmt.Errorf("--force can't be used with -d/--decrypt")
+		}
+		if len(f.EndpointHints) > 0 {
+			return fmt.Errorf("--endpoint-hint can't be used with -d/--decrypt")
+		}
+		if f.DeterministicSeed != "" {
+			return fmt.Errorf("--deterministic-seed can't be used with -d/--decrypt")
+		}
+		if f.Compact {
+			return fmt.Errorf("--compact can't be used with -d/--decrypt")
+		}
+
+	case f.Wait:
+		return fmt.Errorf("--wait can only be used with -d/--decrypt")
+
+	case f.ChainFromHeader:
+		return fmt.Errorf("--chain-from-header can only be used with -d/--decrypt")
+
+	case f.BeaconFile != "":
+		return fmt.Errorf("--beacon-file can only be used with -d/--decrypt")
+
+	case f.Lenient:
+		return fmt.Errorf("--lenient can only be used with -d/--decrypt")
 
 	default:
 		if f.Chain == "" {
 			return fmt.Errorf("-c/--chain can't be empty")
 		}
-		if f.Duration != defaultDuration && f.Round != 0 {
+		if f.Duration != defaultDuration && f.Round != "" {
 			return fmt.Errorf("-D/--duration can't be used with -r/--round")
 		}
-		if f.Duration == "" && f.Round == 0 {
+		if f.At != "" && (f.Round != "" || f.Duration != defaultDuration) {
+			return fmt.Errorf("--at can't be used with -r/--round or -D/--duration")
+		}
+		if f.Dir != "" && (f.Input != "" || f.Remove || f.Shred || f.Mode) {
+			return fmt.Errorf("--dir can't be used with INPUT, --rm, --shred or --preserve-mode")
+		}
+		if f.Compress && f.Dir == "" {
+			return fmt.Errorf("--compress requires --dir")
+		}
+		if (f.ArmorWidth != 0 || f.ArmorLabel != "" || f.ArmorPreamble) && !f.Armor {
+			return fmt.Errorf("--armor-width, --armor-label and --armor-preamble require -a/--armor")
+		}
+		if f.TZ != "" && f.At == "" {
+			return fmt.Errorf("--tz can only be used with --at")
+		}
+		if (f.Remove || f.Shred) && (f.Output == "" || f.Output == "-") {
+			return fmt.Errorf("--rm and --shred require -o/--output")
+		}
+		if (f.Remove || f.Shred) && !IsLocalFile(f.Input) {
+			return fmt.Errorf("--rm and --shred require a local input file")
+		}
+		if f.Mode && !IsLocalFile(f.Input) {
+			return fmt.Errorf("--preserve-mode requires a local input file")
+		}
+		if f.Duration == "" && f.Round == "" {
 			return fmt.Errorf("-D/--duration or -r/--round must be specified")
 		}
+		if f.SigningKey != "" && f.Receipt == "" {
+			return fmt.Errorf("--signing-key requires --receipt")
+		}
+		if (f.ContentDigest || f.DigestKey != "") && (!IsLocalFile(f.Input) || f.Records != "" || f.Resume || f.Stats) {
+			return fmt.Errorf("--content-digest and --digest-key require a local input file and can't be used with --records, --resume or --stats")
+		}
+		if f.Compact && (!IsLocalFile(f.Input) || f.Dir != "" || f.Records != "" || f.Resume) {
+			return fmt.Errorf("--compact requires a local input file and can't be used with --dir, --records or --resume")
+		}
 	}
 
 	return nil
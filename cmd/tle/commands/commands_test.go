@@ -1,41 +1,1737 @@
 package commands
 
 import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	bls "github.com/drand/kyber-bls12381"
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/armor"
+	"github.com/drand/tlock/evm"
+	"github.com/drand/tlock/internal/fakenet"
+	"github.com/drand/tlock/networks/http"
+	"github.com/drand/tlock/relay"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-func Test_ParseDuration(t *testing.T) {
+func Test_VerifyPin(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_chains")
+
+	g1 := bls.NewBLS12381Suite().G1()
+	pinned := g1.Point().Base()
+	other := g1.Point().Mul(g1.Scalar().SetInt64(2), nil)
+
+	if err := VerifyPin(path, "abcd", pinned); err != nil {
+		t.Fatalf("unexpected first use error: %s", err)
+	}
+
+	if err := VerifyPin(path, "abcd", pinned); err != nil {
+		t.Fatalf("unexpected error verifying pinned key: %s", err)
+	}
+
+	if err := VerifyPin(path, "abcd", other); !errors.Is(err, ErrPinMismatch) {
+		t.Fatalf("expecting error '%s'; got %v", ErrPinMismatch, err)
+	}
+
+	if err := VerifyPin(path, "ef01", other); err != nil {
+		t.Fatalf("unexpected error pinning a second chain: %s", err)
+	}
+}
+
+func Test_ParseLocalTime(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatalf("load location: %s", err)
+	}
+
+	got, err := parseLocalTime("2025-12-25 09:00", "Europe/Paris")
+	if err != nil {
+		t.Fatalf("unexpected parse error: %s", err)
+	}
+
+	expected := time.Date(2025, 12, 25, 9, 0, 0, 0, paris)
+	if !got.Equal(expected) {
+		t.Fatalf("expecting time %s; got %s", expected, got)
+	}
+
+	if got.UTC().Hour() != 8 {
+		t.Fatalf("expecting 08:00 UTC; got %s", got.UTC())
+	}
+
+	if _, err := parseLocalTime("2025-12-25 09:00", "Mars/Olympus"); err == nil {
+		t.Fatal("expecting time zone error")
+	}
+
+	if _, err := parseLocalTime("Christmas morning", ""); !errors.Is(err, tlock.ErrInvalidRound) {
+		t.Fatalf("expecting error '%s'; got %v", tlock.ErrInvalidRound, err)
+	}
+}
+
+func Test_FormatRemaining(t *testing.T) {
+	tests := map[time.Duration]string{
+		90 * time.Second:                     "1m30s",
+		5 * time.Hour:                        "5h0m0s",
+		24 * time.Hour:                       "1d",
+		49*time.Hour + 1500*time.Millisecond: "2d1h0m2s",
+	}
+
+	for d, expected := range tests {
+		if got := formatRemaining(d); got != expected {
+			t.Fatalf("expecting %q for %s; got %q", expected, d, got)
+		}
+	}
+}
+
+func Test_Dispatch(t *testing.T) {
+	var received []string
+	subcommands["test"] = func(ctx context.Context, out io.Writer, args []string) error {
+		received = args
+		return nil
+	}
+	defer delete(subcommands, "test")
+
+	handled, err := Dispatch(context.Background(), io.Discard, []string{"--json", "test", "file.tle"})
+	if !handled || err != nil {
+		t.Fatalf("expecting subcommand to be handled; got %t, %v", handled, err)
+	}
+
+	if len(received) != 2 || received[0] != "--json" || received[1] != "file.tle" {
+		t.Fatalf("expecting global flags to be forwarded; got %v", received)
+	}
+
+	if handled, _ := Dispatch(context.Background(), io.Discard, []string{"-d", "file.tle"}); handled {
+		t.Fatal("expecting flags to not be handled as a subcommand")
+	}
+}
+
+func Test_EndpointsFor(t *testing.T) {
+	const custom = "http://localhost:8080/"
+
+	hosts := endpointsFor([]string{custom}, defaultChain)
+	expected := []string{custom, "https://pl-us.testnet.drand.sh/", "https://testnet0-api.drand.cloudflare.com/"}
+	if strings.Join(hosts, " ") != strings.Join(expected, " ") {
+		t.Fatalf("expecting the testnet endpoints; got %v", hosts)
+	}
+
+	hosts = endpointsFor([]string{"https://api.drand.sh/"}, registry[0].ChainHash)
+	if len(hosts) != 2 || hosts[1] != "https://drand.cloudflare.com/" {
+		t.Fatalf("expecting the configured endpoint once; got %v", hosts)
+	}
+
+	hosts = endpointsFor([]string{custom}, "unknown")
+	if len(hosts) != 5 {
+		t.Fatalf("expecting every known endpoint for an unknown chain; got %v", hosts)
+	}
+
+	const hint = "https://hint.example.com/"
+
+	hosts = endpointsFor([]string{custom}, defaultChain, hint)
+	if len(hosts) != 4 || hosts[3] != hint {
+		t.Fatalf("expecting the hint after the registry endpoints; got %v", hosts)
+	}
+
+	hosts = endpointsFor([]string{custom}, "unknown", hint, custom)
+	if len(hosts) != 6 || hosts[1] != hint {
+		t.Fatalf("expecting the hint before the other known endpoints; got %v", hosts)
+	}
+}
+
+func Test_ProbeNetworks(t *testing.T) {
+	chain := fakenet.NewChain(3 * time.Second)
+	handler := fakenet.Handler(chain)
+
+	missing := httptest.NewServer(nethttp.NotFoundHandler())
+	defer missing.Close()
+
+	lagging := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if strings.Contains(r.URL.Path, "/public/") {
+			nethttp.NotFound(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer lagging.Close()
+
+	current := httptest.NewServer(handler)
+	defer current.Close()
+
+	log := NewLogger(io.Discard, LevelQuiet)
+	hosts := []string{missing.URL, lagging.URL, current.URL}
+	roundNumber := chain.RoundNumber(time.Now())
+
+	network, err := ProbeNetworks(context.Background(), log, hosts, "", chain.ChainHash(), 0)
+	if err != nil {
+		t.Fatalf("probe error %s", err)
+	}
+	if _, err := network.Signature(roundNumber); err == nil {
+		t.Fatal("expecting the first endpoint serving the chain without a round")
+	}
+
+	network, err = ProbeNetworks(context.Background(), log, hosts, "", chain.ChainHash(), roundNumber)
+	if err != nil {
+		t.Fatalf("probe error %s", err)
+	}
+	if _, err := network.Signature(roundNumber); err != nil {
+		t.Fatalf("expecting the endpoint serving the round; got %s", err)
+	}
+
+	if _, err := ProbeNetworks(context.Background(), log, hosts[:1], "", chain.ChainHash(), 0); err == nil {
+		t.Fatal("expecting an error when no endpoint serves the chain")
+	}
+}
+
+func Test_NetworksArePinned(t *testing.T) {
+	chain := fakenet.NewChain(3 * time.Second)
+	srv := httptest.NewServer(fakenet.Handler(chain))
+	defer srv.Close()
+
+	// The pin file records another public key for the chain.
+	pinFile := filepath.Join(t.TempDir(), "known_chains")
+	other := fakenet.NewChain(3 * time.Second)
+	if err := VerifyPin(pinFile, chain.ChainHash(), other.PublicKey()); err != nil {
+		t.Fatalf("pin error %s", err)
+	}
+
+	ctx := context.Background()
+	log := NewLogger(io.Discard, LevelQuiet)
+
+	if _, err := ProbeNetworks(ctx, log, []string{srv.URL}, pinFile, chain.ChainHash(), 0); !errors.Is(err, ErrPinMismatch) {
+		t.Fatalf("expecting error %v; got %v", ErrPinMismatch, err)
+	}
+
+	// The fallback of an endpoint that doesn't serve the round yet is checked
+	// too.
+	if _, err := ProbeNetworks(ctx, log, []string{srv.URL, srv.URL}, pinFile, chain.ChainHash(), math.MaxUint32); !errors.Is(err, ErrPinMismatch) {
+		t.Fatalf("expecting error %v; got %v", ErrPinMismatch, err)
+	}
+
+	if _, err := chainInfo(ctx, log, []string{srv.URL}, pinFile, chain.ChainHash(), ""); !errors.Is(err, ErrPinMismatch) {
+		t.Fatalf("expecting error %v; got %v", ErrPinMismatch, err)
+	}
+
+	var cipherData bytes.Buffer
+	if err := tlock.New(chain).Encrypt(&cipherData, strings.NewReader("pinned"), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	input := filepath.Join(t.TempDir(), "data.tle")
+	if err := os.WriteFile(input, cipherData.Bytes(), 0600); err != nil {
+		t.Fatalf("write error %s", err)
+	}
+
+	if err := Status(ctx, io.Discard, []string{"-n", srv.URL, "--pin-file", pinFile, input}); !errors.Is(err, ErrPinMismatch) {
+		t.Fatalf("expecting error %v; got %v", ErrPinMismatch, err)
+	}
+	if err := Status(ctx, io.Discard, []string{"-n", srv.URL, "--pin-file", "", input}); err != nil {
+		t.Fatalf("status error %s", err)
+	}
+}
+
+func Test_EndpointErrors(t *testing.T) {
+	chain := fakenet.NewChain(3 * time.Second)
+
+	first := httptest.NewServer(nethttp.NotFoundHandler())
+	defer first.Close()
+
+	second := httptest.NewServer(nethttp.NotFoundHandler())
+	defer second.Close()
+
+	log := NewLogger(io.Discard, LevelQuiet)
+	_, err := ProbeNetworks(context.Background(), log, []string{first.URL, second.URL}, "", chain.ChainHash(), 0)
+	if err == nil {
+		t.Fatal("expecting an error when no endpoint serves the chain")
+	}
+
+	var endpoint *http.EndpointError
+	if !errors.As(err, &endpoint) || endpoint.Host != first.URL {
+		t.Fatalf("expecting the error of %s; got %v", first.URL, err)
+	}
+	for _, host := range []string{first.URL, second.URL} {
+		if !strings.Contains(err.Error(), host+": ") {
+			t.Fatalf("expecting the error to name %s; got %q", host, err)
+		}
+	}
+
+	failing := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if strings.Contains(r.URL.Path, "/public/") {
+			nethttp.Error(w, "unavailable", nethttp.StatusServiceUnavailable)
+			return
+		}
+		fakenet.Handler(chain).ServeHTTP(w, r)
+	}))
+	defer failing.Close()
+
+	network, err := http.NewNetwork(failing.URL, chain.ChainHash())
+	if err != nil {
+		t.Fatalf("network error %s", err)
+	}
+
+	_, err = network.Signature(chain.RoundNumber(time.Now()))
+	if !errors.As(err, &endpoint) || endpoint.Host != failing.URL {
+		t.Fatalf("expecting the error of %s; got %v", failing.URL, err)
+	}
+}
+
+func Test_IsAvailable(t *testing.T) {
+	chain := fakenet.NewChain(3 * time.Second)
+	handler := fakenet.Handler(chain)
+
+	var methods []string
+	getOnly := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		methods = append(methods, r.Method)
+		if r.Method != nethttp.MethodGet {
+			nethttp.Error(w, "method not allowed", nethttp.StatusMethodNotAllowed)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer getOnly.Close()
+
+	failing := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if strings.Contains(r.URL.Path, "/public/") {
+			nethttp.Error(w, "unavailable", nethttp.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer failing.Close()
+
+	current := chain.RoundNumber(time.Now())
+
+	network, err := http.NewNetwork(getOnly.URL, chain.ChainHash())
+	if err != nil {
+		t.Fatalf("network error %s", err)
+	}
+	methods = nil
+
+	available, at, err := network.IsAvailable(current)
+	if err != nil || !available {
+		t.Fatalf("expecting round %d to be available; got %v, %v", current, available, err)
+	}
+	if !at.Equal(network.RoundTime(current)) {
+		t.Fatalf("expecting round time %s; got %s", network.RoundTime(current), at)
+	}
+	if expected := []string{nethttp.MethodHead, nethttp.MethodGet}; strings.Join(methods, " ") != strings.Join(expected, " ") {
+		t.Fatalf("expecting a HEAD request falling back to GET; got %v", methods)
+	}
+
+	available, _, err = network.IsAvailable(current + 100)
+	if err != nil || available {
+		t.Fatalf("expecting a future round to be unavailable without error; got %v, %v", available, err)
+	}
+
+	network, err = http.NewNetwork(failing.URL, chain.ChainHash())
+	if err != nil {
+		t.Fatalf("network error %s", err)
+	}
+	if _, _, err := network.IsAvailable(current); err == nil {
+		t.Fatal("expecting an error for an unexpected status")
+	}
+}
+
+func Test_Logger(t *testing.T) {
 	type test struct {
-		name     string
-		duration string
-		date     time.Time
-		expected time.Duration
-		err      error
+		level    Level
+		expected string
 	}
 
 	tests := []test{
-		{name: "parseDay", duration: "1d", date: time.Now(), expected: 24 * time.Hour, err: nil},
-		{name: "parseMonth", duration: "1M", date: time.Date(2022, 01, 01, 0, 0, 0, 0, time.UTC), expected: time.Duration(31*24) * time.Hour, err: nil},
-		{name: "parseYear", duration: "1y", date: time.Date(2022, 01, 01, 0, 0, 0, 0, time.UTC), expected: time.Duration(365*24) * time.Hour, err: nil},
-		{name: "parseInvalid", duration: "1C", date: time.Now(), expected: time.Second, err: ErrInvalidDuration},
+		{level: LevelQuiet, expected: "error: e\n"},
+		{level: LevelInfo, expected: "error: e\ni\n"},
+		{level: LevelDebug, expected: "error: e\ni\ndebug: d\n"},
+	}
+
+	for _, tc := range tests {
+		var out strings.Builder
+		log := NewLogger(&out, tc.level)
+		log.Errorf("e")
+		log.Infof("i")
+		log.Debugf("d")
+
+		if out.String() != tc.expected {
+			t.Fatalf("expecting output %q at level %d; got %q", tc.expected, tc.level, out.String())
+		}
+	}
+}
+
+func Test_Output(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.tle")
+
+	out, err := CreateOutput(path)
+	if err != nil {
+		t.Fatalf("create output: %s", err)
+	}
+	if _, err := out.Write([]byte("aborted")); err != nil {
+		t.Fatalf("write output: %s", err)
+	}
+	out.Abort()
+
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expecting no output after abort; got %v", err)
+	}
+
+	out, err = CreateOutput(path)
+	if err != nil {
+		t.Fatalf("create output: %s", err)
+	}
+	if _, err := out.Write([]byte("committed")); err != nil {
+		t.Fatalf("write output: %s", err)
+	}
+	if err := out.Commit(); err != nil {
+		t.Fatalf("commit output: %s", err)
+	}
+	out.Abort()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read output: %s", err)
+	}
+	if string(b) != "committed" {
+		t.Fatalf("expecting committed content; got %q", b)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expecting temporary files to be cleaned up; got %d entries", len(entries))
+	}
+}
+
+func Test_CreateNewOutput(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "report.pdf")
+
+	out, err := CreateNewOutput(path)
+	if err != nil {
+		t.Fatalf("create output: %s", err)
+	}
+	defer out.Abort()
+
+	if err := os.WriteFile(path, []byte("existing"), 0600); err != nil {
+		t.Fatalf("write file: %s", err)
+	}
+
+	if err := out.Commit(); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("expecting the existing file to be kept; got %v", err)
+	}
+	if _, err := CreateNewOutput(path); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("expecting an error for an existing file; got %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil || string(b) != "existing" {
+		t.Fatalf("expecting the existing content; got %q, %v", b, err)
+	}
+}
+
+func Test_DefaultOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		flags    Flags
+		expected string
+	}{
+		{name: "encrypt", flags: Flags{Input: "report.pdf"}, expected: "report.pdf.tle"},
+		{name: "decrypt", flags: Flags{Decrypt: true, Input: "docs/report.pdf.tle"}, expected: "docs/report.pdf"},
+		{name: "decryptWithoutExtension", flags: Flags{Decrypt: true, Input: "report.age"}},
+		{name: "decryptExtensionOnly", flags: Flags{Decrypt: true, Input: "docs/.tle"}},
+		{name: "stdin", flags: Flags{Input: "-"}},
+		{name: "url", flags: Flags{Decrypt: true, Input: "https://example.com/report.pdf.tle"}},
+		{name: "records", flags: Flags{Decrypt: true, Input: "events.tle", Records: "lines"}},
+		{name: "output", flags: Flags{Input: "report.pdf", Output: "other.tle"}},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			duration, err := parseDuration(tc.date, tc.duration)
-			if tc.err == nil && err != nil {
-				t.Fatalf("unexpected parse error: %s", err)
+			if output := DefaultOutput(tc.flags); output != tc.expected {
+				t.Fatalf("expecting %q; got %q", tc.expected, output)
 			}
+		})
+	}
+}
 
-			if tc.err != nil && tc.err != err {
-				t.Fatalf("expecting parsing error '%s'; got %v", ErrInvalidDuration, err)
-			}
+func Test_OpenInputURL(t *testing.T) {
+	srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.URL.Path != "/file.tle" {
+			nethttp.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("remote"))
+	}))
+	defer srv.Close()
 
-			if duration != tc.expected {
-				t.Fatalf("expecting duration %s; go %s", tc.expected, duration)
-			}
+	in, err := OpenInput(context.Background(), srv.URL+"/file.tle")
+	if err != nil {
+		t.Fatalf("open input: %s", err)
+	}
+	defer in.Close()
 
-		})
+	b, err := io.ReadAll(in)
+	if err != nil {
+		t.Fatalf("read input: %s", err)
+	}
+	if string(b) != "remote" {
+		t.Fatalf("expecting remote content; got %q", b)
+	}
+
+	if _, err := OpenInput(context.Background(), srv.URL+"/missing.tle"); err == nil {
+		t.Fatal("expecting error for missing remote input")
+	}
+}
+
+func Test_RemoveInput(t *testing.T) {
+	for _, shred := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "data.txt")
+		if err := os.WriteFile(path, []byte("secret"), 0600); err != nil {
+			t.Fatalf("write input: %s", err)
+		}
+
+		if err := RemoveInput(path, shred); err != nil {
+			t.Fatalf("remove input (shred %t): %s", shred, err)
+		}
+
+		if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expecting input to be removed (shred %t); got %v", shred, err)
+		}
+	}
+}
+
+// fakeClock moves forward by the requested duration whenever it is waited
+// on, so waiting takes no real time.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// delayedNetwork serves signatures once the clock passed the round time plus
+// a publication delay.
+type delayedNetwork struct {
+	clock     *fakeClock
+	roundTime time.Time
+	delay     time.Duration
+	requests  int
+}
+
+func (n *delayedNetwork) RoundTime(roundNumber uint64) time.Time {
+	return n.roundTime
+}
+
+func (n *delayedNetwork) IsAvailableContext(ctx context.Context, roundNumber uint64) (bool, time.Time, error) {
+	n.requests++
+	return !n.clock.Now().Before(n.roundTime.Add(n.delay)), n.roundTime, nil
+}
+
+func Test_WaitForRound(t *testing.T) {
+	start := time.Date(2022, 01, 01, 0, 0, 0, 0, time.UTC)
+	clock := fakeClock{now: start}
+	network := delayedNetwork{
+		clock:     &clock,
+		roundTime: start.Add(90 * 24 * time.Hour),
+		delay:     2500 * time.Millisecond,
+	}
+
+	log := NewLogger(io.Discard, LevelDebug)
+	if err := WaitForRound(context.Background(), log, &clock, &network, 1234); err != nil {
+		t.Fatalf("wait error %s", err)
+	}
+
+	if expected := network.roundTime.Add(3 * time.Second); !clock.now.Equal(expected) {
+		t.Fatalf("expecting the wait to end at %s; got %s", expected, clock.now)
+	}
+
+	if network.requests != 4 {
+		t.Fatalf("expecting 4 availability requests; got %d", network.requests)
+	}
+}
+
+func Test_WaitForRoundCanceled(t *testing.T) {
+	clock := fakeClock{now: time.Now()}
+	network := delayedNetwork{
+		clock:     &clock,
+		roundTime: clock.now,
+		delay:     time.Hour,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	log := NewLogger(io.Discard, LevelQuiet)
+	if err := WaitForRound(ctx, log, &clock, &network, 1234); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expecting canceled error; got %v", err)
+	}
+}
+
+func Test_Stats(t *testing.T) {
+	clock := fakeClock{now: time.Date(2022, 01, 01, 0, 0, 0, 0, time.UTC)}
+
+	var dst strings.Builder
+	stats, in, out := NewStats(&clock, strings.NewReader(strings.Repeat("a", 2048)), &dst)
+	if _, err := io.Copy(out, io.MultiReader(in, strings.NewReader(strings.Repeat("b", 100)))); err != nil {
+		t.Fatalf("copy error %s", err)
+	}
+	clock.now = clock.now.Add(2 * time.Second)
+
+	var report strings.Builder
+	if err := stats.Write(&report, false, false); err != nil {
+		t.Fatalf("write error %s", err)
+	}
+
+	expected := []string{
+		"input:      2.0 KiB",
+		"output:     2.1 KiB",
+		"overhead:   100 B",
+		"elapsed:    2s",
+		"throughput: 1.0 KiB/s",
+	}
+	for _, line := range expected {
+		if !strings.Contains(report.String(), line+"\n") {
+			t.Fatalf("expecting %q in report:\n%s", line, report.String())
+		}
+	}
+}
+
+func Test_FormatSize(t *testing.T) {
+	tests := map[int64]string{
+		0:                      "0 B",
+		1023:                   "1023 B",
+		1536:                   "1.5 KiB",
+		-2048:                  "-2.0 KiB",
+		5 * 1024 * 1024 * 1024: "5.0 GiB",
+	}
+
+	for n, expected := range tests {
+		if got := formatSize(n); got != expected {
+			t.Fatalf("formatSize(%d): expecting %q; got %q", n, expected, got)
+		}
+	}
+}
+
+func Test_ReadManifest(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.yaml")
+
+	manifest := "entries:\n  - input: data.txt\n    output: out/data.tle\n  - input: https://example.com/data.tle\n    output: " + filepath.Join(dir, "data.txt") + "\n    decrypt: true\n"
+	if err := os.WriteFile(path, []byte(manifest), 0600); err != nil {
+		t.Fatalf("write error %s", err)
+	}
+
+	m, err := ReadManifest(path)
+	if err != nil {
+		t.Fatalf("read manifest error %s", err)
+	}
+
+	if m.Entries[0].Input != filepath.Join(dir, "data.txt") || m.Entries[0].Output != filepath.Join(dir, "out", "data.tle") {
+		t.Fatalf("expecting paths relative to the manifest; got %+v", m.Entries[0])
+	}
+	if m.Entries[1].Input != "https://example.com/data.tle" {
+		t.Fatalf("expecting URLs to be kept; got %s", m.Entries[1].Input)
+	}
+
+	if err := os.WriteFile(path, []byte("entries:\n  - input: a\n    output: b\n    delay: 1d\n"), 0600); err != nil {
+		t.Fatalf("write error %s", err)
+	}
+	if _, err := ReadManifest(path); err == nil {
+		t.Fatal("expecting unknown fields to be rejected")
+	}
+}
+
+func Test_ExtractTarOutside(t *testing.T) {
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	tw.WriteHeader(&tar.Header{Name: "../escape.txt", Mode: 0600, Size: 4, Typeflag: tar.TypeReg})
+	tw.Write([]byte("data"))
+	tw.Close()
+
+	dir := t.TempDir()
+	if err := extractTar(filepath.Join(dir, "tree"), &archive, false); err == nil || !strings.Contains(err.Error(), "outside of the directory") {
+		t.Fatalf("expecting entries outside of the directory to be rejected; got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "escape.txt")); err == nil {
+		t.Fatal("expecting no file outside of the directory")
+	}
+}
+
+func Test_Progress(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "data.txt")
+	path := filepath.Join(dir, "data.tle")
+	clock := fakeClock{now: time.Now()}
+
+	if err := os.WriteFile(input, []byte("plain data"), 0600); err != nil {
+		t.Fatalf("write input: %s", err)
+	}
+
+	out, progress, err := OpenProgress(path, input, false, &clock)
+	if err != nil {
+		t.Fatalf("open progress: %s", err)
+	}
+	if progress.Checkpoint() != nil {
+		t.Fatal("expecting no checkpoint for a new operation")
+	}
+
+	out.Write([]byte("saved data"))
+	if err := progress.Save(tlock.Checkpoint{Chunks: 1, Read: 5, Written: 5, Key: []byte("key")}); err != nil {
+		t.Fatalf("save progress: %s", err)
+	}
+
+	// Checkpoints are only saved once per interval.
+	out.Write([]byte(" and more"))
+	if err := progress.Save(tlock.Checkpoint{Chunks: 2, Read: 10, Written: 19, Key: []byte("key")}); err != nil {
+		t.Fatalf("save progress: %s", err)
+	}
+	out.Abort()
+
+	out, progress, err = OpenProgress(path, input, false, &clock)
+	if err != nil {
+		t.Fatalf("reopen progress: %s", err)
+	}
+	if cp := progress.Checkpoint(); cp == nil || cp.Chunks != 1 {
+		t.Fatalf("expecting the first checkpoint; got %+v", cp)
+	}
+	out.Write([]byte("-resumed"))
+	out.Abort()
+
+	b, err := os.ReadFile(path + partialSuffix)
+	if err != nil {
+		t.Fatalf("read partial output: %s", err)
+	}
+	if string(b) != "saved-resumed" {
+		t.Fatalf("expecting the output to resume from the checkpoint; got %q", b)
+	}
+
+	if _, _, err := OpenProgress(path, input, true, &clock); err == nil {
+		t.Fatal("expecting an error for another operation")
+	}
+
+	if err := os.WriteFile(input, []byte("changed data"), 0600); err != nil {
+		t.Fatalf("write input: %s", err)
+	}
+	if _, _, err := OpenProgress(path, input, false, &clock); err == nil {
+		t.Fatal("expecting an error for a changed input")
+	}
+}
+
+func Test_Audit(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.tle")
+	network := fakenet.NewChain(3 * time.Second)
+
+	var encrypted bytes.Buffer
+	digest := NewDigest()
+	if err := tlock.New(network).Encrypt(digest.Writer(&encrypted), strings.NewReader("audited data"), 42); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	if err := os.WriteFile(path, encrypted.Bytes(), 0600); err != nil {
+		t.Fatalf("write encrypted data: %s", err)
+	}
+
+	sum, header, err := DigestFile(path)
+	if err != nil {
+		t.Fatalf("digest file: %s", err)
+	}
+	if sum != digest.Sum() || header.RoundNumber != 42 || header.ChainHash != network.ChainHash() {
+		t.Fatalf("unexpected digest %s and header %+v; expecting %s", sum, header, digest.Sum())
+	}
+
+	log := filepath.Join(dir, "audit", "log.jsonl")
+	for _, op := range []string{"encrypt", "decrypt"} {
+		if err := AppendAudit(log, AuditRecord{Operation: op, SHA256: sum, Round: 42}); err != nil {
+			t.Fatalf("append audit: %s", err)
+		}
+	}
+
+	b, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatalf("read audit log: %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expecting 2 records; got %q", b)
+	}
+	for i, op := range []string{"encrypt", "decrypt"} {
+		var r AuditRecord
+		if err := json.Unmarshal([]byte(lines[i]), &r); err != nil || r.Operation != op || r.SHA256 != sum {
+			t.Fatalf("unexpected record %q: %v", lines[i], err)
+		}
+	}
+}
+
+func Test_Capsule(t *testing.T) {
+	dir := t.TempDir()
+	network := fakenet.NewChain(3 * time.Second)
+
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %s", err)
+	}
+
+	var capsule bytes.Buffer
+	cw := NewCapsuleWriter(&capsule, key, network.ChainHash(), time.Now())
+	for i, name := range []string{"first.txt", "second.txt"} {
+		var encrypted bytes.Buffer
+		if err := tlock.New(network).Encrypt(&encrypted, strings.NewReader(name), uint64(i+1)); err != nil {
+			t.Fatalf("encrypt error %s", err)
+		}
+		if err := cw.Add(name, uint64(i+1), &encrypted, int64(encrypted.Len())); err != nil {
+			t.Fatalf("add error %s", err)
+		}
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatalf("close error %s", err)
+	}
+
+	signer := hex.EncodeToString(key.Public().(ed25519.PublicKey))
+
+	path := filepath.Join(dir, "capsule.tar")
+	if err := os.WriteFile(path, capsule.Bytes(), 0600); err != nil {
+		t.Fatalf("write capsule: %s", err)
+	}
+
+	m, err := ReadCapsule(path, signer)
+	if err != nil {
+		t.Fatalf("read capsule: %s", err)
+	}
+	if m.ChainHash != network.ChainHash() || len(m.Files) != 2 || m.Files[1].Name != "second.txt" || m.Files[1].Round != 2 {
+		t.Fatalf("unexpected manifest %+v", m)
+	}
+
+	if _, err := ReadCapsule(path, strings.Repeat("00", ed25519.PublicKeySize)); err == nil {
+		t.Fatalf("expecting an error for another signer")
+	}
+
+	tests := map[string]func(name string, data []byte) []byte{
+		"file": func(name string, data []byte) []byte {
+			if name == capsuleFiles+"first.txt" {
+				data[len(data)-1] ^= 1
+			}
+			return data
+		},
+		"manifest": func(name string, data []byte) []byte {
+			if name == capsuleManifest {
+				return bytes.Replace(data, []byte(`"round": 2`), []byte(`"round": 1`), 1)
+			}
+			return data
+		},
+	}
+
+	for name, tamper := range tests {
+		t.Run(name, func(t *testing.T) {
+			var tampered bytes.Buffer
+			tr := tar.NewReader(bytes.NewReader(capsule.Bytes()))
+			tw := tar.NewWriter(&tampered)
+			for {
+				hdr, err := tr.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatalf("read capsule: %s", err)
+				}
+				data, err := io.ReadAll(tr)
+				if err != nil {
+					t.Fatalf("read capsule: %s", err)
+				}
+				data = tamper(hdr.Name, data)
+				hdr.Size = int64(len(data))
+				if err := tw.WriteHeader(hdr); err != nil {
+					t.Fatalf("write capsule: %s", err)
+				}
+				if _, err := tw.Write(data); err != nil {
+					t.Fatalf("write capsule: %s", err)
+				}
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatalf("write capsule: %s", err)
+			}
+
+			path := filepath.Join(dir, name+".tar")
+			if err := os.WriteFile(path, tampered.Bytes(), 0600); err != nil {
+				t.Fatalf("write capsule: %s", err)
+			}
+
+			if _, err := ReadCapsule(path, ""); err == nil {
+				t.Fatalf("expecting an error for a tampered capsule")
+			}
+		})
+	}
+}
+
+func Test_Receipt(t *testing.T) {
+	chain := fakenet.NewChain(3 * time.Second)
+	srv := httptest.NewServer(fakenet.Handler(chain))
+	defer srv.Close()
+
+	network, err := http.NewNetwork(srv.URL, chain.ChainHash())
+	if err != nil {
+		t.Fatalf("network error %s", err)
+	}
+
+	key, err := SigningKey("")
+	if err != nil {
+		t.Fatalf("signing key: %s", err)
+	}
+
+	r, err := NewReceipt(context.Background(), SystemClock{}, network, "digest", 100, key)
+	if err != nil {
+		t.Fatalf("receipt error %s", err)
+	}
+
+	if _, err := r.Verify(network.Info(), r.PublicKey); err != nil {
+		t.Fatalf("verify error %s", err)
+	}
+
+	tests := map[string]func(r *NotaryReceipt){
+		"digest": func(r *NotaryReceipt) { r.SHA256 = "other" },
+		"round":  func(r *NotaryReceipt) { r.Round++ },
+		"creation": func(r *NotaryReceipt) {
+			// The receipt is signed again, so only the beacon is invalid.
+			signature, err := chain.Signature(r.CreationRound - 1)
+			if err != nil {
+				t.Fatalf("signature error %s", err)
+			}
+			r.CreationSignature = hex.EncodeToString(signature)
+			r.Signature = ""
+			msg, err := json.Marshal(r)
+			if err != nil {
+				t.Fatalf("marshal error %s", err)
+			}
+			r.Signature = hex.EncodeToString(ed25519.Sign(key, msg))
+		},
+	}
+
+	for name, tamper := range tests {
+		t.Run(name, func(t *testing.T) {
+			tampered := r
+			tamper(&tampered)
+			if _, err := tampered.Verify(network.Info(), ""); err == nil {
+				t.Fatal("expecting an error for a tampered receipt")
+			}
+		})
+	}
+}
+
+func Test_Vault(t *testing.T) {
+	chain := fakenet.NewChain(3 * time.Second)
+	chain.Unlock()
+	srv := httptest.NewServer(fakenet.Handler(chain))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	blob, other := filepath.Join(dir, "bid.tle"), filepath.Join(dir, "other.tle")
+	for _, name := range []string{blob, other} {
+		var cipherData bytes.Buffer
+		if err := tlock.New(chain).Encrypt(&cipherData, strings.NewReader(name), 100); err != nil {
+			t.Fatalf("encrypt error %s", err)
+		}
+		if err := os.WriteFile(name, cipherData.Bytes(), 0600); err != nil {
+			t.Fatalf("write error %s", err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := Vault(context.Background(), &out, []string{"--json", "register", blob}); err != nil {
+		t.Fatalf("register error %s", err)
+	}
+
+	var r registration
+	if err := json.Unmarshal(out.Bytes(), &r); err != nil {
+		t.Fatalf("decode error %s", err)
+	}
+	if r.RoundNumber != 100 || r.ChainHash != chain.ChainHash() || !strings.HasPrefix(r.Calldata, "0x") {
+		t.Fatalf("unexpected registration %+v", r)
+	}
+
+	f, err := os.Open(blob)
+	if err != nil {
+		t.Fatalf("open error %s", err)
+	}
+	defer f.Close()
+	c, err := evm.Commit(f)
+	if err != nil {
+		t.Fatalf("commit error %s", err)
+	}
+
+	reveal := func(roundNumber uint64) string {
+		signature, err := chain.Signature(roundNumber)
+		if err != nil {
+			t.Fatalf("signature error %s", err)
+		}
+		return hex.EncodeToString(evm.Reveal{Commitment: c, Signature: signature}.Calldata())
+	}
+
+	verify := func(args ...string) error {
+		args = append([]string{"verify", "-n", srv.URL, "--pin-file", filepath.Join(dir, "known_chains")}, args...)
+		return Vault(context.Background(), io.Discard, args)
+	}
+
+	if err := verify(reveal(100), blob); err != nil {
+		t.Fatalf("verify error %s", err)
+	}
+	if err := verify(reveal(101)); err == nil {
+		t.Fatal("expecting an error for the signature of another round")
+	}
+	if err := verify(reveal(100), other); err == nil || !strings.Contains(err.Error(), "isn't the blob revealed") {
+		t.Fatalf("expecting an error for another blob; got %v", err)
+	}
+	if err := verify(r.Calldata); !errors.Is(err, evm.ErrWrongMethod) {
+		t.Fatalf("expecting error %s; got %v", evm.ErrWrongMethod, err)
+	}
+}
+
+func Test_Records(t *testing.T) {
+	live := fakenet.NewChain(3 * time.Second)
+	open := fakenet.NewChain(3 * time.Second)
+	open.Unlock()
+	srv := httptest.NewServer(fakenet.Handler(live, open))
+	defer srv.Close()
+
+	log := NewLogger(io.Discard, LevelQuiet)
+	records := [][]byte{[]byte("first"), {}, {0, '\n', 0xff}}
+
+	var src bytes.Buffer
+	for _, r := range records {
+		if err := writeRecord(&src, RecordBinary, r); err != nil {
+			t.Fatalf("write record: %s", err)
+		}
+	}
+
+	for name, chain := range map[string]*fakenet.Chain{"open": open, "live": live} {
+		t.Run(name, func(t *testing.T) {
+			network, err := http.NewNetwork(srv.URL, chain.ChainHash())
+			if err != nil {
+				t.Fatalf("network error %s", err)
+			}
+
+			flags := Flags{Records: RecordBinary, Round: "100", Duration: defaultDuration}
+
+			var encrypted bytes.Buffer
+			if _, err := EncryptRecords(context.Background(), log, SystemClock{}, flags, &encrypted, bytes.NewReader(src.Bytes()), network); err != nil {
+				t.Fatalf("encrypt error %s", err)
+			}
+
+			var decrypted bytes.Buffer
+			if err := DecryptRecords(context.Background(), log, SystemClock{}, flags, &decrypted, &encrypted, network); err != nil {
+				t.Fatalf("decrypt error %s", err)
+			}
+
+			// Records that are still locked are skipped.
+			want := src.Bytes()
+			if chain == live {
+				want = nil
+			}
+			if !bytes.Equal(decrypted.Bytes(), want) {
+				t.Fatalf("expecting %x, got %x", want, decrypted.Bytes())
+			}
+		})
+	}
+
+	r := recordReader{format: RecordBinary, src: bufio.NewReader(bytes.NewReader([]byte{0, 0, 0, 5, 'a'}))}
+	if _, err := r.next(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expecting a truncated record, got %v", err)
+	}
+}
+
+func Test_PushPull(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	resolve := func(ctx context.Context, chainHash string) (relay.RoundTimer, error) {
+		return network, nil
+	}
+
+	s, err := relay.NewServer(relay.Dir(t.TempDir()), resolve)
+	if err != nil {
+		t.Fatalf("server error %s", err)
+	}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	input := filepath.Join(t.TempDir(), "data.tle")
+	var encrypted bytes.Buffer
+	if err := tlock.New(network).Encrypt(&encrypted, strings.NewReader("data"), network.RoundNumber(time.Now())-1); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	if err := os.WriteFile(input, encrypted.Bytes(), 0600); err != nil {
+		t.Fatalf("write error %s", err)
+	}
+
+	// A leftover upload that no longer exists has to be started over.
+	if err := os.WriteFile(input+".upload", []byte("00000000000000000000000000000000\n"), 0600); err != nil {
+		t.Fatalf("write error %s", err)
+	}
+
+	ctx := context.Background()
+	args := []string{"--relay", srv.URL, "--token", "secret", "-q"}
+
+	if err := Push(ctx, io.Discard, append(args, input)); !errors.Is(err, relay.ErrUploadNotFound) {
+		t.Fatalf("expecting error %s; got %v", relay.ErrUploadNotFound, err)
+	}
+
+	var out bytes.Buffer
+	if err := Push(ctx, &out, append(args, input)); err != nil {
+		t.Fatalf("push error %s", err)
+	}
+	if _, err := os.Stat(input + ".upload"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expecting the upload state to be removed; got %v", err)
+	}
+
+	output := filepath.Join(t.TempDir(), "pulled.tle")
+	if err := Pull(ctx, io.Discard, append(args, "-o", output, strings.TrimSpace(out.String()))); err != nil {
+		t.Fatalf("pull error %s", err)
+	}
+
+	b, err := os.ReadFile(output)
+	if err != nil || !bytes.Equal(b, encrypted.Bytes()) {
+		t.Fatalf("expecting the pushed data; got %v", err)
+	}
+
+	out.Reset()
+	if err := Pull(ctx, &out, append(args, "--json", "--list")); err != nil {
+		t.Fatalf("list error %s", err)
+	}
+	if strings.TrimSpace(out.String()) != "[]" {
+		t.Fatalf("expecting no pending items; got %s", out.String())
+	}
+}
+
+func Test_LoadKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := map[string]struct {
+		yaml  string
+		valid bool
+	}{
+		"keys":       {"- name: a\n  token: x\n  rate_limit: 60\n- token: y\n  quota: 100\n", true},
+		"no token":   {"- name: a\n", false},
+		"reused":     {"- token: x\n- token: x\n", false},
+		"negative":   {"- token: x\n  max_size: -1\n", false},
+		"unknown":    {"- token: x\n  limit: 1\n", false},
+		"not a list": {"token: x\n", false},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name+".yaml")
+			if err := os.WriteFile(path, []byte(tt.yaml), 0600); err != nil {
+				t.Fatalf("write error %s", err)
+			}
+
+			keys, err := LoadKeys(path)
+			if tt.valid != (err == nil) {
+				t.Fatalf("expecting valid %v; got %v", tt.valid, err)
+			}
+			if tt.valid && (len(keys) != 2 || keys[1].Name != "2" || keys[0].RateLimit != 60) {
+				t.Fatalf("unexpected keys %+v", keys)
+			}
+		})
+	}
+}
+
+func Test_RelayMetrics(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	s, err := relay.NewServer(relay.Dir(t.TempDir()), func(ctx context.Context, chainHash string) (relay.RoundTimer, error) {
+		return network, nil
+	})
+	if err != nil {
+		t.Fatalf("server error %s", err)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(relayMetrics(s)...)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather error %s", err)
+	}
+
+	names := make(map[string]bool)
+	for _, f := range families {
+		names[f.GetName()] = true
+	}
+	for _, name := range []string{"tle_relay_items", "tle_relay_pending_items", "tle_relay_throttled_requests_total"} {
+		if !names[name] {
+			t.Fatalf("expecting metric %s; got %v", name, names)
+		}
+	}
+}
+
+func Test_IsLoopback(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1:8080": true,
+		"localhost:8080": true,
+		"[::1]:8080":     true,
+		":8080":          false,
+		"0.0.0.0:8080":   false,
+		"10.0.0.1:8080":  false,
+		"example.org:25": false,
+		"127.0.0.1":      false,
+	}
+
+	for addr, loopback := range tests {
+		if got := isLoopback(addr); got != loopback {
+			t.Errorf("isLoopback(%q) = %v; expecting %v", addr, got, loopback)
+		}
+	}
+}
+
+func Test_MailRequiresKeys(t *testing.T) {
+	args := []string{"--listen", ":8080", "--smtp", "localhost:25", "--from", "tlock@example.org", "memory:"}
+	err := Mail(context.Background(), io.Discard, args)
+	if err == nil || !strings.Contains(err.Error(), "requires --keys") {
+		t.Fatalf("expecting mail to refuse listening on all interfaces without keys; got %v", err)
+	}
+}
+
+func Test_OpenStateStorage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "state.key")
+	if err := os.WriteFile(keyFile, bytes.Repeat([]byte{7}, 32), 0600); err != nil {
+		t.Fatalf("write error %s", err)
+	}
+
+	storage, err := openStateStorage(filepath.Join(dir, "state"), keyFile)
+	if err != nil {
+		t.Fatalf("open error %s", err)
+	}
+	if err := storage.Put(ctx, "outbox/1", strings.NewReader("the plain text")); err != nil {
+		t.Fatalf("put error %s", err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "state", "outbox", "1"))
+	if err != nil {
+		t.Fatalf("read error %s", err)
+	}
+	if bytes.Contains(b, []byte("plain text")) {
+		t.Fatal("expecting the state to be encrypted on disk")
+	}
+
+	// The same key reads the state back after a restart.
+	storage, err = openStateStorage(filepath.Join(dir, "state"), keyFile)
+	if err != nil {
+		t.Fatalf("open error %s", err)
+	}
+	obj, err := storage.Get(ctx, "outbox/1")
+	if err != nil {
+		t.Fatalf("get error %s", err)
+	}
+	defer obj.Close()
+	if b, err := io.ReadAll(obj); err != nil || string(b) != "the plain text" {
+		t.Fatalf("expecting the stored object; got %q, %v", b, err)
+	}
+}
+
+func Test_Chains(t *testing.T) {
+	first, second := fakenet.NewChain(3*time.Second), fakenet.NewChain(30*time.Second)
+	srv := httptest.NewServer(fakenet.Handler(first, second))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	if err := Chains(context.Background(), &out, []string{"-q", "--json", "-n", srv.URL}); err != nil {
+		t.Fatalf("chains error %s", err)
+	}
+
+	var endpoints []endpointChains
+	if err := json.Unmarshal(out.Bytes(), &endpoints); err != nil {
+		t.Fatalf("decode error %s", err)
+	}
+	if len(endpoints) != 1 || endpoints[0].Endpoint != srv.URL || len(endpoints[0].Chains) != 2 {
+		t.Fatalf("expecting the two chains of %s; got %+v", srv.URL, endpoints)
+	}
+	for i, c := range []*fakenet.Chain{first, second} {
+		got := endpoints[0].Chains[i]
+		if got.Hash != c.ChainHash() || got.Period != int64(c.Info().Period/time.Second) || !got.Supported {
+			t.Fatalf("unexpected summary of chain %d: %+v", i, got)
+		}
+	}
+
+	out.Reset()
+	if err := Chains(context.Background(), &out, []string{"-q", "-n", srv.URL}); err != nil {
+		t.Fatalf("chains error %s", err)
+	}
+	if !strings.Contains(out.String(), first.ChainHash()) || !strings.Contains(out.String(), "pedersen-bls-unchained  3s") {
+		t.Fatalf("expecting a table of the chains; got %q", out.String())
+	}
+
+	tests := map[string]struct {
+		fail string
+		err  string
+	}{
+		"listing": {fail: "/chains", err: "list chains"},
+		"info":    {fail: "/info", err: "chain info of " + first.ChainHash()},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			failing := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+				if strings.HasSuffix(r.URL.Path, tt.fail) {
+					nethttp.Error(w, "unavailable", nethttp.StatusServiceUnavailable)
+					return
+				}
+				fakenet.Handler(first).ServeHTTP(w, r)
+			}))
+			defer failing.Close()
+
+			err := Chains(context.Background(), io.Discard, []string{"-q", "-n", failing.URL})
+			var endpoint *http.EndpointError
+			if !errors.As(err, &endpoint) || endpoint.Host != failing.URL || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expecting the %s error of %s; got %v", tt.err, failing.URL, err)
+			}
+		})
+	}
+}
+
+func Test_Bot(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	srv := httptest.NewServer(fakenet.Handler(network))
+	defer srv.Close()
+
+	var armored bytes.Buffer
+	a := armor.NewWriter(&armored)
+	if err := tlock.New(network).Encrypt(a, strings.NewReader("it will rain"), 1); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	a.Close()
+	body, _ := json.Marshal(armored.String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var sent []string
+	syncs := 0
+	homeserver := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/_matrix/client/v3/account/whoami":
+			io.WriteString(w, `{"user_id":"@tlock:example.org"}`)
+
+		case r.URL.Path == "/_matrix/client/v3/sync":
+			events := ""
+			if syncs == 1 {
+				events = `{"event_id":"$1","sender":"@alice:example.org","type":"m.room.message","content":{"msgtype":"m.text","body":` + string(body) + `}}`
+			}
+			syncs++
+			io.WriteString(w, `{"next_batch":"s","rooms":{"join":{"!room:example.org":{"timeline":{"events":[`+events+`]}}}}}`)
+
+		case r.Method == nethttp.MethodPut:
+			var content struct {
+				Body string `json:"body"`
+			}
+			json.NewDecoder(r.Body).Decode(&content)
+			sent = append(sent, content.Body)
+			if strings.Contains(content.Body, "it will rain") {
+				cancel()
+			}
+			io.WriteString(w, `{"event_id":"$sent"}`)
+
+		default:
+			nethttp.NotFound(w, r)
+		}
+	}))
+	defer homeserver.Close()
+
+	args := []string{"-q", "-n", srv.URL, "-c", network.ChainHash(), "--pin-file", "", "--homeserver", homeserver.URL, "--room", "!room:example.org", "memory:"}
+
+	t.Setenv("TLE_MATRIX_TOKEN", "")
+	if err := Bot(ctx, io.Discard, args); err == nil || !strings.Contains(err.Error(), "TLE_MATRIX_TOKEN") {
+		t.Fatalf("expecting the bot to require a token; got %v", err)
+	}
+
+	t.Setenv("TLE_MATRIX_TOKEN", "token")
+	if err := Bot(ctx, io.Discard, args); err != nil {
+		t.Fatalf("bot error %s", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sent) == 0 || !strings.Contains(sent[len(sent)-1], "it will rain") {
+		t.Fatalf("expecting the message to be posted once released; got %q", sent)
+	}
+}
+
+func Test_ServiceNotify(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("systemd only runs on Linux")
+	}
+
+	dir, err := os.MkdirTemp("", "notify")
+	if err != nil {
+		t.Fatalf("mkdir error %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("listen error %s", err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", socket)
+	t.Setenv("WATCHDOG_USEC", "20000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serviceReady(ctx, NewLogger(io.Discard, LevelQuiet), "listening on :8080")
+	}()
+
+	read := func() string {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		b := make([]byte, 256)
+		n, err := conn.Read(b)
+		if err != nil {
+			t.Fatalf("read error %s", err)
+		}
+		return string(b[:n])
+	}
+
+	if state := read(); state != "READY=1\nSTATUS=listening on :8080" {
+		t.Fatalf("expecting the service to be ready; got %q", state)
+	}
+	if state := read(); state != "WATCHDOG=1" {
+		t.Fatalf("expecting the watchdog to be fed; got %q", state)
+	}
+
+	cancel()
+	<-done
+	for state := read(); state != "STOPPING=1"; state = read() {
+		if state != "WATCHDOG=1" {
+			t.Fatalf("expecting the service to be stopping; got %q", state)
+		}
+	}
+
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := notify("READY=1"); err != nil {
+		t.Fatalf("expecting no notification outside of systemd; got %v", err)
+	}
+}
+
+func Test_ServiceListener(t *testing.T) {
+	if os.Getenv("TLE_TEST_ACTIVATED") == "1" {
+		// Run as the socket activated process, with the socket as fd 3.
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		ln, err := serviceListener(NewLogger(io.Discard, LevelQuiet), "127.0.0.1:1")
+		if err != nil {
+			t.Fatalf("listener error %s", err)
+		}
+		if os.Getenv("LISTEN_FDS") != "" {
+			t.Fatal("expecting LISTEN_FDS to be unset")
+		}
+
+		c, err := ln.Accept()
+		if err != nil {
+			t.Fatalf("accept error %s", err)
+		}
+		io.WriteString(c, "activated")
+		c.Close()
+		return
+	}
+
+	if runtime.GOOS == "windows" {
+		t.Skip("systemd only runs on Linux")
+	}
+
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	ln, err := serviceListener(NewLogger(io.Discard, LevelQuiet), "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expecting sockets passed to another process to be ignored; got %v", err)
+	}
+	defer ln.Close()
+
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("file error %s", err)
+	}
+	defer f.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^Test_ServiceListener$")
+	cmd.Env = append(os.Environ(), "TLE_TEST_ACTIVATED=1", "LISTEN_FDS=1")
+	cmd.ExtraFiles = []*os.File{f}
+	var stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stderr, &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start error %s", err)
+	}
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial error %s", err)
+	}
+	b, _ := io.ReadAll(c)
+	c.Close()
+
+	if err := cmd.Wait(); err != nil || string(b) != "activated" {
+		t.Fatalf("expecting the activated process to accept the connection; got %q, %v: %s", b, err, stderr.String())
+	}
+}
+
+// lockedArchiveTest encrypts the plain data to an archive whose round is an
+// hour away, served by a fake endpoint that signs it once unlocked.
+func lockedArchiveTest(t *testing.T, plain []byte) (string, string, *atomic.Bool) {
+	locked, unlocked := fakenet.NewChainFromSeed(3*time.Second, "mount"), fakenet.NewChainFromSeed(3*time.Second, "mount")
+	unlocked.Unlock()
+
+	var released atomic.Bool
+	lockedHandler, unlockedHandler := fakenet.Handler(locked), fakenet.Handler(unlocked)
+	srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if released.Load() {
+			unlockedHandler.ServeHTTP(w, r)
+			return
+		}
+		lockedHandler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	path := filepath.Join(t.TempDir(), "notes.txt.tle")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create error %s", err)
+	}
+	roundNumber := locked.RoundNumber(time.Now().Add(time.Hour))
+	if err := tlock.New(locked, tlock.WithChunkSize(1024)).Encrypt(f, bytes.NewReader(plain), roundNumber); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("close error %s", err)
+	}
+
+	return path, srv.URL, &released
+}
+
+func Test_LockedArchive(t *testing.T) {
+	plain := bytes.Repeat([]byte("it will rain "), 1000)
+	path, endpoint, released := lockedArchiveTest(t, plain)
+
+	ctx := context.Background()
+	log := NewLogger(io.Discard, LevelQuiet)
+
+	archive, err := openArchive(ctx, log, []string{endpoint}, "", path)
+	if err != nil {
+		t.Fatalf("open archive error %s", err)
+	}
+	defer archive.Close()
+
+	if archive.name != "notes.txt" || archive.Mode() != 0o444 || archive.Size() != 0 {
+		t.Fatalf("unexpected locked archive %q, mode %v, size %d", archive.name, archive.Mode(), archive.Size())
+	}
+
+	_, err = archive.Open(ctx)
+	if !errors.Is(err, tlock.ErrTooEarly) || !strings.Contains(err.Error(), archive.unlock.Format(time.RFC3339)) {
+		t.Fatalf("expecting the unlock time before the round; got %v", err)
+	}
+
+	released.Store(true)
+
+	r, err := archive.Open(ctx)
+	if err != nil {
+		t.Fatalf("open error %s", err)
+	}
+	if archive.Size() != int64(len(plain)) {
+		t.Fatalf("expecting size %d; got %d", len(plain), archive.Size())
+	}
+
+	got := make([]byte, 100)
+	if _, err := r.ReadAt(got, 5000); err != nil {
+		t.Fatalf("read error %s", err)
+	}
+	if !bytes.Equal(got, plain[5000:5100]) {
+		t.Fatalf("unexpected plain data %q", got)
+	}
+
+	if name := plainName("backup.tle", contentTarGzip); name != "backup.tar.gz" {
+		t.Fatalf("expecting backup.tar.gz; got %q", name)
+	}
+}
+
+func Test_Mount(t *testing.T) {
+	plain := []byte("it will rain")
+	path, endpoint, released := lockedArchiveTest(t, plain)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	log := NewLogger(io.Discard, LevelQuiet)
+
+	archive, err := openArchive(ctx, log, []string{endpoint}, "", path)
+	if err != nil {
+		t.Fatalf("open archive error %s", err)
+	}
+	defer archive.Close()
+
+	mountpoint := t.TempDir()
+	done := make(chan error, 1)
+	go func() {
+		done <- mountArchive(ctx, log, mountpoint, archive)
+	}()
+
+	file := filepath.Join(mountpoint, "notes.txt")
+	for {
+		if _, err := os.Stat(file); err == nil {
+			break
+		}
+
+		select {
+		case err := <-done:
+			t.Skipf("can't mount: %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	if _, err := os.ReadFile(file); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("expecting EPERM before the round; got %v", err)
+	}
+
+	released.Store(true)
+
+	got, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("read error %s", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatalf("expecting %q; got %q", plain, got)
+	}
+
+	if err := os.WriteFile(file, nil, 0o644); err == nil {
+		t.Fatal("expecting the mount to be read-only")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unmount error %s", err)
+	}
+}
+
+func Test_LockedDir(t *testing.T) {
+	plain := []byte("it will rain tomorrow")
+	path, endpoint, released := lockedArchiveTest(t, plain)
+	dir := filepath.Dir(path)
+
+	if err := os.WriteFile(filepath.Join(dir, "readme.md"), []byte("not encrypted"), 0o600); err != nil {
+		t.Fatalf("write error %s", err)
+	}
+
+	ctx := context.Background()
+	d := newLockedDir(NewLogger(io.Discard, LevelQuiet), []string{endpoint}, "", dir)
+	if err := d.scan(ctx); err != nil {
+		t.Fatalf("scan error %s", err)
+	}
+	if len(d.files) != 1 || d.files[0].name != "notes.txt.tle" {
+		t.Fatalf("expecting only notes.txt.tle; got %v", d.files)
+	}
+	roundNumber := d.files[0].header.RoundNumber
+
+	var out bytes.Buffer
+	if _, err := d.execute(ctx, &out, []string{"inspect", "1"}); err != nil {
+		t.Fatalf("inspect error %s", err)
+	}
+	if !strings.Contains(out.String(), "round:   "+strconv.FormatUint(roundNumber, 10)+"\n") {
+		t.Fatalf("expecting the round in %q", out.String())
+	}
+
+	// Extending locks the file until a later round with another layer.
+	if _, err := d.execute(ctx, &out, []string{"extend", "1", strconv.FormatUint(roundNumber, 10)}); err == nil {
+		t.Fatal("expecting an error for a round that isn't later")
+	}
+	if _, err := d.execute(ctx, &out, []string{"extend", "1", strconv.FormatUint(roundNumber+10, 10)}); err != nil {
+		t.Fatalf("extend error %s", err)
+	}
+	f := d.files[0]
+	if f.header.RoundNumber != roundNumber+10 {
+		t.Fatalf("expecting round %d; got %d", roundNumber+10, f.header.RoundNumber)
+	}
+
+	// Opening before the round marks the file to be opened when it's ready.
+	if _, err := d.execute(ctx, &out, []string{"open", "1"}); err != nil {
+		t.Fatalf("open error %s", err)
+	}
+	if !d.pending[f.name] || d.openDue(ctx, &out) {
+		t.Fatal("expecting the file to wait for its round")
+	}
+
+	// The signature of the round isn't published yet.
+	d.now = func() time.Time { return f.unlock.Add(time.Second) }
+	if d.openDue(ctx, &out) || !d.pending[f.name] {
+		t.Fatal("expecting the file to wait for the signature of its round")
+	}
+
+	released.Store(true)
+
+	if !d.openDue(ctx, &out) || d.pending[f.name] {
+		t.Fatalf("expecting the file to be opened; got %q", out.String())
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "notes.txt"))
+	if err != nil {
+		t.Fatalf("read error %s", err)
+	}
+	if !bytes.Equal(b, plain) {
+		t.Fatalf("expecting %q; got %q", plain, b)
+	}
+
+	if _, err := d.execute(ctx, &out, []string{"open", "1"}); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("expecting error %v; got %v", fs.ErrExist, err)
+	}
+	for _, args := range [][]string{{"open", "2"}, {"extend", "1"}, {"unknown"}} {
+		if _, err := d.execute(ctx, &out, args); err == nil {
+			t.Fatalf("expecting an error for %v", args)
+		}
+	}
+}
+
+func Test_TUI(t *testing.T) {
+	path, endpoint, _ := lockedArchiveTest(t, []byte("it will rain tomorrow"))
+
+	d := newLockedDir(NewLogger(io.Discard, LevelQuiet), []string{endpoint}, "", filepath.Dir(path))
+
+	var out bytes.Buffer
+	if err := d.run(context.Background(), strings.NewReader("help\ninspect 1\nquit\nrefresh\n"), &out, time.Hour); err != nil {
+		t.Fatalf("run error %s", err)
+	}
+
+	for _, expected := range []string{"1  notes.txt.tle", "commands:", "unlocks: "} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("expecting %q in %q", expected, out.String())
+		}
+	}
+	if strings.Count(out.String(), "locked files") != 3 {
+		t.Fatalf("expecting the files to be listed before each command until quit; got %q", out.String())
 	}
 }
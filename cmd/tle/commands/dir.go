@@ -0,0 +1,278 @@
+package commands
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// These constants define the content types recorded for encrypted
+// directories.
+const (
+	contentTar     = "tar"
+	contentTarGzip = "tar+gzip"
+)
+
+// IsDirectoryContent reports whether the content type recorded in the header
+// describes an encrypted directory.
+func IsDirectoryContent(contentType string) bool {
+	return contentType == contentTar || contentType == contentTarGzip
+}
+
+// directoryContent returns the content type recorded for a directory.
+func directoryContent(compress bool) string {
+	if compress {
+		return contentTarGzip
+	}
+	return contentTar
+}
+
+// =============================================================================
+
+// TarDirectory returns a reader providing the directory as a tar archive,
+// compressed with gzip if requested. The archive is produced while it's read,
+// so the directory is never held in memory. Only directories and regular
+// files are archived, anything else is skipped.
+func TarDirectory(log *Logger, dir string, compress bool) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	go func() {
+		pw.CloseWithError(writeTar(log, pw, localPath(dir), compress))
+	}()
+
+	return pr
+}
+
+// writeTar writes the tree rooted at the directory as a tar archive.
+func writeTar(log *Logger, w io.Writer, dir string, compress bool) error {
+	var zw *gzip.Writer
+	if compress {
+		zw = gzip.NewWriter(w)
+		w = zw
+	}
+
+	tw := tar.NewWriter(w)
+
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, name)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsDir() && !info.Mode().IsRegular() {
+			log.Infof("skipping %s: not a regular file", name)
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("archive directory: %w", err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	if zw != nil {
+		return zw.Close()
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// Extractor restores the directory tree from the tar archive written to it.
+// Like Output, the tree is extracted to a temporary directory next to the
+// destination and only moved into place once the operation succeeded.
+type Extractor struct {
+	pw        *io.PipeWriter
+	done      chan error
+	err       error
+	finished  bool
+	committed bool
+	path      string
+	tmp       string
+}
+
+// NewExtractor constructs an extractor for the directory at the specified
+// path, which must not exist yet.
+func NewExtractor(path string, contentType string) (*Extractor, error) {
+	path = localPath(path)
+
+	if _, err := os.Lstat(path); err == nil {
+		return nil, fmt.Errorf("output directory %q already exists", path)
+	}
+
+	tmp, err := os.MkdirTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	e := Extractor{
+		pw:   pw,
+		done: make(chan error, 1),
+		path: path,
+		tmp:  tmp,
+	}
+
+	go func() {
+		err := extractTar(tmp, pr, contentType == contentTarGzip)
+		pr.CloseWithError(err)
+		e.done <- err
+	}()
+
+	return &e, nil
+}
+
+// Write implements the io.Writer interface.
+func (e *Extractor) Write(p []byte) (int, error) {
+	return e.pw.Write(p)
+}
+
+// Commit waits for the extraction to finish and moves the directory into
+// place.
+func (e *Extractor) Commit() error {
+	e.pw.Close()
+	if err := e.wait(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(e.tmp, e.path); err != nil {
+		return err
+	}
+	e.committed = true
+
+	return nil
+}
+
+// Abort stops the extraction and removes the temporary directory. It does
+// nothing once the extractor was committed.
+func (e *Extractor) Abort() {
+	if e.committed {
+		return
+	}
+
+	e.pw.CloseWithError(errors.New("extraction aborted"))
+	e.wait()
+	os.RemoveAll(e.tmp)
+}
+
+// wait returns the result of the extraction once it finished.
+func (e *Extractor) wait() error {
+	if !e.finished {
+		e.err = <-e.done
+		e.finished = true
+	}
+	return e.err
+}
+
+// extractTar restores the entries of the archive below the directory.
+// Entries that would be written outside of the directory are rejected.
+func extractTar(dir string, r io.Reader, compressed bool) error {
+	if compressed {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("decompress archive: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read archive: %w", err)
+		}
+
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("archive entry %q is outside of the directory", hdr.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		mode := fs.FileMode(hdr.Mode).Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode|0700); err != nil {
+				return err
+			}
+
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+			if err := writeFile(target, tr, mode); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("archive entry %q has an unsupported type", hdr.Name)
+		}
+	}
+
+	// Drain the padding the archive may end with.
+	_, err := io.Copy(io.Discard, r)
+	return err
+}
+
+// writeFile creates the file with the content of the reader.
+func writeFile(name string, r io.Reader, mode fs.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -1,101 +1,210 @@
 package commands
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"io"
-	"strconv"
-	"strings"
+	"os"
 	"time"
 
-	"filippo.io/age/armor"
 	"github.com/drand/tlock"
+	"github.com/drand/tlock/armor"
 	"github.com/drand/tlock/networks/http"
 )
 
-var ErrInvalidDuration = errors.New("invalid duration unit")
-
 // Encrypt performs the encryption operation. This requires the implementation
 // of an encoder for reading/writing to disk, a network for making calls to the
-// drand network, and an encrypter for encrypting/decrypting the data.
-func Encrypt(flags Flags, dst io.Writer, src io.Reader, network *http.Network) error {
-	tlock := tlock.New(network)
+// drand network, and an encrypter for encrypting/decrypting the data. It
+// returns the round the data is locked to.
+func Encrypt(ctx context.Context, log *Logger, clock Clock, flags Flags, dst io.Writer, src io.Reader, network *http.Network) (uint64, error) {
+	roundNumber, err := encrypt(ctx, log, clock, flags, dst, src, network)
+	if err != nil {
+		return 0, err
+	}
 
-	if flags.Armor {
-		a := armor.NewWriter(dst)
-		defer func() {
-			if err := a.Close(); err != nil {
-				fmt.Printf("Error while closing: %v", err)
-			}
-		}()
-		dst = a
+	return roundNumber, writeSchedule(log.Writer(), flags.JSON, newSchedule(network, roundNumber, clock.Now()))
+}
+
+// encrypt encrypts the source for the round selected by the flags and
+// returns that round. The options are applied after the ones of the flags.
+func encrypt(ctx context.Context, log *Logger, clock Clock, flags Flags, dst io.Writer, src io.Reader, network *http.Network, opts ...tlock.Option) (uint64, error) {
+	roundNumber, err := selectRound(clock.Now(), flags, network)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := encryptRound(ctx, log, clock, flags, dst, src, network, roundNumber, opts...); err != nil {
+		return 0, err
 	}
 
+	return roundNumber, nil
+}
+
+// selectRound returns the round selected by the flags, which can't be in the
+// past.
+func selectRound(now time.Time, flags Flags, network *http.Network) (uint64, error) {
+	spec := flags.Round
 	switch {
-	case flags.Round != 0:
-		lastestAvailableRound := network.RoundNumber(time.Now())
-		if flags.Round < lastestAvailableRound {
-			return fmt.Errorf("round %d is in the past", flags.Round)
+	case flags.At != "":
+		t, err := parseLocalTime(flags.At, flags.TZ)
+		if err != nil {
+			return 0, err
 		}
+		spec = "time:" + t.Format(time.RFC3339)
+
+	case spec == "":
+		spec = "dur:" + flags.Duration
+	}
+
+	roundNumber, err := tlock.ParseRound(spec, now, network)
+	if err != nil {
+		return 0, err
+	}
 
-		return tlock.Encrypt(dst, src, flags.Round)
+	lastestAvailableRound := network.RoundNumber(now)
+	if roundNumber < lastestAvailableRound {
+		return 0, fmt.Errorf("round %d is in the past", roundNumber)
+	}
+
+	return roundNumber, nil
+}
+
+// encryptRound encrypts the source for the round, applying the other
+// settings of the flags.
+func encryptRound(ctx context.Context, log *Logger, clock Clock, flags Flags, dst io.Writer, src io.Reader, network *http.Network, roundNumber uint64, opts ...tlock.Option) error {
+	tlock := tlock.New(network, append(Options(log, clock, flags), opts...)...)
 
-	case flags.Duration != "":
-		duration, err := parseDuration(time.Now(), flags.Duration)
+	if flags.Mode {
+		info, err := os.Stat(localPath(flags.Input))
 		if err != nil {
-			return err
+			return fmt.Errorf("stat input: %w", err)
 		}
+		tlock = tlock.WithFileMode(info.Mode())
+	}
 
-		roundNumber := network.RoundNumber(time.Now().Add(duration))
-		return tlock.Encrypt(dst, src, roundNumber)
+	var a *armor.Writer
+	if flags.Armor {
+		aopts := armorOptions(flags)
+		if flags.ArmorPreamble {
+			aopts = append(aopts, armor.WithPreamble(armorPreamble(network, roundNumber)))
+		}
+		a = armor.NewWriter(dst, aopts...)
+		dst = a
+	}
+
+	var err error
+	if ra, size, ok := randomAccess(src); ok {
+		err = tlock.EncryptFromContext(ctx, dst, ra, size, roundNumber)
+	} else {
+		err = tlock.EncryptContext(ctx, dst, src, roundNumber)
+	}
+	if err != nil {
+		return err
+	}
+
+	if a != nil {
+		if err := a.Close(); err != nil {
+			return fmt.Errorf("closing armor: %w", err)
+		}
 	}
 
 	return nil
 }
 
-// parseDuration parses the duration and can handle days, months, and years.
-func parseDuration(t time.Time, duration string) (time.Duration, error) {
-	d, err := time.ParseDuration(duration)
-	if err == nil {
-		return d, nil
+// randomAccess returns the rest of the source for reading at arbitrary
+// offsets along with its size, if the source is a local file or a mapped one.
+// Encrypting it that way processes its chunks in parallel.
+func randomAccess(src io.Reader) (io.ReaderAt, int64, bool) {
+	ra, ok := src.(interface {
+		io.ReaderAt
+		io.Seeker
+	})
+	if !ok {
+		return nil, 0, false
+	}
+
+	// Pipes implement io.Seeker but fail to seek.
+	start, err := ra.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, false
+	}
+
+	end, err := ra.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, 0, false
+	}
+
+	if _, err := ra.Seek(start, io.SeekStart); err != nil {
+		return nil, 0, false
+	}
+
+	return io.NewSectionReader(ra, start, end-start), end - start, true
+}
+
+// armorOptions returns the armor options that correspond to the flags.
+func armorOptions(flags Flags) []armor.Option {
+	var opts []armor.Option
+
+	if flags.ArmorWidth != 0 {
+		opts = append(opts, armor.WithWidth(flags.ArmorWidth))
 	}
 
-	// M has to be capitalised to avoid conflict with minutes.
-	if !strings.ContainsAny(duration, "dMy") {
-		return time.Second, ErrInvalidDuration
+	if flags.ArmorLabel != "" {
+		opts = append(opts, armor.WithLabel(flags.ArmorLabel))
 	}
 
-	now := time.Now()
+	return opts
+}
 
-	pieces := strings.Split(duration, "d")
-	if len(pieces) == 2 {
-		days, err := strconv.Atoi(pieces[0])
-		if err != nil {
-			return time.Second, fmt.Errorf("parse day duration: %w", err)
-		}
-		diff := now.AddDate(0, 0, days).Sub(now)
-		return diff, nil
+// armorPreamble returns the line written before armored data to tell its
+// readers when it can be decrypted.
+func armorPreamble(network *http.Network, roundNumber uint64) string {
+	unlock := network.RoundTime(roundNumber).UTC().Format("2006-01-02 15:04:05 MST")
+	return fmt.Sprintf("Opens after %s (round %d of chain %s).", unlock, roundNumber, network.ChainHash())
+}
+
+// Options returns the tlock options that correspond to the flags.
+func Options(log *Logger, clock Clock, flags Flags) []tlock.Option {
+	opts := []tlock.Option{
+		tlock.WithLogger(log),
+		tlock.WithClock(clock),
+		tlock.WithStrictParsing(!flags.Lenient),
+		tlock.WithWarnings(func(w tlock.Warning) {
+			log.Infof("warning: %s", w.Message)
+		}),
 	}
 
-	pieces = strings.Split(duration, "M")
-	if len(pieces) == 2 {
-		months, err := strconv.Atoi(pieces[0])
-		if err != nil {
-			return time.Second, fmt.Errorf("parse month duration: %w", err)
-		}
-		diff := now.AddDate(0, months, 0).Sub(now)
-		return diff, nil
+	if flags.AAD != "" {
+		opts = append(opts, tlock.WithAAD([]byte(flags.AAD)))
 	}
 
-	pieces = strings.Split(duration, "y")
-	if len(pieces) == 2 {
-		years, err := strconv.Atoi(pieces[0])
-		if err != nil {
-			return time.Second, fmt.Errorf("parse year duration: %w", err)
-		}
-		diff := now.AddDate(years, 0, 0).Sub(now)
-		return diff, nil
+	if flags.Dir != "" {
+		opts = append(opts, tlock.WithContentType(directoryContent(flags.Compress)))
+	}
+
+	switch {
+	case flags.digestKey != nil:
+		opts = append(opts, tlock.WithDigestKey(flags.digestKey))
+	case flags.ContentDigest:
+		opts = append(opts, tlock.WithContentDigest())
+	}
+
+	if len(flags.EndpointHints) > 0 {
+		opts = append(opts, tlock.WithEndpointHints(flags.EndpointHints...))
+	}
+
+	if flags.policy != nil {
+		opts = append(opts, tlock.WithPolicy(*flags.policy))
+	}
+
+	if flags.Compact {
+		opts = append(opts, tlock.WithCompact())
+	}
+
+	if flags.DeterministicSeed != "" {
+		log.Infof("warning: the output is derived from --deterministic-seed and is only fit for test vectors")
+		opts = append(opts, tlock.WithDeterministicSeed([]byte(flags.DeterministicSeed)))
 	}
 
-	return time.Second, fmt.Errorf("parse duration: %w", err)
+	return opts
 }
@@ -0,0 +1,167 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/drand/tlock"
+)
+
+// Hints runs the commands that manage staged hints, such as the hints of a
+// puzzle or a CTF challenge. A bundle of hints is a capsule whose hints unlock
+// at successive rounds, which is opened with capsule open.
+func Hints(ctx context.Context, out io.Writer, args []string) error {
+	var v verbosity
+
+	fs := flag.NewFlagSet("hints", flag.ContinueOnError)
+	asJSON := jsonFlag(fs)
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch fs.Arg(0) {
+	case "create":
+		return createHints(ctx, out, v, *asJSON, fs.Args()[1:])
+
+	case "list":
+		return listHints(ctx, out, v, *asJSON, fs.Args()[1:])
+	}
+
+	return errors.New("hints requires a command: create or list")
+}
+
+// createHints encrypts the hints into a bundle, the first one unlocking at
+// the start round and every other one an interval after the previous one.
+func createHints(ctx context.Context, out io.Writer, v verbosity, asJSON bool, args []string) error {
+	var networks listFlag
+
+	fs := flag.NewFlagSet("hints create", flag.ContinueOnError)
+	fs.Var(&networks, "n", "the drand API endpoint; can be repeated")
+	fs.Var(&networks, "network", "the drand API endpoint; can be repeated")
+	chainHash := fs.String("c", defaultChain, "the chain the hints are locked to")
+	fs.StringVar(chainHash, "chain", defaultChain, "the chain the hints are locked to")
+	output := fs.String("o", "", "the path to the bundle")
+	fs.StringVar(output, "output", "", "the path to the bundle")
+	start := fs.String("start", "+1", "the round the first hint unlocks at")
+	every := fs.String("every", "", "the interval between hints")
+	signingKey := fs.String("signing-key", "", "the Ed25519 private key signing the manifest")
+	pinFile := fs.String("pin-file", defaultPinFile(), "the file recording the public key of each chain")
+	policyFile := fs.String("policy-file", os.Getenv("TLE_POLICYFILE"), "the file holding the policy enforced when encrypting")
+	fs.BoolVar(&asJSON, "json", asJSON, "print machine readable JSON output")
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := NewLogger(os.Stderr, v.level())
+
+	if fs.NArg() == 0 || *output == "" || *output == "-" || *every == "" {
+		return errors.New("hints create requires -o/--output, --every and at least one HINT")
+	}
+
+	if len(networks) == 0 {
+		networks = listFlag{defaultNetwork}
+	}
+
+	network, err := NetworkForChain(ctx, log, networks, *pinFile, *chainHash, 0)
+	if err != nil {
+		return err
+	}
+
+	now := SystemClock{}.Now()
+	first, err := selectRound(now, Flags{Round: *start}, network)
+	if err != nil {
+		return err
+	}
+
+	interval, err := tlock.ParseDuration(now, *every)
+	if err != nil {
+		return err
+	}
+	step := uint64((interval + network.Info().Period - 1) / network.Info().Period)
+	if step == 0 {
+		return fmt.Errorf("interval %q isn't positive", *every)
+	}
+
+	var s Schedule
+	names := make(map[string]bool)
+	for i, input := range fs.Args() {
+		name := filepath.Base(filepath.FromSlash(input))
+		if input == "-" || !validCapsuleName(name) {
+			return fmt.Errorf("hint %d: invalid input %q", i+1, input)
+		}
+		if names[name] {
+			return fmt.Errorf("hint %d: duplicate name %q", i+1, name)
+		}
+		names[name] = true
+
+		s.Files = append(s.Files, ScheduleEntry{
+			Input: input,
+			Name:  name,
+			Round: strconv.FormatUint(first+uint64(i)*step, 10),
+		})
+	}
+
+	return sealCapsule(ctx, out, log, asJSON, s, network, *output, *signingKey, *policyFile)
+}
+
+// listHints displays which hints of a bundle can be opened, without
+// decrypting them.
+func listHints(ctx context.Context, out io.Writer, v verbosity, asJSON bool, args []string) error {
+	var networks listFlag
+
+	fs := flag.NewFlagSet("hints list", flag.ContinueOnError)
+	fs.Var(&networks, "n", "the drand API endpoint; can be repeated")
+	fs.Var(&networks, "network", "the drand API endpoint; can be repeated")
+	signer := fs.String("signer", "", "the hex encoded public key that has to sign the manifest")
+	pinFile := fs.String("pin-file", defaultPinFile(), "the file recording the public key of each chain")
+	fs.BoolVar(&asJSON, "json", asJSON, "print machine readable JSON output")
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := NewLogger(os.Stderr, v.level())
+
+	if fs.NArg() != 1 {
+		return errors.New("hints list requires a single BUNDLE")
+	}
+
+	m, err := ReadCapsule(fs.Arg(0), *signer)
+	if err != nil {
+		return err
+	}
+
+	if len(networks) == 0 {
+		networks = listFlag{defaultNetwork}
+	}
+
+	network, err := NetworkForChain(ctx, log, networks, *pinFile, m.ChainHash, 0)
+	if err != nil {
+		return err
+	}
+
+	now := SystemClock{}.Now()
+	summary := capsuleSummary{Signer: m.PublicKey}
+
+	for _, mf := range m.Files {
+		r := capsuleResult{
+			Name:       mf.Name,
+			Round:      mf.Round,
+			UnlockTime: network.RoundTime(mf.Round).UTC(),
+			Status:     "locked",
+		}
+		if mf.Round <= network.RoundNumber(now) {
+			r.Status = "openable"
+		}
+
+		summary.Files = append(summary.Files, r)
+	}
+
+	return writeCapsuleSummary(out, asJSON, summary)
+}
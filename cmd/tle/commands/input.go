@@ -0,0 +1,55 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// IsLocalFile reports whether the input name refers to a file on disk rather
+// than stdin or a URL.
+func IsLocalFile(name string) bool {
+	return name != "" && name != "-" && !isURL(name)
+}
+
+// isURL reports whether the input name is an http:// or https:// URL.
+func isURL(name string) bool {
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+}
+
+// OpenInput opens the input named on the command line. An empty name or "-"
+// refers to stdin, and http:// or https:// URLs are streamed from the web.
+func OpenInput(ctx context.Context, name string) (io.ReadCloser, error) {
+	switch {
+	case name == "" || name == "-":
+		return io.NopCloser(os.Stdin), nil
+
+	case isURL(name):
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request for %q: %v", name, err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download input %q: %w", name, err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to download input %q: unexpected status %s", name, resp.Status)
+		}
+
+		return resp.Body, nil
+	}
+
+	f, err := os.OpenFile(localPath(name), os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input file %q: %v", name, err)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,187 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/drand/tlock"
+)
+
+// Inspect describes an encrypted input without decrypting it: the round and
+// chain it's locked to and the settings recorded in its header. It doesn't
+// contact a network unless one is given to estimate when the input unlocks.
+func Inspect(ctx context.Context, out io.Writer, args []string) error {
+	var networks listFlag
+	var v verbosity
+
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	fs.Var(&networks, "n", "the drand API endpoint estimating the unlock time; can be repeated")
+	fs.Var(&networks, "network", "the drand API endpoint estimating the unlock time; can be repeated")
+	pinFile := fs.String("pin-file", defaultPinFile(), "the file recording the public key of each chain")
+	asJSON := jsonFlag(fs)
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := NewLogger(os.Stderr, v.level())
+
+	if fs.NArg() > 1 {
+		return errors.New("inspect requires at most one INPUT")
+	}
+
+	src, err := OpenInput(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	header, rest, err := PeekHeader(src)
+	if err != nil {
+		return err
+	}
+
+	var network tlock.Network
+	if len(networks) > 0 {
+		n, err := NetworkForHeader(ctx, log, networks, *pinFile, header)
+		if err != nil {
+			return err
+		}
+		network = n
+	}
+
+	md, err := tlock.Inspect(rest, network)
+	if err != nil {
+		return err
+	}
+
+	return writeInspection(out, *asJSON, newInspection(md))
+}
+
+// inspection describes an encrypted input.
+type inspection struct {
+	Version       int               `json:"format_version"`
+	RoundNumber   uint64            `json:"round"`
+	ChainHash     string            `json:"chain_hash"`
+	Network       string            `json:"network,omitempty"`
+	Scheme        string            `json:"scheme"`
+	AEAD          string            `json:"aead"`
+	ChunkSize     int               `json:"chunk_size"`
+	Compact       bool              `json:"compact"`
+	Armored       bool              `json:"armored"`
+	PayloadSize   int64             `json:"payload_size"`
+	AAD           bool              `json:"aad"`
+	ContentType   string            `json:"content_type,omitempty"`
+	FileMode      string            `json:"file_mode,omitempty"`
+	Digest        string            `json:"digest,omitempty"`
+	EndpointHints []string          `json:"endpoint_hints,omitempty"`
+	Extensions    map[string]string `json:"extensions,omitempty"`
+	UnlockTime    *time.Time        `json:"unlock_time,omitempty"`
+}
+
+// newInspection returns the description of the encrypted input.
+func newInspection(md tlock.Metadata) inspection {
+	i := inspection{
+		Version:       md.Version,
+		RoundNumber:   md.RoundNumber,
+		ChainHash:     md.ChainHash,
+		Network:       networkName(md.ChainHash),
+		Scheme:        md.Scheme,
+		AEAD:          string(md.AEAD),
+		ChunkSize:     md.ChunkSize,
+		Compact:       md.Compact,
+		Armored:       md.Armored,
+		PayloadSize:   md.PayloadSize,
+		AAD:           md.AAD,
+		ContentType:   md.ContentType,
+		EndpointHints: md.EndpointHints,
+		Extensions:    md.Extensions,
+	}
+
+	if md.FileMode != 0 {
+		i.FileMode = fmt.Sprintf("%04o", md.FileMode.Perm())
+	}
+
+	switch {
+	case md.Digest != nil && md.DigestKeyed:
+		i.Digest = "hmac-sha256"
+	case md.Digest != nil:
+		i.Digest = "sha256"
+	}
+
+	if !md.EstimatedUnlock.IsZero() {
+		unlock := md.EstimatedUnlock.UTC()
+		i.UnlockTime = &unlock
+	}
+
+	return i
+}
+
+// writeInspection displays the description of the encrypted input.
+func writeInspection(out io.Writer, asJSON bool, i inspection) error {
+	if asJSON {
+		return json.NewEncoder(out).Encode(i)
+	}
+
+	chain := i.ChainHash
+	if i.Network != "" {
+		chain += " (" + i.Network + ")"
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(tw, "round:\t%d\n", i.RoundNumber)
+	fmt.Fprintf(tw, "chain:\t%s\n", chain)
+	fmt.Fprintf(tw, "scheme:\t%s\n", i.Scheme)
+	if i.UnlockTime != nil {
+		fmt.Fprintf(tw, "unlocks:\t%s\n", i.UnlockTime.Format(time.RFC3339))
+	}
+	fmt.Fprintf(tw, "version:\t%d\n", i.Version)
+
+	payload := fmt.Sprintf("%s, %d byte chunks", i.AEAD, i.ChunkSize)
+	if i.Compact {
+		payload = fmt.Sprintf("%s, compact", i.AEAD)
+	}
+	fmt.Fprintf(tw, "payload:\t%s, %d bytes\n", payload, i.PayloadSize)
+
+	fmt.Fprintf(tw, "armored:\t%s\n", yesNo(i.Armored))
+	fmt.Fprintf(tw, "aad:\t%s\n", yesNo(i.AAD))
+	if i.ContentType != "" {
+		fmt.Fprintf(tw, "content:\t%s\n", i.ContentType)
+	}
+	if i.FileMode != "" {
+		fmt.Fprintf(tw, "mode:\t%s\n", i.FileMode)
+	}
+	if i.Digest != "" {
+		fmt.Fprintf(tw, "digest:\t%s\n", i.Digest)
+	}
+	if len(i.EndpointHints) > 0 {
+		fmt.Fprintf(tw, "endpoints:\t%s\n", strings.Join(i.EndpointHints, " "))
+	}
+
+	keys := make([]string, 0, len(i.Extensions))
+	for key := range i.Extensions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(tw, "x-%s:\t%s\n", key, i.Extensions[key])
+	}
+
+	return tw.Flush()
+}
+
+// yesNo formats a boolean for the human readable output.
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
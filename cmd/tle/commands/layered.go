@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"io"
+
+	"github.com/drand/tlock"
+)
+
+// ErrAlreadyEncrypted represents an error when the input to encrypt looks
+// like data encrypted by tlock or age, which pipelines tend to encrypt twice
+// by accident.
+var ErrAlreadyEncrypted = errors.New("the input already looks encrypted with tlock or age; use --force to encrypt it again")
+
+// CheckEncrypted fails with ErrAlreadyEncrypted if the source looks
+// encrypted. The returned reader yields the whole source. A seekable source is
+// positioned back where it was and returned as is, so it stays seekable.
+func CheckEncrypted(src io.Reader) (io.Reader, error) {
+	prefix, src, err := peek(src, tlock.SniffSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if tlock.IsEncrypted(prefix) {
+		return nil, ErrAlreadyEncrypted
+	}
+
+	return src, nil
+}
+
+// peek returns up to the first n bytes of the source and a reader that still
+// yields them.
+func peek(src io.Reader, n int) ([]byte, io.Reader, error) {
+	if rs, ok := src.(io.ReadSeeker); ok {
+		// Pipes implement io.Seeker but fail to seek.
+		if start, err := rs.Seek(0, io.SeekCurrent); err == nil {
+			prefix := make([]byte, n)
+			m, err := io.ReadFull(rs, prefix)
+			if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, nil, err
+			}
+
+			if _, err := rs.Seek(start, io.SeekStart); err != nil {
+				return nil, nil, err
+			}
+
+			return prefix[:m], rs, nil
+		}
+	}
+
+	br := bufio.NewReaderSize(src, n)
+	prefix, err := br.Peek(n)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, err
+	}
+
+	return prefix, br, nil
+}
+
+// LayerCheck records the start of the decrypted data written through it to
+// tell whether the data still looks encrypted, which happens when it was
+// encrypted more than once.
+type LayerCheck struct {
+	dst    io.Writer
+	prefix []byte
+}
+
+// NewLayerCheck constructs a layer check writing the decrypted data to the
+// destination.
+func NewLayerCheck(dst io.Writer) *LayerCheck {
+	return &LayerCheck{dst: dst}
+}
+
+// Write implements the io.Writer interface.
+func (lc *LayerCheck) Write(p []byte) (int, error) {
+	if missing := tlock.SniffSize - len(lc.prefix); missing > 0 {
+		if missing > len(p) {
+			missing = len(p)
+		}
+		lc.prefix = append(lc.prefix, p[:missing]...)
+	}
+
+	return lc.dst.Write(p)
+}
+
+// Encrypted reports whether the data written so far looks encrypted.
+func (lc *LayerCheck) Encrypted() bool {
+	return tlock.IsEncrypted(lc.prefix)
+}
@@ -0,0 +1,104 @@
+package commands
+
+import (
+	"flag"
+	"io"
+	"log"
+)
+
+// Level represents how much information the logger displays.
+type Level int
+
+// These constants define the supported logging levels.
+const (
+	LevelQuiet Level = iota
+	LevelInfo
+	LevelDebug
+)
+
+// Logger is a leveled logger. Errors are always displayed, informational
+// messages are displayed unless the logger is quiet, and debug messages are
+// only displayed when the logger is verbose.
+type Logger struct {
+	log   *log.Logger
+	level Level
+}
+
+// NewLogger constructs a logger writing to w at the specified level.
+func NewLogger(w io.Writer, level Level) *Logger {
+	return &Logger{
+		log:   log.New(w, "", 0),
+		level: level,
+	}
+}
+
+// Enabled reports whether messages of the specified level are displayed.
+func (l *Logger) Enabled(level Level) bool {
+	return l.level >= level
+}
+
+// Writer returns the destination of informational output, or io.Discard if
+// such output is disabled.
+func (l *Logger) Writer() io.Writer {
+	if !l.Enabled(LevelInfo) {
+		return io.Discard
+	}
+	return l.log.Writer()
+}
+
+// Errorf displays an error message.
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	l.log.Printf("error: "+format, v...)
+}
+
+// Infof displays an informational message unless the logger is quiet.
+func (l *Logger) Infof(format string, v ...interface{}) {
+	if l.Enabled(LevelInfo) {
+		l.log.Printf(format, v...)
+	}
+}
+
+// Debugf displays a debug message if the logger is verbose.
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	if l.Enabled(LevelDebug) {
+		l.log.Printf("debug: "+format, v...)
+	}
+}
+
+// Printf displays a debug message. It allows the logger to be used by the
+// tlock library.
+func (l *Logger) Printf(format string, v ...interface{}) {
+	l.Debugf(format, v...)
+}
+
+// =============================================================================
+
+// verbosity holds the values of the -q/--quiet and -v/--verbose flags.
+type verbosity struct {
+	quiet   bool
+	verbose bool
+}
+
+// register adds the verbosity flags to the flag set.
+func (v *verbosity) register(fs *flag.FlagSet) {
+	fs.BoolVar(&v.quiet, "q", false, "only display errors")
+	fs.BoolVar(&v.quiet, "quiet", false, "only display errors")
+	fs.BoolVar(&v.verbose, "v", false, "display debug information")
+	fs.BoolVar(&v.verbose, "verbose", false, "display debug information")
+}
+
+// level converts the verbosity flags into a logging level.
+func (v verbosity) level() Level {
+	return levelOf(v.quiet, v.verbose)
+}
+
+// levelOf converts the quiet and verbose settings into a logging level.
+func levelOf(quiet bool, verbose bool) Level {
+	switch {
+	case quiet:
+		return LevelQuiet
+	case verbose:
+		return LevelDebug
+	}
+	return LevelInfo
+}
@@ -0,0 +1,173 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	nethttp "net/http"
+	"net/smtp"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/drand/tlock/mail"
+)
+
+// Mail runs a gateway emailing the messages scheduled with it once their
+// round is reached, keeping them in a directory, an S3 bucket or memory,
+// until the context is canceled.
+func Mail(ctx context.Context, out io.Writer, args []string) error {
+	var networks listFlag
+	var v verbosity
+
+	fs := flag.NewFlagSet("mail", flag.ContinueOnError)
+	fs.Var(&networks, "n", "the drand API endpoint; can be repeated")
+	fs.Var(&networks, "network", "the drand API endpoint; can be repeated")
+	chainHash := fs.String("c", defaultChain, "the chain messages are locked to")
+	fs.StringVar(chainHash, "chain", defaultChain, "the chain messages are locked to")
+	listen := fs.String("listen", "127.0.0.1:8080", "the address to listen on")
+	smtpAddr := fs.String("smtp", "", "the host and port of the SMTP server sending the emails")
+	from := fs.String("from", "", "the address the emails are sent from")
+	ciphertext := fs.Bool("ciphertext", false, "email the encrypted messages with their beacon instead of their plain text")
+	keysFile := fs.String("keys", "", "the file holding the API keys required to schedule messages")
+	stateKey := fs.String("state-key", "", "the file holding the key encrypting the messages and emails in STORAGE")
+	pinFile := fs.String("pin-file", defaultPinFile(), "the file recording the public key of each chain")
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := NewLogger(os.Stderr, v.level())
+
+	if fs.NArg() != 1 {
+		return errors.New("mail requires a single STORAGE")
+	}
+	if *smtpAddr == "" || *from == "" {
+		return errors.New("mail requires --smtp and --from")
+	}
+	if *keysFile == "" && !isLoopback(*listen) {
+		return fmt.Errorf("mail requires --keys to listen on %s, or else anyone could send emails through it", *listen)
+	}
+
+	if *stateKey == "" && !*ciphertext && fs.Arg(0) != "memory:" {
+		return errors.New("mail requires --state-key unless it uses --ciphertext, or else the plain text of the emails waiting to be sent is stored unencrypted")
+	}
+
+	storage, err := openStateStorage(fs.Arg(0), *stateKey)
+	if err != nil {
+		return err
+	}
+
+	if len(networks) == 0 {
+		networks = listFlag{defaultNetwork}
+	}
+
+	network, err := NetworkForChain(ctx, log, networks, *pinFile, *chainHash, 0)
+	if err != nil {
+		return err
+	}
+
+	opts := []mail.Option{mail.WithErrorHandler(func(s mail.Scheduled, err error) {
+		log.Errorf("dropping message %s to %s: %v", s.ID, s.To, err)
+	})}
+	if *ciphertext {
+		opts = append(opts, mail.WithCiphertext())
+	}
+	if *keysFile != "" {
+		keys, err := LoadKeys(*keysFile)
+		if err != nil {
+			return err
+		}
+
+		tokens := make([]string, len(keys))
+		for i, key := range keys {
+			tokens[i] = key.Token
+		}
+		opts = append(opts, mail.WithTokens(tokens...))
+		log.Infof("loaded %d API keys", len(keys))
+	}
+
+	g := mail.New(network, storage, mail.NewSMTP(*smtpAddr, *from, smtpAuth(*smtpAddr)), opts...)
+
+	ln, err := serviceListener(log, *listen)
+	if err != nil {
+		return err
+	}
+
+	srv := nethttp.Server{
+		Handler:           g,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var runErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer srv.Close()
+
+		if runErr = g.Run(ctx); errors.Is(runErr, context.Canceled) {
+			runErr = nil
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	log.Infof("mail gateway listening on %s for chain %s", ln.Addr(), network.ChainHash())
+	go serviceReady(ctx, log, fmt.Sprintf("listening on %s", ln.Addr()))
+
+	err = srv.Serve(ln)
+	cancel()
+	wg.Wait()
+
+	if !errors.Is(err, nethttp.ErrServerClosed) {
+		return err
+	}
+
+	return runErr
+}
+
+// isLoopback reports whether the listen address only accepts connections
+// from the local machine.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// smtpAuth returns the authentication of the SMTP server at the address
+// with the credentials of $TLE_SMTP_USERNAME and $TLE_SMTP_PASSWORD, or nil
+// without them.
+func smtpAuth(addr string) smtp.Auth {
+	username := os.Getenv("TLE_SMTP_USERNAME")
+	if username == "" {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	return smtp.PlainAuth("", username, os.Getenv("TLE_SMTP_PASSWORD"), host)
+}
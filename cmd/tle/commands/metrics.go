@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"net"
+	nethttp "net/http"
+	"time"
+
+	"github.com/drand/tlock/relay"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// serveMetrics serves the metrics of the registry in the Prometheus format
+// at /metrics on the address, until the context is canceled.
+func serveMetrics(ctx context.Context, log *Logger, addr string, reg *prometheus.Registry) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := nethttp.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+
+	srv := nethttp.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	go func() {
+		if err := srv.Serve(ln); !errors.Is(err, nethttp.ErrServerClosed) {
+			log.Errorf("metrics: %v", err)
+		}
+	}()
+
+	log.Infof("metrics served on %s/metrics", ln.Addr())
+	return nil
+}
+
+// relayMetrics returns the collectors of the state of a relay, read from its
+// stats whenever the metrics are scraped.
+func relayMetrics(s *relay.Server) []prometheus.Collector {
+	gauge := func(name string, help string, value func(st relay.Stats) float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{Namespace: "tle", Subsystem: "relay", Name: name, Help: help}, func() float64 {
+			return value(s.Stats())
+		})
+	}
+	counter := func(name string, help string, value func(st relay.Stats) float64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{Namespace: "tle", Subsystem: "relay", Name: name, Help: help}, func() float64 {
+			return value(s.Stats())
+		})
+	}
+
+	return []prometheus.Collector{
+		gauge("items", "The number of items stored.", func(st relay.Stats) float64 { return float64(st.Items) }),
+		gauge("pending_items", "The number of items that aren't released yet.", func(st relay.Stats) float64 { return float64(st.Pending) }),
+		gauge("stored_bytes", "The total size of the items stored.", func(st relay.Stats) float64 { return float64(st.Size) }),
+		gauge("subscribers", "The number of open event streams.", func(st relay.Stats) float64 { return float64(st.Subscribers) }),
+		counter("resolve_errors_total", "The uploads rejected because their chain couldn't be resolved.", func(st relay.Stats) float64 { return float64(st.ResolveErrors) }),
+		counter("throttled_requests_total", "The requests rejected by the rate limit of their key.", func(st relay.Stats) float64 { return float64(st.Throttled) }),
+		counter("busy_uploads_total", "The uploads rejected because too many were in progress.", func(st relay.Stats) float64 { return float64(st.Busy) }),
+	}
+}
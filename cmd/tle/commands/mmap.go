@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// errMmapUnsupported represents an error when files can't be mapped into
+// memory on the platform.
+var errMmapUnsupported = errors.New("memory mapping isn't supported on this platform")
+
+// MappedFile is a local input file mapped into memory. It is read straight
+// from the page cache instead of being copied into buffers, and supports
+// random access so its chunks can be encrypted in parallel.
+type MappedFile struct {
+	*bytes.Reader
+	file  *os.File
+	unmap func() error
+}
+
+// Close unmaps the file and closes it. The data read from the file can't be
+// used afterwards.
+func (m *MappedFile) Close() error {
+	err := m.unmap()
+	if cerr := m.file.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// MapInput opens the local input file and maps it into memory. Where mapping
+// is unavailable, such as on some platforms, file systems or special files,
+// the file is read as usual instead. The file must not be truncated while it
+// is mapped.
+func MapInput(log *Logger, name string) (io.ReadCloser, error) {
+	f, err := os.OpenFile(localPath(name), os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input file %q: %v", name, err)
+	}
+
+	m, err := mapFile(f)
+	if err != nil {
+		log.Debugf("reading %s instead of mapping it: %v", name, err)
+		return f, nil
+	}
+
+	return m, nil
+}
+
+// mapFile maps the regular file into memory.
+func mapFile(f *os.File) (*MappedFile, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	switch size := info.Size(); {
+	case !info.Mode().IsRegular():
+		return nil, errors.New("not a regular file")
+
+	case size > math.MaxInt:
+		return nil, errors.New("file too large")
+
+	case size == 0:
+		// Empty files can't be mapped, and there is nothing to map.
+		return &MappedFile{Reader: bytes.NewReader(nil), file: f, unmap: func() error { return nil }}, nil
+	}
+
+	data, unmap, err := mmap(f, int(info.Size()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &MappedFile{Reader: bytes.NewReader(data), file: f, unmap: unmap}, nil
+}
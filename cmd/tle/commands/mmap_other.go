@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package commands
+
+import "os"
+
+// mmap fails, since the platform has no memory mapping the syscall package
+// supports, so the file is read as usual.
+func mmap(f *os.File, size int) ([]byte, func() error, error) {
+	return nil, nil, errMmapUnsupported
+}
@@ -0,0 +1,185 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drand/tlock"
+)
+
+// Mount exposes the plain data of an encrypted archive as a read-only
+// filesystem until the context is canceled. The archive is only decrypted
+// once its round is reached, and reading it before then fails with EPERM.
+func Mount(ctx context.Context, out io.Writer, args []string) error {
+	var networks listFlag
+	var v verbosity
+
+	fs := flag.NewFlagSet("mount", flag.ContinueOnError)
+	fs.Var(&networks, "n", "the drand API endpoint; can be repeated")
+	fs.Var(&networks, "network", "the drand API endpoint; can be repeated")
+	pinFile := fs.String("pin-file", defaultPinFile(), "the file recording the public key of each chain")
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := NewLogger(os.Stderr, v.level())
+
+	if fs.NArg() != 2 {
+		return errors.New("mount requires an ARCHIVE and a MOUNTPOINT")
+	}
+
+	archive, err := openArchive(ctx, log, networks, *pinFile, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	log.Infof("mounted %s at %s; %s unlocks at %s", fs.Arg(0), fs.Arg(1), archive.name, archive.unlock.Format(time.RFC3339))
+
+	return mountArchive(ctx, log, fs.Arg(1), archive)
+}
+
+// errMountUnsupported is returned by mountArchive on platforms without FUSE.
+var errMountUnsupported = errors.New("mount requires FUSE, which is only supported on Linux")
+
+// =============================================================================
+
+// lockedArchive serves the plain data of an encrypted archive to a mount,
+// which is decrypted once its round is reached and then kept open.
+type lockedArchive struct {
+	name   string
+	src    *os.File
+	size   int64
+	header tlock.Header
+	unlock time.Time
+	tlock  tlock.Tlock
+
+	mu     sync.Mutex
+	reader *tlock.ReaderAt
+}
+
+// openArchive opens the local encrypted archive and retrieves the network of
+// its chain, without decrypting it.
+func openArchive(ctx context.Context, log *Logger, networks []string, pinFile string, path string) (*lockedArchive, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open archive: %w", err)
+	}
+
+	archive, err := newLockedArchive(ctx, log, networks, pinFile, path, src)
+	if err != nil {
+		src.Close()
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+// newLockedArchive reads the header of the archive and retrieves the network
+// of its chain.
+func newLockedArchive(ctx context.Context, log *Logger, networks []string, pinFile string, path string, src *os.File) (*lockedArchive, error) {
+	info, err := src.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat archive: %w", err)
+	}
+
+	header, err := tlock.ReadHeader(io.NewSectionReader(src, 0, info.Size()))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(networks) == 0 {
+		networks = listFlag{defaultNetwork}
+	}
+
+	network, err := NetworkForHeader(ctx, log, networks, pinFile, header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lockedArchive{
+		name:   plainName(path, header.ContentType),
+		src:    src,
+		size:   info.Size(),
+		header: header,
+		unlock: network.RoundTime(header.RoundNumber).UTC(),
+		tlock:  tlock.New(network, tlock.WithLogger(log)),
+	}, nil
+}
+
+// plainName returns the name the plain data of the archive is exposed under:
+// the name of the archive without its .tle extension, followed by the
+// extension of a tar archive for encrypted directories.
+func plainName(path string, contentType string) string {
+	name := filepath.Base(path)
+	if trimmed := strings.TrimSuffix(name, encryptedExtension); trimmed != "" {
+		name = trimmed
+	}
+
+	switch contentType {
+	case contentTar:
+		name += ".tar"
+	case contentTarGzip:
+		name += ".tar.gz"
+	}
+
+	return name
+}
+
+// Open returns a reader providing random access to the plain data, which
+// fails with an error matching tlock.ErrTooEarly, telling the unlock time,
+// before the round is reached.
+func (a *lockedArchive) Open(ctx context.Context) (*tlock.ReaderAt, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.reader != nil {
+		return a.reader, nil
+	}
+
+	r, err := a.tlock.DecryptReaderAt(ctx, a.src, a.size)
+	if err != nil {
+		if errors.Is(err, tlock.ErrTooEarly) {
+			return nil, fmt.Errorf("%w: %s unlocks at %s", tlock.ErrTooEarly, a.name, a.unlock.Format(time.RFC3339))
+		}
+		return nil, err
+	}
+	a.reader = r
+
+	return r, nil
+}
+
+// Size returns the size of the plain data, which is only known once the
+// archive is decrypted and is 0 before then.
+func (a *lockedArchive) Size() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.reader == nil {
+		return 0
+	}
+	return a.reader.Size()
+}
+
+// Mode returns the permissions of the plain data, those recorded in the
+// header without the write bits, or read-only for everyone if none were.
+func (a *lockedArchive) Mode() fs.FileMode {
+	if a.header.FileMode == 0 {
+		return 0o444
+	}
+	return a.header.FileMode.Perm() &^ 0o222
+}
+
+// Close closes the archive.
+func (a *lockedArchive) Close() error {
+	return a.src.Close()
+}
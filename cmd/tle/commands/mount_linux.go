@@ -0,0 +1,139 @@
+//go:build linux
+// +build linux
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"time"
+
+	"github.com/drand/tlock"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// mountArchive mounts a read-only filesystem holding the plain data of the
+// archive at the mount point, and unmounts it once the context is canceled.
+// The mount syscall is used when running as root, fusermount otherwise.
+func mountArchive(ctx context.Context, log *Logger, mountpoint string, archive *lockedArchive) error {
+	// The size of the plain data is only known once the archive is
+	// decrypted, so the kernel mustn't keep the attributes reported before.
+	var noCache time.Duration
+
+	server, err := fs.Mount(mountpoint, &archiveRoot{archive: archive, log: log}, &fs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName:      archive.src.Name(),
+			Name:        "tle",
+			Options:     []string{"ro"},
+			DirectMount: true,
+		},
+		AttrTimeout: &noCache,
+	})
+	if err != nil {
+		return fmt.Errorf("mount %s: %w", mountpoint, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		server.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	if err := server.Unmount(); err != nil {
+		return fmt.Errorf("unmount %s: %w", mountpoint, err)
+	}
+	<-done
+
+	return nil
+}
+
+// archiveRoot is the root directory of a mounted archive, which holds the
+// plain data as a single file.
+type archiveRoot struct {
+	fs.Inode
+	archive *lockedArchive
+	log     *Logger
+}
+
+var (
+	_ fs.NodeOnAdder   = (*archiveRoot)(nil)
+	_ fs.NodeGetattrer = (*archiveRoot)(nil)
+)
+
+// OnAdd adds the file holding the plain data to the root directory.
+func (r *archiveRoot) OnAdd(ctx context.Context) {
+	file := r.NewPersistentInode(ctx, &plainFile{archive: r.archive, log: r.log}, fs.StableAttr{Mode: fuse.S_IFREG})
+	r.AddChild(r.archive.name, file, false)
+}
+
+// Getattr reports the root directory as read-only.
+func (r *archiveRoot) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = 0o555
+	return 0
+}
+
+// plainFile is the file holding the plain data of a mounted archive, which
+// can't be opened before the round of the archive is reached. Its
+// modification time is the unlock time.
+type plainFile struct {
+	fs.Inode
+	archive *lockedArchive
+	log     *Logger
+}
+
+var (
+	_ fs.NodeGetattrer = (*plainFile)(nil)
+	_ fs.NodeOpener    = (*plainFile)(nil)
+	_ fs.NodeReader    = (*plainFile)(nil)
+)
+
+// Getattr reports the permissions and size of the plain data, and the unlock
+// time as modification time.
+func (f *plainFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = uint32(f.archive.Mode())
+	out.Size = uint64(f.archive.Size())
+	out.SetTimes(nil, &f.archive.unlock, &f.archive.unlock)
+	return 0
+}
+
+// Open decrypts the archive the first time it's opened after its round is
+// reached. Before then it fails with EPERM and logs the unlock time.
+func (f *plainFile) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+
+	r, err := f.archive.Open(ctx)
+	switch {
+	case errors.Is(err, tlock.ErrTooEarly):
+		f.log.Infof("open %s: %v", f.archive.name, err)
+		return nil, 0, syscall.EPERM
+	case err != nil:
+		f.log.Infof("open %s: %v", f.archive.name, err)
+		return nil, 0, syscall.EIO
+	}
+
+	return r, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+// Read reads the plain data at the offset, decrypting only the chunks
+// covering it.
+func (f *plainFile) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	n, err := fh.(*tlock.ReaderAt).ReadAt(dest, off)
+	if err != nil && !errors.Is(err, io.EOF) {
+		f.log.Infof("read %s: %v", f.archive.name, err)
+		return nil, syscall.EIO
+	}
+
+	return fuse.ReadResultData(dest[:n]), 0
+}
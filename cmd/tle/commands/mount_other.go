@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package commands
+
+import "context"
+
+// mountArchive fails, since FUSE mounts are only supported on Linux.
+func mountArchive(ctx context.Context, log *Logger, mountpoint string, archive *lockedArchive) error {
+	return errMountUnsupported
+}
@@ -0,0 +1,189 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/networks/http"
+)
+
+// knownNetwork describes a public drand network that tle can select from
+// the chain hash recorded in the input.
+type knownNetwork struct {
+	Name      string
+	ChainHash string
+	Endpoints []string
+}
+
+// registry lists the public drand networks known to tle, along with the
+// endpoints serving them.
+var registry = []knownNetwork{
+	{
+		Name:      "mainnet",
+		ChainHash: "dbd506d6ef76e5f386f41c651dcb808c5bcbd75471cc4eafa3f4df7ad4e4c493",
+		Endpoints: []string{"https://api.drand.sh/", "https://drand.cloudflare.com/"},
+	},
+	{
+		Name:      "quicknet",
+		ChainHash: "52db9ba70e0cc0f6eaf7803dd07447a1f5477735fd3f661792ba94600c84e971",
+		Endpoints: []string{"https://api.drand.sh/", "https://drand.cloudflare.com/"},
+	},
+	{
+		Name:      "testnet",
+		ChainHash: defaultChain,
+		Endpoints: []string{"https://pl-us.testnet.drand.sh/", "https://testnet0-api.drand.cloudflare.com/"},
+	},
+}
+
+// endpointsFor returns the endpoints to try for the chain, starting with the
+// configured ones, followed by the registry endpoints serving the chain and
+// then the hints recorded in the input. A chain that isn't in the registry is
+// looked up on every known endpoint after the hints.
+func endpointsFor(configured []string, chainHash string, hints ...string) []string {
+	var candidates []string
+	for _, kn := range registry {
+		if kn.ChainHash == chainHash {
+			candidates = append(candidates, kn.Endpoints...)
+			break
+		}
+	}
+	known := candidates != nil
+
+	candidates = append(candidates, hints...)
+	if !known {
+		for _, kn := range registry {
+			candidates = append(candidates, kn.Endpoints...)
+		}
+	}
+
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, h := range append(configured, candidates...) {
+		if !seen[h] {
+			seen[h] = true
+			hosts = append(hosts, h)
+		}
+	}
+
+	return hosts
+}
+
+// networkName returns the registry name of the chain, or an empty string
+// when the chain is unknown.
+func networkName(chainHash string) string {
+	for _, kn := range registry {
+		if kn.ChainHash == chainHash {
+			return kn.Name
+		}
+	}
+
+	return ""
+}
+
+// PeekHeader reads the header of the encrypted input. It returns a reader
+// that still provides the complete input.
+func PeekHeader(src io.Reader) (tlock.Header, io.Reader, error) {
+	var buf bytes.Buffer
+	header, err := tlock.ReadHeader(io.TeeReader(src, &buf))
+	if err != nil {
+		return tlock.Header{}, nil, err
+	}
+
+	return header, io.MultiReader(&buf, src), nil
+}
+
+// NetworkForChain constructs a network for the chain. The specified endpoints
+// are tried first, followed by the registry endpoints serving the chain. The
+// public key of the chain is checked against the pin file.
+func NetworkForChain(ctx context.Context, log *Logger, hosts []string, pinFile string, chainHash string, roundNumber uint64) (*http.Network, error) {
+	if name := networkName(chainHash); name != "" {
+		log.Debugf("the input is locked to %s", name)
+	}
+
+	return ProbeNetworks(ctx, log, endpointsFor(hosts, chainHash), pinFile, chainHash, roundNumber)
+}
+
+// NetworkForHeader constructs a network for the chain recorded in the header.
+// The endpoints suggested by the header are tried after the specified and
+// registry endpoints. They aren't trusted: the chain information they serve
+// has to match the chain hash, whose public key is then checked against the
+// pin file.
+func NetworkForHeader(ctx context.Context, log *Logger, hosts []string, pinFile string, header tlock.Header) (*http.Network, error) {
+	if name := networkName(header.ChainHash); name != "" {
+		log.Debugf("the input is locked to %s", name)
+	}
+	if len(header.EndpointHints) > 0 {
+		log.Debugf("the input suggests the endpoints %v", header.EndpointHints)
+	}
+
+	return ProbeNetworks(ctx, log, endpointsFor(hosts, header.ChainHash, header.EndpointHints...), pinFile, header.ChainHash, header.RoundNumber)
+}
+
+// ProbeNetworks constructs a network for the chain from the first endpoint
+// that serves it. When the round number isn't zero, an endpoint that doesn't
+// serve the round yet is skipped in favor of the next one, and only used if
+// no endpoint serves the round. When no endpoint serves the chain, the error
+// holds the failure of each endpoint as an http.EndpointError. Every network
+// of the commands is constructed here, so the public key of the chain is
+// always checked against the pin file, which an empty path skips.
+func ProbeNetworks(ctx context.Context, log *Logger, hosts []string, pinFile string, chainHash string, roundNumber uint64) (*http.Network, error) {
+	var fallback *http.Network
+	var fallbackHost string
+	var errs http.EndpointErrors
+
+	for _, h := range hosts {
+		log.Debugf("connecting to %s for chain %s", h, chainHash)
+
+		network, err := http.NewNetworkContext(ctx, h, chainHash)
+		if err != nil {
+			switch {
+			case ctx.Err() != nil:
+				return nil, ctx.Err()
+			case len(hosts) == 1:
+				return nil, err
+			}
+			errs = append(errs, err)
+			continue
+		}
+
+		if roundNumber != 0 && len(hosts) > 1 {
+			if available, _, err := network.IsAvailableContext(ctx, roundNumber); !available {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				if err != nil {
+					log.Debugf("can't tell whether round %d is served: %v", roundNumber, err)
+				} else {
+					log.Debugf("%s doesn't serve round %d", h, roundNumber)
+				}
+				if fallback == nil {
+					fallback, fallbackHost = network, h
+				}
+				continue
+			}
+		}
+
+		log.Debugf("using %s", h)
+		return pinned(pinFile, network)
+	}
+
+	if fallback != nil {
+		log.Debugf("using %s", fallbackHost)
+		return pinned(pinFile, fallback)
+	}
+
+	return nil, fmt.Errorf("no endpoint serves chain %s:\n%w", chainHash, errs)
+}
+
+// pinned returns the network once its public key is checked against the pin
+// file.
+func pinned(pinFile string, network *http.Network) (*http.Network, error) {
+	if err := VerifyPin(pinFile, network.ChainHash(), network.PublicKey()); err != nil {
+		return nil, err
+	}
+
+	return network, nil
+}
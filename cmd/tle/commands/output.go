@@ -0,0 +1,174 @@
+package commands
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/drand/tlock"
+)
+
+// Output writes to a temporary file next to the destination path and only
+// moves it into place once the operation succeeded. This makes sure an
+// interrupted or failed operation never leaves a partial file behind. The
+// output is created with 0600 permissions regardless of the umask.
+type Output struct {
+	*os.File
+	path      string
+	committed bool
+
+	// keep is set for resumable outputs, whose partial file is kept when
+	// the operation fails so it can be continued.
+	keep bool
+
+	// noClobber is set for outputs that must not replace an existing file.
+	noClobber bool
+}
+
+// CreateOutput constructs an output for the file at the specified path.
+func CreateOutput(path string) (*Output, error) {
+	path = localPath(path)
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	out := Output{
+		File: f,
+		path: path,
+	}
+
+	return &out, nil
+}
+
+// CreateNewOutput works like CreateOutput but refuses to replace an existing
+// file, both when the output is created and when it's committed.
+func CreateNewOutput(path string) (*Output, error) {
+	if err := checkNotExist(localPath(path)); err != nil {
+		return nil, err
+	}
+
+	out, err := CreateOutput(path)
+	if err != nil {
+		return nil, err
+	}
+	out.noClobber = true
+
+	return out, nil
+}
+
+// checkNotExist returns an error wrapping fs.ErrExist if the path exists.
+func checkNotExist(path string) error {
+	if _, err := os.Lstat(path); err == nil {
+		return fmt.Errorf("output %q: %w; use -o/--output to choose the output", path, fs.ErrExist)
+	}
+
+	return nil
+}
+
+// Commit flushes the written data to disk and moves the temporary file to the
+// destination path, replacing any existing file unless the output was created
+// with CreateNewOutput.
+func (o *Output) Commit() error {
+	if err := o.File.Sync(); err != nil {
+		return fmt.Errorf("sync output: %w", err)
+	}
+
+	if err := o.File.Close(); err != nil {
+		return fmt.Errorf("close output: %w", err)
+	}
+
+	if o.noClobber {
+		if err := checkNotExist(o.path); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(o.File.Name(), o.path); err != nil {
+		return fmt.Errorf("rename output: %w", err)
+	}
+
+	o.committed = true
+	return nil
+}
+
+// Abort removes the temporary file unless the output was committed or is
+// resumable. It is safe to call Abort after Commit, which makes it suitable
+// for a defer.
+func (o *Output) Abort() {
+	if o.committed {
+		return
+	}
+
+	o.File.Close()
+	if !o.keep {
+		os.Remove(o.File.Name())
+	}
+}
+
+// encryptedExtension is the extension of the files tle encrypts to when no
+// output is given.
+const encryptedExtension = ".tle"
+
+// DefaultOutput returns the output derived from the input file when no output
+// is given and the result would be written to a terminal: the input with the
+// .tle extension appended when encrypting and removed when decrypting, like
+// gzip does. It returns an empty string when the input isn't a local file,
+// when decrypting an input without the extension, and for records, which are
+// meant to be read.
+func DefaultOutput(flags Flags) string {
+	input := flags.Input
+	if flags.Output != "" || flags.Records != "" || flags.Dir != "" || input == "" || input == "-" || isURL(input) {
+		return ""
+	}
+
+	if !flags.Decrypt {
+		return input + encryptedExtension
+	}
+
+	output := strings.TrimSuffix(input, encryptedExtension)
+	if output == input || output == "" || strings.HasSuffix(output, "/") || strings.HasSuffix(output, string(filepath.Separator)) {
+		return ""
+	}
+
+	return output
+}
+
+// StdoutIsTerminal reports whether the standard output is a terminal rather
+// than a file or a pipe.
+func StdoutIsTerminal() bool {
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// RestoreMode applies the permissions recorded in the header of the encrypted
+// input file to the output. Nothing is changed if no permissions were recorded.
+func RestoreMode(out *Output, input string) error {
+	f, err := os.Open(localPath(input))
+	if err != nil {
+		return fmt.Errorf("failed to open input file %q: %v", input, err)
+	}
+	defer f.Close()
+
+	header, err := tlock.ReadHeader(f)
+	if err != nil {
+		return err
+	}
+
+	if header.FileMode == 0 {
+		return nil
+	}
+
+	if err := out.Chmod(header.FileMode); err != nil {
+		return fmt.Errorf("restore mode: %w", err)
+	}
+
+	return nil
+}
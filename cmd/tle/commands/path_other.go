@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package commands
+
+// localPath prepares a path given on the command line for use with the os
+// package. Paths need no adjustment outside of Windows.
+func localPath(name string) string {
+	return name
+}
@@ -0,0 +1,23 @@
+//go:build windows
+// +build windows
+
+package commands
+
+import "path/filepath"
+
+// localPath prepares a path given on the command line for use with the os
+// package. The os package only applies the \\?\ prefix that lifts the
+// MAX_PATH limit to absolute paths, so relative paths are made absolute.
+// UNC paths are already absolute and are left alone.
+func localPath(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return name
+	}
+
+	return abs
+}
@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/drand/kyber"
+)
+
+// ErrPinMismatch represents an error when a network serves a public key that
+// differs from the one recorded the first time its chain was used.
+var ErrPinMismatch = errors.New("public key does not match the pinned key for this chain")
+
+// defaultPinFile returns the location of the pin file set by the TLE_PINFILE
+// environment variable, or inside the user's configuration directory. An
+// empty string disables pinning.
+func defaultPinFile() string {
+	if path, exists := os.LookupEnv("TLE_PINFILE"); exists {
+		return path
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "tlock", "known_chains")
+}
+
+// VerifyPin implements trust on first use for chain public keys, similar to
+// how SSH handles known_hosts. The first time a chain hash is seen its public
+// key is recorded in the pin file. Subsequent calls verify the network still
+// serves the same public key for that chain hash.
+func VerifyPin(path string, chainHash string, publicKey kyber.Point) error {
+	if path == "" {
+		return nil
+	}
+
+	b, err := publicKey.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("marshal public key: %w", err)
+	}
+	key := hex.EncodeToString(b)
+
+	pins, err := readPins(path)
+	if err != nil {
+		return fmt.Errorf("read pin file: %w", err)
+	}
+
+	if pinned, exists := pins[chainHash]; exists {
+		if pinned != key {
+			return fmt.Errorf("chain %s: %w", chainHash, ErrPinMismatch)
+		}
+		return nil
+	}
+
+	if err := appendPin(path, chainHash, key); err != nil {
+		return fmt.Errorf("write pin file: %w", err)
+	}
+
+	return nil
+}
+
+// readPins reads the chain hash to public key mappings stored in the pin file.
+// A missing file is treated as an empty set of pins.
+func readPins(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return map[string]string{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	pins := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed entry on line %d", line)
+		}
+		pins[fields[0]] = fields[1]
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return pins, nil
+}
+
+// appendPin records a new chain hash to public key mapping in the pin file.
+func appendPin(path string, chainHash string, key string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(f, "%s %s\n", chainHash, key); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,84 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/drand/tlock"
+	"gopkg.in/yaml.v2"
+)
+
+// policyFile describes the YAML representation of a tlock.Policy. Durations
+// use the syntax of -D/--duration.
+type policyFile struct {
+	MinDuration      string   `yaml:"min_duration"`
+	MaxDuration      string   `yaml:"max_duration"`
+	AllowedChains    []string `yaml:"allowed_chains"`
+	RequireArmor     bool     `yaml:"require_armor"`
+	ForbidPastRounds bool     `yaml:"forbid_past_rounds"`
+}
+
+// defaultPolicyFile returns the location of the policy file inside the
+// user's configuration directory.
+func defaultPolicyFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "tlock", "policy.yaml")
+}
+
+// LoadPolicy reads the policy enforced when encrypting from the file at the
+// specified path, or from the default location if the path is empty. A
+// missing file at the default location means there is no policy, and nil is
+// returned.
+func LoadPolicy(path string, now time.Time) (*tlock.Policy, error) {
+	optional := path == ""
+	if optional {
+		if path = defaultPolicyFile(); path == "" {
+			return nil, nil
+		}
+	}
+
+	b, err := os.ReadFile(localPath(path))
+	if err != nil {
+		if optional && errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read policy: %w", err)
+	}
+
+	var pf policyFile
+	if err := yaml.UnmarshalStrict(b, &pf); err != nil {
+		return nil, fmt.Errorf("parse policy %q: %w", path, err)
+	}
+
+	p := tlock.Policy{
+		AllowedChains:    pf.AllowedChains,
+		RequireArmor:     pf.RequireArmor,
+		ForbidPastRounds: pf.ForbidPastRounds,
+	}
+
+	if pf.MinDuration != "" {
+		if p.MinDuration, err = tlock.ParseDuration(now, pf.MinDuration); err != nil {
+			return nil, fmt.Errorf("policy %q: min_duration: %w", path, err)
+		}
+	}
+
+	if pf.MaxDuration != "" {
+		if p.MaxDuration, err = tlock.ParseDuration(now, pf.MaxDuration); err != nil {
+			return nil, fmt.Errorf("policy %q: max_duration: %w", path, err)
+		}
+	}
+
+	if p.MaxDuration != 0 && p.MinDuration > p.MaxDuration {
+		return nil, fmt.Errorf("policy %q: min_duration exceeds max_duration", path)
+	}
+
+	return &p, nil
+}
@@ -0,0 +1,176 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/relay"
+)
+
+// Push uploads an encrypted file to a relay, which serves it once its round is
+// reached. The upload id is recorded next to the file until the upload
+// completes, so running the same command again after an interruption resumes
+// the upload.
+func Push(ctx context.Context, out io.Writer, args []string) error {
+	var v verbosity
+
+	fs := flag.NewFlagSet("push", flag.ContinueOnError)
+	relayURL := fs.String("relay", os.Getenv("TLE_RELAY"), "the URL of the relay")
+	token := fs.String("token", os.Getenv("TLE_RELAY_TOKEN"), "the token identifying the owner of the items")
+	asJSON := jsonFlag(fs)
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := NewLogger(os.Stderr, v.level())
+
+	if fs.NArg() != 1 || fs.Arg(0) == "-" {
+		return errors.New("push requires a single INPUT file")
+	}
+	if *relayURL == "" {
+		return errors.New("push requires --relay or TLE_RELAY")
+	}
+
+	input := localPath(fs.Arg(0))
+	f, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header, err := tlock.ReadHeader(f)
+	if err != nil {
+		return err
+	}
+
+	client := relay.NewClient(*relayURL, relay.WithToken(*token))
+	state := input + ".upload"
+
+	b, err := os.ReadFile(state)
+	switch {
+	case err == nil:
+		log.Infof("resuming upload %s", strings.TrimSpace(string(b)))
+
+	case errors.Is(err, os.ErrNotExist):
+		u, err := client.StartUpload(ctx, header.RoundNumber)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(state, []byte(u.ID+"\n"), 0600); err != nil {
+			return err
+		}
+		b = []byte(u.ID)
+
+	default:
+		return err
+	}
+
+	item, err := client.ResumeUpload(ctx, strings.TrimSpace(string(b)), f)
+	if errors.Is(err, relay.ErrUploadNotFound) {
+		os.Remove(state)
+		return fmt.Errorf("%w; run push again to start over", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(state); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return json.NewEncoder(out).Encode(item)
+	}
+
+	fmt.Fprintf(out, "%s\n", item.ID)
+	log.Infof("released at round %d (%s)", item.RoundNumber, item.UnlockTime.Local().Format(time.RFC1123))
+	return nil
+}
+
+// Pull downloads an item from a relay once it's released, or lists the items
+// pushed with the token that aren't released yet.
+func Pull(ctx context.Context, out io.Writer, args []string) error {
+	var v verbosity
+
+	fs := flag.NewFlagSet("pull", flag.ContinueOnError)
+	relayURL := fs.String("relay", os.Getenv("TLE_RELAY"), "the URL of the relay")
+	token := fs.String("token", os.Getenv("TLE_RELAY_TOKEN"), "the token identifying the owner of the items")
+	output := fs.String("o", "", "the path to the encrypted file")
+	fs.StringVar(output, "output", "", "the path to the encrypted file")
+	list := fs.Bool("list", false, "list the pending items instead of downloading one")
+	asJSON := jsonFlag(fs)
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := NewLogger(os.Stderr, v.level())
+
+	if *relayURL == "" {
+		return errors.New("pull requires --relay or TLE_RELAY")
+	}
+
+	client := relay.NewClient(*relayURL, relay.WithToken(*token))
+
+	if *list {
+		if fs.NArg() != 0 {
+			return errors.New("--list can't be used with ID")
+		}
+
+		items, err := client.Items(ctx, true)
+		if err != nil {
+			return err
+		}
+
+		return writeItems(out, *asJSON, items)
+	}
+
+	if fs.NArg() != 1 {
+		return errors.New("pull requires a single ID or --list")
+	}
+
+	if *output == "" || *output == "-" {
+		return client.Pull(ctx, fs.Arg(0), out)
+	}
+
+	f, err := CreateOutput(*output)
+	if err != nil {
+		return fmt.Errorf("failed to open output file %q: %v", *output, err)
+	}
+	defer f.Abort()
+
+	if err := client.Pull(ctx, fs.Arg(0), f); err != nil {
+		return err
+	}
+
+	if err := f.Commit(); err != nil {
+		return err
+	}
+
+	log.Infof("pulled item %s", fs.Arg(0))
+	return nil
+}
+
+// writeItems displays the items of a relay.
+func writeItems(out io.Writer, asJSON bool, items []relay.Item) error {
+	if asJSON {
+		return json.NewEncoder(out).Encode(items)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID\tROUND\tUNLOCKS\tSIZE\n")
+
+	for _, item := range items {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\n", item.ID, item.RoundNumber, item.UnlockTime.Format(time.RFC3339), item.Size)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,302 @@
+package commands
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/drand/drand/chain"
+	"github.com/drand/tlock/networks/beacon"
+	"github.com/drand/tlock/networks/http"
+)
+
+// NotaryReceipt attests that encrypted data with the digest was locked to the
+// round of the chain. The creation beacon is the latest round of the chain
+// when the receipt was created, which proves the receipt wasn't created before
+// that round was published. The receipt is signed, and can be verified
+// without the encrypted data.
+type NotaryReceipt struct {
+	SHA256            string `json:"sha256"`
+	Round             uint64 `json:"round"`
+	ChainHash         string `json:"chain_hash"`
+	CreationRound     uint64 `json:"creation_round"`
+	CreationSignature string `json:"creation_signature"`
+	PublicKey         string `json:"public_key"`
+	Signature         string `json:"signature,omitempty"`
+}
+
+// NewReceipt creates a receipt for encrypted data with the digest locked to
+// the round, signed with the key. The creation beacon is retrieved from the
+// network.
+func NewReceipt(ctx context.Context, clock Clock, network *http.Network, sum string, roundNumber uint64, key ed25519.PrivateKey) (NotaryReceipt, error) {
+	creation := network.RoundNumber(clock.Now())
+
+	signature, err := network.SignatureContext(ctx, creation)
+	if err != nil {
+		if ctx.Err() != nil {
+			return NotaryReceipt{}, ctx.Err()
+		}
+		return NotaryReceipt{}, fmt.Errorf("fetch creation beacon of round %d: %w", creation, err)
+	}
+
+	r := NotaryReceipt{
+		SHA256:            sum,
+		Round:             roundNumber,
+		ChainHash:         network.ChainHash(),
+		CreationRound:     creation,
+		CreationSignature: hex.EncodeToString(signature),
+		PublicKey:         hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+	}
+
+	msg, err := json.Marshal(r)
+	if err != nil {
+		return NotaryReceipt{}, err
+	}
+	r.Signature = hex.EncodeToString(ed25519.Sign(key, msg))
+
+	return r, nil
+}
+
+// WriteReceipt writes the receipt to the file at the specified path.
+func WriteReceipt(path string, r NotaryReceipt) error {
+	out, err := CreateOutput(path)
+	if err != nil {
+		return fmt.Errorf("failed to open receipt file %q: %w", path, err)
+	}
+	defer out.Abort()
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		return err
+	}
+
+	return out.Commit()
+}
+
+// ReadReceipt reads the receipt at the specified path.
+func ReadReceipt(path string) (NotaryReceipt, error) {
+	b, err := os.ReadFile(localPath(path))
+	if err != nil {
+		return NotaryReceipt{}, fmt.Errorf("read receipt: %w", err)
+	}
+
+	var r NotaryReceipt
+	if err := json.Unmarshal(b, &r); err != nil {
+		return NotaryReceipt{}, fmt.Errorf("parse receipt: %w", err)
+	}
+
+	return r, nil
+}
+
+// SigningKey returns the key read from the file at the specified path, or a
+// new key if the path is empty.
+func SigningKey(path string) (ed25519.PrivateKey, error) {
+	if path != "" {
+		return ReadSigningKey(path)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	return key, err
+}
+
+// Verify checks the signature of the receipt and its creation beacon against
+// the chain, and returns the time at which the creation beacon was published.
+// If signer isn't empty, the receipt has to be signed by that hex encoded
+// public key.
+func (r NotaryReceipt) Verify(info *chain.Info, signer string) (time.Time, error) {
+	if info.HashString() != r.ChainHash {
+		return time.Time{}, fmt.Errorf("receipt is for chain %s, not %s", r.ChainHash, info.HashString())
+	}
+
+	publicKey, err := hex.DecodeString(r.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return time.Time{}, fmt.Errorf("receipt public key %q is invalid", r.PublicKey)
+	}
+
+	signature, err := hex.DecodeString(r.Signature)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("decoding receipt signature: %w", err)
+	}
+
+	unsigned := r
+	unsigned.Signature = ""
+	msg, err := json.Marshal(unsigned)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if !ed25519.Verify(publicKey, msg, signature) {
+		return time.Time{}, errors.New("receipt signature is invalid")
+	}
+	if signer != "" && !strings.EqualFold(signer, r.PublicKey) {
+		return time.Time{}, fmt.Errorf("receipt is signed by %s, not %s", r.PublicKey, signer)
+	}
+
+	creation, err := hex.DecodeString(r.CreationSignature)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("decoding creation beacon: %w", err)
+	}
+
+	created, err := beacon.Verify(info, r.CreationRound, creation)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("creation beacon: %w", err)
+	}
+
+	if r.Round < r.CreationRound {
+		return time.Time{}, fmt.Errorf("round %d is before the creation round %d", r.Round, r.CreationRound)
+	}
+
+	return created, nil
+}
+
+// =============================================================================
+
+// Receipt runs the commands that manage receipts, written when encrypting
+// with --receipt.
+func Receipt(ctx context.Context, out io.Writer, args []string) error {
+	var v verbosity
+
+	fs := flag.NewFlagSet("receipt", flag.ContinueOnError)
+	asJSON := jsonFlag(fs)
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.Arg(0) == "verify" {
+		return verifyReceipt(ctx, out, v, *asJSON, fs.Args()[1:])
+	}
+
+	return errors.New("receipt requires a command: verify")
+}
+
+// receiptVerification describes the outcome of receipt verify.
+type receiptVerification struct {
+	SHA256       string     `json:"sha256"`
+	RoundNumber  uint64     `json:"round"`
+	ChainHash    string     `json:"chain_hash"`
+	Signer       string     `json:"signer"`
+	Valid        bool       `json:"valid"`
+	Created      *time.Time `json:"created,omitempty"`
+	UnlockTime   *time.Time `json:"unlock_time,omitempty"`
+	InputMatches *bool      `json:"input_matches,omitempty"`
+	Error        string     `json:"error,omitempty"`
+}
+
+// verifyReceipt checks a receipt against its chain and, if an input is
+// given, that the input is the encrypted data the receipt is about.
+func verifyReceipt(ctx context.Context, out io.Writer, v verbosity, asJSON bool, args []string) error {
+	var networks listFlag
+
+	fs := flag.NewFlagSet("receipt verify", flag.ContinueOnError)
+	fs.Var(&networks, "n", "the drand API endpoint; can be repeated")
+	fs.Var(&networks, "network", "the drand API endpoint; can be repeated")
+	infoFile := fs.String("chain-info", "", "the file holding the chain information")
+	signer := fs.String("signer", "", "the hex encoded public key that has to sign the receipt")
+	pinFile := fs.String("pin-file", defaultPinFile(), "the file recording the public key of each chain")
+	fs.BoolVar(&asJSON, "json", asJSON, "print machine readable JSON output")
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := NewLogger(os.Stderr, v.level())
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return errors.New("receipt verify requires a RECEIPT and an optional INPUT")
+	}
+
+	r, err := ReadReceipt(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	info, err := chainInfo(ctx, log, networks, *pinFile, r.ChainHash, *infoFile)
+	if err != nil {
+		return err
+	}
+
+	result := receiptVerification{
+		SHA256:      r.SHA256,
+		RoundNumber: r.Round,
+		ChainHash:   r.ChainHash,
+		Signer:      r.PublicKey,
+	}
+
+	created, verr := r.Verify(info, *signer)
+	if verr == nil {
+		unlock := time.Unix(chain.TimeOfRound(info.Period, info.GenesisTime, r.Round), 0).UTC()
+		created = created.UTC()
+		result.Valid = true
+		result.Created = &created
+		result.UnlockTime = &unlock
+	}
+
+	if verr == nil && fs.NArg() == 2 {
+		sum, err := digestInput(ctx, fs.Arg(1))
+		if err != nil {
+			return err
+		}
+
+		matches := sum == r.SHA256
+		result.InputMatches = &matches
+		if !matches {
+			verr = fmt.Errorf("input %s doesn't match the receipt", fs.Arg(1))
+		}
+	}
+
+	if verr != nil {
+		result.Valid = false
+		result.Error = verr.Error()
+	}
+
+	switch {
+	case asJSON:
+		if err := json.NewEncoder(out).Encode(result); err != nil {
+			return err
+		}
+
+	case result.Valid:
+		fmt.Fprintf(out, "sha256:   %s\n", result.SHA256)
+		fmt.Fprintf(out, "round:    %d\n", result.RoundNumber)
+		fmt.Fprintf(out, "chain:    %s\n", result.ChainHash)
+		fmt.Fprintf(out, "signer:   %s\n", result.Signer)
+		fmt.Fprintf(out, "created:  after %s\n", created.Format(time.RFC3339))
+		fmt.Fprintf(out, "unlocks:  %s\n", result.UnlockTime.Format(time.RFC3339))
+		if result.InputMatches != nil {
+			fmt.Fprintf(out, "input:    matches\n")
+		}
+	}
+
+	if verr != nil {
+		return fmt.Errorf("receipt is invalid: %w", verr)
+	}
+
+	return nil
+}
+
+// digestInput returns the digest of the input, which can be a file, "-" for
+// stdin, or a URL.
+func digestInput(ctx context.Context, name string) (string, error) {
+	src, err := OpenInput(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	d := NewDigest()
+	if _, err := io.Copy(io.Discard, d.Reader(src)); err != nil {
+		return "", err
+	}
+
+	return d.Sum(), nil
+}
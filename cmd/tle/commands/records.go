@@ -0,0 +1,195 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/networks/http"
+)
+
+// These are the formats of the records read and written with --records.
+const (
+	// RecordLines reads every line as a record and writes every encrypted
+	// record as a line of base64.
+	RecordLines = "lines"
+
+	// RecordBinary reads and writes records prefixed with their length as a
+	// 4 byte big endian integer.
+	RecordBinary = "binary"
+)
+
+// maxRecordSize is the size of the largest record, which is held in memory.
+const maxRecordSize = 64 << 20
+
+// recordCacheSize is the number of rounds whose signature is kept while
+// decrypting records, since consecutive records are often locked to the same
+// round.
+const recordCacheSize = 128
+
+// validRecords reports whether the format of records is known.
+func validRecords(format string) bool {
+	return format == RecordLines || format == RecordBinary
+}
+
+// EncryptRecords encrypts every record of the source independently and writes
+// them to the destination as they are read, so records can be released
+// gradually. The round of every record is selected by the flags when the
+// record is read, which locks every record for the same duration. The round
+// of the last record is returned.
+func EncryptRecords(ctx context.Context, log *Logger, clock Clock, flags Flags, dst io.Writer, src io.Reader, network *http.Network) (uint64, error) {
+	r := recordReader{format: flags.Records, src: bufio.NewReader(src)}
+
+	var roundNumber uint64
+	for n := 1; ; n++ {
+		record, err := r.next()
+		if errors.Is(err, io.EOF) {
+			return roundNumber, nil
+		}
+		if err != nil {
+			return roundNumber, fmt.Errorf("record %d: %w", n, err)
+		}
+
+		if roundNumber, err = selectRound(clock.Now(), flags, network); err != nil {
+			return roundNumber, fmt.Errorf("record %d: %w", n, err)
+		}
+
+		var encrypted bytes.Buffer
+		if err := encryptRound(ctx, log, clock, flags, &encrypted, bytes.NewReader(record), network, roundNumber); err != nil {
+			return roundNumber, fmt.Errorf("record %d: %w", n, err)
+		}
+		log.Debugf("record %d is locked to round %d", n, roundNumber)
+
+		record = encrypted.Bytes()
+		if flags.Records == RecordLines {
+			record = []byte(base64.StdEncoding.EncodeToString(record))
+		}
+
+		if err := writeRecord(dst, flags.Records, record); err != nil {
+			return roundNumber, err
+		}
+	}
+}
+
+// DecryptRecords decrypts the records of the source written by EncryptRecords
+// and writes them to the destination in the format they were read in. The
+// records that can't be decrypted yet are skipped and counted, so the source
+// can be decrypted again later to release more of them.
+func DecryptRecords(ctx context.Context, log *Logger, clock Clock, flags Flags, dst io.Writer, src io.Reader, network tlock.Network) error {
+	r := recordReader{format: flags.Records, src: bufio.NewReader(src)}
+	opts := append(Options(log, clock, flags), tlock.WithSignatureCache(tlock.NewSignatureCache(recordCacheSize)))
+	tl := tlock.New(network, opts...)
+
+	var locked int
+	for n := 1; ; n++ {
+		record, err := r.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("record %d: %w", n, err)
+		}
+
+		if flags.Records == RecordLines {
+			if record, err = base64.StdEncoding.DecodeString(string(record)); err != nil {
+				return fmt.Errorf("record %d: %w", n, err)
+			}
+		}
+
+		var plain bytes.Buffer
+		err = tl.DecryptContext(ctx, &plain, bytes.NewReader(record))
+		switch {
+		case errors.Is(err, tlock.ErrTooEarly):
+			locked++
+			log.Debugf("record %d: %v", n, err)
+			continue
+		case err != nil:
+			return fmt.Errorf("record %d: %w", n, err)
+		}
+
+		if err := writeRecord(dst, flags.Records, plain.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	if locked > 0 {
+		log.Infof("%d records can't be decrypted yet", locked)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// recordReader reads the records of a source in the specified format.
+type recordReader struct {
+	format string
+	src    *bufio.Reader
+}
+
+// next returns the next record, or io.EOF once there are no more records.
+// Lines don't include their line ending.
+func (r recordReader) next() ([]byte, error) {
+	switch r.format {
+	case RecordBinary:
+		var size [4]byte
+		if _, err := io.ReadFull(r.src, size[:]); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, fmt.Errorf("record length truncated: %w", err)
+			}
+			return nil, err
+		}
+
+		n := binary.BigEndian.Uint32(size[:])
+		if n > maxRecordSize {
+			return nil, fmt.Errorf("record of %d bytes is too large", n)
+		}
+
+		record := make([]byte, n)
+		if _, err := io.ReadFull(r.src, record); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("record truncated: %w", err)
+		}
+		return record, nil
+
+	default:
+		line, err := r.src.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return nil, err
+		}
+		if len(line) > maxRecordSize {
+			return nil, fmt.Errorf("line of %d bytes is too large", len(line))
+		}
+
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		return bytes.TrimSuffix(line, []byte("\r")), nil
+	}
+}
+
+// writeRecord writes a record in the specified format with a single write,
+// so records written to a pipe are never split.
+func writeRecord(dst io.Writer, format string, record []byte) error {
+	var b []byte
+
+	switch format {
+	case RecordBinary:
+		b = make([]byte, 4, 4+len(record))
+		binary.BigEndian.PutUint32(b, uint32(len(record)))
+		b = append(b, record...)
+
+	default:
+		b = make([]byte, 0, len(record)+1)
+		b = append(append(b, record...), '\n')
+	}
+
+	_, err := dst.Write(b)
+	return err
+}
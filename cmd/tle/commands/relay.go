@@ -0,0 +1,376 @@
+package commands
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	nethttp "net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/drand/tlock/networks/http"
+	"github.com/drand/tlock/relay"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gopkg.in/yaml.v2"
+)
+
+// Relay runs a relay server storing the items uploaded to it in a directory,
+// an S3 bucket or memory and serving them once their round is reached, until
+// the context is canceled.
+func Relay(ctx context.Context, out io.Writer, args []string) error {
+	var networks, chains listFlag
+	var v verbosity
+
+	fs := flag.NewFlagSet("relay", flag.ContinueOnError)
+	fs.Var(&networks, "n", "the drand API endpoint; can be repeated")
+	fs.Var(&networks, "network", "the drand API endpoint; can be repeated")
+	fs.Var(&chains, "c", "a chain items can be locked to; can be repeated")
+	fs.Var(&chains, "chain", "a chain items can be locked to; can be repeated")
+	listen := fs.String("listen", "127.0.0.1:8080", "the address to listen on")
+	maxSize := fs.Int64("max-size", relay.DefaultMaxSize, "the size in bytes of the largest item")
+	maxUploads := fs.Int("max-uploads", 16, "the number of uploads handled at once; 0 for no limit")
+	uploadQueue := fs.Int("upload-queue", 64, "the number of uploads waiting for their turn")
+	keysFile := fs.String("keys", "", "the file holding the API keys required to upload items")
+	metrics := fs.String("metrics", "", "the address to serve Prometheus metrics on")
+	pinFile := fs.String("pin-file", defaultPinFile(), "the file recording the public key of each chain")
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := NewLogger(os.Stderr, v.level())
+
+	if fs.NArg() != 1 {
+		return errors.New("relay requires a single STORAGE")
+	}
+	if *keysFile == "" && !isLoopback(*listen) {
+		return fmt.Errorf("relay requires --keys to listen on %s, or else anyone could upload items to it", *listen)
+	}
+
+	storage, err := openStorage(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if len(networks) == 0 {
+		networks = listFlag{defaultNetwork}
+	}
+
+	resolver := relayResolver{
+		log:      log,
+		networks: networks,
+		chains:   chains,
+		pinFile:  *pinFile,
+		resolved: make(map[string]relay.RoundTimer),
+		fetchErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "tle",
+			Subsystem: "relay",
+			Name:      "chain_fetch_errors_total",
+			Help:      "The failures to fetch the information of a chain from the network.",
+		}),
+	}
+
+	opts := []relay.Option{
+		relay.WithMaxSize(*maxSize),
+		relay.WithMaxUploads(*maxUploads, *uploadQueue),
+		relay.WithReadiness(resolver.ready),
+	}
+	if *keysFile != "" {
+		keys, err := LoadKeys(*keysFile)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, relay.WithKeys(keys...))
+		log.Infof("loaded %d API keys", len(keys))
+	}
+
+	s, err := relay.NewServer(storage, resolver.resolve, opts...)
+	if err != nil {
+		return err
+	}
+	var handler nethttp.Handler = s
+
+	if *metrics != "" {
+		requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "tle",
+			Subsystem: "relay",
+			Name:      "requests_total",
+			Help:      "The requests served, by method and status code.",
+		}, []string{"method", "code"})
+		handler = promhttp.InstrumentHandlerCounter(requests, handler)
+
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(requests, resolver.fetchErrors)
+		reg.MustRegister(relayMetrics(s)...)
+
+		if err := serveMetrics(ctx, log, *metrics, reg); err != nil {
+			return err
+		}
+	}
+
+	ln, err := serviceListener(log, *listen)
+	if err != nil {
+		return err
+	}
+
+	srv := nethttp.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				reloadRelay(log, s, *keysFile, &resolver)
+			}
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	log.Infof("relay listening on %s", ln.Addr())
+	go serviceReady(ctx, log, fmt.Sprintf("listening on %s", ln.Addr()))
+
+	if err := srv.Serve(ln); !errors.Is(err, nethttp.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// reloadRelay reloads the keys of a running relay from the file, if any, and
+// resolves the chains again on their next upload, so that changes to the
+// networks and the pins are picked up. Requests in progress, such as event
+// streams and uploads, aren't interrupted. The keys are kept if the file is
+// invalid.
+func reloadRelay(log *Logger, s *relay.Server, keysFile string, resolver *relayResolver) {
+	if keysFile != "" {
+		keys, err := LoadKeys(keysFile)
+		if err != nil {
+			log.Errorf("reload: %v", err)
+			return
+		}
+		s.SetKeys(keys...)
+	}
+
+	resolver.reset()
+	log.Infof("reloaded the relay configuration")
+}
+
+// keyFile describes the YAML representation of an API key of a relay.
+type keyFile struct {
+	Name      string `yaml:"name"`
+	Token     string `yaml:"token"`
+	RateLimit int    `yaml:"rate_limit"`
+	MaxSize   int64  `yaml:"max_size"`
+	Quota     int64  `yaml:"quota"`
+}
+
+// LoadKeys reads the API keys of a relay from the YAML file at the specified
+// path, which holds a list of keys.
+func LoadKeys(path string) ([]relay.Key, error) {
+	b, err := os.ReadFile(localPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("read keys: %w", err)
+	}
+
+	var kfs []keyFile
+	if err := yaml.UnmarshalStrict(b, &kfs); err != nil {
+		return nil, fmt.Errorf("parse keys %q: %w", path, err)
+	}
+
+	keys := make([]relay.Key, len(kfs))
+	tokens := make(map[string]bool)
+	for i, kf := range kfs {
+		if kf.Name == "" {
+			kf.Name = strconv.Itoa(i + 1)
+		}
+		if kf.Token == "" {
+			return nil, fmt.Errorf("keys %q: key %s has no token", path, kf.Name)
+		}
+		if tokens[kf.Token] {
+			return nil, fmt.Errorf("keys %q: key %s reuses a token", path, kf.Name)
+		}
+		if kf.RateLimit < 0 || kf.MaxSize < 0 || kf.Quota < 0 {
+			return nil, fmt.Errorf("keys %q: key %s has a negative limit", path, kf.Name)
+		}
+		tokens[kf.Token] = true
+
+		keys[i] = relay.Key{
+			Name:      kf.Name,
+			Token:     kf.Token,
+			RateLimit: kf.RateLimit,
+			MaxSize:   kf.MaxSize,
+			Quota:     kf.Quota,
+		}
+	}
+
+	return keys, nil
+}
+
+// openStorage returns the storage of a relay described by the argument: an
+// s3:// URL naming a bucket and a prefix, memory: for a storage lost on exit,
+// or else a directory, which is created if needed. An S3 bucket is accessed
+// with the credentials, region and endpoint of the AWS environment variables.
+func openStorage(arg string) (relay.Storage, error) {
+	switch {
+	case arg == "memory:":
+		return relay.Memory(), nil
+
+	case strings.HasPrefix(arg, "s3://"):
+		u, err := url.Parse(arg)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid bucket %q", arg)
+		}
+
+		prefix := strings.TrimPrefix(u.Path, "/")
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+
+		region := os.Getenv("AWS_REGION")
+		if region == "" {
+			region = os.Getenv("AWS_DEFAULT_REGION")
+		}
+
+		return relay.NewS3(relay.S3Config{
+			Endpoint:        os.Getenv("AWS_ENDPOINT_URL"),
+			Region:          region,
+			Bucket:          u.Host,
+			Prefix:          prefix,
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		})
+	}
+
+	dir := localPath(arg)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return relay.Dir(dir), nil
+}
+
+// openStateStorage returns the storage described by the argument, like
+// openStorage, encrypting its objects with the key derived from the file
+// holding at least 32 random bytes, if any.
+func openStateStorage(arg string, keyFile string) (relay.Storage, error) {
+	storage, err := openStorage(arg)
+	if err != nil || keyFile == "" {
+		return storage, err
+	}
+
+	b, err := os.ReadFile(localPath(keyFile))
+	if err != nil {
+		return nil, fmt.Errorf("read state key: %w", err)
+	}
+	if len(b) < 32 {
+		return nil, fmt.Errorf("state key %q holds %d bytes instead of at least 32", keyFile, len(b))
+	}
+
+	key := sha256.Sum256(b)
+	return relay.Sealed(storage, key[:])
+}
+
+// relayResolver resolves the chains of the items uploaded to a relay,
+// constructing the network of every chain once.
+type relayResolver struct {
+	log      *Logger
+	networks []string
+	chains   []string
+	pinFile  string
+
+	mu          sync.Mutex
+	resolved    map[string]relay.RoundTimer
+	fetchErrors prometheus.Counter
+}
+
+// resolve returns the network of the chain, if the chain is accepted.
+func (r *relayResolver) resolve(ctx context.Context, chainHash string) (relay.RoundTimer, error) {
+	if len(r.chains) > 0 && !contains(r.chains, chainHash) {
+		return nil, errors.New("chain isn't accepted by the relay")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if rt, exists := r.resolved[chainHash]; exists {
+		return rt, nil
+	}
+
+	network, err := NetworkForChain(ctx, r.log, r.networks, r.pinFile, chainHash, 0)
+	if err != nil {
+		if !errors.Is(err, ErrPinMismatch) {
+			r.fetchErrors.Inc()
+		}
+		return nil, err
+	}
+
+	r.resolved[chainHash] = network
+	return network, nil
+}
+
+// ready reports whether one of the networks can be reached and every chain
+// accepted by the relay can be resolved, which /readyz checks.
+func (r *relayResolver) ready(ctx context.Context) error {
+	var err error
+	for _, host := range r.networks {
+		if _, err = http.Chains(ctx, host); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("no network reachable: %w", err)
+	}
+
+	for _, chainHash := range r.chains {
+		if _, err := r.resolve(ctx, chainHash); err != nil {
+			return fmt.Errorf("chain %s: %w", chainHash, err)
+		}
+	}
+
+	return nil
+}
+
+// reset forgets the networks resolved so far.
+func (r *relayResolver) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.resolved = make(map[string]relay.RoundTimer)
+}
+
+// contains reports whether the list holds the value.
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
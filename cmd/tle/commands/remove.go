@@ -0,0 +1,49 @@
+package commands
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+)
+
+// RemoveInput deletes the input file once it has been encrypted. When shred
+// is set the content is first overwritten with random data. Shredding is best
+// effort: journaling and copy-on-write filesystems, as well as SSDs, may keep
+// copies of the original blocks.
+func RemoveInput(name string, shred bool) error {
+	name = localPath(name)
+
+	if shred {
+		if err := shredFile(name); err != nil {
+			return fmt.Errorf("shred input: %w", err)
+		}
+	}
+
+	if err := os.Remove(name); err != nil {
+		return fmt.Errorf("remove input: %w", err)
+	}
+
+	return nil
+}
+
+// shredFile overwrites the content of the file with random data and flushes
+// it to disk.
+func shredFile(name string) error {
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.CopyN(f, rand.Reader, info.Size()); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
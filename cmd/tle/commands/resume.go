@@ -0,0 +1,273 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/networks/http"
+)
+
+// These constants define the suffixes of the files kept next to the output
+// of a resumable operation until it completes.
+const (
+	partialSuffix  = ".partial"
+	progressSuffix = ".progress"
+)
+
+// saveInterval is how often the progress of a resumable operation is saved.
+// Saving flushes the output to disk, so doing it for every chunk would slow
+// down large operations.
+const saveInterval = time.Second
+
+// Progress tracks the last checkpoint of a resumable operation in a file next
+// to the output. The output is written to a partial file that is kept when
+// the operation is interrupted, so running the same command again continues
+// from the last checkpoint.
+type Progress struct {
+	out   *Output
+	path  string
+	clock Clock
+	saved time.Time
+	state progressState
+}
+
+// progressState is the content of the progress file. The input is recorded
+// to make sure the operation is resumed with the same data.
+type progressState struct {
+	Operation  string            `json:"operation"`
+	Input      string            `json:"input"`
+	Size       int64             `json:"size"`
+	ModTime    time.Time         `json:"mod_time"`
+	Checkpoint *tlock.Checkpoint `json:"checkpoint,omitempty"`
+}
+
+// OpenProgress opens the output of a resumable operation on the input. If the
+// progress file of an interrupted operation exists, the partial output is
+// truncated to its last checkpoint, otherwise a new partial output is
+// created.
+func OpenProgress(path string, input string, decrypt bool, clock Clock) (*Output, *Progress, error) {
+	path = localPath(path)
+
+	name, err := filepath.Abs(localPath(input))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("stat input: %w", err)
+	}
+
+	p := Progress{
+		path:  path + progressSuffix,
+		clock: clock,
+		state: progressState{
+			Operation: "encrypt",
+			Input:     name,
+			Size:      info.Size(),
+			ModTime:   info.ModTime().UTC(),
+		},
+	}
+	if decrypt {
+		p.state.Operation = "decrypt"
+	}
+
+	cp, err := p.load()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	flag := os.O_RDWR | os.O_CREATE
+	if cp == nil {
+		flag |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(path+partialSuffix, flag, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cp != nil {
+		if err := truncate(f, cp.Written); err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("partial output %q: %w; remove %q to start over", f.Name(), err, p.path)
+		}
+	}
+
+	p.state.Checkpoint = cp
+	p.out = &Output{
+		File: f,
+		path: path,
+		keep: true,
+	}
+
+	if err := p.write(); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return p.out, &p, nil
+}
+
+// load reads the checkpoint of an interrupted operation. It returns nil if
+// there is none to resume from.
+func (p *Progress) load() (*tlock.Checkpoint, error) {
+	b, err := os.ReadFile(p.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read progress: %w", err)
+	}
+
+	var saved progressState
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return nil, fmt.Errorf("read progress %q: %w", p.path, err)
+	}
+
+	if saved.Operation != p.state.Operation || saved.Input != p.state.Input || saved.Size != p.state.Size || !saved.ModTime.Equal(p.state.ModTime) {
+		return nil, fmt.Errorf("progress file %q belongs to another operation or the input changed; remove it to start over", p.path)
+	}
+
+	return saved.Checkpoint, nil
+}
+
+// truncate discards the data written after the offset and positions the
+// file at the offset.
+func truncate(f *os.File, offset int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() < offset {
+		return fmt.Errorf("shorter than the %d bytes recorded", offset)
+	}
+
+	if err := f.Truncate(offset); err != nil {
+		return err
+	}
+
+	_, err = f.Seek(offset, io.SeekStart)
+	return err
+}
+
+// Checkpoint returns the checkpoint the operation resumes from, or nil if
+// the operation starts from the beginning.
+func (p *Progress) Checkpoint() *tlock.Checkpoint {
+	return p.state.Checkpoint
+}
+
+// Save records the checkpoint once the output was flushed to disk. It is used
+// with tlock.WithCheckpoint and only saves once per saveInterval.
+func (p *Progress) Save(cp tlock.Checkpoint) error {
+	now := p.clock.Now()
+	if now.Sub(p.saved) < saveInterval {
+		return nil
+	}
+	p.saved = now
+
+	if err := p.out.Sync(); err != nil {
+		return fmt.Errorf("sync output: %w", err)
+	}
+
+	p.state.Checkpoint = &cp
+	return p.write()
+}
+
+// Done removes the progress file once the output was committed.
+func (p *Progress) Done() error {
+	if err := os.Remove(p.path); err != nil {
+		return fmt.Errorf("remove progress: %w", err)
+	}
+	return nil
+}
+
+// write replaces the progress file with the current state. The file can
+// hold the key of an encryption, so it is only readable by the owner.
+func (p *Progress) write() error {
+	b, err := json.Marshal(p.state)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p.path), "."+filepath.Base(p.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("write progress: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("write progress: %w", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("write progress: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write progress: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), p.path); err != nil {
+		return fmt.Errorf("write progress: %w", err)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// ResumeEncrypt encrypts the input like Encrypt does, saving the progress as
+// it goes. If the progress has a checkpoint, the encryption continues from
+// there instead of starting over.
+func ResumeEncrypt(ctx context.Context, log *Logger, clock Clock, flags Flags, progress *Progress, dst io.Writer, src io.ReadSeeker, network *http.Network) error {
+	checkpoint := tlock.WithCheckpoint(progress.Save)
+
+	cp := progress.Checkpoint()
+	if cp == nil {
+		roundNumber, err := encrypt(ctx, log, clock, flags, dst, src, network, checkpoint)
+		if err != nil {
+			return err
+		}
+
+		return writeSchedule(log.Writer(), flags.JSON, newSchedule(network, roundNumber, clock.Now()))
+	}
+
+	if _, err := src.Seek(cp.Read, io.SeekStart); err != nil {
+		return fmt.Errorf("seek input: %w", err)
+	}
+
+	log.Infof("resuming encryption after %s", formatSize(cp.Read))
+	return tlock.New(network, append(Options(log, clock, flags), checkpoint)...).ResumeEncryptContext(ctx, dst, src, *cp)
+}
+
+// ResumeDecrypt decrypts the input, saving the progress as it goes. If the
+// progress has a checkpoint, the decryption continues from there instead of
+// starting over.
+func ResumeDecrypt(ctx context.Context, log *Logger, clock Clock, flags Flags, progress *Progress, dst io.Writer, src io.ReadSeeker, network tlock.Network) error {
+	// The header of the input was already read to select the network.
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("rewind input: %w", err)
+	}
+
+	tl := tlock.New(network, append(Options(log, clock, flags), tlock.WithCheckpoint(progress.Save))...)
+
+	cp := progress.Checkpoint()
+	if cp == nil {
+		return tl.DecryptContext(ctx, dst, src)
+	}
+
+	log.Infof("resuming decryption after %s", formatSize(cp.Written))
+	return tl.ResumeDecryptContext(ctx, dst, src, *cp)
+}
@@ -0,0 +1,84 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/drand/tlock"
+)
+
+// localTimeLayouts lists the accepted layouts for the --at flag.
+var localTimeLayouts = []string{
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// parseLocalTime parses a wall clock time in the specified IANA time zone,
+// such as Europe/Paris. The local time zone is used when tz is empty.
+func parseLocalTime(value string, tz string) (time.Time, error) {
+	loc := time.Local
+	if tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return time.Time{}, fmt.Errorf("load time zone: %w", err)
+		}
+	}
+
+	for _, layout := range localTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%w: time %q", tlock.ErrInvalidRound, value)
+}
+
+// Round converts a round specifier, such as +1000 or dur:30d, into the round
+// number of the chain, and reports when that round is reached. It helps pick
+// the round given to -r/--round.
+func Round(ctx context.Context, out io.Writer, args []string) error {
+	var networks listFlag
+	var v verbosity
+
+	fs := flag.NewFlagSet("round", flag.ContinueOnError)
+	fs.Var(&networks, "n", "the drand API endpoint; can be repeated")
+	fs.Var(&networks, "network", "the drand API endpoint; can be repeated")
+	chainHash := fs.String("c", defaultChain, "the chain of the round")
+	fs.StringVar(chainHash, "chain", defaultChain, "the chain of the round")
+	pinFile := fs.String("pin-file", defaultPinFile(), "the file recording the public key of each chain")
+	asJSON := jsonFlag(fs)
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := NewLogger(os.Stderr, v.level())
+
+	if fs.NArg() != 1 {
+		return errors.New("round requires a single SPEC")
+	}
+
+	if len(networks) == 0 {
+		networks = listFlag{defaultNetwork}
+	}
+
+	network, err := NetworkForChain(ctx, log, networks, *pinFile, *chainHash, 0)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	roundNumber, err := tlock.ParseRound(fs.Arg(0), now, network)
+	if err != nil {
+		return err
+	}
+
+	return writeSchedule(out, *asJSON, newSchedule(network, roundNumber, now))
+}
@@ -0,0 +1,119 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Stats measures the amount of data read and written by an operation and how
+// long it took, which helps to tune the chunk size and to decide whether to
+// compress large inputs.
+type Stats struct {
+	clock Clock
+	start time.Time
+	in    countingReader
+	out   countingWriter
+}
+
+// NewStats starts measuring an operation. The returned reader and writer
+// have to be used in place of the source and destination.
+func NewStats(clock Clock, src io.Reader, dst io.Writer) (*Stats, io.Reader, io.Writer) {
+	s := Stats{
+		clock: clock,
+		start: clock.Now(),
+		in:    countingReader{r: src},
+		out:   countingWriter{w: dst},
+	}
+
+	return &s, &s.in, &s.out
+}
+
+// report describes a completed operation.
+type report struct {
+	Input      int64   `json:"input_bytes"`
+	Output     int64   `json:"output_bytes"`
+	Overhead   int64   `json:"overhead_bytes"`
+	Elapsed    float64 `json:"elapsed_seconds"`
+	Throughput float64 `json:"throughput_bytes_per_second"`
+}
+
+// Write displays the measurements of the operation. The overhead is the
+// size the encrypted data adds to the plain data.
+func (s *Stats) Write(out io.Writer, decrypt bool, asJSON bool) error {
+	elapsed := s.clock.Now().Sub(s.start)
+
+	r := report{
+		Input:    s.in.n,
+		Output:   s.out.n,
+		Overhead: s.out.n - s.in.n,
+		Elapsed:  elapsed.Seconds(),
+	}
+
+	if decrypt {
+		r.Overhead = -r.Overhead
+	}
+
+	if elapsed > 0 {
+		r.Throughput = float64(r.Input) / elapsed.Seconds()
+	}
+
+	if asJSON {
+		return json.NewEncoder(out).Encode(r)
+	}
+
+	fmt.Fprintf(out, "input:      %s\n", formatSize(r.Input))
+	fmt.Fprintf(out, "output:     %s\n", formatSize(r.Output))
+	fmt.Fprintf(out, "overhead:   %s\n", formatSize(r.Overhead))
+	fmt.Fprintf(out, "elapsed:    %s\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(out, "throughput: %s/s\n", formatSize(int64(r.Throughput)))
+
+	return nil
+}
+
+// formatSize formats a number of bytes using binary units.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit && n > -unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	value := float64(n)
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+
+	var i int
+	for value /= unit; (value >= unit || value <= -unit) && i < len(units)-1; i++ {
+		value /= unit
+	}
+
+	return fmt.Sprintf("%.1f %s", value, units[i])
+}
+
+// =============================================================================
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read implements the io.Reader interface.
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements the io.Writer interface.
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,130 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/networks/http"
+)
+
+// Status reports the round and chain an encrypted input is locked to, and
+// how long it takes until it can be decrypted.
+func Status(ctx context.Context, out io.Writer, args []string) error {
+	var host string
+
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	fs.StringVar(&host, "n", defaultNetwork, "the drand API endpoint")
+	fs.StringVar(&host, "network", defaultNetwork, "the drand API endpoint")
+	pinFile := fs.String("pin-file", defaultPinFile(), "the file recording the public key of each chain")
+	asJSON := jsonFlag(fs)
+	var v verbosity
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := NewLogger(os.Stderr, v.level())
+
+	src, err := OpenInput(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	header, err := tlock.ReadHeader(src)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("input is locked to round %d of chain %s", header.RoundNumber, header.ChainHash)
+
+	network, err := ProbeNetworks(ctx, log, []string{host}, *pinFile, header.ChainHash, 0)
+	if err != nil {
+		return err
+	}
+
+	sched := newSchedule(network, header.RoundNumber, time.Now())
+	if sched.Ready {
+		available, _, err := network.IsAvailableContext(ctx, header.RoundNumber)
+		if err != nil {
+			return err
+		}
+		sched.Ready = available
+	}
+
+	return writeSchedule(out, *asJSON, sched)
+}
+
+// schedule describes when data encrypted for a round can be decrypted.
+type schedule struct {
+	RoundNumber uint64    `json:"round"`
+	ChainHash   string    `json:"chain_hash"`
+	UnlockTime  time.Time `json:"unlock_time"`
+	Remaining   int64     `json:"remaining_seconds"`
+	Ready       bool      `json:"ready"`
+}
+
+// newSchedule returns the schedule of the round, which is considered ready
+// once its time is reached.
+func newSchedule(network *http.Network, roundNumber uint64, now time.Time) schedule {
+	unlock := network.RoundTime(roundNumber).UTC()
+	remaining := unlock.Sub(now)
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return schedule{
+		RoundNumber: roundNumber,
+		ChainHash:   network.ChainHash(),
+		UnlockTime:  unlock,
+		Remaining:   int64(remaining.Round(time.Second) / time.Second),
+		Ready:       remaining == 0,
+	}
+}
+
+// writeSchedule displays the round and chain used for encryption along with
+// the time at which decryption becomes possible.
+func writeSchedule(out io.Writer, asJSON bool, s schedule) error {
+	if asJSON {
+		return json.NewEncoder(out).Encode(s)
+	}
+
+	fmt.Fprintf(out, "round:    %d\n", s.RoundNumber)
+	fmt.Fprintf(out, "chain:    %s\n", s.ChainHash)
+
+	unlock := s.UnlockTime.Format(time.RFC3339)
+	switch {
+	case s.Ready:
+		fmt.Fprintf(out, "unlocked: %s (ready to decrypt)\n", unlock)
+	case s.Remaining == 0:
+		fmt.Fprintf(out, "unlocked: %s (signature not published yet)\n", unlock)
+	default:
+		fmt.Fprintf(out, "unlocks:  %s (in %s)\n", unlock, formatRemaining(time.Duration(s.Remaining)*time.Second))
+	}
+
+	return nil
+}
+
+// formatRemaining formats a duration using days for anything longer than a
+// day, since durations of several months are common.
+func formatRemaining(d time.Duration) string {
+	d = d.Round(time.Second)
+
+	day := 24 * time.Hour
+	days := d / day
+	if days == 0 {
+		return d.String()
+	}
+
+	rest := d - days*day
+	if rest == 0 {
+		return fmt.Sprintf("%dd", days)
+	}
+
+	return fmt.Sprintf("%dd%s", days, rest)
+}
@@ -0,0 +1,118 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation.
+const listenFDsStart = 3
+
+// serviceListener returns the socket systemd passed to the process when it's
+// socket activated, or else listens on the address. Only the first socket
+// passed is used, and the variables describing them are unset so child
+// processes don't pick them up.
+func serviceListener(log *Logger, addr string) (net.Listener, error) {
+	pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	fds, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if pid != os.Getpid() || fds < 1 {
+		return net.Listen("tcp", addr)
+	}
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(listenFDsStart, "LISTEN_FD_3")
+	defer f.Close()
+
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("socket activation: %w", err)
+	}
+
+	log.Debugf("using the socket passed by systemd instead of %s", addr)
+	return ln, nil
+}
+
+// notify sends the state, such as READY=1 or STOPPING=1, to the service
+// manager when the process runs as a systemd service of Type=notify. It does
+// nothing otherwise.
+func notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// Abstract sockets are given with a leading @.
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("notify: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("notify: %w", err)
+	}
+
+	return nil
+}
+
+// serviceReady tells the service manager that the service is ready, and keeps
+// its watchdog fed until the context is canceled, if the service has
+// WatchdogSec set. It then tells the service manager that the service is
+// stopping.
+func serviceReady(ctx context.Context, log *Logger, status string) {
+	if err := notify("READY=1\nSTATUS=" + status); err != nil {
+		log.Errorf("%v", err)
+	}
+
+	interval := watchdogInterval()
+	if interval == 0 {
+		<-ctx.Done()
+		notify("STOPPING=1")
+		return
+	}
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			notify("STOPPING=1")
+			return
+
+		case <-ticker.C:
+			if err := notify("WATCHDOG=1"); err != nil {
+				log.Errorf("%v", err)
+			}
+		}
+	}
+}
+
+// watchdogInterval returns the interval the service manager expects the
+// watchdog to be fed within, or zero if the watchdog isn't enabled for this
+// process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
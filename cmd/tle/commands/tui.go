@@ -0,0 +1,510 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/networks/http"
+)
+
+// TUI runs an interactive terminal interface over the encrypted files of a
+// directory. It lists them with the time left until they can be decrypted,
+// and reads commands from the standard input to inspect them, open them once
+// their round is reached, or extend them to a later round.
+func TUI(ctx context.Context, out io.Writer, args []string) error {
+	var networks listFlag
+	var v verbosity
+
+	fs := flag.NewFlagSet("tui", flag.ContinueOnError)
+	fs.Var(&networks, "n", "the drand API endpoint; can be repeated")
+	fs.Var(&networks, "network", "the drand API endpoint; can be repeated")
+	pinFile := fs.String("pin-file", defaultPinFile(), "the file recording the public key of each chain")
+	refresh := fs.Duration("refresh", time.Second, "how often the files waiting to be opened are checked")
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := NewLogger(os.Stderr, v.level())
+
+	if fs.NArg() != 1 {
+		return errors.New("tui requires a DIR")
+	}
+	if *refresh <= 0 {
+		return errors.New("--refresh must be positive")
+	}
+
+	if len(networks) == 0 {
+		networks = listFlag{defaultNetwork}
+	}
+
+	t := newLockedDir(log, networks, *pinFile, fs.Arg(0))
+	return t.run(ctx, os.Stdin, out, *refresh)
+}
+
+// tuiHelp lists the commands of the interface.
+const tuiHelp = `commands:
+  inspect N        show the header of file N
+  open N           decrypt file N once its round is reached
+  extend N ROUND   lock file N until a later ROUND, as accepted by --round
+  refresh          list the files again, which pressing enter does too
+  quit             leave, forgetting the files waiting to be opened`
+
+// =============================================================================
+
+// lockedDir holds the encrypted files of a directory shown by the interface.
+// It's only used by the goroutine running the interface.
+type lockedDir struct {
+	log      *Logger
+	networks []string
+	pinFile  string
+	dir      string
+	now      func() time.Time
+
+	files   []*lockedFile
+	chains  map[string]*http.Network
+	pending map[string]bool
+}
+
+// lockedFile is an encrypted file of the directory. Only its outer layer is
+// known, so the unlock time of a file extended with another layer is the one
+// of that layer.
+type lockedFile struct {
+	name    string
+	header  tlock.Header
+	network *http.Network
+	unlock  time.Time
+}
+
+// newLockedDir constructs the state of the interface over the directory.
+func newLockedDir(log *Logger, networks []string, pinFile string, dir string) *lockedDir {
+	return &lockedDir{
+		log:      log,
+		networks: networks,
+		pinFile:  pinFile,
+		dir:      dir,
+		now:      time.Now,
+		chains:   make(map[string]*http.Network),
+		pending:  make(map[string]bool),
+	}
+}
+
+// run lists the files and executes the commands read from in until quit is
+// entered, the input ends, or the context is canceled. The files marked with
+// open are checked every refresh period and decrypted once they are ready.
+func (d *lockedDir) run(ctx context.Context, in io.Reader, out io.Writer, refresh time.Duration) error {
+	if err := d.scan(ctx); err != nil {
+		return err
+	}
+	d.draw(out)
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-ticker.C:
+			if d.openDue(ctx, out) {
+				d.draw(out)
+			}
+
+		case line, ok := <-lines:
+			if !ok {
+				return nil
+			}
+
+			quit, err := d.execute(ctx, out, strings.Fields(line))
+			if quit {
+				return nil
+			}
+			if err != nil {
+				fmt.Fprintf(out, "error: %v\n", err)
+			}
+			d.draw(out)
+		}
+	}
+}
+
+// execute runs a command of the interface and reports whether it's quit.
+func (d *lockedDir) execute(ctx context.Context, out io.Writer, args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, d.scan(ctx)
+	}
+
+	switch args[0] {
+	case "quit", "q":
+		return true, nil
+
+	case "refresh", "r":
+		return false, d.scan(ctx)
+
+	case "inspect", "i":
+		f, err := d.file(args, 2)
+		if err != nil {
+			return false, err
+		}
+		d.inspect(out, f)
+		return false, nil
+
+	case "open", "o":
+		f, err := d.file(args, 2)
+		if err != nil {
+			return false, err
+		}
+		if d.now().Before(f.unlock) {
+			d.pending[f.name] = true
+			fmt.Fprintf(out, "%s opens at %s\n", f.name, f.unlock.Format(time.RFC3339))
+			return false, nil
+		}
+		return false, d.open(ctx, out, f)
+
+	case "extend", "e":
+		f, err := d.file(args, 3)
+		if err != nil {
+			return false, err
+		}
+		if err := d.extend(ctx, f, args[2]); err != nil {
+			return false, err
+		}
+		return false, d.scan(ctx)
+
+	case "help", "h", "?":
+		fmt.Fprintln(out, tuiHelp)
+		return false, nil
+	}
+
+	return false, fmt.Errorf("unknown command %q; enter help to list the commands", args[0])
+}
+
+// file returns the file whose number is the second argument of a command
+// expecting n arguments.
+func (d *lockedDir) file(args []string, n int) (*lockedFile, error) {
+	if len(args) != n {
+		return nil, fmt.Errorf("%s expects %d arguments", args[0], n-1)
+	}
+
+	i, err := strconv.Atoi(args[1])
+	if err != nil || i < 1 || i > len(d.files) {
+		return nil, fmt.Errorf("no file %s", args[1])
+	}
+
+	return d.files[i-1], nil
+}
+
+// =============================================================================
+
+// scan lists the encrypted files of the directory, ordered by unlock time.
+// Hidden files, such as the temporary files of outputs, are skipped along
+// with the files that aren't encrypted. A file whose chain can't be reached
+// is skipped too, after logging why.
+func (d *lockedDir) scan(ctx context.Context) error {
+	entries, err := os.ReadDir(localPath(d.dir))
+	if err != nil {
+		return fmt.Errorf("read dir: %w", err)
+	}
+
+	var files []*lockedFile
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		header, err := d.readHeader(entry.Name())
+		if err != nil {
+			d.log.Debugf("skipping %s: %v", entry.Name(), err)
+			continue
+		}
+
+		network, err := d.network(ctx, header)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			d.log.Infof("skipping %s: %v", entry.Name(), err)
+			continue
+		}
+
+		files = append(files, &lockedFile{
+			name:    entry.Name(),
+			header:  header,
+			network: network,
+			unlock:  network.RoundTime(header.RoundNumber).UTC(),
+		})
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].unlock.Before(files[j].unlock)
+	})
+	d.files = files
+
+	// Files that are gone can't be opened anymore.
+	for name := range d.pending {
+		if d.lookup(name) == nil {
+			delete(d.pending, name)
+		}
+	}
+
+	return nil
+}
+
+// readHeader reads the header of the file of the directory.
+func (d *lockedDir) readHeader(name string) (tlock.Header, error) {
+	f, err := os.Open(d.path(name))
+	if err != nil {
+		return tlock.Header{}, err
+	}
+	defer f.Close()
+
+	return tlock.ReadHeader(f)
+}
+
+// network returns the network of the chain recorded in the header, whose
+// public key is checked against the pin file the first time it's used.
+func (d *lockedDir) network(ctx context.Context, header tlock.Header) (*http.Network, error) {
+	if network, exists := d.chains[header.ChainHash]; exists {
+		return network, nil
+	}
+
+	network, err := NetworkForHeader(ctx, d.log, d.networks, d.pinFile, header)
+	if err != nil {
+		return nil, err
+	}
+
+	d.chains[header.ChainHash] = network
+
+	return network, nil
+}
+
+// lookup returns the file with the name, or nil if it isn't listed.
+func (d *lockedDir) lookup(name string) *lockedFile {
+	for _, f := range d.files {
+		if f.name == name {
+			return f
+		}
+	}
+
+	return nil
+}
+
+// path returns the path of the file of the directory.
+func (d *lockedDir) path(name string) string {
+	return filepath.Join(localPath(d.dir), name)
+}
+
+// =============================================================================
+
+// draw lists the files with the time left until they can be decrypted,
+// which is computed anew every time.
+func (d *lockedDir) draw(out io.Writer) {
+	now := d.now()
+
+	fmt.Fprintf(out, "\n%s: %d locked files at %s\n", d.dir, len(d.files), now.UTC().Format(time.RFC3339))
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tNAME\tROUND\tUNLOCKS\tSTATUS")
+	for i, f := range d.files {
+		status := "ready"
+		if remaining := f.unlock.Sub(now); remaining > 0 {
+			status = "in " + formatRemaining(remaining)
+		}
+		if d.pending[f.name] {
+			status += ", opens when ready"
+		}
+
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\n", i+1, f.name, f.header.RoundNumber, f.unlock.Format(time.RFC3339), status)
+	}
+	tw.Flush()
+
+	fmt.Fprint(out, "inspect N | open N | extend N ROUND | refresh | help | quit > ")
+}
+
+// inspect shows the header of the file.
+func (d *lockedDir) inspect(out io.Writer, f *lockedFile) {
+	chain := f.header.ChainHash
+	if name := networkName(chain); name != "" {
+		chain += " (" + name + ")"
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(tw, "file:\t%s\n", d.path(f.name))
+	fmt.Fprintf(tw, "chain:\t%s\n", chain)
+	fmt.Fprintf(tw, "scheme:\t%s\n", f.header.Scheme)
+	fmt.Fprintf(tw, "round:\t%d\n", f.header.RoundNumber)
+	fmt.Fprintf(tw, "unlocks:\t%s\n", f.unlock.Format(time.RFC3339))
+	fmt.Fprintf(tw, "version:\t%d\n", f.header.Version)
+	fmt.Fprintf(tw, "payload:\t%s, %d byte chunks\n", f.header.AEAD, f.header.ChunkSize)
+	if f.header.ContentType != "" {
+		fmt.Fprintf(tw, "content:\t%s\n", f.header.ContentType)
+	}
+	if f.header.FileMode != 0 {
+		fmt.Fprintf(tw, "mode:\t%s\n", f.header.FileMode)
+	}
+	if len(f.header.EndpointHints) > 0 {
+		fmt.Fprintf(tw, "endpoints:\t%s\n", strings.Join(f.header.EndpointHints, " "))
+	}
+	tw.Flush()
+}
+
+// openDue opens the files marked with open whose round is reached, and
+// reports whether any was opened. Files whose signature isn't published yet
+// are tried again later.
+func (d *lockedDir) openDue(ctx context.Context, out io.Writer) bool {
+	now := d.now()
+
+	var opened bool
+	for _, f := range d.files {
+		if !d.pending[f.name] || now.Before(f.unlock) {
+			continue
+		}
+
+		err := d.open(ctx, out, f)
+		switch {
+		case errors.Is(err, tlock.ErrTooEarly):
+			d.log.Debugf("%s: %v", f.name, err)
+			continue
+		case err != nil:
+			fmt.Fprintf(out, "error: %v\n", err)
+		}
+
+		delete(d.pending, f.name)
+		opened = true
+	}
+
+	return opened
+}
+
+// open decrypts the file next to it, named after it without its .tle
+// extension. A file that was extended is decrypted until no layer is left.
+// An existing file isn't replaced.
+func (d *lockedDir) open(ctx context.Context, out io.Writer, f *lockedFile) error {
+	src, err := os.Open(d.path(f.name))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	name := plainName(f.name, f.header.ContentType)
+	if name == f.name {
+		name += ".out"
+	}
+
+	dst, err := CreateNewOutput(d.path(name))
+	if err != nil {
+		return err
+	}
+	defer dst.Abort()
+
+	if err := d.decryptLayers(ctx, dst, src); err != nil {
+		return fmt.Errorf("open %s: %w", f.name, err)
+	}
+
+	if err := dst.Commit(); err != nil {
+		return err
+	}
+	delete(d.pending, f.name)
+
+	fmt.Fprintf(out, "opened %s to %s\n", f.name, name)
+	return nil
+}
+
+// decryptLayers decrypts the source, and then the decrypted data as long as
+// it looks encrypted, which is the case for extended files.
+func (d *lockedDir) decryptLayers(ctx context.Context, dst io.Writer, src io.Reader) error {
+	// Closing the pipes stops the decryption of the outer layers when an
+	// inner one fails.
+	var pipes []*io.PipeReader
+	defer func() {
+		for _, pr := range pipes {
+			pr.Close()
+		}
+	}()
+
+	for {
+		header, rest, err := PeekHeader(src)
+		if err != nil {
+			return err
+		}
+
+		network, err := d.network(ctx, header)
+		if err != nil {
+			return err
+		}
+
+		pr, pw := io.Pipe()
+		pipes = append(pipes, pr)
+		go func() {
+			pw.CloseWithError(tlock.New(network, tlock.WithLogger(d.log)).Decrypt(pw, rest))
+		}()
+
+		prefix, plain, err := peek(pr, tlock.SniffSize)
+		if err != nil {
+			return err
+		}
+
+		if !tlock.IsEncrypted(prefix) {
+			_, err := io.Copy(dst, plain)
+			return err
+		}
+
+		src = plain
+	}
+}
+
+// extend locks the file until a later round, by encrypting it again. The
+// round is given like --round and must come after the current one, so it's
+// still locked to the same chain.
+func (d *lockedDir) extend(ctx context.Context, f *lockedFile, spec string) error {
+	roundNumber, err := tlock.ParseRound(spec, d.now(), f.network)
+	if err != nil {
+		return err
+	}
+	if roundNumber <= f.header.RoundNumber {
+		return fmt.Errorf("round %d doesn't come after round %d", roundNumber, f.header.RoundNumber)
+	}
+
+	src, err := os.Open(d.path(f.name))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := CreateOutput(d.path(f.name))
+	if err != nil {
+		return err
+	}
+	defer dst.Abort()
+
+	if err := tlock.New(f.network, tlock.WithLogger(d.log)).Encrypt(dst, src, roundNumber); err != nil {
+		return fmt.Errorf("extend %s: %w", f.name, err)
+	}
+
+	return dst.Commit()
+}
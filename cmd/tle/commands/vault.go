@@ -0,0 +1,199 @@
+package commands
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/drand/tlock/evm"
+)
+
+// Vault runs the commands that bridge encrypted files and timevault
+// contracts, which hold the blobs registered with them in escrow until they
+// are revealed with the signature of their round.
+func Vault(ctx context.Context, out io.Writer, args []string) error {
+	var v verbosity
+
+	fs := flag.NewFlagSet("vault", flag.ContinueOnError)
+	asJSON := jsonFlag(fs)
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch fs.Arg(0) {
+	case "register":
+		return registerVault(ctx, out, *asJSON, fs.Args()[1:])
+
+	case "verify":
+		return verifyVault(ctx, out, v, *asJSON, fs.Args()[1:])
+	}
+
+	return errors.New("vault requires a command: register or verify")
+}
+
+// registration describes the outcome of vault register.
+type registration struct {
+	RoundNumber    uint64 `json:"round"`
+	ChainHash      string `json:"chain_hash"`
+	BlobHash       string `json:"blob_hash"`
+	CommitmentHash string `json:"commitment_hash"`
+	Calldata       string `json:"calldata"`
+}
+
+// registerVault computes the commitment to the time lock of an encrypted
+// input and the calldata registering it with a timevault. This doesn't
+// require access to the network.
+func registerVault(ctx context.Context, out io.Writer, asJSON bool, args []string) error {
+	fs := flag.NewFlagSet("vault register", flag.ContinueOnError)
+	fs.BoolVar(&asJSON, "json", asJSON, "print machine readable JSON output")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() > 1 {
+		return errors.New("vault register accepts a single input")
+	}
+
+	src, err := OpenInput(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	c, err := evm.Commit(src)
+	if err != nil {
+		return err
+	}
+
+	hash := c.Hash()
+	r := registration{
+		RoundNumber:    c.RoundNumber,
+		ChainHash:      hex.EncodeToString(c.ChainHash[:]),
+		BlobHash:       "0x" + hex.EncodeToString(c.BlobHash[:]),
+		CommitmentHash: "0x" + hex.EncodeToString(hash[:]),
+		Calldata:       "0x" + hex.EncodeToString(c.RegisterCalldata()),
+	}
+
+	if asJSON {
+		return json.NewEncoder(out).Encode(r)
+	}
+
+	fmt.Fprintf(out, "round:      %d\n", r.RoundNumber)
+	fmt.Fprintf(out, "chain:      %s\n", r.ChainHash)
+	fmt.Fprintf(out, "blob hash:  %s\n", r.BlobHash)
+	fmt.Fprintf(out, "commitment: %s\n", r.CommitmentHash)
+	fmt.Fprintf(out, "calldata:   %s\n", r.Calldata)
+	return nil
+}
+
+// revealVerification describes the outcome of vault verify.
+type revealVerification struct {
+	RoundNumber uint64     `json:"round"`
+	ChainHash   string     `json:"chain_hash"`
+	BlobHash    string     `json:"blob_hash"`
+	Valid       bool       `json:"valid"`
+	Published   *time.Time `json:"published,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// verifyVault checks the calldata of a reveal transaction: its signature has
+// to be the one of the round it reveals, produced by the chain whose public
+// key matches the pinned one, and the encrypted input, if given, has to be
+// the blob it reveals.
+func verifyVault(ctx context.Context, out io.Writer, v verbosity, asJSON bool, args []string) error {
+	var networks listFlag
+
+	fs := flag.NewFlagSet("vault verify", flag.ContinueOnError)
+	fs.Var(&networks, "n", "the drand API endpoint; can be repeated")
+	fs.Var(&networks, "network", "the drand API endpoint; can be repeated")
+	infoFile := fs.String("chain-info", "", "the file holding the chain information")
+	pinFile := fs.String("pin-file", defaultPinFile(), "the file recording the public key of each chain")
+	fs.BoolVar(&asJSON, "json", asJSON, "print machine readable JSON output")
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log := NewLogger(os.Stderr, v.level())
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return errors.New("vault verify requires the hex encoded CALLDATA of a reveal and accepts a single input")
+	}
+
+	calldata, err := hex.DecodeString(strings.TrimPrefix(fs.Arg(0), "0x"))
+	if err != nil {
+		return fmt.Errorf("decoding calldata: %w", err)
+	}
+
+	reveal, err := evm.ParseReveal(calldata)
+	if err != nil {
+		return err
+	}
+	c := reveal.Commitment
+
+	r := revealVerification{
+		RoundNumber: c.RoundNumber,
+		ChainHash:   hex.EncodeToString(c.ChainHash[:]),
+		BlobHash:    "0x" + hex.EncodeToString(c.BlobHash[:]),
+	}
+
+	info, err := chainInfo(ctx, log, networks, *pinFile, r.ChainHash, *infoFile)
+	if err != nil {
+		return err
+	}
+
+	published, verr := evm.VerifyReveal(info, reveal)
+	if verr == nil && fs.NArg() == 2 {
+		verr = matchBlob(ctx, fs.Arg(1), c)
+	}
+
+	if verr == nil {
+		published = published.UTC()
+		r.Valid = true
+		r.Published = &published
+	} else {
+		r.Error = verr.Error()
+	}
+
+	switch {
+	case asJSON:
+		if err := json.NewEncoder(out).Encode(r); err != nil {
+			return err
+		}
+	case r.Valid:
+		fmt.Fprintf(out, "reveal of round %d of chain %s is valid, published at %s\n", r.RoundNumber, r.ChainHash, published.Format(time.RFC3339))
+	}
+
+	if verr != nil {
+		return fmt.Errorf("reveal of round %d of chain %s is invalid: %w", r.RoundNumber, r.ChainHash, verr)
+	}
+
+	return nil
+}
+
+// matchBlob checks that the encrypted input is the blob of the commitment.
+func matchBlob(ctx context.Context, name string, c evm.Commitment) error {
+	src, err := OpenInput(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	got, err := evm.Commit(src)
+	if err != nil {
+		return err
+	}
+
+	if got != c {
+		return fmt.Errorf("%s isn't the blob revealed", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/drand/tlock"
+)
+
+// These variables identify the release and commit tle was built from. They
+// are set by release builds with -ldflags, such as
+// "-X github.com/drand/tlock/cmd/tle/commands.releaseVersion=v1.2.0", and
+// otherwise read from the build information recorded by the Go toolchain.
+var (
+	releaseVersion = ""
+	releaseCommit  = ""
+)
+
+// versionInfo describes this build of tle and what it supports, so bug
+// reports and support tooling can tell installs apart.
+type versionInfo struct {
+	Version          string         `json:"version"`
+	Commit           string         `json:"commit,omitempty"`
+	GoVersion        string         `json:"go_version"`
+	Platform         string         `json:"platform"`
+	MinFormatVersion int            `json:"min_format_version"`
+	FormatVersion    int            `json:"format_version"`
+	Schemes          []string       `json:"schemes"`
+	AEADs            []tlock.AEAD   `json:"aeads"`
+	Chains           []versionChain `json:"chains"`
+}
+
+// versionChain describes a chain of the registry built into tle.
+type versionChain struct {
+	Name      string   `json:"name"`
+	Hash      string   `json:"hash"`
+	Endpoints []string `json:"endpoints"`
+}
+
+// Version reports the version of tle, the format versions and schemes
+// it supports and the chains it knows.
+func Version(ctx context.Context, out io.Writer, args []string) error {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	asJSON := jsonFlag(fs)
+	var v verbosity
+	v.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	info := buildVersion()
+	if *asJSON {
+		return json.NewEncoder(out).Encode(info)
+	}
+
+	aeads := make([]string, len(info.AEADs))
+	for i, aead := range info.AEADs {
+		aeads[i] = string(aead)
+	}
+
+	fmt.Fprintf(out, "tle %s", info.Version)
+	if info.Commit != "" {
+		fmt.Fprintf(out, " (%s)", info.Commit)
+	}
+	fmt.Fprintf(out, " %s %s\n", info.GoVersion, info.Platform)
+	fmt.Fprintf(out, "formats: v%d to v%d\n", info.MinFormatVersion, info.FormatVersion)
+	fmt.Fprintf(out, "schemes: %s\n", strings.Join(info.Schemes, ", "))
+	fmt.Fprintf(out, "aeads: %s\n\n", strings.Join(aeads, ", "))
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "CHAIN\tHASH\tENDPOINTS\n")
+	for _, c := range info.Chains {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Name, c.Hash, strings.Join(c.Endpoints, " "))
+	}
+
+	return tw.Flush()
+}
+
+// buildVersion returns the description of this build of tle. The version
+// and commit set with -ldflags take precedence over the build information,
+// which only holds a version when tle was installed with go install.
+func buildVersion() versionInfo {
+	caps := tlock.Supported()
+
+	info := versionInfo{
+		Version:          releaseVersion,
+		Commit:           releaseCommit,
+		GoVersion:        runtime.Version(),
+		Platform:         runtime.GOOS + "/" + runtime.GOARCH,
+		MinFormatVersion: caps.MinFormatVersion,
+		FormatVersion:    caps.FormatVersion,
+		Schemes:          caps.Schemes,
+		AEADs:            caps.AEADs,
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" && info.Commit == "" {
+				info.Commit = s.Value
+			}
+		}
+	}
+
+	if info.Version == "" {
+		info.Version = "devel"
+	}
+
+	for _, kn := range registry {
+		info.Chains = append(info.Chains, versionChain{
+			Name:      kn.Name,
+			Hash:      kn.ChainHash,
+			Endpoints: kn.Endpoints,
+		})
+	}
+
+	return info
+}
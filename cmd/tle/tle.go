@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"errors"
-	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	_ "time/tzdata" // Embeds the time zone database used by --tz.
 
 	"github.com/drand/tlock"
 	"github.com/drand/tlock/cmd/tle/commands"
+	"github.com/drand/tlock/networks/beacon"
 	"github.com/drand/tlock/networks/http"
 )
 
@@ -21,53 +25,325 @@ func main() {
 		return
 	}
 
-	if err := run(log); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := run(ctx)
+	stop()
+
+	if err != nil {
+		var wrongChain *tlock.WrongChainError
+		var version *tlock.VersionError
+		var endpoint *http.EndpointError
 		switch {
+		case errors.Is(err, context.Canceled):
+			log.Print("interrupted")
+			os.Exit(130)
+		case errors.Is(err, tlock.ErrTooEarly) && errors.As(err, &endpoint):
+			log.Fatalf("%v: %v", tlock.ErrTooEarly, endpoint)
 		case errors.Is(err, tlock.ErrTooEarly):
 			log.Fatal(tlock.ErrTooEarly)
 		case errors.Is(err, http.ErrNotUnchained):
 			log.Fatal(http.ErrNotUnchained)
+		case errors.As(err, &wrongChain):
+			log.Fatalf("%v; use --chain-from-header to select the chain of the input", wrongChain)
+		case errors.As(err, &version):
+			log.Fatal(version)
+		case errors.Is(err, tlock.ErrUTF16Input):
+			log.Fatalf("%v; write the encrypted output with -o or use --armor when redirecting in PowerShell", tlock.ErrUTF16Input)
 		default:
 			log.Fatal(err)
 		}
 	}
 }
 
-func run(log *log.Logger) error {
+func run(ctx context.Context) error {
+	if handled, err := commands.Dispatch(ctx, os.Stdout, os.Args[1:]); handled {
+		return err
+	}
+
 	flags, err := commands.Parse()
 	if err != nil {
 		return fmt.Errorf("parse commands: %v", err)
 	}
 
-	var src io.Reader = os.Stdin
-	if name := flag.Arg(0); name != "" && name != "-" {
-		f, err := os.OpenFile(name, os.O_RDONLY, 0644)
-		if err != nil {
-			return fmt.Errorf("failed to open input file %q: %v", name, err)
+	logger := commands.NewLogger(os.Stderr, flags.Level())
+	clock := commands.SystemClock{}
+
+	var src io.ReadCloser
+	switch {
+	case flags.Dir != "":
+		src = commands.TarDirectory(logger, flags.Dir, flags.Compress)
+	case flags.Mmap:
+		if src, err = commands.MapInput(logger, flags.Input); err != nil {
+			return err
+		}
+	default:
+		if src, err = commands.OpenInput(ctx, flags.Input); err != nil {
+			return err
+		}
+	}
+	defer src.Close()
+
+	var in io.Reader = src
+	var header tlock.Header
+	switch {
+	case flags.Decrypt && flags.Records == "":
+		if header, in, err = commands.PeekHeader(in); err != nil {
+			return err
+		}
+	case !flags.Decrypt && flags.Records == "" && flags.Dir == "" && !flags.Force:
+		if in, err = commands.CheckEncrypted(in); err != nil {
+			return err
+		}
+	}
+
+	// Without an output, data that would be written to a terminal goes to a
+	// file named after the input instead, which is never replaced.
+	var derived bool
+	if flags.Output == "" && commands.StdoutIsTerminal() {
+		if flags.Output = commands.DefaultOutput(flags); flags.Output != "" {
+			derived = true
+			logger.Infof("writing the output to %s", flags.Output)
 		}
-		defer f.Close()
-		src = f
 	}
 
 	var dst io.Writer = os.Stdout
-	if name := flags.Output; name != "" && name != "-" {
-		f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+	var out *commands.Output
+	var tree *commands.Extractor
+	var progress *commands.Progress
+	switch name := flags.Output; {
+	case name == "" || name == "-":
+	case flags.Decrypt && commands.IsDirectoryContent(header.ContentType):
+		if flags.Resume {
+			return errors.New("--resume can't be used to restore a directory")
+		}
+		tree, err = commands.NewExtractor(name, header.ContentType)
+		if err != nil {
+			return err
+		}
+		defer tree.Abort()
+		dst = tree
+	case flags.Resume:
+		out, progress, err = commands.OpenProgress(name, flags.Input, flags.Decrypt, clock)
+		if err != nil {
+			return err
+		}
+		defer out.Abort()
+		dst = out
+	case derived:
+		out, err = commands.CreateNewOutput(name)
+		if err != nil {
+			return err
+		}
+		defer out.Abort()
+		dst = out
+	default:
+		out, err = commands.CreateOutput(name)
 		if err != nil {
 			return fmt.Errorf("failed to open output file %q: %v", name, err)
 		}
-		defer f.Close()
-		dst = f
+		defer out.Abort()
+		dst = out
 	}
 
-	network, err := http.NewNetwork(flags.Network, flags.Chain)
+	var network *http.Network
+	var bundle *beacon.Bundle
+	switch {
+	case flags.BeaconFile != "":
+		bundle, err = commands.ReadBeacon(flags.BeaconFile, header)
+	case flags.ChainFromHeader:
+		network, err = commands.NetworkForHeader(ctx, logger, flags.Network, flags.PinFile, header)
+	default:
+		network, err = commands.ProbeNetworks(ctx, logger, flags.Network, flags.PinFile, flags.Chain, header.RoundNumber)
+	}
 	if err != nil {
 		return err
 	}
 
+	// Decryption uses the beacon file instead of the network if one is given.
+	var chain tlock.Network = network
+	if bundle != nil {
+		chain = bundle
+		if err := commands.VerifyPin(flags.PinFile, chain.ChainHash(), chain.PublicKey()); err != nil {
+			return err
+		}
+	}
+
+	if flags.Wait {
+		if err := commands.WaitForRound(ctx, logger, clock, network, header.RoundNumber); err != nil {
+			return err
+		}
+	}
+
+	var stats *commands.Stats
+	if flags.Stats {
+		stats, in, dst = commands.NewStats(clock, in, dst)
+	}
+
+	// The digest of resumed operations is read from the files once they
+	// complete.
+	var digest *commands.Digest
+	if (flags.AuditLog != "" || flags.Receipt != "") && progress == nil {
+		digest = commands.NewDigest()
+		if flags.Decrypt {
+			in = digest.Reader(in)
+		} else {
+			dst = digest.Writer(dst)
+		}
+	}
+
+	// Decrypted data that still looks encrypted was most likely encrypted
+	// twice, which is pointed out once it's written.
+	var layers *commands.LayerCheck
+	if flags.Decrypt && flags.Records == "" && tree == nil && progress == nil {
+		layers = commands.NewLayerCheck(dst)
+		dst = layers
+	}
+
+	roundNumber := header.RoundNumber
 	switch {
+	case progress != nil && flags.Decrypt:
+		err = commands.ResumeDecrypt(ctx, logger, clock, flags, progress, dst, src.(io.ReadSeeker), chain)
+	case progress != nil:
+		err = commands.ResumeEncrypt(ctx, logger, clock, flags, progress, dst, src.(io.ReadSeeker), network)
+	case flags.Records != "" && flags.Decrypt:
+		err = commands.DecryptRecords(ctx, logger, clock, flags, dst, in, chain)
+	case flags.Records != "":
+		roundNumber, err = commands.EncryptRecords(ctx, logger, clock, flags, dst, in, network)
 	case flags.Decrypt:
-		return tlock.New(network).Decrypt(dst, src)
+		err = tlock.New(chain, commands.Options(logger, clock, flags)...).DecryptContext(ctx, dst, in)
+	default:
+		roundNumber, err = commands.Encrypt(ctx, logger, clock, flags, dst, in, network)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if layers != nil && layers.Encrypted() {
+		logger.Infof("the decrypted data looks encrypted as well, so it was likely encrypted twice; decrypt it again to recover the original data")
+	}
+
+	if flags.Decrypt && flags.Mode && out != nil {
+		if err := commands.RestoreMode(out, flags.Input); err != nil {
+			return err
+		}
+	}
+
+	if out != nil {
+		if err := out.Commit(); err != nil {
+			return err
+		}
+	}
+
+	if progress != nil {
+		if err := progress.Done(); err != nil {
+			return err
+		}
+	}
+
+	if tree != nil {
+		if err := tree.Commit(); err != nil {
+			return err
+		}
+	}
+
+	if flags.AuditLog != "" {
+		if err := audit(flags, clock, digest, roundNumber, chain, network); err != nil {
+			return err
+		}
+	}
+
+	if flags.Receipt != "" {
+		if err := receipt(ctx, flags, clock, digest, roundNumber, network); err != nil {
+			return err
+		}
+	}
+
+	if stats != nil {
+		if err := stats.Write(os.Stderr, flags.Decrypt, flags.JSON); err != nil {
+			return err
+		}
+	}
+
+	if flags.Remove || flags.Shred {
+		return commands.RemoveInput(flags.Input, flags.Shred)
+	}
+
+	return nil
+}
+
+// audit appends the completed operation to the audit log. Without a digest,
+// the encrypted data is read back from its file.
+func audit(flags commands.Flags, clock commands.Clock, digest *commands.Digest, roundNumber uint64, chain tlock.Network, network *http.Network) error {
+	r := commands.AuditRecord{
+		Time:      clock.Now().UTC(),
+		Operation: "encrypt",
+		Input:     flags.Input,
+		Output:    flags.Output,
+		Round:     roundNumber,
+		ChainHash: chain.ChainHash(),
+	}
+	if flags.Decrypt {
+		r.Operation = "decrypt"
+	}
+	if flags.Dir != "" {
+		r.Input = flags.Dir
+	}
+	if r.Input == "" {
+		r.Input = "-"
+	}
+	if r.Output == "" {
+		r.Output = "-"
+	}
+	if network != nil {
+		r.Endpoint = network.Host()
+	}
+
+	switch {
+	case digest != nil:
+		r.SHA256 = digest.Sum()
+
+	default:
+		encrypted := flags.Output
+		if flags.Decrypt {
+			encrypted = flags.Input
+		}
+
+		sum, header, err := commands.DigestFile(encrypted)
+		if err != nil {
+			return fmt.Errorf("audit: %w", err)
+		}
+		r.SHA256, r.Round = sum, header.RoundNumber
+	}
+
+	return commands.AppendAudit(flags.AuditLog, r)
+}
+
+// receipt writes a signed receipt of the encryption. Without a digest, the
+// encrypted data is read back from its file.
+func receipt(ctx context.Context, flags commands.Flags, clock commands.Clock, digest *commands.Digest, roundNumber uint64, network *http.Network) error {
+	key, err := commands.SigningKey(flags.SigningKey)
+	if err != nil {
+		return err
+	}
+
+	var sum string
+	switch {
+	case digest != nil:
+		sum = digest.Sum()
+
 	default:
-		return commands.Encrypt(flags, dst, src, network)
+		var header tlock.Header
+		if sum, header, err = commands.DigestFile(flags.Output); err != nil {
+			return fmt.Errorf("receipt: %w", err)
+		}
+		roundNumber = header.RoundNumber
+	}
+
+	r, err := commands.NewReceipt(ctx, clock, network, sum, roundNumber, key)
+	if err != nil {
+		return err
 	}
+
+	return commands.WriteReceipt(flags.Receipt, r)
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/drand/tlock/internal/fakenet"
+	"github.com/rogpeppe/go-internal/testscript"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(testscript.RunMain(m, map[string]func() int{
+		"tle": func() int {
+			main()
+			return 0
+		},
+	}))
+}
+
+// Test_Scripts runs the scripts in testdata/script against a fake network.
+// The chain in TLE_CHAIN follows the clock, while the chain in OPEN_CHAIN
+// signs any round right away so decryption doesn't require waiting. Its
+// signature of round 100 is in OPEN_SIGNATURE.
+func Test_Scripts(t *testing.T) {
+	testscript.Run(t, testscript.Params{
+		Dir: filepath.Join("testdata", "script"),
+		Setup: func(env *testscript.Env) error {
+			live := fakenet.NewChain(3 * time.Second)
+			open := fakenet.NewChain(3 * time.Second)
+			open.Unlock()
+
+			signature, err := open.Signature(100)
+			if err != nil {
+				return err
+			}
+
+			srv := httptest.NewServer(fakenet.Handler(live, open))
+			env.Defer(srv.Close)
+
+			env.Setenv("TLE_NETWORK", srv.URL)
+			env.Setenv("TLE_CHAIN", live.ChainHash())
+			env.Setenv("TLE_PINFILE", filepath.Join(env.WorkDir, "known_chains"))
+			env.Setenv("OPEN_CHAIN", open.ChainHash())
+			env.Setenv("OPEN_SIGNATURE", hex.EncodeToString(signature))
+			return nil
+		},
+		Cmds: map[string]func(ts *testscript.TestScript, neg bool, args []string){
+			"sleep": sleep,
+		},
+	})
+}
+
+// sleep waits for the duration given as its argument, so scripts can wait for
+// the next round of the chain in TLE_CHAIN.
+func sleep(ts *testscript.TestScript, neg bool, args []string) {
+	if neg || len(args) != 1 {
+		ts.Fatalf("usage: sleep duration")
+	}
+
+	d, err := time.ParseDuration(args[0])
+	ts.Check(err)
+	time.Sleep(d)
+}
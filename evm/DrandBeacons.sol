@@ -0,0 +1,15 @@
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+pragma solidity ^0.8.4;
+
+/// @title IDrandBeacons
+/// @notice A proposed interface of oracle contracts posting drand beacons on
+/// chain, which the BeaconSource of the evm Go package reads to decrypt data
+/// without access to drand. No deployed oracle implements it yet: existing
+/// ones need an adapter contract implementing it in front of them. The
+/// signatures don't have to be verified by the oracle, since tlock verifies
+/// them against the public key of the chain.
+interface IDrandBeacons {
+    /// @notice Returns the signature of the round of the chain, or no bytes if
+    /// the beacon of the round isn't posted yet.
+    function signature(bytes32 chainHash, uint64 roundNumber) external view returns (bytes memory);
+}
@@ -0,0 +1,17 @@
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+pragma solidity ^0.8.4;
+
+/// @title ITimevault
+/// @notice The interface of escrow contracts holding tlock encrypted blobs
+/// until their round is reached, whose calldata the evm Go package and tle
+/// vault produce and check. Blobs are registered with the commitment to their
+/// time lock, as checked by TlockCommitment, and revealed with the signature
+/// of their round, which decrypts them and which anyone can verify off chain.
+interface ITimevault {
+    /// @notice Registers the blob with the hash, locked to the round of the
+    /// chain.
+    function register(bytes32 chainHash, uint64 roundNumber, bytes32 blobHash) external;
+
+    /// @notice Reveals the registered blob with the signature of its round.
+    function reveal(bytes32 chainHash, uint64 roundNumber, bytes32 blobHash, bytes calldata signature) external;
+}
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+pragma solidity ^0.8.4;
+
+/// @title TlockCommitment
+/// @notice Checks that tlock encrypted blobs are locked to a round of a drand
+/// chain, as committed to by the evm Go package. The blob is the binary form
+/// of the encrypted data, whose header starts with the time lock.
+library TlockCommitment {
+    struct Commitment {
+        bytes32 chainHash;
+        uint64 roundNumber;
+        bytes32 blobHash;
+    }
+
+    bytes private constant INTRO = "age-encryption.org/v1\n-> tlock ";
+
+    /// @notice Decodes a commitment encoded with MarshalABI.
+    function decode(bytes memory data) internal pure returns (Commitment memory c) {
+        (c.chainHash, c.roundNumber, c.blobHash) = abi.decode(data, (bytes32, uint64, bytes32));
+    }
+
+    /// @notice Returns the hash identifying the commitment, as returned by
+    /// Commitment.Hash.
+    function hash(Commitment memory c) internal pure returns (bytes32) {
+        return keccak256(abi.encode(c.chainHash, c.roundNumber, c.blobHash));
+    }
+
+    /// @notice Reports whether the blob is the one of the commitment and
+    /// starts with the time lock to its round and chain.
+    function verify(Commitment memory c, bytes calldata blob) internal pure returns (bool) {
+        return keccak256(blob) == c.blobHash && lockedTo(blob, c.roundNumber, c.chainHash);
+    }
+
+    /// @notice Reports whether the blob starts with the time lock to the round
+    /// of the chain, as reported by LockedTo.
+    function lockedTo(bytes calldata blob, uint64 roundNumber, bytes32 chainHash) internal pure returns (bool) {
+        bytes memory prefix = abi.encodePacked(INTRO, _decimal(roundNumber), " ", _hex(chainHash));
+        if (blob.length <= prefix.length || keccak256(blob[:prefix.length]) != keccak256(prefix)) {
+            return false;
+        }
+
+        bytes1 next = blob[prefix.length];
+        return next == " " || next == "\n";
+    }
+
+    function _decimal(uint64 n) private pure returns (bytes memory b) {
+        if (n == 0) {
+            return "0";
+        }
+
+        uint256 digits;
+        for (uint64 m = n; m != 0; m /= 10) {
+            digits++;
+        }
+
+        b = new bytes(digits);
+        for (; n != 0; n /= 10) {
+            b[--digits] = bytes1(uint8(48 + (n % 10)));
+        }
+    }
+
+    function _hex(bytes32 value) private pure returns (bytes memory b) {
+        bytes16 symbols = "0123456789abcdef";
+
+        b = new bytes(64);
+        for (uint256 i = 0; i < 32; i++) {
+            b[2 * i] = symbols[uint8(value[i]) >> 4];
+            b[2 * i + 1] = symbols[uint8(value[i]) & 0x0f];
+        }
+    }
+}
@@ -0,0 +1,218 @@
+package evm
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/drand/tlock/networks"
+	"golang.org/x/crypto/sha3"
+)
+
+// beaconMethod is the function of the IDrandBeacons interface proposed in
+// DrandBeacons.sol that returns the signature of a round.
+const beaconMethod = "signature(bytes32,uint64)"
+
+// ErrBeaconNotPosted represents an error when the oracle contract doesn't
+// hold the beacon of a round yet.
+var ErrBeaconNotPosted = errors.New("beacon isn't posted yet")
+
+// This assertion checks that BeaconSource keeps satisfying the contract of
+// the networks package.
+var _ networks.BeaconSource = (*BeaconSource)(nil)
+
+// =============================================================================
+
+// SourceOption configures a beacon source constructed with NewBeaconSource.
+type SourceOption func(s *BeaconSource)
+
+// WithHTTPClient sets the HTTP client the JSON-RPC requests are made with.
+// The default is http.DefaultClient.
+func WithHTTPClient(client *http.Client) SourceOption {
+	return func(s *BeaconSource) {
+		s.client = client
+	}
+}
+
+// BeaconSource reads the drand beacons posted on chain by an oracle contract
+// implementing IDrandBeacons, with the eth_call method of the JSON-RPC API of
+// an Ethereum node. Since IDrandBeacons is a proposed interface, the contract
+// has to be written for it, or be an adapter in front of an existing oracle. This allows data to be decrypted where drand and its
+// relays can't be reached but a node can, such as the infrastructure of a
+// rollup.
+type BeaconSource struct {
+	url       string
+	contract  string
+	chainHash [32]byte
+	client    *http.Client
+}
+
+// NewBeaconSource constructs a source for the chain identified by the chain
+// hash, reading the beacons from the oracle contract at the address through
+// the JSON-RPC API served at the URL.
+func NewBeaconSource(url string, contract string, chainHash string, opts ...SourceOption) (*BeaconSource, error) {
+	address, err := hex.DecodeString(strings.TrimPrefix(contract, "0x"))
+	if err != nil || len(address) != 20 {
+		return nil, fmt.Errorf("contract address %q isn't 20 hex encoded bytes", contract)
+	}
+
+	s := BeaconSource{
+		url:      url,
+		contract: "0x" + hex.EncodeToString(address),
+		client:   http.DefaultClient,
+	}
+
+	if n, err := hex.Decode(s.chainHash[:], []byte(chainHash)); err != nil || n != len(s.chainHash) {
+		return nil, fmt.Errorf("chain hash %q isn't 32 hex encoded bytes", chainHash)
+	}
+
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	return &s, nil
+}
+
+// GetBeacon reads the signature of the round from the oracle contract. It
+// fails with ErrBeaconNotPosted if the contract doesn't hold it yet.
+func (s *BeaconSource) GetBeacon(ctx context.Context, roundNumber uint64) ([]byte, error) {
+	result, err := s.call(ctx, beaconCall(s.chainHash, roundNumber))
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := unmarshalBytes(result, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(signature) == 0 {
+		return nil, fmt.Errorf("%w: round %d", ErrBeaconNotPosted, roundNumber)
+	}
+
+	return signature, nil
+}
+
+// =============================================================================
+
+// rpcRequest is the body of a JSON-RPC request.
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+// rpcResponse is the body of a JSON-RPC response.
+type rpcResponse struct {
+	Result string `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// call calls the contract with the data on the latest block, returning the
+// data it returned.
+func (s *BeaconSource) call(ctx context.Context, data []byte) ([]byte, error) {
+	call := map[string]string{
+		"to":   s.contract,
+		"data": "0x" + hex.EncodeToString(data),
+	}
+
+	body, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: 1, Method: "eth_call", Params: []interface{}{call, "latest"}})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("eth_call: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("eth_call: node responded %s", resp.Status)
+	}
+
+	var r rpcResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("eth_call: decode response: %w", err)
+	}
+
+	if r.Error != nil {
+		return nil, fmt.Errorf("eth_call: %s (code %d)", r.Error.Message, r.Error.Code)
+	}
+
+	result, err := hex.DecodeString(strings.TrimPrefix(r.Result, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("eth_call: decode result: %w", err)
+	}
+
+	return result, nil
+}
+
+// beaconCall returns the ABI encoding of the call of the signature function
+// of the oracle for the round of the chain.
+func beaconCall(chainHash [32]byte, roundNumber uint64) []byte {
+	b := make([]byte, 4+2*32)
+	copy(b[:4], selector(beaconMethod))
+	copy(b[4:36], chainHash[:])
+	binary.BigEndian.PutUint64(b[60:68], roundNumber)
+
+	return b
+}
+
+// unmarshalBytes decodes the ABI encoding of bytes whose offset is in the
+// word at the specified position: the offset of the bytes, followed by their
+// length and the bytes padded to a whole number of words.
+func unmarshalBytes(b []byte, at uint64) ([]byte, error) {
+	word := func(off uint64) (uint64, error) {
+		if off > uint64(len(b)) || uint64(len(b))-off < 32 {
+			return 0, fmt.Errorf("%w: data is too short", ErrInvalidABI)
+		}
+		if !bytes.Equal(b[off:off+24], make([]byte, 24)) {
+			return 0, fmt.Errorf("%w: data is too large", ErrInvalidABI)
+		}
+		return binary.BigEndian.Uint64(b[off+24 : off+32]), nil
+	}
+
+	off, err := word(at)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := word(off)
+	if err != nil {
+		return nil, err
+	}
+
+	start := off + 32
+	if n > math.MaxUint64-start || start+n > uint64(len(b)) {
+		return nil, fmt.Errorf("%w: data is too short", ErrInvalidABI)
+	}
+
+	return b[start : start+n], nil
+}
+
+// selector returns the selector of the function with the signature, which
+// starts the calldata of its calls.
+func selector(signature string) []byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte(signature))
+
+	return h.Sum(nil)[:4]
+}
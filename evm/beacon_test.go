@@ -0,0 +1,144 @@
+package evm_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/evm"
+	"github.com/drand/tlock/internal/fakenet"
+)
+
+// oracle is the address of the oracle contract the node serves.
+const oracle = "0x00000000000000000000000000000000000d7a2d"
+
+// node serves eth_call for an oracle contract holding the beacons of the
+// chain up to the posted round.
+func node(t *testing.T, chain *fakenet.Chain, posted uint64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != "eth_call" || len(req.Params) != 2 {
+			t.Errorf("unexpected request %+v: %v", req, err)
+			return
+		}
+
+		var call struct {
+			To   string `json:"to"`
+			Data string `json:"data"`
+		}
+		json.Unmarshal(req.Params[0], &call)
+		data, _ := hex.DecodeString(strings.TrimPrefix(call.Data, "0x"))
+
+		// The selector of signature(bytes32,uint64).
+		if call.To != oracle || len(data) != 68 || hex.EncodeToString(data[:4]) != "4335a5c7" {
+			w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"execution reverted"}}`))
+			return
+		}
+
+		var signature []byte
+		roundNumber := binary.BigEndian.Uint64(data[60:68])
+		if hex.EncodeToString(data[4:36]) == chain.ChainHash() && roundNumber <= posted {
+			signature, _ = chain.Signature(roundNumber)
+		}
+
+		// The ABI encoding of bytes: offset, length and padded bytes.
+		result := make([]byte, 64+(len(signature)+31)/32*32)
+		result[31] = 32
+		binary.BigEndian.PutUint64(result[56:64], uint64(len(signature)))
+		copy(result[64:], signature)
+
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x` + hex.EncodeToString(result) + `"}`))
+	}))
+}
+
+func Test_BeaconSource(t *testing.T) {
+	network := fakenet.NewChainFromSeed(3*time.Second, "evm beacons")
+	signer := fakenet.NewChainFromSeed(3*time.Second, "evm beacons")
+	signer.Unlock()
+	future := network.RoundNumber(time.Now()) + 100
+
+	srv := node(t, signer, future)
+	defer srv.Close()
+
+	source, err := evm.NewBeaconSource(srv.URL, oracle, network.ChainHash())
+	if err != nil {
+		t.Fatalf("source error %s", err)
+	}
+
+	var cipherData bytes.Buffer
+	if err := tlock.New(network).Encrypt(&cipherData, strings.NewReader("on chain"), future); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	var plainData bytes.Buffer
+	if err := tlock.New(network, tlock.WithBeaconSource(source)).Decrypt(&plainData, bytes.NewReader(cipherData.Bytes())); err != nil {
+		t.Fatalf("decrypt error %s", err)
+	}
+	if plainData.String() != "on chain" {
+		t.Fatalf("unexpected plain data %q", plainData.String())
+	}
+
+	if _, err := source.GetBeacon(context.Background(), future+1); !errors.Is(err, evm.ErrBeaconNotPosted) {
+		t.Fatalf("expecting error %s; got %v", evm.ErrBeaconNotPosted, err)
+	}
+
+	var later bytes.Buffer
+	if err := tlock.New(network).Encrypt(&later, strings.NewReader("on chain"), future+1); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	if err := tlock.New(network, tlock.WithBeaconSource(source)).Decrypt(&plainData, &later); !errors.Is(err, tlock.ErrTooEarly) {
+		t.Fatalf("expecting error %s; got %v", tlock.ErrTooEarly, err)
+	}
+
+	other, err := evm.NewBeaconSource(srv.URL, "0x000000000000000000000000000000000000dead", network.ChainHash())
+	if err != nil {
+		t.Fatalf("source error %s", err)
+	}
+	if _, err := other.GetBeacon(context.Background(), future); err == nil || !strings.Contains(err.Error(), "execution reverted") {
+		t.Fatalf("expecting the error of the node; got %v", err)
+	}
+}
+
+func Test_BeaconSourceErrors(t *testing.T) {
+	chainHash := fakenet.NewChain(3 * time.Second).ChainHash()
+
+	if _, err := evm.NewBeaconSource("http://localhost", "0xdead", chainHash); err == nil {
+		t.Fatal("expecting an error for a short contract address")
+	}
+	if _, err := evm.NewBeaconSource("http://localhost", oracle, "cafe"); err == nil {
+		t.Fatal("expecting an error for a short chain hash")
+	}
+
+	results := map[string]string{
+		"short":     "0x20",
+		"offset":    "0x" + strings.Repeat("ff", 32),
+		"truncated": "0x" + strings.Repeat("00", 31) + "20" + strings.Repeat("00", 31) + "40" + strings.Repeat("00", 32),
+	}
+	for name, result := range results {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"` + result + `"}`))
+		}))
+
+		source, err := evm.NewBeaconSource(srv.URL, oracle, chainHash)
+		if err != nil {
+			t.Fatalf("source error %s", err)
+		}
+		if _, err := source.GetBeacon(context.Background(), 1); !errors.Is(err, evm.ErrInvalidABI) {
+			t.Fatalf("%s: expecting error %s; got %v", name, evm.ErrInvalidABI, err)
+		}
+
+		srv.Close()
+	}
+}
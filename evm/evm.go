@@ -0,0 +1,169 @@
+// Package evm encodes the time lock of encrypted data for smart contracts, so
+// contracts can check that a blob is locked to a round of a chain, such as
+// the sealed bids of an auction that are only revealed once the auction
+// closes. The commitment uses the ABI encoding of Solidity, and
+// TlockCommitment.sol provides a library checking commitments against the
+// blobs they are about.
+//
+// The package also encodes the calls to the escrow contracts of Timevault.sol
+// and checks their reveals, and reads the drand beacons posted on chain by
+// oracle contracts implementing IDrandBeacons, so data can be decrypted with
+// chain data alone. IDrandBeacons, in DrandBeacons.sol, is an interface
+// proposed by this package: no deployed oracle implements it yet, and
+// existing oracles, which expose their own functions, can't be read without
+// an adapter contract implementing it.
+package evm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/armor"
+	"golang.org/x/crypto/sha3"
+)
+
+// ABISize is the number of bytes of an ABI encoded commitment, which is
+// decoded in Solidity with abi.decode(data, (bytes32, uint64, bytes32)).
+const ABISize = 3 * 32
+
+// intro starts the binary form of every blob, followed by the round and chain
+// hash of the time lock.
+const intro = "age-encryption.org/v1\n-> tlock "
+
+// ErrInvalidABI represents an error when an ABI encoded commitment can't be
+// decoded.
+var ErrInvalidABI = errors.New("invalid ABI encoding")
+
+// Commitment states that the blob with the hash is locked to the round of the
+// chain. The hash is the keccak256 of the binary form of the blob, which is
+// what contracts compute, so armored blobs have to be dearmored before they
+// are handed to a contract.
+type Commitment struct {
+	ChainHash   [32]byte
+	RoundNumber uint64
+	BlobHash    [32]byte
+}
+
+// Commit reads the encrypted data from the source, which can be armored, and
+// returns the commitment to its time lock. This doesn't require access to the
+// network. The header has to start with the time lock, as it does when
+// written by tlock, so contracts can check the commitment against the blob.
+func Commit(src io.Reader) (Commitment, error) {
+	br := bufio.NewReader(src)
+
+	var r io.Reader = br
+	if start, _ := br.Peek(len(armor.Prefix)); string(start) == armor.Prefix {
+		r = armor.NewReader(br)
+	}
+
+	// Everything read from the source is hashed, including what is read
+	// ahead of the header.
+	h := sha3.NewLegacyKeccak256()
+	// The start is long enough for the largest round, the chain hash and the
+	// separator that follows it.
+	start := startWriter{max: len(intro) + 20 + 1 + 64 + 1}
+	r = io.TeeReader(r, io.MultiWriter(h, &start))
+
+	header, err := tlock.ReadHeader(r)
+	if err != nil {
+		return Commitment{}, err
+	}
+
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return Commitment{}, fmt.Errorf("read blob: %w", err)
+	}
+
+	c := Commitment{RoundNumber: header.RoundNumber}
+	if n, err := hex.Decode(c.ChainHash[:], []byte(header.ChainHash)); err != nil || n != len(c.ChainHash) {
+		return Commitment{}, fmt.Errorf("chain hash %q isn't 32 hex encoded bytes", header.ChainHash)
+	}
+	copy(c.BlobHash[:], h.Sum(nil))
+
+	if !LockedTo(start.b, c.RoundNumber, c.ChainHash) {
+		return Commitment{}, errors.New("header doesn't start with the time lock")
+	}
+
+	return c, nil
+}
+
+// MarshalABI returns the ABI encoding of the commitment, as encoded in
+// Solidity with abi.encode(chainHash, roundNumber, blobHash).
+func (c Commitment) MarshalABI() []byte {
+	b := make([]byte, ABISize)
+	copy(b[:32], c.ChainHash[:])
+	binary.BigEndian.PutUint64(b[56:64], c.RoundNumber)
+	copy(b[64:], c.BlobHash[:])
+
+	return b
+}
+
+// UnmarshalABI decodes a commitment encoded by MarshalABI.
+func UnmarshalABI(b []byte) (Commitment, error) {
+	if len(b) != ABISize {
+		return Commitment{}, fmt.Errorf("%w: expecting %d bytes, got %d", ErrInvalidABI, ABISize, len(b))
+	}
+
+	// The round is a uint64, so the padding of its word has to be zero.
+	if !bytes.Equal(b[32:56], make([]byte, 24)) {
+		return Commitment{}, fmt.Errorf("%w: round doesn't fit in a uint64", ErrInvalidABI)
+	}
+
+	var c Commitment
+	copy(c.ChainHash[:], b[:32])
+	c.RoundNumber = binary.BigEndian.Uint64(b[56:64])
+	copy(c.BlobHash[:], b[64:])
+
+	return c, nil
+}
+
+// Hash returns the keccak256 of the ABI encoding of the commitment, which
+// identifies it in contracts.
+func (c Commitment) Hash() [32]byte {
+	var sum [32]byte
+
+	h := sha3.NewLegacyKeccak256()
+	h.Write(c.MarshalABI())
+	copy(sum[:], h.Sum(nil))
+
+	return sum
+}
+
+// LockedTo reports whether the binary form of the blob starts with the time
+// lock to the round of the chain. This is the check made by contracts, which
+// can't parse the complete header.
+func LockedTo(blob []byte, roundNumber uint64, chainHash [32]byte) bool {
+	prefix := intro + strconv.FormatUint(roundNumber, 10) + " " + hex.EncodeToString(chainHash[:])
+	if len(blob) <= len(prefix) || string(blob[:len(prefix)]) != prefix {
+		return false
+	}
+
+	next := blob[len(prefix)]
+	return next == ' ' || next == '\n'
+}
+
+// =============================================================================
+
+// startWriter keeps the first bytes written to it.
+type startWriter struct {
+	b   []byte
+	max int
+}
+
+// Write keeps the bytes until the maximum is reached.
+func (w *startWriter) Write(p []byte) (int, error) {
+	if n := w.max - len(w.b); n > 0 {
+		if len(p) < n {
+			n = len(p)
+		}
+		w.b = append(w.b, p[:n]...)
+	}
+
+	return len(p), nil
+}
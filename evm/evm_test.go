@@ -0,0 +1,166 @@
+package evm_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/drand/tlock/armor"
+	"github.com/drand/tlock/evm"
+	"github.com/drand/tlock/internal/fakenet"
+)
+
+// vector is a test vector of testdata/vectors.json, which can also be used to
+// test contracts.
+type vector struct {
+	Name           string `json:"name"`
+	Blob           string `json:"blob"`
+	ChainHash      string `json:"chain_hash"`
+	Round          uint64 `json:"round"`
+	BlobHash       string `json:"blob_hash"`
+	ABI            string `json:"abi"`
+	CommitmentHash string `json:"commitment_hash"`
+}
+
+func readVectors(t *testing.T) []vector {
+	b, err := os.ReadFile("testdata/vectors.json")
+	if err != nil {
+		t.Fatalf("read vectors error %s", err)
+	}
+
+	var vectors []vector
+	if err := json.Unmarshal(b, &vectors); err != nil {
+		t.Fatalf("parse vectors error %s", err)
+	}
+
+	return vectors
+}
+
+func Test_Vectors(t *testing.T) {
+	for _, v := range readVectors(t) {
+		blob, _ := hex.DecodeString(v.Blob)
+
+		c, err := evm.Commit(bytes.NewReader(blob))
+		if err != nil {
+			t.Fatalf("%s: commit error %s", v.Name, err)
+		}
+
+		if got := hex.EncodeToString(c.ChainHash[:]); got != v.ChainHash {
+			t.Fatalf("%s: expecting chain hash %s; got %s", v.Name, v.ChainHash, got)
+		}
+		if c.RoundNumber != v.Round {
+			t.Fatalf("%s: expecting round %d; got %d", v.Name, v.Round, c.RoundNumber)
+		}
+		if got := hex.EncodeToString(c.BlobHash[:]); got != v.BlobHash {
+			t.Fatalf("%s: expecting blob hash %s; got %s", v.Name, v.BlobHash, got)
+		}
+		if got := hex.EncodeToString(c.MarshalABI()); got != v.ABI {
+			t.Fatalf("%s: expecting ABI encoding %s; got %s", v.Name, v.ABI, got)
+		}
+		if sum := c.Hash(); hex.EncodeToString(sum[:]) != v.CommitmentHash {
+			t.Fatalf("%s: expecting commitment hash %s; got %x", v.Name, v.CommitmentHash, sum)
+		}
+
+		abi, _ := hex.DecodeString(v.ABI)
+		decoded, err := evm.UnmarshalABI(abi)
+		if err != nil || decoded != c {
+			t.Fatalf("%s: expecting the ABI encoding to decode to the commitment; got %v", v.Name, err)
+		}
+
+		if !evm.LockedTo(blob, c.RoundNumber, c.ChainHash) {
+			t.Fatalf("%s: expecting the blob to be locked to the round", v.Name)
+		}
+		if evm.LockedTo(blob, c.RoundNumber+1, c.ChainHash) || evm.LockedTo(blob, c.RoundNumber/10, c.ChainHash) {
+			t.Fatalf("%s: expecting the blob not to be locked to other rounds", v.Name)
+		}
+
+		// Armored blobs commit to their binary form.
+		var armored bytes.Buffer
+		w := armor.NewWriter(&armored)
+		w.Write(blob)
+		w.Close()
+
+		ac, err := evm.Commit(&armored)
+		if err != nil || ac != c {
+			t.Fatalf("%s: expecting the armored blob to have the same commitment; got %v", v.Name, err)
+		}
+	}
+}
+
+func Test_InvalidABI(t *testing.T) {
+	abi, _ := hex.DecodeString(readVectors(t)[0].ABI)
+
+	if _, err := evm.UnmarshalABI(abi[:evm.ABISize-1]); !errors.Is(err, evm.ErrInvalidABI) {
+		t.Fatalf("expecting ErrInvalidABI for a short encoding; got %v", err)
+	}
+
+	abi[40] = 1
+	if _, err := evm.UnmarshalABI(abi); !errors.Is(err, evm.ErrInvalidABI) {
+		t.Fatalf("expecting ErrInvalidABI for a round larger than a uint64; got %v", err)
+	}
+}
+
+func Test_CommitInvalid(t *testing.T) {
+	blob, _ := hex.DecodeString(readVectors(t)[0].Blob)
+
+	tampered := bytes.Replace(blob, []byte("-> tlock"), []byte("-> other\nAAAA\n-> tlock"), 1)
+	if _, err := evm.Commit(bytes.NewReader(tampered)); err == nil {
+		t.Fatal("expecting an error for a header with another stanza")
+	}
+
+	if _, err := evm.Commit(bytes.NewReader(blob[:20])); err == nil {
+		t.Fatal("expecting an error for a truncated blob")
+	}
+}
+
+func Test_Reveal(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	abi, _ := hex.DecodeString(readVectors(t)[0].ABI)
+	c, err := evm.UnmarshalABI(abi)
+	if err != nil {
+		t.Fatalf("unmarshal error %s", err)
+	}
+	copy(c.ChainHash[:], network.Info().Hash())
+
+	signature, err := network.Signature(c.RoundNumber)
+	if err != nil {
+		t.Fatalf("signature error %s", err)
+	}
+
+	r, err := evm.ParseReveal(evm.Reveal{Commitment: c, Signature: signature}.Calldata())
+	if err != nil {
+		t.Fatalf("parse error %s", err)
+	}
+	if r.Commitment != c || !bytes.Equal(r.Signature, signature) {
+		t.Fatalf("expecting the reveal to decode to itself; got %+v", r)
+	}
+
+	if _, err := evm.VerifyReveal(network.Info(), r); err != nil {
+		t.Fatalf("verify error %s", err)
+	}
+
+	r.Commitment.RoundNumber++
+	if _, err := evm.VerifyReveal(network.Info(), r); err == nil {
+		t.Fatal("expecting an error for the signature of another round")
+	}
+
+	r.Commitment.ChainHash[0]++
+	if _, err := evm.VerifyReveal(network.Info(), r); err == nil {
+		t.Fatal("expecting an error for another chain")
+	}
+
+	if _, err := evm.ParseReveal(c.RegisterCalldata()); !errors.Is(err, evm.ErrWrongMethod) {
+		t.Fatalf("expecting error %s; got %v", evm.ErrWrongMethod, err)
+	}
+
+	calldata := evm.Reveal{Commitment: c, Signature: signature}.Calldata()
+	if _, err := evm.ParseReveal(calldata[:len(calldata)-32]); !errors.Is(err, evm.ErrInvalidABI) {
+		t.Fatalf("expecting error %s; got %v", evm.ErrInvalidABI, err)
+	}
+}
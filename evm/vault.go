@@ -0,0 +1,90 @@
+package evm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/drand/drand/chain"
+	"github.com/drand/tlock/networks/beacon"
+)
+
+// These constants define the functions of the ITimevault interface of
+// Timevault.sol, which escrow contracts implement to register blobs and
+// reveal them once their round is reached.
+const (
+	registerMethod = "register(bytes32,uint64,bytes32)"
+	revealMethod   = "reveal(bytes32,uint64,bytes32,bytes)"
+)
+
+// ErrWrongMethod represents an error when calldata doesn't call the expected
+// function of a timevault.
+var ErrWrongMethod = errors.New("calldata calls another function")
+
+// RegisterCalldata returns the calldata of the call to the register function
+// of a timevault, which records the commitment on chain.
+func (c Commitment) RegisterCalldata() []byte {
+	return append(selector(registerMethod), c.MarshalABI()...)
+}
+
+// =============================================================================
+
+// Reveal is the data of a transaction revealing a blob registered with a
+// timevault: the commitment to its time lock and the signature of its round,
+// which decrypts the blob. Contracts don't verify the signature, since that's
+// costly on chain, so anyone watching a timevault can check reveals with
+// VerifyReveal instead.
+type Reveal struct {
+	Commitment Commitment
+	Signature  []byte
+}
+
+// Calldata returns the calldata of the call to the reveal function of a
+// timevault.
+func (r Reveal) Calldata() []byte {
+	b := make([]byte, ABISize+2*32+(len(r.Signature)+31)/32*32)
+	copy(b, r.Commitment.MarshalABI())
+	binary.BigEndian.PutUint64(b[ABISize+24:ABISize+32], ABISize+32)
+	binary.BigEndian.PutUint64(b[ABISize+56:ABISize+64], uint64(len(r.Signature)))
+	copy(b[ABISize+64:], r.Signature)
+
+	return append(selector(revealMethod), b...)
+}
+
+// ParseReveal decodes the calldata of a reveal transaction, as returned by
+// Calldata.
+func ParseReveal(calldata []byte) (Reveal, error) {
+	if len(calldata) < 4 || !bytes.Equal(calldata[:4], selector(revealMethod)) {
+		return Reveal{}, fmt.Errorf("%w: expecting %s", ErrWrongMethod, revealMethod)
+	}
+	args := calldata[4:]
+
+	if len(args) < ABISize {
+		return Reveal{}, fmt.Errorf("%w: expecting at least %d bytes, got %d", ErrInvalidABI, ABISize, len(args))
+	}
+
+	c, err := UnmarshalABI(args[:ABISize])
+	if err != nil {
+		return Reveal{}, err
+	}
+
+	signature, err := unmarshalBytes(args, ABISize)
+	if err != nil {
+		return Reveal{}, err
+	}
+
+	return Reveal{Commitment: c, Signature: signature}, nil
+}
+
+// VerifyReveal checks that the signature of the reveal is the one of the
+// round of the commitment, produced by the chain with the information. It
+// returns the time at which the chain published the round.
+func VerifyReveal(info *chain.Info, r Reveal) (time.Time, error) {
+	if !bytes.Equal(info.Hash(), r.Commitment.ChainHash[:]) {
+		return time.Time{}, fmt.Errorf("reveal is for chain %x, not %s", r.Commitment.ChainHash, info.HashString())
+	}
+
+	return beacon.Verify(info, r.Commitment.RoundNumber, r.Signature)
+}
@@ -0,0 +1,473 @@
+// Package bn254 implements the pairing of the BN254 curve, also known as
+// alt_bn128, with gnark-crypto, for chains that sign their rounds on its G1
+// with a public key on its G2, like evmnet. EVM contracts can verify their
+// signatures with the precompiles of the curve. Points are encoded like drand
+// and the precompiles do: uncompressed, big endian, with the imaginary part
+// of the coordinates of G2 first.
+package bn254
+
+import (
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/drand/kyber"
+	"github.com/drand/kyber/group/mod"
+	"github.com/drand/kyber/pairing"
+	"github.com/drand/kyber/util/random"
+	"github.com/drand/kyber/xof/blake2xb"
+	"golang.org/x/crypto/sha3"
+)
+
+// DST is the domain separation tag the chains hash their rounds to G1 with.
+var DST = []byte("BLS_SIG_BN254G1_XMD:KECCAK-256_SVDW_RO_NUL_")
+
+// Identity returns the message chains sign for a round: the Keccak-256 of
+// the round as an 8 byte big endian integer.
+func Identity(roundNumber uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], roundNumber)
+
+	h := sha3.NewLegacyKeccak256()
+	h.Write(b[:])
+	return h.Sum(nil)
+}
+
+// order is the order of the groups, which scalars are reduced by.
+var order = fr.Modulus()
+
+// =============================================================================
+
+// Suite is the pairing suite of BN254. Its G1 points hash messages with the
+// domain separation tag of the suite.
+type Suite struct {
+	dst []byte
+}
+
+// NewSuite returns the suite of BN254 hashing to G1 with DST.
+func NewSuite() *Suite {
+	return &Suite{dst: DST}
+}
+
+// G1 returns the group of the signatures.
+func (s *Suite) G1() kyber.Group {
+	return &group{name: "bn254.G1", point: func() kyber.Point { return &PointG1{dst: s.dst} }}
+}
+
+// G2 returns the group of the public keys.
+func (s *Suite) G2() kyber.Group {
+	return &group{name: "bn254.G2", point: func() kyber.Point { return &PointG2{} }}
+}
+
+// GT returns the target group of the pairing.
+func (s *Suite) GT() kyber.Group {
+	return &group{name: "bn254.GT", point: func() kyber.Point { return new(PointGT).Null() }}
+}
+
+// Pair computes the pairing of a point of G1 with a point of G2.
+func (s *Suite) Pair(p1 kyber.Point, p2 kyber.Point) kyber.Point {
+	gt, err := bn254.Pair([]bn254.G1Affine{p1.(*PointG1).p}, []bn254.G2Affine{p2.(*PointG2).p})
+	if err != nil {
+		// Pairing only fails for slices of different lengths.
+		panic(err)
+	}
+
+	return &PointGT{f: gt}
+}
+
+// ValidatePairing reports whether e(p1, p2) equals e(inv1, inv2).
+func (s *Suite) ValidatePairing(p1, p2, inv1, inv2 kyber.Point) bool {
+	var neg bn254.G1Affine
+	neg.Neg(&inv1.(*PointG1).p)
+
+	ok, err := bn254.PairingCheck(
+		[]bn254.G1Affine{p1.(*PointG1).p, neg},
+		[]bn254.G2Affine{p2.(*PointG2).p, inv2.(*PointG2).p},
+	)
+	return err == nil && ok
+}
+
+// Hash returns a new SHA-256 hash, which the identity based encryption of
+// kyber derives its keys with.
+func (s *Suite) Hash() hash.Hash {
+	return sha256.New()
+}
+
+// XOF returns a new blake2xb XOF.
+func (s *Suite) XOF(seed []byte) kyber.XOF {
+	return blake2xb.New(seed)
+}
+
+// RandomStream returns a stream reading from crypto/rand.
+func (s *Suite) RandomStream() cipher.Stream {
+	return random.New()
+}
+
+// Read isn't supported, like in kyber-bls12381.
+func (s *Suite) Read(r io.Reader, objs ...interface{}) error {
+	return errors.New("bn254: unsupported operation")
+}
+
+// Write isn't supported, like in kyber-bls12381.
+func (s *Suite) Write(w io.Writer, objs ...interface{}) error {
+	return errors.New("bn254: unsupported operation")
+}
+
+// group is a group of the suite.
+type group struct {
+	name  string
+	point func() kyber.Point
+}
+
+func (g *group) String() string       { return g.name }
+func (g *group) Scalar() kyber.Scalar { return mod.NewInt64(0, order) }
+func (g *group) ScalarLen() int       { return g.Scalar().MarshalSize() }
+func (g *group) Point() kyber.Point   { return g.point() }
+func (g *group) PointLen() int        { return g.Point().MarshalSize() }
+
+// =============================================================================
+
+// Hash hashes the message to G1 as specified by RFC 9380, with
+// expand_message_xmd using Keccak-256 and the Shallue-van de Woestijne map,
+// and the domain separation tag. G1 has no cofactor to clear.
+func Hash(msg []byte, dst []byte) (*PointG1, error) {
+	b, err := expandMessage(msg, dst, 2*fieldLen)
+	if err != nil {
+		return nil, err
+	}
+
+	var u0, u1 fp.Element
+	u0.SetBytes(b[:fieldLen])
+	u1.SetBytes(b[fieldLen:])
+
+	q0, q1 := bn254.MapToG1(u0), bn254.MapToG1(u1)
+
+	p := PointG1{dst: dst}
+	p.p.Add(&q0, &q1)
+
+	return &p, nil
+}
+
+// fieldLen is the number of bytes hashed to each field element, which
+// keeps their bias negligible.
+const fieldLen = 48
+
+// expandMessage implements expand_message_xmd of RFC 9380 with Keccak-256.
+func expandMessage(msg []byte, dst []byte, n int) ([]byte, error) {
+	h := sha3.NewLegacyKeccak256()
+	size := h.Size()
+
+	ell := (n + size - 1) / size
+	if ell > 255 || n > 65535 || len(dst) > 255 {
+		return nil, errors.New("bn254: invalid expand_message_xmd length")
+	}
+	dstPrime := append(append([]byte(nil), dst...), byte(len(dst)))
+
+	h.Write(make([]byte, h.BlockSize()))
+	h.Write(msg)
+	h.Write([]byte{byte(n >> 8), byte(n), 0})
+	h.Write(dstPrime)
+	b0 := h.Sum(nil)
+
+	out := make([]byte, 0, ell*size)
+	bi := make([]byte, size)
+	for i := 1; i <= ell; i++ {
+		for j := range bi {
+			bi[j] ^= b0[j]
+		}
+
+		h.Reset()
+		h.Write(bi)
+		h.Write([]byte{byte(i)})
+		h.Write(dstPrime)
+		bi = h.Sum(nil)
+
+		out = append(out, bi...)
+	}
+
+	return out[:n], nil
+}
+
+// =============================================================================
+
+// PointG1 is a point of G1, which hashes messages with its domain separation
+// tag.
+type PointG1 struct {
+	p   bn254.G1Affine
+	dst []byte
+}
+
+func (p *PointG1) Equal(q kyber.Point) bool { return p.p.Equal(&q.(*PointG1).p) }
+func (p *PointG1) Null() kyber.Point        { p.p = bn254.G1Affine{}; return p }
+func (p *PointG1) Set(q kyber.Point) kyber.Point {
+	p.p = q.(*PointG1).p
+	return p
+}
+func (p *PointG1) Clone() kyber.Point { return &PointG1{p: p.p, dst: p.dst} }
+
+func (p *PointG1) Base() kyber.Point {
+	_, _, g1, _ := bn254.Generators()
+	p.p = g1
+	return p
+}
+
+func (p *PointG1) Pick(rand cipher.Stream) kyber.Point {
+	return p.Mul(mod.NewInt64(0, order).Pick(rand), nil)
+}
+
+func (p *PointG1) Add(a, b kyber.Point) kyber.Point {
+	p.p.Add(&a.(*PointG1).p, &b.(*PointG1).p)
+	return p
+}
+
+func (p *PointG1) Sub(a, b kyber.Point) kyber.Point {
+	p.p.Sub(&a.(*PointG1).p, &b.(*PointG1).p)
+	return p
+}
+
+func (p *PointG1) Neg(a kyber.Point) kyber.Point {
+	p.p.Neg(&a.(*PointG1).p)
+	return p
+}
+
+func (p *PointG1) Mul(s kyber.Scalar, q kyber.Point) kyber.Point {
+	if q == nil {
+		q = new(PointG1).Base()
+	}
+	p.p.ScalarMultiplication(&q.(*PointG1).p, scalarBig(s))
+	return p
+}
+
+// Hash hashes the message to the point with its domain separation tag.
+func (p *PointG1) Hash(msg []byte) kyber.Point {
+	h, err := Hash(msg, p.dst)
+	if err != nil {
+		// Hashing only fails for domains that are too long.
+		panic(err)
+	}
+
+	p.p = h.p
+	return p
+}
+
+func (p *PointG1) MarshalBinary() ([]byte, error) {
+	b := p.p.RawBytes()
+	return b[:], nil
+}
+
+func (p *PointG1) UnmarshalBinary(b []byte) error {
+	if len(b) != p.MarshalSize() {
+		return fmt.Errorf("bn254.G1: invalid length %d", len(b))
+	}
+	if _, err := p.p.SetBytes(b); err != nil {
+		return fmt.Errorf("bn254.G1: %w", err)
+	}
+	return nil
+}
+
+func (p *PointG1) MarshalSize() int                       { return bn254.SizeOfG1AffineUncompressed }
+func (p *PointG1) MarshalTo(w io.Writer) (int, error)     { return marshalTo(p, w) }
+func (p *PointG1) UnmarshalFrom(r io.Reader) (int, error) { return unmarshalFrom(p, r) }
+func (p *PointG1) String() string                         { return pointString("bn254.G1", p) }
+
+func (p *PointG1) EmbedLen() int { panic("bn254: unsupported operation") }
+func (p *PointG1) Embed(data []byte, r cipher.Stream) kyber.Point {
+	panic("bn254: unsupported operation")
+}
+func (p *PointG1) Data() ([]byte, error) { panic("bn254: unsupported operation") }
+
+// PointG2 is a point of G2.
+type PointG2 struct {
+	p bn254.G2Affine
+}
+
+func (p *PointG2) Equal(q kyber.Point) bool { return p.p.Equal(&q.(*PointG2).p) }
+func (p *PointG2) Null() kyber.Point        { p.p = bn254.G2Affine{}; return p }
+func (p *PointG2) Set(q kyber.Point) kyber.Point {
+	p.p = q.(*PointG2).p
+	return p
+}
+func (p *PointG2) Clone() kyber.Point { return &PointG2{p: p.p} }
+
+func (p *PointG2) Base() kyber.Point {
+	_, _, _, g2 := bn254.Generators()
+	p.p = g2
+	return p
+}
+
+func (p *PointG2) Pick(rand cipher.Stream) kyber.Point {
+	return p.Mul(mod.NewInt64(0, order).Pick(rand), nil)
+}
+
+func (p *PointG2) Add(a, b kyber.Point) kyber.Point {
+	p.p.Add(&a.(*PointG2).p, &b.(*PointG2).p)
+	return p
+}
+
+func (p *PointG2) Sub(a, b kyber.Point) kyber.Point {
+	p.p.Sub(&a.(*PointG2).p, &b.(*PointG2).p)
+	return p
+}
+
+func (p *PointG2) Neg(a kyber.Point) kyber.Point {
+	p.p.Neg(&a.(*PointG2).p)
+	return p
+}
+
+func (p *PointG2) Mul(s kyber.Scalar, q kyber.Point) kyber.Point {
+	if q == nil {
+		q = new(PointG2).Base()
+	}
+	p.p.ScalarMultiplication(&q.(*PointG2).p, scalarBig(s))
+	return p
+}
+
+func (p *PointG2) MarshalBinary() ([]byte, error) {
+	b := p.p.RawBytes()
+	return b[:], nil
+}
+
+// UnmarshalBinary decodes the point, which has to be in G2 and not only on
+// the twist.
+func (p *PointG2) UnmarshalBinary(b []byte) error {
+	if len(b) != p.MarshalSize() {
+		return fmt.Errorf("bn254.G2: invalid length %d", len(b))
+	}
+	if _, err := p.p.SetBytes(b); err != nil {
+		return fmt.Errorf("bn254.G2: %w", err)
+	}
+	return nil
+}
+
+func (p *PointG2) MarshalSize() int                       { return bn254.SizeOfG2AffineUncompressed }
+func (p *PointG2) MarshalTo(w io.Writer) (int, error)     { return marshalTo(p, w) }
+func (p *PointG2) UnmarshalFrom(r io.Reader) (int, error) { return unmarshalFrom(p, r) }
+func (p *PointG2) String() string                         { return pointString("bn254.G2", p) }
+
+func (p *PointG2) EmbedLen() int { panic("bn254: unsupported operation") }
+func (p *PointG2) Embed(data []byte, r cipher.Stream) kyber.Point {
+	panic("bn254: unsupported operation")
+}
+func (p *PointG2) Data() ([]byte, error) { panic("bn254: unsupported operation") }
+
+// PointGT is an element of the target group. Like in kyber, the group is
+// written additively, so adding elements multiplies them.
+type PointGT struct {
+	f bn254.GT
+}
+
+func (p *PointGT) Equal(q kyber.Point) bool { return p.f.Equal(&q.(*PointGT).f) }
+func (p *PointGT) Null() kyber.Point        { p.f.SetOne(); return p }
+func (p *PointGT) Set(q kyber.Point) kyber.Point {
+	p.f = q.(*PointGT).f
+	return p
+}
+func (p *PointGT) Clone() kyber.Point { return &PointGT{f: p.f} }
+
+// Base sets the element to the pairing of the generators of G1 and G2.
+func (p *PointGT) Base() kyber.Point {
+	suite := NewSuite()
+	p.f = suite.Pair(suite.G1().Point().Base(), suite.G2().Point().Base()).(*PointGT).f
+	return p
+}
+
+func (p *PointGT) Pick(rand cipher.Stream) kyber.Point {
+	return p.Mul(mod.NewInt64(0, order).Pick(rand), new(PointGT).Base())
+}
+
+func (p *PointGT) Add(a, b kyber.Point) kyber.Point {
+	p.f.Mul(&a.(*PointGT).f, &b.(*PointGT).f)
+	return p
+}
+
+func (p *PointGT) Sub(a, b kyber.Point) kyber.Point {
+	var inv bn254.GT
+	inv.Inverse(&b.(*PointGT).f)
+	p.f.Mul(&a.(*PointGT).f, &inv)
+	return p
+}
+
+func (p *PointGT) Neg(a kyber.Point) kyber.Point {
+	p.f.Inverse(&a.(*PointGT).f)
+	return p
+}
+
+func (p *PointGT) Mul(s kyber.Scalar, q kyber.Point) kyber.Point {
+	if q == nil {
+		q = new(PointGT).Base()
+	}
+	p.f.Exp(q.(*PointGT).f, scalarBig(s))
+	return p
+}
+
+func (p *PointGT) MarshalBinary() ([]byte, error) {
+	b := p.f.Bytes()
+	return b[:], nil
+}
+
+func (p *PointGT) UnmarshalBinary(b []byte) error {
+	if err := p.f.SetBytes(b); err != nil {
+		return fmt.Errorf("bn254.GT: %w", err)
+	}
+	return nil
+}
+
+func (p *PointGT) MarshalSize() int                       { return bn254.SizeOfGT }
+func (p *PointGT) MarshalTo(w io.Writer) (int, error)     { return marshalTo(p, w) }
+func (p *PointGT) UnmarshalFrom(r io.Reader) (int, error) { return unmarshalFrom(p, r) }
+func (p *PointGT) String() string                         { return pointString("bn254.GT", p) }
+
+func (p *PointGT) EmbedLen() int { panic("bn254: unsupported operation") }
+func (p *PointGT) Embed(data []byte, r cipher.Stream) kyber.Point {
+	panic("bn254: unsupported operation")
+}
+func (p *PointGT) Data() ([]byte, error) { panic("bn254: unsupported operation") }
+
+// =============================================================================
+
+// scalarBig returns the value of a scalar of the suite.
+func scalarBig(s kyber.Scalar) *big.Int {
+	return &s.(*mod.Int).V
+}
+
+// marshalTo writes the encoding of the point.
+func marshalTo(p kyber.Point, w io.Writer) (int, error) {
+	b, err := p.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	return w.Write(b)
+}
+
+// unmarshalFrom reads the encoding of the point.
+func unmarshalFrom(p kyber.Point, r io.Reader) (int, error) {
+	b := make([]byte, p.MarshalSize())
+	n, err := io.ReadFull(r, b)
+	if err != nil {
+		return n, err
+	}
+	return n, p.UnmarshalBinary(b)
+}
+
+// pointString returns the hex encoding of the point prefixed by its group.
+func pointString(name string, p kyber.Point) string {
+	b, _ := p.MarshalBinary()
+	return name + ": " + hex.EncodeToString(b)
+}
+
+// These assertions check that the suite satisfies the interfaces kyber
+// requires for identity based encryption.
+var (
+	_ pairing.Suite        = (*Suite)(nil)
+	_ kyber.HashablePoint  = (*PointG1)(nil)
+	_ kyber.HashableScalar = (*mod.Int)(nil)
+)
@@ -0,0 +1,156 @@
+package bn254_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/drand/tlock/internal/bn254"
+)
+
+// These vectors were generated with the BN254 implementation of kyber used by
+// drand, with the secret key derived from the SHA-256 of "tlock bn254 vector".
+const (
+	vectorPublicKey = "2e345f4fa8302299f7b30274810bd2cbd25d8114e2a2d752eb2125086809e0fc1ef411c02010bbfc1ed90b7645d6bbc668e494f9d5821c06dc7c7e700aab801413c2e7491023c7a586f0d879e4b035ff2b533743a3a3ebb194a0a5f966df336621ec9bfcd948e5a33ceef167d8d7ec925cc67242b75b6d74e0839eae4e801c9c"
+	vectorSeed      = "tlock bn254 vector"
+)
+
+var vectorRounds = []struct {
+	round     uint64
+	identity  string
+	signature string
+}{
+	{
+		round:     1,
+		identity:  "6c31fc15422ebad28aaf9089c306702f67540b53c7eea8b7d2941044b027100f",
+		signature: "2be386d26897d7aab9af60eae94019b8964793653422b6d0d0db0b79fc3676cc21040391dec14855b7bad1ea5338ccecef0529e6ce7bc26f4d155816a49ef451",
+	},
+	{
+		round:     1000,
+		identity:  "f479a7bd3819aa63bbe476777c509fd59e626fac3d37221509ba4fd41b1459b6",
+		signature: "0f5d4b9b071ad59adb8dcf4e5a5d33c0a0a7e41050a8703d7664e7005ccaa46103592f61657deac92bfed3129102bbab2c1888aaf8dd61ce3063d903bbe22332",
+	},
+}
+
+// evmnetPublicKey is the public key of the evmnet chain of drand.
+const evmnetPublicKey = "07e1d1d335df83fa98462005690372c643340060d205306a9aa8106b6bd0b3820557ec32c2ad488e4d4f6008f89a346f18492092ccc0d594610de2732c8b808f0095685ae3a85ba243747b1b2f426049010f6b73a0cf1d389351d5aaaa1047f6297d3a4f9749b33eb2d904c9d9ebf17224150ddd7abd7567a9bec6c74480ee0b"
+
+func mustDecode(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("decode error %s", err)
+	}
+	return b
+}
+
+func Test_Sign(t *testing.T) {
+	suite := bn254.NewSuite()
+	digest := sha256.Sum256([]byte(vectorSeed))
+	secret := suite.G1().Scalar().SetBytes(digest[:])
+
+	public, err := suite.G2().Point().Mul(secret, nil).MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal error %s", err)
+	}
+	if !bytes.Equal(public, mustDecode(t, vectorPublicKey)) {
+		t.Fatalf("unexpected public key %x", public)
+	}
+
+	for _, v := range vectorRounds {
+		identity := bn254.Identity(v.round)
+		if !bytes.Equal(identity, mustDecode(t, v.identity)) {
+			t.Fatalf("round %d: unexpected identity %x", v.round, identity)
+		}
+
+		msg, err := bn254.Hash(identity, bn254.DST)
+		if err != nil {
+			t.Fatalf("round %d: hash error %s", v.round, err)
+		}
+
+		sig, err := msg.Mul(secret, msg).MarshalBinary()
+		if err != nil {
+			t.Fatalf("round %d: marshal error %s", v.round, err)
+		}
+		if !bytes.Equal(sig, mustDecode(t, v.signature)) {
+			t.Fatalf("round %d: unexpected signature %x", v.round, sig)
+		}
+	}
+}
+
+func Test_Verify(t *testing.T) {
+	suite := bn254.NewSuite()
+
+	public := suite.G2().Point()
+	if err := public.UnmarshalBinary(mustDecode(t, vectorPublicKey)); err != nil {
+		t.Fatalf("unmarshal public key error %s", err)
+	}
+
+	for _, v := range vectorRounds {
+		sig := suite.G1().Point()
+		if err := sig.UnmarshalBinary(mustDecode(t, v.signature)); err != nil {
+			t.Fatalf("round %d: unmarshal signature error %s", v.round, err)
+		}
+
+		for _, w := range vectorRounds {
+			msg, err := bn254.Hash(bn254.Identity(w.round), bn254.DST)
+			if err != nil {
+				t.Fatalf("round %d: hash error %s", w.round, err)
+			}
+
+			valid := suite.ValidatePairing(msg, public, sig, suite.G2().Point().Base())
+			if valid != (v.round == w.round) {
+				t.Fatalf("signature of round %d on round %d: expected valid %t", v.round, w.round, !valid)
+			}
+		}
+	}
+}
+
+func Test_Pair(t *testing.T) {
+	suite := bn254.NewSuite()
+	a := suite.G1().Scalar().Pick(suite.RandomStream())
+	b := suite.G1().Scalar().Pick(suite.RandomStream())
+
+	left := suite.Pair(suite.G1().Point().Mul(a, nil), suite.G2().Point().Mul(b, nil))
+	right := suite.GT().Point().Mul(suite.G1().Scalar().Mul(a, b), nil)
+	if !left.Equal(right) {
+		t.Fatal("pairing isn't bilinear")
+	}
+
+	b2, err := right.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal error %s", err)
+	}
+	decoded := suite.GT().Point()
+	if err := decoded.UnmarshalBinary(b2); err != nil {
+		t.Fatalf("unmarshal error %s", err)
+	}
+	if !decoded.Equal(right) {
+		t.Fatal("target group element doesn't round trip")
+	}
+}
+
+func Test_PublicKey(t *testing.T) {
+	suite := bn254.NewSuite()
+	b := mustDecode(t, evmnetPublicKey)
+
+	public := suite.G2().Point()
+	if err := public.UnmarshalBinary(b); err != nil {
+		t.Fatalf("unmarshal error %s", err)
+	}
+
+	got, err := public.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal error %s", err)
+	}
+	if !bytes.Equal(got, b) {
+		t.Fatalf("unexpected encoding %x", got)
+	}
+
+	b[len(b)-1] ^= 1
+	if err := suite.G2().Point().UnmarshalBinary(b); err == nil {
+		t.Fatal("expected an error for a point off the curve")
+	}
+}
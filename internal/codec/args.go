@@ -0,0 +1,36 @@
+package codec
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Arg formats a key=value stanza argument.
+func Arg(key string, value string) string {
+	return key + "=" + value
+}
+
+// ParseArgs calls the function with the key and value of every key=value
+// stanza argument, in order, stopping at the first error. Arguments that
+// aren't key=value pairs and keys that are repeated are rejected.
+func ParseArgs(args []string, fn func(key string, value string) error) error {
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		pair := strings.SplitN(arg, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return fmt.Errorf("%w: check stanza args: malformed argument %q", ErrMalformed, arg)
+		}
+		key, value := pair[0], pair[1]
+
+		if seen[key] {
+			return fmt.Errorf("%w: check stanza args: duplicate argument %q", ErrMalformed, key)
+		}
+		seen[key] = true
+
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
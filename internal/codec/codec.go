@@ -0,0 +1,24 @@
+// Package codec encodes the header and frames the payload of encrypted data,
+// which follow the age v1 format. It knows the layout of the data but not the
+// meaning of the tlock stanza, which is left to the tlock package, so the
+// format can evolve separately from the API.
+package codec
+
+import (
+	"encoding/base64"
+	"errors"
+)
+
+// ErrMalformed represents an error when the header can't be parsed.
+var ErrMalformed = errors.New("malformed header")
+
+// ErrTooLarge represents an error when the header exceeds the maximum size it
+// is parsed with.
+var ErrTooLarge = errors.New("header too large")
+
+// BodyEncoding is the encoding of stanza bodies and the header MAC.
+var BodyEncoding = base64.RawStdEncoding.Strict()
+
+// ValueEncoding is the encoding of binary values of stanza arguments, which
+// can't contain spaces.
+var ValueEncoding = base64.RawURLEncoding.Strict()
@@ -0,0 +1,246 @@
+package codec_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/drand/tlock/internal/codec"
+)
+
+// testHeader returns a header with stanzas whose bodies end on either side of
+// a line break, authenticated with a fixed file key.
+func testHeader(t *testing.T) (*codec.Header, []byte) {
+	t.Helper()
+
+	hdr := codec.Header{
+		Stanzas: []*age.Stanza{
+			{Type: "tlock", Args: []string{"1000", "abcd", "v=2"}, Body: bytes.Repeat([]byte{1}, 48)},
+			{Type: "other", Body: bytes.Repeat([]byte{2}, 47)},
+			{Type: "empty"},
+		},
+	}
+
+	var raw bytes.Buffer
+	if err := hdr.MarshalWithoutMAC(&raw); err != nil {
+		t.Fatalf("marshal error %s", err)
+	}
+
+	mac, err := codec.HeaderMAC(make([]byte, codec.FileKeySize), raw.Bytes())
+	if err != nil {
+		t.Fatalf("mac error %s", err)
+	}
+	hdr.MAC = mac
+
+	var b bytes.Buffer
+	if err := hdr.Marshal(&b); err != nil {
+		t.Fatalf("marshal error %s", err)
+	}
+
+	return &hdr, b.Bytes()
+}
+
+func Test_HeaderRoundTrip(t *testing.T) {
+	hdr, b := testHeader(t)
+
+	payload := []byte("payload")
+	br := bufio.NewReader(bytes.NewReader(append(append([]byte{}, b...), payload...)))
+
+	parsed, raw, err := codec.ParseHeader(br, 0)
+	if err != nil {
+		t.Fatalf("parse error %s", err)
+	}
+
+	if len(parsed.Stanzas) != len(hdr.Stanzas) {
+		t.Fatalf("expecting %d stanzas; got %d", len(hdr.Stanzas), len(parsed.Stanzas))
+	}
+	for i, s := range parsed.Stanzas {
+		expected := hdr.Stanzas[i]
+		if s.Type != expected.Type || strings.Join(s.Args, " ") != strings.Join(expected.Args, " ") || !bytes.Equal(s.Body, expected.Body) {
+			t.Fatalf("stanza %d: expecting %+v; got %+v", i, expected, s)
+		}
+	}
+
+	if !bytes.Equal(parsed.MAC, hdr.MAC) {
+		t.Fatal("mac doesn't match")
+	}
+	if !bytes.HasSuffix(raw, []byte(codec.FooterPrefix)) || !bytes.HasPrefix(b, raw) {
+		t.Fatal("unexpected authenticated part of the header")
+	}
+	if size := parsed.Size(raw); size != int64(len(b)) {
+		t.Fatalf("expecting size %d; got %d", len(b), size)
+	}
+
+	rest, _ := io.ReadAll(br)
+	if !bytes.Equal(rest, payload) {
+		t.Fatalf("expecting the reader at the payload; got %q", rest)
+	}
+}
+
+func Test_ParseHeaderErrors(t *testing.T) {
+	_, b := testHeader(t)
+	valid := string(b)
+
+	tests := map[string]struct {
+		header   string
+		maxSize  int
+		expected error
+	}{
+		"empty":           {header: "", expected: io.ErrUnexpectedEOF},
+		"truncated":       {header: valid[:len(valid)/2], expected: io.ErrUnexpectedEOF},
+		"wrong intro":     {header: strings.Replace(valid, "v1", "v2", 1), expected: codec.ErrMalformed},
+		"unexpected line": {header: strings.Replace(valid, "-> other", "other", 1), expected: codec.ErrMalformed},
+		"empty argument":  {header: strings.Replace(valid, "1000 abcd", "1000  abcd", 1), expected: codec.ErrMalformed},
+		"long body line":  {header: strings.Replace(valid, "AQEB\n", "AQEBAQ\n", 1), expected: codec.ErrMalformed},
+		"invalid body":    {header: strings.Replace(valid, "-> empty\n", "-> empty\n!\n", 1), expected: codec.ErrMalformed},
+		"non-canonical":   {header: strings.Replace(valid, "-> empty\n", "-> empty\nAR\n", 1), expected: codec.ErrMalformed},
+		"invalid mac":     {header: valid[:strings.LastIndex(valid, " ")] + " AAAA\n", expected: codec.ErrMalformed},
+		"too large":       {header: valid, maxSize: len(valid) - 1, expected: codec.ErrTooLarge},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := codec.ParseHeader(bufio.NewReader(strings.NewReader(tt.header)), tt.maxSize)
+			if !errors.Is(err, tt.expected) {
+				t.Fatalf("expecting %v; got %v", tt.expected, err)
+			}
+		})
+	}
+
+	if _, _, err := codec.ParseHeader(bufio.NewReader(strings.NewReader(valid)), len(valid)); err != nil {
+		t.Fatalf("expecting a header of the maximum size to parse; got %s", err)
+	}
+}
+
+func Test_ParseArgs(t *testing.T) {
+	tests := map[string]struct {
+		args     []string
+		expected string
+		err      error
+	}{
+		"none":       {},
+		"pairs":      {args: []string{"a=1", "b=", "c=x=y"}, expected: "a:1 b: c:x=y"},
+		"no value":   {args: []string{"a"}, err: codec.ErrMalformed},
+		"no key":     {args: []string{"=1"}, err: codec.ErrMalformed},
+		"duplicated": {args: []string{"a=1", "a=1"}, err: codec.ErrMalformed},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var parsed []string
+			err := codec.ParseArgs(tt.args, func(key string, value string) error {
+				parsed = append(parsed, key+":"+value)
+				return nil
+			})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expecting %v; got %v", tt.err, err)
+			}
+			if tt.err == nil && strings.Join(parsed, " ") != tt.expected {
+				t.Fatalf("expecting %s; got %s", tt.expected, parsed)
+			}
+		})
+	}
+
+	stop := errors.New("stop")
+	var calls int
+	err := codec.ParseArgs([]string{"a=1", "b=2"}, func(key string, value string) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Fatalf("expecting the first error to stop the parse; got %v after %d calls", err, calls)
+	}
+
+	if arg := codec.Arg("chunk", "1024"); arg != "chunk=1024" {
+		t.Fatalf("unexpected argument %s", arg)
+	}
+}
+
+func Test_Nonce(t *testing.T) {
+	nonce := make([]byte, 12)
+
+	codec.SetCounter(nonce, 0x0102)
+	codec.SetLast(nonce, true)
+	if expected := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 2, 1}; !bytes.Equal(nonce, expected) {
+		t.Fatalf("expecting %x; got %x", expected, nonce)
+	}
+
+	codec.SetCounter(nonce, 0x01ff)
+	if err := codec.IncrementNonce(nonce); err != nil {
+		t.Fatalf("increment error %s", err)
+	}
+	if expected := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0}; !bytes.Equal(nonce, expected) {
+		t.Fatalf("expecting %x; got %x", expected, nonce)
+	}
+
+	full := bytes.Repeat([]byte{0xff}, 12)
+	if err := codec.IncrementNonce(full); err == nil {
+		t.Fatal("expecting an overflow error")
+	}
+
+	key, err := codec.PayloadKey(make([]byte, codec.FileKeySize), make([]byte, codec.PayloadNonceSize))
+	if err != nil || len(key) != 32 {
+		t.Fatalf("expecting a 32 byte key; got %d bytes, %v", len(key), err)
+	}
+}
+
+// FuzzParseHeader checks that any header that parses marshals back to the
+// same bytes, since the encoding of a header is unique.
+func FuzzParseHeader(f *testing.F) {
+	hdr := codec.Header{
+		Stanzas: []*age.Stanza{{Type: "tlock", Args: []string{"1000", "abcd"}, Body: bytes.Repeat([]byte{1}, 64)}},
+		MAC:     make([]byte, 32),
+	}
+	var b bytes.Buffer
+	if err := hdr.Marshal(&b); err != nil {
+		f.Fatalf("marshal error %s", err)
+	}
+	f.Add(b.Bytes())
+	f.Add([]byte(codec.Intro))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parsed, raw, err := codec.ParseHeader(bufio.NewReader(bytes.NewReader(data)), 0)
+		if err != nil {
+			return
+		}
+
+		var out bytes.Buffer
+		if err := parsed.Marshal(&out); err != nil {
+			t.Fatalf("marshal error %s", err)
+		}
+		if !bytes.HasPrefix(data, out.Bytes()) {
+			t.Fatalf("header doesn't marshal back to its encoding:\n%q\n%q", data, out.Bytes())
+		}
+		if size := parsed.Size(raw); size != int64(out.Len()) {
+			t.Fatalf("expecting size %d; got %d", out.Len(), size)
+		}
+	})
+}
+
+// FuzzParseArgs checks that the arguments that parse format back to the same
+// arguments.
+func FuzzParseArgs(f *testing.F) {
+	f.Add("v=2 chunk=1024 x-a=b")
+	f.Add("a==")
+
+	f.Fuzz(func(t *testing.T, line string) {
+		args := strings.Split(line, " ")
+
+		var formatted []string
+		err := codec.ParseArgs(args, func(key string, value string) error {
+			formatted = append(formatted, codec.Arg(key, value))
+			return nil
+		})
+		if err != nil {
+			return
+		}
+
+		if strings.Join(formatted, " ") != line {
+			t.Fatalf("expecting %q; got %q", line, strings.Join(formatted, " "))
+		}
+	})
+}
@@ -0,0 +1,205 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/hkdf"
+)
+
+// These constants define the layout of the header, which follows the age v1
+// format so that data encrypted with the default options can also be
+// decrypted with age.
+const (
+	Intro          = "age-encryption.org/v1\n"
+	StanzaPrefix   = "->"
+	FooterPrefix   = "---"
+	ColumnsPerLine = 64
+	FileKeySize    = 16
+)
+
+// Header represents the header of encrypted data.
+type Header struct {
+	Stanzas []*age.Stanza
+	MAC     []byte
+}
+
+// MarshalWithoutMAC writes the part of the header that is authenticated by
+// the MAC, which ends with the footer prefix.
+func (h *Header) MarshalWithoutMAC(w io.Writer) error {
+	if _, err := io.WriteString(w, Intro); err != nil {
+		return err
+	}
+
+	for _, s := range h.Stanzas {
+		if err := writeStanza(w, s); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w, FooterPrefix)
+	return err
+}
+
+// Marshal writes the complete header.
+func (h *Header) Marshal(w io.Writer) error {
+	if err := h.MarshalWithoutMAC(w); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, " %s\n", BodyEncoding.EncodeToString(h.MAC))
+	return err
+}
+
+// Size returns the number of bytes of the complete header, given the part
+// that is authenticated by the MAC.
+func (h *Header) Size(headerWithoutMAC []byte) int64 {
+	return int64(len(headerWithoutMAC) + len(" \n") + BodyEncoding.EncodedLen(len(h.MAC)))
+}
+
+// writeStanza writes a stanza with its body wrapped at 64 columns. The last
+// line of the body is always shorter than 64 columns, and empty if needed.
+func writeStanza(w io.Writer, s *age.Stanza) error {
+	line := StanzaPrefix + " " + strings.Join(append([]string{s.Type}, s.Args...), " ") + "\n"
+	if _, err := io.WriteString(w, line); err != nil {
+		return err
+	}
+
+	body := BodyEncoding.EncodeToString(s.Body)
+	for len(body) >= ColumnsPerLine {
+		if _, err := io.WriteString(w, body[:ColumnsPerLine]+"\n"); err != nil {
+			return err
+		}
+		body = body[ColumnsPerLine:]
+	}
+
+	_, err := io.WriteString(w, body+"\n")
+	return err
+}
+
+// ParseHeader reads the header from the source, leaving it positioned at the
+// start of the payload. It also returns the part of the header that is
+// authenticated by the MAC. Headers larger than the maximum size in bytes are
+// rejected with ErrTooLarge, unless it's zero.
+func ParseHeader(r *bufio.Reader, maxSize int) (*Header, []byte, error) {
+	var raw bytes.Buffer
+
+	// next reads the next line, counting it against the maximum size.
+	var size int
+	next := func() (string, error) {
+		line, err := readLine(r)
+		size += len(line)
+		if maxSize > 0 && size > maxSize {
+			return "", ErrTooLarge
+		}
+		return line, err
+	}
+
+	line, err := next()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read intro: %w", err)
+	}
+	if line != Intro {
+		return nil, nil, fmt.Errorf("%w: unexpected intro %q", ErrMalformed, strings.TrimSpace(line))
+	}
+	raw.WriteString(line)
+
+	var h Header
+	for {
+		line, err := next()
+		if err != nil {
+			return nil, nil, fmt.Errorf("read header: %w", err)
+		}
+
+		if strings.HasPrefix(line, FooterPrefix) {
+			mac := strings.TrimSuffix(strings.TrimPrefix(line, FooterPrefix+" "), "\n")
+			if h.MAC, err = BodyEncoding.DecodeString(mac); err != nil || len(h.MAC) != sha256.Size {
+				return nil, nil, fmt.Errorf("%w: invalid mac", ErrMalformed)
+			}
+			raw.WriteString(FooterPrefix)
+			break
+		}
+
+		if !strings.HasPrefix(line, StanzaPrefix+" ") {
+			return nil, nil, fmt.Errorf("%w: unexpected line %q", ErrMalformed, strings.TrimSpace(line))
+		}
+		raw.WriteString(line)
+
+		args := strings.Split(strings.TrimSuffix(line, "\n")[len(StanzaPrefix)+1:], " ")
+		for _, arg := range args {
+			if arg == "" {
+				return nil, nil, fmt.Errorf("%w: empty stanza argument", ErrMalformed)
+			}
+		}
+
+		s := age.Stanza{
+			Type: args[0],
+			Args: args[1:],
+		}
+
+		for {
+			line, err := next()
+			if err != nil {
+				return nil, nil, fmt.Errorf("read stanza body: %w", err)
+			}
+			raw.WriteString(line)
+
+			encoded := strings.TrimSuffix(line, "\n")
+			if len(encoded) > ColumnsPerLine {
+				return nil, nil, fmt.Errorf("%w: stanza body line too long", ErrMalformed)
+			}
+
+			chunk, err := BodyEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w: invalid stanza body", ErrMalformed)
+			}
+			s.Body = append(s.Body, chunk...)
+
+			if len(encoded) < ColumnsPerLine {
+				break
+			}
+		}
+
+		h.Stanzas = append(h.Stanzas, &s)
+	}
+
+	return &h, raw.Bytes(), nil
+}
+
+// readLine reads a single line terminated by a newline. Lines are limited to
+// the size of the reader's buffer.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	switch {
+	case errors.Is(err, bufio.ErrBufferFull):
+		return "", fmt.Errorf("%w: line too long", ErrMalformed)
+	case errors.Is(err, io.EOF):
+		return "", io.ErrUnexpectedEOF
+	case err != nil:
+		return "", err
+	}
+
+	return string(line), nil
+}
+
+// HeaderMAC computes the MAC that authenticates the header with the file key.
+func HeaderMAC(fileKey []byte, headerWithoutMAC []byte) ([]byte, error) {
+	key := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, fileKey, nil, []byte("header")), key); err != nil {
+		return nil, fmt.Errorf("derive header key: %w", err)
+	}
+
+	defer Wipe(key)
+
+	h := hmac.New(sha256.New, key)
+	h.Write(headerWithoutMAC)
+
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,58 @@
+package codec
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// PayloadNonceSize is the size of the random nonce that precedes the payload
+// and is used to derive the payload key.
+const PayloadNonceSize = 16
+
+// PayloadKey derives the payload key from the file key and the payload nonce.
+func PayloadKey(fileKey []byte, nonce []byte) ([]byte, error) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, fileKey, nonce, []byte("payload")), key); err != nil {
+		return nil, fmt.Errorf("derive payload key: %w", err)
+	}
+
+	return key, nil
+}
+
+// The nonce of a payload chunk holds the chunk number as a big endian counter
+// in all but its last byte, which flags the last chunk.
+
+// IncrementNonce increments the counter of the nonce.
+func IncrementNonce(nonce []byte) error {
+	for i := len(nonce) - 2; i >= 0; i-- {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return nil
+		}
+	}
+
+	return errors.New("payload nonce overflow")
+}
+
+// SetCounter sets the counter of the nonce to the chunk number, and clears
+// the last chunk flag.
+func SetCounter(nonce []byte, chunk uint64) {
+	nonce[len(nonce)-1] = 0
+	for i := len(nonce) - 2; i >= 0; i-- {
+		nonce[i] = byte(chunk)
+		chunk >>= 8
+	}
+}
+
+// SetLast sets or clears the last chunk flag of the nonce.
+func SetLast(nonce []byte, last bool) {
+	nonce[len(nonce)-1] = 0
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+}
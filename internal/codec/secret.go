@@ -0,0 +1,18 @@
+package codec
+
+import "crypto/subtle"
+
+// Equal reports whether two MACs or digests are equal. It takes the same
+// time wherever they differ, so comparing a forged value with the expected
+// one doesn't reveal how much of it is right.
+func Equal(a []byte, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// Wipe overwrites secret material that is no longer used, so it doesn't
+// linger in memory until it is garbage collected.
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
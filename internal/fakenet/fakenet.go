@@ -0,0 +1,308 @@
+// Package fakenet implements a drand network that runs in process. It signs
+// beacons with a locally generated key so encryption and decryption can be
+// tested without access to a real network.
+package fakenet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drand/drand/chain"
+	"github.com/drand/drand/client"
+	"github.com/drand/drand/common/scheme"
+	"github.com/drand/kyber"
+	bls12381 "github.com/drand/kyber-bls12381"
+	"github.com/drand/kyber/sign/bls"
+	"github.com/drand/kyber/util/random"
+	"github.com/drand/tlock/internal/bn254"
+	"github.com/drand/tlock/internal/g1"
+	"github.com/drand/tlock/networks"
+	json "github.com/nikkolasg/hexjson"
+)
+
+// ErrNotAvailable represents an error when the signature of a round that
+// wasn't reached yet is requested.
+var ErrNotAvailable = errors.New("round not available yet")
+
+// These assertions check that Chain keeps satisfying the contract of the
+// networks package.
+var (
+	_ networks.Network             = (*Chain)(nil)
+	_ networks.AvailabilityNetwork = (*Chain)(nil)
+	_ networks.RoundTimer          = (*Chain)(nil)
+	_ networks.RoundCalculator     = (*Chain)(nil)
+	_ networks.SchemeNetwork       = (*Chain)(nil)
+)
+
+// =============================================================================
+
+// Chain represents an unchained randomness chain whose beacons are signed on
+// demand. It implements the Network interface of the networks package.
+type Chain struct {
+	info     *chain.Info
+	hash     string
+	infoJSON []byte
+	infoErr  error
+	secret   kyber.Scalar
+	unlocked bool
+	now      func() time.Time
+}
+
+// NewChain constructs a chain with a new key pair and the specified period.
+// The genesis is placed one period in the past so the first round is
+// available right away.
+func NewChain(period time.Duration) *Chain {
+	suite := bls12381.NewBLS12381Suite()
+	secret, public := bls.NewSchemeOnG2(suite).NewKeyPair(random.New())
+
+	return newChain(period, scheme.UnchainedSchemeID, secret, public, time.Now().Add(-period))
+}
+
+// NewChainOnG1 works like NewChain but constructs a chain that signs its
+// rounds on G1 with a public key on G2, like quicknet. Its info can't be
+// served over HTTP, since drand only reads public keys on G1.
+func NewChainOnG1(period time.Duration) *Chain {
+	suite := bls12381.NewBLS12381Suite()
+	secret, public := bls.NewSchemeOnG1(suite).NewKeyPair(random.New())
+
+	return newChain(period, SchemeOnG1, secret, public, time.Now().Add(-period))
+}
+
+// NewChainOnLegacyG1 works like NewChainOnG1 but constructs a chain that
+// hashes its rounds with the domain of signatures on G2, like the first
+// chains signing on G1.
+func NewChainOnLegacyG1(period time.Duration) *Chain {
+	suite := bls12381.NewBLS12381Suite()
+	secret, public := bls.NewSchemeOnG1(suite).NewKeyPair(random.New())
+
+	return newChain(period, SchemeOnLegacyG1, secret, public, time.Now().Add(-period))
+}
+
+// NewChainOnBN254 works like NewChainOnG1 but constructs a chain on the
+// BN254 curve, like evmnet.
+func NewChainOnBN254(period time.Duration) *Chain {
+	suite := bn254.NewSuite()
+	secret := suite.G1().Scalar().Pick(random.New())
+	public := suite.G2().Point().Mul(secret, nil)
+
+	return newChain(period, SchemeOnBN254, secret, public, time.Now().Add(-period))
+}
+
+// NewChainFromSeed works like NewChain but derives the key pair from the seed
+// and places the genesis at a fixed time, so the chain, its hash, and the
+// data encrypted to it are the same every time. Such data can be kept as test
+// fixtures.
+func NewChainFromSeed(period time.Duration, seed string) *Chain {
+	suite := bls12381.NewBLS12381Suite()
+	digest := sha256.Sum256([]byte(seed))
+	secret := suite.G1().Scalar().SetBytes(digest[:])
+	public := suite.G1().Point().Mul(secret, nil)
+
+	return newChain(period, scheme.UnchainedSchemeID, secret, public, fixedGenesis)
+}
+
+// fixedGenesis is the genesis of the chains constructed by NewChainFromSeed.
+var fixedGenesis = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// These constants define the schemes of the chains constructed by
+// NewChainOnG1, NewChainOnLegacyG1, and NewChainOnBN254.
+const (
+	SchemeOnG1       = "bls-unchained-g1-rfc9380"
+	SchemeOnLegacyG1 = "bls-unchained-on-g1"
+	SchemeOnBN254    = "bls-bn254-unchained-on-g1"
+)
+
+// newChain constructs a chain with the key pair. Its hash and the JSON of its
+// info are computed once, since marshaling the public key isn't safe for
+// concurrent use.
+func newChain(period time.Duration, schemeID string, secret kyber.Scalar, public kyber.Point, genesis time.Time) *Chain {
+	info := chain.Info{
+		PublicKey:   public,
+		Period:      period,
+		Scheme:      scheme.Scheme{ID: schemeID, DecouplePrevSig: true},
+		GenesisTime: genesis.Unix(),
+		GenesisSeed: []byte("fakenet"),
+	}
+
+	c := Chain{
+		info:   &info,
+		hash:   info.HashString(),
+		secret: secret,
+		now:    time.Now,
+	}
+
+	var b bytes.Buffer
+	if c.infoErr = info.ToJSON(&b, nil); c.infoErr == nil {
+		c.infoJSON = b.Bytes()
+	}
+
+	return &c
+}
+
+// Unlock makes the chain sign any round right away, including rounds that
+// are still in the future. This allows decryption to be tested without
+// waiting for rounds to be reached. Unlock must be called before the chain is
+// used concurrently.
+func (c *Chain) Unlock() {
+	c.unlocked = true
+}
+
+// Info returns the public information of the chain.
+func (c *Chain) Info() *chain.Info {
+	return c.info
+}
+
+// ChainHash returns the chain hash for this network.
+func (c *Chain) ChainHash() string {
+	return c.hash
+}
+
+// SchemeID returns the drand scheme of the chain.
+func (c *Chain) SchemeID() string {
+	return c.info.Scheme.ID
+}
+
+// PublicKey returns the kyber point needed for encryption and decryption.
+func (c *Chain) PublicKey() kyber.Point {
+	return c.info.PublicKey
+}
+
+// Signature returns the signature of the specified round, or
+// ErrNotAvailable if the round hasn't been reached yet.
+func (c *Chain) Signature(roundNumber uint64) ([]byte, error) {
+	if !c.unlocked && roundNumber > c.RoundNumber(c.now()) {
+		return nil, ErrNotAvailable
+	}
+
+	msg := chain.NewVerifier(c.info.Scheme).DigestMessage(roundNumber, nil)
+
+	switch c.info.Scheme.ID {
+	case SchemeOnG1, SchemeOnLegacyG1:
+		dst := g1.DST
+		if c.info.Scheme.ID == SchemeOnLegacyG1 {
+			dst = g1.LegacyDST
+		}
+
+		point, err := g1.HashWithDST(msg, dst)
+		if err != nil {
+			return nil, err
+		}
+		return point.Mul(c.secret, point).MarshalBinary()
+
+	case SchemeOnBN254:
+		point, err := bn254.Hash(bn254.Identity(roundNumber), bn254.DST)
+		if err != nil {
+			return nil, err
+		}
+		return point.Mul(c.secret, point).MarshalBinary()
+	}
+
+	suite := bls12381.NewBLS12381Suite()
+	return bls.NewSchemeOnG2(suite).Sign(c.secret, msg)
+}
+
+// IsAvailable reports whether the signature of the round is available, which
+// is always the case once the chain is unlocked, along with the time at which
+// the round is reached.
+func (c *Chain) IsAvailable(roundNumber uint64) (bool, time.Time, error) {
+	return c.unlocked || roundNumber <= c.RoundNumber(c.now()), c.RoundTime(roundNumber), nil
+}
+
+// RoundNumber returns the latest round that is available at the specified
+// time.
+func (c *Chain) RoundNumber(t time.Time) uint64 {
+	return chain.CurrentRound(t.Unix(), c.info.Period, c.info.GenesisTime)
+}
+
+// RoundTime returns the time at which the specified round becomes available.
+func (c *Chain) RoundTime(roundNumber uint64) time.Time {
+	return time.Unix(chain.TimeOfRound(c.info.Period, c.info.GenesisTime, roundNumber), 0)
+}
+
+// =============================================================================
+
+// Handler returns an http handler that serves the chains using the drand
+// HTTP API. The first chain is also served as the default chain.
+func Handler(chains ...*Chain) http.Handler {
+	byHash := make(map[string]*Chain)
+	for _, c := range chains {
+		byHash[c.ChainHash()] = c
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+		if len(parts) == 1 && parts[0] == "chains" {
+			hashes := make([]string, 0, len(chains))
+			for _, c := range chains {
+				hashes = append(hashes, c.ChainHash())
+			}
+			writeJSON(w, hashes)
+			return
+		}
+
+		c := chains[0]
+		if len(parts) > 0 {
+			if hc, exists := byHash[parts[0]]; exists {
+				c = hc
+				parts = parts[1:]
+			}
+		}
+
+		switch {
+		case len(parts) == 1 && parts[0] == "info":
+			if c.infoErr != nil {
+				http.Error(w, c.infoErr.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(c.infoJSON)
+
+		case len(parts) == 2 && parts[0] == "public":
+			serveBeacon(w, c, parts[1])
+
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// serveBeacon writes the beacon of the round, which is either a number or
+// "latest".
+func serveBeacon(w http.ResponseWriter, c *Chain, round string) {
+	roundNumber := c.RoundNumber(c.now())
+	if round != "latest" {
+		var err error
+		if roundNumber, err = strconv.ParseUint(round, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("invalid round %q", round), http.StatusBadRequest)
+			return
+		}
+	}
+
+	sig, err := c.Signature(roundNumber)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	randomness := sha256.Sum256(sig)
+	writeJSON(w, client.RandomData{
+		Rnd:    roundNumber,
+		Random: randomness[:],
+		Sig:    sig,
+	})
+}
+
+// writeJSON writes the value as a JSON response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,41 @@
+// Package g1 hashes messages to the G1 group of BLS12-381 as specified by RFC
+// 9380, which is how chains signing on G1, like quicknet, hash the rounds
+// they sign. The G1 points of kyber-bls12381 hash with the domain of G2
+// signatures instead, like the first chains signing on G1 did.
+package g1
+
+import (
+	bls "github.com/drand/kyber-bls12381"
+	bls12381 "github.com/kilic/bls12-381"
+)
+
+// These variables define the domain separation tags of BLS signatures on G1,
+// and of the signatures on G2 that the first chains signing on G1 used by
+// mistake.
+var (
+	DST       = []byte("BLS_SIG_BLS12381G1_XMD:SHA-256_SSWU_RO_NUL_")
+	LegacyDST = []byte("BLS_SIG_BLS12381G2_XMD:SHA-256_SSWU_RO_NUL_")
+)
+
+// Hash hashes the message to a point of G1 with the domain of G1 signatures.
+func Hash(msg []byte) (*bls.KyberG1, error) {
+	return HashWithDST(msg, DST)
+}
+
+// HashWithDST hashes the message to a point of G1 with the domain separation
+// tag.
+func HashWithDST(msg []byte, dst []byte) (*bls.KyberG1, error) {
+	g := bls12381.NewG1()
+
+	p, err := g.HashToCurve(msg, dst)
+	if err != nil {
+		return nil, err
+	}
+
+	point := bls.NullKyberG1()
+	if err := point.UnmarshalBinary(g.ToCompressed(p)); err != nil {
+		return nil, err
+	}
+
+	return point, nil
+}
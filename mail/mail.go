@@ -0,0 +1,687 @@
+// Package mail implements a gateway scheduling emails for the future: it
+// accepts an armored time lock encrypted message and the address of its
+// recipient, and emails the message once its round is reached, so the
+// content is provably unreadable until then, by the gateway included. The
+// recipient gets the plain text, or the encrypted message with the beacon
+// decrypting it, for a gateway that shouldn't see the content at all.
+//
+// Messages are scheduled with Schedule or with the endpoint of the gateway:
+//
+//	POST /messages  schedules a message and returns its description
+//
+// whose JSON body holds the recipient as "to", an optional "subject" and the
+// armored "ciphertext". A gateway with tokens requires one of them as bearer
+// token. Messages are kept in a relay storage until they are decrypted, with
+// the queue package, and emails in an outbox until they are sent, so both
+// survive restarts. Emails that can't be sent are retried with an
+// exponential backoff, then dropped. The outbox holds the decrypted content
+// of the emails, which is deleted once they are sent or dropped. Unless the
+// gateway emails the ciphertext, the storage should be wrapped with
+// relay.Sealed so the content isn't stored unencrypted. Adapting a mail
+// service only requires implementing the Sender interface, which SMTP
+// implements.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	netmail "net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drand/drand/chain"
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/networks/beacon"
+	"github.com/drand/tlock/queue"
+	"github.com/drand/tlock/relay"
+)
+
+// These constants define the default settings of a gateway.
+const (
+	DefaultMaxSize     = 1 << 20
+	DefaultMaxAttempts = 8
+	DefaultRetryDelay  = time.Minute
+)
+
+// These constants define the prefixes of the keys of the stored messages and
+// of the emails waiting to be sent.
+const (
+	scheduledDir = "scheduled/"
+	outboxDir    = "outbox/"
+)
+
+// ErrInvalidMessage represents an error when a message can't be scheduled,
+// such as when its recipient isn't a valid address or it isn't locked to the
+// chain of the gateway.
+var ErrInvalidMessage = errors.New("invalid message")
+
+// Email is an email sent by the gateway.
+type Email struct {
+	To      string
+	Subject string
+	Body    []byte
+}
+
+// Sender sends the emails, such as through an SMTP server.
+type Sender interface {
+	Send(ctx context.Context, e Email) error
+}
+
+// Network represents the network the messages are locked to. Its chain
+// information describes the beacons sent with encrypted messages.
+type Network interface {
+	queue.Network
+	Info() *chain.Info
+}
+
+// Scheduled describes a message scheduled with the gateway.
+type Scheduled struct {
+	ID          string    `json:"id"`
+	To          string    `json:"to"`
+	Subject     string    `json:"subject,omitempty"`
+	RoundNumber uint64    `json:"round"`
+	UnlockTime  time.Time `json:"unlock_time"`
+}
+
+// =============================================================================
+
+// Option configures a gateway constructed with New.
+type Option func(g *Gateway)
+
+// WithTlockOptions sets the options of the tlock decrypting the messages,
+// such as a beacon source or a signature cache.
+func WithTlockOptions(opts ...tlock.Option) Option {
+	return func(g *Gateway) {
+		g.opts = opts
+	}
+}
+
+// WithCiphertext emails the encrypted messages with the beacon of their
+// round, which tle decrypts them with, instead of their plain text.
+func WithCiphertext() Option {
+	return func(g *Gateway) {
+		g.ciphertext = true
+	}
+}
+
+// WithTokens restricts scheduling messages through the endpoint of the
+// gateway to the holders of the tokens.
+func WithTokens(tokens ...string) Option {
+	return func(g *Gateway) {
+		g.tokens = make(map[string]bool)
+		for _, token := range tokens {
+			g.tokens[digest(token)] = true
+		}
+	}
+}
+
+// WithMaxSize sets the size in bytes of the largest request the endpoint of
+// the gateway accepts. The default is DefaultMaxSize.
+func WithMaxSize(size int64) Option {
+	return func(g *Gateway) {
+		g.maxSize = size
+	}
+}
+
+// WithRetries sets how many times sending an email is attempted before it's
+// dropped, and the delay before the first retry, which doubles with every
+// attempt. The defaults are DefaultMaxAttempts and DefaultRetryDelay.
+func WithRetries(attempts int, delay time.Duration) Option {
+	return func(g *Gateway) {
+		g.maxAttempts = attempts
+		g.retryDelay = delay
+	}
+}
+
+// WithClock sets the clock deciding when the emails are due. The default is
+// the system clock.
+func WithClock(clock tlock.Clock) Option {
+	return func(g *Gateway) {
+		g.clock = clock
+	}
+}
+
+// WithErrorHandler sets the function called with the messages that can't be
+// decrypted, or whose email can't be sent, and the reason, before they are
+// dropped. Failures are dropped silently by default.
+func WithErrorHandler(fn func(s Scheduled, err error)) Option {
+	return func(g *Gateway) {
+		g.onError = fn
+	}
+}
+
+// Gateway schedules encrypted messages and emails them once their round is
+// reached.
+type Gateway struct {
+	network     Network
+	chainHash   string
+	storage     relay.Storage
+	sender      Sender
+	opts        []tlock.Option
+	ciphertext  bool
+	tokens      map[string]bool
+	maxSize     int64
+	maxAttempts int
+	retryDelay  time.Duration
+	clock       tlock.Clock
+	onError     func(s Scheduled, err error)
+
+	mu        sync.Mutex
+	queued    []string
+	held      map[string]bool
+	scheduled chan struct{}
+	outbox    chan struct{}
+}
+
+// New constructs a gateway for the messages locked to the network, which
+// keeps the messages and emails in the storage and sends the emails with
+// the sender. Settings that aren't positive are replaced by their default.
+func New(network Network, storage relay.Storage, sender Sender, opts ...Option) *Gateway {
+	g := Gateway{
+		network:     network,
+		chainHash:   network.ChainHash(),
+		storage:     storage,
+		sender:      sender,
+		maxSize:     DefaultMaxSize,
+		maxAttempts: DefaultMaxAttempts,
+		retryDelay:  DefaultRetryDelay,
+		clock:       systemClock{},
+		held:        make(map[string]bool),
+		scheduled:   make(chan struct{}, 1),
+		outbox:      make(chan struct{}, 1),
+	}
+
+	for _, opt := range opts {
+		opt(&g)
+	}
+
+	if g.maxSize <= 0 {
+		g.maxSize = DefaultMaxSize
+	}
+	if g.maxAttempts <= 0 {
+		g.maxAttempts = DefaultMaxAttempts
+	}
+	if g.retryDelay <= 0 {
+		g.retryDelay = DefaultRetryDelay
+	}
+
+	return &g
+}
+
+// record is the JSON encoding of a stored message.
+type record struct {
+	Scheduled
+	Ciphertext string `json:"ciphertext"`
+}
+
+// delivery is the JSON encoding of an email waiting to be sent.
+type delivery struct {
+	Scheduled
+	Body        []byte    `json:"body"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+}
+
+// Schedule stores the armored message, locked to the chain of the network,
+// to be emailed to the recipient once its round is reached. An empty subject
+// is replaced by one stating the round. It fails with ErrInvalidMessage if
+// the message or the recipient isn't valid.
+func (g *Gateway) Schedule(ctx context.Context, to string, subject string, ciphertext string) (Scheduled, error) {
+	addr, err := netmail.ParseAddress(to)
+	if err != nil {
+		return Scheduled{}, fmt.Errorf("%w: recipient %q: %v", ErrInvalidMessage, to, err)
+	}
+
+	if strings.ContainsAny(subject, "\r\n") {
+		return Scheduled{}, fmt.Errorf("%w: subject holds a line break", ErrInvalidMessage)
+	}
+
+	ciphertext = strings.TrimSpace(ciphertext)
+	header, err := tlock.ReadHeader(strings.NewReader(ciphertext))
+	if err != nil {
+		return Scheduled{}, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+
+	if header.ChainHash != g.chainHash {
+		return Scheduled{}, fmt.Errorf("%w: locked to chain %s instead of %s", ErrInvalidMessage, header.ChainHash, g.chainHash)
+	}
+
+	id, err := newID()
+	if err != nil {
+		return Scheduled{}, err
+	}
+
+	if subject == "" {
+		subject = fmt.Sprintf("Message sealed until round %d", header.RoundNumber)
+	}
+
+	rec := record{
+		Scheduled: Scheduled{
+			ID:          id,
+			To:          addr.Address,
+			Subject:     subject,
+			RoundNumber: header.RoundNumber,
+			UnlockTime:  g.network.RoundTime(header.RoundNumber).UTC(),
+		},
+		Ciphertext: ciphertext,
+	}
+
+	if err := g.putJSON(ctx, scheduledDir+id, rec); err != nil {
+		return Scheduled{}, fmt.Errorf("store message: %w", err)
+	}
+
+	g.mu.Lock()
+	g.queued = append(g.queued, scheduledDir+id)
+	g.mu.Unlock()
+	notify(g.scheduled)
+
+	return rec.Scheduled, nil
+}
+
+// Run decrypts the messages as their rounds are reached and sends their
+// emails until the context is canceled or the storage fails. The messages
+// and emails stored by a previous run are handled first.
+func (g *Gateway) Run(ctx context.Context) error {
+	keys, err := g.storage.List(ctx, scheduledDir)
+	if err != nil {
+		return fmt.Errorf("list messages: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	delivered := make(chan error, 1)
+	go func() {
+		delivered <- g.deliver(ctx)
+	}()
+
+	c := queue.NewConsumer(g.network, &source{gateway: g, keys: keys}, sink{gateway: g},
+		queue.WithTlockOptions(g.opts...),
+		queue.WithErrorHandler(func(m queue.Message, err error) {
+			var rec record
+			if g.getJSON(ctx, m.Key, &rec) == nil {
+				g.report(rec.Scheduled, fmt.Errorf("decrypt: %w", err))
+			}
+		}),
+	)
+	err = c.Run(ctx)
+
+	cancel()
+	if derr := <-delivered; derr != nil && !errors.Is(derr, context.Canceled) {
+		return derr
+	}
+
+	return err
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || strings.Trim(r.URL.Path, "/") != "messages" {
+		writeError(w, http.StatusNotFound, errors.New("unknown endpoint"))
+		return
+	}
+
+	if g.tokens != nil {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || token == r.Header.Get("Authorization") || !g.tokens[digest(token)] {
+			writeError(w, http.StatusUnauthorized, errors.New("a valid token is required"))
+			return
+		}
+	}
+
+	var req struct {
+		To         string `json:"to"`
+		Subject    string `json:"subject"`
+		Ciphertext string `json:"ciphertext"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, g.maxSize)).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		writeError(w, http.StatusBadRequest, fmt.Errorf("read message: %w", err))
+		return
+	}
+
+	s, err := g.Schedule(r.Context(), req.To, req.Subject, req.Ciphertext)
+	switch {
+	case errors.Is(err, ErrInvalidMessage):
+		writeError(w, http.StatusBadRequest, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		writeJSON(w, http.StatusCreated, s)
+	}
+}
+
+// =============================================================================
+
+// deliver sends the emails of the outbox as they are added and retries the
+// ones that failed, until the context is canceled or the storage fails.
+func (g *Gateway) deliver(ctx context.Context) error {
+	for {
+		wait, err := g.deliverDue(ctx)
+		if err != nil {
+			return err
+		}
+
+		var timer *time.Timer
+		var wake <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			wake = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-g.outbox:
+		case <-wake:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// deliverDue sends the emails whose attempt is due. It returns how long to
+// wait for the next attempt, or zero if there is none.
+func (g *Gateway) deliverDue(ctx context.Context) (time.Duration, error) {
+	keys, err := g.storage.List(ctx, outboxDir)
+	if err != nil {
+		return 0, fmt.Errorf("list outbox: %w", err)
+	}
+
+	var wait time.Duration
+	for _, key := range keys {
+		var d delivery
+		if err := g.getJSON(ctx, key, &d); err != nil {
+			return 0, err
+		}
+
+		if until := d.NextAttempt.Sub(g.clock.Now()); until > 0 {
+			if wait == 0 || until < wait {
+				wait = until
+			}
+			continue
+		}
+
+		err := g.sender.Send(ctx, Email{To: d.To, Subject: d.Subject, Body: d.Body})
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+
+		d.Attempts++
+		switch {
+		case err == nil:
+			// The email was sent.
+
+		case d.Attempts >= g.maxAttempts:
+			g.report(d.Scheduled, fmt.Errorf("send after %d attempts: %w", d.Attempts, err))
+
+		default:
+			delay := g.retryDelay << (d.Attempts - 1)
+			d.NextAttempt = g.clock.Now().Add(delay)
+			if err := g.putJSON(ctx, key, d); err != nil {
+				return 0, err
+			}
+			if wait == 0 || delay < wait {
+				wait = delay
+			}
+			continue
+		}
+
+		if err := g.storage.Delete(ctx, key); err != nil {
+			return 0, err
+		}
+	}
+
+	return wait, nil
+}
+
+// body returns the body of the email of the decrypted message: its plain
+// text, or the message with the beacon decrypting it.
+func (g *Gateway) body(rec record, plain []byte) ([]byte, error) {
+	if !g.ciphertext {
+		return plain, nil
+	}
+
+	signature, err := g.network.Signature(rec.RoundNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := beacon.New(g.network.Info(), rec.RoundNumber, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "This message was sealed until round %d of chain %s, reached at %s.\n", rec.RoundNumber, g.chainHash, rec.UnlockTime.Format(time.RFC3339))
+	fmt.Fprintf(&body, "Save the beacon below to beacon.json and the message to message.tle, then decrypt it with:\n\n")
+	fmt.Fprintf(&body, "    tle -d --beacon-file beacon.json -o message message.tle\n\n")
+	if err := b.Write(&body); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(&body, "\n%s\n", rec.Ciphertext)
+
+	return body.Bytes(), nil
+}
+
+// report calls the error handler, if any.
+func (g *Gateway) report(s Scheduled, err error) {
+	if g.onError != nil {
+		g.onError(s, err)
+	}
+}
+
+// hold records that the message is handed to the consumer, reporting false
+// if it already was, as when it's scheduled while the stored messages are
+// listed.
+func (g *Gateway) hold(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.held[key] {
+		return false
+	}
+	g.held[key] = true
+	return true
+}
+
+// release forgets the message once handled.
+func (g *Gateway) release(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.held, key)
+}
+
+// getJSON reads the JSON object stored under the key into v.
+func (g *Gateway) getJSON(ctx context.Context, key string, v interface{}) error {
+	obj, err := g.storage.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	if err := json.NewDecoder(obj).Decode(v); err != nil {
+		return fmt.Errorf("parse %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// putJSON stores v as a JSON object under the key.
+func (g *Gateway) putJSON(ctx context.Context, key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return g.storage.Put(ctx, key, bytes.NewReader(b))
+}
+
+// =============================================================================
+
+// source implements the Source and Acker interfaces of the queue package,
+// with the stored messages followed by the messages scheduled while the
+// gateway runs.
+type source struct {
+	gateway *Gateway
+	keys    []string
+}
+
+// Receive returns the next message to decrypt, waiting for one to be
+// scheduled.
+func (s *source) Receive(ctx context.Context) (queue.Message, error) {
+	g := s.gateway
+
+	for {
+		key, ok := s.next()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return queue.Message{}, ctx.Err()
+			case <-g.scheduled:
+			}
+			continue
+		}
+
+		if !g.hold(key) {
+			continue
+		}
+
+		// A message scheduled while the stored messages are listed may have
+		// been handled already.
+		var rec record
+		err := g.getJSON(ctx, key, &rec)
+		if errors.Is(err, fs.ErrNotExist) {
+			g.release(key)
+			continue
+		}
+		if err != nil {
+			return queue.Message{}, fmt.Errorf("load message: %w", err)
+		}
+
+		return queue.Message{Key: key, Data: []byte(rec.Ciphertext)}, nil
+	}
+}
+
+// next returns the key of the next stored or scheduled message.
+func (s *source) next() (string, bool) {
+	if len(s.keys) > 0 {
+		key := s.keys[0]
+		s.keys = s.keys[1:]
+		return key, true
+	}
+
+	g := s.gateway
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if len(g.queued) == 0 {
+		return "", false
+	}
+	key := g.queued[0]
+	g.queued = g.queued[1:]
+	return key, true
+}
+
+// Ack deletes the message once its email is in the outbox, or once dropped.
+func (s *source) Ack(ctx context.Context, m queue.Message) error {
+	defer s.gateway.release(m.Key)
+	return s.gateway.storage.Delete(ctx, m.Key)
+}
+
+// sink implements the Sink interface of the queue package, adding the
+// emails of the decrypted messages to the outbox.
+type sink struct {
+	gateway *Gateway
+}
+
+// Publish adds the email of the decrypted message to the outbox.
+func (s sink) Publish(ctx context.Context, m queue.Message) error {
+	g := s.gateway
+
+	var rec record
+	if err := g.getJSON(ctx, m.Key, &rec); err != nil {
+		return fmt.Errorf("load message: %w", err)
+	}
+
+	body, err := g.body(rec, m.Data)
+	if err != nil {
+		return fmt.Errorf("compose email of %s: %w", rec.ID, err)
+	}
+
+	d := delivery{Scheduled: rec.Scheduled, Body: body, NextAttempt: g.clock.Now()}
+	if err := g.putJSON(ctx, outboxDir+rec.ID, d); err != nil {
+		return fmt.Errorf("store email: %w", err)
+	}
+
+	notify(g.outbox)
+	return nil
+}
+
+// =============================================================================
+
+// errorResponse is the JSON body of a failed request.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// writeError writes the error as the JSON body of a response.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// writeJSON writes the value as the JSON body of a response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// systemClock implements the tlock.Clock interface using the system time.
+type systemClock struct{}
+
+// Now returns the current system time.
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// notify wakes up the receiver of the channel, unless it's already notified.
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// newID returns a random identifier.
+func newID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// digest returns the hex encoded SHA-256 of the token, so tokens aren't
+// compared in variable time.
+func digest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
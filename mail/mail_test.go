@@ -0,0 +1,400 @@
+package mail_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	netmail "net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/armor"
+	"github.com/drand/tlock/internal/fakenet"
+	"github.com/drand/tlock/mail"
+	"github.com/drand/tlock/networks/beacon"
+	"github.com/drand/tlock/relay"
+)
+
+func Test_Gateway(t *testing.T) {
+	network := fakenet.NewChain(time.Second)
+	next := network.RoundNumber(time.Now()) + 1
+	storage := relay.Memory()
+
+	// The first attempt to email bob fails.
+	s := &sender{failures: map[string]int{"bob@example.org": 1}}
+	g := mail.New(network, storage, s, mail.WithTokens("secret"), mail.WithRetries(3, 10*time.Millisecond))
+
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+
+	post := func(token string, body string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/messages", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("post error %s", err)
+		}
+		defer resp.Body.Close()
+
+		var v map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&v)
+		return resp.StatusCode, v
+	}
+	message := func(to string, ciphertext string) string {
+		b, _ := json.Marshal(map[string]string{"to": to, "ciphertext": ciphertext})
+		return string(b)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		body   string
+		status int
+	}{
+		{"no token", "", message("alice@example.org", encrypt(t, network, "hello", 1)), http.StatusUnauthorized},
+		{"wrong token", "guess", message("alice@example.org", encrypt(t, network, "hello", 1)), http.StatusUnauthorized},
+		{"invalid recipient", "secret", message("alice", encrypt(t, network, "hello", 1)), http.StatusBadRequest},
+		{"not encrypted", "secret", message("alice@example.org", "hello"), http.StatusBadRequest},
+		{"other chain", "secret", message("alice@example.org", encrypt(t, fakenet.NewChain(time.Second), "hello", 1)), http.StatusBadRequest},
+		{"header injection", "secret", `{"to":"alice@example.org","subject":"hi\r\nBcc: eve@example.org","ciphertext":"x"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, v := post(tt.token, tt.body); status != tt.status {
+				t.Fatalf("expecting status %d; got %d: %v", tt.status, status, v)
+			}
+		})
+	}
+
+	status, v := post("secret", message("Alice <alice@example.org>", encrypt(t, network, "released", 1)))
+	if status != http.StatusCreated || v["to"] != "alice@example.org" || v["round"] != float64(1) || v["subject"] != "Message sealed until round 1" {
+		t.Fatalf("expecting the message to be scheduled; got %d: %v", status, v)
+	}
+
+	sched, err := g.Schedule(context.Background(), "bob@example.org", "Our prediction", encrypt(t, network, "later", next))
+	if err != nil {
+		t.Fatalf("schedule error %s", err)
+	}
+	if sched.RoundNumber != next || !sched.UnlockTime.Equal(network.RoundTime(next)) {
+		t.Fatalf("unexpected description %+v", sched)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- g.Run(ctx)
+	}()
+
+	emails := s.wait(t, 2)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expecting the gateway to stop once canceled; got %v", err)
+	}
+
+	if emails[0].To != "alice@example.org" || string(emails[0].Body) != "released" {
+		t.Fatalf("unexpected first email %+v", emails[0])
+	}
+	if emails[1].To != "bob@example.org" || emails[1].Subject != "Our prediction" || string(emails[1].Body) != "later" {
+		t.Fatalf("unexpected second email %+v", emails[1])
+	}
+	if s.attempts["bob@example.org"] != 2 {
+		t.Fatalf("expecting the failed email to be retried once; got %d attempts", s.attempts["bob@example.org"])
+	}
+
+	for _, prefix := range []string{"scheduled/", "outbox/"} {
+		if keys, err := storage.List(context.Background(), prefix); err != nil || len(keys) != 0 {
+			t.Fatalf("expecting %s to be empty; got %v, %v", prefix, keys, err)
+		}
+	}
+}
+
+func Test_GatewayRestart(t *testing.T) {
+	network := fakenet.NewChain(time.Second)
+	storage := relay.Memory()
+	ctx := context.Background()
+
+	first := mail.New(network, storage, &sender{})
+	if _, err := first.Schedule(ctx, "alice@example.org", "", encrypt(t, network, "kept", 1)); err != nil {
+		t.Fatalf("schedule error %s", err)
+	}
+
+	// The sender always fails, so the email is dropped after its attempts.
+	s := &sender{failures: map[string]int{"alice@example.org": 100}}
+	var mu sync.Mutex
+	var dropped []error
+	second := mail.New(network, storage, s, mail.WithRetries(2, 10*time.Millisecond), mail.WithErrorHandler(func(sched mail.Scheduled, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		dropped = append(dropped, err)
+	}))
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- second.Run(ctx)
+	}()
+
+	for {
+		mu.Lock()
+		n := len(dropped)
+		mu.Unlock()
+		if n > 0 || ctx.Err() != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if len(dropped) != 1 || !strings.Contains(dropped[0].Error(), "after 2 attempts") {
+		t.Fatalf("expecting the stored message to be dropped after 2 attempts; got %v", dropped)
+	}
+	if s.attempts["alice@example.org"] != 2 {
+		t.Fatalf("expecting 2 attempts; got %d", s.attempts["alice@example.org"])
+	}
+	if keys, err := storage.List(context.Background(), "outbox/"); err != nil || len(keys) != 0 {
+		t.Fatalf("expecting the dropped email to be deleted; got %v, %v", keys, err)
+	}
+}
+
+func Test_GatewayClock(t *testing.T) {
+	network := fakenet.NewChain(time.Second)
+	storage := relay.Memory()
+	c := clock{now: time.Now()}
+
+	// The first attempt fails, and the retry is due once the clock reaches it.
+	s := &sender{failures: map[string]int{"alice@example.org": 1}}
+	g := mail.New(network, storage, s, mail.WithClock(&c), mail.WithRetries(3, 10*time.Millisecond))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := g.Schedule(ctx, "alice@example.org", "", encrypt(t, network, "later", 1)); err != nil {
+		t.Fatalf("schedule error %s", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- g.Run(ctx)
+	}()
+
+	for s.count("alice@example.org") == 0 && ctx.Err() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := s.count("alice@example.org"); n != 1 {
+		t.Fatalf("expecting 1 attempt before the clock moves; got %d", n)
+	}
+
+	c.Add(time.Second)
+	if emails := s.wait(t, 1); emails[0].To != "alice@example.org" {
+		t.Fatalf("expecting the email to alice to be sent; got %v", emails)
+	}
+
+	cancel()
+	<-done
+}
+
+func Test_GatewayCiphertext(t *testing.T) {
+	network := fakenet.NewChain(time.Second)
+	s := &sender{}
+	g := mail.New(network, relay.Memory(), s, mail.WithCiphertext())
+
+	ciphertext := encrypt(t, network, "sealed", 1)
+	if _, err := g.Schedule(context.Background(), "alice@example.org", "", ciphertext); err != nil {
+		t.Fatalf("schedule error %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- g.Run(ctx)
+	}()
+
+	body := string(s.wait(t, 1)[0].Body)
+	cancel()
+	<-done
+
+	if !strings.Contains(body, strings.TrimSpace(ciphertext)) || strings.Contains(body, "sealed\n") {
+		t.Fatalf("expecting the email to hold the encrypted message only; got %q", body)
+	}
+
+	start := strings.Index(body, "{")
+	b, err := beacon.Read(strings.NewReader(body[start:]))
+	if err != nil {
+		t.Fatalf("read beacon error %s", err)
+	}
+
+	var plain bytes.Buffer
+	if err := tlock.New(b).Decrypt(&plain, strings.NewReader(ciphertext)); err != nil || plain.String() != "sealed" {
+		t.Fatalf("expecting the beacon to decrypt the message; got %q, %v", plain.String(), err)
+	}
+}
+
+func Test_SMTP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error %s", err)
+	}
+	defer ln.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		c := textproto.NewConn(conn)
+		c.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := c.ReadLine()
+			if err != nil {
+				return
+			}
+
+			switch verb := strings.ToUpper(strings.Fields(line)[0]); verb {
+			case "EHLO", "HELO", "MAIL", "RCPT":
+				c.PrintfLine("250 OK")
+			case "DATA":
+				c.PrintfLine("354 Go ahead")
+				data, _ := c.ReadDotBytes()
+				received <- string(data)
+				c.PrintfLine("250 OK")
+			case "QUIT":
+				c.PrintfLine("221 Bye")
+				return
+			default:
+				c.PrintfLine("502 Unknown command")
+			}
+		}
+	}()
+
+	s := mail.NewSMTP(ln.Addr().String(), "tlock@example.org", nil)
+	body := bytes.Repeat([]byte("A long message with non ASCII characters: é. "), 10)
+	if err := s.Send(context.Background(), mail.Email{To: "alice@example.org", Subject: "Révélé", Body: body}); err != nil {
+		t.Fatalf("send error %s", err)
+	}
+
+	msg, err := netmail.ReadMessage(bufio.NewReader(strings.NewReader(<-received)))
+	if err != nil {
+		t.Fatalf("read message error %s", err)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Révélé" || msg.Header.Get("From") != "tlock@example.org" || msg.Header.Get("To") != "alice@example.org" {
+		t.Fatalf("unexpected headers %v", msg.Header)
+	}
+
+	got, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, msg.Body))
+	if err != nil || !bytes.Equal(got, body) {
+		t.Fatalf("expecting the body to be sent; got %q, %v", got, err)
+	}
+}
+
+// =============================================================================
+
+// encrypt returns the armored encryption of the data for the round.
+func encrypt(t *testing.T, network *fakenet.Chain, data string, roundNumber uint64) string {
+	var armored bytes.Buffer
+	a := armor.NewWriter(&armored)
+	if err := tlock.New(network).Encrypt(a, strings.NewReader(data), roundNumber); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("armor error %s", err)
+	}
+	return armored.String()
+}
+
+// sender records the emails sent, failing the number of attempts set for
+// their recipient.
+type sender struct {
+	mu       sync.Mutex
+	failures map[string]int
+	attempts map[string]int
+	emails   []mail.Email
+}
+
+func (s *sender) Send(ctx context.Context, e mail.Email) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.attempts == nil {
+		s.attempts = make(map[string]int)
+	}
+	s.attempts[e.To]++
+
+	if s.attempts[e.To] <= s.failures[e.To] {
+		return errors.New("mailbox unavailable")
+	}
+
+	s.emails = append(s.emails, e)
+	return nil
+}
+
+// count returns the number of attempts to email the recipient.
+func (s *sender) count(to string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts[to]
+}
+
+// clock is a clock the tests move forward.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// wait returns the emails sent once there are n of them.
+func (s *sender) wait(t *testing.T, n int) []mail.Email {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		emails := append([]mail.Email(nil), s.emails...)
+		s.mu.Unlock()
+
+		if len(emails) >= n {
+			return emails
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("expecting %d emails to be sent", n)
+	return nil
+}
@@ -0,0 +1,69 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"time"
+)
+
+// This assertion checks that SMTP keeps satisfying the Sender interface.
+var _ Sender = (*SMTP)(nil)
+
+// SMTP sends emails through an SMTP server, upgrading the connection with
+// STARTTLS when the server supports it.
+type SMTP struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTP constructs a sender of emails from the address through the SMTP
+// server at addr, a host and port. The authentication can be nil for servers
+// that don't require it, such as a local relay.
+func NewSMTP(addr string, from string, auth smtp.Auth) *SMTP {
+	return &SMTP{
+		addr: addr,
+		from: from,
+		auth: auth,
+	}
+}
+
+// Send sends the email as UTF-8 text. The context is only checked before
+// connecting to the server.
+func (s *SMTP) Send(ctx context.Context, e Email) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := smtp.SendMail(s.addr, s.auth, s.from, []string{e.To}, s.message(e, time.Now())); err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+
+	return nil
+}
+
+// message returns the RFC 5322 message of the email, whose body is base64
+// encoded so it can hold any content.
+func (s *SMTP) message(e Email, date time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.from)
+	fmt.Fprintf(&b, "To: %s\r\n", e.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", e.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&b, "Content-Transfer-Encoding: base64\r\n\r\n")
+
+	body := base64.StdEncoding.EncodeToString(e.Body)
+	for len(body) > 76 {
+		b.WriteString(body[:76] + "\r\n")
+		body = body[76:]
+	}
+	b.WriteString(body + "\r\n")
+
+	return b.Bytes()
+}
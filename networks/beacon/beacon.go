@@ -0,0 +1,178 @@
+// Package beacon implements the Network interface of the networks package with
+// a single beacon read from a file. This allows data to be decrypted on
+// machines without network access, using a beacon fetched and verified by a
+// machine that has it.
+package beacon
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/drand/drand/chain"
+	"github.com/drand/drand/common/scheme"
+	"github.com/drand/kyber"
+	"github.com/drand/tlock/networks"
+)
+
+// ErrOtherRound represents an error when the signature of a round other than
+// the one of the bundle is requested.
+var ErrOtherRound = errors.New("beacon is for another round")
+
+// These assertions check that Bundle keeps satisfying the contract of the
+// networks package.
+var (
+	_ networks.Network       = (*Bundle)(nil)
+	_ networks.RoundTimer    = (*Bundle)(nil)
+	_ networks.SchemeNetwork = (*Bundle)(nil)
+	_ networks.BeaconSource  = (*Bundle)(nil)
+)
+
+// =============================================================================
+
+// Bundle holds the signature of a round together with the information of its
+// chain. The chain hash is computed from the information, so a bundle can
+// only be used in place of the chain it was exported from, and the signature
+// is verified against the public key of the chain.
+type Bundle struct {
+	info      *chain.Info
+	round     uint64
+	signature []byte
+}
+
+// New constructs a bundle for the signature of the round, after verifying
+// the signature.
+func New(info *chain.Info, roundNumber uint64, signature []byte) (*Bundle, error) {
+	if _, err := Verify(info, roundNumber, signature); err != nil {
+		return nil, err
+	}
+
+	b := Bundle{
+		info:      info,
+		round:     roundNumber,
+		signature: signature,
+	}
+
+	return &b, nil
+}
+
+// Verify checks that the signature of the round was produced by the chain,
+// which is useful for beacons received out of band. It returns the time at
+// which the chain published the round.
+func Verify(info *chain.Info, roundNumber uint64, signature []byte) (time.Time, error) {
+	if info.Scheme.ID != scheme.UnchainedSchemeID {
+		return time.Time{}, fmt.Errorf("chain uses scheme %q: only %q is supported", info.Scheme.ID, scheme.UnchainedSchemeID)
+	}
+
+	beacon := chain.Beacon{
+		Round:     roundNumber,
+		Signature: signature,
+	}
+
+	if err := chain.NewVerifier(info.Scheme).VerifyBeacon(beacon, info.PublicKey); err != nil {
+		return time.Time{}, fmt.Errorf("verify beacon: %w", err)
+	}
+
+	return roundTime(info, roundNumber), nil
+}
+
+// roundTime returns the time at which the chain publishes the round.
+func roundTime(info *chain.Info, roundNumber uint64) time.Time {
+	return time.Unix(chain.TimeOfRound(info.Period, info.GenesisTime, roundNumber), 0)
+}
+
+// file represents the JSON encoding of a bundle. The chain information uses
+// the format of the drand HTTP API.
+type file struct {
+	Round     uint64          `json:"round"`
+	Signature string          `json:"signature"`
+	Chain     json.RawMessage `json:"chain"`
+}
+
+// Read reads and verifies a bundle written by Write.
+func Read(r io.Reader) (*Bundle, error) {
+	var f file
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, fmt.Errorf("decoding beacon: %w", err)
+	}
+
+	if len(f.Chain) == 0 {
+		return nil, errors.New("decoding beacon: missing chain information")
+	}
+
+	info, err := chain.InfoFromJSON(bytes.NewReader(f.Chain))
+	if err != nil {
+		return nil, fmt.Errorf("decoding chain information: %w", err)
+	}
+
+	signature, err := hex.DecodeString(f.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("decoding signature: %w", err)
+	}
+
+	return New(info, f.Round, signature)
+}
+
+// Write writes the bundle as JSON.
+func (b *Bundle) Write(w io.Writer) error {
+	var info bytes.Buffer
+	if err := b.info.ToJSON(&info, nil); err != nil {
+		return fmt.Errorf("encoding chain information: %w", err)
+	}
+
+	f := file{
+		Round:     b.round,
+		Signature: hex.EncodeToString(b.signature),
+		Chain:     info.Bytes(),
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(f)
+}
+
+// Round returns the round of the beacon.
+func (b *Bundle) Round() uint64 {
+	return b.round
+}
+
+// ChainHash returns the hash of the chain the beacon belongs to.
+func (b *Bundle) ChainHash() string {
+	return b.info.HashString()
+}
+
+// SchemeID returns the drand scheme of the chain.
+func (b *Bundle) SchemeID() string {
+	return b.info.Scheme.ID
+}
+
+// PublicKey returns the public key of the chain.
+func (b *Bundle) PublicKey() kyber.Point {
+	return b.info.PublicKey
+}
+
+// Signature returns the signature of the beacon if it is for the specified
+// round.
+func (b *Bundle) Signature(roundNumber uint64) ([]byte, error) {
+	if roundNumber != b.round {
+		return nil, fmt.Errorf("%w: round %d is needed but the beacon is for round %d", ErrOtherRound, roundNumber, b.round)
+	}
+
+	return b.signature, nil
+}
+
+// GetBeacon works like Signature, so the beacon can be used as the beacon
+// source of a network that doesn't serve the round.
+func (b *Bundle) GetBeacon(_ context.Context, roundNumber uint64) ([]byte, error) {
+	return b.Signature(roundNumber)
+}
+
+// RoundTime returns the time at which the specified round becomes available.
+func (b *Bundle) RoundTime(roundNumber uint64) time.Time {
+	return roundTime(b.info, roundNumber)
+}
@@ -0,0 +1,87 @@
+package beacon_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/internal/fakenet"
+	"github.com/drand/tlock/networks/beacon"
+)
+
+func Test_Bundle(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	const roundNumber = 100
+
+	var cipherData bytes.Buffer
+	if err := tlock.New(network).Encrypt(&cipherData, strings.NewReader("offline"), roundNumber); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	signature, err := network.Signature(roundNumber)
+	if err != nil {
+		t.Fatalf("signature error %s", err)
+	}
+
+	b, err := beacon.New(network.Info(), roundNumber, signature)
+	if err != nil {
+		t.Fatalf("new bundle error %s", err)
+	}
+
+	var file bytes.Buffer
+	if err := b.Write(&file); err != nil {
+		t.Fatalf("write error %s", err)
+	}
+
+	b, err = beacon.Read(&file)
+	if err != nil {
+		t.Fatalf("read error %s", err)
+	}
+	if b.ChainHash() != network.ChainHash() || b.Round() != roundNumber {
+		t.Fatalf("unexpected bundle for chain %s round %d", b.ChainHash(), b.Round())
+	}
+
+	var plainData bytes.Buffer
+	if err := tlock.New(b).Decrypt(&plainData, &cipherData); err != nil {
+		t.Fatalf("decrypt error %s", err)
+	}
+	if plainData.String() != "offline" {
+		t.Fatalf("unexpected plain data %q", plainData.String())
+	}
+
+	if _, err := b.Signature(roundNumber + 1); !errors.Is(err, beacon.ErrOtherRound) {
+		t.Fatalf("expecting error %v; got %v", beacon.ErrOtherRound, err)
+	}
+
+	if _, err := beacon.New(network.Info(), roundNumber+1, signature); err == nil {
+		t.Fatal("expecting an error for a signature of another round")
+	}
+}
+
+func Test_Verify(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	signature, err := network.Signature(10)
+	if err != nil {
+		t.Fatalf("signature error %s", err)
+	}
+
+	published, err := beacon.Verify(network.Info(), 10, signature)
+	if err != nil {
+		t.Fatalf("verify error %s", err)
+	}
+	if !published.Equal(network.RoundTime(10)) {
+		t.Fatalf("expecting round time %s; got %s", network.RoundTime(10), published)
+	}
+
+	other := fakenet.NewChain(3 * time.Second)
+	if _, err := beacon.Verify(other.Info(), 10, signature); err == nil {
+		t.Fatal("expecting an error for the signature of another chain")
+	}
+}
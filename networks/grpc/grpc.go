@@ -0,0 +1,66 @@
+// Package grpc implements the BeaconSource interface of the networks package
+// with the public gRPC API of drand nodes, so data can be decrypted with
+// signatures retrieved from a node that doesn't serve the HTTP API. The chain
+// information still comes from a network, which the signatures are verified
+// against.
+package grpc
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/drand/drand/protobuf/common"
+	"github.com/drand/drand/protobuf/drand"
+	"github.com/drand/tlock/networks"
+	"google.golang.org/grpc"
+)
+
+// This assertion checks that Source keeps satisfying the contract of the
+// networks package.
+var _ networks.BeaconSource = (*Source)(nil)
+
+// =============================================================================
+
+// Source retrieves the signatures of the rounds of a chain from a drand node.
+type Source struct {
+	client    drand.PublicClient
+	chainHash []byte
+}
+
+// NewSource constructs a source for the chain identified by the chain hash,
+// retrieving the signatures over the connection, which the caller dials with
+// the credentials of the node and closes once done.
+func NewSource(conn grpc.ClientConnInterface, chainHash string) (*Source, error) {
+	hash, err := hex.DecodeString(chainHash)
+	if err != nil {
+		return nil, fmt.Errorf("decoding chain hash: %w", err)
+	}
+
+	s := Source{
+		client:    drand.NewPublicClient(conn),
+		chainHash: hash,
+	}
+
+	return &s, nil
+}
+
+// GetBeacon retrieves the signature of the round from the node. It fails if
+// the node doesn't serve the round yet.
+func (s *Source) GetBeacon(ctx context.Context, roundNumber uint64) ([]byte, error) {
+	req := drand.PublicRandRequest{
+		Round:    roundNumber,
+		Metadata: &common.Metadata{ChainHash: s.chainHash},
+	}
+
+	resp, err := s.client.PublicRand(ctx, &req)
+	if err != nil {
+		return nil, fmt.Errorf("public rand: %w", err)
+	}
+
+	if resp.GetRound() != roundNumber {
+		return nil, fmt.Errorf("node served round %d instead of round %d", resp.GetRound(), roundNumber)
+	}
+
+	return resp.GetSignature(), nil
+}
@@ -0,0 +1,87 @@
+package grpc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drand/drand/protobuf/drand"
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/internal/fakenet"
+	tgrpc "github.com/drand/tlock/networks/grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// node serves the signatures of a chain like the public gRPC API of drand.
+type node struct {
+	drand.UnimplementedPublicServer
+	chain *fakenet.Chain
+}
+
+func (n *node) PublicRand(_ context.Context, req *drand.PublicRandRequest) (*drand.PublicRandResponse, error) {
+	if hex.EncodeToString(req.GetMetadata().GetChainHash()) != n.chain.ChainHash() {
+		return nil, errors.New("unknown chain")
+	}
+
+	signature, err := n.chain.Signature(req.GetRound())
+	if err != nil {
+		return nil, err
+	}
+
+	return &drand.PublicRandResponse{Round: req.GetRound(), Signature: signature}, nil
+}
+
+func Test_Source(t *testing.T) {
+	network := fakenet.NewChainFromSeed(3*time.Second, "grpc source")
+	signer := fakenet.NewChainFromSeed(3*time.Second, "grpc source")
+	signer.Unlock()
+	future := network.RoundNumber(time.Now()) + 100
+
+	ln := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	drand.RegisterPublicServer(srv, &node{chain: signer})
+	go srv.Serve(ln)
+	defer srv.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial error %s", err)
+	}
+	defer conn.Close()
+
+	source, err := tgrpc.NewSource(conn, network.ChainHash())
+	if err != nil {
+		t.Fatalf("source error %s", err)
+	}
+
+	var cipherData bytes.Buffer
+	if err := tlock.New(network).Encrypt(&cipherData, strings.NewReader("relayed"), future); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	var plainData bytes.Buffer
+	if err := tlock.New(network, tlock.WithBeaconSource(source)).Decrypt(&plainData, &cipherData); err != nil {
+		t.Fatalf("decrypt error %s", err)
+	}
+	if plainData.String() != "relayed" {
+		t.Fatalf("unexpected plain data %q", plainData.String())
+	}
+
+	other, err := tgrpc.NewSource(conn, fakenet.NewChain(3*time.Second).ChainHash())
+	if err != nil {
+		t.Fatalf("source error %s", err)
+	}
+	if _, err := other.GetBeacon(context.Background(), future); err == nil {
+		t.Fatal("expecting an error for a chain the node doesn't serve")
+	}
+}
@@ -1,39 +1,151 @@
-// Package http implements the Network interface for the tlock package.
+// Package http implements the Network interface of the networks package with
+// the drand HTTP API.
 package http
 
 import (
 	"context"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/drand/drand/chain"
 	"github.com/drand/drand/client"
 	dhttp "github.com/drand/drand/client/http"
 	"github.com/drand/drand/common/scheme"
 	"github.com/drand/kyber"
+	"github.com/drand/tlock/networks"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // timeout represents the maximum amount of time to wait for network operations.
 const timeout = 5 * time.Second
 
+// tracerName identifies the spans created by the package.
+const tracerName = "github.com/drand/tlock/networks/http"
+
 // ErrNotUnchained represents an error when the informed chain belongs to a
 // chained network.
 var ErrNotUnchained = errors.New("hash does not belong to an unchained network")
 
+// EndpointError records the endpoint that a request to the network failed
+// on, so operators can tell which endpoint to look at.
+type EndpointError struct {
+	Host string
+	Err  error
+}
+
+// Error implements the error interface.
+func (e *EndpointError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Host, e.Err)
+}
+
+// Unwrap returns the error the endpoint failed with.
+func (e *EndpointError) Unwrap() error {
+	return e.Err
+}
+
+// EndpointErrors aggregates the failures of several endpoints tried in turn.
+// errors.Is and errors.As match the error of any endpoint.
+type EndpointErrors []error
+
+// Error implements the error interface. The failure of every endpoint is on
+// its own line, like errors.Join.
+func (e EndpointErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap returns the errors of the endpoints.
+func (e EndpointErrors) Unwrap() []error {
+	return e
+}
+
+// Is reports whether the error of any endpoint matches the target, for
+// versions of Go whose errors.Is doesn't unwrap several errors.
+func (e EndpointErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// As finds the first error of the endpoints that matches the target, for
+// versions of Go whose errors.As doesn't unwrap several errors.
+func (e EndpointErrors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// endpointError attributes a failure to the endpoint.
+func endpointError(host string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &EndpointError{Host: host, Err: err}
+}
+
+// These assertions check that Network keeps satisfying the contract of the
+// networks package.
+var (
+	_ networks.Network             = (*Network)(nil)
+	_ networks.ContextNetwork      = (*Network)(nil)
+	_ networks.AvailabilityNetwork = (*Network)(nil)
+	_ networks.RoundTimer          = (*Network)(nil)
+	_ networks.RoundCalculator     = (*Network)(nil)
+	_ networks.SchemeNetwork       = (*Network)(nil)
+	_ networks.BeaconSource        = (*Network)(nil)
+)
+
 // =============================================================================
 
 // Network represents the network support using the drand http client.
 type Network struct {
+	host      string
 	chainHash string
 	client    client.Client
 	publicKey kyber.Point
+	period    time.Duration
+	genesis   int64
+	info      *chain.Info
 }
 
 // NewNetwork constructs a network for use that will use the http client.
 func NewNetwork(host string, chainHash string) (*Network, error) {
+	return NewNetworkContext(context.Background(), host, chainHash)
+}
+
+// NewNetworkContext works like NewNetwork but stops retrieving the chain
+// information as soon as the context is canceled. Like the network calls of
+// the constructed network, retrieving the information is recorded as a span
+// of the global OpenTelemetry tracer provider, and its errors are attributed
+// to the host with an EndpointError.
+func NewNetworkContext(ctx context.Context, host string, chainHash string) (_ *Network, err error) {
+	ctx, span := startSpan(ctx, "drand.Info", host, chainHash)
+	defer func() { endSpan(span, err) }()
+	defer func() { err = endpointError(host, err) }()
+
 	hash, err := hex.DecodeString(chainHash)
 	if err != nil {
 		return nil, fmt.Errorf("decoding chain hash: %w", err)
@@ -44,7 +156,7 @@ func NewNetwork(host string, chainHash string) (*Network, error) {
 		return nil, fmt.Errorf("creating client: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	info, err := client.Info(ctx)
@@ -57,28 +169,58 @@ func NewNetwork(host string, chainHash string) (*Network, error) {
 	}
 
 	network := Network{
+		host:      host,
 		chainHash: chainHash,
 		client:    client,
 		publicKey: info.PublicKey,
+		period:    info.Period,
+		genesis:   info.GenesisTime,
+		info:      info,
 	}
 
 	return &network, nil
 }
 
+// Host returns the endpoint serving the network.
+func (n *Network) Host() string {
+	return n.host
+}
+
 // ChainHash returns the chain hash for this network.
 func (n *Network) ChainHash() string {
 	return n.chainHash
 }
 
+// SchemeID returns the drand scheme of the chain.
+func (n *Network) SchemeID() string {
+	return n.info.Scheme.ID
+}
+
 // PublicKey returns the kyber point needed for encryption and decryption.
 func (n *Network) PublicKey() kyber.Point {
 	return n.publicKey
 }
 
+// Info returns the information the network provides for the chain.
+func (n *Network) Info() *chain.Info {
+	return n.info
+}
+
 // Signature makes a call to the network to retrieve the signature for the
 // specified round number.
 func (n *Network) Signature(roundNumber uint64) ([]byte, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	return n.SignatureContext(context.Background(), roundNumber)
+}
+
+// SignatureContext works like Signature but stops waiting for the network as
+// soon as the context is canceled.
+func (n *Network) SignatureContext(ctx context.Context, roundNumber uint64) (_ []byte, err error) {
+	ctx, span := startSpan(ctx, "drand.Signature", n.host, n.chainHash)
+	span.SetAttributes(attribute.Int64("tlock.round", int64(roundNumber)))
+	defer func() { endSpan(span, err) }()
+	defer func() { err = endpointError(n.host, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	result, err := n.client.Get(ctx, roundNumber)
@@ -89,6 +231,75 @@ func (n *Network) Signature(roundNumber uint64) ([]byte, error) {
 	return result.Signature(), nil
 }
 
+// GetBeacon works like SignatureContext, so the network can be used as the
+// beacon source of another network.
+func (n *Network) GetBeacon(ctx context.Context, roundNumber uint64) ([]byte, error) {
+	return n.SignatureContext(ctx, roundNumber)
+}
+
+// IsAvailable reports whether the signature of the round is available, along
+// with the time at which the round is reached. It implements the tlock
+// AvailabilityNetwork interface.
+func (n *Network) IsAvailable(roundNumber uint64) (bool, time.Time, error) {
+	return n.IsAvailableContext(context.Background(), roundNumber)
+}
+
+// IsAvailableContext works like IsAvailable but stops waiting for the network
+// as soon as the context is canceled. The round is checked with a HEAD
+// request, which doesn't download the beacon, unless the endpoint only supports
+// GET. The local clock isn't trusted to tell whether the round is reached.
+func (n *Network) IsAvailableContext(ctx context.Context, roundNumber uint64) (_ bool, _ time.Time, err error) {
+	at := n.RoundTime(roundNumber)
+
+	ctx, span := startSpan(ctx, "drand.IsAvailable", n.host, n.chainHash)
+	span.SetAttributes(attribute.Int64("tlock.round", int64(roundNumber)))
+	defer func() { endSpan(span, err) }()
+	defer func() { err = endpointError(n.host, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/%s/public/%d", strings.TrimSuffix(n.host, "/"), n.chainHash, roundNumber)
+	status, err := probe(ctx, http.MethodHead, url)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = probe(ctx, http.MethodGet, url)
+	}
+	if err != nil {
+		return false, at, fmt.Errorf("probing round %d: %w", roundNumber, err)
+	}
+
+	switch status {
+	case http.StatusOK:
+		return true, at, nil
+
+	// drand answers with 404 for rounds that aren't published yet, and newer
+	// versions with 425.
+	case http.StatusNotFound, http.StatusTooEarly:
+		return false, at, nil
+	}
+
+	return false, at, fmt.Errorf("probing round %d: unexpected status %d", roundNumber, status)
+}
+
+// probe sends a request without a body to the URL and returns the status code
+// of the response, whose body is discarded.
+func probe(ctx context.Context, method string, url string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("creating request: %w", err)
+	}
+
+	client := http.Client{Transport: transport()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode, nil
+}
+
 // RoundNumber will return the latest round of randomness that is available
 // for the specified time. To handle a duration construct time like this:
 // time.Now().Add(6*time.Second)
@@ -96,8 +307,93 @@ func (n *Network) RoundNumber(t time.Time) uint64 {
 	return n.client.RoundAt(t)
 }
 
+// RoundTime returns the time at which the specified round becomes available.
+func (n *Network) RoundTime(roundNumber uint64) time.Time {
+	return time.Unix(chain.TimeOfRound(n.period, n.genesis, roundNumber), 0)
+}
+
 // =============================================================================
 
+// Chains returns the hashes of all the chains served by the specified host.
+// Errors are attributed to the host with an EndpointError.
+func Chains(ctx context.Context, host string) (_ []string, err error) {
+	defer func() { err = endpointError(host, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(host, "/")+"/chains", nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	client := http.Client{Transport: transport()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("getting chains: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("getting chains: unexpected status %s", resp.Status)
+	}
+
+	var chainHashes []string
+	if err := json.NewDecoder(resp.Body).Decode(&chainHashes); err != nil {
+		return nil, fmt.Errorf("decoding chains: %w", err)
+	}
+
+	return chainHashes, nil
+}
+
+// ChainInfo returns the information the specified host provides for the
+// chain identified by the chain hash. Errors are attributed to the host with
+// an EndpointError.
+func ChainInfo(ctx context.Context, host string, chainHash string) (_ *chain.Info, err error) {
+	defer func() { err = endpointError(host, err) }()
+
+	hash, err := hex.DecodeString(chainHash)
+	if err != nil {
+		return nil, fmt.Errorf("decoding chain hash: %w", err)
+	}
+
+	client, err := dhttp.New(host, hash, transport())
+	if err != nil {
+		return nil, fmt.Errorf("creating client: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	info, err := client.Info(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting client information: %w", err)
+	}
+
+	return info, nil
+}
+
+// =============================================================================
+
+// startSpan starts a span for a request to the endpoint.
+func startSpan(ctx context.Context, name string, host string, chainHash string) (context.Context, trace.Span) {
+	attrs := trace.WithAttributes(
+		attribute.String("tlock.endpoint", host),
+		attribute.String("tlock.chain_hash", chainHash),
+	)
+
+	return otel.Tracer(tracerName).Start(ctx, name, attrs, trace.WithSpanKind(trace.SpanKindClient))
+}
+
+// endSpan records the error of the request, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
 // transport sets reasonable defaults for the connection.
 func transport() *http.Transport {
 	return &http.Transport{
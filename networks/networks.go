@@ -0,0 +1,93 @@
+// Package networks defines the contract between the tlock package and the
+// networks providing the signatures of drand rounds, so third parties can
+// write networks of their own. The networks/http package implements it with
+// the drand HTTP API, and the networks/beacon package with a single beacon
+// read from a file.
+//
+// The signatures of a network can also come from a BeaconSource of their own,
+// such as the networks/grpc package, which retrieves them with the drand gRPC
+// API, or a BeaconFunc fetching them from a blockchain or a broadcast channel.
+//
+// A network serves a single chain. Network is required, and the other
+// interfaces are optional: tlock checks whether a network implements them
+// and falls back to the behavior they describe otherwise.
+package networks
+
+import (
+	"context"
+	"time"
+
+	"github.com/drand/kyber"
+)
+
+// Network represents a system that provides support for encrypting/decrypting
+// a DEK based on a future time.
+type Network interface {
+	// ChainHash returns the hex encoded hash of the chain, which is recorded
+	// in the header of encrypted data.
+	ChainHash() string
+
+	// PublicKey returns the public key of the chain, which data is encrypted
+	// to. It has to be in the group the scheme of the chain expects.
+	PublicKey() kyber.Point
+
+	// Signature returns the signature of the round. It has to fail if the
+	// round isn't reached yet, which tlock reports as ErrTooEarly. The
+	// signature doesn't have to be verified, since tlock verifies it.
+	Signature(roundNumber uint64) ([]byte, error)
+}
+
+// ContextNetwork is implemented by networks that can stop retrieving a
+// signature when a context is canceled. It is used instead of Signature
+// whenever a network supports it.
+type ContextNetwork interface {
+	SignatureContext(ctx context.Context, roundNumber uint64) ([]byte, error)
+}
+
+// AvailabilityNetwork is implemented by networks that can tell whether the
+// signature of a round is available without retrieving it, along with the
+// time at which the round is reached. Being unavailable isn't an error, so
+// callers waiting for a round don't have to tell it apart from a failure to
+// reach the network.
+type AvailabilityNetwork interface {
+	IsAvailable(roundNumber uint64) (bool, time.Time, error)
+}
+
+// RoundTimer is implemented by networks that can tell when a round becomes
+// available, which is used to report how long it takes until data can be
+// decrypted.
+type RoundTimer interface {
+	RoundTime(roundNumber uint64) time.Time
+}
+
+// RoundCalculator is implemented by networks that can tell the latest round
+// available at a given time, which is required to encrypt for a number of
+// rounds after the current one.
+type RoundCalculator interface {
+	RoundNumber(t time.Time) uint64
+}
+
+// BeaconSource provides the signatures of the rounds of a chain, separately
+// from the chain information and round math of a network, so data can be
+// decrypted with signatures retrieved in any way. Like Network.Signature,
+// GetBeacon has to fail if the round isn't reached yet and doesn't have to
+// verify the signature, since tlock verifies it against the public key of the
+// network.
+type BeaconSource interface {
+	GetBeacon(ctx context.Context, roundNumber uint64) ([]byte, error)
+}
+
+// BeaconFunc adapts a function to the BeaconSource interface.
+type BeaconFunc func(ctx context.Context, roundNumber uint64) ([]byte, error)
+
+// GetBeacon calls the function.
+func (f BeaconFunc) GetBeacon(ctx context.Context, roundNumber uint64) ([]byte, error) {
+	return f(ctx, roundNumber)
+}
+
+// SchemeNetwork is implemented by networks that tell the drand scheme of
+// their chain. Networks that don't are assumed to use the unchained scheme
+// signing on G2, like mainnet.
+type SchemeNetwork interface {
+	SchemeID() string
+}
@@ -0,0 +1,384 @@
+// Package queue decrypts time lock encrypted messages consumed from a message
+// queue, such as NATS or Kafka, as soon as their rounds are reached. Messages
+// that can't be decrypted yet are buffered and scheduled for their round, and
+// the messages due at the same time are decrypted together, retrieving the
+// signature of every round once. Adapting a broker only requires implementing
+// the Source and Sink interfaces.
+package queue
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/drand/tlock"
+)
+
+// These constants define the default settings of a consumer.
+const (
+	DefaultMaxPending = 10000
+	DefaultBatchSize  = 256
+	DefaultRetryDelay = time.Second
+)
+
+// Message is a message of the queue. The key identifies the message and is
+// kept when the decrypted message is published.
+type Message struct {
+	Key  string
+	Data []byte
+}
+
+// Source provides the encrypted messages. Receive blocks until a message is
+// available, and returns io.EOF once there are no more messages.
+type Source interface {
+	Receive(ctx context.Context) (Message, error)
+}
+
+// Sink receives the decrypted messages.
+type Sink interface {
+	Publish(ctx context.Context, m Message) error
+}
+
+// Acker is implemented by sources that need to be told when a message was
+// handled, either published or dropped, so brokers can commit their offset.
+// Messages buffered until their round are only acknowledged once published.
+type Acker interface {
+	Ack(ctx context.Context, m Message) error
+}
+
+// Network represents the network the messages are locked to. It has to tell
+// when rounds are reached to schedule the messages.
+type Network interface {
+	tlock.Network
+	RoundTime(roundNumber uint64) time.Time
+}
+
+// =============================================================================
+
+// Option configures a consumer constructed with NewConsumer.
+type Option func(c *Consumer)
+
+// WithTlockOptions sets the options of the tlock decrypting the messages,
+// such as their associated data or a signature cache.
+func WithTlockOptions(opts ...tlock.Option) Option {
+	return func(c *Consumer) {
+		c.opts = opts
+	}
+}
+
+// WithMaxPending sets how many messages are buffered until their round is
+// reached. No more messages are received while the buffer is full. The
+// default is DefaultMaxPending.
+func WithMaxPending(n int) Option {
+	return func(c *Consumer) {
+		c.maxPending = n
+	}
+}
+
+// WithBatchSize sets how many due messages are decrypted together. The
+// default is DefaultBatchSize.
+func WithBatchSize(n int) Option {
+	return func(c *Consumer) {
+		c.batchSize = n
+	}
+}
+
+// WithRetryDelay sets how long to wait before decrypting a message again
+// when the network doesn't serve its round yet although the round time
+// passed. The default is DefaultRetryDelay.
+func WithRetryDelay(d time.Duration) Option {
+	return func(c *Consumer) {
+		c.retryDelay = d
+	}
+}
+
+// WithErrorHandler sets a function called with the messages that can't be
+// decrypted and the reason, before they are dropped. Invalid messages are
+// dropped silently by default.
+func WithErrorHandler(fn func(m Message, err error)) Option {
+	return func(c *Consumer) {
+		c.onError = fn
+	}
+}
+
+// Consumer receives encrypted messages from a source and publishes them to a
+// sink once decrypted.
+type Consumer struct {
+	network    Network
+	src        Source
+	dst        Sink
+	opts       []tlock.Option
+	maxPending int
+	batchSize  int
+	retryDelay time.Duration
+	onError    func(m Message, err error)
+
+	pending   atomic.Int64
+	decrypted atomic.Uint64
+	retried   atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// Stats is a snapshot of the activity of a consumer, for monitoring.
+type Stats struct {
+	// Pending is the number of messages buffered until their round.
+	Pending int
+
+	// Decrypted, Retried and Dropped count the messages published, the
+	// decryptions retried because the network didn't serve the round yet,
+	// and the messages that couldn't be decrypted.
+	Decrypted uint64
+	Retried   uint64
+	Dropped   uint64
+}
+
+// Stats returns the activity of the consumer. It can be called while the
+// consumer runs.
+func (c *Consumer) Stats() Stats {
+	return Stats{
+		Pending:   int(c.pending.Load()),
+		Decrypted: c.decrypted.Load(),
+		Retried:   c.retried.Load(),
+		Dropped:   c.dropped.Load(),
+	}
+}
+
+// NewConsumer constructs a consumer of the messages of the source locked to
+// the network. Settings that aren't positive are replaced by their default.
+func NewConsumer(network Network, src Source, dst Sink, opts ...Option) *Consumer {
+	c := Consumer{
+		network:    network,
+		src:        src,
+		dst:        dst,
+		maxPending: DefaultMaxPending,
+		batchSize:  DefaultBatchSize,
+		retryDelay: DefaultRetryDelay,
+	}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	if c.batchSize <= 0 {
+		c.batchSize = DefaultBatchSize
+	}
+	if c.maxPending <= 0 {
+		c.maxPending = DefaultMaxPending
+	}
+	if c.retryDelay <= 0 {
+		c.retryDelay = DefaultRetryDelay
+	}
+
+	return &c
+}
+
+// Run consumes messages until the context is canceled or the source fails.
+// Once the source returned io.EOF, Run waits for the buffered messages to be
+// published and returns nil. Messages buffered when Run returns early aren't
+// acknowledged, so the broker delivers them again.
+func (c *Consumer) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	received := make(chan Message)
+	failed := make(chan error, 1)
+	go c.receive(ctx, received, failed)
+
+	var pending pendingQueue
+	var exhausted bool
+	defer c.pending.Store(0)
+
+	for {
+		if err := c.decryptDue(ctx, &pending); err != nil {
+			return err
+		}
+		c.pending.Store(int64(pending.Len()))
+
+		if exhausted && pending.Len() == 0 {
+			return nil
+		}
+
+		in := received
+		if exhausted || pending.Len() >= c.maxPending {
+			in = nil
+		}
+
+		var timer *time.Timer
+		var wake <-chan time.Time
+		if pending.Len() > 0 {
+			timer = time.NewTimer(time.Until(pending[0].due))
+			wake = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case err := <-failed:
+			return err
+
+		case m, ok := <-in:
+			switch {
+			case ok:
+				if err := c.schedule(ctx, &pending, m); err != nil {
+					return err
+				}
+
+			default:
+				// The source may have failed right before closing the
+				// channel.
+				select {
+				case err := <-failed:
+					return err
+				default:
+				}
+				exhausted = true
+			}
+
+		case <-wake:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// receive passes the messages of the source to the channel, which is closed
+// once the source has no more messages or failed.
+func (c *Consumer) receive(ctx context.Context, out chan<- Message, failed chan<- error) {
+	defer close(out)
+
+	for {
+		m, err := c.src.Receive(ctx)
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			failed <- err
+			return
+		}
+
+		select {
+		case out <- m:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// schedule buffers the message until its round is reached. Messages without
+// a valid header are dropped.
+func (c *Consumer) schedule(ctx context.Context, pending *pendingQueue, m Message) error {
+	header, err := tlock.ReadHeader(bytes.NewReader(m.Data))
+	if err != nil {
+		return c.drop(ctx, m, err)
+	}
+
+	heap.Push(pending, pendingMessage{
+		msg: m,
+		due: c.network.RoundTime(header.RoundNumber),
+	})
+
+	return nil
+}
+
+// decryptDue decrypts and publishes the buffered messages whose round time
+// passed. Messages the network doesn't serve the round of yet are retried
+// after the retry delay.
+func (c *Consumer) decryptDue(ctx context.Context, pending *pendingQueue) error {
+	tl := tlock.New(c.network, c.opts...)
+
+	for {
+		now := time.Now()
+
+		var due []pendingMessage
+		for pending.Len() > 0 && len(due) < c.batchSize && !(*pending)[0].due.After(now) {
+			due = append(due, heap.Pop(pending).(pendingMessage))
+		}
+		if len(due) == 0 {
+			return nil
+		}
+
+		items := make([]tlock.BatchItem, len(due))
+		plain := make([]bytes.Buffer, len(due))
+		for i, p := range due {
+			items[i] = tlock.BatchItem{Src: bytes.NewReader(p.msg.Data), Dst: &plain[i]}
+		}
+
+		for i, r := range tl.DecryptBatch(ctx, items) {
+			switch {
+			case r.Err == nil:
+				if err := c.dst.Publish(ctx, Message{Key: due[i].msg.Key, Data: plain[i].Bytes()}); err != nil {
+					return err
+				}
+				if err := c.ack(ctx, due[i].msg); err != nil {
+					return err
+				}
+				c.decrypted.Add(1)
+
+			case errors.Is(r.Err, tlock.ErrTooEarly):
+				c.retried.Add(1)
+				due[i].due = now.Add(c.retryDelay)
+				heap.Push(pending, due[i])
+
+			case ctx.Err() != nil:
+				return ctx.Err()
+
+			default:
+				if err := c.drop(ctx, due[i].msg, r.Err); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// drop reports the message that can't be decrypted and acknowledges it.
+func (c *Consumer) drop(ctx context.Context, m Message, err error) error {
+	c.dropped.Add(1)
+	if c.onError != nil {
+		c.onError(m, err)
+	}
+
+	return c.ack(ctx, m)
+}
+
+// ack acknowledges the message if the source requires it.
+func (c *Consumer) ack(ctx context.Context, m Message) error {
+	if a, ok := c.src.(Acker); ok {
+		return a.Ack(ctx, m)
+	}
+	return nil
+}
+
+// =============================================================================
+
+// pendingMessage is a message buffered until it's due.
+type pendingMessage struct {
+	msg Message
+	due time.Time
+}
+
+// pendingQueue orders the buffered messages by the time they are due. It
+// implements heap.Interface.
+type pendingQueue []pendingMessage
+
+func (q pendingQueue) Len() int           { return len(q) }
+func (q pendingQueue) Less(i, j int) bool { return q[i].due.Before(q[j].due) }
+func (q pendingQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *pendingQueue) Push(x interface{}) {
+	*q = append(*q, x.(pendingMessage))
+}
+
+func (q *pendingQueue) Pop() interface{} {
+	old := *q
+	p := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return p
+}
@@ -0,0 +1,116 @@
+package queue_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/internal/fakenet"
+	"github.com/drand/tlock/queue"
+)
+
+func Test_Consumer(t *testing.T) {
+	network := fakenet.NewChain(time.Second)
+	next := network.RoundNumber(time.Now()) + 1
+
+	encrypt := func(data string, roundNumber uint64) []byte {
+		var cipherData bytes.Buffer
+		if err := tlock.New(network).Encrypt(&cipherData, bytes.NewReader([]byte(data)), roundNumber); err != nil {
+			t.Fatalf("encrypt error %s", err)
+		}
+		return cipherData.Bytes()
+	}
+
+	src := &source{messages: []queue.Message{
+		{Key: "later", Data: encrypt("reveal", next)},
+		{Key: "invalid", Data: []byte("not encrypted")},
+		{Key: "now", Data: encrypt("commit", 1)},
+	}}
+	var dst sink
+
+	var dropped []string
+	c := queue.NewConsumer(network, src, &dst, queue.WithErrorHandler(func(m queue.Message, err error) {
+		dropped = append(dropped, m.Key)
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := c.Run(ctx); err != nil {
+		t.Fatalf("run error %s", err)
+	}
+
+	if len(dst.messages) != 2 || dst.messages[0].Key != "now" || dst.messages[1].Key != "later" {
+		t.Fatalf("expecting the messages to be published as their rounds are reached; got %v", dst.messages)
+	}
+	if string(dst.messages[0].Data) != "commit" || string(dst.messages[1].Data) != "reveal" {
+		t.Fatalf("decrypted messages are invalid: %q, %q", dst.messages[0].Data, dst.messages[1].Data)
+	}
+	if len(dropped) != 1 || dropped[0] != "invalid" {
+		t.Fatalf("expecting the invalid message to be dropped; got %v", dropped)
+	}
+	if len(src.acked) != 3 {
+		t.Fatalf("expecting every message to be acknowledged; got %v", src.acked)
+	}
+	if stats := c.Stats(); stats.Decrypted != 2 || stats.Dropped != 1 || stats.Pending != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func Test_ConsumerSourceError(t *testing.T) {
+	errBroker := errors.New("broker unavailable")
+	src := &source{err: errBroker}
+
+	err := queue.NewConsumer(fakenet.NewChain(time.Second), src, &sink{}).Run(context.Background())
+	if !errors.Is(err, errBroker) {
+		t.Fatalf("expecting error %v; got %v", errBroker, err)
+	}
+}
+
+// source provides the messages in order, then fails with its error or
+// io.EOF.
+type source struct {
+	mu       sync.Mutex
+	messages []queue.Message
+	acked    []string
+	err      error
+}
+
+func (s *source) Receive(ctx context.Context) (queue.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.messages) == 0 {
+		if s.err != nil {
+			return queue.Message{}, s.err
+		}
+		return queue.Message{}, io.EOF
+	}
+
+	m := s.messages[0]
+	s.messages = s.messages[1:]
+	return m, nil
+}
+
+func (s *source) Ack(ctx context.Context, m queue.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.acked = append(s.acked, m.Key)
+	return nil
+}
+
+// sink records the published messages.
+type sink struct {
+	messages []queue.Message
+}
+
+func (s *sink) Publish(ctx context.Context, m queue.Message) error {
+	s.messages = append(s.messages, m)
+	return nil
+}
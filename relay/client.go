@@ -0,0 +1,269 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drand/tlock"
+)
+
+// DefaultChunkSize is the default size of the requests resumable uploads are
+// made of.
+const DefaultChunkSize = 4 << 20
+
+// ClientOption configures a client constructed with NewClient.
+type ClientOption func(c *Client)
+
+// WithToken sets the bearer token identifying the owner of the items pushed
+// by the client. It is required to list them and to resume uploads.
+func WithToken(token string) ClientOption {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithHTTPClient sets the HTTP client the requests are made with. The default
+// is http.DefaultClient.
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(c *Client) {
+		c.client = client
+	}
+}
+
+// WithChunkSize sets the size of the requests resumable uploads are made of.
+// The default is DefaultChunkSize.
+func WithChunkSize(size int64) ClientOption {
+	return func(c *Client) {
+		c.chunkSize = size
+	}
+}
+
+// Client speaks the relay protocol to a server.
+type Client struct {
+	url       string
+	token     string
+	client    *http.Client
+	chunkSize int64
+}
+
+// NewClient constructs a client of the relay server at the base URL.
+func NewClient(baseURL string, opts ...ClientOption) *Client {
+	c := Client{
+		url:       strings.TrimRight(baseURL, "/"),
+		client:    http.DefaultClient,
+		chunkSize: DefaultChunkSize,
+	}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return &c
+}
+
+// Push uploads the encrypted data as a new item, claiming the round its
+// header is locked to. It is a resumable upload whose id is lost, use
+// StartUpload and ResumeUpload to resume uploads that are interrupted.
+func (c *Client) Push(ctx context.Context, src io.ReadSeeker) (Item, error) {
+	header, err := tlock.ReadHeader(src)
+	if err != nil {
+		return Item{}, err
+	}
+
+	u, err := c.StartUpload(ctx, header.RoundNumber)
+	if err != nil {
+		return Item{}, err
+	}
+
+	return c.ResumeUpload(ctx, u.ID, src)
+}
+
+// StartUpload starts a resumable upload of an item locked to the round, or
+// to any round if it is zero.
+func (c *Client) StartUpload(ctx context.Context, roundNumber uint64) (Upload, error) {
+	path := "/" + uploadsDir
+	if roundNumber != 0 {
+		path += "?round=" + strconv.FormatUint(roundNumber, 10)
+	}
+
+	var u Upload
+	if err := c.do(ctx, http.MethodPost, path, nil, nil, &u); err != nil {
+		return Upload{}, fmt.Errorf("start upload: %w", err)
+	}
+
+	return u, nil
+}
+
+// ResumeUpload sends the encrypted data of the upload from the offset the
+// server is at, in chunks, and completes the upload into an item.
+func (c *Client) ResumeUpload(ctx context.Context, id string, src io.ReadSeeker) (Item, error) {
+	path := "/" + uploadsDir + "/" + url.PathEscape(id)
+
+	var u Upload
+	if err := c.do(ctx, http.MethodGet, path, nil, nil, &u); err != nil {
+		return Item{}, fmt.Errorf("resume upload: %w", err)
+	}
+
+	if _, err := src.Seek(u.Offset, io.SeekStart); err != nil {
+		return Item{}, fmt.Errorf("resume upload: %w", err)
+	}
+
+	chunk := make([]byte, c.chunkSize)
+	for {
+		n, err := io.ReadFull(src, chunk)
+		if n > 0 {
+			header := http.Header{"Upload-Offset": {strconv.FormatInt(u.Offset, 10)}}
+			if err := c.do(ctx, http.MethodPatch, path, header, bytes.NewReader(chunk[:n]), &u); err != nil {
+				return Item{}, fmt.Errorf("upload: %w", err)
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return Item{}, fmt.Errorf("read: %w", err)
+		}
+	}
+
+	var item Item
+	if err := c.do(ctx, http.MethodPost, path+"/complete", nil, nil, &item); err != nil {
+		return Item{}, fmt.Errorf("complete upload: %w", err)
+	}
+
+	return item, nil
+}
+
+// Item returns the description of the item.
+func (c *Client) Item(ctx context.Context, id string) (Item, error) {
+	var item Item
+	if err := c.do(ctx, http.MethodGet, "/items/"+url.PathEscape(id), nil, nil, &item); err != nil {
+		return Item{}, err
+	}
+
+	return item, nil
+}
+
+// Items lists the items pushed with the token of the client, in the order
+// they are released. With pending set, only the items that aren't released
+// yet are listed.
+func (c *Client) Items(ctx context.Context, pending bool) ([]Item, error) {
+	path := "/items"
+	if pending {
+		path += "?pending=1"
+	}
+
+	var items []Item
+	if err := c.do(ctx, http.MethodGet, path, nil, nil, &items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// Pull writes the encrypted data of the item to dst. It fails with a
+// TooEarlyError, which matches tlock.ErrTooEarly, if the item isn't released
+// yet.
+func (c *Client) Pull(ctx context.Context, id string, dst io.Writer) error {
+	resp, err := c.request(ctx, http.MethodGet, "/items/"+url.PathEscape(id)+"/data", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(dst, resp.Body); err != nil {
+		return fmt.Errorf("read item: %w", err)
+	}
+
+	return nil
+}
+
+// do makes a request and decodes the JSON body of its response into v.
+func (c *Client) do(ctx context.Context, method string, path string, header http.Header, body io.Reader, v interface{}) error {
+	resp, err := c.request(ctx, method, path, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+
+	return nil
+}
+
+// request makes a request, returning the error reported by the server if it
+// fails.
+func (c *Client) request(ctx context.Context, method string, path string, header http.Header, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	return nil, responseError(resp)
+}
+
+// TooEarlyError reports an item requested before it's released, with the
+// time it's released at so the request can be retried then. It matches
+// tlock.ErrTooEarly.
+type TooEarlyError struct {
+	RoundNumber uint64
+	UnlockTime  time.Time
+}
+
+// Error implements the error interface.
+func (e *TooEarlyError) Error() string {
+	return fmt.Sprintf("%v: round %d is reached at %s", tlock.ErrTooEarly, e.RoundNumber, e.UnlockTime.Format(time.RFC3339))
+}
+
+// Is reports whether the target is tlock.ErrTooEarly.
+func (e *TooEarlyError) Is(target error) bool {
+	return target == tlock.ErrTooEarly
+}
+
+// responseError returns the error reported in the response, wrapping the
+// error of the package, or of tlock, it starts with.
+func responseError(resp *http.Response) error {
+	var e errorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+		return fmt.Errorf("relay responded %s", resp.Status)
+	}
+
+	if e.UnlockTime != nil {
+		return &TooEarlyError{RoundNumber: e.RoundNumber, UnlockTime: *e.UnlockTime}
+	}
+
+	for _, err := range []error{tlock.ErrTooEarly, ErrNotFound, ErrUploadNotFound} {
+		if strings.HasPrefix(e.Error, err.Error()) {
+			return fmt.Errorf("%w%s", err, strings.TrimPrefix(e.Error, err.Error()))
+		}
+	}
+
+	return errors.New(e.Error)
+}
@@ -0,0 +1,147 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Key is an API key of a relay. Once a server has keys, uploading items and
+// listing them requires the token of one of them as the bearer token, while
+// items are still described and served to anyone.
+type Key struct {
+	Name  string
+	Token string
+
+	// RateLimit is the number of requests per minute made with the key, zero
+	// meaning unlimited. Bursts of up to that many requests are allowed.
+	RateLimit int
+
+	// MaxSize is the size of the largest item uploaded with the key, zero
+	// meaning the one of the server.
+	MaxSize int64
+
+	// Quota is the total size of the items stored with the key, zero meaning
+	// unlimited.
+	Quota int64
+}
+
+// WithKeys restricts the uploads to the holders of the keys, each with its
+// own limits.
+func WithKeys(keys ...Key) Option {
+	return func(s *Server) {
+		s.SetKeys(keys...)
+	}
+}
+
+// SetKeys replaces the keys of the server while it runs, such as when its
+// configuration is reloaded. Keys whose token is kept keep what remains of
+// their rate limit, and the items of removed keys are still served. Without
+// keys, the server accepts uploads from anyone again.
+func (s *Server) SetKeys(keys ...Key) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states := make(map[string]*keyState, len(keys))
+	for _, k := range keys {
+		o := digest(k.Token)
+
+		ks := keyState{Key: k, tokens: float64(k.RateLimit)}
+		if prev, exists := s.keys[o]; exists && prev.RateLimit != 0 {
+			ks.tokens = math.Min(prev.tokens, ks.tokens)
+			ks.last = prev.last
+		}
+		states[o] = &ks
+	}
+
+	s.keys = states
+}
+
+// keyState is a key along with the requests it can make right away.
+type keyState struct {
+	Key
+	tokens float64
+	last   time.Time
+}
+
+// authorize checks the key of the request, if the server has keys, and
+// consumes one request of its rate limit. It returns the status of the
+// failure. Describing and downloading items and streaming events doesn't
+// require a key.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, public bool) (int, error) {
+	if len(s.keys) == 0 {
+		return 0, nil
+	}
+
+	o := owner(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, exists := s.keys[o]
+	if !exists {
+		if public && o == "" {
+			return 0, nil
+		}
+		return http.StatusUnauthorized, errors.New("a valid API key is required")
+	}
+
+	if k.RateLimit == 0 {
+		return 0, nil
+	}
+
+	// The bucket refills at the rate limit, up to the rate limit.
+	now := s.clock.Now()
+	rate := float64(k.RateLimit) / float64(time.Minute)
+	if !k.last.IsZero() {
+		k.tokens = math.Min(float64(k.RateLimit), k.tokens+float64(now.Sub(k.last))*rate)
+	}
+	k.last = now
+
+	if k.tokens < 1 {
+		s.throttled.Add(1)
+		wait := time.Duration((1 - k.tokens) / rate)
+		w.Header().Set("Retry-After", strconv.FormatInt(int64((wait+time.Second-1)/time.Second), 10))
+		return http.StatusTooManyRequests, fmt.Errorf("key %s exceeded %d requests per minute", k.Name, k.RateLimit)
+	}
+	k.tokens--
+
+	return 0, nil
+}
+
+// maxSizeOf returns the size of the largest item the owner can upload.
+func (s *Server) maxSizeOf(owner string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if k, exists := s.keys[owner]; exists && k.MaxSize != 0 && k.MaxSize < s.maxSize {
+		return k.MaxSize
+	}
+
+	return s.maxSize
+}
+
+// checkQuota checks that the owner has room for an item of the size. It has
+// to be called with the lock held.
+func (s *Server) checkQuota(owner string, size int64) error {
+	k, exists := s.keys[owner]
+	if !exists || k.Quota == 0 {
+		return nil
+	}
+
+	used := size
+	for _, rec := range s.items {
+		if rec.Owner == owner {
+			used += rec.Size
+		}
+	}
+
+	if used > k.Quota {
+		return fmt.Errorf("key %s exceeded its quota of %d bytes", k.Name, k.Quota)
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// errBusy represents an error when the uploads in progress and waiting are at
+// their limits.
+var errBusy = errors.New("too many uploads in progress")
+
+// WithMaxUploads bounds the uploads handled at once, whether whole items or
+// parts of resumable uploads. Up to queue more uploads wait for their turn,
+// and the others fail with 429 Too Many Requests, so a burst of uploads can't
+// exhaust the server. Uploads aren't bounded by default.
+func WithMaxUploads(n int, queue int) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.uploads = &limiter{slots: make(chan struct{}, n), queue: int64(queue)}
+		}
+	}
+}
+
+// limiter bounds the requests handled at once, with a bounded number of
+// requests waiting for their turn. A nil limiter doesn't bound anything.
+type limiter struct {
+	slots   chan struct{}
+	queue   int64
+	waiting atomic.Int64
+}
+
+// acquire waits for a slot, failing with errBusy if the queue is full.
+func (l *limiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if l.waiting.Add(1) > l.queue {
+		l.waiting.Add(-1)
+		return errBusy
+	}
+	defer l.waiting.Add(-1)
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees the slot acquired.
+func (l *limiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
@@ -0,0 +1,726 @@
+// Package relay implements a store-and-forward service for time lock
+// encrypted data. Clients upload encrypted items, which the server keeps and
+// only lets anyone download once the round they are locked to is reached,
+// turning tlock into an embargoed publishing system. The server never holds
+// anything it could decrypt, and the embargo doesn't rely on it: it only
+// saves readers from polling the network themselves.
+//
+// The protocol is made of these endpoints:
+//
+//	POST  /items                  uploads an item and returns its description
+//	GET   /items                  lists the items of the owner
+//	GET   /items/{id}             returns the description of an item
+//	GET   /items/{id}/data        returns the encrypted data once the round is reached
+//	GET   /events                 streams the items as they are released
+//	POST  /uploads                starts a resumable upload
+//	GET   /uploads/{id}           returns the offset of a resumable upload
+//	PATCH /uploads/{id}           appends to a resumable upload at its offset
+//	POST  /uploads/{id}/complete  turns a resumable upload into an item
+//	GET   /healthz                reports that the server is running
+//	GET   /readyz                 reports whether the server can serve requests
+//
+// Uploads can claim the round the item is locked to with the round query
+// parameter, which fails the upload unless the header of the encrypted data
+// is locked to that round. Clients identify their owner with a bearer token,
+// which is required to list items and to resume uploads started with it. A
+// server with API keys only accepts the tokens of its keys for everything but
+// describing and downloading items and streaming events, and rejects the
+// requests beyond the rate limit of a key with 429 Too Many Requests. Data
+// requested too early fails with 425 Too Early and a Retry-After header, and
+// its JSON body tells the round of the item and when it's released.
+// Events are server-sent events named "released" whose data is the
+// description of the item. The server is ready once its storage can be
+// listed and the readiness check set with WithReadiness passes, such as the
+// drand network being reachable, and fails /readyz with 503 Service
+// Unavailable otherwise, so orchestrators only route requests to servers
+// able to handle them. The protocol is described by the OpenAPI document
+// served at /openapi.json, which /docs renders.
+package relay
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drand/tlock"
+)
+
+// DefaultMaxSize is the default size of the largest item the server accepts.
+const DefaultMaxSize = 64 << 20
+
+// These are the extensions of the objects holding the encrypted data and the
+// description of every item, whose key is its identifier.
+const (
+	dataExt = ".tle"
+	itemExt = ".json"
+)
+
+// openAPI is the OpenAPI document describing the protocol, served at
+// /openapi.json, and docs the page rendering it, served at /docs.
+var (
+	//go:embed openapi.json
+	openAPI []byte
+
+	//go:embed docs.html
+	docs []byte
+)
+
+// ErrNotFound represents an error when an item doesn't exist.
+var ErrNotFound = errors.New("item not found")
+
+// Item describes an item stored by a relay. The round and chain are read from
+// the header of the encrypted data.
+type Item struct {
+	ID          string    `json:"id"`
+	RoundNumber uint64    `json:"round"`
+	ChainHash   string    `json:"chain_hash"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	Created     time.Time `json:"created"`
+	UnlockTime  time.Time `json:"unlock_time"`
+}
+
+// record is an item as stored by a server, with the digest of the token of
+// its owner, if any.
+type record struct {
+	Item
+	Owner string `json:"owner,omitempty"`
+}
+
+// RoundTimer tells when the rounds of a chain are reached, like the networks
+// of the networks/http package.
+type RoundTimer interface {
+	RoundTime(roundNumber uint64) time.Time
+}
+
+// Resolver returns the round timer of the chain with the hash, or an error if
+// the relay doesn't accept items locked to that chain.
+type Resolver func(ctx context.Context, chainHash string) (RoundTimer, error)
+
+// =============================================================================
+
+// Option configures a server constructed with NewServer.
+type Option func(s *Server)
+
+// WithMaxSize sets the size of the largest item the server accepts. The
+// default is DefaultMaxSize.
+func WithMaxSize(size int64) Option {
+	return func(s *Server) {
+		s.maxSize = size
+	}
+}
+
+// WithClock sets the clock deciding when items are released. The default is
+// the system clock.
+func WithClock(clock tlock.Clock) Option {
+	return func(s *Server) {
+		s.clock = clock
+	}
+}
+
+// WithReadiness sets a check /readyz runs in addition to listing the
+// storage, such as whether the networks of the chains can be reached. The
+// server isn't ready while the check fails.
+func WithReadiness(check func(ctx context.Context) error) Option {
+	return func(s *Server) {
+		s.ready = check
+	}
+}
+
+// Server serves the relay protocol, keeping the items in a storage.
+type Server struct {
+	storage Storage
+	resolve Resolver
+	clock   tlock.Clock
+	maxSize int64
+	ready   func(ctx context.Context) error
+
+	mu      sync.Mutex
+	items   map[string]record
+	pending map[string]bool
+	keys    map[string]*keyState
+
+	// changed is closed and replaced whenever an item is added, which wakes
+	// up the event streams.
+	changed chan struct{}
+
+	// uploads bounds the uploads handled at once.
+	uploads *limiter
+
+	subscribers   atomic.Int64
+	resolveErrors atomic.Uint64
+	throttled     atomic.Uint64
+	busy          atomic.Uint64
+}
+
+// NewServer constructs a server keeping its items in the storage. The items
+// already stored there are served again. The resolver decides which chains
+// are accepted and when their rounds are reached.
+func NewServer(storage Storage, resolve Resolver, opts ...Option) (*Server, error) {
+	s := Server{
+		storage: storage,
+		resolve: resolve,
+		clock:   systemClock{},
+		maxSize: DefaultMaxSize,
+		items:   make(map[string]record),
+		pending: make(map[string]bool),
+		keys:    make(map[string]*keyState),
+		changed: make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	ctx := context.Background()
+
+	keys, err := storage.List(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("list items: %w", err)
+	}
+
+	for _, key := range keys {
+		if !strings.HasSuffix(key, itemExt) {
+			continue
+		}
+
+		var rec record
+		if err := s.getJSON(ctx, key, &rec); err != nil {
+			return nil, fmt.Errorf("read item: %w", err)
+		}
+		s.items[rec.ID] = rec
+	}
+
+	return &s, nil
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	public := r.Method == http.MethodGet && (parts[0] == "events" || parts[0] == "items" && len(parts) > 1 || parts[0] == "openapi.json" || parts[0] == "docs" || parts[0] == "healthz" || parts[0] == "readyz")
+	if status, err := s.authorize(w, r, public); err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 1 && parts[0] == "items":
+		s.upload(w, r)
+
+	case r.Method == http.MethodGet && len(parts) == 1 && parts[0] == "items":
+		s.list(w, r)
+
+	case r.Method == http.MethodGet && len(parts) == 2 && parts[0] == "items":
+		s.describe(w, parts[1])
+
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[0] == "items" && parts[2] == "data":
+		s.download(w, r, parts[1])
+
+	case r.Method == http.MethodGet && len(parts) == 1 && parts[0] == "events":
+		s.events(w, r)
+
+	case r.Method == http.MethodGet && len(parts) == 1 && parts[0] == "openapi.json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPI)
+
+	case r.Method == http.MethodGet && len(parts) == 1 && parts[0] == "docs":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(docs)
+
+	case r.Method == http.MethodGet && len(parts) == 1 && parts[0] == "healthz":
+		writeJSON(w, http.StatusOK, healthResponse{Status: "ok"})
+
+	case r.Method == http.MethodGet && len(parts) == 1 && parts[0] == "readyz":
+		s.readiness(w, r)
+
+	case r.Method == http.MethodPost && len(parts) == 1 && parts[0] == uploadsDir:
+		s.startUpload(w, r)
+
+	case r.Method == http.MethodGet && len(parts) == 2 && parts[0] == uploadsDir:
+		s.uploadStatus(w, r, parts[1])
+
+	case r.Method == http.MethodPatch && len(parts) == 2 && parts[0] == uploadsDir:
+		s.appendUpload(w, r, parts[1])
+
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[0] == uploadsDir && parts[2] == "complete":
+		s.completeUpload(w, r, parts[1])
+
+	default:
+		writeError(w, http.StatusNotFound, errors.New("unknown endpoint"))
+	}
+}
+
+// upload stores the encrypted data of the request body as a new item.
+func (s *Server) upload(w http.ResponseWriter, r *http.Request) {
+	claim, err := claimedRound(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.admit(w, r) {
+		return
+	}
+	defer s.uploads.release()
+
+	tmp, err := spool()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, http.MaxBytesReader(w, r.Body, s.maxSizeOf(owner(r)))); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("read item: %w", err))
+		return
+	}
+
+	item, status, err := s.store(r.Context(), tmp, claim, owner(r))
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, item)
+}
+
+// store reads the header of the uploaded data, spooled to the file, checks it
+// against the claimed round, if any, and stores the data as a new item of the
+// owner. It returns the status of the failure. The description is written
+// last, so an interrupted upload leaves no item.
+func (s *Server) store(ctx context.Context, f *os.File, claim uint64, owner string) (Item, int, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Item{}, http.StatusInternalServerError, err
+	}
+
+	// Everything read from the file is hashed, including what is read ahead
+	// of the header.
+	h := sha256.New()
+	src := io.TeeReader(f, h)
+
+	header, err := tlock.ReadHeader(src)
+	if err != nil {
+		return Item{}, http.StatusBadRequest, err
+	}
+
+	if claim != 0 && header.RoundNumber != claim {
+		return Item{}, http.StatusBadRequest, fmt.Errorf("the header is locked to round %d, not %d", header.RoundNumber, claim)
+	}
+
+	if _, err := io.Copy(io.Discard, src); err != nil {
+		return Item{}, http.StatusInternalServerError, err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return Item{}, http.StatusInternalServerError, err
+	}
+
+	rt, err := s.resolve(ctx, header.ChainHash)
+	if err != nil {
+		s.resolveErrors.Add(1)
+		return Item{}, http.StatusBadRequest, fmt.Errorf("chain %s: %w", header.ChainHash, err)
+	}
+
+	id, err := newID()
+	if err != nil {
+		return Item{}, http.StatusInternalServerError, err
+	}
+
+	rec := record{
+		Item: Item{
+			ID:          id,
+			RoundNumber: header.RoundNumber,
+			ChainHash:   header.ChainHash,
+			Size:        size,
+			SHA256:      hex.EncodeToString(h.Sum(nil)),
+			Created:     s.clock.Now().UTC(),
+			UnlockTime:  rt.RoundTime(header.RoundNumber).UTC(),
+		},
+		Owner: owner,
+	}
+
+	// The quota is checked before the data is stored, which can take a
+	// while, and again once it is.
+	s.mu.Lock()
+	err = s.checkQuota(owner, size)
+	s.mu.Unlock()
+	if err != nil {
+		return Item{}, http.StatusRequestEntityTooLarge, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Item{}, http.StatusInternalServerError, err
+	}
+	if err := s.storage.Put(ctx, id+dataExt, f); err != nil {
+		return Item{}, http.StatusInternalServerError, fmt.Errorf("store item: %w", err)
+	}
+
+	// The lock is held until the item is recorded, so concurrent uploads
+	// can't exceed the quota together.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkQuota(owner, size); err != nil {
+		s.storage.Delete(ctx, id+dataExt)
+		return Item{}, http.StatusRequestEntityTooLarge, err
+	}
+
+	if err := s.putJSON(ctx, id+itemExt, rec); err != nil {
+		s.storage.Delete(ctx, id+dataExt)
+		return Item{}, http.StatusInternalServerError, fmt.Errorf("store item: %w", err)
+	}
+
+	s.items[rec.ID] = rec
+	close(s.changed)
+	s.changed = make(chan struct{})
+
+	return rec.Item, 0, nil
+}
+
+// list returns the items of the owner identified by the request, in the
+// order they are released. With the pending query parameter set to 1, only
+// the items that aren't released yet are listed.
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	o := owner(r)
+	if o == "" {
+		writeError(w, http.StatusUnauthorized, errors.New("listing items requires a token"))
+		return
+	}
+	pending := r.URL.Query().Get("pending") == "1"
+	now := s.clock.Now()
+
+	s.mu.Lock()
+	items := []Item{}
+	for _, rec := range s.items {
+		if rec.Owner == o && (!pending || rec.UnlockTime.After(now)) {
+			items = append(items, rec.Item)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].UnlockTime.Before(items[j].UnlockTime)
+	})
+
+	writeJSON(w, http.StatusOK, items)
+}
+
+// admit waits for the turn of an upload, reporting false if the request was
+// rejected because too many uploads are in progress or canceled.
+func (s *Server) admit(w http.ResponseWriter, r *http.Request) bool {
+	err := s.uploads.acquire(r.Context())
+	switch {
+	case err == nil:
+		return true
+
+	case errors.Is(err, errBusy):
+		s.busy.Add(1)
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusTooManyRequests, err)
+
+	default:
+		writeError(w, http.StatusServiceUnavailable, err)
+	}
+
+	return false
+}
+
+// describe returns the description of the item, which is available before
+// the item is released.
+func (s *Server) describe(w http.ResponseWriter, id string) {
+	item, err := s.item(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, item)
+}
+
+// download returns the encrypted data of the item once it's released.
+func (s *Server) download(w http.ResponseWriter, r *http.Request, id string) {
+	item, err := s.item(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	if remaining := item.UnlockTime.Sub(s.clock.Now()); remaining > 0 {
+		retryAfter := int64((remaining + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+		writeJSON(w, http.StatusTooEarly, errorResponse{
+			Error:       fmt.Sprintf("%v: round %d is reached in %s", tlock.ErrTooEarly, item.RoundNumber, remaining.Round(time.Second)),
+			RoundNumber: item.RoundNumber,
+			UnlockTime:  &item.UnlockTime,
+			RetryAfter:  retryAfter,
+		})
+		return
+	}
+
+	data, err := s.storage.Get(r.Context(), item.ID+dataExt)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer data.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, item.ID+dataExt, item.Created, data)
+}
+
+// events streams the items as they are released. The since query parameter,
+// an RFC 3339 time, also streams the items released after that time, so a
+// subscriber that reconnects doesn't miss any.
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming isn't supported"))
+		return
+	}
+
+	since := s.clock.Now()
+	if v := r.URL.Query().Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid since: %w", err))
+			return
+		}
+		since = t
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	s.subscribers.Add(1)
+	defer s.subscribers.Add(-1)
+
+	for {
+		now := s.clock.Now()
+		released, next, changed := s.released(since, now)
+
+		for _, item := range released {
+			b, err := json.Marshal(item)
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: released\ndata: %s\n\n", b); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+		since = now
+
+		var timer *time.Timer
+		var wake <-chan time.Time
+		if !next.IsZero() {
+			timer = time.NewTimer(next.Sub(now))
+			wake = timer.C
+		}
+
+		select {
+		case <-r.Context().Done():
+		case <-changed:
+		case <-wake:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		if r.Context().Err() != nil {
+			return
+		}
+	}
+}
+
+// readiness reports whether the storage can be listed and the readiness check
+// passes, giving up after a few seconds so probes don't pile up.
+func (s *Server) readiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if _, err := s.storage.List(ctx, uploadsDir+"/"); err != nil {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("storage: %w", err))
+		return
+	}
+
+	if s.ready != nil {
+		if err := s.ready(ctx); err != nil {
+			writeError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, healthResponse{Status: "ready"})
+}
+
+// released returns the items released after since and up to now, in the order
+// they were released, along with the time the next item is released, if any,
+// and a channel closed when an item is added.
+func (s *Server) released(since time.Time, now time.Time) ([]Item, time.Time, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var items []Item
+	var next time.Time
+	for _, rec := range s.items {
+		item := rec.Item
+		switch {
+		case item.UnlockTime.After(now):
+			if next.IsZero() || item.UnlockTime.Before(next) {
+				next = item.UnlockTime
+			}
+		case item.UnlockTime.After(since):
+			items = append(items, item)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].UnlockTime.Before(items[j].UnlockTime)
+	})
+
+	return items, next, s.changed
+}
+
+// item returns the item with the identifier.
+func (s *Server) item(id string) (Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, exists := s.items[id]
+	if !exists {
+		return Item{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+
+	return rec.Item, nil
+}
+
+// getJSON reads the JSON object stored under the key into v.
+func (s *Server) getJSON(ctx context.Context, key string, v interface{}) error {
+	obj, err := s.storage.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	if err := json.NewDecoder(obj).Decode(v); err != nil {
+		return fmt.Errorf("parse %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// putJSON stores v as a JSON object under the key.
+func (s *Server) putJSON(ctx context.Context, key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return s.storage.Put(ctx, key, bytes.NewReader(b))
+}
+
+// spool creates the temporary file data is received into, and checked in,
+// before it's stored.
+func spool() (*os.File, error) {
+	return os.CreateTemp("", "tlock-relay-*")
+}
+
+// =============================================================================
+
+// systemClock implements the tlock.Clock interface using the system time.
+type systemClock struct{}
+
+// Now returns the current system time.
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// newID returns the random identifier of a new item or upload.
+func newID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// owner returns the digest of the bearer token of the request, which
+// identifies the owner of items and uploads, or an empty string without one.
+func owner(r *http.Request) string {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == r.Header.Get("Authorization") {
+		return ""
+	}
+
+	return digest(token)
+}
+
+// digest returns the hex encoded SHA-256 of a token, which is what the server
+// stores instead of the token.
+func digest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// claimedRound returns the round claimed by the round query parameter of the
+// request, or zero without one.
+func claimedRound(r *http.Request) (uint64, error) {
+	v := r.URL.Query().Get("round")
+	if v == "" {
+		return 0, nil
+	}
+
+	roundNumber, err := strconv.ParseUint(v, 10, 64)
+	if err != nil || roundNumber == 0 {
+		return 0, fmt.Errorf("invalid round %q", v)
+	}
+
+	return roundNumber, nil
+}
+
+// healthResponse is the body of the responses of /healthz and /readyz.
+type healthResponse struct {
+	Status string `json:"status"`
+}
+
+// errorResponse is the body of the responses of failed requests. Requests
+// for data that isn't released yet also tell the round of the item, when it's
+// released and the seconds until then, like the Retry-After header.
+type errorResponse struct {
+	Error       string     `json:"error"`
+	RoundNumber uint64     `json:"round,omitempty"`
+	UnlockTime  *time.Time `json:"unlock_time,omitempty"`
+	RetryAfter  int64      `json:"retry_after,omitempty"`
+}
+
+// writeError writes the error as the JSON body of a response.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// writeJSON writes the value as the JSON body of a response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,793 @@
+package relay_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/internal/fakenet"
+	"github.com/drand/tlock/relay"
+)
+
+// clock is a clock the tests move forward.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newServer(t *testing.T, storage relay.Storage, network *fakenet.Chain, c *clock) *httptest.Server {
+	resolve := func(ctx context.Context, chainHash string) (relay.RoundTimer, error) {
+		if chainHash != network.ChainHash() {
+			return nil, errors.New("chain isn't accepted")
+		}
+		return network, nil
+	}
+
+	s, err := relay.NewServer(storage, resolve, relay.WithClock(c), relay.WithMaxSize(1<<20))
+	if err != nil {
+		t.Fatalf("server error %s", err)
+	}
+
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func upload(t *testing.T, url string, data []byte) relay.Item {
+	resp, err := http.Post(url+"/items", "application/octet-stream", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("upload error %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		b, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expecting status 201; got %d: %s", resp.StatusCode, b)
+	}
+
+	var item relay.Item
+	if err := json.NewDecoder(resp.Body).Decode(&item); err != nil {
+		t.Fatalf("decode error %s", err)
+	}
+	return item
+}
+
+func Test_Relay(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	c := clock{now: time.Now()}
+	storage := relay.Dir(t.TempDir())
+	srv := newServer(t, storage, network, &c)
+
+	roundNumber := network.RoundNumber(c.Now()) + 10
+
+	var encrypted bytes.Buffer
+	if err := tlock.New(network).Encrypt(&encrypted, strings.NewReader("embargoed"), roundNumber); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	item := upload(t, srv.URL, encrypted.Bytes())
+	if item.RoundNumber != roundNumber || item.ChainHash != network.ChainHash() || item.Size != int64(encrypted.Len()) {
+		t.Fatalf("unexpected item %+v", item)
+	}
+
+	resp, err := http.Get(srv.URL + "/items/" + item.ID + "/data")
+	if err != nil {
+		t.Fatalf("download error %s", err)
+	}
+	var early struct {
+		RoundNumber uint64    `json:"round"`
+		UnlockTime  time.Time `json:"unlock_time"`
+		RetryAfter  int64     `json:"retry_after"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&early)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooEarly || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("expecting 425 with Retry-After; got %d", resp.StatusCode)
+	}
+	if err != nil {
+		t.Fatalf("decode error %s", err)
+	}
+	if early.RoundNumber != roundNumber || !early.UnlockTime.Equal(item.UnlockTime) || resp.Header.Get("Retry-After") != fmt.Sprint(early.RetryAfter) {
+		t.Fatalf("expecting the round and unlock time of the item; got %+v", early)
+	}
+
+	c.Add(time.Minute)
+
+	// A restarted server serves the items it stored.
+	srv = newServer(t, storage, network, &c)
+
+	resp, err = http.Get(srv.URL + "/items/" + item.ID + "/data")
+	if err != nil {
+		t.Fatalf("download error %s", err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(b, encrypted.Bytes()) {
+		t.Fatalf("expecting the encrypted data; got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(srv.URL + "/items/unknown")
+	if err != nil {
+		t.Fatalf("describe error %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expecting 404; got %d", resp.StatusCode)
+	}
+
+	for name, data := range map[string][]byte{
+		"garbage":     []byte("not encrypted"),
+		"other chain": encryptedFor(t, fakenet.NewChain(3*time.Second)),
+		"too large":   bytes.Repeat(encrypted.Bytes(), 1<<20/encrypted.Len()+1),
+	} {
+		resp, err := http.Post(srv.URL+"/items", "application/octet-stream", bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: upload error %s", name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%s: expecting 400; got %d", name, resp.StatusCode)
+		}
+	}
+}
+
+func Test_RelayEvents(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	c := clock{now: time.Now()}
+	srv := newServer(t, relay.Memory(), network, &c)
+
+	since := c.Now()
+	item := upload(t, srv.URL, encryptedFor(t, network))
+	c.Add(time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events?since="+since.UTC().Format(time.RFC3339), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("events error %s", err)
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+
+		var released relay.Item
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &released); err != nil {
+			t.Fatalf("decode error %s", err)
+		}
+		if released.ID != item.ID {
+			t.Fatalf("expecting item %s to be released; got %s", item.ID, released.ID)
+		}
+		return
+	}
+
+	t.Fatalf("expecting a released event; got %v", scanner.Err())
+}
+
+func Test_RelayClient(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	c := clock{now: time.Now()}
+	srv := newServer(t, newS3Storage(t), network, &c)
+	ctx := context.Background()
+
+	client := relay.NewClient(srv.URL, relay.WithToken("secret"), relay.WithChunkSize(64))
+	encrypted := encryptedFor(t, network)
+
+	// An interrupted upload resumes where the server stopped.
+	u, err := client.StartUpload(ctx, network.RoundNumber(time.Now())+1)
+	if err != nil {
+		t.Fatalf("start error %s", err)
+	}
+	if _, err := client.ResumeUpload(ctx, u.ID, &failingReader{data: encrypted, fail: 100}); err == nil {
+		t.Fatal("expecting the upload to fail")
+	}
+
+	if _, err := relay.NewClient(srv.URL, relay.WithToken("other")).ResumeUpload(ctx, u.ID, bytes.NewReader(encrypted)); !errors.Is(err, relay.ErrUploadNotFound) {
+		t.Fatalf("expecting error %s; got %v", relay.ErrUploadNotFound, err)
+	}
+
+	item, err := client.ResumeUpload(ctx, u.ID, bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatalf("resume error %s", err)
+	}
+	if item.Size != int64(len(encrypted)) || item.SHA256 != fmt.Sprintf("%x", sha256.Sum256(encrypted)) {
+		t.Fatalf("unexpected item %+v", item)
+	}
+
+	// The round claimed has to be the one of the header.
+	u, err = client.StartUpload(ctx, item.RoundNumber+1)
+	if err != nil {
+		t.Fatalf("start error %s", err)
+	}
+	if _, err := client.ResumeUpload(ctx, u.ID, bytes.NewReader(encrypted)); err == nil || !strings.Contains(err.Error(), "locked to round") {
+		t.Fatalf("expecting the claimed round to be rejected; got %v", err)
+	}
+
+	pushed, err := client.Push(ctx, bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatalf("push error %s", err)
+	}
+
+	err = client.Pull(ctx, pushed.ID, io.Discard)
+	if !errors.Is(err, tlock.ErrTooEarly) {
+		t.Fatalf("expecting error %s; got %v", tlock.ErrTooEarly, err)
+	}
+	var early *relay.TooEarlyError
+	if !errors.As(err, &early) || early.RoundNumber != pushed.RoundNumber || !early.UnlockTime.Equal(pushed.UnlockTime) {
+		t.Fatalf("expecting the round and unlock time of the item; got %v", err)
+	}
+
+	items, err := client.Items(ctx, true)
+	if err != nil {
+		t.Fatalf("items error %s", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("expecting 2 pending items; got %d", len(items))
+	}
+
+	if _, err := relay.NewClient(srv.URL).Items(ctx, false); err == nil {
+		t.Fatal("expecting listing items without a token to fail")
+	}
+
+	c.Add(time.Minute)
+
+	if items, err = client.Items(ctx, true); err != nil || len(items) != 0 {
+		t.Fatalf("expecting no pending items; got %d, %v", len(items), err)
+	}
+
+	var pulled bytes.Buffer
+	if err := client.Pull(ctx, pushed.ID, &pulled); err != nil {
+		t.Fatalf("pull error %s", err)
+	}
+	if !bytes.Equal(pulled.Bytes(), encrypted) {
+		t.Fatal("expecting the pushed data")
+	}
+
+	if _, err := client.Item(ctx, "unknown"); !errors.Is(err, relay.ErrNotFound) {
+		t.Fatalf("expecting error %s; got %v", relay.ErrNotFound, err)
+	}
+}
+
+func Test_RelayKeys(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	c := clock{now: time.Now()}
+	resolve := func(ctx context.Context, chainHash string) (relay.RoundTimer, error) {
+		return network, nil
+	}
+
+	encrypted := encryptedFor(t, network)
+	keys := []relay.Key{
+		{Name: "limited", Token: "limited", RateLimit: 2},
+		{Name: "small", Token: "small", MaxSize: int64(len(encrypted)) - 1},
+		{Name: "quota", Token: "quota", Quota: int64(len(encrypted)) + 1},
+	}
+
+	s, err := relay.NewServer(relay.Memory(), resolve, relay.WithClock(&c), relay.WithKeys(keys...))
+	if err != nil {
+		t.Fatalf("server error %s", err)
+	}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	ctx := context.Background()
+
+	for _, token := range []string{"", "unknown"} {
+		if _, err := relay.NewClient(srv.URL, relay.WithToken(token)).Push(ctx, bytes.NewReader(encrypted)); err == nil {
+			t.Fatalf("expecting an upload with token %q to fail", token)
+		}
+	}
+
+	// Items are served to anyone.
+	item, err := relay.NewClient(srv.URL, relay.WithToken("quota")).Push(ctx, bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatalf("push error %s", err)
+	}
+	if _, err := relay.NewClient(srv.URL).Item(ctx, item.ID); err != nil {
+		t.Fatalf("describe error %s", err)
+	}
+
+	if _, err := relay.NewClient(srv.URL, relay.WithToken("quota")).Push(ctx, bytes.NewReader(encrypted)); err == nil || !strings.Contains(err.Error(), "quota") {
+		t.Fatalf("expecting the quota to be exceeded; got %v", err)
+	}
+
+	if _, err := relay.NewClient(srv.URL, relay.WithToken("small")).Push(ctx, bytes.NewReader(encrypted)); err == nil {
+		t.Fatal("expecting the item to be too large")
+	}
+
+	limited := relay.NewClient(srv.URL, relay.WithToken("limited"))
+	for i := 0; i < 2; i++ {
+		if _, err := limited.Items(ctx, false); err != nil {
+			t.Fatalf("request %d: items error %s", i, err)
+		}
+	}
+	if _, err := limited.Items(ctx, false); err == nil || !strings.Contains(err.Error(), "requests per minute") {
+		t.Fatalf("expecting the rate limit to be exceeded; got %v", err)
+	}
+
+	c.Add(30 * time.Second)
+	if _, err := limited.Items(ctx, false); err != nil {
+		t.Fatalf("expecting the rate limit to be refilled; got %v", err)
+	}
+
+	if stats := s.Stats(); stats.Items != 1 || stats.Pending != 0 || stats.Size != item.Size || stats.Throttled != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// Replacing the keys keeps the rate limit of the kept ones.
+	s.SetKeys(keys[0])
+	if _, err := limited.Items(ctx, false); err == nil {
+		t.Fatal("expecting the rate limit to be kept")
+	}
+	if _, err := relay.NewClient(srv.URL, relay.WithToken("quota")).Items(ctx, false); err == nil {
+		t.Fatal("expecting the removed key to be rejected")
+	}
+
+	s.SetKeys()
+	if _, err := relay.NewClient(srv.URL).Push(ctx, bytes.NewReader(encrypted)); err != nil {
+		t.Fatalf("expecting uploads without keys to be accepted; got %v", err)
+	}
+}
+
+func Test_RelayMaxUploads(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	resolve := func(ctx context.Context, chainHash string) (relay.RoundTimer, error) {
+		return network, nil
+	}
+
+	s, err := relay.NewServer(relay.Memory(), resolve, relay.WithMaxUploads(1, 0))
+	if err != nil {
+		t.Fatalf("server error %s", err)
+	}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	encrypted := encryptedFor(t, network)
+
+	// The first upload holds the only slot until its body is complete.
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		resp, err := http.Post(srv.URL+"/items", "application/octet-stream", pr)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusCreated {
+				err = fmt.Errorf("status %d", resp.StatusCode)
+			}
+		}
+		done <- err
+	}()
+	pw.Write(encrypted[:10])
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Post(srv.URL+"/items", "application/octet-stream", bytes.NewReader(encrypted))
+		if err != nil {
+			t.Fatalf("upload error %s", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusTooManyRequests {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expecting 429 while the slot is held; got %d", resp.StatusCode)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	pw.Write(encrypted[10:])
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("first upload error %s", err)
+	}
+
+	upload(t, srv.URL, encrypted)
+
+	if stats := s.Stats(); stats.Busy == 0 {
+		t.Fatalf("expecting busy uploads to be counted; got %+v", stats)
+	}
+}
+
+func Test_RelayOpenAPI(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	srv := newServer(t, relay.Memory(), network, &clock{now: time.Now()})
+
+	resp, err := http.Get(srv.URL + "/openapi.json")
+	if err != nil {
+		t.Fatalf("openapi error %s", err)
+	}
+	defer resp.Body.Close()
+
+	var spec struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&spec); err != nil {
+		t.Fatalf("decode error %s", err)
+	}
+
+	// Every operation of the document is served.
+	for path, ops := range spec.Paths {
+		for method := range ops {
+			req, _ := http.NewRequest(strings.ToUpper(method), srv.URL+strings.ReplaceAll(path, "{id}", "unknown"), nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("%s %s: error %s", method, path, err)
+			}
+			if resp.Header.Get("Content-Type") == "text/event-stream" {
+				resp.Body.Close()
+				continue
+			}
+
+			var e struct {
+				Error string `json:"error"`
+			}
+			json.NewDecoder(resp.Body).Decode(&e)
+			resp.Body.Close()
+			if e.Error == "unknown endpoint" {
+				t.Fatalf("%s %s isn't served", method, path)
+			}
+		}
+	}
+
+	resp, err = http.Get(srv.URL + "/docs")
+	if err != nil {
+		t.Fatalf("docs error %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("expecting the docs page; got %d", resp.StatusCode)
+	}
+}
+
+func Test_RelayHealth(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	resolve := func(ctx context.Context, chainHash string) (relay.RoundTimer, error) {
+		return network, nil
+	}
+
+	var unreachable, broken atomic.Bool
+	storage := failingStorage{Storage: relay.Memory(), broken: &broken}
+	s, err := relay.NewServer(storage, resolve, relay.WithKeys(relay.Key{Token: "secret"}), relay.WithReadiness(func(ctx context.Context) error {
+		if unreachable.Load() {
+			return errors.New("network unreachable")
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("server error %s", err)
+	}
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("get error %s", err)
+		}
+		defer resp.Body.Close()
+
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	tests := []struct {
+		name        string
+		unreachable bool
+		broken      bool
+		path        string
+		status      int
+		body        string
+	}{
+		{name: "healthy", path: "/healthz", status: http.StatusOK, body: `"ok"`},
+		{name: "ready", path: "/readyz", status: http.StatusOK, body: `"ready"`},
+		{name: "unreachable", unreachable: true, path: "/readyz", status: http.StatusServiceUnavailable, body: "network unreachable"},
+		{name: "broken storage", broken: true, path: "/readyz", status: http.StatusServiceUnavailable, body: "storage: disk failure"},
+		{name: "alive while not ready", unreachable: true, broken: true, path: "/healthz", status: http.StatusOK, body: `"ok"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unreachable.Store(tt.unreachable)
+			broken.Store(tt.broken)
+
+			if status, body := get(tt.path); status != tt.status || !strings.Contains(body, tt.body) {
+				t.Fatalf("expecting %d and %s; got %d and %s", tt.status, tt.body, status, body)
+			}
+		})
+	}
+}
+
+// failingStorage fails to list its objects while broken.
+type failingStorage struct {
+	relay.Storage
+	broken *atomic.Bool
+}
+
+func (s failingStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	if s.broken.Load() {
+		return nil, errors.New("disk failure")
+	}
+	return s.Storage.List(ctx, prefix)
+}
+
+func Test_Storage(t *testing.T) {
+	sealed, err := relay.Sealed(relay.Memory(), bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("sealed error %s", err)
+	}
+
+	storages := map[string]relay.Storage{
+		"memory": relay.Memory(),
+		"dir":    relay.Dir(t.TempDir()),
+		"s3":     newS3Storage(t),
+		"sealed": sealed,
+	}
+
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			if _, err := storage.Get(ctx, "a/1"); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("expecting a missing object; got %v", err)
+			}
+
+			objects := map[string]io.Reader{
+				"a/1":   strings.NewReader("one"),
+				"a/2":   io.MultiReader(strings.NewReader("t"), strings.NewReader("wo")),
+				"a/b/3": strings.NewReader("three"),
+				"c":     strings.NewReader("four"),
+			}
+			for key, r := range objects {
+				if err := storage.Put(ctx, key, r); err != nil {
+					t.Fatalf("put %s error %s", key, err)
+				}
+			}
+			if err := storage.Put(ctx, "a/1", strings.NewReader("uno")); err != nil {
+				t.Fatalf("put error %s", err)
+			}
+
+			lists := map[string]string{"a/": "a/1 a/2", "": "c", "a/1": "a/1", "b/": ""}
+			for prefix, expected := range lists {
+				keys, err := storage.List(ctx, prefix)
+				if err != nil {
+					t.Fatalf("list %q error %s", prefix, err)
+				}
+				if strings.Join(keys, " ") != expected {
+					t.Fatalf("list %q: expecting %q; got %q", prefix, expected, keys)
+				}
+			}
+
+			obj, err := storage.Get(ctx, "a/1")
+			if err != nil {
+				t.Fatalf("get error %s", err)
+			}
+			defer obj.Close()
+
+			if b, err := io.ReadAll(obj); err != nil || string(b) != "uno" {
+				t.Fatalf("expecting the replaced object; got %q, %v", b, err)
+			}
+			if size, err := obj.Seek(0, io.SeekEnd); err != nil || size != 3 {
+				t.Fatalf("expecting a size of 3; got %d, %v", size, err)
+			}
+			obj.Seek(1, io.SeekStart)
+			if b, err := io.ReadAll(obj); err != nil || string(b) != "no" {
+				t.Fatalf("expecting the object from offset 1; got %q, %v", b, err)
+			}
+
+			for i := 0; i < 2; i++ {
+				if err := storage.Delete(ctx, "a/2"); err != nil {
+					t.Fatalf("delete error %s", err)
+				}
+			}
+			if _, err := storage.Get(ctx, "a/2"); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("expecting a deleted object; got %v", err)
+			}
+		})
+	}
+}
+
+func Test_SealedStorage(t *testing.T) {
+	ctx := context.Background()
+	inner := relay.Memory()
+	key := bytes.Repeat([]byte{1}, 32)
+
+	if _, err := relay.Sealed(inner, key[:16]); err == nil {
+		t.Fatal("expecting an error for a short key")
+	}
+
+	storage, err := relay.Sealed(inner, key)
+	if err != nil {
+		t.Fatalf("sealed error %s", err)
+	}
+
+	if err := storage.Put(ctx, "outbox/1", strings.NewReader("the plain text")); err != nil {
+		t.Fatalf("put error %s", err)
+	}
+	if err := storage.Put(ctx, "outbox/2", strings.NewReader("another email")); err != nil {
+		t.Fatalf("put error %s", err)
+	}
+
+	obj, err := inner.Get(ctx, "outbox/1")
+	if err != nil {
+		t.Fatalf("get error %s", err)
+	}
+	raw, _ := io.ReadAll(obj)
+	if bytes.Contains(raw, []byte("plain text")) {
+		t.Fatal("expecting the stored object to be encrypted")
+	}
+
+	// Objects can't be read with another key, nor moved to another key.
+	other, err := relay.Sealed(inner, bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatalf("sealed error %s", err)
+	}
+	if _, err := other.Get(ctx, "outbox/1"); !errors.Is(err, relay.ErrSealedObject) {
+		t.Fatalf("expecting error %v; got %v", relay.ErrSealedObject, err)
+	}
+
+	if err := inner.Put(ctx, "outbox/2", bytes.NewReader(raw)); err != nil {
+		t.Fatalf("put error %s", err)
+	}
+	if _, err := storage.Get(ctx, "outbox/2"); !errors.Is(err, relay.ErrSealedObject) {
+		t.Fatalf("expecting error %v; got %v", relay.ErrSealedObject, err)
+	}
+
+	raw[len(raw)-1] ^= 1
+	if err := inner.Put(ctx, "outbox/1", bytes.NewReader(raw)); err != nil {
+		t.Fatalf("put error %s", err)
+	}
+	if _, err := storage.Get(ctx, "outbox/1"); !errors.Is(err, relay.ErrSealedObject) {
+		t.Fatalf("expecting error %v; got %v", relay.ErrSealedObject, err)
+	}
+}
+
+// newS3Storage returns a storage in the bucket of a fake S3 service.
+func newS3Storage(t *testing.T) relay.Storage {
+	srv := httptest.NewServer(&fakeS3{t: t, objects: make(map[string][]byte)})
+	t.Cleanup(srv.Close)
+
+	storage, err := relay.NewS3(relay.S3Config{
+		Endpoint:        srv.URL,
+		Bucket:          "bucket",
+		Prefix:          "relay/",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+	})
+	if err != nil {
+		t.Fatalf("s3 error %s", err)
+	}
+
+	return storage
+}
+
+// fakeS3 serves the objects of a bucket with the part of the S3 API used by
+// the storage, listing them two at a time.
+type fakeS3 struct {
+	t       *testing.T
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+		f.t.Errorf("unsigned request %s %s", r.Method, r.URL)
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/bucket":
+		f.list(w, r)
+
+	case r.Method == http.MethodPut:
+		b, _ := io.ReadAll(r.Body)
+		if sum := sha256.Sum256(b); r.Header.Get("X-Amz-Content-Sha256") != fmt.Sprintf("%x", sum) {
+			f.t.Errorf("put %s with the wrong digest", key)
+		}
+		f.objects[key] = b
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		b, exists := f.objects[key]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code></Error>")
+			return
+		}
+		http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(b))
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	after := query.Get("continuation-token")
+
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) && !strings.Contains(key[len(prefix):], "/") && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	fmt.Fprint(w, "<ListBucketResult>")
+	for i, key := range keys {
+		if i == 2 {
+			fmt.Fprintf(w, "<IsTruncated>true</IsTruncated><NextContinuationToken>%s</NextContinuationToken>", keys[i-1])
+			break
+		}
+		fmt.Fprintf(w, "<Contents><Key>%s</Key></Contents>", key)
+	}
+	fmt.Fprint(w, "</ListBucketResult>")
+}
+
+// failingReader reads the data until the offset fail, where it fails.
+type failingReader struct {
+	data   []byte
+	fail   int
+	offset int
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.offset >= r.fail {
+		return 0, errors.New("interrupted")
+	}
+	if len(p) > r.fail-r.offset {
+		p = p[:r.fail-r.offset]
+	}
+	n := copy(p, r.data[r.offset:])
+	r.offset += n
+	return n, nil
+}
+
+func (r *failingReader) Seek(offset int64, whence int) (int64, error) {
+	r.offset = int(offset)
+	return offset, nil
+}
+
+func encryptedFor(t *testing.T, network *fakenet.Chain) []byte {
+	var b bytes.Buffer
+	if err := tlock.New(network).Encrypt(&b, strings.NewReader("data"), network.RoundNumber(time.Now())+1); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	return b.Bytes()
+}
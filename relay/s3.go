@@ -0,0 +1,454 @@
+package relay
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drand/tlock"
+)
+
+// S3Config configures a storage constructed with NewS3.
+type S3Config struct {
+	// Endpoint is the URL of the S3 service, addressing buckets by path. The
+	// default is the AWS endpoint of the region. Without a bucket, the
+	// endpoint addresses the bucket itself.
+	Endpoint string
+
+	// Region is the region of the bucket, which requests are signed for. The
+	// default is us-east-1.
+	Region string
+
+	// Bucket is the name of the bucket.
+	Bucket string
+
+	// Prefix is prepended to every key, so several relays can share a bucket.
+	Prefix string
+
+	// AccessKeyID, SecretAccessKey and SessionToken are the credentials
+	// signing the requests. The session token is only needed for temporary
+	// credentials.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Client sends the requests. The default is http.DefaultClient.
+	Client *http.Client
+
+	// Clock dates the requests. The default is the system clock.
+	Clock tlock.Clock
+}
+
+// s3Storage implements the Storage interface with the objects of an S3
+// bucket.
+type s3Storage struct {
+	cfg      S3Config
+	endpoint *url.URL
+}
+
+// NewS3 returns a storage keeping every object in an S3 bucket, or in any
+// service implementing its API.
+func NewS3(cfg S3Config) (Storage, error) {
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		if cfg.Bucket == "" {
+			return nil, errors.New("s3 storage needs a bucket or an endpoint")
+		}
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = systemClock{}
+	}
+
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parse endpoint: %w", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("invalid endpoint %q", cfg.Endpoint)
+	}
+
+	return &s3Storage{cfg: cfg, endpoint: endpoint}, nil
+}
+
+// Put implements the Storage interface. S3 needs the size and the digest of
+// an object before it's sent, so data that can't be read twice is written to
+// a temporary file first.
+func (s *s3Storage) Put(ctx context.Context, key string, r io.Reader) error {
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		f, err := os.CreateTemp("", "tlock-s3-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		if _, err := io.Copy(f, r); err != nil {
+			return err
+		}
+		rs = f
+	}
+
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	size, err := io.Copy(h, rs)
+	if err != nil {
+		return err
+	}
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, key, nil, nil, io.NopCloser(rs), size, hex.EncodeToString(h.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// Get implements the Storage interface. The size of the object is requested
+// first, and its data is only requested once read, from the offset it's read
+// at.
+func (s *s3Storage) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, nil, 0, emptySHA256)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	return &s3Object{ctx: ctx, s: s, key: key, size: resp.ContentLength}, nil
+}
+
+// Delete implements the Storage interface.
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil, 0, emptySHA256)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// List implements the Storage interface, following the continuation tokens
+// of the truncated listings.
+func (s *s3Storage) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+
+	query := url.Values{
+		"list-type": {"2"},
+		"prefix":    {s.cfg.Prefix + prefix},
+		"delimiter": {"/"},
+	}
+
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil, 0, emptySHA256)
+		if err != nil {
+			return nil, err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parse listing: %w", err)
+		}
+
+		for _, c := range result.Contents {
+			keys = append(keys, strings.TrimPrefix(c.Key, s.cfg.Prefix))
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// do sends a signed request for the object with the key, or for the bucket
+// with an empty key. A response with an error status is returned as an error,
+// wrapping fs.ErrNotExist for a missing object.
+func (s *s3Storage) do(ctx context.Context, method string, key string, query url.Values, header http.Header, body io.ReadCloser, size int64, payloadHash string) (*http.Response, error) {
+	segments := []string{strings.TrimSuffix(s.endpoint.Path, "/")}
+	if s.cfg.Bucket != "" {
+		segments = append(segments, s.cfg.Bucket)
+	}
+	if key != "" {
+		segments = append(segments, s.cfg.Prefix+key)
+	}
+
+	u := *s.endpoint
+	u.Path = strings.Join(segments, "/")
+	u.RawPath = strings.Join(escapeSegments(segments), "/")
+	if u.Path == "" {
+		u.Path, u.RawPath = "/", "/"
+	}
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	s.sign(req, payloadHash, s.cfg.Clock.Now())
+
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var e s3Error
+	xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&e)
+	if e.Code == "" {
+		e.Code = resp.Status
+	}
+
+	msg := fmt.Sprintf("s3 %s %s: %s", method, u.Path, e.Code)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if resp.StatusCode == http.StatusNotFound && key != "" {
+		return nil, fmt.Errorf("%s: %w", msg, fs.ErrNotExist)
+	}
+
+	return nil, errors.New(msg)
+}
+
+// emptySHA256 is the hex encoded SHA-256 of an empty payload.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign signs the request with AWS Signature Version 4 at the time, adding
+// the headers the signature covers.
+func (s *s3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + s.cfg.Region + "/s3/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.cfg.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	// The signature covers the host, the range and every S3 header.
+	headers := map[string]string{"host": host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if k == "range" || k == "content-type" || strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	digest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+
+	key := []byte("AWS4" + s.cfg.SecretAccessKey)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.cfg.AccessKeyID+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the data with the key.
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery returns the query as signed by AWS Signature Version 4,
+// sorted by name and value.
+func canonicalQuery(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for k, vs := range query {
+		for _, v := range vs {
+			pairs = append(pairs, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, "&")
+}
+
+// escapeSegments escapes every segment of a path.
+func escapeSegments(segments []string) []string {
+	escaped := make([]string, len(segments))
+	for i, segment := range segments {
+		parts := strings.Split(segment, "/")
+		for j, part := range parts {
+			parts[j] = awsEscape(part)
+		}
+		escaped[i] = strings.Join(parts, "/")
+	}
+
+	return escaped
+}
+
+// awsEscape escapes every byte but the unreserved characters of RFC 3986, as
+// AWS does.
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+
+	return b.String()
+}
+
+// listBucketResult is the part of a ListObjectsV2 response the storage reads.
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// s3Error is the body of the S3 responses of failed requests.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// =============================================================================
+
+// s3Object reads an S3 object, requesting its data from the offset it's read
+// at whenever it's sought.
+type s3Object struct {
+	ctx    context.Context
+	s      *s3Storage
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+// Read implements the io.Reader interface.
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.body == nil {
+		header := http.Header{"Range": {"bytes=" + strconv.FormatInt(o.offset, 10) + "-"}}
+		resp, err := o.s.do(o.ctx, http.MethodGet, o.key, nil, header, nil, 0, emptySHA256)
+		if err != nil {
+			return 0, err
+		}
+		o.body = resp.Body
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	if errors.Is(err, io.EOF) && o.offset < o.size {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+// Seek implements the io.Seeker interface.
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the object")
+	}
+
+	if offset != o.offset {
+		o.Close()
+		o.offset = offset
+	}
+
+	return offset, nil
+}
+
+// Close implements the io.Closer interface.
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+
+	err := o.body.Close()
+	o.body = nil
+
+	return err
+}
@@ -0,0 +1,46 @@
+package relay
+
+// Stats is a snapshot of the state of a server, for monitoring.
+type Stats struct {
+	// Items is the number of items stored, Pending the number of those that
+	// aren't released yet and Size their total size in bytes.
+	Items   int
+	Pending int
+	Size    int64
+
+	// Subscribers is the number of open event streams.
+	Subscribers int
+
+	// ResolveErrors counts the uploads rejected because their chain couldn't
+	// be resolved, such as when the network is unreachable, Throttled the
+	// requests rejected by the rate limit of their key and Busy the uploads
+	// rejected because too many were in progress.
+	ResolveErrors uint64
+	Throttled     uint64
+	Busy          uint64
+}
+
+// Stats returns the state of the server.
+func (s *Server) Stats() Stats {
+	now := s.clock.Now()
+
+	st := Stats{
+		Subscribers:   int(s.subscribers.Load()),
+		ResolveErrors: s.resolveErrors.Load(),
+		Throttled:     s.throttled.Load(),
+		Busy:          s.busy.Load(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, rec := range s.items {
+		st.Items++
+		st.Size += rec.Size
+		if rec.UnlockTime.After(now) {
+			st.Pending++
+		}
+	}
+
+	return st
+}
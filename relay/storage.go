@@ -0,0 +1,283 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Storage stores the objects of a relay: the encrypted data and the
+// description of its items, and the parts of its resumable uploads. Keys are
+// slash separated paths. Implementations have to be safe for concurrent use.
+type Storage interface {
+	// Put stores the object read from r under the key, replacing the object
+	// stored there if any. An object is never seen partially written.
+	Put(ctx context.Context, key string, r io.Reader) error
+
+	// Get returns the object stored under the key. It fails with an error
+	// wrapping fs.ErrNotExist if there is none.
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, error)
+
+	// Delete removes the object stored under the key, if any.
+	Delete(ctx context.Context, key string) error
+
+	// List returns the keys starting with the prefix that have no slash after
+	// it, in increasing order.
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// =============================================================================
+
+// dirStorage implements the Storage interface with the files of a directory.
+type dirStorage struct {
+	root string
+}
+
+// Dir returns a storage keeping every object in a file of the directory,
+// under the path of its key.
+func Dir(root string) Storage {
+	return dirStorage{root: root}
+}
+
+// tmpPrefix starts the name of the files being written.
+const tmpPrefix = ".tmp-"
+
+// Put implements the Storage interface. The object is written to a temporary
+// file renamed once synced.
+func (d dirStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	name := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(name), tmpPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), name)
+}
+
+// Get implements the Storage interface.
+func (d dirStorage) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	return os.Open(d.path(key))
+}
+
+// Delete implements the Storage interface.
+func (d dirStorage) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(d.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List implements the Storage interface.
+func (d dirStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	dir, base := path.Split(prefix)
+
+	entries, err := os.ReadDir(d.path(dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasPrefix(e.Name(), base) && !strings.HasPrefix(e.Name(), tmpPrefix) {
+			keys = append(keys, dir+e.Name())
+		}
+	}
+
+	return keys, nil
+}
+
+// path returns the path of the file of the key.
+func (d dirStorage) path(key string) string {
+	return filepath.Join(d.root, filepath.FromSlash(key))
+}
+
+// =============================================================================
+
+// memoryStorage implements the Storage interface in memory.
+type memoryStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+// Memory returns a storage keeping every object in memory, which is lost
+// when the process exits.
+func Memory() Storage {
+	return &memoryStorage{objects: make(map[string][]byte)}
+}
+
+// Put implements the Storage interface.
+func (m *memoryStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.objects[key] = b
+	return nil
+}
+
+// Get implements the Storage interface.
+func (m *memoryStorage) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, exists := m.objects[key]
+	if !exists {
+		return nil, fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	}
+
+	// Objects are replaced and never modified, so they can be shared.
+	return nopCloser{bytes.NewReader(b)}, nil
+}
+
+// Delete implements the Storage interface.
+func (m *memoryStorage) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.objects, key)
+	return nil
+}
+
+// List implements the Storage interface.
+func (m *memoryStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []string
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) && !strings.Contains(key[len(prefix):], "/") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// nopCloser adds a Close method doing nothing to a reader.
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+// Close implements the io.Closer interface.
+func (nopCloser) Close() error {
+	return nil
+}
+
+// =============================================================================
+
+// ErrSealedObject represents an error when an object of a sealed storage
+// can't be opened, because it was modified, moved to another key, or sealed
+// with another key.
+var ErrSealedObject = errors.New("sealed object can't be opened")
+
+// sealedStorage implements the Storage interface by encrypting the objects of
+// another storage.
+type sealedStorage struct {
+	storage Storage
+	aead    cipher.AEAD
+}
+
+// Sealed returns a storage encrypting every object with the key before
+// storing it in the storage, so the state of a service, such as the emails
+// waiting to be sent by a mail gateway, isn't readable by whoever can read
+// the storage. The key has to be chacha20poly1305.KeySize bytes. The objects
+// are authenticated along with their key, so they can't be modified or
+// swapped. Objects are held in memory while they are encrypted or
+// decrypted, so the storage suits small objects rather than relay items.
+func Sealed(storage Storage, key []byte) (Storage, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, fmt.Errorf("sealed storage: %w", err)
+	}
+
+	return sealedStorage{storage: storage, aead: aead}, nil
+}
+
+// Put implements the Storage interface. The object is stored as a random
+// nonce followed by the encrypted object.
+func (s sealedStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+len(plain)+s.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	return s.storage.Put(ctx, key, bytes.NewReader(s.aead.Seal(nonce, nonce, plain, []byte(key))))
+}
+
+// Get implements the Storage interface. It fails with ErrSealedObject if the
+// object can't be decrypted.
+func (s sealedStorage) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	obj, err := s.storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	sealed, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < s.aead.NonceSize() {
+		return nil, fmt.Errorf("%s: %w", key, ErrSealedObject)
+	}
+
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	plain, err := s.aead.Open(ciphertext[:0], nonce, ciphertext, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", key, ErrSealedObject)
+	}
+
+	return nopCloser{bytes.NewReader(plain)}, nil
+}
+
+// Delete implements the Storage interface.
+func (s sealedStorage) Delete(ctx context.Context, key string) error {
+	return s.storage.Delete(ctx, key)
+}
+
+// List implements the Storage interface. Keys aren't encrypted.
+func (s sealedStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	return s.storage.List(ctx, prefix)
+}
@@ -0,0 +1,302 @@
+package relay
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
+)
+
+// uploadsDir is the prefix of the keys, and the endpoint, of the resumable
+// uploads. The data of an upload is stored in parts, one per request appending
+// to it, under the key of the upload followed by the offset of the part.
+const uploadsDir = "uploads"
+
+// ErrUploadNotFound represents an error when a resumable upload doesn't exist.
+var ErrUploadNotFound = errors.New("upload not found")
+
+// Upload describes a resumable upload, whose data is appended in as many
+// requests as needed until it is completed into an item.
+type Upload struct {
+	ID     string `json:"id"`
+	Offset int64  `json:"offset"`
+}
+
+// uploadRecord is a resumable upload as stored by a server, with the size of
+// the parts stored so far.
+type uploadRecord struct {
+	ID          string    `json:"id"`
+	RoundNumber uint64    `json:"round,omitempty"`
+	Owner       string    `json:"owner,omitempty"`
+	Created     time.Time `json:"created"`
+	Offset      int64     `json:"offset"`
+}
+
+// startUpload starts a resumable upload, recording the claimed round and the
+// owner to check them once it is completed.
+func (s *Server) startUpload(w http.ResponseWriter, r *http.Request) {
+	claim, err := claimedRound(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	id, err := newID()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	rec := uploadRecord{
+		ID:          id,
+		RoundNumber: claim,
+		Owner:       owner(r),
+		Created:     s.clock.Now().UTC(),
+	}
+
+	if err := s.putJSON(r.Context(), uploadKey(id), rec); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, Upload{ID: id})
+}
+
+// uploadStatus returns the offset the upload has to be resumed at.
+func (s *Server) uploadStatus(w http.ResponseWriter, r *http.Request, id string) {
+	rec, err := s.resumable(r, id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Upload{ID: id, Offset: rec.Offset})
+}
+
+// appendUpload appends the request body to the upload, provided the
+// Upload-Offset header matches the size of what was uploaded so far. A body
+// cut short is kept, so the upload resumes from wherever it stopped.
+func (s *Server) appendUpload(w http.ResponseWriter, r *http.Request, id string) {
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("invalid Upload-Offset header"))
+		return
+	}
+
+	if !s.acquire(id) {
+		writeError(w, http.StatusConflict, errors.New("upload is already in progress"))
+		return
+	}
+	defer s.release(id)
+
+	if !s.admit(w, r) {
+		return
+	}
+	defer s.uploads.release()
+
+	rec, err := s.resumable(r, id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	if offset != rec.Offset {
+		writeError(w, http.StatusConflict, fmt.Errorf("upload is at offset %d, not %d", rec.Offset, offset))
+		return
+	}
+
+	tmp, err := spool()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	n, readErr := io.Copy(tmp, http.MaxBytesReader(w, r.Body, s.maxSizeOf(rec.Owner)-rec.Offset))
+
+	if n > 0 {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if err := s.storage.Put(r.Context(), partKey(id, rec.Offset), tmp); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		// A part stored without its record updated is replaced by the next
+		// one, which is appended at the same offset.
+		rec.Offset += n
+		if err := s.putJSON(r.Context(), uploadKey(id), rec); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	if readErr != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("read upload: %w", readErr))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Upload{ID: id, Offset: rec.Offset})
+}
+
+// completeUpload checks the uploaded data and stores it as a new item. An
+// upload whose data isn't accepted is discarded.
+func (s *Server) completeUpload(w http.ResponseWriter, r *http.Request, id string) {
+	if !s.acquire(id) {
+		writeError(w, http.StatusConflict, errors.New("upload is already in progress"))
+		return
+	}
+	defer s.release(id)
+
+	if !s.admit(w, r) {
+		return
+	}
+	defer s.uploads.release()
+
+	rec, err := s.resumable(r, id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	tmp, err := spool()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := s.assemble(r.Context(), tmp, rec); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer s.discard(r.Context(), id)
+
+	item, status, err := s.store(r.Context(), tmp, rec.RoundNumber, rec.Owner)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, item)
+}
+
+// resumable returns the upload with the id, if the request comes from its
+// owner.
+func (s *Server) resumable(r *http.Request, id string) (uploadRecord, error) {
+	var rec uploadRecord
+
+	if b, err := hex.DecodeString(id); err != nil || len(b) != 16 {
+		return uploadRecord{}, fmt.Errorf("%w: %s", ErrUploadNotFound, id)
+	}
+
+	err := s.getJSON(r.Context(), uploadKey(id), &rec)
+	if errors.Is(err, fs.ErrNotExist) {
+		return uploadRecord{}, fmt.Errorf("%w: %s", ErrUploadNotFound, id)
+	}
+	if err != nil {
+		return uploadRecord{}, fmt.Errorf("read upload %s: %w", id, err)
+	}
+
+	if rec.Owner != owner(r) {
+		return uploadRecord{}, fmt.Errorf("%w: %s", ErrUploadNotFound, id)
+	}
+
+	return rec, nil
+}
+
+// acquire marks the upload as being written to, reporting false if it
+// already is.
+func (s *Server) acquire(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending[id] {
+		return false
+	}
+	s.pending[id] = true
+
+	return true
+}
+
+// release marks the upload as no longer being written to.
+func (s *Server) release(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.pending, id)
+}
+
+// assemble writes the parts of the upload to the file, in order, checking
+// that none is missing.
+func (s *Server) assemble(ctx context.Context, f *os.File, rec uploadRecord) error {
+	keys, err := s.storage.List(ctx, partsPrefix(rec.ID))
+	if err != nil {
+		return fmt.Errorf("list parts: %w", err)
+	}
+
+	var size int64
+	for _, key := range keys {
+		offset, err := strconv.ParseInt(path.Base(key), 10, 64)
+		if err != nil || offset >= rec.Offset {
+			continue
+		}
+		if offset != size {
+			return fmt.Errorf("upload %s is missing data at offset %d", rec.ID, size)
+		}
+
+		part, err := s.storage.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(f, part)
+		part.Close()
+		if err != nil {
+			return fmt.Errorf("read part: %w", err)
+		}
+		size += n
+	}
+
+	if size != rec.Offset {
+		return fmt.Errorf("upload %s is missing data at offset %d", rec.ID, size)
+	}
+
+	return nil
+}
+
+// discard deletes the upload and its parts.
+func (s *Server) discard(ctx context.Context, id string) {
+	s.storage.Delete(ctx, uploadKey(id))
+
+	keys, _ := s.storage.List(ctx, partsPrefix(id))
+	for _, key := range keys {
+		s.storage.Delete(ctx, key)
+	}
+}
+
+// uploadKey returns the key of the record of the upload.
+func uploadKey(id string) string {
+	return uploadsDir + "/" + id + itemExt
+}
+
+// partsPrefix returns the prefix of the keys of the parts of the upload.
+func partsPrefix(id string) string {
+	return uploadsDir + "/" + id + "/"
+}
+
+// partKey returns the key of the part of the upload at the offset, which is
+// padded so the keys sort in the order of the parts.
+func partKey(id string, offset int64) string {
+	return partsPrefix(id) + fmt.Sprintf("%020d", offset)
+}
@@ -0,0 +1,276 @@
+// Package rpc implements the Tlock gRPC service defined in tlock.proto, which
+// encrypts and decrypts data streamed in chunks. Services written in other
+// languages can generate a client from tlock.proto and time lock payloads of
+// any size, since neither side holds more than a chunk at once.
+package rpc
+
+//go:generate protoc -I .. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative ../rpc/tlock.proto
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/armor"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultChunkSize is the default size of the chunks streamed back.
+const DefaultChunkSize = 64 << 10
+
+// errHeader represents an error when the header of an operation is missing
+// or repeated.
+var errHeader = errors.New("the header has to be in the first request, and only there")
+
+// Resolver returns the network of the chain with the hash, or an error if the
+// server doesn't accept that chain.
+type Resolver func(ctx context.Context, chainHash string) (tlock.Network, error)
+
+// =============================================================================
+
+// Option configures a server constructed with NewServer.
+type Option func(s *Server)
+
+// WithTlockOptions sets the options of the tlock encrypting and decrypting
+// the data, such as a signature cache.
+func WithTlockOptions(opts ...tlock.Option) Option {
+	return func(s *Server) {
+		s.opts = opts
+	}
+}
+
+// WithChunkSize sets the size of the chunks streamed back. The default is
+// DefaultChunkSize.
+func WithChunkSize(size int) Option {
+	return func(s *Server) {
+		s.chunkSize = size
+	}
+}
+
+// Server implements the Tlock service.
+type Server struct {
+	UnimplementedTlockServer
+
+	resolve   Resolver
+	opts      []tlock.Option
+	chunkSize int
+}
+
+// NewServer constructs a server encrypting and decrypting with the networks
+// returned by the resolver.
+func NewServer(resolve Resolver, opts ...Option) *Server {
+	s := Server{
+		resolve:   resolve,
+		chunkSize: DefaultChunkSize,
+	}
+
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	if s.chunkSize <= 0 {
+		s.chunkSize = DefaultChunkSize
+	}
+
+	return &s
+}
+
+// EncryptStream implements the EncryptStream method of the Tlock service.
+func (s *Server) EncryptStream(stream Tlock_EncryptStreamServer) error {
+	ctx := stream.Context()
+
+	req, err := stream.Recv()
+	if err != nil {
+		return statusError(err)
+	}
+	header := req.GetHeader()
+	if header == nil {
+		return status.Error(codes.InvalidArgument, errHeader.Error())
+	}
+
+	network, err := s.resolve(ctx, header.GetChainHash())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "chain %s: %v", header.GetChainHash(), err)
+	}
+
+	src := requestReader{next: func() ([]byte, error) {
+		req, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if req.GetHeader() != nil {
+			return nil, errHeader
+		}
+		return req.GetData(), nil
+	}}
+
+	dst := chunkWriter{buf: make([]byte, 0, s.chunkSize), send: func(data []byte) error {
+		return stream.Send(&Chunk{Data: data})
+	}}
+
+	var w io.Writer = &dst
+	var a *armor.Writer
+	if header.GetArmor() {
+		a = armor.NewWriter(&dst)
+		w = a
+	}
+
+	if err := tlock.New(network, s.opts...).EncryptContext(ctx, w, &src, header.GetRound()); err != nil {
+		return statusError(err)
+	}
+
+	if a != nil {
+		if err := a.Close(); err != nil {
+			return statusError(err)
+		}
+	}
+
+	return statusError(dst.flush())
+}
+
+// DecryptStream implements the DecryptStream method of the Tlock service.
+func (s *Server) DecryptStream(stream Tlock_DecryptStreamServer) error {
+	ctx := stream.Context()
+
+	req, err := stream.Recv()
+	if err != nil {
+		return statusError(err)
+	}
+	header := req.GetHeader()
+	if header == nil {
+		return status.Error(codes.InvalidArgument, errHeader.Error())
+	}
+
+	var src io.Reader = &requestReader{next: func() ([]byte, error) {
+		req, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if req.GetHeader() != nil {
+			return nil, errHeader
+		}
+		return req.GetData(), nil
+	}}
+
+	// Without a chain, the chain is read from the header of the data, which
+	// is then read again to decrypt it.
+	chainHash := header.GetChainHash()
+	if chainHash == "" {
+		var read bytes.Buffer
+		h, err := tlock.ReadHeader(io.TeeReader(src, &read))
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		chainHash = h.ChainHash
+		src = io.MultiReader(&read, src)
+	}
+
+	network, err := s.resolve(ctx, chainHash)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "chain %s: %v", chainHash, err)
+	}
+
+	dst := chunkWriter{buf: make([]byte, 0, s.chunkSize), send: func(data []byte) error {
+		return stream.Send(&Chunk{Data: data})
+	}}
+
+	if err := tlock.New(network, s.opts...).DecryptContext(ctx, &dst, src); err != nil {
+		return statusError(err)
+	}
+
+	return statusError(dst.flush())
+}
+
+// =============================================================================
+
+// requestReader reads the data of the requests of a stream until the client
+// closes it.
+type requestReader struct {
+	next func() ([]byte, error)
+	data []byte
+}
+
+// Read implements the io.Reader interface.
+func (r *requestReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		data, err := r.next()
+		if err != nil {
+			return 0, err
+		}
+		r.data = data
+	}
+
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+
+	return n, nil
+}
+
+// chunkWriter buffers the data written to it and sends it in chunks of the
+// capacity of its buffer.
+type chunkWriter struct {
+	buf  []byte
+	send func(data []byte) error
+}
+
+// Write implements the io.Writer interface.
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	written := len(p)
+
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// flush sends the data buffered, if any.
+func (w *chunkWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	// The message keeps a reference to the data until it's sent, so the
+	// buffer isn't reused.
+	data := w.buf
+	w.buf = make([]byte, 0, cap(w.buf))
+
+	return w.send(data)
+}
+
+// statusError converts the error to a gRPC status error, keeping the status of
+// the errors that have one.
+func statusError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	var wrongChain *tlock.WrongChainError
+	switch {
+	case errors.Is(err, tlock.ErrTooEarly):
+		return status.Error(codes.FailedPrecondition, err.Error())
+
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+
+	case errors.Is(err, errHeader), errors.As(err, &wrongChain):
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return status.Error(codes.Unknown, err.Error())
+}
@@ -0,0 +1,148 @@
+package rpc_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/internal/fakenet"
+	"github.com/drand/tlock/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newClient(t *testing.T, network *fakenet.Chain) rpc.TlockClient {
+	resolve := func(ctx context.Context, chainHash string) (tlock.Network, error) {
+		if chainHash != network.ChainHash() {
+			return nil, errors.New("chain isn't accepted")
+		}
+		return network, nil
+	}
+
+	ln := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	rpc.RegisterTlockServer(srv, rpc.NewServer(resolve, rpc.WithChunkSize(100)))
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial error %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return rpc.NewTlockClient(conn)
+}
+
+// stream sends the header and the data in chunks and returns the data
+// streamed back.
+func stream(t *testing.T, s grpc.ClientStream, header interface{}, data []byte, request func(data []byte) interface{}) ([]byte, error) {
+	if err := s.SendMsg(header); err != nil {
+		t.Fatalf("send error %s", err)
+	}
+	for len(data) > 0 {
+		n := 37
+		if n > len(data) {
+			n = len(data)
+		}
+		if err := s.SendMsg(request(data[:n])); err != nil {
+			break
+		}
+		data = data[n:]
+	}
+	if err := s.CloseSend(); err != nil {
+		t.Fatalf("close error %s", err)
+	}
+
+	var out bytes.Buffer
+	for {
+		var c rpc.Chunk
+		err := s.RecvMsg(&c)
+		if errors.Is(err, io.EOF) {
+			return out.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		out.Write(c.Data)
+	}
+}
+
+func encrypt(t *testing.T, client rpc.TlockClient, header *rpc.EncryptHeader, data []byte) ([]byte, error) {
+	s, err := client.EncryptStream(context.Background())
+	if err != nil {
+		t.Fatalf("stream error %s", err)
+	}
+	return stream(t, s, &rpc.EncryptRequest{Request: &rpc.EncryptRequest_Header{Header: header}}, data, func(data []byte) interface{} {
+		return &rpc.EncryptRequest{Request: &rpc.EncryptRequest_Data{Data: data}}
+	})
+}
+
+func decrypt(t *testing.T, client rpc.TlockClient, header *rpc.DecryptHeader, data []byte) ([]byte, error) {
+	s, err := client.DecryptStream(context.Background())
+	if err != nil {
+		t.Fatalf("stream error %s", err)
+	}
+	return stream(t, s, &rpc.DecryptRequest{Request: &rpc.DecryptRequest_Header{Header: header}}, data, func(data []byte) interface{} {
+		return &rpc.DecryptRequest{Request: &rpc.DecryptRequest_Data{Data: data}}
+	})
+}
+
+func Test_Stream(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	client := newClient(t, network)
+	plain := []byte(strings.Repeat("time locked over gRPC ", 100))
+
+	for _, armored := range []bool{false, true} {
+		encrypted, err := encrypt(t, client, &rpc.EncryptHeader{ChainHash: network.ChainHash(), Round: 1, Armor: armored}, plain)
+		if err != nil {
+			t.Fatalf("encrypt error %s", err)
+		}
+		if armored != bytes.HasPrefix(encrypted, []byte("-----BEGIN")) {
+			t.Fatalf("expecting armor %v", armored)
+		}
+
+		// The chain is read from the data when the header doesn't name it.
+		for _, chainHash := range []string{network.ChainHash(), ""} {
+			decrypted, err := decrypt(t, client, &rpc.DecryptHeader{ChainHash: chainHash}, encrypted)
+			if err != nil {
+				t.Fatalf("decrypt error %s", err)
+			}
+			if !bytes.Equal(decrypted, plain) {
+				t.Fatal("decrypted data doesn't match")
+			}
+		}
+	}
+
+	locked, err := encrypt(t, client, &rpc.EncryptHeader{ChainHash: network.ChainHash(), Round: network.RoundNumber(time.Now()) + 10}, plain)
+	if err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	if _, err := decrypt(t, client, &rpc.DecryptHeader{}, locked); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expecting FailedPrecondition; got %v", err)
+	}
+
+	if _, err := encrypt(t, client, &rpc.EncryptHeader{ChainHash: "unknown", Round: 1}, plain); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expecting InvalidArgument for an unknown chain; got %v", err)
+	}
+
+	s, err := client.EncryptStream(context.Background())
+	if err != nil {
+		t.Fatalf("stream error %s", err)
+	}
+	if _, err := stream(t, s, &rpc.EncryptRequest{Request: &rpc.EncryptRequest_Data{Data: plain}}, nil, nil); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expecting InvalidArgument without a header; got %v", err)
+	}
+}
@@ -0,0 +1,522 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.28.1
+// 	protoc        (unknown)
+// source: rpc/tlock.proto
+
+package rpc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// EncryptRequest is a request of EncryptStream.
+type EncryptRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Types that are assignable to Request:
+	//	*EncryptRequest_Header
+	//	*EncryptRequest_Data
+	Request isEncryptRequest_Request `protobuf_oneof:"request"`
+}
+
+func (x *EncryptRequest) Reset() {
+	*x = EncryptRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_tlock_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *EncryptRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EncryptRequest) ProtoMessage() {}
+
+func (x *EncryptRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_tlock_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EncryptRequest.ProtoReflect.Descriptor instead.
+func (*EncryptRequest) Descriptor() ([]byte, []int) {
+	return file_rpc_tlock_proto_rawDescGZIP(), []int{0}
+}
+
+func (m *EncryptRequest) GetRequest() isEncryptRequest_Request {
+	if m != nil {
+		return m.Request
+	}
+	return nil
+}
+
+func (x *EncryptRequest) GetHeader() *EncryptHeader {
+	if x, ok := x.GetRequest().(*EncryptRequest_Header); ok {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *EncryptRequest) GetData() []byte {
+	if x, ok := x.GetRequest().(*EncryptRequest_Data); ok {
+		return x.Data
+	}
+	return nil
+}
+
+type isEncryptRequest_Request interface {
+	isEncryptRequest_Request()
+}
+
+type EncryptRequest_Header struct {
+	// header has to be in the first request, and only there.
+	Header *EncryptHeader `protobuf:"bytes,1,opt,name=header,proto3,oneof"`
+}
+
+type EncryptRequest_Data struct {
+	// data is the next chunk of the data to encrypt.
+	Data []byte `protobuf:"bytes,2,opt,name=data,proto3,oneof"`
+}
+
+func (*EncryptRequest_Header) isEncryptRequest_Request() {}
+
+func (*EncryptRequest_Data) isEncryptRequest_Request() {}
+
+// EncryptHeader describes an encryption.
+type EncryptHeader struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// chain_hash is the hex encoded hash of the chain the data is locked to.
+	ChainHash string `protobuf:"bytes,1,opt,name=chain_hash,json=chainHash,proto3" json:"chain_hash,omitempty"`
+	// round is the round the data is locked to.
+	Round uint64 `protobuf:"varint,2,opt,name=round,proto3" json:"round,omitempty"`
+	// armor encrypts the data to the PEM encoded format.
+	Armor bool `protobuf:"varint,3,opt,name=armor,proto3" json:"armor,omitempty"`
+}
+
+func (x *EncryptHeader) Reset() {
+	*x = EncryptHeader{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_tlock_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *EncryptHeader) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EncryptHeader) ProtoMessage() {}
+
+func (x *EncryptHeader) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_tlock_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EncryptHeader.ProtoReflect.Descriptor instead.
+func (*EncryptHeader) Descriptor() ([]byte, []int) {
+	return file_rpc_tlock_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *EncryptHeader) GetChainHash() string {
+	if x != nil {
+		return x.ChainHash
+	}
+	return ""
+}
+
+func (x *EncryptHeader) GetRound() uint64 {
+	if x != nil {
+		return x.Round
+	}
+	return 0
+}
+
+func (x *EncryptHeader) GetArmor() bool {
+	if x != nil {
+		return x.Armor
+	}
+	return false
+}
+
+// DecryptRequest is a request of DecryptStream.
+type DecryptRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Types that are assignable to Request:
+	//	*DecryptRequest_Header
+	//	*DecryptRequest_Data
+	Request isDecryptRequest_Request `protobuf_oneof:"request"`
+}
+
+func (x *DecryptRequest) Reset() {
+	*x = DecryptRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_tlock_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DecryptRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DecryptRequest) ProtoMessage() {}
+
+func (x *DecryptRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_tlock_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DecryptRequest.ProtoReflect.Descriptor instead.
+func (*DecryptRequest) Descriptor() ([]byte, []int) {
+	return file_rpc_tlock_proto_rawDescGZIP(), []int{2}
+}
+
+func (m *DecryptRequest) GetRequest() isDecryptRequest_Request {
+	if m != nil {
+		return m.Request
+	}
+	return nil
+}
+
+func (x *DecryptRequest) GetHeader() *DecryptHeader {
+	if x, ok := x.GetRequest().(*DecryptRequest_Header); ok {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *DecryptRequest) GetData() []byte {
+	if x, ok := x.GetRequest().(*DecryptRequest_Data); ok {
+		return x.Data
+	}
+	return nil
+}
+
+type isDecryptRequest_Request interface {
+	isDecryptRequest_Request()
+}
+
+type DecryptRequest_Header struct {
+	// header has to be in the first request, and only there.
+	Header *DecryptHeader `protobuf:"bytes,1,opt,name=header,proto3,oneof"`
+}
+
+type DecryptRequest_Data struct {
+	// data is the next chunk of the data to decrypt.
+	Data []byte `protobuf:"bytes,2,opt,name=data,proto3,oneof"`
+}
+
+func (*DecryptRequest_Header) isDecryptRequest_Request() {}
+
+func (*DecryptRequest_Data) isDecryptRequest_Request() {}
+
+// DecryptHeader describes a decryption.
+type DecryptHeader struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// chain_hash is the hex encoded hash of the chain the data is locked to.
+	// The chain recorded in the data is used if it's empty.
+	ChainHash string `protobuf:"bytes,1,opt,name=chain_hash,json=chainHash,proto3" json:"chain_hash,omitempty"`
+}
+
+func (x *DecryptHeader) Reset() {
+	*x = DecryptHeader{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_tlock_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DecryptHeader) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DecryptHeader) ProtoMessage() {}
+
+func (x *DecryptHeader) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_tlock_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DecryptHeader.ProtoReflect.Descriptor instead.
+func (*DecryptHeader) Descriptor() ([]byte, []int) {
+	return file_rpc_tlock_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *DecryptHeader) GetChainHash() string {
+	if x != nil {
+		return x.ChainHash
+	}
+	return ""
+}
+
+// Chunk is the next chunk of the result of an operation.
+type Chunk struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Data []byte `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+}
+
+func (x *Chunk) Reset() {
+	*x = Chunk{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_tlock_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Chunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Chunk) ProtoMessage() {}
+
+func (x *Chunk) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_tlock_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Chunk.ProtoReflect.Descriptor instead.
+func (*Chunk) Descriptor() ([]byte, []int) {
+	return file_rpc_tlock_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Chunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+var File_rpc_tlock_proto protoreflect.FileDescriptor
+
+var file_rpc_tlock_proto_rawDesc = []byte{
+	0x0a, 0x0f, 0x72, 0x70, 0x63, 0x2f, 0x74, 0x6c, 0x6f, 0x63, 0x6b, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x12, 0x08, 0x74, 0x6c, 0x6f, 0x63, 0x6b, 0x2e, 0x76, 0x31, 0x22, 0x64, 0x0a, 0x0e, 0x45,
+	0x6e, 0x63, 0x72, 0x79, 0x70, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x31, 0x0a,
+	0x06, 0x68, 0x65, 0x61, 0x64, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x17, 0x2e,
+	0x74, 0x6c, 0x6f, 0x63, 0x6b, 0x2e, 0x76, 0x31, 0x2e, 0x45, 0x6e, 0x63, 0x72, 0x79, 0x70, 0x74,
+	0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x48, 0x00, 0x52, 0x06, 0x68, 0x65, 0x61, 0x64, 0x65, 0x72,
+	0x12, 0x14, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x48, 0x00,
+	0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x42, 0x09, 0x0a, 0x07, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x22, 0x5a, 0x0a, 0x0d, 0x45, 0x6e, 0x63, 0x72, 0x79, 0x70, 0x74, 0x48, 0x65, 0x61, 0x64,
+	0x65, 0x72, 0x12, 0x1d, 0x0a, 0x0a, 0x63, 0x68, 0x61, 0x69, 0x6e, 0x5f, 0x68, 0x61, 0x73, 0x68,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x63, 0x68, 0x61, 0x69, 0x6e, 0x48, 0x61, 0x73,
+	0x68, 0x12, 0x14, 0x0a, 0x05, 0x72, 0x6f, 0x75, 0x6e, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04,
+	0x52, 0x05, 0x72, 0x6f, 0x75, 0x6e, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x61, 0x72, 0x6d, 0x6f, 0x72,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x61, 0x72, 0x6d, 0x6f, 0x72, 0x22, 0x64, 0x0a,
+	0x0e, 0x44, 0x65, 0x63, 0x72, 0x79, 0x70, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12,
+	0x31, 0x0a, 0x06, 0x68, 0x65, 0x61, 0x64, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x17, 0x2e, 0x74, 0x6c, 0x6f, 0x63, 0x6b, 0x2e, 0x76, 0x31, 0x2e, 0x44, 0x65, 0x63, 0x72, 0x79,
+	0x70, 0x74, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x48, 0x00, 0x52, 0x06, 0x68, 0x65, 0x61, 0x64,
+	0x65, 0x72, 0x12, 0x14, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c,
+	0x48, 0x00, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x42, 0x09, 0x0a, 0x07, 0x72, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x22, 0x2e, 0x0a, 0x0d, 0x44, 0x65, 0x63, 0x72, 0x79, 0x70, 0x74, 0x48, 0x65,
+	0x61, 0x64, 0x65, 0x72, 0x12, 0x1d, 0x0a, 0x0a, 0x63, 0x68, 0x61, 0x69, 0x6e, 0x5f, 0x68, 0x61,
+	0x73, 0x68, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x63, 0x68, 0x61, 0x69, 0x6e, 0x48,
+	0x61, 0x73, 0x68, 0x22, 0x1b, 0x0a, 0x05, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x12, 0x12, 0x0a, 0x04,
+	0x64, 0x61, 0x74, 0x61, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61,
+	0x32, 0x87, 0x01, 0x0a, 0x05, 0x54, 0x6c, 0x6f, 0x63, 0x6b, 0x12, 0x3e, 0x0a, 0x0d, 0x45, 0x6e,
+	0x63, 0x72, 0x79, 0x70, 0x74, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x12, 0x18, 0x2e, 0x74, 0x6c,
+	0x6f, 0x63, 0x6b, 0x2e, 0x76, 0x31, 0x2e, 0x45, 0x6e, 0x63, 0x72, 0x79, 0x70, 0x74, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e, 0x74, 0x6c, 0x6f, 0x63, 0x6b, 0x2e, 0x76, 0x31,
+	0x2e, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x28, 0x01, 0x30, 0x01, 0x12, 0x3e, 0x0a, 0x0d, 0x44, 0x65,
+	0x63, 0x72, 0x79, 0x70, 0x74, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x12, 0x18, 0x2e, 0x74, 0x6c,
+	0x6f, 0x63, 0x6b, 0x2e, 0x76, 0x31, 0x2e, 0x44, 0x65, 0x63, 0x72, 0x79, 0x70, 0x74, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e, 0x74, 0x6c, 0x6f, 0x63, 0x6b, 0x2e, 0x76, 0x31,
+	0x2e, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x28, 0x01, 0x30, 0x01, 0x42, 0x1c, 0x5a, 0x1a, 0x67, 0x69,
+	0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x64, 0x72, 0x61, 0x6e, 0x64, 0x2f, 0x74,
+	0x6c, 0x6f, 0x63, 0x6b, 0x2f, 0x72, 0x70, 0x63, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_rpc_tlock_proto_rawDescOnce sync.Once
+	file_rpc_tlock_proto_rawDescData = file_rpc_tlock_proto_rawDesc
+)
+
+func file_rpc_tlock_proto_rawDescGZIP() []byte {
+	file_rpc_tlock_proto_rawDescOnce.Do(func() {
+		file_rpc_tlock_proto_rawDescData = protoimpl.X.CompressGZIP(file_rpc_tlock_proto_rawDescData)
+	})
+	return file_rpc_tlock_proto_rawDescData
+}
+
+var file_rpc_tlock_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_rpc_tlock_proto_goTypes = []interface{}{
+	(*EncryptRequest)(nil), // 0: tlock.v1.EncryptRequest
+	(*EncryptHeader)(nil),  // 1: tlock.v1.EncryptHeader
+	(*DecryptRequest)(nil), // 2: tlock.v1.DecryptRequest
+	(*DecryptHeader)(nil),  // 3: tlock.v1.DecryptHeader
+	(*Chunk)(nil),          // 4: tlock.v1.Chunk
+}
+var file_rpc_tlock_proto_depIdxs = []int32{
+	1, // 0: tlock.v1.EncryptRequest.header:type_name -> tlock.v1.EncryptHeader
+	3, // 1: tlock.v1.DecryptRequest.header:type_name -> tlock.v1.DecryptHeader
+	0, // 2: tlock.v1.Tlock.EncryptStream:input_type -> tlock.v1.EncryptRequest
+	2, // 3: tlock.v1.Tlock.DecryptStream:input_type -> tlock.v1.DecryptRequest
+	4, // 4: tlock.v1.Tlock.EncryptStream:output_type -> tlock.v1.Chunk
+	4, // 5: tlock.v1.Tlock.DecryptStream:output_type -> tlock.v1.Chunk
+	4, // [4:6] is the sub-list for method output_type
+	2, // [2:4] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_rpc_tlock_proto_init() }
+func file_rpc_tlock_proto_init() {
+	if File_rpc_tlock_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_rpc_tlock_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*EncryptRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_tlock_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*EncryptHeader); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_tlock_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DecryptRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_tlock_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DecryptHeader); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_tlock_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Chunk); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_rpc_tlock_proto_msgTypes[0].OneofWrappers = []interface{}{
+		(*EncryptRequest_Header)(nil),
+		(*EncryptRequest_Data)(nil),
+	}
+	file_rpc_tlock_proto_msgTypes[2].OneofWrappers = []interface{}{
+		(*DecryptRequest_Header)(nil),
+		(*DecryptRequest_Data)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_rpc_tlock_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   5,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_rpc_tlock_proto_goTypes,
+		DependencyIndexes: file_rpc_tlock_proto_depIdxs,
+		MessageInfos:      file_rpc_tlock_proto_msgTypes,
+	}.Build()
+	File_rpc_tlock_proto = out.File
+	file_rpc_tlock_proto_rawDesc = nil
+	file_rpc_tlock_proto_goTypes = nil
+	file_rpc_tlock_proto_depIdxs = nil
+}
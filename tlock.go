@@ -5,110 +5,1025 @@ package tlock
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
 	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"math"
+	"time"
 
 	"filippo.io/age"
-	"filippo.io/age/armor"
 	"github.com/drand/drand/chain"
 	"github.com/drand/drand/common/scheme"
 	"github.com/drand/kyber"
 	bls "github.com/drand/kyber-bls12381"
 	"github.com/drand/kyber/encrypt/ibe"
+	sign "github.com/drand/kyber/sign/bls"
+	"github.com/drand/tlock/armor"
+	"github.com/drand/tlock/internal/codec"
+	"github.com/drand/tlock/networks"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ErrTooEarly represents an error when a decryption operation happens early.
 var ErrTooEarly = errors.New("too early to decrypt")
 
+// ErrUTF16Input represents an error when the encrypted data was converted to
+// UTF-16 text, which corrupts anything but armored data. This typically
+// happens when redirecting output in Windows PowerShell.
+var ErrUTF16Input = errors.New("input was converted to UTF-16 text and is corrupted")
+
+// ErrWrongChain represents an error when the encrypted data is locked to a
+// different chain than the one of the network. The error is returned as a
+// *WrongChainError, which names both chains.
+var ErrWrongChain = errors.New("wrong chain")
+
+// WrongChainError provides the chain hashes involved in an ErrWrongChain
+// error.
+type WrongChainError struct {
+	HeaderChainHash  string
+	NetworkChainHash string
+}
+
+// Error implements the error interface.
+func (e *WrongChainError) Error() string {
+	return fmt.Sprintf("%v: the data is locked to chain %s but the network uses chain %s", ErrWrongChain, e.HeaderChainHash, e.NetworkChainHash)
+}
+
+// Unwrap allows errors.Is to match ErrWrongChain.
+func (e *WrongChainError) Unwrap() error {
+	return ErrWrongChain
+}
+
+// ErrUnsupportedVersion represents an error when the encrypted data uses a
+// newer format than this version of tlock supports. The error is returned as
+// a *VersionError, which names the version of the data.
+var ErrUnsupportedVersion = errors.New("unsupported format version")
+
+// VersionError provides the format version involved in an
+// ErrUnsupportedVersion error.
+type VersionError struct {
+	Version int
+}
+
+// Error implements the error interface.
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("%v: file requires tlock >= format v%d, this tlock supports formats v%d to v%d", ErrUnsupportedVersion, e.Version, MinFormatVersion, FormatVersion)
+}
+
+// Unwrap allows errors.Is to match ErrUnsupportedVersion.
+func (e *VersionError) Unwrap() error {
+	return ErrUnsupportedVersion
+}
+
+// ErrAADMismatch represents an error when the associated data provided for
+// decryption doesn't match the one used for encryption.
+var ErrAADMismatch = errors.New("associated data does not match")
+
+// ErrHeaderMACMismatch represents an error when the header was modified after
+// encryption, or doesn't belong to the data encryption key it holds.
+var ErrHeaderMACMismatch = errors.New("header mac mismatch")
+
+// =============================================================================
+
+// These types alias the contract of the networks package, where networks
+// written by third parties are documented, so existing callers keep
+// compiling.
+type (
+	Network             = networks.Network
+	ContextNetwork      = networks.ContextNetwork
+	AvailabilityNetwork = networks.AvailabilityNetwork
+)
+
 // =============================================================================
 
-// Network represents a system that provides support for encrypting/decrypting
-// a DEK based on a future time.
-type Network interface {
-	ChainHash() string
-	PublicKey() kyber.Point
-	Signature(roundNumber uint64) ([]byte, error)
+// Logger represents a logger that displays debug information about the
+// operations of a tlock. It is implemented by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Clock represents the source of the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock implements the Clock interface using the system time.
+type systemClock struct{}
+
+// Now returns the current system time.
+func (systemClock) Now() time.Time {
+	return time.Now()
 }
 
 // =============================================================================
 
 // Tlock provides an API for time lock encryption and decryption.
 type Tlock struct {
-	network Network
+	network          Network
+	fileMode         fs.FileMode
+	aead             AEAD
+	chunkSize        int
+	compactSmall     bool
+	compact          bool
+	logger           Logger
+	rand             io.Reader
+	clock            Clock
+	strictChainCheck bool
+	strictParsing    bool
+	limits           limits
+	aad              []byte
+	contentType      string
+	extensions       map[string]string
+	endpointHints    []string
+	convergentKey    []byte
+	digest           bool
+	digestKey        []byte
+	checkpoint       func(Checkpoint) error
+	tracerProvider   trace.TracerProvider
+	policy           *Policy
+	cache            *SignatureCache
+	signatures       *signatureGroup
+	warnings         func(Warning)
+	source           networks.BeaconSource
+
+	// chainHash and pairing are the hash of the chain and the pairing of the
+	// public key with the identity of the round an Encryptor encrypts to,
+	// computed once.
+	chainHash string
+	pairing   kyber.Point
+
+	// hardened enables the hardening described by WithHardening.
+	hardened bool
+
+	// deterministicSeed is the seed of the random stream of the encryption,
+	// set by WithDeterministicSeed for test vectors.
+	deterministicSeed []byte
+}
+
+// Option configures a tlock constructed with New.
+type Option func(t *Tlock)
+
+// WithAEAD sets the algorithm used to encrypt the payload. The algorithm is
+// recorded in the header, so decryption doesn't need this option. The default
+// is ChaCha20Poly1305.
+func WithAEAD(aead AEAD) Option {
+	return func(t *Tlock) {
+		t.aead = aead
+	}
+}
+
+// WithChunkSize sets the size of the chunks the payload is split into before
+// it's encrypted. The chunk size is recorded in the header, so decryption
+// doesn't need this option. The default is DefaultChunkSize, which keeps the
+// data compatible with age.
+func WithChunkSize(size int) Option {
+	return func(t *Tlock) {
+		t.chunkSize = size
+	}
+}
+
+// WithCompact makes EncryptFrom encrypt sources up to MaxCompactSize as a
+// compact payload, which takes fewer bytes but can't be decrypted with age or
+// by readers of format versions before 3. Other sources, and data encrypted
+// with Encrypt, are written as without it. It isn't the default so that data
+// encrypted with the default options stays readable by age.
+func WithCompact() Option {
+	return func(t *Tlock) {
+		t.compactSmall = true
+	}
+}
+
+// WithLogger sets a logger that displays debug information. Nothing is
+// logged by default.
+func WithLogger(logger Logger) Option {
+	return func(t *Tlock) {
+		t.logger = logger
+	}
+}
+
+// WithRand sets the source of randomness for the data encryption key and the
+// payload nonce. The default is crypto/rand.Reader. The time lock encryption
+// of the key uses crypto/rand unless WithConvergentKey or
+// WithDeterministicSeed is set.
+func WithRand(rand io.Reader) Option {
+	return func(t *Tlock) {
+		t.rand = rand
+	}
+}
+
+// WithClock sets the clock used to report how long it takes until encrypted
+// data can be decrypted. The default is the system clock.
+func WithClock(clock Clock) Option {
+	return func(t *Tlock) {
+		t.clock = clock
+	}
+}
+
+// WithStrictChainCheck sets whether decryption fails right away when the
+// chain hash in the header doesn't match the network. When disabled, the
+// signature of the network is used regardless, which only succeeds if the
+// chains share the same key. The check is enabled by default.
+func WithStrictChainCheck(strict bool) Option {
+	return func(t *Tlock) {
+		t.strictChainCheck = strict
+	}
+}
+
+// WithStrictParsing sets whether decryption rejects headers holding arguments
+// it doesn't know or values tlock doesn't write, such as numbers with leading
+// zeros or settings recorded with their default value. Lenient parsing
+// ignores unknown arguments, so data written by newer versions that add
+// information to the header can still be decrypted. Extensions are accepted
+// either way, and the encoding of the header is always checked. Parsing is
+// lenient by default.
+func WithStrictParsing(strict bool) Option {
+	return func(t *Tlock) {
+		t.strictParsing = strict
+	}
+}
+
+// WithAAD sets associated data, such as a document ID or a policy, that is
+// authenticated with the payload. Data encrypted with associated data can
+// only be decrypted by a tlock given the same associated data, which prevents
+// encrypted data from being moved between contexts. An empty but non-nil
+// value counts as associated data.
+func WithAAD(aad []byte) Option {
+	return func(t *Tlock) {
+		t.aad = aad
+	}
+}
+
+// WithContentType records the type of the plain data in the header, such as
+// "tar", so tools can process the data after decryption. The content type
+// is made of lowercase letters, digits and the characters ".+-" and isn't
+// authenticated before decryption.
+func WithContentType(contentType string) Option {
+	return func(t *Tlock) {
+		t.contentType = contentType
+	}
+}
+
+// WithExtension records a key/value pair in the extension area of the
+// header, so metadata can be added without changing the format. Keys are made
+// of lowercase letters, digits and '-', start with a letter and are at most
+// MaxExtensionKeySize long, while values can hold any bytes up to
+// MaxExtensionSize. Extensions are authenticated with the header when
+// decrypting, and readers ignore the keys they don't use.
+func WithExtension(key string, value string) Option {
+	return func(t *Tlock) {
+		extensions := make(map[string]string, len(t.extensions)+1)
+		for k, v := range t.extensions {
+			extensions[k] = v
+		}
+		extensions[key] = value
+		t.extensions = extensions
+	}
 }
 
 // New constructs a tlock for the specified network which can encrypt data that
 // can be decrypted until the future.
-func New(network Network) Tlock {
-	return Tlock{
-		network: network,
+func New(network Network, opts ...Option) Tlock {
+	t := Tlock{
+		network:          network,
+		aead:             ChaCha20Poly1305,
+		chunkSize:        DefaultChunkSize,
+		rand:             rand.Reader,
+		clock:            systemClock{},
+		strictChainCheck: true,
+		limits:           limits{headerSize: DefaultMaxHeaderSize},
+		hardened:         hardenedByDefault,
 	}
+
+	for _, opt := range opts {
+		opt(&t)
+	}
+
+	return t
+}
+
+// WithFileMode returns a copy of the tlock that records the permission bits
+// of the specified file mode in the header of encrypted data, so they can be
+// restored after decryption.
+func (t Tlock) WithFileMode(mode fs.FileMode) Tlock {
+	t.fileMode = mode.Perm()
+	return t
 }
 
 // Encrypt will encrypt the source and write that to the destination. The encrypted
 // data will not be decryptable until the specified round is reached by the network.
 func (t Tlock) Encrypt(dst io.Writer, src io.Reader, roundNumber uint64) (err error) {
-	w, err := age.Encrypt(dst, &tleRecipient{network: t.network, roundNumber: roundNumber})
+	return t.EncryptContext(context.Background(), dst, src, roundNumber)
+}
+
+// EncryptContext works like Encrypt but stops reading the source as soon as
+// the context is canceled.
+func (t Tlock) EncryptContext(ctx context.Context, dst io.Writer, src io.Reader, roundNumber uint64) error {
+	return t.encrypt(ctx, dst, src, roundNumber, func(w *streamWriter) (int64, error) {
+		n, err := io.Copy(w, contextReader{ctx: ctx, r: src})
+		if err != nil {
+			return n, fmt.Errorf("write: %w", err)
+		}
+
+		if err := w.Close(); err != nil {
+			return n, fmt.Errorf("close: %w", err)
+		}
+
+		return n, nil
+	})
+}
+
+// encrypt writes the header for the round and the payload, whose chunks are
+// written by the payload function. The source is only read by the options
+// that need the plain data before the header is written, such as convergent
+// encryption. The payload function returns the number of bytes of plain
+// data it encrypted.
+func (t Tlock) encrypt(ctx context.Context, dst io.Writer, src io.Reader, roundNumber uint64, payload func(w *streamWriter) (int64, error)) (err error) {
+	if t.chunkSize <= 0 || t.chunkSize > MaxChunkSize {
+		return fmt.Errorf("invalid chunk size %d", t.chunkSize)
+	}
+
+	if !validContentType(t.contentType) {
+		return fmt.Errorf("invalid content type %q", t.contentType)
+	}
+
+	if err := checkExtensions(t.extensions); err != nil {
+		return err
+	}
+
+	if err := checkEndpointHints(t.endpointHints); err != nil {
+		return err
+	}
+
+	sch, err := networkScheme(t.network)
 	if err != nil {
-		return fmt.Errorf("age encrypt: %w", err)
+		return err
 	}
 
-	defer func() {
-		if err = w.Close(); err != nil {
-			err = fmt.Errorf("close: %w", err)
+	if t.policy != nil {
+		if err := t.policy.Check(t.network, roundNumber, t.clock.Now(), dst); err != nil {
+			return err
 		}
+	}
+
+	random := t.rand
+	switch {
+	case t.convergentKey != nil && t.deterministicSeed != nil:
+		return errors.New("deterministic seed can't be used with a convergent key")
+	case t.convergentKey != nil:
+		if random, err = t.convergentRand(ctx, src, roundNumber); err != nil {
+			return err
+		}
+	case t.deterministicSeed != nil:
+		random = t.deterministicRand(roundNumber)
+	}
+
+	var digest []byte
+	if t.digest {
+		if digest, err = t.contentDigest(ctx, src); err != nil {
+			return err
+		}
+	}
+
+	ctx, span := t.startSpan(ctx, "tlock.Encrypt", attrChainHash.String(t.networkChainHash()), roundAttr(roundNumber))
+	var plain int64
+	out := byteCounter{w: dst}
+	defer func() {
+		span.SetAttributes(attrPlain.Int64(plain), attrEncrypted.Int64(out.n))
+		endSpan(span, err)
 	}()
+	dst = &out
+
+	t.logf("encrypting for round %d of chain %s", roundNumber, t.networkChainHash())
+	t.warnRoundSoon(roundNumber)
 
-	if _, err := io.Copy(w, src); err != nil {
-		return fmt.Errorf("write: %w", err)
+	fileKey := make([]byte, codec.FileKeySize)
+	if _, err := io.ReadFull(random, fileKey); err != nil {
+		return fmt.Errorf("generate dek: %w", err)
 	}
+	defer t.wipe(fileKey)
 
-	return nil
+	recipient := tleRecipient{
+		network:     t.network,
+		scheme:      sch,
+		roundNumber: roundNumber,
+		fileMode:    t.fileMode,
+		aead:        t.aead,
+		chunkSize:   t.chunkSize,
+		compact:     t.compact,
+		aad:         t.aad != nil,
+		contentType: t.contentType,
+		extensions:  t.extensions,
+		hints:       t.endpointHints,
+		digest:      digest,
+		digestKeyed: t.digestKey != nil,
+		chainHash:   t.chainHash,
+		pairing:     t.pairing,
+		hardened:    t.hardened,
+	}
+	if t.convergentKey != nil || t.deterministicSeed != nil {
+		recipient.sigma = random
+	}
+
+	stanzas, err := timelockWrapper{recipient: &recipient}.WrapDEK(ctx, fileKey)
+	if err != nil {
+		return fmt.Errorf("wrap dek: %w", err)
+	}
+
+	var hdr bytes.Buffer
+	if err := writeHeader(&hdr, stanzas, fileKey); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+
+	if _, err := dst.Write(hdr.Bytes()); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+
+	// Compact payloads have no nonce, since the key is only used once.
+	var nonce []byte
+	if !t.compact {
+		nonce = make([]byte, codec.PayloadNonceSize)
+		if _, err := io.ReadFull(random, nonce); err != nil {
+			return fmt.Errorf("generate nonce: %w", err)
+		}
+
+		if _, err := dst.Write(nonce); err != nil {
+			return fmt.Errorf("write nonce: %w", err)
+		}
+	}
+
+	key, err := codec.PayloadKey(fileKey, nonce)
+	if err != nil {
+		return err
+	}
+
+	aead, err := payloadCipher(t.aead, key)
+	if err != nil {
+		return err
+	}
+
+	cp := Checkpoint{
+		AEAD:           t.aead,
+		ChunkSize:      t.chunkSize,
+		Key:            key,
+		AdditionalData: additionalData(t.aad),
+	}
+
+	w := newStreamWriter(aead, cp.AdditionalData, dst, t.chunkSize)
+	w.limits = t.limits
+	w.checkpoint = t.encryptCheckpoint(cp, int64(hdr.Len()+len(nonce)), aead.Overhead())
+
+	plain, err = payload(w)
+	return err
+}
+
+// EncryptRelative encrypts the source for the round that comes the specified
+// number of rounds after the current round of the network, which is the
+// natural way to express short delays like the reveal phase of a
+// commit-reveal protocol. It returns the round the data is locked to. The
+// network has to tell the current round, like the one of the networks/http
+// package.
+func (t Tlock) EncryptRelative(dst io.Writer, src io.Reader, rounds uint64) (uint64, error) {
+	return t.EncryptRelativeContext(context.Background(), dst, src, rounds)
+}
+
+// EncryptRelativeContext works like EncryptRelative but stops reading the
+// source as soon as the context is canceled.
+func (t Tlock) EncryptRelativeContext(ctx context.Context, dst io.Writer, src io.Reader, rounds uint64) (uint64, error) {
+	rc, ok := t.network.(networks.RoundCalculator)
+	if !ok {
+		return 0, errors.New("network can't tell the current round")
+	}
+
+	if rounds == 0 {
+		return 0, errors.New("relative round must be at least 1")
+	}
+
+	current := rc.RoundNumber(t.clock.Now())
+	if current > math.MaxUint64-rounds {
+		return 0, fmt.Errorf("relative round %d is out of range", rounds)
+	}
+	roundNumber := current + rounds
+
+	if err := t.EncryptContext(ctx, dst, src, roundNumber); err != nil {
+		return 0, err
+	}
+
+	return roundNumber, nil
 }
 
 // Decrypt will decrypt the source and write that to the destination. The decrypted
 // data will not be decryptable unless the specified round from the encrypt call
 // is reached by the network.
 func (t Tlock) Decrypt(dst io.Writer, src io.Reader) error {
-	rr := bufio.NewReader(src)
+	return t.DecryptContext(context.Background(), dst, src)
+}
+
+// DecryptContext works like Decrypt but stops retrieving the signature from
+// the network and writing the decrypted data as soon as the context is
+// canceled.
+func (t Tlock) DecryptContext(ctx context.Context, dst io.Writer, src io.Reader) error {
+	return t.decrypt(ctx, dst, src, nil)
+}
+
+// CanDecrypt checks that the source can be decrypted without decrypting its
+// payload, so services can confirm that data is available before streaming
+// it. It retrieves the signature of the round, unwraps the data key and
+// authenticates the header with it, and fails with the error Decrypt would
+// fail with, such as ErrTooEarly. Nothing past the payload nonce is read, so
+// a corrupt payload is only detected by Decrypt.
+func (t Tlock) CanDecrypt(ctx context.Context, src io.Reader) (err error) {
+	ctx, span := t.startSpan(ctx, "tlock.CanDecrypt")
+	defer func() { endSpan(span, err) }()
+
+	br, text, _ := dearmor(src)
+
+	_, _, _, err = t.unlock(ctx, br, text)
+	return err
+}
+
+// decrypt decrypts the source, skipping the chunks before the checkpoint if
+// one is provided.
+func (t Tlock) decrypt(ctx context.Context, dst io.Writer, src io.Reader, resume *Checkpoint) (err error) {
+	ctx, span := t.startSpan(ctx, "tlock.Decrypt")
+	out := byteCounter{w: dst}
+	defer func() {
+		span.SetAttributes(attrPlain.Int64(out.n))
+		endSpan(span, err)
+	}()
+	dst = &out
 
-	if start, _ := rr.Peek(len(armor.Header)); string(start) == armor.Header {
-		src = armor.NewReader(rr)
-	} else {
-		src = rr
+	var base int64 = -1
+	if s, ok := src.(io.Seeker); ok && resume != nil {
+		if offset, err := s.Seek(0, io.SeekCurrent); err == nil {
+			base = offset
+		}
 	}
 
-	r, err := age.Decrypt(src, &tleIdentity{network: t.network})
+	br, text, armored := dearmor(src)
+
+	info, aead, start, err := t.unlock(ctx, br, text)
 	if err != nil {
-		return fmt.Errorf("age decrypt: %w", err)
+		return err
+	}
+
+	encryptedChunk := int64(info.ChunkSize + aead.Overhead())
+
+	r := newStreamReader(aead, additionalData(t.aad), br, info.ChunkSize)
+	r.limits = t.limits
+
+	if resume != nil {
+		if resume.Read != start+int64(resume.Chunks)*encryptedChunk || resume.Written != int64(resume.Chunks)*int64(info.ChunkSize) {
+			return errors.New("checkpoint does not match the encrypted data")
+		}
+
+		t.logf("resuming decryption after %d chunks", resume.Chunks)
+
+		switch {
+		case base >= 0 && !armored && text == nil:
+			if _, err := src.(io.Seeker).Seek(base+resume.Read, io.SeekStart); err != nil {
+				return fmt.Errorf("skip payload: %w", err)
+			}
+			br.Reset(src)
+
+		default:
+			if _, err := io.CopyN(io.Discard, br, resume.Read-start); err != nil {
+				return fmt.Errorf("skip payload: %w", text.check(err))
+			}
+		}
+
+		r.skip(resume.Chunks)
+	}
+
+	// The digest covers all the data, so it can't be verified when resuming.
+	h := t.digestVerifier(info)
+	if h != nil && resume != nil {
+		t.warn(WarnDigestNotVerified, "not verifying the content digest of a resumed decryption")
+		h = nil
+	}
+	if h != nil {
+		dst = io.MultiWriter(dst, h)
+	}
+
+	if err := r.copyTo(ctx, dst, t.decryptCheckpoint(start, info.ChunkSize, aead.Overhead())); err != nil {
+		return fmt.Errorf("write: %w", text.check(err))
 	}
 
-	if _, err := io.Copy(dst, r); err != nil {
-		return fmt.Errorf("write: %w", err)
+	if h != nil {
+		return verifyDigest(h, info.Digest)
 	}
 
 	return nil
 }
 
-// =============================================================================
+// unlock reads the header and payload nonce from the source, recovers the
+// file key from the network, and returns the time lock information, the
+// payload cipher, and the offset of the payload in the encrypted data.
+func (t Tlock) unlock(ctx context.Context, br *bufio.Reader, text *utf16Reader) (Header, cipher.AEAD, int64, error) {
+	hdr, raw, err := parseHeader(br, t.limits.headerSize)
+	if err != nil {
+		return Header{}, nil, 0, fmt.Errorf("parse header: %w", text.check(err))
+	}
 
-// TimeLock encrypts the specified data for the given round number. The data
-// can't be decrypted until the specified round is reached by the network in use.
-func TimeLock(publicKey kyber.Point, roundNumber uint64, data []byte) (*ibe.Ciphertext, error) {
-	h := sha256.New()
-	if _, err := h.Write(chain.RoundToBytes(roundNumber)); err != nil {
-		return nil, fmt.Errorf("sha256 write: %w", err)
+	info, err := tlockHeader(hdr, t.strictParsing)
+	if err != nil {
+		return Header{}, nil, 0, err
 	}
-	id := h.Sum(nil)
 
-	cipherText, err := ibe.Encrypt(bls.NewBLS12381Suite(), publicKey, id, data)
+	t.logf("decrypting round %d of chain %s", info.RoundNumber, info.ChainHash)
+	t.warnLegacyFormat(hdr.Stanzas)
+	trace.SpanFromContext(ctx).SetAttributes(attrChainHash.String(info.ChainHash), roundAttr(info.RoundNumber))
+
+	switch {
+	case info.AAD && t.aad == nil:
+		return Header{}, nil, 0, fmt.Errorf("%w: the data was encrypted with associated data", ErrAADMismatch)
+	case !info.AAD && t.aad != nil:
+		return Header{}, nil, 0, fmt.Errorf("%w: the data was encrypted without associated data", ErrAADMismatch)
+	}
+
+	start := time.Now()
+	fileKey, err := t.unwrap(ctx, hdr.Stanzas, info.RoundNumber)
+	t.warnSlowNetwork(info.RoundNumber, time.Since(start))
 	if err != nil {
-		return nil, fmt.Errorf("encrypt data: %w", err)
+		return Header{}, nil, 0, fmt.Errorf("unwrap dek: %w", t.tooEarly(err, info.RoundNumber))
 	}
+	defer t.wipe(fileKey)
 
-	return cipherText, nil
+	mac, err := codec.HeaderMAC(fileKey, raw)
+	if err != nil {
+		return Header{}, nil, 0, err
+	}
+
+	if !codec.Equal(mac, hdr.MAC) {
+		return Header{}, nil, 0, ErrHeaderMACMismatch
+	}
+
+	var nonce []byte
+	if !info.Compact {
+		nonce = make([]byte, codec.PayloadNonceSize)
+		if _, err := io.ReadFull(br, nonce); err != nil {
+			return Header{}, nil, 0, fmt.Errorf("read nonce: %w", text.check(err))
+		}
+	}
+
+	aead, err := payloadAEAD(info.AEAD, fileKey, nonce)
+	if err != nil {
+		return Header{}, nil, 0, err
+	}
+
+	return info, aead, hdr.Size(raw) + int64(len(nonce)), nil
+}
+
+// unwrap recovers the data encryption key from the stanzas, retrieving the
+// signature of the round from the network.
+func (t Tlock) unwrap(ctx context.Context, stanzas []*age.Stanza, roundNumber uint64) (fileKey []byte, err error) {
+	ctx, span := t.startSpan(ctx, "tlock.Unwrap", attrChainHash.String(t.network.ChainHash()), roundAttr(roundNumber))
+	defer func() { endSpan(span, err) }()
+
+	identity := tleIdentity{
+		network:  t.network,
+		lenient:  !t.strictChainCheck,
+		strict:   t.strictParsing,
+		cache:    t.cache,
+		group:    t.signatures,
+		source:   t.source,
+		hardened: t.hardened,
+	}
+
+	return timelockWrapper{identity: &identity}.UnwrapDEK(ctx, stanzas)
+}
+
+// networkChainHash returns the hash of the chain of the network, unless it
+// was computed beforehand.
+func (t Tlock) networkChainHash() string {
+	if t.chainHash != "" {
+		return t.chainHash
+	}
+	return t.network.ChainHash()
+}
+
+// logf displays a debug message if the tlock has a logger.
+func (t Tlock) logf(format string, v ...interface{}) {
+	if t.logger != nil {
+		t.logger.Printf(format, v...)
+	}
+}
+
+// tooEarly adds the time remaining until the round is reached to ErrTooEarly
+// errors, if the network can tell when the round becomes available.
+func (t Tlock) tooEarly(err error, roundNumber uint64) error {
+	rt, ok := t.network.(networks.RoundTimer)
+	if !ok || !errors.Is(err, ErrTooEarly) {
+		return err
+	}
+
+	remaining := rt.RoundTime(roundNumber).Sub(t.clock.Now()).Round(time.Second)
+	if remaining <= 0 {
+		return err
+	}
+
+	return fmt.Errorf("%w: round %d is reached in %s", ErrTooEarly, roundNumber, remaining)
+}
+
+// writeHeader writes the header for the stanzas, authenticated with the
+// file key.
+func writeHeader(dst io.Writer, stanzas []*age.Stanza, fileKey []byte) error {
+	hdr := codec.Header{
+		Stanzas: stanzas,
+	}
+
+	var raw bytes.Buffer
+	if err := hdr.MarshalWithoutMAC(&raw); err != nil {
+		return err
+	}
+
+	mac, err := codec.HeaderMAC(fileKey, raw.Bytes())
+	if err != nil {
+		return err
+	}
+	hdr.MAC = mac
+
+	return hdr.Marshal(dst)
+}
+
+// tlockHeader extracts the time lock information from the single tlock
+// stanza of the header, parsing it strictly if requested.
+func tlockHeader(hdr *codec.Header, strict bool) (Header, error) {
+	if len(hdr.Stanzas) != 1 {
+		return Header{}, fmt.Errorf("%w: check stanzas length: should be one", ErrMalformedHeader)
+	}
+
+	return parseStanza(hdr.Stanzas[0], strict)
+}
+
+// payloadAEAD constructs the payload cipher from the file key and nonce.
+func payloadAEAD(a AEAD, fileKey []byte, nonce []byte) (cipher.AEAD, error) {
+	key, err := codec.PayloadKey(fileKey, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return payloadCipher(a, key)
+}
+
+// payloadCipher constructs the payload cipher from the payload key.
+func payloadCipher(a AEAD, key []byte) (cipher.AEAD, error) {
+	aead, err := a.newAEAD(key)
+	if err != nil {
+		return nil, fmt.Errorf("payload cipher: %w", err)
+	}
+
+	return aead, nil
+}
+
+// Header represents the time lock information stored in the header of
+// encrypted data.
+type Header struct {
+	Version     int
+	RoundNumber uint64
+	ChainHash   string
+	FileMode    fs.FileMode
+	AEAD        AEAD
+	ChunkSize   int
+	AAD         bool
+	ContentType string
+
+	// Compact is set for payloads without a nonce made of a single chunk,
+	// whose ChunkSize is then MaxCompactSize.
+	Compact bool
+
+	// Scheme is the drand scheme of the chain, SchemeUnchained unless
+	// recorded otherwise.
+	Scheme string
+
+	// Extensions holds the key/value pairs of the extension area. They are
+	// only authenticated once the data is decrypted.
+	Extensions map[string]string
+
+	// Digest is the SHA-256 of the plain data, or its HMAC-SHA256 when
+	// DigestKeyed is set, if it was recorded.
+	Digest      []byte
+	DigestKeyed bool
+
+	// EndpointHints holds the URLs of drand endpoints suggested by whoever
+	// encrypted the data. They aren't authenticated before decryption.
+	EndpointHints []string
+}
+
+// ReadHeader reads the time lock information from the header of the source
+// without decrypting it. This doesn't require access to the network.
+func ReadHeader(src io.Reader) (Header, error) {
+	br, text, _ := dearmor(src)
+
+	hdr, _, err := parseHeader(br, DefaultMaxHeaderSize)
+	if err != nil {
+		return Header{}, fmt.Errorf("read header: %w", text.check(err))
+	}
+
+	return tlockHeader(hdr, false)
+}
+
+// SniffSize is the number of leading bytes IsEncrypted needs to recognize
+// encrypted data, which covers the first line of armored data of any width.
+const SniffSize = 4096
+
+// IsEncrypted reports whether data starting with the prefix looks encrypted
+// by tlock or age, armored or not. It only looks at the format, without
+// checking the header, to catch data that is about to be encrypted twice or
+// that still has a layer of encryption. The prefix should hold the first
+// SniffSize bytes of the data, or all of it if it's shorter.
+func IsEncrypted(prefix []byte) bool {
+	br, _, _ := dearmor(bytes.NewReader(prefix))
+
+	start := make([]byte, len(codec.Intro))
+	if _, err := io.ReadFull(br, start); err != nil {
+		return false
+	}
+
+	return string(start) == codec.Intro
+}
+
+// Metadata describes encrypted data, as reported by Inspect.
+type Metadata struct {
+	Header
+
+	// Armored reports whether the data is PEM encoded.
+	Armored bool
+
+	// PayloadSize is the size in bytes of the encrypted payload that
+	// follows the header, after removing the armor.
+	PayloadSize int64
+
+	// EstimatedUnlock is the time at which the round is reached. It is only
+	// set when the network provides round times for the chain of the data.
+	EstimatedUnlock time.Time
+}
+
+// Inspect reads the source to describe the encrypted data without decrypting
+// it. The network is optional and only used to estimate when the data can be
+// decrypted, which doesn't require access to the network for the networks
+// provided by this module.
+func Inspect(src io.Reader, network Network) (Metadata, error) {
+	br, text, armored := dearmor(src)
+
+	hdr, _, err := parseHeader(br, DefaultMaxHeaderSize)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("read header: %w", text.check(err))
+	}
+
+	header, err := tlockHeader(hdr, false)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	size, err := io.Copy(io.Discard, br)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("read payload: %w", text.check(err))
+	}
+
+	md := Metadata{
+		Header:      header,
+		Armored:     armored,
+		PayloadSize: size,
+	}
+
+	if network != nil && network.ChainHash() == header.ChainHash {
+		if rt, ok := network.(networks.RoundTimer); ok {
+			md.EstimatedUnlock = rt.RoundTime(header.RoundNumber)
+		}
+	}
+
+	return md, nil
+}
+
+// contextReader stops reading from the underlying reader once the context
+// is canceled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// Read implements the io.Reader interface.
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// These byte sequences mark text that was re-encoded by editors and shells,
+// most commonly on Windows.
+var (
+	utf8BOM    = []byte{0xef, 0xbb, 0xbf}
+	utf16LEBOM = []byte{0xff, 0xfe}
+)
+
+// dearmor returns a reader that decodes the source if it is armored. Armored
+// data that was converted to UTF-16 or prefixed with a byte order mark or
+// blank lines, as happens with some Windows editors and shells, is accepted,
+// and so is a preamble before the header line. Binary data is never taken
+// for a preamble. Line endings are handled by the armor reader itself. The returned
+// utf16Reader is nil unless the source was converted to UTF-16, and the
+// returned flag reports whether the source was armored.
+func dearmor(src io.Reader) (*bufio.Reader, *utf16Reader, bool) {
+	rr := bufio.NewReader(src)
+
+	var text *utf16Reader
+	if start, _ := rr.Peek(len(utf16LEBOM)); bytes.Equal(start, utf16LEBOM) {
+		rr.Discard(len(utf16LEBOM))
+		text = &utf16Reader{r: rr}
+		rr = bufio.NewReader(text)
+	}
+
+	if start, _ := rr.Peek(len(utf8BOM)); bytes.Equal(start, utf8BOM) {
+		rr.Discard(len(utf8BOM))
+	}
+
+	for {
+		b, err := rr.Peek(1)
+		if err != nil || (b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n') {
+			break
+		}
+		rr.Discard(1)
+	}
+
+	if start, _ := rr.Peek(len(codec.Intro)); string(start) == codec.Intro {
+		return rr, text, false
+	}
+
+	if armor.SkipPreamble(rr) {
+		return bufio.NewReader(armor.NewReader(rr)), text, true
+	}
+
+	return rr, text, false
+}
+
+// utf16Reader converts little endian UTF-16 text back to ASCII. Anything
+// else is reported as ErrUTF16Input, since binary data can't survive the
+// conversion to UTF-16.
+type utf16Reader struct {
+	r   *bufio.Reader
+	err error
+}
+
+// check returns ErrUTF16Input in place of err if the reader found data that
+// isn't ASCII text. age doesn't wrap the errors of its source, so they are
+// lost otherwise. It is safe to call check on a nil reader.
+func (u *utf16Reader) check(err error) error {
+	if u != nil && u.err != nil {
+		return u.err
+	}
+	return err
+}
+
+// Read implements the io.Reader interface.
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	var n int
+	for n < len(p) {
+		lo, err := u.r.ReadByte()
+		if err != nil {
+			if n > 0 && err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+
+		hi, err := u.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+
+		if hi != 0 || lo > 0x7f {
+			u.err = ErrUTF16Input
+			return n, u.err
+		}
+
+		p[n] = lo
+		n++
+
+		// Avoid blocking on the source once some data is available.
+		if u.r.Buffered() < 2 {
+			break
+		}
+	}
+
+	return n, nil
+}
+
+// =============================================================================
+
+// TimeLock encrypts the specified data for the given round number. The data
+// can't be decrypted until the specified round is reached by the network in use.
+func TimeLock(publicKey kyber.Point, roundNumber uint64, data []byte) (*ibe.Ciphertext, error) {
+	return EncryptToIdentity(publicKey, roundIdentity(roundNumber), data)
 }
 
 // TimeUnlock decrypts the specified ciphertext for the given beacon. The
@@ -122,12 +1037,44 @@ func TimeUnlock(publicKey kyber.Point, beacon chain.Beacon, ciphertext *ibe.Ciph
 		return nil, fmt.Errorf("verify beacon: %w", err)
 	}
 
-	var signature bls.KyberG2
-	if err := signature.UnmarshalBinary(beacon.Signature); err != nil {
+	return decryptWithSignature(beacon.Signature, ciphertext)
+}
+
+// EncryptToIdentity encrypts the specified data so it can only be decrypted
+// with the signature of the network over the identity. The identity is the
+// message the network signs; for rounds this is the digest of the round
+// number, which is what TimeLock uses. This allows time locking to other
+// identities the network signs.
+func EncryptToIdentity(publicKey kyber.Point, identity []byte, data []byte) (*ibe.Ciphertext, error) {
+	cipherText, err := ibe.Encrypt(bls.NewBLS12381Suite(), publicKey, identity, data)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt data: %w", err)
+	}
+
+	return cipherText, nil
+}
+
+// DecryptWithSignature decrypts a ciphertext produced by EncryptToIdentity
+// with the signature of the network over the same identity. The signature is
+// verified against the public key before it is used.
+func DecryptWithSignature(publicKey kyber.Point, identity []byte, signature []byte, ciphertext *ibe.Ciphertext) ([]byte, error) {
+	suite := bls.NewBLS12381Suite()
+	if err := sign.NewSchemeOnG2(suite).Verify(publicKey, identity, signature); err != nil {
+		return nil, fmt.Errorf("verify signature: %w", err)
+	}
+
+	return decryptWithSignature(signature, ciphertext)
+}
+
+// decryptWithSignature decrypts the ciphertext with a signature that was
+// already verified.
+func decryptWithSignature(signature []byte, ciphertext *ibe.Ciphertext) ([]byte, error) {
+	var point bls.KyberG2
+	if err := point.UnmarshalBinary(signature); err != nil {
 		return nil, fmt.Errorf("unmarshal kyber G2: %w", err)
 	}
 
-	data, err := ibe.Decrypt(bls.NewBLS12381Suite(), &signature, ciphertext)
+	data, err := ibe.Decrypt(bls.NewBLS12381Suite(), &point, ciphertext)
 	if err != nil {
 		return nil, fmt.Errorf("decrypt dek: %w", err)
 	}
@@ -135,6 +1082,13 @@ func TimeUnlock(publicKey kyber.Point, beacon chain.Beacon, ciphertext *ibe.Ciph
 	return data, nil
 }
 
+// roundIdentity returns the identity the network signs for a round of an
+// unchained chain.
+func roundIdentity(roundNumber uint64) []byte {
+	h := sha256.Sum256(chain.RoundToBytes(roundNumber))
+	return h[:]
+}
+
 // =============================================================================
 
 // These constants define the size of the different CipherDEK fields.
@@ -151,10 +1105,10 @@ func CiphertextToBytes(ciphertext *ibe.Ciphertext) ([]byte, error) {
 		return nil, fmt.Errorf("marshal kyber point: %w", err)
 	}
 
-	b := make([]byte, kyberPointLen+cipherVLen+cipherWLen)
+	b := make([]byte, len(kyberPoint)+cipherVLen+cipherWLen)
 	copy(b, kyberPoint)
-	copy(b[kyberPointLen:], ciphertext.V)
-	copy(b[kyberPointLen+cipherVLen:], ciphertext.W)
+	copy(b[len(kyberPoint):], ciphertext.V)
+	copy(b[len(kyberPoint)+cipherVLen:], ciphertext.W)
 
 	return b, nil
 }
@@ -1,26 +1,75 @@
 package tlock
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"sort"
 	"strconv"
+	"strings"
 
 	"filippo.io/age"
-	"github.com/drand/drand/chain"
+	"github.com/drand/kyber"
+	"github.com/drand/tlock/internal/codec"
+	"github.com/drand/tlock/networks"
 )
 
 // tleRecipient implements the age Recipient interface. This is used to encrypt
 // data with the age Encrypt API.
 type tleRecipient struct {
 	network     Network
+	scheme      *timelockScheme
 	roundNumber uint64
+	fileMode    fs.FileMode
+	aead        AEAD
+	chunkSize   int
+	compact     bool
+	aad         bool
+	contentType string
+	extensions  map[string]string
+	hints       []string
+	digest      []byte
+	digestKeyed bool
+
+	// sigma is the source of the random element of the time lock encryption
+	// when it has to be deterministic.
+	sigma io.Reader
+
+	// chainHash and pairing are the hash of the chain and the pairing of the
+	// public key with the identity of the round, if they were computed
+	// beforehand.
+	chainHash string
+	pairing   kyber.Point
+
+	// hardened bypasses the round cache of the scheme.
+	hardened bool
 }
 
 // Wrap is called by the age Encrypt API and is provided the DEK generated by
 // age that is used for encrypting/decrypting data. Inside of Wrap we encrypt
 // the DEK using time lock encryption.
 func (t *tleRecipient) Wrap(fileKey []byte) ([]*age.Stanza, error) {
-	ciphertext, err := TimeLock(t.network.PublicKey(), t.roundNumber, fileKey)
+	sch := t.scheme
+	if sch == nil {
+		sch = schemes[SchemeUnchained]
+	}
+
+	chainHash := t.chainHash
+	if chainHash == "" {
+		chainHash = t.network.ChainHash()
+	}
+
+	gid := t.pairing
+	if gid == nil {
+		var err error
+		if gid, err = sch.cachedRounds(t.hardened).pairing(sch, chainHash, t.network.PublicKey(), t.roundNumber); err != nil {
+			return nil, fmt.Errorf("encrypt dek: %w", err)
+		}
+	}
+
+	ciphertext, err := sch.timeLockToPairing(gid, fileKey, t.sigma)
 	if err != nil {
 		return nil, fmt.Errorf("encrypt dek: %w", err)
 	}
@@ -30,12 +79,69 @@ func (t *tleRecipient) Wrap(fileKey []byte) ([]*age.Stanza, error) {
 		return nil, fmt.Errorf("bytes: %w", err)
 	}
 
+	version := sch.version
+	if t.compact && version < compactVersion {
+		version = compactVersion
+	}
+	if (t.digest != nil || len(t.hints) > 0) && version < metadataVersion {
+		version = metadataVersion
+	}
+
 	stanza := age.Stanza{
 		Type: "tlock",
-		Args: []string{strconv.FormatUint(t.roundNumber, 10), t.network.ChainHash()},
+		Args: []string{strconv.FormatUint(t.roundNumber, 10), chainHash, codec.Arg("v", strconv.Itoa(version))},
 		Body: body,
 	}
 
+	if sch.id != SchemeUnchained {
+		stanza.Args = append(stanza.Args, codec.Arg("scheme", sch.id))
+	}
+
+	if t.fileMode != 0 {
+		stanza.Args = append(stanza.Args, codec.Arg("mode", strconv.FormatUint(uint64(t.fileMode.Perm()), 8)))
+	}
+
+	// The payload settings are only recorded when they differ from the
+	// defaults, which keeps the data compatible with age.
+	if t.aead != "" && t.aead != ChaCha20Poly1305 {
+		stanza.Args = append(stanza.Args, codec.Arg("aead", string(t.aead)))
+	}
+
+	switch {
+	case t.compact:
+		stanza.Args = append(stanza.Args, codec.Arg("compact", "1"))
+	case t.chunkSize != 0 && t.chunkSize != DefaultChunkSize:
+		stanza.Args = append(stanza.Args, codec.Arg("chunk", strconv.Itoa(t.chunkSize)))
+	}
+
+	if t.aad {
+		stanza.Args = append(stanza.Args, codec.Arg("aad", "1"))
+	}
+
+	if t.contentType != "" {
+		stanza.Args = append(stanza.Args, codec.Arg("content", t.contentType))
+	}
+
+	if t.digest != nil {
+		stanza.Args = append(stanza.Args, codec.Arg("digest", formatDigest(t.digest, t.digestKeyed)))
+	}
+
+	if len(t.hints) > 0 {
+		stanza.Args = append(stanza.Args, codec.Arg("hints", formatEndpointHints(t.hints)))
+	}
+
+	// Extensions are sorted so the header doesn't depend on the order of the
+	// options.
+	keys := make([]string, 0, len(t.extensions))
+	for key := range t.extensions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		stanza.Args = append(stanza.Args, codec.Arg(extensionPrefix+key, codec.ValueEncoding.EncodeToString([]byte(t.extensions[key]))))
+	}
+
 	return []*age.Stanza{&stanza}, nil
 }
 
@@ -44,7 +150,16 @@ func (t *tleRecipient) Wrap(fileKey []byte) ([]*age.Stanza, error) {
 // tleIdentity implements the age Identity interface. This is used to decrypt
 // data with the age Decrypt API.
 type tleIdentity struct {
+	ctx     context.Context
 	network Network
+	lenient bool
+	strict  bool
+	cache   *SignatureCache
+	group   *signatureGroup
+	source  networks.BeaconSource
+
+	// hardened bypasses the round cache of the scheme.
+	hardened bool
 }
 
 // Unwrap is called by the age Decrypt API and is provided the DEK that was time
@@ -52,47 +167,391 @@ type tleIdentity struct {
 // the DEK and provide back to age.
 func (t *tleIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
 	if len(stanzas) != 1 {
-		return nil, errors.New("check stanzas length: should be one")
+		return nil, fmt.Errorf("%w: check stanzas length: should be one", ErrMalformedHeader)
 	}
 
 	stanza := stanzas[0]
 
+	header, err := parseStanza(stanza, t.strict)
+	if err != nil {
+		return nil, err
+	}
+	roundNumber := header.RoundNumber
+
+	if !t.lenient && t.network.ChainHash() != header.ChainHash {
+		return nil, &WrongChainError{
+			HeaderChainHash:  header.ChainHash,
+			NetworkChainHash: t.network.ChainHash(),
+		}
+	}
+
+	sch, err := networkScheme(t.network)
+	if err != nil {
+		return nil, err
+	}
+	if sch.id != header.Scheme {
+		return nil, fmt.Errorf("%w: the data uses scheme %s but the network uses scheme %s", ErrWrongChain, header.Scheme, sch.id)
+	}
+
+	ciphertext, err := sch.ciphertext(stanza.Body)
+	if err != nil {
+		return nil, fmt.Errorf("parse cipher dek: %w", err)
+	}
+
+	signature, cached := t.cache.get(t.network.ChainHash(), roundNumber)
+	if !cached {
+		if signature, err = t.signature(roundNumber); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil, fmt.Errorf("signature: %w", err)
+			}
+			return nil, fmt.Errorf("signature: %w", signatureError{err: err})
+		}
+	}
+
+	fileKey, err := sch.timeUnlock(sch.cachedRounds(t.hardened), t.network.ChainHash(), t.network.PublicKey(), roundNumber, signature, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt dek: %w", err)
+	}
+
+	if !cached {
+		t.cache.add(t.network.ChainHash(), roundNumber, signature)
+	}
+
+	return fileKey, nil
+}
+
+// signatureError reports a signature that couldn't be retrieved from the
+// network. It matches ErrTooEarly, since networks fail the same way before
+// the round is reached, and unwraps to the error of the network, which tells
+// the endpoint that failed.
+type signatureError struct {
+	err error
+}
+
+// Error implements the error interface.
+func (e signatureError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrTooEarly, e.err)
+}
+
+// Is reports whether the target is ErrTooEarly.
+func (e signatureError) Is(target error) bool {
+	return target == ErrTooEarly
+}
+
+// Unwrap returns the error of the network.
+func (e signatureError) Unwrap() error {
+	return e.err
+}
+
+// signature retrieves the signature for the round from the network. The
+// identities of a batch share the signatures they retrieve.
+func (t *tleIdentity) signature(roundNumber uint64) ([]byte, error) {
+	if t.group == nil {
+		return t.fetch(roundNumber)
+	}
+
+	return t.group.do(roundNumber, func() ([]byte, error) {
+		return t.fetch(roundNumber)
+	})
+}
+
+// fetch retrieves the signature for the round from the beacon source, or from
+// the network if there is none, honoring the identity's context when the
+// network supports it.
+func (t *tleIdentity) fetch(roundNumber uint64) ([]byte, error) {
+	if t.source != nil {
+		ctx := t.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		return t.source.GetBeacon(ctx, roundNumber)
+	}
+
+	cn, ok := t.network.(ContextNetwork)
+	if !ok || t.ctx == nil {
+		return t.network.Signature(roundNumber)
+	}
+
+	return cn.SignatureContext(t.ctx, roundNumber)
+}
+
+// =============================================================================
+
+// validContentType reports whether the content type can be recorded in the
+// stanza arguments. An empty content type isn't recorded.
+func validContentType(contentType string) bool {
+	for _, c := range contentType {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && !strings.ContainsRune(".+-", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// These constants define the limits of the extension area of the header,
+// which keep the stanza arguments on a single header line.
+const (
+	MaxExtensions       = 16
+	MaxExtensionKeySize = 32
+	MaxExtensionSize    = 128
+)
+
+// extensionPrefix marks the stanza arguments of the extension area.
+const extensionPrefix = "x-"
+
+// validExtensionKey reports whether the key can be recorded in the extension
+// area.
+func validExtensionKey(key string) bool {
+	if key == "" || len(key) > MaxExtensionKeySize || key[0] < 'a' || key[0] > 'z' {
+		return false
+	}
+
+	for _, c := range key {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// checkExtensions validates the extensions recorded when encrypting.
+func checkExtensions(extensions map[string]string) error {
+	if len(extensions) > MaxExtensions {
+		return fmt.Errorf("%d extensions exceed the limit of %d", len(extensions), MaxExtensions)
+	}
+
+	for key, value := range extensions {
+		if !validExtensionKey(key) {
+			return fmt.Errorf("invalid extension key %q", key)
+		}
+		if len(value) > MaxExtensionSize {
+			return fmt.Errorf("extension %q of %d bytes exceeds the limit of %d", key, len(value), MaxExtensionSize)
+		}
+	}
+
+	return nil
+}
+
+// parseStanza validates a tlock stanza and extracts its time lock information.
+// A strict parse also rejects unknown arguments and the values that tlock
+// doesn't write, such as numbers with leading zeros or the default settings.
+func parseStanza(stanza *age.Stanza, strict bool) (Header, error) {
+	return parseStanzaUpTo(stanza, strict, FormatVersion)
+}
+
+// parseStanzaUpTo parses a tlock stanza like a version of tlock supporting
+// formats up to maxVersion, which lets tests check how older versions handle
+// newer data.
+func parseStanzaUpTo(stanza *age.Stanza, strict bool, maxVersion int) (Header, error) {
 	if stanza.Type != "tlock" {
-		return nil, fmt.Errorf("check stanza type: wrong type: %w", age.ErrIncorrectIdentity)
+		return Header{}, fmt.Errorf("check stanza type: wrong type: %w", age.ErrIncorrectIdentity)
 	}
 
-	if len(stanza.Args) != 2 {
-		return nil, fmt.Errorf("check stanza args: should be two: %w", age.ErrIncorrectIdentity)
+	if len(stanza.Args) < 2 {
+		return Header{}, fmt.Errorf("check stanza args: should be at least two: %w", age.ErrIncorrectIdentity)
 	}
 
 	roundNumber, err := strconv.ParseUint(stanza.Args[0], 10, 64)
 	if err != nil {
-		return nil, fmt.Errorf("parse block round: %w", err)
+		return Header{}, fmt.Errorf("%w: parse block round: %v", ErrMalformedHeader, err)
 	}
-
-	if t.network.ChainHash() != stanza.Args[1] {
-		return nil, errors.New("wrong chainhash")
+	if strict && stanza.Args[0] != strconv.FormatUint(roundNumber, 10) {
+		return Header{}, fmt.Errorf("%w: parse block round: non-canonical round %q", ErrMalformedHeader, stanza.Args[0])
 	}
 
-	ciphertext, err := BytesToCiphertext(stanza.Body)
+	version, err := stanzaVersion(stanza.Args[2:], maxVersion)
 	if err != nil {
-		return nil, fmt.Errorf("parse cipher dek: %w", err)
+		return Header{}, err
 	}
 
-	signature, err := t.network.Signature(roundNumber)
+	header := Header{
+		Version:     version,
+		RoundNumber: roundNumber,
+		ChainHash:   stanza.Args[1],
+		AEAD:        ChaCha20Poly1305,
+		ChunkSize:   DefaultChunkSize,
+		Scheme:      SchemeUnchained,
+	}
+
+	// Any additional arguments are optional key=value pairs. Unknown keys
+	// are ignored so newer versions can add information, unless the parse is
+	// strict, but keys can't be repeated.
+	err = codec.ParseArgs(stanza.Args[2:], func(key string, value string) error {
+		return parseArg(&header, key, value, strict)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("signature: %w", ErrTooEarly)
+		return Header{}, err
 	}
 
-	beacon := chain.Beacon{
-		Round:     roundNumber,
-		Signature: signature,
+	// A compact payload is a single chunk of up to MaxCompactSize bytes.
+	if header.Compact {
+		if header.ChunkSize != DefaultChunkSize {
+			return Header{}, fmt.Errorf("%w: check stanza args: compact payload with a chunk size", ErrMalformedHeader)
+		}
+		header.ChunkSize = MaxCompactSize
 	}
 
-	fileKey, err := TimeUnlock(t.network.PublicKey(), beacon, ciphertext)
+	return header, nil
+}
+
+// parseArg adds a key=value argument of a tlock stanza to the header, whose
+// version was already set.
+func parseArg(header *Header, key string, value string, strict bool) error {
+	if strings.HasPrefix(key, extensionPrefix) {
+		if err := parseExtension(header, strings.TrimPrefix(key, extensionPrefix), value); err != nil {
+			return fmt.Errorf("%w: check stanza args: %v", ErrMalformedHeader, err)
+		}
+		return nil
+	}
+
+	arg := codec.Arg(key, value)
+	version := header.Version
+
+	switch key {
+	case "v":
+		// Checked by stanzaVersion before the other arguments.
+		if strict && value != strconv.Itoa(version) {
+			return nonCanonical(arg)
+		}
+
+	case "scheme":
+		sch, err := schemeByID(value)
+		if err != nil {
+			return fmt.Errorf("%w: check stanza args: %v", ErrMalformedHeader, err)
+		}
+		if version < sch.version {
+			return fmt.Errorf("%w: check stanza args: scheme %s requires format v%d", ErrMalformedHeader, value, sch.version)
+		}
+		if strict && value == SchemeUnchained {
+			return nonCanonical(arg)
+		}
+		header.Scheme = value
+
+	case "mode":
+		mode, err := strconv.ParseUint(value, 8, 32)
+		if err != nil || fs.FileMode(mode) != fs.FileMode(mode).Perm() {
+			return fmt.Errorf("%w: check stanza args: invalid mode %q", ErrMalformedHeader, value)
+		}
+		if strict && value != strconv.FormatUint(mode, 8) {
+			return nonCanonical(arg)
+		}
+		header.FileMode = fs.FileMode(mode)
+
+	case "aead":
+		if strict && AEAD(value) != AES256GCM {
+			return nonCanonical(arg)
+		}
+		header.AEAD = AEAD(value)
+
+	case "chunk":
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 || size > MaxChunkSize {
+			return fmt.Errorf("%w: check stanza args: invalid chunk size %q", ErrMalformedHeader, value)
+		}
+		if strict && (value != strconv.Itoa(size) || size == DefaultChunkSize) {
+			return nonCanonical(arg)
+		}
+		header.ChunkSize = size
+
+	case "compact":
+		if version < compactVersion {
+			return fmt.Errorf("%w: check stanza args: compact payload requires format v%d", ErrMalformedHeader, compactVersion)
+		}
+		if strict && value != "1" {
+			return nonCanonical(arg)
+		}
+		header.Compact = value == "1"
+
+	case "aad":
+		if strict && value != "1" {
+			return nonCanonical(arg)
+		}
+		header.AAD = value == "1"
+
+	case "content":
+		if value == "" || !validContentType(value) {
+			return fmt.Errorf("%w: check stanza args: invalid content type %q", ErrMalformedHeader, value)
+		}
+		header.ContentType = value
+
+	case "digest":
+		if version < metadataVersion {
+			return fmt.Errorf("%w: check stanza args: content digest requires format v%d", ErrMalformedHeader, metadataVersion)
+		}
+		if err := parseDigest(header, value); err != nil {
+			return fmt.Errorf("%w: check stanza args: %v", ErrMalformedHeader, err)
+		}
+
+	case "hints":
+		if version < metadataVersion {
+			return fmt.Errorf("%w: check stanza args: endpoint hints require format v%d", ErrMalformedHeader, metadataVersion)
+		}
+		if err := parseEndpointHints(header, value); err != nil {
+			return fmt.Errorf("%w: check stanza args: %v", ErrMalformedHeader, err)
+		}
+
+	default:
+		if strict {
+			return fmt.Errorf("%w: check stanza args: unknown argument %q", ErrMalformedHeader, key)
+		}
+	}
+
+	return nil
+}
+
+// nonCanonical returns the error of a strict parse for an argument whose value
+// isn't the one tlock writes for it.
+func nonCanonical(arg string) error {
+	return fmt.Errorf("%w: check stanza args: non-canonical argument %q", ErrMalformedHeader, arg)
+}
+
+// stanzaVersion returns the format version recorded in the stanza arguments.
+// Data without a version predates versioning and uses the first version. The
+// version is checked before the other arguments, whose meaning can change in
+// newer versions, so a version above maxVersion fails with a VersionError
+// before an argument it introduced is reported as unknown.
+func stanzaVersion(args []string, maxVersion int) (int, error) {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "v=") {
+			continue
+		}
+
+		version, err := strconv.Atoi(arg[len("v="):])
+		if err != nil || version < MinFormatVersion {
+			return 0, fmt.Errorf("%w: check stanza args: invalid version %q", ErrMalformedHeader, arg[len("v="):])
+		}
+		if version > maxVersion {
+			return 0, &VersionError{Version: version}
+		}
+
+		return version, nil
+	}
+
+	return MinFormatVersion, nil
+}
+
+// parseExtension adds an extension of the stanza arguments to the header,
+// following the same rules as when encrypting.
+func parseExtension(header *Header, key string, encoded string) error {
+	if !validExtensionKey(key) {
+		return fmt.Errorf("invalid extension key %q", key)
+	}
+
+	value, err := codec.ValueEncoding.DecodeString(encoded)
 	if err != nil {
-		return nil, fmt.Errorf("decrypt dek: %w", err)
+		return fmt.Errorf("invalid extension %q: %w", key, err)
+	}
+	if len(value) > MaxExtensionSize {
+		return fmt.Errorf("extension %q of %d bytes exceeds the limit of %d", key, len(value), MaxExtensionSize)
 	}
 
-	return fileKey, nil
+	if header.Extensions == nil {
+		header.Extensions = make(map[string]string)
+	}
+	if len(header.Extensions) == MaxExtensions {
+		return fmt.Errorf("extensions exceed the limit of %d", MaxExtensions)
+	}
+	header.Extensions[key] = string(value)
+
+	return nil
 }
@@ -3,9 +3,14 @@ package tlock
 import (
 	"bytes"
 	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
 	"testing"
 	"time"
 
+	"filippo.io/age"
+	"github.com/drand/tlock/internal/fakenet"
 	"github.com/drand/tlock/networks/http"
 )
 
@@ -49,3 +54,282 @@ func Test_WrapUnwrap(t *testing.T) {
 		t.Fatalf("decrypted filekey is invalid; expected %d; got %d", len(b), len(fileKey))
 	}
 }
+
+func Test_AgeCompatibility(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	plain := bytes.Repeat([]byte("tlock and age share a format\n"), 5000)
+
+	t.Run("tlock to age", func(t *testing.T) {
+		var cipherData bytes.Buffer
+		if err := New(network).Encrypt(&cipherData, bytes.NewReader(plain), 10); err != nil {
+			t.Fatalf("encrypt error %s", err)
+		}
+
+		r, err := age.Decrypt(&cipherData, &tleIdentity{network: network})
+		if err != nil {
+			t.Fatalf("age decrypt error %s", err)
+		}
+
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("age read error %s", err)
+		}
+
+		if !bytes.Equal(b, plain) {
+			t.Fatalf("decrypted data is invalid; expected %d bytes; got %d bytes", len(plain), len(b))
+		}
+	})
+
+	t.Run("small tlock to age", func(t *testing.T) {
+		message := []byte("reveal: 42")
+
+		var cipherData bytes.Buffer
+		if err := New(network).EncryptFrom(&cipherData, bytes.NewReader(message), int64(len(message)), 10); err != nil {
+			t.Fatalf("encrypt error %s", err)
+		}
+
+		r, err := age.Decrypt(&cipherData, &tleIdentity{network: network})
+		if err != nil {
+			t.Fatalf("age decrypt error %s", err)
+		}
+
+		b, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(b, message) {
+			t.Fatalf("expecting %q; got %q, %v", message, b, err)
+		}
+	})
+
+	t.Run("age to tlock", func(t *testing.T) {
+		var cipherData bytes.Buffer
+		w, err := age.Encrypt(&cipherData, &tleRecipient{network: network, roundNumber: 10})
+		if err != nil {
+			t.Fatalf("age encrypt error %s", err)
+		}
+		if _, err := w.Write(plain); err != nil {
+			t.Fatalf("age write error %s", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("age close error %s", err)
+		}
+
+		var plainData bytes.Buffer
+		if err := New(network).Decrypt(&plainData, &cipherData); err != nil {
+			t.Fatalf("decrypt error %s", err)
+		}
+
+		if !bytes.Equal(plainData.Bytes(), plain) {
+			t.Fatalf("decrypted data is invalid; expected %d bytes; got %d bytes", len(plain), plainData.Len())
+		}
+	})
+}
+
+// argsRecipient wraps a tleRecipient and changes the arguments of its stanza,
+// which age authenticates like any other header.
+type argsRecipient struct {
+	tleRecipient
+	change func(args []string) []string
+}
+
+func (r *argsRecipient) Wrap(fileKey []byte) ([]*age.Stanza, error) {
+	stanzas, err := r.tleRecipient.Wrap(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	stanzas[0].Args = r.change(stanzas[0].Args)
+
+	return stanzas, nil
+}
+
+func Test_StrictParsing(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	appendArgs := func(extra ...string) func([]string) []string {
+		return func(args []string) []string { return append(args, extra...) }
+	}
+
+	tests := map[string]struct {
+		change    func([]string) []string
+		canonical bool
+	}{
+		"canonical":        {change: appendArgs("mode=600", "content=tar", "x-note=bm90ZQ"), canonical: true},
+		"unknown argument": {change: appendArgs("future=1")},
+		"default aead":     {change: appendArgs("aead=chacha20poly1305")},
+		"default chunk":    {change: appendArgs("chunk=65536")},
+		"default scheme":   {change: appendArgs("scheme=" + SchemeUnchained)},
+		"padded mode":      {change: appendArgs("mode=0600")},
+		"padded version":   {change: func(args []string) []string { return append(args[:2], "v=01") }},
+		"padded round":     {change: func(args []string) []string { return append([]string{"010"}, args[1:]...) }},
+		"aad value":        {change: appendArgs("aad=0")},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			recipient := argsRecipient{
+				tleRecipient: tleRecipient{network: network, roundNumber: 10},
+				change:       tt.change,
+			}
+
+			var cipherData bytes.Buffer
+			w, err := age.Encrypt(&cipherData, &recipient)
+			if err != nil {
+				t.Fatalf("age encrypt error %s", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("age close error %s", err)
+			}
+
+			if err := New(network).Decrypt(io.Discard, bytes.NewReader(cipherData.Bytes())); err != nil {
+				t.Fatalf("lenient decrypt error %s", err)
+			}
+
+			err = New(network, WithStrictParsing(true)).Decrypt(io.Discard, bytes.NewReader(cipherData.Bytes()))
+			switch {
+			case tt.canonical && err != nil:
+				t.Fatalf("strict decrypt error %s", err)
+			case !tt.canonical && !errors.Is(err, ErrMalformedHeader):
+				t.Fatalf("expecting error %v; got %v", ErrMalformedHeader, err)
+			}
+		})
+	}
+}
+
+// Test_MetadataVersion checks that data recording a content digest or
+// endpoint hints fails with a version error, not an unknown argument, in a
+// strict reader that predates them.
+func Test_MetadataVersion(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+
+	tests := map[string]tleRecipient{
+		"digest": {network: network, roundNumber: 10, digest: make([]byte, 32)},
+		"hints":  {network: network, roundNumber: 10, hints: []string{"https://api.drand.sh"}},
+	}
+
+	for name, recipient := range tests {
+		t.Run(name, func(t *testing.T) {
+			stanzas, err := recipient.Wrap(make([]byte, 16))
+			if err != nil {
+				t.Fatalf("wrap error %s", err)
+			}
+
+			header, err := parseStanza(stanzas[0], true)
+			if err != nil {
+				t.Fatalf("strict parse error %s", err)
+			}
+			if header.Version != metadataVersion {
+				t.Fatalf("expecting version %d; got %d", metadataVersion, header.Version)
+			}
+
+			var versionErr *VersionError
+			if _, err := parseStanzaUpTo(stanzas[0], true, compactVersion); !errors.As(err, &versionErr) || versionErr.Version != metadataVersion {
+				t.Fatalf("expecting a version error; got %v", err)
+			}
+
+			// Recording an older version doesn't make the arguments valid.
+			stanzas[0].Args[2] = fmt.Sprintf("v=%d", compactVersion)
+			if _, err := parseStanza(stanzas[0], false); !errors.Is(err, ErrMalformedHeader) {
+				t.Fatalf("expecting error %v; got %v", ErrMalformedHeader, err)
+			}
+		})
+	}
+}
+
+func Test_RoundCache(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	sch, err := networkScheme(network)
+	if err != nil {
+		t.Fatalf("scheme error %s", err)
+	}
+
+	first, err := sch.rounds.pairing(sch, network.ChainHash(), network.PublicKey(), 10)
+	if err != nil {
+		t.Fatalf("pairing error %s", err)
+	}
+	second, err := sch.rounds.pairing(sch, network.ChainHash(), network.PublicKey(), 10)
+	if err != nil {
+		t.Fatalf("pairing error %s", err)
+	}
+	if first != second {
+		t.Fatalf("expecting the cached pairing")
+	}
+
+	signature, err := network.Signature(10)
+	if err != nil {
+		t.Fatalf("signature error %s", err)
+	}
+	other, err := network.Signature(11)
+	if err != nil {
+		t.Fatalf("signature error %s", err)
+	}
+
+	// Only the signature that was verified is accepted without verification.
+	for i, tt := range []struct {
+		signature []byte
+		valid     bool
+	}{{signature, true}, {other, false}, {signature, true}} {
+		err := sch.rounds.verify(sch, network.ChainHash(), network.PublicKey(), 10, tt.signature)
+		if tt.valid != (err == nil) {
+			t.Fatalf("verification %d: expecting valid %t; got %v", i, tt.valid, err)
+		}
+	}
+
+	// The least recently used round is evicted.
+	cache := newRoundCache()
+	for round := uint64(1); round <= roundCacheSize+1; round++ {
+		cache.entry(network.ChainHash(), round)
+	}
+	if cache.order.Len() != roundCacheSize {
+		t.Fatalf("expecting %d rounds; got %d", roundCacheSize, cache.order.Len())
+	}
+	if _, exists := cache.entries[cacheKey{network.ChainHash(), 1}]; exists {
+		t.Fatalf("expecting round 1 to be evicted")
+	}
+}
+
+// Benchmark_RoundCache compares encrypting and decrypting short messages
+// locked to the same round with and without the pairing and verified
+// signature of the round cached. On a single core, caching takes encryptions
+// from about 4.8ms to 1.7ms and decryptions from about 7.0ms to 3.8ms.
+func Benchmark_RoundCache(b *testing.B) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	sch, err := networkScheme(network)
+	if err != nil {
+		b.Fatalf("scheme error %s", err)
+	}
+	defer func(rounds *roundCache) { sch.rounds = rounds }(sch.rounds)
+
+	var cipherData bytes.Buffer
+	if err := New(network).Encrypt(&cipherData, bytes.NewReader([]byte("commit: 42")), 10); err != nil {
+		b.Fatalf("encrypt error %s", err)
+	}
+
+	for _, cached := range []bool{false, true} {
+		b.Run(fmt.Sprintf("encrypt/cached=%t", cached), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if !cached {
+					sch.rounds = newRoundCache()
+				}
+				if err := New(network).Encrypt(io.Discard, bytes.NewReader([]byte("commit: 42")), 10); err != nil {
+					b.Fatalf("encrypt error %s", err)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("decrypt/cached=%t", cached), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if !cached {
+					sch.rounds = newRoundCache()
+				}
+				if err := New(network).Decrypt(io.Discard, bytes.NewReader(cipherData.Bytes())); err != nil {
+					b.Fatalf("decrypt error %s", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package tlock
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// BatchItem is an encrypted message decrypted by DecryptBatch.
+type BatchItem struct {
+	Src io.Reader
+	Dst io.Writer
+}
+
+// BatchResult describes the decryption of a batch item. The header is set
+// whenever it could be read, so items that failed with ErrTooEarly can be
+// scheduled again for their round.
+type BatchResult struct {
+	Header Header
+	Err    error
+}
+
+// DecryptBatch decrypts many messages at once, such as a backlog drained
+// from a queue after their unlock time. The items are grouped by round and
+// the signature of every round is retrieved from the network once, while the
+// items are decrypted concurrently. The results are in the order of the
+// items, and the failure of an item doesn't affect the others.
+func (t Tlock) DecryptBatch(ctx context.Context, items []BatchItem) []BatchResult {
+	results := make([]BatchResult, len(items))
+	sources := make([]io.Reader, len(items))
+
+	var order []int
+	for i, item := range items {
+		var buf bytes.Buffer
+		header, err := ReadHeader(io.TeeReader(item.Src, &buf))
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		results[i].Header = header
+		sources[i] = io.MultiReader(&buf, item.Src)
+		order = append(order, i)
+	}
+
+	sort.SliceStable(order, func(a, b int) bool {
+		return results[order[a]].Header.RoundNumber < results[order[b]].Header.RoundNumber
+	})
+
+	t.signatures = &signatureGroup{calls: make(map[uint64]*signatureCall)}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0) && w < len(order); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i].Err = t.DecryptContext(ctx, items[i].Dst, sources[i])
+			}
+		}()
+	}
+
+	for _, i := range order {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// =============================================================================
+
+// signatureGroup retrieves the signature of every round once for the
+// decryptions of a batch, however many of them need it at the same time.
+type signatureGroup struct {
+	mu    sync.Mutex
+	calls map[uint64]*signatureCall
+}
+
+// signatureCall holds the outcome of retrieving the signature of a round.
+type signatureCall struct {
+	once      sync.Once
+	signature []byte
+	err       error
+}
+
+// do returns the signature of the round, calling fetch the first time the
+// round is requested and waiting for that call otherwise.
+func (g *signatureGroup) do(roundNumber uint64, fetch func() ([]byte, error)) ([]byte, error) {
+	g.mu.Lock()
+	c, exists := g.calls[roundNumber]
+	if !exists {
+		c = &signatureCall{}
+		g.calls[roundNumber] = c
+	}
+	g.mu.Unlock()
+
+	c.once.Do(func() {
+		c.signature, c.err = fetch()
+	})
+
+	return c.signature, c.err
+}
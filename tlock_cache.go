@@ -0,0 +1,125 @@
+package tlock
+
+import (
+	"container/list"
+	"sync"
+)
+
+// SignatureCache keeps the signatures of recently decrypted rounds in memory,
+// so decrypting many messages locked to the same round retrieves its
+// signature from the network once. When the cache is full, the least
+// recently used signature is evicted. A cache can be shared by tlocks used
+// concurrently.
+type SignatureCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[cacheKey]*list.Element
+	stats   CacheStats
+}
+
+// CacheStats describes the use of a signature cache.
+type CacheStats struct {
+	Entries   int    `json:"entries"`
+	Size      int    `json:"size"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// cacheKey identifies the signature of a round of a chain.
+type cacheKey struct {
+	chainHash   string
+	roundNumber uint64
+}
+
+// cacheEntry is the value of the elements of the eviction order.
+type cacheEntry struct {
+	key       cacheKey
+	signature []byte
+}
+
+// NewSignatureCache constructs a cache holding the signatures of at most the
+// specified number of rounds.
+func NewSignatureCache(size int) *SignatureCache {
+	if size < 1 {
+		size = 1
+	}
+
+	return &SignatureCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[cacheKey]*list.Element),
+	}
+}
+
+// WithSignatureCache sets the cache used to look up signatures before
+// retrieving them from the network. Only signatures that decrypted data are
+// added to the cache. Nothing is cached by default.
+func WithSignatureCache(cache *SignatureCache) Option {
+	return func(t *Tlock) {
+		t.cache = cache
+	}
+}
+
+// Stats returns the number of cached signatures and how often the cache was
+// used.
+func (c *SignatureCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	stats.Size = c.size
+
+	return stats
+}
+
+// get returns the cached signature of the round of the chain, if any. It
+// reports a miss on a nil cache without counting it.
+func (c *SignatureCache) get(chainHash string, roundNumber uint64) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, exists := c.entries[cacheKey{chainHash, roundNumber}]
+	if !exists {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.stats.Hits++
+	c.order.MoveToFront(e)
+
+	return e.Value.(*cacheEntry).signature, true
+}
+
+// add caches the signature of the round of the chain, evicting the least
+// recently used signature if the cache is full. It does nothing on a nil
+// cache.
+func (c *SignatureCache) add(chainHash string, roundNumber uint64, signature []byte) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cacheKey{chainHash, roundNumber}
+	if e, exists := c.entries[key]; exists {
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, signature: signature})
+
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		c.stats.Evictions++
+	}
+}
@@ -0,0 +1,56 @@
+package tlock
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// codeEncoding is the encoding of unlock codes, which leaves out the letters
+// easily mistaken for digits when read aloud or copied by hand.
+var codeEncoding = base32.NewEncoding("0123456789ABCDEFGHJKMNPQRSTVWXYZ").WithPadding(base32.NoPadding)
+
+// These constants define the layout of unlock codes, three groups of four
+// characters holding 60 bits of the digest.
+const (
+	codeGroups    = 3
+	codeGroupSize = 4
+)
+
+// UnlockCode reads the header of the source and returns a short code, such as
+// "K4QF-7XNM-2RBD", that two parties can compare out of band to confirm they
+// hold the same encrypted data without decrypting it. The code is derived
+// from the round, the chain hash and the rest of the header, which is unique
+// to every encryption, so data encrypted twice for the same round has
+// different codes. Armored data has the same code as its binary form.
+func UnlockCode(src io.Reader) (string, error) {
+	br, text, _ := dearmor(src)
+
+	hdr, raw, err := parseHeader(br, DefaultMaxHeaderSize)
+	if err != nil {
+		return "", fmt.Errorf("read header: %w", text.check(err))
+	}
+
+	header, err := tlockHeader(hdr, false)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	io.WriteString(h, "tlock unlock code\n")
+	io.WriteString(h, strconv.FormatUint(header.RoundNumber, 10)+" "+header.ChainHash+"\n")
+	h.Write(raw)
+	h.Write(hdr.MAC)
+
+	encoded := codeEncoding.EncodeToString(h.Sum(nil))
+
+	groups := make([]string, codeGroups)
+	for i := range groups {
+		groups[i] = encoded[i*codeGroupSize : (i+1)*codeGroupSize]
+	}
+
+	return strings.Join(groups, "-"), nil
+}
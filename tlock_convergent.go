@@ -0,0 +1,153 @@
+package tlock
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/drand/kyber"
+	"github.com/drand/kyber/encrypt/ibe"
+	"github.com/drand/kyber/pairing"
+	"golang.org/x/crypto/hkdf"
+)
+
+// WithConvergentKey enables convergent encryption, which derives everything
+// that is normally random from a keyed hash of the plain data, so identical
+// data encrypted by the same tenant for the same round with the same options
+// produces identical encrypted data that object storage can deduplicate. The
+// key is the secret of the tenant and should be at least 32 random bytes.
+// The source has to implement io.Seeker, since it's read twice.
+//
+// This weakens the privacy of the data. Whoever sees the encrypted data can
+// tell when two copies hold the same plain data, and whoever holds the key
+// can confirm a guess of the plain data without waiting for the round. Only
+// use it for data that can't be guessed, or when the key is as well protected
+// as the data.
+func WithConvergentKey(key []byte) Option {
+	return func(t *Tlock) {
+		t.convergentKey = key
+	}
+}
+
+// convergentRand reads the seekable source to derive the random stream of
+// the encryption from the plain data, the round and every option recorded in
+// the header or authenticated with the payload. The source is positioned back
+// where it was.
+func (t Tlock) convergentRand(ctx context.Context, src io.Reader, roundNumber uint64) (io.Reader, error) {
+	rs, ok := src.(io.ReadSeeker)
+	if !ok {
+		return nil, errors.New("convergent encryption requires a seekable source")
+	}
+
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("seek source: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, t.convergentKey)
+	writeField := func(b []byte) {
+		var size [8]byte
+		binary.BigEndian.PutUint64(size[:], uint64(len(b)))
+		mac.Write(size[:])
+		mac.Write(b)
+	}
+
+	var settings [24]byte
+	binary.BigEndian.PutUint64(settings[:8], roundNumber)
+	binary.BigEndian.PutUint64(settings[8:16], uint64(t.chunkSize))
+	binary.BigEndian.PutUint64(settings[16:], uint64(t.fileMode.Perm()))
+	writeField(settings[:])
+	writeField([]byte(t.networkChainHash()))
+	writeField([]byte(t.aead))
+	writeField([]byte(t.contentType))
+	writeField(additionalData(t.aad))
+
+	keys := make([]string, 0, len(t.extensions))
+	for key := range t.extensions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writeField([]byte(key))
+		writeField([]byte(t.extensions[key]))
+	}
+
+	if _, err := io.Copy(mac, contextReader{ctx: ctx, r: rs}); err != nil {
+		return nil, fmt.Errorf("hash source: %w", err)
+	}
+
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek source: %w", err)
+	}
+
+	return hkdf.New(sha256.New, mac.Sum(nil), nil, []byte("tlock convergent encryption")), nil
+}
+
+// =============================================================================
+
+// pairIdentity returns the pairing of the master key with the identity, the
+// part of the identity based encryption of kyber that only depends on the
+// chain and the identity.
+func pairIdentity(s pairing.Suite, master kyber.Point, id []byte) (kyber.Point, error) {
+	hG2, ok := s.G2().Point().(kyber.HashablePoint)
+	if !ok {
+		return nil, errors.New("point needs to implement `kyber.HashablePoint`")
+	}
+
+	return s.Pair(master, hG2.Hash(id)), nil
+}
+
+// encryptWithSigma implements the identity based encryption of kyber with the
+// pairing returned by pairIdentity and the random element sigma provided by
+// the caller, which makes the encryption deterministic. The pairing isn't
+// modified, so it can be shared. The ciphertext is decrypted by ibe.Decrypt.
+func encryptWithSigma(s pairing.Suite, gid kyber.Point, msg []byte, sigma []byte) (*ibe.Ciphertext, error) {
+	if len(msg) > s.Hash().Size() || len(sigma) != len(msg) {
+		return nil, errors.New("plaintext too long for the hash function provided")
+	}
+
+	hashable, ok := s.G1().Scalar().(kyber.HashableScalar)
+	if !ok {
+		return nil, errors.New("scalar can't be created from hash")
+	}
+	h3 := s.Hash()
+	h3.Write(ibe.H3Tag())
+	h3.Write(sigma)
+	h3.Write(msg)
+	r, err := hashable.Hash(s, bytes.NewReader(h3.Sum(nil)))
+	if err != nil {
+		return nil, err
+	}
+
+	u := s.G1().Point().Mul(r, s.G1().Point().Base())
+
+	h2 := s.Hash()
+	h2.Write(ibe.H2Tag())
+	if _, err := s.GT().Point().Mul(r, gid).MarshalTo(h2); err != nil {
+		return nil, err
+	}
+	v := xorBytes(sigma, h2.Sum(nil)[:len(msg)])
+
+	h4 := s.Hash()
+	h4.Write(ibe.H4Tag())
+	h4.Write(sigma)
+	w := xorBytes(msg, h4.Sum(nil)[:len(msg)])
+
+	return &ibe.Ciphertext{U: u, V: v, W: w}, nil
+}
+
+// xorBytes returns the exclusive or of two slices of the same length.
+func xorBytes(a []byte, b []byte) []byte {
+	res := make([]byte, len(a))
+	for i := range a {
+		res[i] = a[i] ^ b[i]
+	}
+
+	return res
+}
@@ -0,0 +1,282 @@
+package tlock_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/internal/fakenet"
+)
+
+var updateCorpus = flag.Bool("update-corpus", false, "regenerate the files of the adversarial corpus")
+
+// corpusDir holds encrypted files that are malformed or tampered with in
+// specific ways, along with the valid file they were derived from.
+const corpusDir = "test_artifacts/corpus"
+
+// corpusChain returns the chain the files of the corpus are encrypted to.
+func corpusChain() *fakenet.Chain {
+	network := fakenet.NewChainFromSeed(3*time.Second, "tlock corpus")
+	network.Unlock()
+
+	return network
+}
+
+// corpusRound is the round the files of the corpus are locked to.
+const corpusRound = 1000
+
+// Test_AdversarialCorpus decrypts every file of the corpus and checks the
+// error it fails with, so that the parser keeps rejecting them the same way.
+// The files are regenerated with -update-corpus.
+func Test_AdversarialCorpus(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(t *testing.T, valid []byte) []byte
+		err    error
+	}{
+		{"valid", func(t *testing.T, b []byte) []byte { return b }, nil},
+
+		// Truncations of the header.
+		{"empty", func(t *testing.T, b []byte) []byte { return nil }, io.ErrUnexpectedEOF},
+		{"truncated-intro", func(t *testing.T, b []byte) []byte { return b[:10] }, io.ErrUnexpectedEOF},
+		{"truncated-stanza", func(t *testing.T, b []byte) []byte {
+			return b[:bytes.Index(b, []byte("\n-> "))+20]
+		}, io.ErrUnexpectedEOF},
+		{"truncated-body", func(t *testing.T, b []byte) []byte {
+			return b[:bytes.Index(b, []byte("\n---"))-5]
+		}, io.ErrUnexpectedEOF},
+		{"truncated-nonce", func(t *testing.T, b []byte) []byte {
+			return b[:headerEnd(b)+8]
+		}, io.ErrUnexpectedEOF},
+
+		// Malformed header lines.
+		{"wrong-intro", func(t *testing.T, b []byte) []byte {
+			return bytes.Replace(b, []byte("age-encryption.org/v1"), []byte("age-encryption.org/v2"), 1)
+		}, tlock.ErrMalformedHeader},
+		{"missing-mac", func(t *testing.T, b []byte) []byte {
+			return append(b[:bytes.Index(b, []byte("\n---"))+4], b[headerEnd(b)-1:]...)
+		}, tlock.ErrMalformedHeader},
+		{"short-mac", func(t *testing.T, b []byte) []byte {
+			return append(b[:headerEnd(b)-5], b[headerEnd(b)-1:]...)
+		}, tlock.ErrMalformedHeader},
+		{"empty-argument", func(t *testing.T, b []byte) []byte {
+			return replaceStanza(t, b, func(args []string) []string { return append(args[:2], append([]string{""}, args[2:]...)...) })
+		}, tlock.ErrMalformedHeader},
+		{"long-body-line", func(t *testing.T, b []byte) []byte {
+			lines := bodyLines(b)
+			return bytes.Replace(b, []byte(strings.Join(lines, "\n")), []byte(strings.Join(lines, "")+"\n"), 1)
+		}, tlock.ErrMalformedHeader},
+		{"invalid-body", func(t *testing.T, b []byte) []byte {
+			line := bodyLines(b)[0]
+			return bytes.Replace(b, []byte(line), []byte("!"+line[1:]), 1)
+		}, tlock.ErrMalformedHeader},
+		{"two-stanzas", func(t *testing.T, b []byte) []byte {
+			start := bytes.Index(b, []byte("\n-> ")) + 1
+			end := bytes.Index(b, []byte("\n---")) + 1
+			stanza := append([]byte{}, b[start:end]...)
+			return append(append(append([]byte{}, b[:end]...), stanza...), b[end:]...)
+		}, tlock.ErrMalformedHeader},
+
+		// Malformed stanza arguments.
+		{"wrong-stanza-type", func(t *testing.T, b []byte) []byte {
+			return replaceStanza(t, b, func(args []string) []string { return append([]string{"X25519"}, args[1:]...) })
+		}, age.ErrIncorrectIdentity},
+		{"missing-chain", func(t *testing.T, b []byte) []byte {
+			return replaceStanza(t, b, func(args []string) []string { return args[:2] })
+		}, age.ErrIncorrectIdentity},
+		{"bad-round", func(t *testing.T, b []byte) []byte {
+			return replaceArg(t, b, 1, "1e3")
+		}, tlock.ErrMalformedHeader},
+		{"overflowing-round", func(t *testing.T, b []byte) []byte {
+			return replaceArg(t, b, 1, "18446744073709551616")
+		}, tlock.ErrMalformedHeader},
+		{"malformed-argument", func(t *testing.T, b []byte) []byte {
+			return replaceStanza(t, b, func(args []string) []string { return append(args, "chunk") })
+		}, tlock.ErrMalformedHeader},
+		{"duplicate-argument", func(t *testing.T, b []byte) []byte {
+			return replaceStanza(t, b, func(args []string) []string { return append(args, "aead=aes256gcm", "aead=aes256gcm") })
+		}, tlock.ErrMalformedHeader},
+		{"bad-chunk-size", func(t *testing.T, b []byte) []byte {
+			return replaceStanza(t, b, func(args []string) []string { return append(without(args, "chunk"), "chunk=0") })
+		}, tlock.ErrMalformedHeader},
+		{"unknown-scheme", func(t *testing.T, b []byte) []byte {
+			return replaceStanza(t, b, func(args []string) []string { return append(args, "scheme=bls-unknown") })
+		}, tlock.ErrMalformedHeader},
+		{"unsupported-version", func(t *testing.T, b []byte) []byte {
+			return replaceStanza(t, b, func(args []string) []string { return append(without(args, "v"), "v=99") })
+		}, tlock.ErrUnsupportedVersion},
+
+		// Headers locked to another chain.
+		{"swapped-chain-hash", func(t *testing.T, b []byte) []byte {
+			return replaceArg(t, b, 2, fakenet.NewChainFromSeed(3*time.Second, "other corpus").ChainHash())
+		}, tlock.ErrWrongChain},
+		{"swapped-scheme", func(t *testing.T, b []byte) []byte {
+			return replaceStanza(t, b, func(args []string) []string {
+				return append(without(args, "v"), "v=2", "scheme="+tlock.SchemeUnchainedG1)
+			})
+		}, tlock.ErrWrongChain},
+
+		// Bogus time lock ciphertexts.
+		{"short-ciphertext", func(t *testing.T, b []byte) []byte {
+			return replaceBody(t, b, func(body []byte) []byte { return body[:len(body)-1] })
+		}, tlock.ErrInvalidCiphertext},
+		{"long-ciphertext", func(t *testing.T, b []byte) []byte {
+			return replaceBody(t, b, func(body []byte) []byte { return append(body, 0) })
+		}, tlock.ErrInvalidCiphertext},
+		{"bogus-point", func(t *testing.T, b []byte) []byte {
+			return replaceBody(t, b, func(body []byte) []byte {
+				copy(body, bytes.Repeat([]byte{0xff}, 48))
+				return body
+			})
+		}, tlock.ErrInvalidCiphertext},
+		{"tampered-ciphertext", func(t *testing.T, b []byte) []byte {
+			return replaceBody(t, b, func(body []byte) []byte {
+				body[len(body)-1] ^= 1
+				return body
+			})
+		}, tlock.ErrInvalidCiphertext},
+
+		// Tampering with the authenticated header and the payload.
+		{"tampered-header", func(t *testing.T, b []byte) []byte {
+			return replaceStanza(t, b, func(args []string) []string { return append(args, "mode=600") })
+		}, tlock.ErrHeaderMACMismatch},
+		{"missing-last-chunk", func(t *testing.T, b []byte) []byte {
+			return b[:headerEnd(b)+16+corpusChunk+16]
+		}, io.ErrUnexpectedEOF},
+		{"truncated-payload", func(t *testing.T, b []byte) []byte { return b[:len(b)-1] }, tlock.ErrCorruptPayload},
+		{"flipped-payload", func(t *testing.T, b []byte) []byte {
+			b[len(b)-1] ^= 1
+			return b
+		}, tlock.ErrCorruptPayload},
+		{"trailing-data", func(t *testing.T, b []byte) []byte { return append(b, 0) }, tlock.ErrCorruptPayload},
+		{"swapped-chunks", func(t *testing.T, b []byte) []byte {
+			payload := headerEnd(b) + 16
+			first := append([]byte{}, b[payload:payload+corpusChunk+16]...)
+			copy(b[payload:], b[payload+corpusChunk+16:payload+2*(corpusChunk+16)])
+			copy(b[payload+corpusChunk+16:], first)
+			return b
+		}, tlock.ErrCorruptPayload},
+	}
+
+	network := corpusChain()
+
+	if *updateCorpus {
+		var valid bytes.Buffer
+		data := bytes.Repeat([]byte("time lock corpus\n"), 12)
+		if err := tlock.New(network, tlock.WithChunkSize(corpusChunk)).Encrypt(&valid, bytes.NewReader(data), corpusRound); err != nil {
+			t.Fatalf("encrypt error %s", err)
+		}
+
+		if err := os.MkdirAll(corpusDir, 0755); err != nil {
+			t.Fatalf("mkdir error %s", err)
+		}
+		for _, tt := range tests {
+			b := tt.mutate(t, append([]byte{}, valid.Bytes()...))
+			if err := os.WriteFile(filepath.Join(corpusDir, tt.name+".tle"), b, 0644); err != nil {
+				t.Fatalf("write error %s", err)
+			}
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := os.ReadFile(filepath.Join(corpusDir, tt.name+".tle"))
+			if err != nil {
+				t.Fatalf("read error %s", err)
+			}
+
+			err = tlock.New(network).Decrypt(io.Discard, bytes.NewReader(b))
+			switch {
+			case tt.err == nil && err != nil:
+				t.Fatalf("decrypt error %s", err)
+			case !errors.Is(err, tt.err):
+				t.Fatalf("expecting error %v; got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+// corpusChunk is the chunk size of the files of the corpus, which is small so
+// that the payload has several chunks.
+const corpusChunk = 64
+
+// headerEnd returns the offset of the end of the header, where the nonce
+// starts.
+func headerEnd(b []byte) int {
+	footer := bytes.Index(b, []byte("\n---"))
+	return footer + 1 + bytes.IndexByte(b[footer+1:], '\n') + 1
+}
+
+// bodyLines returns the lines of the stanza body.
+func bodyLines(b []byte) []string {
+	start := bytes.Index(b, []byte("\n-> ")) + 1
+	start += bytes.IndexByte(b[start:], '\n') + 1
+	end := bytes.Index(b, []byte("\n---"))
+
+	return strings.Split(string(b[start:end]), "\n")
+}
+
+// replaceStanza replaces the stanza line with the arguments returned by the
+// function, which are given the current ones starting with the stanza type.
+func replaceStanza(t *testing.T, b []byte, fn func(args []string) []string) []byte {
+	start := bytes.Index(b, []byte("\n-> ")) + 1
+	end := start + bytes.IndexByte(b[start:], '\n')
+
+	args := strings.Split(string(b[start+len("-> "):end]), " ")
+	if len(args) < 4 {
+		t.Fatalf("unexpected stanza %q", b[start:end])
+	}
+
+	line := "-> " + strings.Join(fn(args), " ")
+	return append(append(append([]byte{}, b[:start]...), line...), b[end:]...)
+}
+
+// without returns the stanza arguments without the one of the key.
+func without(args []string, key string) []string {
+	var kept []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, key+"=") {
+			kept = append(kept, arg)
+		}
+	}
+
+	return kept
+}
+
+// replaceArg replaces the stanza argument at the index, counting the stanza
+// type.
+func replaceArg(t *testing.T, b []byte, i int, value string) []byte {
+	return replaceStanza(t, b, func(args []string) []string {
+		args[i] = value
+		return args
+	})
+}
+
+// replaceBody replaces the stanza body with the one returned by the function,
+// which is given the current one.
+func replaceBody(t *testing.T, b []byte, fn func(body []byte) []byte) []byte {
+	lines := bodyLines(b)
+	body, err := base64.RawStdEncoding.DecodeString(strings.Join(lines, ""))
+	if err != nil {
+		t.Fatalf("decode body error %s", err)
+	}
+
+	encoded := base64.RawStdEncoding.EncodeToString(fn(body))
+	var wrapped []string
+	for len(encoded) >= 64 {
+		wrapped = append(wrapped, encoded[:64])
+		encoded = encoded[64:]
+	}
+	wrapped = append(wrapped, encoded)
+
+	return bytes.Replace(b, []byte(strings.Join(lines, "\n")), []byte(strings.Join(wrapped, "\n")), 1)
+}
@@ -0,0 +1,33 @@
+package tlock
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// WithDeterministicSeed derives the data encryption key, the payload nonce and
+// the random element of the time lock encryption from the seed, so the same
+// source encrypted for the same round of the same chain with the same options
+// always produces the same encrypted data. It is meant for test vectors and
+// reproducible builds only: sources encrypted with the same seed for the same
+// round share their key, so seeing two of them, or knowing the seed, is enough
+// to decrypt them without waiting for the round. It can't be combined with
+// WithConvergentKey.
+func WithDeterministicSeed(seed []byte) Option {
+	return func(t *Tlock) {
+		t.deterministicSeed = seed
+	}
+}
+
+// deterministicRand returns the random stream of the encryption for the
+// round, derived from the seed and the chain.
+func (t Tlock) deterministicRand(roundNumber uint64) io.Reader {
+	salt := make([]byte, 8, 8+len(t.networkChainHash()))
+	binary.BigEndian.PutUint64(salt, roundNumber)
+	salt = append(salt, t.networkChainHash()...)
+
+	return hkdf.New(sha256.New, t.deterministicSeed, salt, []byte("tlock deterministic encryption"))
+}
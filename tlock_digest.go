@@ -0,0 +1,138 @@
+package tlock
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/drand/tlock/internal/codec"
+)
+
+// ErrDigestMismatch represents an error when the decrypted data doesn't match
+// the digest recorded in the header.
+var ErrDigestMismatch = errors.New("content digest does not match")
+
+// These are the algorithms of the content digest recorded in the header.
+const (
+	digestSHA256     = "sha256"
+	digestHMACSHA256 = "hmac-sha256"
+)
+
+// WithContentDigest records the SHA-256 of the plain data in the header,
+// where it's authenticated with the rest of the header, and Decrypt verifies
+// it once the data is decrypted. This detects corruption end to end, beyond
+// the authentication of every chunk. The source has to implement io.Seeker,
+// since it's read twice. The data requires format version 4 to decrypt.
+//
+// The digest can be read from the header before the round is reached, which
+// lets anyone confirm a guess of the plain data. Use WithDigestKey for data
+// that could be guessed.
+func WithContentDigest() Option {
+	return func(t *Tlock) {
+		t.digest = true
+	}
+}
+
+// WithDigestKey records the HMAC-SHA256 of the plain data with the key
+// instead of its SHA-256, like WithContentDigest, so only the holders of the
+// key can confirm a guess of the plain data. Decrypt verifies a keyed digest
+// only when given the same key. The key should be at least 32 random bytes.
+func WithDigestKey(key []byte) Option {
+	return func(t *Tlock) {
+		t.digest = true
+		t.digestKey = key
+	}
+}
+
+// contentDigest reads the seekable source to compute the digest of the plain
+// data, keyed if the tlock has a digest key. The source is positioned back
+// where it was.
+func (t Tlock) contentDigest(ctx context.Context, src io.Reader) ([]byte, error) {
+	rs, ok := src.(io.ReadSeeker)
+	if !ok {
+		return nil, errors.New("content digest requires a seekable source")
+	}
+
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("seek source: %w", err)
+	}
+
+	h := t.digestHash(t.digestKey != nil)
+	if _, err := io.Copy(h, contextReader{ctx: ctx, r: rs}); err != nil {
+		return nil, fmt.Errorf("hash source: %w", err)
+	}
+
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek source: %w", err)
+	}
+
+	return h.Sum(nil), nil
+}
+
+// digestVerifier returns the hash the decrypted data has to be written to for
+// the digest of the header to be verified, or nil if there is none or it's
+// keyed and the tlock has no key.
+func (t Tlock) digestVerifier(info Header) hash.Hash {
+	switch {
+	case info.Digest == nil:
+		return nil
+
+	case info.DigestKeyed && t.digestKey == nil:
+		t.warn(WarnDigestNotVerified, "not verifying the keyed content digest without its key")
+		return nil
+	}
+
+	return t.digestHash(info.DigestKeyed)
+}
+
+// digestHash returns the hash computing a content digest.
+func (t Tlock) digestHash(keyed bool) hash.Hash {
+	if keyed {
+		return hmac.New(sha256.New, t.digestKey)
+	}
+
+	return sha256.New()
+}
+
+// verifyDigest checks the data written to the hash against the digest.
+func verifyDigest(h hash.Hash, digest []byte) error {
+	if !codec.Equal(h.Sum(nil), digest) {
+		return ErrDigestMismatch
+	}
+
+	return nil
+}
+
+// formatDigest returns the stanza argument value recording a digest.
+func formatDigest(digest []byte, keyed bool) string {
+	alg := digestSHA256
+	if keyed {
+		alg = digestHMACSHA256
+	}
+
+	return alg + ":" + codec.ValueEncoding.EncodeToString(digest)
+}
+
+// parseDigest adds the digest recorded by a stanza argument to the header.
+func parseDigest(header *Header, value string) error {
+	pair := strings.SplitN(value, ":", 2)
+	if len(pair) != 2 || pair[0] != digestSHA256 && pair[0] != digestHMACSHA256 {
+		return fmt.Errorf("invalid digest %q", value)
+	}
+
+	digest, err := codec.ValueEncoding.DecodeString(pair[1])
+	if err != nil || len(digest) != sha256.Size {
+		return fmt.Errorf("invalid digest %q", value)
+	}
+
+	header.Digest = digest
+	header.DigestKeyed = pair[0] == digestHMACSHA256
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package tlock
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Draw is the order of a set of commitments derived from the randomness of a
+// round, such as the entries of a raffle ranked to pick its winners. Since the
+// randomness isn't known before the round is reached, commitments collected
+// before then, typically encrypted to that round, can't be chosen to win.
+type Draw struct {
+	RoundNumber uint64
+	Signature   []byte
+
+	// Order lists the indexes of the commitments from the first to the last
+	// drawn.
+	Order []int
+}
+
+// Winners returns the indexes of the first n commitments drawn.
+func (d Draw) Winners(n int) []int {
+	if n > len(d.Order) {
+		n = len(d.Order)
+	}
+
+	return d.Order[:n]
+}
+
+// Draw retrieves the signature of the round and orders the commitments with
+// its randomness. It fails with ErrTooEarly if the round isn't reached yet.
+// The same commitments always lead to the same order, whatever the order they
+// are listed in, so anyone can check the draw with VerifyDraw.
+func (t Tlock) Draw(ctx context.Context, roundNumber uint64, commitments [][]byte) (Draw, error) {
+	identity := tleIdentity{
+		ctx:     ctx,
+		network: t.network,
+		cache:   t.cache,
+		group:   t.signatures,
+		source:  t.source,
+	}
+
+	signature, cached := t.cache.get(t.network.ChainHash(), roundNumber)
+	if !cached {
+		var err error
+		if signature, err = identity.signature(roundNumber); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return Draw{}, fmt.Errorf("signature: %w", err)
+			}
+			return Draw{}, t.tooEarly(fmt.Errorf("signature: %w", signatureError{err: err}), roundNumber)
+		}
+	}
+
+	if err := verifySignature(t.network, roundNumber, signature); err != nil {
+		return Draw{}, err
+	}
+
+	if !cached {
+		t.cache.add(t.network.ChainHash(), roundNumber, signature)
+	}
+
+	d := Draw{
+		RoundNumber: roundNumber,
+		Signature:   signature,
+		Order:       DrawOrder(Randomness(signature), commitments),
+	}
+
+	return d, nil
+}
+
+// VerifyDraw checks the signature of the draw against the public key of the
+// network and that the commitments were ordered with its randomness. This
+// doesn't require access to the network.
+func VerifyDraw(network Network, d Draw, commitments [][]byte) error {
+	if err := verifySignature(network, d.RoundNumber, d.Signature); err != nil {
+		return err
+	}
+
+	order := DrawOrder(Randomness(d.Signature), commitments)
+	if len(order) != len(d.Order) {
+		return fmt.Errorf("draw orders %d commitments, not %d", len(d.Order), len(order))
+	}
+	for i := range order {
+		if order[i] != d.Order[i] {
+			return fmt.Errorf("commitment %d isn't drawn in position %d", d.Order[i], i)
+		}
+	}
+
+	return nil
+}
+
+// verifySignature checks the signature of the round against the public key of
+// the network.
+func verifySignature(network Network, roundNumber uint64, signature []byte) error {
+	sch, err := networkScheme(network)
+	if err != nil {
+		return err
+	}
+
+	if err := sch.rounds.verify(sch, network.ChainHash(), network.PublicKey(), roundNumber, signature); err != nil {
+		return fmt.Errorf("verify beacon: %w", err)
+	}
+
+	return nil
+}
+
+// Randomness returns the randomness of a round given its signature, which is
+// the SHA-256 of the signature as published by drand.
+func Randomness(signature []byte) []byte {
+	sum := sha256.Sum256(signature)
+	return sum[:]
+}
+
+// DrawOrder orders the commitments with the randomness and returns their
+// indexes from the first to the last drawn. The commitments are ranked by the
+// SHA-256 of the randomness followed by the commitment, in increasing order,
+// and identical commitments keep the order they are listed in.
+func DrawOrder(randomness []byte, commitments [][]byte) []int {
+	ranks := make([][]byte, len(commitments))
+	order := make([]int, len(commitments))
+	for i, c := range commitments {
+		h := sha256.New()
+		h.Write(randomness)
+		h.Write(c)
+		ranks[i] = h.Sum(nil)
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return bytes.Compare(ranks[order[i]], ranks[order[j]]) < 0
+	})
+
+	return order
+}
@@ -0,0 +1,57 @@
+package tlock
+
+import (
+	"context"
+	"io"
+)
+
+// Encryptor encrypts many independent sources to the same round, such as
+// the uploads a server locks until a daily release. The identity of the
+// round is hashed and paired with the public key of the network once, which
+// is most of the cost of encrypting short data, so every encryption only
+// takes the rest. The hash of the chain is computed once as well. An
+// Encryptor is safe for concurrent use if the optional interfaces of its
+// network are.
+type Encryptor struct {
+	tlock       Tlock
+	roundNumber uint64
+}
+
+// NewEncryptor returns an encryptor for the round, with the options of the
+// tlock. The data it encrypts is the same the tlock would encrypt.
+func (t Tlock) NewEncryptor(roundNumber uint64) (*Encryptor, error) {
+	sch, err := networkScheme(t.network)
+	if err != nil {
+		return nil, err
+	}
+
+	t.chainHash = t.network.ChainHash()
+	t.pairing, err = sch.cachedRounds(t.hardened).pairing(sch, t.chainHash, t.network.PublicKey(), roundNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Encryptor{tlock: t, roundNumber: roundNumber}, nil
+}
+
+// RoundNumber returns the round the encryptor encrypts to.
+func (e *Encryptor) RoundNumber() uint64 {
+	return e.roundNumber
+}
+
+// Encrypt encrypts the source to the destination like Tlock.Encrypt.
+func (e *Encryptor) Encrypt(dst io.Writer, src io.Reader) error {
+	return e.tlock.EncryptContext(context.Background(), dst, src, e.roundNumber)
+}
+
+// EncryptContext works like Encrypt but stops reading the source as soon as
+// the context is canceled.
+func (e *Encryptor) EncryptContext(ctx context.Context, dst io.Writer, src io.Reader) error {
+	return e.tlock.EncryptContext(ctx, dst, src, e.roundNumber)
+}
+
+// EncryptFrom encrypts the source of the specified size like
+// Tlock.EncryptFrom, so short messages use a compact payload.
+func (e *Encryptor) EncryptFrom(ctx context.Context, dst io.Writer, src io.ReaderAt, size int64) error {
+	return e.tlock.EncryptFromContext(ctx, dst, src, size, e.roundNumber)
+}
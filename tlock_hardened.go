@@ -0,0 +1,7 @@
+//go:build tlock_hardened
+
+package tlock
+
+// hardenedByDefault enables WithHardening for every tlock, since tlock is
+// built with the tlock_hardened build tag.
+const hardenedByDefault = true
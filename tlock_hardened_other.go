@@ -0,0 +1,7 @@
+//go:build !tlock_hardened
+
+package tlock
+
+// hardenedByDefault leaves WithHardening disabled unless it is set, since
+// tlock isn't built with the tlock_hardened build tag.
+const hardenedByDefault = false
@@ -0,0 +1,23 @@
+package tlock
+
+import "github.com/drand/tlock/internal/codec"
+
+// WithHardening enables extra hardening against side channels, at some cost
+// in speed. MACs and digests are always compared in constant time; hardened
+// tlocks also wipe the data encryption key from memory once the payload key
+// is derived from it, and don't use the pairings and verified signatures
+// cached for recently used rounds, so the time an operation takes doesn't
+// tell which rounds other operations used. Hardening is disabled by default,
+// unless tlock is built with the tlock_hardened build tag.
+func WithHardening(hardened bool) Option {
+	return func(t *Tlock) {
+		t.hardened = hardened
+	}
+}
+
+// wipe overwrites the secret material if the tlock is hardened.
+func (t Tlock) wipe(b []byte) {
+	if t.hardened {
+		codec.Wipe(b)
+	}
+}
@@ -0,0 +1,268 @@
+package tlock
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drand/tlock/internal/fakenet"
+)
+
+// Test_ConstantTimeComparisons makes sure that MACs, digests and other secret
+// material are compared with codec.Equal, by failing on any other comparison
+// of bytes outside of the functions known to compare public data: calls to
+// the variable time functions, and == or != between byte arrays or strings
+// converted or encoded from bytes, other than with constants.
+func Test_ConstantTimeComparisons(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping type checking the dependencies in short mode")
+	}
+
+	public := map[string]bool{
+		"dearmor":   true, // byte order marks
+		"verify":    true, // signatures of rounds
+		"DrawOrder": true, // randomness of rounds
+	}
+
+	for _, dir := range []string{".", filepath.Join("internal", "codec")} {
+		fset, files, info := typeCheck(t, dir)
+		for _, finding := range variableTimeComparisons(fset, files, info, public) {
+			t.Error(finding)
+		}
+	}
+}
+
+// Test_ConstantTimeComparisonsFixture makes sure that the check of
+// Test_ConstantTimeComparisons fires on the comparisons it's looking for.
+func Test_ConstantTimeComparisonsFixture(t *testing.T) {
+	const fixture = `package fixture
+
+import (
+	"bytes"
+	"encoding/hex"
+)
+
+func macs(mac, expected [32]byte, tag, want []byte, name string) bool {
+	if bytes.Equal(tag, want) {
+		return true
+	}
+	if mac == expected {
+		return true
+	}
+	if string(tag) != string(want) {
+		return false
+	}
+	if hex.EncodeToString(tag) == name {
+		return true
+	}
+	return len(tag) == len(want) && string(tag) == "public"
+}
+`
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "fixture.go", fixture, 0)
+	if err != nil {
+		t.Fatalf("parse error %s", err)
+	}
+	info := types.Info{Types: make(map[ast.Expr]types.TypeAndValue)}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check("fixture", fset, []*ast.File{f}, &info); err != nil {
+		t.Fatalf("type check error %s", err)
+	}
+
+	findings := variableTimeComparisons(fset, []*ast.File{f}, &info, nil)
+	expected := []string{
+		"fixture.go:9:5: macs compares with bytes.Equal instead of codec.Equal",
+		"fixture.go:12:5: macs compares bytes with == instead of codec.Equal",
+		"fixture.go:15:5: macs compares bytes with != instead of codec.Equal",
+		"fixture.go:18:5: macs compares bytes with == instead of codec.Equal",
+	}
+	if strings.Join(findings, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expecting findings\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(findings, "\n"))
+	}
+}
+
+// typeCheck parses and type checks the files of the package in the
+// directory built by default, except for the tests.
+func typeCheck(t *testing.T, dir string) (*token.FileSet, []*ast.File, *types.Info) {
+	t.Helper()
+
+	pkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		t.Fatalf("import error %s", err)
+	}
+
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, name := range pkg.GoFiles {
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			t.Fatalf("parse error %s", err)
+		}
+		files = append(files, f)
+	}
+
+	info := types.Info{Types: make(map[ast.Expr]types.TypeAndValue)}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check(pkg.ImportPath, fset, files, &info); err != nil {
+		t.Fatalf("type check error %s", err)
+	}
+
+	return fset, files, &info
+}
+
+// variableTimeComparisons returns the comparisons of bytes in variable time
+// made by the functions of the files, except for the public ones.
+func variableTimeComparisons(fset *token.FileSet, files []*ast.File, info *types.Info, public map[string]bool) []string {
+	variableTime := map[string]bool{
+		"bytes.Equal":       true,
+		"bytes.Compare":     true,
+		"hmac.Equal":        true,
+		"reflect.DeepEqual": true,
+	}
+
+	var findings []string
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil || public[fn.Name.Name] {
+				continue
+			}
+
+			ast.Inspect(fn.Body, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.CallExpr:
+					sel, ok := n.Fun.(*ast.SelectorExpr)
+					if !ok {
+						return true
+					}
+					pkg, ok := sel.X.(*ast.Ident)
+					if ok && variableTime[pkg.Name+"."+sel.Sel.Name] {
+						findings = append(findings, fmt.Sprintf("%s: %s compares with %s.%s instead of codec.Equal", fset.Position(n.Pos()), fn.Name.Name, pkg.Name, sel.Sel.Name))
+					}
+
+				case *ast.BinaryExpr:
+					if n.Op != token.EQL && n.Op != token.NEQ {
+						return true
+					}
+					// Comparisons with constants, such as magic strings, are public.
+					if info.Types[n.X].Value != nil || info.Types[n.Y].Value != nil {
+						return true
+					}
+					if fromBytes(info, n.X) || fromBytes(info, n.Y) {
+						findings = append(findings, fmt.Sprintf("%s: %s compares bytes with %s instead of codec.Equal", fset.Position(n.Pos()), fn.Name.Name, n.Op))
+					}
+				}
+				return true
+			})
+		}
+	}
+
+	return findings
+}
+
+// fromBytes reports whether the expression is a byte array, or a string
+// converted or encoded from bytes, which == compares in variable time.
+func fromBytes(info *types.Info, e ast.Expr) bool {
+	for {
+		p, ok := e.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		e = p.X
+	}
+
+	if isBytes(info.TypeOf(e)) {
+		if _, ok := info.TypeOf(e).Underlying().(*types.Array); ok {
+			return true
+		}
+	}
+
+	call, ok := e.(*ast.CallExpr)
+	if !ok || len(call.Args) != 1 {
+		return false
+	}
+	if info.Types[call.Fun].IsType() {
+		return isBytes(info.TypeOf(call.Args[0]))
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	return ok && sel.Sel.Name == "EncodeToString" && isBytes(info.TypeOf(call.Args[0]))
+}
+
+// isBytes reports whether the type is a byte slice or array.
+func isBytes(t types.Type) bool {
+	if t == nil {
+		return false
+	}
+
+	var elem types.Type
+	switch u := t.Underlying().(type) {
+	case *types.Slice:
+		elem = u.Elem()
+	case *types.Array:
+		elem = u.Elem()
+	default:
+		return false
+	}
+
+	b, ok := elem.Underlying().(*types.Basic)
+	return ok && b.Kind() == types.Byte
+}
+
+func Test_Hardening(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	sch, err := networkScheme(network)
+	if err != nil {
+		t.Fatalf("scheme error %s", err)
+	}
+
+	message := []byte("commit: 42")
+
+	tl := New(network, WithHardening(true))
+	if !tl.hardened {
+		t.Fatalf("expecting a hardened tlock")
+	}
+
+	var cipherData bytes.Buffer
+	if err := tl.Encrypt(&cipherData, bytes.NewReader(message), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	var plainData bytes.Buffer
+	if err := tl.Decrypt(&plainData, &cipherData); err != nil {
+		t.Fatalf("decrypt error %s", err)
+	}
+	if !bytes.Equal(plainData.Bytes(), message) {
+		t.Fatalf("expecting %q; got %q", message, plainData.Bytes())
+	}
+
+	// The round was neither paired nor verified through the cache.
+	sch.rounds.mu.Lock()
+	_, cached := sch.rounds.entries[cacheKey{network.ChainHash(), 10}]
+	sch.rounds.mu.Unlock()
+	if cached {
+		t.Fatalf("expecting round 10 not to be cached")
+	}
+
+	if err := New(network).Encrypt(io.Discard, bytes.NewReader(message), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	sch.rounds.mu.Lock()
+	_, cached = sch.rounds.entries[cacheKey{network.ChainHash(), 10}]
+	sch.rounds.mu.Unlock()
+	if !cached && !hardenedByDefault {
+		t.Fatalf("expecting round 10 to be cached")
+	}
+}
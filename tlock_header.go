@@ -0,0 +1,24 @@
+package tlock
+
+import (
+	"bufio"
+	"errors"
+
+	"github.com/drand/tlock/internal/codec"
+)
+
+// ErrMalformedHeader represents an error when the header can't be parsed.
+var ErrMalformedHeader = codec.ErrMalformed
+
+// parseHeader reads the header from the source, leaving it positioned at the
+// start of the payload. It also returns the part of the header that is
+// authenticated by the MAC. Headers larger than the maximum size in bytes are
+// rejected, unless it's zero.
+func parseHeader(r *bufio.Reader, maxSize int) (*codec.Header, []byte, error) {
+	hdr, raw, err := codec.ParseHeader(r, maxSize)
+	if errors.Is(err, codec.ErrTooLarge) {
+		return nil, nil, &LimitError{Limit: "header size", Max: int64(maxSize)}
+	}
+
+	return hdr, raw, err
+}
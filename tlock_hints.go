@@ -0,0 +1,81 @@
+package tlock
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/drand/tlock/internal/codec"
+)
+
+// These constants define the limits of the endpoint hints recorded in the
+// header, which keep the stanza arguments on a single header line.
+const (
+	MaxEndpointHints    = 4
+	MaxEndpointHintSize = 256
+)
+
+// WithEndpointHints records the URLs of drand HTTP endpoints serving the
+// chain in the header, so recipients who haven't configured any endpoint can
+// still find the network to decrypt with. The hints aren't authenticated
+// before decryption, so they should only be used to retrieve the chain
+// recorded in the header, whose hash the network checks. The data requires
+// format version 4 to decrypt.
+func WithEndpointHints(urls ...string) Option {
+	return func(t *Tlock) {
+		t.endpointHints = append([]string(nil), urls...)
+	}
+}
+
+// checkEndpointHints validates the endpoint hints recorded when encrypting.
+func checkEndpointHints(hints []string) error {
+	if len(hints) > MaxEndpointHints {
+		return fmt.Errorf("%d endpoint hints exceed the limit of %d", len(hints), MaxEndpointHints)
+	}
+
+	for _, hint := range hints {
+		if err := checkEndpointHint(hint); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkEndpointHint validates a single endpoint hint, which has to be an
+// http:// or https:// URL.
+func checkEndpointHint(hint string) error {
+	if len(hint) > MaxEndpointHintSize {
+		return fmt.Errorf("endpoint hint of %d bytes exceeds the limit of %d", len(hint), MaxEndpointHintSize)
+	}
+
+	u, err := url.Parse(hint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.ContainsAny(hint, " \n") {
+		return fmt.Errorf("invalid endpoint hint %q", hint)
+	}
+
+	return nil
+}
+
+// formatEndpointHints returns the stanza argument value recording the
+// endpoint hints.
+func formatEndpointHints(hints []string) string {
+	return codec.ValueEncoding.EncodeToString([]byte(strings.Join(hints, "\n")))
+}
+
+// parseEndpointHints adds the endpoint hints recorded by a stanza argument to
+// the header, following the same rules as when encrypting.
+func parseEndpointHints(header *Header, value string) error {
+	decoded, err := codec.ValueEncoding.DecodeString(value)
+	if err != nil || len(decoded) == 0 {
+		return fmt.Errorf("invalid endpoint hints %q", value)
+	}
+
+	hints := strings.Split(string(decoded), "\n")
+	if err := checkEndpointHints(hints); err != nil {
+		return err
+	}
+	header.EndpointHints = hints
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package tlock
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/drand/tlock/networks"
+)
+
+// maxJournalRecord is the size of the largest encrypted journal record, which
+// is held in memory.
+const maxJournalRecord = 64 << 20
+
+// JournalRecord is a record of a journal.
+type JournalRecord struct {
+	Time        time.Time
+	RoundNumber uint64
+	Data        []byte
+}
+
+// Journal appends records to a single file, each locked to the round
+// available once the embargo passed since its timestamp, such as an audit log
+// that is only disclosed after a delay. Every record is encrypted on its own
+// and written with a single write, so records appended concurrently or by
+// several processes don't interleave. Journals are read with ReadJournal.
+type Journal struct {
+	t       Tlock
+	f       *os.File
+	embargo time.Duration
+	mu      sync.Mutex
+}
+
+// OpenJournal opens the journal at the specified path, which is created if it
+// doesn't exist, to append records locked for the embargo. The network has to
+// tell the round available at a given time.
+func (t Tlock) OpenJournal(path string, embargo time.Duration) (*Journal, error) {
+	if _, ok := t.network.(networks.RoundCalculator); !ok {
+		return nil, errors.New("network can't tell the round of a time")
+	}
+
+	if embargo < 0 {
+		return nil, fmt.Errorf("embargo %s is negative", embargo)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+
+	// Checkpoints can't resume a single record.
+	t.checkpoint = nil
+
+	return &Journal{t: t, f: f, embargo: embargo}, nil
+}
+
+// Append encrypts the data with its timestamp and appends it to the journal.
+// It returns the round the record is locked to.
+func (j *Journal) Append(timestamp time.Time, data []byte) (uint64, error) {
+	return j.AppendContext(context.Background(), timestamp, data)
+}
+
+// AppendContext works like Append but stops encrypting the data as soon as the
+// context is canceled.
+func (j *Journal) AppendContext(ctx context.Context, timestamp time.Time, data []byte) (uint64, error) {
+	roundNumber := j.t.network.(networks.RoundCalculator).RoundNumber(timestamp.Add(j.embargo))
+
+	// The timestamp is encrypted with the data, so it isn't disclosed before
+	// the record.
+	plain := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(plain, uint64(timestamp.UnixNano()))
+	plain = append(plain, data...)
+
+	var encrypted bytes.Buffer
+	if err := j.t.EncryptContext(ctx, &encrypted, bytes.NewReader(plain), roundNumber); err != nil {
+		return 0, err
+	}
+
+	if encrypted.Len() > maxJournalRecord {
+		return 0, fmt.Errorf("record of %d bytes is too large", encrypted.Len())
+	}
+
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(encrypted.Len()))
+	record := append(size[:n:n], encrypted.Bytes()...)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := j.f.Write(record); err != nil {
+		return 0, fmt.Errorf("append record: %w", err)
+	}
+
+	return roundNumber, nil
+}
+
+// Sync flushes the records appended to the journal to disk.
+func (j *Journal) Sync() error {
+	return j.f.Sync()
+}
+
+// Close closes the journal.
+func (j *Journal) Close() error {
+	return j.f.Close()
+}
+
+// =============================================================================
+
+// JournalReader reads the records of a journal that can be decrypted.
+type JournalReader struct {
+	t      Tlock
+	r      *bufio.Reader
+	locked []Header
+}
+
+// ReadJournal returns a reader of the records of the journal in the source
+// that can currently be decrypted.
+func (t Tlock) ReadJournal(src io.Reader) *JournalReader {
+	t.checkpoint = nil
+
+	return &JournalReader{t: t, r: bufio.NewReader(src)}
+}
+
+// Next returns the next record that can be decrypted, skipping the ones that
+// are still locked. It returns io.EOF once there are no more records.
+func (jr *JournalReader) Next(ctx context.Context) (JournalRecord, error) {
+	for {
+		size, err := binary.ReadUvarint(jr.r)
+		switch {
+		case errors.Is(err, io.EOF):
+			return JournalRecord{}, io.EOF
+		case err != nil:
+			return JournalRecord{}, fmt.Errorf("read record: %w", err)
+		case size > maxJournalRecord:
+			return JournalRecord{}, fmt.Errorf("record of %d bytes is too large", size)
+		}
+
+		encrypted := make([]byte, size)
+		if _, err := io.ReadFull(jr.r, encrypted); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return JournalRecord{}, fmt.Errorf("read record: %w", err)
+		}
+
+		header, err := ReadHeader(bytes.NewReader(encrypted))
+		if err != nil {
+			return JournalRecord{}, err
+		}
+
+		var plain bytes.Buffer
+		err = jr.t.DecryptContext(ctx, &plain, bytes.NewReader(encrypted))
+		switch {
+		case errors.Is(err, ErrTooEarly):
+			jr.locked = append(jr.locked, header)
+			continue
+		case err != nil:
+			return JournalRecord{}, err
+		case plain.Len() < 8:
+			return JournalRecord{}, errors.New("record has no timestamp")
+		}
+
+		record := JournalRecord{
+			Time:        time.Unix(0, int64(binary.BigEndian.Uint64(plain.Bytes()))),
+			RoundNumber: header.RoundNumber,
+			Data:        plain.Bytes()[8:],
+		}
+
+		return record, nil
+	}
+}
+
+// Locked returns the headers of the records skipped so far because they
+// can't be decrypted yet, telling when they become readable.
+func (jr *JournalReader) Locked() []Header {
+	return jr.locked
+}
@@ -0,0 +1,82 @@
+package tlock
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrLimitExceeded represents an error when data exceeds one of the limits
+// set on the tlock. The error is returned as a *LimitError, which names the
+// limit.
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// LimitError provides the limit involved in an ErrLimitExceeded error.
+type LimitError struct {
+	Limit string
+	Max   int64
+}
+
+// Error implements the error interface.
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v: the %s exceeds the limit of %d", ErrLimitExceeded, e.Limit, e.Max)
+}
+
+// Unwrap allows errors.Is to match ErrLimitExceeded.
+func (e *LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// DefaultMaxHeaderSize is the default size in bytes of the largest header
+// accepted when decrypting, which is far above the size of any header tlock
+// writes but stops hostile headers from using up memory.
+const DefaultMaxHeaderSize = 64 * 1024
+
+// WithMaxHeaderSize sets the size in bytes of the largest header accepted
+// when decrypting, or 0 for no limit. The default is DefaultMaxHeaderSize,
+// which ReadHeader, Inspect and UnlockCode use as well.
+func WithMaxHeaderSize(size int) Option {
+	return func(t *Tlock) {
+		t.limits.headerSize = size
+	}
+}
+
+// WithMaxPayloadSize sets the size in bytes of the largest plain data that is
+// encrypted or decrypted, or 0 for no limit, which is the default. Going over
+// the limit fails once the chunk that crosses it is reached, so the output
+// may hold the data up to the previous chunk.
+func WithMaxPayloadSize(size int64) Option {
+	return func(t *Tlock) {
+		t.limits.payloadSize = size
+	}
+}
+
+// WithMaxChunkCount sets the number of chunks, the last one included, of the
+// largest payload that is encrypted or decrypted, or 0 for no limit, which
+// is the default. Since the chunk size of encrypted data is set by its
+// header, this bounds the work done for data with tiny chunks.
+func WithMaxChunkCount(count uint64) Option {
+	return func(t *Tlock) {
+		t.limits.chunkCount = count
+	}
+}
+
+// limits holds the limits set on a tlock.
+type limits struct {
+	headerSize  int
+	payloadSize int64
+	chunkCount  uint64
+}
+
+// checkChunk checks the limits of the payload once it's made of the chunks,
+// holding the bytes of plain data.
+func (l limits) checkChunk(chunks uint64, size int64) error {
+	switch {
+	case l.chunkCount > 0 && chunks > l.chunkCount:
+		return &LimitError{Limit: "chunk count", Max: int64(l.chunkCount)}
+
+	case l.payloadSize > 0 && size > l.payloadSize:
+		return &LimitError{Limit: "payload size", Max: l.payloadSize}
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package tlock
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+
+	"github.com/drand/kyber"
+)
+
+// roundCacheSize is the number of rounds whose pairing and verified signature
+// a scheme keeps in memory.
+const roundCacheSize = 64
+
+// roundCache keeps the values of recently used rounds of chains that don't
+// depend on the data: the pairing of the public key with the identity of the
+// round, which encryption to the round starts with, and the signature of the
+// round once verified, which decryption starts with. Both are the costliest
+// part of the time lock encryption of a data key, so encrypting or decrypting
+// many messages locked to the same round computes them once. When the cache
+// is full, the least recently used round is evicted. It is safe for
+// concurrent use.
+type roundCache struct {
+	mu      sync.Mutex
+	order   *list.List
+	entries map[cacheKey]*list.Element
+}
+
+// roundEntry is the value of the elements of the eviction order.
+type roundEntry struct {
+	key       cacheKey
+	pairing   kyber.Point
+	signature []byte
+}
+
+// newRoundCache constructs an empty round cache.
+func newRoundCache() *roundCache {
+	return &roundCache{
+		order:   list.New(),
+		entries: make(map[cacheKey]*list.Element),
+	}
+}
+
+// entry returns the entry of the round of the chain, adding it if it isn't
+// cached yet. It must be called with the lock held.
+func (c *roundCache) entry(chainHash string, roundNumber uint64) *roundEntry {
+	key := cacheKey{chainHash, roundNumber}
+	if e, exists := c.entries[key]; exists {
+		c.order.MoveToFront(e)
+		return e.Value.(*roundEntry)
+	}
+
+	entry := roundEntry{key: key}
+	c.entries[key] = c.order.PushFront(&entry)
+
+	if c.order.Len() > roundCacheSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*roundEntry).key)
+	}
+
+	return &entry
+}
+
+// =============================================================================
+
+// pairing returns the pairing of the public key of the chain with the
+// identity of the round for the scheme, computing it unless it's cached. A
+// nil cache computes it every time.
+func (c *roundCache) pairing(s *timelockScheme, chainHash string, publicKey kyber.Point, roundNumber uint64) (kyber.Point, error) {
+	if c == nil {
+		return s.pairRound(publicKey, roundNumber)
+	}
+
+	c.mu.Lock()
+	gid := c.entry(chainHash, roundNumber).pairing
+	c.mu.Unlock()
+
+	if gid != nil {
+		return gid, nil
+	}
+
+	gid, err := s.pairRound(publicKey, roundNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.entry(chainHash, roundNumber).pairing = gid
+	c.mu.Unlock()
+
+	return gid, nil
+}
+
+// verify checks the signature of the round against the public key of the
+// chain for the scheme, unless the same signature was verified already. A
+// nil cache verifies it every time.
+func (c *roundCache) verify(s *timelockScheme, chainHash string, publicKey kyber.Point, roundNumber uint64, signature []byte) error {
+	if c == nil {
+		return s.verify(publicKey, roundNumber, signature)
+	}
+
+	c.mu.Lock()
+	verified := c.entry(chainHash, roundNumber).signature
+	c.mu.Unlock()
+
+	if verified != nil && bytes.Equal(verified, signature) {
+		return nil
+	}
+
+	if err := s.verify(publicKey, roundNumber, signature); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.entry(chainHash, roundNumber).signature = append([]byte(nil), signature...)
+	c.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package tlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/drand/tlock/internal/codec"
+)
+
+// EncryptFrom works like Encrypt but reads the source of the specified size
+// at arbitrary offsets, which allows its chunks to be encrypted in parallel.
+// This is considerably faster than Encrypt for large local files and other
+// sources that support random access. Since the size is known, sources up to
+// MaxCompactSize are encrypted as a compact payload with WithCompact.
+// Otherwise the encrypted data is the same Encrypt would write.
+func (t Tlock) EncryptFrom(dst io.Writer, src io.ReaderAt, size int64, roundNumber uint64) error {
+	return t.EncryptFromContext(context.Background(), dst, src, size, roundNumber)
+}
+
+// EncryptFromContext works like EncryptFrom but stops encrypting as soon as
+// the context is canceled.
+func (t Tlock) EncryptFromContext(ctx context.Context, dst io.Writer, src io.ReaderAt, size int64, roundNumber uint64) error {
+	if size < 0 {
+		return fmt.Errorf("invalid size %d", size)
+	}
+
+	if t.compactSmall && size <= MaxCompactSize {
+		t.chunkSize = MaxCompactSize
+		t.compact = true
+	}
+
+	sr := io.NewSectionReader(src, 0, size)
+	return t.encrypt(ctx, dst, sr, roundNumber, func(w *streamWriter) (int64, error) {
+		return w.writeFrom(ctx, src, size, runtime.GOMAXPROCS(0))
+	})
+}
+
+// =============================================================================
+
+// writeFrom encrypts the source of the specified size as the whole payload,
+// including the last chunk. Batches of chunks are read and sealed by the
+// workers at the same time and written in order, so no more than one chunk
+// per worker is held in memory. It returns the number of bytes of plain data
+// written.
+func (w *streamWriter) writeFrom(ctx context.Context, src io.ReaderAt, size int64, workers int) (int64, error) {
+	chunkSize := int64(w.chunkSize)
+
+	// An empty payload still holds a single empty chunk, and a full last
+	// chunk isn't followed by an empty one.
+	chunks := (size + chunkSize - 1) / chunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+
+	if err := w.limits.checkChunk(uint64(chunks), size); err != nil {
+		return 0, err
+	}
+
+	bufs := make([][]byte, workers)
+	for i := range bufs {
+		bufs[i] = make([]byte, 0, w.chunkSize+w.aead.Overhead())
+	}
+	errs := make([]error, workers)
+
+	var written int64
+	for first := int64(0); first < chunks; first += int64(workers) {
+		if err := ctx.Err(); err != nil {
+			return written, fmt.Errorf("write: %w", err)
+		}
+
+		batch := chunks - first
+		if batch > int64(workers) {
+			batch = int64(workers)
+		}
+
+		var wg sync.WaitGroup
+		for i := int64(0); i < batch; i++ {
+			wg.Add(1)
+			go func(i int64) {
+				defer wg.Done()
+				bufs[i], errs[i] = w.sealAt(bufs[i], src, size, first+i, first+i == chunks-1)
+			}(i)
+		}
+		wg.Wait()
+
+		for i := int64(0); i < batch; i++ {
+			if errs[i] != nil {
+				return written, fmt.Errorf("write: %w", errs[i])
+			}
+
+			if _, err := w.dst.Write(bufs[i]); err != nil {
+				return written, fmt.Errorf("write: %w", err)
+			}
+			written += int64(len(bufs[i]) - w.aead.Overhead())
+
+			chunk := first + i
+			if chunk == chunks-1 {
+				break
+			}
+
+			w.chunks = uint64(chunk + 1)
+			if w.checkpoint == nil {
+				continue
+			}
+
+			if err := w.checkpoint(w.chunks); err != nil {
+				return written, fmt.Errorf("write: %w", err)
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// sealAt reads the chunk of the source at the specified index and seals it
+// into the buffer.
+func (w *streamWriter) sealAt(buf []byte, src io.ReaderAt, size int64, chunk int64, last bool) ([]byte, error) {
+	off := chunk * int64(w.chunkSize)
+	n := size - off
+	if n > int64(w.chunkSize) {
+		n = int64(w.chunkSize)
+	}
+
+	buf = buf[:n]
+	if r, err := src.ReadAt(buf, off); r < len(buf) {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return buf, fmt.Errorf("read chunk %d: %w", chunk, err)
+	}
+
+	nonce := make([]byte, w.aead.NonceSize())
+	codec.SetCounter(nonce, uint64(chunk))
+	codec.SetLast(nonce, last)
+
+	return w.aead.Seal(buf[:0], nonce, buf, w.ad), nil
+}
@@ -0,0 +1,90 @@
+package tlock
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/drand/tlock/armor"
+	"github.com/drand/tlock/networks"
+)
+
+// ErrPolicy represents an error when an encryption is rejected by the policy
+// of the tlock.
+var ErrPolicy = errors.New("policy violation")
+
+// Policy restricts the encryptions a tlock accepts, so organizations can
+// rule out mistakes like locking data for decades or to a test network. The
+// zero value allows everything.
+type Policy struct {
+	// MinDuration and MaxDuration bound how long after the encryption the
+	// round is reached. Zero values leave the bound unset.
+	MinDuration time.Duration
+	MaxDuration time.Duration
+
+	// AllowedChains lists the hashes of the chains data can be encrypted
+	// to. An empty list allows any chain.
+	AllowedChains []string
+
+	// RequireArmor requires the destination to be an *armor.Writer.
+	RequireArmor bool
+
+	// ForbidPastRounds rejects rounds that were already reached, which
+	// would leave the data unprotected.
+	ForbidPastRounds bool
+}
+
+// WithPolicy sets the policy encryptions are checked against before anything
+// is written. Decryption isn't restricted by the policy.
+func WithPolicy(policy Policy) Option {
+	return func(t *Tlock) {
+		t.policy = &policy
+	}
+}
+
+// Check verifies that encrypting to the destination for the round of the
+// network at the specified time complies with the policy. Checks based on
+// time require a network that can tell when the round is reached, like the
+// one of the networks/http package.
+func (p Policy) Check(network Network, roundNumber uint64, now time.Time, dst io.Writer) error {
+	if len(p.AllowedChains) > 0 && !contains(p.AllowedChains, network.ChainHash()) {
+		return fmt.Errorf("%w: chain %s is not allowed", ErrPolicy, network.ChainHash())
+	}
+
+	if p.RequireArmor {
+		if _, ok := dst.(*armor.Writer); !ok {
+			return fmt.Errorf("%w: encrypted data has to be armored", ErrPolicy)
+		}
+	}
+
+	if !p.ForbidPastRounds && p.MinDuration == 0 && p.MaxDuration == 0 {
+		return nil
+	}
+
+	rt, ok := network.(networks.RoundTimer)
+	if !ok {
+		return fmt.Errorf("%w: the network can't tell when round %d is reached", ErrPolicy, roundNumber)
+	}
+
+	switch duration := rt.RoundTime(roundNumber).Sub(now); {
+	case p.ForbidPastRounds && duration <= 0:
+		return fmt.Errorf("%w: round %d was already reached", ErrPolicy, roundNumber)
+	case p.MinDuration != 0 && duration < p.MinDuration:
+		return fmt.Errorf("%w: round %d is reached in %s, before the minimum of %s", ErrPolicy, roundNumber, duration.Round(time.Second), p.MinDuration)
+	case p.MaxDuration != 0 && duration > p.MaxDuration:
+		return fmt.Errorf("%w: round %d is reached in %s, after the maximum of %s", ErrPolicy, roundNumber, duration.Round(time.Second), p.MaxDuration)
+	}
+
+	return nil
+}
+
+// contains reports whether the value is in the list.
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package tlock
+
+import (
+	"context"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/drand/tlock/internal/codec"
+)
+
+// ReaderAt provides random access to the plain data of encrypted data. Only
+// the chunks covering the requested range are read and decrypted, so parts of
+// large files such as disk images or datasets can be read without decrypting
+// the rest. A ReaderAt is safe for concurrent use if its source is.
+type ReaderAt struct {
+	header    Header
+	src       io.ReaderAt
+	aead      cipher.AEAD
+	ad        []byte
+	chunkSize int64
+	start     int64
+	end       int64
+	chunks    int64
+	size      int64
+
+	mu        sync.Mutex
+	nonce     []byte
+	encrypted []byte
+	plain     []byte
+	cached    int64
+}
+
+// DecryptReaderAt returns a reader providing random access to the plain data
+// of the encrypted source of the specified size. The header is read and the
+// key is recovered from the network right away, so this fails with
+// ErrTooEarly before the round is reached. Armored data can't be read at
+// arbitrary offsets, so the source must hold the binary format.
+func (t Tlock) DecryptReaderAt(ctx context.Context, src io.ReaderAt, size int64) (_ *ReaderAt, err error) {
+	ctx, span := t.startSpan(ctx, "tlock.DecryptReaderAt", attrEncrypted.Int64(size))
+	defer func() { endSpan(span, err) }()
+
+	br, text, armored := dearmor(io.NewSectionReader(src, 0, size))
+	if armored || text != nil {
+		return nil, errors.New("random access requires unarmored data")
+	}
+
+	info, aead, start, err := t.unlock(ctx, br, text)
+	if err != nil {
+		return nil, err
+	}
+
+	overhead := int64(aead.Overhead())
+	encryptedChunk := int64(info.ChunkSize) + overhead
+
+	payload := size - start
+	if payload < overhead {
+		return nil, fmt.Errorf("payload truncated: %w", io.ErrUnexpectedEOF)
+	}
+
+	// Every chunk but the last is full, and only a single chunk can be
+	// empty.
+	chunks := (payload + encryptedChunk - 1) / encryptedChunk
+	if last := payload - (chunks-1)*encryptedChunk; last == overhead && chunks > 1 {
+		return nil, errors.New("last chunk is empty")
+	}
+
+	r := ReaderAt{
+		header:    info,
+		src:       src,
+		aead:      aead,
+		ad:        additionalData(t.aad),
+		chunkSize: int64(info.ChunkSize),
+		start:     start,
+		end:       size,
+		chunks:    chunks,
+		size:      payload - chunks*overhead,
+		nonce:     make([]byte, aead.NonceSize()),
+		encrypted: make([]byte, encryptedChunk),
+		plain:     make([]byte, 0, info.ChunkSize),
+		cached:    -1,
+	}
+
+	// The last chunk is authenticated right away, so truncated data is
+	// detected before anything is read.
+	if _, err := r.chunk(chunks - 1); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// Header returns the time lock information of the encrypted data, whose
+// extensions were authenticated when the reader was created.
+func (r *ReaderAt) Header() Header {
+	return r.header
+}
+
+// Size returns the size of the plain data.
+func (r *ReaderAt) Size() int64 {
+	return r.size
+}
+
+// ReadAt implements the io.ReaderAt interface.
+func (r *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= r.size {
+			return n, io.EOF
+		}
+
+		i := pos / r.chunkSize
+		plain, err := r.chunk(i)
+		if err != nil {
+			return n, err
+		}
+
+		n += copy(p[n:], plain[pos-i*r.chunkSize:])
+	}
+
+	return n, nil
+}
+
+// chunk returns the plain data of the chunk, reading and decrypting it
+// unless it's the last one that was used. It must be called with the mutex
+// held.
+func (r *ReaderAt) chunk(i int64) ([]byte, error) {
+	if i == r.cached {
+		return r.plain, nil
+	}
+	r.cached = -1
+
+	offset := r.start + i*int64(len(r.encrypted))
+	encrypted := r.encrypted
+	if remaining := r.end - offset; remaining < int64(len(encrypted)) {
+		encrypted = encrypted[:remaining]
+	}
+
+	if n, err := r.src.ReadAt(encrypted, offset); n < len(encrypted) {
+		return nil, fmt.Errorf("read payload chunk %d: %w", i, err)
+	}
+
+	last := i == r.chunks-1
+	codec.SetCounter(r.nonce, uint64(i))
+	codec.SetLast(r.nonce, last)
+
+	plain, err := r.aead.Open(r.plain[:0], r.nonce, encrypted, r.ad)
+	if err != nil {
+		if i == 0 && r.ad != nil {
+			return nil, fmt.Errorf("%w or the payload was modified", ErrAADMismatch)
+		}
+		return nil, fmt.Errorf("failed to decrypt and authenticate payload chunk %d", i)
+	}
+
+	r.plain = plain
+	r.cached = i
+
+	return plain, nil
+}
@@ -0,0 +1,129 @@
+package tlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Checkpoint records the progress of an encryption or decryption after a
+// complete chunk of the payload. An interrupted operation can be resumed
+// from its last checkpoint with ResumeEncryptContext or ResumeDecryptContext,
+// which is useful for large files on unreliable storage.
+type Checkpoint struct {
+	// Chunks is the number of payload chunks that were processed.
+	Chunks uint64 `json:"chunks"`
+
+	// Read is the number of bytes consumed from the source. For decryption
+	// this is counted after removing the armor.
+	Read int64 `json:"read"`
+
+	// Written is the number of bytes written to the destination.
+	Written int64 `json:"written"`
+
+	// The following fields are only recorded by encryption, which can't
+	// recover the payload settings before the round is reached. The key
+	// decrypts the payload, so a checkpoint of an encryption has to be kept
+	// as safe as the plain data and discarded once the encryption completed.
+	AEAD           AEAD   `json:"aead,omitempty"`
+	ChunkSize      int    `json:"chunk_size,omitempty"`
+	Key            []byte `json:"key,omitempty"`
+	AdditionalData []byte `json:"additional_data,omitempty"`
+}
+
+// WithCheckpoint sets a function that is called with the progress of an
+// encryption or decryption after every intermediate chunk of the payload.
+// The chunk was written to the destination by then, but not necessarily
+// flushed, so the function has to flush the destination before recording
+// the checkpoint. An error returned by the function stops the operation.
+func WithCheckpoint(fn func(Checkpoint) error) Option {
+	return func(t *Tlock) {
+		t.checkpoint = fn
+	}
+}
+
+// ResumeEncryptContext continues an encryption from a checkpoint. The source
+// has to be positioned at the checkpoint's Read offset and the destination
+// at its Written offset, so the data written after the checkpoint is
+// replaced. The payload settings are taken from the checkpoint, which means
+// the options of the tlock other than the logger and checkpoint function are
+// ignored, and no network access is needed.
+func (t Tlock) ResumeEncryptContext(ctx context.Context, dst io.Writer, src io.Reader, cp Checkpoint) error {
+	if cp.Key == nil || cp.ChunkSize <= 0 || cp.ChunkSize > MaxChunkSize {
+		return errors.New("checkpoint was not recorded by an encryption")
+	}
+
+	aead, err := payloadCipher(cp.AEAD, cp.Key)
+	if err != nil {
+		return err
+	}
+
+	start := cp.Written - int64(cp.Chunks)*int64(cp.ChunkSize+aead.Overhead())
+	if start <= 0 || cp.Read != int64(cp.Chunks)*int64(cp.ChunkSize) {
+		return errors.New("checkpoint is inconsistent")
+	}
+
+	t.logf("resuming encryption after %d chunks", cp.Chunks)
+
+	w := newStreamWriter(aead, cp.AdditionalData, dst, cp.ChunkSize)
+	w.limits = t.limits
+	w.skip(cp.Chunks)
+	w.checkpoint = t.encryptCheckpoint(cp, start, aead.Overhead())
+
+	if _, err := io.Copy(w, contextReader{ctx: ctx, r: src}); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+
+	return nil
+}
+
+// ResumeDecryptContext continues a decryption from a checkpoint. The source
+// has to be positioned at the start of the encrypted data, since the header
+// is needed to recover the key, and the destination at the checkpoint's
+// Written offset. The chunks before the checkpoint are skipped without being
+// decrypted, by seeking if the source is an unarmored io.Seeker.
+func (t Tlock) ResumeDecryptContext(ctx context.Context, dst io.Writer, src io.Reader, cp Checkpoint) error {
+	if cp.Key != nil {
+		return errors.New("checkpoint was not recorded by a decryption")
+	}
+
+	return t.decrypt(ctx, dst, src, &cp)
+}
+
+// encryptCheckpoint returns the function reporting the checkpoints of an
+// encryption whose payload starts at the specified offset, or nil if no
+// checkpoint function was set.
+func (t Tlock) encryptCheckpoint(cp Checkpoint, start int64, overhead int) func(uint64) error {
+	if t.checkpoint == nil {
+		return nil
+	}
+
+	return func(chunks uint64) error {
+		cp.Chunks = chunks
+		cp.Read = int64(chunks) * int64(cp.ChunkSize)
+		cp.Written = start + int64(chunks)*int64(cp.ChunkSize+overhead)
+		return t.checkpoint(cp)
+	}
+}
+
+// decryptCheckpoint returns the function reporting the checkpoints of a
+// decryption whose payload starts at the specified offset, or nil if no
+// checkpoint function was set.
+func (t Tlock) decryptCheckpoint(start int64, chunkSize int, overhead int) func(uint64) error {
+	if t.checkpoint == nil {
+		return nil
+	}
+
+	return func(chunks uint64) error {
+		return t.checkpoint(Checkpoint{
+			Chunks:  chunks,
+			Read:    start + int64(chunks)*int64(chunkSize+overhead),
+			Written: int64(chunks) * int64(chunkSize),
+		})
+	}
+}
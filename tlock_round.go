@@ -0,0 +1,260 @@
+package tlock
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drand/tlock/networks"
+)
+
+// ErrInvalidRound represents an error when a round specifier can't be parsed.
+var ErrInvalidRound = errors.New("invalid round specifier")
+
+// timeLayouts lists the accepted layouts for absolute times in round specifiers.
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+}
+
+// ParseRound converts a round specifier into a round number. The specifier
+// can take one of these forms:
+//
+//	1234567                  an absolute round number
+//	+1000                    a number of rounds, at least 1, after the current round
+//	time:2025-07-01T00:00Z   the round available at the given time
+//	dur:45d                  the round available after the given duration
+func ParseRound(spec string, now time.Time, network networks.RoundCalculator) (uint64, error) {
+	switch {
+	case strings.HasPrefix(spec, "+"):
+		n, err := strconv.ParseUint(spec[1:], 10, 64)
+		if err != nil || n == 0 {
+			return 0, fmt.Errorf("%w: relative round %q", ErrInvalidRound, spec)
+		}
+
+		current := network.RoundNumber(now)
+		if current > math.MaxUint64-n {
+			return 0, fmt.Errorf("%w: relative round %q is out of range", ErrInvalidRound, spec)
+		}
+		return current + n, nil
+
+	case strings.HasPrefix(spec, "time:"):
+		value := strings.TrimPrefix(spec, "time:")
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				return network.RoundNumber(t), nil
+			}
+		}
+		return 0, fmt.Errorf("%w: time %q", ErrInvalidRound, value)
+
+	case strings.HasPrefix(spec, "dur:"):
+		target, err := AddDuration(now, strings.TrimPrefix(spec, "dur:"))
+		if err != nil {
+			return 0, err
+		}
+		return network.RoundNumber(target), nil
+	}
+
+	roundNumber, err := strconv.ParseUint(spec, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidRound, spec)
+	}
+
+	return roundNumber, nil
+}
+
+// =============================================================================
+
+// ErrInvalidDuration represents an error when a duration uses an unknown unit.
+var ErrInvalidDuration = errors.New("invalid duration unit")
+
+// calendar accumulates the calendar and clock parts of a duration so they can
+// be applied to a point in time at once.
+type calendar struct {
+	years  int
+	months int
+	days   int
+	clock  time.Duration
+}
+
+// add applies the specified amount of a unit to the calendar.
+func (c *calendar) add(amount string, unit string) error {
+	switch unit {
+	case "y", "M", "w", "d":
+		n, err := strconv.Atoi(amount)
+		if err != nil {
+			return fmt.Errorf("parse %q: calendar units need a whole number", amount+unit)
+		}
+
+		switch unit {
+		case "y":
+			c.years += n
+		case "M":
+			c.months += n
+		case "w":
+			c.days += 7 * n
+		case "d":
+			c.days += n
+		}
+
+	case "h", "m", "s", "ms", "us", "µs", "ns":
+		d, err := time.ParseDuration(amount + unit)
+		if err != nil {
+			return fmt.Errorf("parse %q: %w", amount+unit, err)
+		}
+		c.clock += d
+
+	default:
+		return ErrInvalidDuration
+	}
+
+	return nil
+}
+
+// after returns t moved forward by the calendar. The calendar units are
+// applied to the date first, so months and years keep their varying lengths,
+// and the clock units are added to the result.
+func (c calendar) after(t time.Time) time.Time {
+	return t.AddDate(c.years, c.months, c.days).Add(c.clock)
+}
+
+// ParseDuration parses the duration relative to t and can handle weeks, days,
+// months, and years in addition to the units understood by time.ParseDuration.
+// Units can be combined like 1y6M or 2w3d12h, and ISO-8601 durations like
+// P1Y2M3DT4H are accepted too. Callers that need a point in time should use
+// AddDuration, since the length of months and years depends on t.
+func ParseDuration(t time.Time, duration string) (time.Duration, error) {
+	target, err := AddDuration(t, duration)
+	if err != nil {
+		return time.Second, err
+	}
+
+	return target.Sub(t), nil
+}
+
+// AddDuration parses the duration like ParseDuration and returns t moved
+// forward by it. The input is split into number and unit components, and
+// anything left over, such as a number without a unit, is rejected, as are
+// negative durations and units given more than once.
+func AddDuration(t time.Time, duration string) (time.Time, error) {
+	if strings.HasPrefix(duration, "P") {
+		return parseISODuration(t, duration)
+	}
+
+	if strings.HasPrefix(duration, "-") {
+		return time.Time{}, fmt.Errorf("parse duration %q: negative duration", duration)
+	}
+
+	var c calendar
+	rest := duration
+	if rest == "" {
+		return time.Time{}, errors.New("parse duration: empty duration")
+	}
+
+	seen := make(map[string]bool)
+	for rest != "" {
+		amount, unit, remaining := nextComponent(rest)
+		if amount == "" {
+			return time.Time{}, fmt.Errorf("parse duration %q: missing number before %q", duration, rest)
+		}
+		if unit == "" {
+			return time.Time{}, fmt.Errorf("parse duration %q: missing unit after %q", duration, amount)
+		}
+		if seen[unit] {
+			return time.Time{}, fmt.Errorf("parse duration %q: unit %q given more than once", duration, unit)
+		}
+		seen[unit] = true
+
+		// M has to be capitalised to avoid conflict with minutes.
+		if err := c.add(amount, unit); err != nil {
+			if errors.Is(err, ErrInvalidDuration) {
+				return time.Time{}, fmt.Errorf("parse duration %q: %w %q", duration, ErrInvalidDuration, unit)
+			}
+			return time.Time{}, fmt.Errorf("parse duration: %w", err)
+		}
+
+		rest = remaining
+	}
+
+	return c.after(t), nil
+}
+
+// nextComponent splits the leading number and unit off a duration string.
+func nextComponent(s string) (amount string, unit string, rest string) {
+	i := 0
+	for i < len(s) && isNumeric(s[i]) {
+		i++
+	}
+
+	j := i
+	for j < len(s) && !isNumeric(s[j]) {
+		j++
+	}
+
+	return s[:i], s[i:j], s[j:]
+}
+
+// isNumeric reports whether the byte can be part of a duration amount.
+func isNumeric(b byte) bool {
+	return b == '.' || ('0' <= b && b <= '9')
+}
+
+// parseISODuration parses ISO-8601 durations of the form PnYnMnWnDTnHnMnS and
+// returns t moved forward by it. As with the short form, the calendar units
+// need whole numbers.
+func parseISODuration(t time.Time, duration string) (time.Time, error) {
+	invalid := fmt.Errorf("parse duration %q: invalid ISO-8601 duration", duration)
+
+	body := strings.TrimPrefix(duration, "P")
+	datePart, timePart := body, ""
+	if i := strings.Index(body, "T"); i >= 0 {
+		datePart, timePart = body[:i], body[i+1:]
+		if timePart == "" {
+			return time.Time{}, invalid
+		}
+	}
+	if datePart == "" && timePart == "" {
+		return time.Time{}, invalid
+	}
+
+	var c calendar
+	dateUnits := map[string]string{"Y": "y", "M": "M", "W": "w", "D": "d"}
+	timeUnits := map[string]string{"H": "h", "M": "m", "S": "s"}
+
+	parts := []struct {
+		text  string
+		units map[string]string
+		order string
+	}{
+		{text: datePart, units: dateUnits, order: "YMWD"},
+		{text: timePart, units: timeUnits, order: "HMS"},
+	}
+
+	for _, part := range parts {
+		last := -1
+		for rest := part.text; rest != ""; {
+			amount, unit, remaining := nextComponent(rest)
+			if amount == "" || len(unit) != 1 {
+				return time.Time{}, invalid
+			}
+
+			pos := strings.Index(part.order, unit)
+			if pos <= last {
+				return time.Time{}, invalid
+			}
+			last = pos
+
+			if err := c.add(amount, part.units[unit]); err != nil {
+				return time.Time{}, fmt.Errorf("parse duration: %w", err)
+			}
+
+			rest = remaining
+		}
+	}
+
+	return c.after(t), nil
+}
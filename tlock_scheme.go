@@ -0,0 +1,396 @@
+package tlock
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/drand/drand/chain"
+	"github.com/drand/drand/common/scheme"
+	"github.com/drand/kyber"
+	bls "github.com/drand/kyber-bls12381"
+	"github.com/drand/kyber/encrypt/ibe"
+	"github.com/drand/kyber/pairing"
+	"github.com/drand/tlock/internal/bn254"
+	"github.com/drand/tlock/internal/g1"
+	"github.com/drand/tlock/networks"
+)
+
+// These constants identify the drand schemes recorded in the header of
+// encrypted data. Chained chains can't be used, since the message of a round
+// includes the signature of the previous round, which isn't known in
+// advance.
+const (
+	// SchemeUnchained signs the rounds on G2 with a public key on G1, like
+	// the default and mainnet chains. It's the scheme of data without one
+	// recorded in the header.
+	SchemeUnchained = scheme.UnchainedSchemeID
+
+	// SchemeUnchainedG1 signs the rounds on G1 with a public key on G2, like
+	// the quicknet chain.
+	SchemeUnchainedG1 = "bls-unchained-g1-rfc9380"
+
+	// SchemeUnchainedOnG1 works like SchemeUnchainedG1 but hashes the rounds
+	// with the domain of signatures on G2, as the first chains signing on G1
+	// did before quicknet.
+	SchemeUnchainedOnG1 = "bls-unchained-on-g1"
+
+	// SchemeBN254UnchainedOnG1 signs the Keccak-256 of the rounds on G1 of
+	// the BN254 curve with a public key on its G2, like the evmnet chain, so
+	// EVM contracts can verify its signatures.
+	SchemeBN254UnchainedOnG1 = "bls-bn254-unchained-on-g1"
+)
+
+// ErrInvalidCiphertext represents an error when the time lock encrypted data
+// encryption key of the header isn't a valid ciphertext of the scheme, or
+// can't be decrypted with the signature of its round.
+var ErrInvalidCiphertext = errors.New("invalid ciphertext")
+
+// =============================================================================
+
+// timelockScheme provides the groups of a drand scheme used to encrypt to and
+// decrypt with the signature of a round.
+type timelockScheme struct {
+	id string
+
+	// version is the format version required to decrypt data of the scheme.
+	version int
+
+	// identity returns the message the chain signs for a round, which the
+	// data key is encrypted to.
+	identity func(roundNumber uint64) []byte
+
+	// suite is the pairing suite whose G1 holds the public key and the
+	// ciphertext point, and whose G2 holds the signatures.
+	suite pairing.Suite
+
+	// verify checks the signature of a round against the public key.
+	verify func(publicKey kyber.Point, roundNumber uint64, signature []byte) error
+
+	// rounds keeps the pairings and verified signatures of recently used
+	// rounds.
+	rounds *roundCache
+}
+
+// schemes holds the supported schemes by identifier.
+var schemes = map[string]*timelockScheme{
+	SchemeUnchained: {
+		id:       SchemeUnchained,
+		version:  1,
+		identity: roundIdentity,
+		suite:    bls.NewBLS12381Suite(),
+		verify:   verifierOnG2(),
+		rounds:   newRoundCache(),
+	},
+	SchemeUnchainedG1: {
+		id:       SchemeUnchainedG1,
+		version:  2,
+		identity: roundIdentity,
+		suite:    g1Suite{Suite: bls.NewBLS12381Suite(), dst: g1.DST},
+		verify:   verifierOnG1(roundIdentity, g1.DST),
+		rounds:   newRoundCache(),
+	},
+	SchemeUnchainedOnG1: {
+		id:       SchemeUnchainedOnG1,
+		version:  2,
+		identity: roundIdentity,
+		suite:    g1Suite{Suite: bls.NewBLS12381Suite(), dst: g1.LegacyDST},
+		verify:   verifierOnG1(roundIdentity, g1.LegacyDST),
+		rounds:   newRoundCache(),
+	},
+	SchemeBN254UnchainedOnG1: {
+		id:       SchemeBN254UnchainedOnG1,
+		version:  2,
+		identity: bn254.Identity,
+		suite:    bn254Suite{Suite: bn254.NewSuite()},
+		verify:   verifierOnBN254(),
+		rounds:   newRoundCache(),
+	},
+}
+
+// unsupportedSchemes explains why known drand schemes can't be used.
+var unsupportedSchemes = map[string]string{
+	scheme.DefaultSchemeID: "is chained and can't be used for time lock encryption",
+}
+
+// schemeByID returns the scheme with the identifier.
+func schemeByID(id string) (*timelockScheme, error) {
+	if reason, exists := unsupportedSchemes[id]; exists {
+		return nil, fmt.Errorf("scheme %s %s", id, reason)
+	}
+
+	sch, exists := schemes[id]
+	if !exists {
+		return nil, fmt.Errorf("unsupported scheme %q", id)
+	}
+
+	return sch, nil
+}
+
+// RoundIdentity returns the identity that chains of the scheme sign for the
+// round, which data locked to the round is encrypted to. The schemes on
+// BLS12-381 sign the SHA-256 of the round as an 8 byte big endian integer,
+// and differ in how they hash it to the group of the signatures, while
+// SchemeBN254UnchainedOnG1 signs its Keccak-256.
+func RoundIdentity(schemeID string, roundNumber uint64) ([]byte, error) {
+	sch, err := schemeByID(schemeID)
+	if err != nil {
+		return nil, err
+	}
+
+	return sch.identity(roundNumber), nil
+}
+
+// networkScheme returns the scheme of the network.
+func networkScheme(network Network) (*timelockScheme, error) {
+	if sn, ok := network.(networks.SchemeNetwork); ok {
+		return schemeByID(sn.SchemeID())
+	}
+
+	return schemes[SchemeUnchained], nil
+}
+
+// cachedRounds returns the cache of the rounds of the scheme, or nil when the
+// tlock is hardened, so the time taken doesn't depend on the rounds used
+// before.
+func (s *timelockScheme) cachedRounds(hardened bool) *roundCache {
+	if hardened {
+		return nil
+	}
+	return s.rounds
+}
+
+// pairRound returns the pairing of the public key with the identity of the
+// round, which timeLockToPairing encrypts to.
+func (s *timelockScheme) pairRound(publicKey kyber.Point, roundNumber uint64) (kyber.Point, error) {
+	gid, err := pairIdentity(s.suite, publicKey, s.identity(roundNumber))
+	if err != nil {
+		return nil, fmt.Errorf("pair identity: %w", err)
+	}
+
+	return gid, nil
+}
+
+// timeLockToPairing encrypts the data for the round whose pairing was
+// returned by pairRound, with the random element read from sigma, or a
+// random one if sigma is nil. This saves the pairing, the costliest part of
+// the encryption, when encrypting to the same round many times.
+func (s *timelockScheme) timeLockToPairing(gid kyber.Point, data []byte, sigma io.Reader) (*ibe.Ciphertext, error) {
+	if sigma == nil {
+		sigma = rand.Reader
+	}
+
+	b := make([]byte, len(data))
+	if _, err := io.ReadFull(sigma, b); err != nil {
+		return nil, fmt.Errorf("generate sigma: %w", err)
+	}
+
+	ciphertext, err := encryptWithSigma(s.suite, gid, data, b)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt data: %w", err)
+	}
+
+	return ciphertext, nil
+}
+
+// timeUnlock verifies the signature of the round of the chain, unless the
+// cache holds it already, and decrypts the ciphertext with it.
+func (s *timelockScheme) timeUnlock(rounds *roundCache, chainHash string, publicKey kyber.Point, roundNumber uint64, signature []byte, ciphertext *ibe.Ciphertext) ([]byte, error) {
+	if err := rounds.verify(s, chainHash, publicKey, roundNumber, signature); err != nil {
+		return nil, fmt.Errorf("verify beacon: %w", err)
+	}
+
+	point := s.suite.G2().Point()
+	if err := point.UnmarshalBinary(signature); err != nil {
+		return nil, fmt.Errorf("unmarshal signature: %w", err)
+	}
+
+	data, err := ibe.Decrypt(s.suite, point, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCiphertext, err)
+	}
+
+	return data, nil
+}
+
+// ciphertext converts the bytes of a stanza body to a ciphertext, whose point
+// belongs to the group of the public key.
+func (s *timelockScheme) ciphertext(b []byte) (*ibe.Ciphertext, error) {
+	u := s.suite.G1().Point()
+
+	expLen := u.MarshalSize() + cipherVLen + cipherWLen
+	if len(b) != expLen {
+		return nil, fmt.Errorf("%w: incorrect length: exp: %d got: %d", ErrInvalidCiphertext, expLen, len(b))
+	}
+
+	if err := u.UnmarshalBinary(b[:u.MarshalSize()]); err != nil {
+		return nil, fmt.Errorf("%w: unmarshal ciphertext point: %v", ErrInvalidCiphertext, err)
+	}
+
+	ct := ibe.Ciphertext{
+		U: u,
+		V: append([]byte(nil), b[u.MarshalSize():u.MarshalSize()+cipherVLen]...),
+		W: append([]byte(nil), b[u.MarshalSize()+cipherVLen:]...),
+	}
+
+	return &ct, nil
+}
+
+// =============================================================================
+
+// g1Suite is the pairing suite of schemes signing on G1. Its groups are
+// swapped, so the identity based encryption of kyber, which expects the
+// public key on G1 and the signatures on G2, can be used as is. Messages are
+// hashed to the signatures with the domain separation tag of the scheme.
+type g1Suite struct {
+	pairing.Suite
+	dst []byte
+}
+
+// G1 returns the group of the public key, which is G2 of BLS12-381.
+func (s g1Suite) G1() kyber.Group {
+	return s.Suite.G2()
+}
+
+// G2 returns the group of the signatures, which is G1 of BLS12-381 hashing
+// with the domain of the scheme.
+func (s g1Suite) G2() kyber.Group {
+	return g1Group{Group: s.Suite.G1(), dst: s.dst}
+}
+
+// Pair computes the pairing of the points of the swapped groups.
+func (s g1Suite) Pair(p1 kyber.Point, p2 kyber.Point) kyber.Point {
+	return s.Suite.Pair(unwrapPoint(p2), unwrapPoint(p1))
+}
+
+// g1Group is the G1 group of BLS12-381 whose points hash messages as
+// specified by RFC 9380 with the domain separation tag.
+type g1Group struct {
+	kyber.Group
+	dst []byte
+}
+
+// Point returns a new point of the group.
+func (g g1Group) Point() kyber.Point {
+	return &signaturePoint{KyberG1: bls.NullKyberG1(), dst: g.dst}
+}
+
+// signaturePoint is a point of G1 that hashes messages with the domain
+// separation tag.
+type signaturePoint struct {
+	*bls.KyberG1
+	dst []byte
+}
+
+// Hash hashes the message to the point.
+func (p *signaturePoint) Hash(msg []byte) kyber.Point {
+	point, err := g1.HashWithDST(msg, p.dst)
+	if err != nil {
+		// Hashing to the curve only fails for domains that are too long.
+		panic(err)
+	}
+
+	p.KyberG1 = point
+	return point
+}
+
+// unwrapPoint returns the point of kyber-bls12381 held by a signature point,
+// which is what its pairing requires.
+func unwrapPoint(p kyber.Point) kyber.Point {
+	if sp, ok := p.(*signaturePoint); ok {
+		return sp.KyberG1
+	}
+	return p
+}
+
+// bn254Suite is the pairing suite of SchemeBN254UnchainedOnG1, whose groups
+// are swapped like those of g1Suite.
+type bn254Suite struct {
+	*bn254.Suite
+}
+
+// G1 returns the group of the public key, which is G2 of BN254.
+func (s bn254Suite) G1() kyber.Group {
+	return s.Suite.G2()
+}
+
+// G2 returns the group of the signatures, which is G1 of BN254.
+func (s bn254Suite) G2() kyber.Group {
+	return s.Suite.G1()
+}
+
+// Pair computes the pairing of the points of the swapped groups.
+func (s bn254Suite) Pair(p1 kyber.Point, p2 kyber.Point) kyber.Point {
+	return s.Suite.Pair(p2, p1)
+}
+
+// verifierOnG2 returns the function checking a signature on G2 of a round of
+// an unchained chain against the public key on G1.
+func verifierOnG2() func(kyber.Point, uint64, []byte) error {
+	verifier := chain.NewVerifier(scheme.Scheme{ID: scheme.UnchainedSchemeID, DecouplePrevSig: true})
+
+	return func(publicKey kyber.Point, roundNumber uint64, signature []byte) error {
+		if _, ok := publicKey.(*bls.KyberG1); !ok {
+			return errors.New("public key isn't on G1")
+		}
+		return verifier.VerifyBeacon(chain.Beacon{Round: roundNumber, Signature: signature}, publicKey)
+	}
+}
+
+// verifierOnG1 returns the function checking a signature on G1 of the
+// identity of a round, hashed with the domain separation tag, against the
+// public key on G2.
+func verifierOnG1(identity func(roundNumber uint64) []byte, dst []byte) func(kyber.Point, uint64, []byte) error {
+	suite := bls.NewBLS12381Suite()
+
+	return func(publicKey kyber.Point, roundNumber uint64, signature []byte) error {
+		if _, ok := publicKey.(*bls.KyberG2); !ok {
+			return errors.New("public key isn't on G2")
+		}
+
+		msg, err := g1.HashWithDST(identity(roundNumber), dst)
+		if err != nil {
+			return err
+		}
+
+		sig := bls.NullKyberG1()
+		if err := sig.UnmarshalBinary(signature); err != nil {
+			return fmt.Errorf("unmarshal signature: %w", err)
+		}
+
+		if !suite.ValidatePairing(msg, publicKey, sig, suite.G2().Point().Base()) {
+			return errors.New("invalid signature")
+		}
+
+		return nil
+	}
+}
+
+// verifierOnBN254 returns the function checking a signature on G1 of BN254
+// of a round against the public key on G2.
+func verifierOnBN254() func(kyber.Point, uint64, []byte) error {
+	suite := bn254.NewSuite()
+
+	return func(publicKey kyber.Point, roundNumber uint64, signature []byte) error {
+		if _, ok := publicKey.(*bn254.PointG2); !ok {
+			return errors.New("public key isn't on G2 of BN254")
+		}
+
+		msg, err := bn254.Hash(bn254.Identity(roundNumber), bn254.DST)
+		if err != nil {
+			return err
+		}
+
+		sig := suite.G1().Point()
+		if err := sig.UnmarshalBinary(signature); err != nil {
+			return fmt.Errorf("unmarshal signature: %w", err)
+		}
+
+		if !suite.ValidatePairing(msg, publicKey, sig, suite.G2().Point().Base()) {
+			return errors.New("invalid signature")
+		}
+
+		return nil
+	}
+}
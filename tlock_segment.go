@@ -0,0 +1,214 @@
+package tlock
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// segmentsIntro is the first line of data encrypted by EncryptSegments. It
+// keeps segmented data from being mistaken for the data of a single round.
+const segmentsIntro = "tlock-segments/v1\n"
+
+// Segment is a part of the data encrypted by EncryptSegments, which can be
+// decrypted once its round is reached.
+type Segment struct {
+	RoundNumber uint64
+	Src         io.Reader
+}
+
+// Progress describes the decryption of segmented data by DecryptSegments.
+type Progress struct {
+	// Decrypted is the number of segments that were decrypted.
+	Decrypted int
+
+	// Locked holds the headers of the segments that can't be decrypted yet,
+	// in the order of the data, telling when the rest becomes readable.
+	Locked []Header
+}
+
+// EncryptSegments encrypts every segment for its own round into a single
+// file that progressively becomes readable, such as a book releasing one
+// chapter per week. The segments are encrypted like the data of Encrypt, one
+// after the other, and their rounds can't decrease. Segmented data is
+// decrypted with DecryptSegments.
+func (t Tlock) EncryptSegments(dst io.Writer, segments []Segment) error {
+	return t.EncryptSegmentsContext(context.Background(), dst, segments)
+}
+
+// EncryptSegmentsContext works like EncryptSegments but stops reading the
+// segments as soon as the context is canceled.
+func (t Tlock) EncryptSegmentsContext(ctx context.Context, dst io.Writer, segments []Segment) error {
+	if len(segments) == 0 {
+		return errors.New("no segments to encrypt")
+	}
+
+	for i, s := range segments {
+		if i > 0 && s.RoundNumber < segments[i-1].RoundNumber {
+			return fmt.Errorf("segment %d: round %d is before the round of the previous segment", i, s.RoundNumber)
+		}
+
+		if t.policy != nil {
+			if err := t.policy.Check(t.network, s.RoundNumber, t.clock.Now(), dst); err != nil {
+				return fmt.Errorf("segment %d: %w", i, err)
+			}
+		}
+	}
+
+	// The policy was checked against the destination, and checkpoints can't
+	// resume from the middle of a segment.
+	t.policy = nil
+	t.checkpoint = nil
+
+	if _, err := io.WriteString(dst, segmentsIntro); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+
+	for i, s := range segments {
+		w := frameWriter{w: dst}
+		if err := t.EncryptContext(ctx, &w, s.Src, s.RoundNumber); err != nil {
+			return fmt.Errorf("segment %d: %w", i, err)
+		}
+
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("segment %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// DecryptSegments decrypts the segments of data encrypted by EncryptSegments
+// whose round is reached and writes them to the destination, in order. The
+// segments that are still locked are reported without failing, so the data
+// can be decrypted again once more rounds are reached.
+func (t Tlock) DecryptSegments(dst io.Writer, src io.Reader) (Progress, error) {
+	return t.DecryptSegmentsContext(context.Background(), dst, src)
+}
+
+// DecryptSegmentsContext works like DecryptSegments but stops retrieving
+// signatures from the network and writing the decrypted data as soon as the
+// context is canceled.
+func (t Tlock) DecryptSegmentsContext(ctx context.Context, dst io.Writer, src io.Reader) (Progress, error) {
+	br, text, _ := dearmor(src)
+
+	intro, err := br.Peek(len(segmentsIntro))
+	if err != nil || string(intro) != segmentsIntro {
+		return Progress{}, fmt.Errorf("read header: %w", text.check(errors.New("not segmented data")))
+	}
+	br.Discard(len(segmentsIntro))
+
+	// Checkpoints can't resume from the middle of a segment.
+	var progress Progress
+	t.checkpoint = nil
+
+	for i := 0; ; i++ {
+		if _, err := br.Peek(1); errors.Is(err, io.EOF) {
+			break
+		}
+
+		fr := frameReader{r: br}
+
+		var buf bytes.Buffer
+		header, err := ReadHeader(io.TeeReader(&fr, &buf))
+		if err != nil {
+			return progress, fmt.Errorf("segment %d: %w", i, text.check(err))
+		}
+
+		// The rounds of the segments can't decrease, so once a segment is
+		// locked the rest of them is locked as well.
+		if len(progress.Locked) == 0 {
+			err := t.DecryptContext(ctx, dst, io.MultiReader(&buf, &fr))
+			switch {
+			case err == nil:
+				progress.Decrypted++
+			case errors.Is(err, ErrTooEarly):
+				progress.Locked = append(progress.Locked, header)
+			default:
+				return progress, fmt.Errorf("segment %d: %w", i, err)
+			}
+		} else {
+			progress.Locked = append(progress.Locked, header)
+		}
+
+		if _, err := io.Copy(io.Discard, &fr); err != nil {
+			return progress, fmt.Errorf("segment %d: %w", i, text.check(err))
+		}
+	}
+
+	return progress, nil
+}
+
+// =============================================================================
+
+// frameWriter writes the data of a segment as length prefixed frames, so the
+// end of the segment can be found without decrypting it. Closing the writer
+// writes an empty frame that marks the end of the segment.
+type frameWriter struct {
+	w io.Writer
+}
+
+// Write implements the io.Writer interface.
+func (fw *frameWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(len(p)))
+	if _, err := fw.w.Write(size[:n]); err != nil {
+		return 0, err
+	}
+
+	return fw.w.Write(p)
+}
+
+// Close marks the end of the segment.
+func (fw *frameWriter) Close() error {
+	_, err := fw.w.Write([]byte{0})
+	return err
+}
+
+// frameReader reads the data of a segment written by a frameWriter, and
+// reports io.EOF at the end of the segment.
+type frameReader struct {
+	r    *bufio.Reader
+	left uint64
+	done bool
+}
+
+// Read implements the io.Reader interface.
+func (fr *frameReader) Read(p []byte) (int, error) {
+	for fr.left == 0 {
+		if fr.done {
+			return 0, io.EOF
+		}
+
+		size, err := binary.ReadUvarint(fr.r)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, fmt.Errorf("read segment: %w", err)
+		}
+
+		fr.left = size
+		fr.done = size == 0
+	}
+
+	if uint64(len(p)) > fr.left {
+		p = p[:fr.left]
+	}
+
+	n, err := fr.r.Read(p)
+	fr.left -= uint64(n)
+	if errors.Is(err, io.EOF) {
+		err = fmt.Errorf("read segment: %w", io.ErrUnexpectedEOF)
+	}
+
+	return n, err
+}
@@ -0,0 +1,18 @@
+package tlock
+
+import (
+	"github.com/drand/tlock/networks"
+)
+
+// WithBeaconSource sets the source the signatures of the rounds are retrieved
+// from when decrypting, instead of the network, which still provides the
+// information of the chain. This allows setups such as signatures relayed
+// over gRPC, read from a file or fetched from a blockchain. The source has to
+// serve the chain of the network, whose public key the signatures are
+// verified against, so an untrusted source can't decrypt data with forged
+// signatures.
+func WithBeaconSource(source networks.BeaconSource) Option {
+	return func(t *Tlock) {
+		t.source = source
+	}
+}
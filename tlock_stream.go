@@ -0,0 +1,315 @@
+package tlock
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/drand/tlock/internal/codec"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// AEAD identifies the authenticated encryption algorithm used to encrypt the
+// payload.
+type AEAD string
+
+// These constants define the supported payload algorithms. Data encrypted
+// with ChaCha20Poly1305 and the default chunk size can also be decrypted
+// with age.
+const (
+	ChaCha20Poly1305 AEAD = "chacha20poly1305"
+	AES256GCM        AEAD = "aes256gcm"
+)
+
+// These constants define the limits of the payload chunk size. The maximum
+// protects decryption from allocating large buffers for hostile headers.
+const (
+	DefaultChunkSize = 64 * 1024
+	MaxChunkSize     = 16 * 1024 * 1024
+)
+
+// LargeChunkSize is a chunk size for WithChunkSize that splits sources of
+// tens of megabytes or more into fewer chunks. Whether it's faster than the
+// default depends on the machine, which Benchmark_EncryptFrom measures. Like
+// any other chunk size than DefaultChunkSize, the payload can't be decrypted
+// with age.
+const LargeChunkSize = 1024 * 1024
+
+// MaxCompactSize is the largest source of known size encrypted as a compact
+// payload with WithCompact. A compact payload leaves out the nonce and is a
+// single chunk, so short messages such as keys or commit-reveal values take
+// fewer bytes and smaller buffers to encrypt and decrypt. It requires format
+// version compactVersion, and can't be decrypted with age.
+const MaxCompactSize = 1024
+
+// compactVersion is the format version required to decrypt compact payloads.
+const compactVersion = 3
+
+// ErrCorruptPayload represents an error when a chunk of the payload fails to
+// authenticate, or the payload doesn't end where it should, because the data
+// was modified.
+var ErrCorruptPayload = errors.New("corrupt payload")
+
+// newAEAD constructs the payload cipher for the algorithm.
+func (a AEAD) newAEAD(key []byte) (cipher.AEAD, error) {
+	switch a {
+	case ChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+
+	case AES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	}
+
+	return nil, fmt.Errorf("unsupported aead %q", string(a))
+}
+
+// additionalData converts the associated data provided by the caller into the
+// additional data authenticated with every chunk. Without associated data
+// the chunks are sealed like age does.
+func additionalData(aad []byte) []byte {
+	if aad == nil {
+		return nil
+	}
+
+	h := sha256.Sum256(aad)
+	return h[:]
+}
+
+// =============================================================================
+
+// streamWriter encrypts the payload as a sequence of chunks using the STREAM
+// construction. Each chunk is authenticated with a counter nonce, and the
+// final chunk is marked so truncation is detected.
+type streamWriter struct {
+	aead      cipher.AEAD
+	ad        []byte
+	dst       io.Writer
+	buf       []byte
+	chunkSize int
+	nonce     []byte
+	chunks    uint64
+	limits    limits
+
+	// checkpoint is called with the number of chunks written after every
+	// intermediate chunk, if set.
+	checkpoint func(chunks uint64) error
+}
+
+// newStreamWriter constructs a writer for the payload. Every chunk is
+// authenticated together with the additional data, which can be nil.
+func newStreamWriter(aead cipher.AEAD, ad []byte, dst io.Writer, chunkSize int) *streamWriter {
+	return &streamWriter{
+		aead:      aead,
+		ad:        ad,
+		dst:       dst,
+		buf:       make([]byte, 0, chunkSize+aead.Overhead()),
+		chunkSize: chunkSize,
+		nonce:     make([]byte, aead.NonceSize()),
+	}
+}
+
+// Write implements the io.Writer interface. A full chunk is only written
+// once more data arrives, since the last chunk has to be marked as such.
+func (w *streamWriter) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		if len(w.buf) == w.chunkSize {
+			if err := w.flushChunk(false); err != nil {
+				return n, err
+			}
+		}
+
+		c := copy(w.buf[len(w.buf):w.chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		p = p[c:]
+		n += c
+	}
+
+	return n, nil
+}
+
+// Close writes the last chunk. It doesn't close the destination.
+func (w *streamWriter) Close() error {
+	return w.flushChunk(true)
+}
+
+// flushChunk encrypts and writes the buffered chunk.
+func (w *streamWriter) flushChunk(last bool) error {
+	if err := w.limits.checkChunk(w.chunks+1, int64(w.chunks)*int64(w.chunkSize)+int64(len(w.buf))); err != nil {
+		return err
+	}
+
+	codec.SetLast(w.nonce, last)
+
+	w.buf = w.aead.Seal(w.buf[:0], w.nonce, w.buf, w.ad)
+	if _, err := w.dst.Write(w.buf); err != nil {
+		return err
+	}
+
+	w.buf = w.buf[:0]
+	if err := codec.IncrementNonce(w.nonce); err != nil {
+		return err
+	}
+
+	if last {
+		return nil
+	}
+
+	w.chunks++
+	if w.checkpoint == nil {
+		return nil
+	}
+
+	return w.checkpoint(w.chunks)
+}
+
+// skip continues the stream after the specified number of chunks, which
+// were written before.
+func (w *streamWriter) skip(chunks uint64) {
+	w.chunks = chunks
+	codec.SetCounter(w.nonce, chunks)
+}
+
+// =============================================================================
+
+// streamReader decrypts a payload written by streamWriter.
+type streamReader struct {
+	aead      cipher.AEAD
+	ad        []byte
+	src       io.Reader
+	encrypted []byte
+	plain     []byte
+	chunkSize int
+	nonce     []byte
+	chunks    uint64
+	limits    limits
+	first     bool
+	last      bool
+}
+
+// newStreamReader constructs a reader for the payload. The additional data
+// has to match the one used for writing.
+func newStreamReader(aead cipher.AEAD, ad []byte, src io.Reader, chunkSize int) *streamReader {
+	return &streamReader{
+		aead:      aead,
+		ad:        ad,
+		src:       src,
+		encrypted: make([]byte, chunkSize+aead.Overhead()),
+		chunkSize: chunkSize,
+		nonce:     make([]byte, aead.NonceSize()),
+		first:     true,
+	}
+}
+
+// copyTo decrypts the remaining chunks and writes them to the destination
+// until the last chunk was written or the context is canceled. The
+// checkpoint function, if not nil, is called with the number of chunks read
+// once an intermediate chunk was written.
+func (r *streamReader) copyTo(ctx context.Context, dst io.Writer, checkpoint func(chunks uint64) error) error {
+	for !r.last {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := r.readChunk(); err != nil {
+			return err
+		}
+
+		if err := r.limits.checkChunk(r.chunks+1, int64(r.chunks)*int64(r.chunkSize)+int64(len(r.plain))); err != nil {
+			return err
+		}
+
+		if _, err := dst.Write(r.plain); err != nil {
+			return err
+		}
+
+		if r.last {
+			break
+		}
+
+		r.chunks++
+		if checkpoint != nil {
+			if err := checkpoint(r.chunks); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// skip continues the stream after the specified number of chunks, which the
+// source has to be positioned after.
+func (r *streamReader) skip(chunks uint64) {
+	r.chunks = chunks
+	r.first = chunks == 0
+	codec.SetCounter(r.nonce, chunks)
+}
+
+// readChunk reads and decrypts the next chunk. Once the last chunk was read
+// the reader is marked as done.
+func (r *streamReader) readChunk() error {
+	n, err := io.ReadFull(r.src, r.encrypted)
+	switch {
+	case errors.Is(err, io.EOF):
+		return fmt.Errorf("payload truncated: %w", io.ErrUnexpectedEOF)
+
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		// A short chunk has to be the last one.
+		return r.open(r.encrypted[:n], true)
+
+	case err != nil:
+		return err
+	}
+
+	// A full chunk is the last one only if it doesn't authenticate as an
+	// intermediate chunk.
+	if err := r.open(r.encrypted, false); err == nil {
+		return nil
+	}
+
+	if err := r.open(r.encrypted, true); err != nil {
+		return err
+	}
+
+	var extra [1]byte
+	if n, _ := r.src.Read(extra[:]); n > 0 {
+		return fmt.Errorf("%w: trailing data after the end of the payload", ErrCorruptPayload)
+	}
+
+	return nil
+}
+
+// open decrypts a chunk.
+func (r *streamReader) open(chunk []byte, last bool) error {
+	codec.SetLast(r.nonce, last)
+
+	plain, err := r.aead.Open(r.plain[:0], r.nonce, chunk, r.ad)
+	if err != nil {
+		codec.SetLast(r.nonce, false)
+		if r.first && r.ad != nil {
+			return fmt.Errorf("%w or the payload was modified", ErrAADMismatch)
+		}
+		return fmt.Errorf("%w: failed to decrypt and authenticate payload chunk", ErrCorruptPayload)
+	}
+
+	if last {
+		if len(plain) == 0 && !r.first {
+			return fmt.Errorf("%w: last chunk is empty", ErrCorruptPayload)
+		}
+		r.last = true
+	}
+
+	r.plain = plain
+	r.first = false
+
+	return codec.IncrementNonce(r.nonce)
+}
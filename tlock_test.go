@@ -2,15 +2,38 @@ package tlock_test
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	_ "embed" // Calls init function.
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
+	"math"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"filippo.io/age"
 	"github.com/drand/drand/chain"
+	"github.com/drand/drand/common/scheme"
+	"github.com/drand/kyber"
+	bls "github.com/drand/kyber-bls12381"
+	sign "github.com/drand/kyber/sign/bls"
+	"github.com/drand/kyber/util/random"
 	"github.com/drand/tlock"
+	"github.com/drand/tlock/armor"
+	"github.com/drand/tlock/internal/fakenet"
+	"github.com/drand/tlock/internal/g1"
+	"github.com/drand/tlock/networks"
 	"github.com/drand/tlock/networks/http"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -233,3 +256,2283 @@ func Test_TimeLockUnlock(t *testing.T) {
 		t.Fatalf("unexpected bytes; expected len %d; got %d", len(data), len(b))
 	}
 }
+
+func Test_ReadHeader(t *testing.T) {
+	var cipherData bytes.Buffer
+	if err := tlock.New(stubNetwork{}).Encrypt(&cipherData, bytes.NewReader(dataFile), 1234); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	header, err := tlock.ReadHeader(&cipherData)
+	if err != nil {
+		t.Fatalf("read header error %s", err)
+	}
+
+	if header.RoundNumber != 1234 {
+		t.Fatalf("unexpected round; expected %d; got %d", 1234, header.RoundNumber)
+	}
+
+	if header.ChainHash != (stubNetwork{}).ChainHash() {
+		t.Fatalf("unexpected chain hash; expected %s; got %s", (stubNetwork{}).ChainHash(), header.ChainHash)
+	}
+}
+
+func Test_ReadHeaderFileMode(t *testing.T) {
+	var cipherData bytes.Buffer
+	tl := tlock.New(stubNetwork{}).WithFileMode(0640)
+	if err := tl.Encrypt(&cipherData, bytes.NewReader(dataFile), 1234); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	header, err := tlock.ReadHeader(&cipherData)
+	if err != nil {
+		t.Fatalf("read header error %s", err)
+	}
+
+	if header.FileMode != 0640 {
+		t.Fatalf("unexpected file mode; expected %o; got %o", 0640, header.FileMode)
+	}
+}
+
+func Test_ReadHeaderWindowsText(t *testing.T) {
+	var armored bytes.Buffer
+	a := armor.NewWriter(&armored)
+	if err := tlock.New(stubNetwork{}).Encrypt(a, bytes.NewReader(dataFile), 1234); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("armor close error %s", err)
+	}
+
+	crlf := bytes.ReplaceAll(armored.Bytes(), []byte("\n"), []byte("\r\n"))
+
+	utf16 := []byte{0xff, 0xfe}
+	for _, b := range crlf {
+		utf16 = append(utf16, b, 0)
+	}
+
+	tests := map[string][]byte{
+		"crlf":         crlf,
+		"utf8 bom":     append([]byte{0xef, 0xbb, 0xbf}, crlf...),
+		"blank lines":  append([]byte("\r\n\r\n"), crlf...),
+		"utf16 le bom": utf16,
+	}
+
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			header, err := tlock.ReadHeader(bytes.NewReader(input))
+			if err != nil {
+				t.Fatalf("read header error %s", err)
+			}
+
+			if header.RoundNumber != 1234 {
+				t.Fatalf("unexpected round; expected %d; got %d", 1234, header.RoundNumber)
+			}
+		})
+	}
+}
+
+func Test_ReadHeaderUTF16Binary(t *testing.T) {
+	// Mimic PowerShell, which decodes binary output as text and re-encodes
+	// it as UTF-16, replacing invalid bytes.
+	input := []byte{0xff, 0xfe, 'a', 0, 'g', 0, 'e', 0, 0xfd, 0xff}
+
+	_, err := tlock.ReadHeader(bytes.NewReader(input))
+	if !errors.Is(err, tlock.ErrUTF16Input) {
+		t.Fatalf("expected utf16 input error; got %v", err)
+	}
+}
+
+func Test_Options(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	// Large enough for several chunks with a partial last chunk.
+	data := bytes.Repeat(dataFile, 10)
+
+	tests := map[string]struct {
+		opts []tlock.Option
+		data []byte
+	}{
+		"defaults":         {data: data},
+		"aes256gcm":        {opts: []tlock.Option{tlock.WithAEAD(tlock.AES256GCM)}, data: data},
+		"small chunks":     {opts: []tlock.Option{tlock.WithChunkSize(100)}, data: data},
+		"full last chunk":  {opts: []tlock.Option{tlock.WithChunkSize(100)}, data: data[:500]},
+		"empty":            {opts: []tlock.Option{tlock.WithChunkSize(100)}, data: nil},
+		"gcm small chunks": {opts: []tlock.Option{tlock.WithAEAD(tlock.AES256GCM), tlock.WithChunkSize(64)}, data: data},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var cipherData bytes.Buffer
+			if err := tlock.New(network, test.opts...).Encrypt(&cipherData, bytes.NewReader(test.data), 10); err != nil {
+				t.Fatalf("encrypt error %s", err)
+			}
+
+			var plainData bytes.Buffer
+			if err := tlock.New(network).Decrypt(&plainData, &cipherData); err != nil {
+				t.Fatalf("decrypt error %s", err)
+			}
+
+			if !bytes.Equal(plainData.Bytes(), test.data) {
+				t.Fatalf("decrypted data is invalid; expected %d bytes; got %d bytes", len(test.data), plainData.Len())
+			}
+		})
+	}
+}
+
+func Test_ContentType(t *testing.T) {
+	var cipherData bytes.Buffer
+	if err := tlock.New(stubNetwork{}, tlock.WithContentType("tar+gzip")).Encrypt(&cipherData, bytes.NewReader(dataFile), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	header, err := tlock.ReadHeader(&cipherData)
+	if err != nil {
+		t.Fatalf("read header error %s", err)
+	}
+	if header.ContentType != "tar+gzip" {
+		t.Fatalf("expecting content type %q; got %q", "tar+gzip", header.ContentType)
+	}
+
+	for _, contentType := range []string{"text plain", "Tar", "a=b"} {
+		if err := tlock.New(stubNetwork{}, tlock.WithContentType(contentType)).Encrypt(io.Discard, bytes.NewReader(dataFile), 10); err == nil {
+			t.Fatalf("expected error for content type %q", contentType)
+		}
+	}
+}
+
+func Test_Extensions(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	tl := tlock.New(network, tlock.WithExtension("codec", "gzip"), tlock.WithExtension("owner-2", "a b=c\n"))
+
+	var cipherData bytes.Buffer
+	if err := tl.Encrypt(&cipherData, bytes.NewReader(dataFile), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	b := cipherData.Bytes()
+
+	header, err := tlock.ReadHeader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("read header error %s", err)
+	}
+	if len(header.Extensions) != 2 || header.Extensions["codec"] != "gzip" || header.Extensions["owner-2"] != "a b=c\n" {
+		t.Fatalf("unexpected extensions %v", header.Extensions)
+	}
+
+	if err := tlock.New(network).Decrypt(io.Discard, bytes.NewReader(b)); err != nil {
+		t.Fatalf("decrypt error %s", err)
+	}
+
+	// Extensions are authenticated with the header.
+	tampered := bytes.Replace(b, []byte("x-codec=Z3ppcA"), []byte("x-codec=enN0ZA"), 1)
+	if err := tlock.New(network).Decrypt(io.Discard, bytes.NewReader(tampered)); err == nil {
+		t.Fatalf("expecting an error for a tampered extension")
+	}
+
+	parsing := map[string]struct {
+		arg   string
+		valid bool
+	}{
+		"unknown key": {"x-codec=Z3ppcA future=1", true},
+		"duplicate":   {"x-codec=Z3ppcA x-codec=Z3ppcA", false},
+		"empty key":   {"x-codec=Z3ppcA =1", false},
+		"invalid key": {"x-Codec=Z3ppcA", false},
+		"bad value":   {"x-codec=Z3ppc+", false},
+		"padding":     {"x-codec=Z3ppcA==", false},
+	}
+
+	for name, tc := range parsing {
+		t.Run(name, func(t *testing.T) {
+			input := bytes.Replace(b, []byte("x-codec=Z3ppcA"), []byte(tc.arg), 1)
+			if _, err := tlock.ReadHeader(bytes.NewReader(input)); (err == nil) != tc.valid {
+				t.Fatalf("expecting valid %v; got error %v", tc.valid, err)
+			}
+		})
+	}
+
+	invalid := []tlock.Option{
+		tlock.WithExtension("", "value"),
+		tlock.WithExtension("1st", "value"),
+		tlock.WithExtension("has_underscore", "value"),
+		tlock.WithExtension("big", strings.Repeat("a", tlock.MaxExtensionSize+1)),
+	}
+	for _, opt := range invalid {
+		if err := tlock.New(network, opt).Encrypt(io.Discard, bytes.NewReader(dataFile), 10); err == nil {
+			t.Fatalf("expecting an error for an invalid extension")
+		}
+	}
+
+	var many []tlock.Option
+	for i := 0; i <= tlock.MaxExtensions; i++ {
+		many = append(many, tlock.WithExtension(fmt.Sprintf("k%d", i), "v"))
+	}
+	if err := tlock.New(network, many...).Encrypt(io.Discard, bytes.NewReader(dataFile), 10); err == nil {
+		t.Fatalf("expecting an error for too many extensions")
+	}
+}
+
+func Test_EndpointHints(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	hints := []string{"https://relay.example.com/", "http://127.0.0.1:8080"}
+
+	var cipherData bytes.Buffer
+	if err := tlock.New(network, tlock.WithEndpointHints(hints...)).Encrypt(&cipherData, bytes.NewReader(dataFile), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	header, err := tlock.ReadHeader(bytes.NewReader(cipherData.Bytes()))
+	if err != nil {
+		t.Fatalf("read header error %s", err)
+	}
+	if strings.Join(header.EndpointHints, " ") != strings.Join(hints, " ") {
+		t.Fatalf("expecting hints %v; got %v", hints, header.EndpointHints)
+	}
+
+	if err := tlock.New(network, tlock.WithStrictParsing(true)).Decrypt(io.Discard, bytes.NewReader(cipherData.Bytes())); err != nil {
+		t.Fatalf("decrypt error %s", err)
+	}
+
+	invalid := [][]string{
+		{"ftp://example.com/"},
+		{"https://"},
+		{"relay.example.com"},
+		{"https://example.com/" + strings.Repeat("a", tlock.MaxEndpointHintSize)},
+		{"https://a/", "https://b/", "https://c/", "https://d/", "https://e/"},
+	}
+	for _, hints := range invalid {
+		if err := tlock.New(network, tlock.WithEndpointHints(hints...)).Encrypt(io.Discard, bytes.NewReader(dataFile), 10); err == nil {
+			t.Fatalf("expecting an error for the hints %v", hints)
+		}
+	}
+}
+
+func Test_Warnings(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	var warnings []tlock.Warning
+	collect := tlock.WithWarnings(func(w tlock.Warning) {
+		warnings = append(warnings, w)
+	})
+
+	var cipherData bytes.Buffer
+	if err := tlock.New(network, collect).Encrypt(&cipherData, bytes.NewReader(dataFile), 2); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	if len(warnings) != 1 || warnings[0].Code != tlock.WarnRoundSoon {
+		t.Fatalf("expecting a round soon warning; got %v", warnings)
+	}
+
+	warnings = nil
+	if err := tlock.New(network, collect).Encrypt(io.Discard, bytes.NewReader(dataFile), 1000); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	if err := tlock.New(network, collect).Decrypt(io.Discard, bytes.NewReader(cipherData.Bytes())); err != nil {
+		t.Fatalf("decrypt error %s", err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("expecting no warnings; got %v", warnings)
+	}
+
+	// The warning is reported before the header is authenticated.
+	legacy := bytes.Replace(cipherData.Bytes(), []byte(" v=1"), nil, 1)
+	if err := tlock.New(network, collect).Decrypt(io.Discard, bytes.NewReader(legacy)); !errors.Is(err, tlock.ErrHeaderMACMismatch) {
+		t.Fatalf("expecting error %v; got %v", tlock.ErrHeaderMACMismatch, err)
+	}
+	if len(warnings) != 1 || warnings[0].Code != tlock.WarnLegacyFormat {
+		t.Fatalf("expecting a legacy format warning; got %v", warnings)
+	}
+}
+
+func Test_AgeWrapper(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate error %s", err)
+	}
+	wrapper := tlock.AgeWrapper{
+		Recipients: []age.Recipient{identity.Recipient()},
+		Identities: []age.Identity{identity},
+	}
+	ctx := context.Background()
+
+	var cipherData bytes.Buffer
+	if err := tlock.EncryptWithWrapper(ctx, wrapper, &cipherData, bytes.NewReader(dataFile)); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	// The data follows the age format.
+	r, err := age.Decrypt(bytes.NewReader(cipherData.Bytes()), identity)
+	if err != nil {
+		t.Fatalf("age decrypt error %s", err)
+	}
+	if b, _ := io.ReadAll(r); !bytes.Equal(b, dataFile) {
+		t.Fatalf("age decrypted data is invalid")
+	}
+
+	var armored bytes.Buffer
+	a := armor.NewWriter(&armored)
+	w, err := age.Encrypt(a, identity.Recipient())
+	if err != nil {
+		t.Fatalf("age encrypt error %s", err)
+	}
+	w.Write(dataFile)
+	w.Close()
+	a.Close()
+
+	var plainData bytes.Buffer
+	if err := tlock.DecryptWithWrapper(ctx, wrapper, &plainData, &armored); err != nil {
+		t.Fatalf("decrypt error %s", err)
+	}
+	if !bytes.Equal(plainData.Bytes(), dataFile) {
+		t.Fatalf("decrypted data is invalid")
+	}
+
+	other, _ := age.GenerateX25519Identity()
+	err = tlock.DecryptWithWrapper(ctx, tlock.AgeWrapper{Identities: []age.Identity{other}}, io.Discard, bytes.NewReader(cipherData.Bytes()))
+	if !errors.Is(err, age.ErrIncorrectIdentity) {
+		t.Fatalf("expecting error %v; got %v", age.ErrIncorrectIdentity, err)
+	}
+
+	// Time locked data isn't meant for other recipients.
+	network := fakenet.NewChain(3 * time.Second)
+	var locked bytes.Buffer
+	if err := tlock.New(network).Encrypt(&locked, bytes.NewReader(dataFile), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	if err := tlock.DecryptWithWrapper(ctx, wrapper, io.Discard, &locked); !errors.Is(err, age.ErrIncorrectIdentity) {
+		t.Fatalf("expecting error %v; got %v", age.ErrIncorrectIdentity, err)
+	}
+}
+
+func Test_CanDecrypt(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	ctx := context.Background()
+
+	var cipherData bytes.Buffer
+	if err := tlock.New(network).Encrypt(&cipherData, bytes.NewReader(dataFile), 1000); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	b := cipherData.Bytes()
+
+	if err := tlock.New(network).CanDecrypt(ctx, bytes.NewReader(b)); !errors.Is(err, tlock.ErrTooEarly) {
+		t.Fatalf("expecting error %v; got %v", tlock.ErrTooEarly, err)
+	}
+
+	network.Unlock()
+
+	if err := tlock.New(network).CanDecrypt(ctx, bytes.NewReader(b)); err != nil {
+		t.Fatalf("can decrypt error %s", err)
+	}
+
+	// The payload isn't read, so its corruption goes unnoticed.
+	flipped := append([]byte{}, b...)
+	flipped[len(flipped)-1] ^= 1
+	if err := tlock.New(network).CanDecrypt(ctx, bytes.NewReader(flipped)); err != nil {
+		t.Fatalf("can decrypt error %s", err)
+	}
+
+	tampered := bytes.Replace(b, []byte(" v=1"), []byte(" v=1 mode=600"), 1)
+	if err := tlock.New(network).CanDecrypt(ctx, bytes.NewReader(tampered)); !errors.Is(err, tlock.ErrHeaderMACMismatch) {
+		t.Fatalf("expecting error %v; got %v", tlock.ErrHeaderMACMismatch, err)
+	}
+
+	if err := tlock.New(network, tlock.WithAAD([]byte("id"))).CanDecrypt(ctx, bytes.NewReader(b)); !errors.Is(err, tlock.ErrAADMismatch) {
+		t.Fatalf("expecting error %v; got %v", tlock.ErrAADMismatch, err)
+	}
+}
+
+func Test_Versions(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	var cipherData bytes.Buffer
+	if err := tlock.New(network).Encrypt(&cipherData, bytes.NewReader(dataFile), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	b := cipherData.Bytes()
+
+	header, err := tlock.ReadHeader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("read header error %s", err)
+	}
+	if header.Version != 1 {
+		t.Fatalf("expecting version %d; got %d", 1, header.Version)
+	}
+
+	// Data without a version predates versioning.
+	legacy := bytes.Replace(b, []byte(" v=1"), nil, 1)
+	if header, err := tlock.ReadHeader(bytes.NewReader(legacy)); err != nil || header.Version != tlock.MinFormatVersion {
+		t.Fatalf("expecting version %d; got %d and error %v", tlock.MinFormatVersion, header.Version, err)
+	}
+
+	newer := bytes.Replace(b, []byte(" v=1"), []byte(" v=9 mode=bogus"), 1)
+	if _, err := tlock.ReadHeader(bytes.NewReader(newer)); !errors.Is(err, tlock.ErrUnsupportedVersion) {
+		t.Fatalf("expecting error %v; got %v", tlock.ErrUnsupportedVersion, err)
+	}
+
+	var versionErr *tlock.VersionError
+	if err := tlock.New(network).Decrypt(io.Discard, bytes.NewReader(newer)); !errors.As(err, &versionErr) || versionErr.Version != 9 {
+		t.Fatalf("expecting a version error; got %v", err)
+	}
+	if !strings.Contains(versionErr.Error(), "file requires tlock >= format v9") {
+		t.Fatalf("unexpected message %q", versionErr.Error())
+	}
+
+	invalid := bytes.Replace(b, []byte(" v=1"), []byte(" v=0"), 1)
+	if _, err := tlock.ReadHeader(bytes.NewReader(invalid)); err == nil || errors.Is(err, tlock.ErrUnsupportedVersion) {
+		t.Fatalf("expecting an invalid version error; got %v", err)
+	}
+
+	c := tlock.Supported()
+	if !c.SupportsVersion(header.Version) || c.SupportsVersion(9) || c.SupportsVersion(0) {
+		t.Fatalf("unexpected supported versions %d to %d", c.MinFormatVersion, c.FormatVersion)
+	}
+}
+
+// Test_RoundIdentity pins the identities of the rounds and how every scheme
+// hashes them, so data keeps decrypting with other implementations.
+func Test_RoundIdentity(t *testing.T) {
+	identities := map[uint64]string{
+		1:              "cd2662154e6d76b2b2b92e70c0cac3ccf534f9b74eb5b89819ec509083d00a50",
+		1000:           "f652498d092acd949bad74e40683bf3824fb817980504a0c7e6722cfc5a9c0a3",
+		math.MaxUint64: "12a3ae445661ce5dee78d0650d33362dec29c4f82af05e7e57fb595bbbacf0ca",
+	}
+
+	for _, id := range tlock.Supported().Schemes {
+		if id == tlock.SchemeBN254UnchainedOnG1 {
+			continue
+		}
+		verifier := chain.NewVerifier(scheme.Scheme{ID: id, DecouplePrevSig: true})
+
+		for roundNumber, expected := range identities {
+			identity, err := tlock.RoundIdentity(id, roundNumber)
+			if err != nil {
+				t.Fatalf("%s: identity error %s", id, err)
+			}
+			if hex.EncodeToString(identity) != expected {
+				t.Fatalf("%s: expecting identity %s of round %d; got %x", id, expected, roundNumber, identity)
+			}
+			if !bytes.Equal(identity, verifier.DigestMessage(roundNumber, nil)) {
+				t.Fatalf("%s: identity of round %d doesn't match drand", id, roundNumber)
+			}
+		}
+	}
+
+	// BN254 chains sign the Keccak-256 of the rounds, which the pinned drand
+	// doesn't implement, so the identities come from the drand release
+	// that introduced them.
+	bn254Identities := map[uint64]string{
+		1:    "6c31fc15422ebad28aaf9089c306702f67540b53c7eea8b7d2941044b027100f",
+		1000: "f479a7bd3819aa63bbe476777c509fd59e626fac3d37221509ba4fd41b1459b6",
+	}
+
+	for roundNumber, expected := range bn254Identities {
+		identity, err := tlock.RoundIdentity(tlock.SchemeBN254UnchainedOnG1, roundNumber)
+		if err != nil {
+			t.Fatalf("identity error %s", err)
+		}
+		if hex.EncodeToString(identity) != expected {
+			t.Fatalf("expecting BN254 identity %s of round %d; got %x", expected, roundNumber, identity)
+		}
+	}
+
+	if _, err := tlock.RoundIdentity(scheme.DefaultSchemeID, 1); err == nil {
+		t.Fatal("expecting an error for a chained scheme")
+	}
+
+	// The hash to G1 follows RFC 9380, whose test vector hashes an empty
+	// message.
+	p, _ := g1.HashWithDST(nil, []byte("QUUX-V01-CS02-with-BLS12381G1_XMD:SHA-256_SSWU_RO_"))
+	if b := mustMarshal(t, p); hex.EncodeToString(b) != "852926add2207b76ca4fa57a8734416c8dc95e24501772c814278700eed6d1e4e8cf62d9c09db0fac349612b759e79a1" {
+		t.Fatalf("unexpected hash to G1 %x", b)
+	}
+
+	// The first chains signing on G1 hash the identity like kyber-bls12381.
+	identity, _ := tlock.RoundIdentity(tlock.SchemeUnchainedOnG1, 1)
+	const (
+		point       = "95d3fa4eeea6c775ee329d2805948d00b920e5167e48d9ea5bfa41616ddec9d87b6ad2dd22805e4f2f68cb596ebe5c31"
+		legacyPoint = "95b110e567bf88e6302b8d3abb546d59558d97715c4228821aecaa6f2df0e6990f47aca1294ad384d1bf168478ce58bf"
+	)
+	if p, _ := g1.HashWithDST(identity, g1.DST); fmt.Sprintf("%x", mustMarshal(t, p)) != point {
+		t.Fatalf("unexpected point of round 1 %x", mustMarshal(t, p))
+	}
+	if p, _ := g1.HashWithDST(identity, g1.LegacyDST); fmt.Sprintf("%x", mustMarshal(t, p)) != legacyPoint {
+		t.Fatalf("unexpected legacy point of round 1 %x", mustMarshal(t, p))
+	}
+	if p := bls.NullKyberG1().Hash(identity); fmt.Sprintf("%x", mustMarshal(t, p)) != legacyPoint {
+		t.Fatal("legacy point doesn't match kyber-bls12381")
+	}
+
+	network := fakenet.NewChainOnLegacyG1(3 * time.Second)
+	network.Unlock()
+
+	var cipherData bytes.Buffer
+	if err := tlock.New(network).Encrypt(&cipherData, bytes.NewReader(dataFile), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	var plainData bytes.Buffer
+	if err := tlock.New(network).Decrypt(&plainData, &cipherData); err != nil {
+		t.Fatalf("decrypt error %s", err)
+	}
+	if !bytes.Equal(plainData.Bytes(), dataFile) {
+		t.Fatalf("decrypted data is invalid")
+	}
+}
+
+// mustMarshal returns the compressed encoding of the point.
+func mustMarshal(t *testing.T, p kyber.Point) []byte {
+	t.Helper()
+
+	b, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal error %s", err)
+	}
+	return b
+}
+
+func Test_Schemes(t *testing.T) {
+	network := fakenet.NewChainOnG1(3 * time.Second)
+
+	var cipherData bytes.Buffer
+	if err := tlock.New(network).Encrypt(&cipherData, bytes.NewReader(dataFile), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	b := cipherData.Bytes()
+
+	header, err := tlock.ReadHeader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("read header error %s", err)
+	}
+	if header.Scheme != tlock.SchemeUnchainedG1 || header.Version != 2 {
+		t.Fatalf("unexpected scheme %s and version %d", header.Scheme, header.Version)
+	}
+
+	if err := tlock.New(network).Decrypt(io.Discard, bytes.NewReader(b)); !errors.Is(err, tlock.ErrTooEarly) {
+		t.Fatalf("expecting error %v; got %v", tlock.ErrTooEarly, err)
+	}
+
+	network.Unlock()
+
+	var plainData bytes.Buffer
+	if err := tlock.New(network).Decrypt(&plainData, bytes.NewReader(b)); err != nil {
+		t.Fatalf("decrypt error %s", err)
+	}
+	if !bytes.Equal(plainData.Bytes(), dataFile) {
+		t.Fatalf("decrypted data is invalid")
+	}
+
+	// The groups of the scheme of the data have to match the network.
+	other := fakenet.NewChain(3 * time.Second)
+	other.Unlock()
+	if err := tlock.New(other, tlock.WithStrictChainCheck(false)).Decrypt(io.Discard, bytes.NewReader(b)); err == nil || !strings.Contains(err.Error(), "scheme") {
+		t.Fatalf("expecting a scheme error; got %v", err)
+	}
+
+	for _, arg := range []string{"scheme=pedersen-bls-chained", "scheme=unknown"} {
+		tampered := bytes.Replace(b, []byte("scheme="+tlock.SchemeUnchainedG1), []byte(arg), 1)
+		if _, err := tlock.ReadHeader(bytes.NewReader(tampered)); err == nil {
+			t.Fatalf("expecting an error for %s", arg)
+		}
+	}
+
+	// The scheme requires the version that introduced it.
+	downgraded := bytes.Replace(b, []byte(" v=2"), []byte(" v=1"), 1)
+	if _, err := tlock.ReadHeader(bytes.NewReader(downgraded)); err == nil {
+		t.Fatalf("expecting an error for a downgraded version")
+	}
+}
+
+func Test_SchemeBN254(t *testing.T) {
+	network := fakenet.NewChainOnBN254(3 * time.Second)
+
+	var cipherData bytes.Buffer
+	if err := tlock.New(network).Encrypt(&cipherData, bytes.NewReader(dataFile), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	b := cipherData.Bytes()
+
+	header, err := tlock.ReadHeader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("read header error %s", err)
+	}
+	if header.Scheme != tlock.SchemeBN254UnchainedOnG1 || header.Version != 2 {
+		t.Fatalf("unexpected scheme %s and version %d", header.Scheme, header.Version)
+	}
+
+	if err := tlock.New(network).Decrypt(io.Discard, bytes.NewReader(b)); !errors.Is(err, tlock.ErrTooEarly) {
+		t.Fatalf("expecting error %v; got %v", tlock.ErrTooEarly, err)
+	}
+
+	network.Unlock()
+
+	var plainData bytes.Buffer
+	if err := tlock.New(network).Decrypt(&plainData, bytes.NewReader(b)); err != nil {
+		t.Fatalf("decrypt error %s", err)
+	}
+	if !bytes.Equal(plainData.Bytes(), dataFile) {
+		t.Fatalf("decrypted data is invalid")
+	}
+
+	// Data locked to another BN254 chain can't be decrypted.
+	other := fakenet.NewChainOnBN254(3 * time.Second)
+	other.Unlock()
+	if err := tlock.New(other, tlock.WithStrictChainCheck(false)).Decrypt(io.Discard, bytes.NewReader(b)); err == nil {
+		t.Fatal("expecting an error for another chain")
+	}
+}
+
+func Test_InvalidChunkSize(t *testing.T) {
+	for _, size := range []int{-1, 0, tlock.MaxChunkSize + 1} {
+		var cipherData bytes.Buffer
+		if err := tlock.New(stubNetwork{}, tlock.WithChunkSize(size)).Encrypt(&cipherData, bytes.NewReader(dataFile), 10); err == nil {
+			t.Fatalf("expected error for chunk size %d", size)
+		}
+	}
+}
+
+func Test_TamperedPayload(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	var cipherData bytes.Buffer
+	tl := tlock.New(network, tlock.WithChunkSize(100))
+	if err := tl.Encrypt(&cipherData, bytes.NewReader(dataFile), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	b := cipherData.Bytes()
+
+	tests := map[string][]byte{
+		"truncated": b[:len(b)-150],
+		"flipped":   append(append([]byte{}, b[:len(b)-1]...), b[len(b)-1]^1),
+		"trailing":  append(append([]byte{}, b...), 0),
+	}
+
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tl.Decrypt(io.Discard, bytes.NewReader(input)); err == nil {
+				t.Fatal("expecting decrypt error")
+			}
+		})
+	}
+}
+
+func Test_TooEarlyRemaining(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	roundNumber := network.RoundNumber(time.Now()) + 100
+
+	var cipherData bytes.Buffer
+	if err := tlock.New(network).Encrypt(&cipherData, bytes.NewReader(dataFile), roundNumber); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	clock := fixedClock(network.RoundTime(roundNumber).Add(-time.Minute))
+	err := tlock.New(network, tlock.WithClock(clock)).Decrypt(io.Discard, &cipherData)
+	if !errors.Is(err, tlock.ErrTooEarly) {
+		t.Fatalf("expecting decrypt error to contain '%s'; got %s", tlock.ErrTooEarly, err)
+	}
+
+	if !strings.Contains(err.Error(), "reached in 1m0s") {
+		t.Fatalf("expecting remaining time in error; got %s", err)
+	}
+}
+
+func Test_StrictChainCheck(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	var cipherData bytes.Buffer
+	if err := tlock.New(network).Encrypt(&cipherData, bytes.NewReader(dataFile), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	renamed := renamedNetwork{network}
+	err := tlock.New(renamed).Decrypt(io.Discard, bytes.NewReader(cipherData.Bytes()))
+
+	var wrongChain *tlock.WrongChainError
+	if !errors.As(err, &wrongChain) || !errors.Is(err, tlock.ErrWrongChain) {
+		t.Fatalf("expecting wrong chain error; got %v", err)
+	}
+
+	if wrongChain.HeaderChainHash != network.ChainHash() || wrongChain.NetworkChainHash != "renamed" {
+		t.Fatalf("unexpected chain hashes in error: %s", err)
+	}
+
+	var plainData bytes.Buffer
+	if err := tlock.New(renamed, tlock.WithStrictChainCheck(false)).Decrypt(&plainData, &cipherData); err != nil {
+		t.Fatalf("decrypt error %s", err)
+	}
+
+	if !bytes.Equal(plainData.Bytes(), dataFile) {
+		t.Fatalf("decrypted file is invalid; expected %d; got %d", len(dataFile), plainData.Len())
+	}
+}
+
+func Test_EncryptToIdentity(t *testing.T) {
+	suite := bls.NewBLS12381Suite()
+	scheme := sign.NewSchemeOnG2(suite)
+	secret, publicKey := scheme.NewKeyPair(random.New())
+
+	identity := []byte("application defined identity")
+	dek := bytes.Repeat([]byte{7}, 16)
+
+	ciphertext, err := tlock.EncryptToIdentity(publicKey, identity, dek)
+	if err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	signature, err := scheme.Sign(secret, identity)
+	if err != nil {
+		t.Fatalf("sign error %s", err)
+	}
+
+	b, err := tlock.DecryptWithSignature(publicKey, identity, signature, ciphertext)
+	if err != nil {
+		t.Fatalf("decrypt error %s", err)
+	}
+
+	if !bytes.Equal(b, dek) {
+		t.Fatalf("decrypted data is invalid; expected %x; got %x", dek, b)
+	}
+
+	other, err := scheme.Sign(secret, []byte("another identity"))
+	if err != nil {
+		t.Fatalf("sign error %s", err)
+	}
+
+	if _, err := tlock.DecryptWithSignature(publicKey, identity, other, ciphertext); err == nil {
+		t.Fatal("expecting decrypt error for a signature over another identity")
+	}
+}
+
+func Test_AAD(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	var bound bytes.Buffer
+	if err := tlock.New(network, tlock.WithAAD([]byte("doc-1"))).Encrypt(&bound, bytes.NewReader(dataFile), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	var unbound bytes.Buffer
+	if err := tlock.New(network).Encrypt(&unbound, bytes.NewReader(dataFile), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	header, err := tlock.ReadHeader(bytes.NewReader(bound.Bytes()))
+	if err != nil {
+		t.Fatalf("read header error %s", err)
+	}
+	if !header.AAD {
+		t.Fatal("expecting the header to require associated data")
+	}
+
+	var plainData bytes.Buffer
+	if err := tlock.New(network, tlock.WithAAD([]byte("doc-1"))).Decrypt(&plainData, bytes.NewReader(bound.Bytes())); err != nil {
+		t.Fatalf("decrypt error %s", err)
+	}
+	if !bytes.Equal(plainData.Bytes(), dataFile) {
+		t.Fatalf("decrypted file is invalid; expected %d; got %d", len(dataFile), plainData.Len())
+	}
+
+	tests := map[string]struct {
+		aad   []byte
+		input []byte
+	}{
+		"wrong aad":      {aad: []byte("doc-2"), input: bound.Bytes()},
+		"missing aad":    {aad: nil, input: bound.Bytes()},
+		"unexpected aad": {aad: []byte("doc-1"), input: unbound.Bytes()},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tlock.New(network, tlock.WithAAD(test.aad)).Decrypt(io.Discard, bytes.NewReader(test.input))
+			if !errors.Is(err, tlock.ErrAADMismatch) {
+				t.Fatalf("expecting decrypt error to contain '%s'; got %v", tlock.ErrAADMismatch, err)
+			}
+		})
+	}
+}
+
+func Test_Inspect(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	const roundNumber = 100
+
+	var binary bytes.Buffer
+	if err := tlock.New(network).Encrypt(&binary, bytes.NewReader(dataFile), roundNumber); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	var armored bytes.Buffer
+	w := armor.NewWriter(&armored)
+	if _, err := w.Write(binary.Bytes()); err != nil {
+		t.Fatalf("armor error %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("armor error %s", err)
+	}
+
+	// The payload holds the nonce, the data and a tag for the single chunk.
+	payloadSize := int64(16 + len(dataFile) + 16)
+
+	md, err := tlock.Inspect(bytes.NewReader(binary.Bytes()), network)
+	if err != nil {
+		t.Fatalf("inspect error %s", err)
+	}
+
+	if md.RoundNumber != roundNumber || md.ChainHash != network.ChainHash() || md.Armored {
+		t.Fatalf("unexpected metadata %+v", md)
+	}
+	if md.PayloadSize != payloadSize {
+		t.Fatalf("expecting a payload of %d bytes; got %d", payloadSize, md.PayloadSize)
+	}
+	if !md.EstimatedUnlock.Equal(network.RoundTime(roundNumber)) {
+		t.Fatalf("expecting unlock at %s; got %s", network.RoundTime(roundNumber), md.EstimatedUnlock)
+	}
+
+	md, err = tlock.Inspect(bytes.NewReader(armored.Bytes()), nil)
+	if err != nil {
+		t.Fatalf("inspect error %s", err)
+	}
+
+	if !md.Armored || md.PayloadSize != payloadSize {
+		t.Fatalf("unexpected armored metadata %+v", md)
+	}
+	if !md.EstimatedUnlock.IsZero() {
+		t.Fatalf("expecting no unlock estimate without a network; got %s", md.EstimatedUnlock)
+	}
+}
+
+// =============================================================================
+
+// stubNetwork can be used to encrypt data without any network access. Its
+// signatures are never available.
+type stubNetwork struct{}
+
+func (stubNetwork) ChainHash() string {
+	return "cafe"
+}
+
+func (stubNetwork) PublicKey() kyber.Point {
+	return bls.NewBLS12381Suite().G1().Point().Base()
+}
+
+func (stubNetwork) Signature(roundNumber uint64) ([]byte, error) {
+	return nil, errors.New("signature not available")
+}
+
+// renamedNetwork reports a different chain hash for the same key.
+type renamedNetwork struct {
+	tlock.Network
+}
+
+func (renamedNetwork) ChainHash() string {
+	return "renamed"
+}
+
+// fixedClock always reports the same time.
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func Test_Resume(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	errStop := errors.New("stop")
+	stopAt := func(chunks uint64, cp *tlock.Checkpoint) tlock.Option {
+		return tlock.WithCheckpoint(func(c tlock.Checkpoint) error {
+			*cp = c
+			if c.Chunks == chunks {
+				return errStop
+			}
+			return nil
+		})
+	}
+
+	var cp tlock.Checkpoint
+	var cipherData bytes.Buffer
+	err := tlock.New(network, tlock.WithChunkSize(100), tlock.WithAAD([]byte("doc")), stopAt(3, &cp)).Encrypt(&cipherData, bytes.NewReader(dataFile), 10)
+	if !errors.Is(err, errStop) {
+		t.Fatalf("expecting encryption to stop; got %v", err)
+	}
+	if cp.Chunks != 3 || cp.Read != 300 || cp.Key == nil {
+		t.Fatalf("unexpected checkpoint %+v", cp)
+	}
+
+	// Data written after the checkpoint is discarded before resuming.
+	cipherData.Truncate(int(cp.Written))
+	if err := tlock.New(network).ResumeEncryptContext(context.Background(), &cipherData, bytes.NewReader(dataFile[cp.Read:]), cp); err != nil {
+		t.Fatalf("resume encrypt error %s", err)
+	}
+	encrypted := cipherData.Bytes()
+
+	tl := tlock.New(network, tlock.WithAAD([]byte("doc")))
+
+	var plainData bytes.Buffer
+	if err := tl.Decrypt(&plainData, bytes.NewReader(encrypted)); err != nil {
+		t.Fatalf("decrypt error %s", err)
+	}
+	if !bytes.Equal(plainData.Bytes(), dataFile) {
+		t.Fatal("resumed encryption is invalid")
+	}
+
+	plainData.Reset()
+	err = tlock.New(network, tlock.WithAAD([]byte("doc")), stopAt(2, &cp)).Decrypt(&plainData, bytes.NewReader(encrypted))
+	if !errors.Is(err, errStop) {
+		t.Fatalf("expecting decryption to stop; got %v", err)
+	}
+	if cp.Chunks != 2 || cp.Written != 200 || cp.Key != nil {
+		t.Fatalf("unexpected checkpoint %+v", cp)
+	}
+
+	sources := map[string]func() io.Reader{
+		"seeker":    func() io.Reader { return bytes.NewReader(encrypted) },
+		"stream":    func() io.Reader { return io.MultiReader(bytes.NewReader(encrypted)) },
+		"malformed": func() io.Reader { return bytes.NewReader(encrypted[:len(encrypted)-1]) },
+	}
+
+	for name, src := range sources {
+		t.Run(name, func(t *testing.T) {
+			resumed := bytes.NewBuffer(append([]byte{}, plainData.Bytes()[:cp.Written]...))
+			err := tl.ResumeDecryptContext(context.Background(), resumed, src(), cp)
+			if name == "malformed" {
+				if err == nil {
+					t.Fatal("expecting decrypt error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resume decrypt error %s", err)
+			}
+			if !bytes.Equal(resumed.Bytes(), dataFile) {
+				t.Fatal("resumed decryption is invalid")
+			}
+		})
+	}
+
+	cp.Read++
+	if err := tl.ResumeDecryptContext(context.Background(), io.Discard, bytes.NewReader(encrypted), cp); err == nil {
+		t.Fatal("expecting an error for an inconsistent checkpoint")
+	}
+}
+
+func Test_DecryptReaderAt(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	var cipherData bytes.Buffer
+	if err := tlock.New(network, tlock.WithChunkSize(100)).Encrypt(&cipherData, bytes.NewReader(dataFile), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	encrypted := cipherData.Bytes()
+
+	tl := tlock.New(network)
+	r, err := tl.DecryptReaderAt(context.Background(), bytes.NewReader(encrypted), int64(len(encrypted)))
+	if err != nil {
+		t.Fatalf("decrypt reader error %s", err)
+	}
+	if r.Size() != int64(len(dataFile)) {
+		t.Fatalf("expecting size %d; got %d", len(dataFile), r.Size())
+	}
+
+	ranges := [][2]int{{0, 10}, {95, 210}, {300, 100}, {len(dataFile) - 5, 5}, {0, len(dataFile)}}
+	for _, rg := range ranges {
+		b := make([]byte, rg[1])
+		if _, err := r.ReadAt(b, int64(rg[0])); err != nil {
+			t.Fatalf("read at %d error %s", rg[0], err)
+		}
+		if !bytes.Equal(b, dataFile[rg[0]:rg[0]+rg[1]]) {
+			t.Fatalf("range %v is invalid", rg)
+		}
+	}
+
+	b := make([]byte, 10)
+	if n, err := r.ReadAt(b, int64(len(dataFile)-4)); n != 4 || !errors.Is(err, io.EOF) {
+		t.Fatalf("expecting a short read at the end; got %d, %v", n, err)
+	}
+
+	// The chunks are laid out as 100 plain bytes and a 16 byte tag.
+	truncated := encrypted[:len(encrypted)-(len(dataFile)%100+16)]
+	if _, err := tl.DecryptReaderAt(context.Background(), bytes.NewReader(truncated), int64(len(truncated))); err == nil {
+		t.Fatal("expecting an error for truncated data")
+	}
+
+	var armored bytes.Buffer
+	w := armor.NewWriter(&armored)
+	w.Write(encrypted)
+	w.Close()
+	if _, err := tl.DecryptReaderAt(context.Background(), bytes.NewReader(armored.Bytes()), int64(armored.Len())); err == nil {
+		t.Fatal("expecting an error for armored data")
+	}
+
+	locked := fakenet.NewChain(3 * time.Second)
+	cipherData.Reset()
+	if err := tlock.New(locked).Encrypt(&cipherData, bytes.NewReader(dataFile), locked.RoundNumber(time.Now())+100); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	_, err = tlock.New(locked).DecryptReaderAt(context.Background(), bytes.NewReader(cipherData.Bytes()), int64(cipherData.Len()))
+	if !errors.Is(err, tlock.ErrTooEarly) {
+		t.Fatalf("expecting error %v; got %v", tlock.ErrTooEarly, err)
+	}
+}
+
+func Test_EncryptFrom(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	// Convergent encryption makes the output deterministic, so both paths
+	// have to write the same bytes.
+	for _, size := range []int{0, 1, 100, 250, 500, len(dataFile)} {
+		data := dataFile[:size]
+
+		var sequential, parallel []tlock.Checkpoint
+		record := func(cps *[]tlock.Checkpoint) tlock.Option {
+			return tlock.WithCheckpoint(func(c tlock.Checkpoint) error {
+				*cps = append(*cps, c)
+				return nil
+			})
+		}
+
+		var want bytes.Buffer
+		tl := tlock.New(network, tlock.WithChunkSize(100), tlock.WithConvergentKey([]byte("key")), record(&sequential))
+		if err := tl.Encrypt(&want, bytes.NewReader(data), 10); err != nil {
+			t.Fatalf("%d: encrypt error %s", size, err)
+		}
+
+		var got bytes.Buffer
+		tl = tlock.New(network, tlock.WithChunkSize(100), tlock.WithConvergentKey([]byte("key")), record(&parallel))
+		if err := tl.EncryptFrom(&got, bytes.NewReader(data), int64(size), 10); err != nil {
+			t.Fatalf("%d: encrypt from error %s", size, err)
+		}
+
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Fatalf("%d: expecting the same encrypted data as Encrypt", size)
+		}
+		if !reflect.DeepEqual(parallel, sequential) {
+			t.Fatalf("%d: expecting checkpoints %+v; got %+v", size, sequential, parallel)
+		}
+
+		var plainData bytes.Buffer
+		if err := tlock.New(network).Decrypt(&plainData, &got); err != nil {
+			t.Fatalf("%d: decrypt error %s", size, err)
+		}
+		if !bytes.Equal(plainData.Bytes(), data) {
+			t.Fatalf("%d: decrypted data is invalid", size)
+		}
+	}
+
+	tl := tlock.New(network, tlock.WithChunkSize(100))
+	if err := tl.EncryptFrom(io.Discard, bytes.NewReader(dataFile[:150]), 250, 10); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expecting error %v; got %v", io.ErrUnexpectedEOF, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := tl.EncryptFromContext(ctx, io.Discard, bytes.NewReader(dataFile), int64(len(dataFile)), 10); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expecting error %v; got %v", context.Canceled, err)
+	}
+
+	tl = tlock.New(network, tlock.WithChunkSize(100), tlock.WithMaxPayloadSize(200))
+	var limitErr *tlock.LimitError
+	if err := tl.EncryptFrom(io.Discard, bytes.NewReader(dataFile), int64(len(dataFile)), 10); !errors.As(err, &limitErr) {
+		t.Fatalf("expecting a limit error; got %v", err)
+	}
+}
+
+func Test_EncryptFromChunkSize(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	tests := map[string]struct {
+		size      int64
+		opts      []tlock.Option
+		chunkSize int
+	}{
+		"small":    {size: 100, chunkSize: tlock.DefaultChunkSize},
+		"compact":  {size: 100, opts: []tlock.Option{tlock.WithCompact()}, chunkSize: tlock.MaxCompactSize},
+		"medium":   {size: tlock.MaxCompactSize + 1, opts: []tlock.Option{tlock.WithCompact()}, chunkSize: tlock.DefaultChunkSize},
+		"large":    {size: largeSourceSize, chunkSize: tlock.DefaultChunkSize},
+		"opted in": {size: largeSourceSize, opts: []tlock.Option{tlock.WithChunkSize(tlock.LargeChunkSize)}, chunkSize: tlock.LargeChunkSize},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var cipherData bytes.Buffer
+			err := tlock.New(network, test.opts...).EncryptFrom(&cipherData, zeroReaderAt{}, test.size, 10)
+			if err != nil {
+				t.Fatalf("encrypt error %s", err)
+			}
+
+			header, err := tlock.ReadHeader(bytes.NewReader(cipherData.Bytes()))
+			if err != nil {
+				t.Fatalf("read header error %s", err)
+			}
+			if header.ChunkSize != test.chunkSize {
+				t.Fatalf("expecting chunk size %d; got %d", test.chunkSize, header.ChunkSize)
+			}
+
+			if err := tlock.New(network).Decrypt(io.Discard, &cipherData); err != nil {
+				t.Fatalf("decrypt error %s", err)
+			}
+		})
+	}
+
+	// Streams of unknown size keep the default.
+	var cipherData bytes.Buffer
+	if err := tlock.New(network).Encrypt(&cipherData, io.LimitReader(zeroReader{}, largeSourceSize), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	header, err := tlock.ReadHeader(&cipherData)
+	if err != nil {
+		t.Fatalf("read header error %s", err)
+	}
+	if header.ChunkSize != tlock.DefaultChunkSize {
+		t.Fatalf("expecting chunk size %d; got %d", tlock.DefaultChunkSize, header.ChunkSize)
+	}
+}
+
+// largeSourceSize is the size of the large sources of the chunk size tests
+// and benchmarks.
+const largeSourceSize = 64 * 1024 * 1024
+
+// Benchmark_ChunkSize measures the throughput of encryption for several
+// source and chunk sizes, which the defaults are chosen from.
+func Benchmark_ChunkSize(b *testing.B) {
+	network := fakenet.NewChain(3 * time.Second)
+
+	for _, size := range []int64{64 * 1024, 1024 * 1024, largeSourceSize} {
+		for _, chunkSize := range []int{16 * 1024, tlock.DefaultChunkSize, 256 * 1024, tlock.LargeChunkSize, 4 * 1024 * 1024} {
+			for _, aead := range []tlock.AEAD{tlock.ChaCha20Poly1305, tlock.AES256GCM} {
+				name := fmt.Sprintf("%d/%d/%s", size, chunkSize, aead)
+				b.Run(name, func(b *testing.B) {
+					tl := tlock.New(network, tlock.WithChunkSize(chunkSize), tlock.WithAEAD(aead))
+					b.SetBytes(size)
+					for i := 0; i < b.N; i++ {
+						if err := tl.Encrypt(io.Discard, io.LimitReader(zeroReader{}, size), 10); err != nil {
+							b.Fatalf("encrypt error %s", err)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+// Benchmark_EncryptFrom measures the throughput of EncryptFrom, which
+// encrypts the chunks in parallel, with the default chunk size and with
+// LargeChunkSize.
+func Benchmark_EncryptFrom(b *testing.B) {
+	network := fakenet.NewChain(3 * time.Second)
+
+	for _, size := range []int64{1024 * 1024, largeSourceSize} {
+		for _, chunkSize := range []int{tlock.DefaultChunkSize, tlock.LargeChunkSize} {
+			name := fmt.Sprintf("%d/%d", size, chunkSize)
+			b.Run(name, func(b *testing.B) {
+				tl := tlock.New(network, tlock.WithChunkSize(chunkSize))
+				b.SetBytes(size)
+				for i := 0; i < b.N; i++ {
+					if err := tl.EncryptFrom(io.Discard, zeroReaderAt{}, size, 10); err != nil {
+						b.Fatalf("encrypt error %s", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func Test_CompactPayload(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	message := []byte("reveal: 42")
+	tl := tlock.New(network, tlock.WithAAD([]byte("vote")), tlock.WithCompact())
+
+	var compact bytes.Buffer
+	if err := tl.EncryptFrom(&compact, bytes.NewReader(message), int64(len(message)), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	b := compact.Bytes()
+
+	header, err := tlock.ReadHeader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("read header error %s", err)
+	}
+	if !header.Compact || header.Version != 3 || header.ChunkSize != tlock.MaxCompactSize {
+		t.Fatalf("expecting a compact payload of format v3; got %+v", header)
+	}
+
+	var stream bytes.Buffer
+	if err := tl.Encrypt(&stream, bytes.NewReader(message), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	// The nonce is left out, while the header records the compact payload.
+	if saved := stream.Len() - compact.Len(); saved != 16-len(" compact=1") {
+		t.Fatalf("expecting the compact payload to save the nonce; saved %d bytes", saved)
+	}
+
+	var plainData bytes.Buffer
+	if err := tl.Decrypt(&plainData, bytes.NewReader(b)); err != nil {
+		t.Fatalf("decrypt error %s", err)
+	}
+	if !bytes.Equal(plainData.Bytes(), message) {
+		t.Fatal("decrypted data is invalid")
+	}
+
+	r, err := tl.DecryptReaderAt(context.Background(), bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatalf("decrypt reader error %s", err)
+	}
+	got := make([]byte, len(message))
+	if _, err := r.ReadAt(got, 0); err != nil || !bytes.Equal(got, message) {
+		t.Fatalf("expecting %q; got %q, %v", message, got, err)
+	}
+
+	tests := map[string]struct {
+		data []byte
+		err  error
+	}{
+		"flipped":    {data: append(append([]byte{}, b[:len(b)-1]...), b[len(b)-1]^1), err: tlock.ErrAADMismatch},
+		"extended":   {data: append(append([]byte{}, b...), make([]byte, 16)...), err: tlock.ErrAADMismatch},
+		"downgraded": {data: bytes.Replace(b, []byte(" v=3"), []byte(" v=2"), 1), err: tlock.ErrMalformedHeader},
+		"chunked":    {data: bytes.Replace(b, []byte(" compact=1"), []byte(" compact=1 chunk=100"), 1), err: tlock.ErrMalformedHeader},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tl.Decrypt(io.Discard, bytes.NewReader(test.data)); !errors.Is(err, test.err) {
+				t.Fatalf("expecting error %v; got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func Test_Encryptor(t *testing.T) {
+	for _, network := range []*fakenet.Chain{fakenet.NewChain(3 * time.Second), fakenet.NewChainOnG1(3 * time.Second), fakenet.NewChainOnLegacyG1(3 * time.Second), fakenet.NewChainOnBN254(3 * time.Second)} {
+		network.Unlock()
+
+		enc, err := tlock.New(network).NewEncryptor(10)
+		if err != nil {
+			t.Fatalf("%s: new encryptor error %s", network.SchemeID(), err)
+		}
+		if enc.RoundNumber() != 10 {
+			t.Fatalf("%s: expecting round 10; got %d", network.SchemeID(), enc.RoundNumber())
+		}
+
+		// Every upload is encrypted with its own key, concurrently.
+		uploads := make([]bytes.Buffer, 8)
+		var wg sync.WaitGroup
+		for i := range uploads {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if err := enc.Encrypt(&uploads[i], strings.NewReader(fmt.Sprintf("upload %d", i))); err != nil {
+					t.Errorf("%s: encrypt error %s", network.SchemeID(), err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		for i := range uploads {
+			var plainData bytes.Buffer
+			if err := tlock.New(network).Decrypt(&plainData, &uploads[i]); err != nil {
+				t.Fatalf("%s: decrypt error %s", network.SchemeID(), err)
+			}
+			if want := fmt.Sprintf("upload %d", i); plainData.String() != want {
+				t.Fatalf("%s: expecting %q; got %q", network.SchemeID(), want, plainData.String())
+			}
+		}
+
+		// With convergent encryption, the output is the one of the tlock.
+		tl := tlock.New(network, tlock.WithConvergentKey([]byte("key")))
+		var want, got bytes.Buffer
+		if err := tl.Encrypt(&want, bytes.NewReader(dataFile), 10); err != nil {
+			t.Fatalf("%s: encrypt error %s", network.SchemeID(), err)
+		}
+		enc, err = tl.NewEncryptor(10)
+		if err != nil {
+			t.Fatalf("%s: new encryptor error %s", network.SchemeID(), err)
+		}
+		if err := enc.Encrypt(&got, bytes.NewReader(dataFile)); err != nil {
+			t.Fatalf("%s: encrypt error %s", network.SchemeID(), err)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Fatalf("%s: expecting the encrypted data of the tlock", network.SchemeID())
+		}
+	}
+}
+
+// Benchmark_Encryptor compares encrypting short messages to the same round
+// with and without an Encryptor.
+func Benchmark_Encryptor(b *testing.B) {
+	network := fakenet.NewChain(3 * time.Second)
+	message := []byte("commit: 42")
+
+	b.Run("tlock", func(b *testing.B) {
+		tl := tlock.New(network)
+		for i := 0; i < b.N; i++ {
+			if err := tl.Encrypt(io.Discard, bytes.NewReader(message), 10); err != nil {
+				b.Fatalf("encrypt error %s", err)
+			}
+		}
+	})
+
+	b.Run("encryptor", func(b *testing.B) {
+		enc, err := tlock.New(network).NewEncryptor(10)
+		if err != nil {
+			b.Fatalf("new encryptor error %s", err)
+		}
+		for i := 0; i < b.N; i++ {
+			if err := enc.Encrypt(io.Discard, bytes.NewReader(message)); err != nil {
+				b.Fatalf("encrypt error %s", err)
+			}
+		}
+	})
+}
+
+func Test_Tracing(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	var rec spanRecorder
+	tl := tlock.New(network, tlock.WithTracerProvider(&rec))
+
+	var cipherData bytes.Buffer
+	if err := tl.Encrypt(&cipherData, bytes.NewReader(dataFile), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	var plainData bytes.Buffer
+	if err := tl.Decrypt(&plainData, bytes.NewReader(cipherData.Bytes())); err != nil {
+		t.Fatalf("decrypt error %s", err)
+	}
+
+	if len(rec.spans) != 3 {
+		t.Fatalf("expecting 3 spans; got %d", len(rec.spans))
+	}
+
+	for i, name := range []string{"tlock.Encrypt", "tlock.Decrypt", "tlock.Unwrap"} {
+		s := rec.spans[i]
+		if s.name != name || !s.ended || s.failed {
+			t.Fatalf("unexpected span %d: %+v", i, s)
+		}
+		if s.attrs["tlock.chain_hash"].AsString() != network.ChainHash() || s.attrs["tlock.round"].AsInt64() != 10 {
+			t.Fatalf("span %s is missing the chain and round: %v", name, s.attrs)
+		}
+	}
+
+	if n := rec.spans[0].attrs["tlock.plain_bytes"].AsInt64(); n != int64(len(dataFile)) {
+		t.Fatalf("expecting %d plain bytes encrypted; got %d", len(dataFile), n)
+	}
+	if n := rec.spans[0].attrs["tlock.encrypted_bytes"].AsInt64(); n != int64(cipherData.Len()) {
+		t.Fatalf("expecting %d encrypted bytes; got %d", cipherData.Len(), n)
+	}
+	if n := rec.spans[1].attrs["tlock.plain_bytes"].AsInt64(); n != int64(len(dataFile)) {
+		t.Fatalf("expecting %d plain bytes decrypted; got %d", len(dataFile), n)
+	}
+
+	locked := fakenet.NewChain(3 * time.Second)
+	cipherData.Reset()
+	if err := tlock.New(locked).Encrypt(&cipherData, bytes.NewReader(dataFile), locked.RoundNumber(time.Now())+100); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	rec.spans = nil
+	err := tlock.New(locked, tlock.WithTracerProvider(&rec)).Decrypt(io.Discard, bytes.NewReader(cipherData.Bytes()))
+	if !errors.Is(err, tlock.ErrTooEarly) {
+		t.Fatalf("expecting error %v; got %v", tlock.ErrTooEarly, err)
+	}
+	for _, s := range rec.spans {
+		if !s.ended || !s.failed {
+			t.Fatalf("expecting span %s to end with an error", s.name)
+		}
+	}
+}
+
+// spanRecorder is a tracer provider that records the spans it starts.
+type spanRecorder struct {
+	spans []*recordedSpan
+}
+
+func (r *spanRecorder) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return r
+}
+
+func (r *spanRecorder) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	s := recordedSpan{
+		Span:  trace.SpanFromContext(context.Background()),
+		name:  name,
+		attrs: make(map[attribute.Key]attribute.Value),
+	}
+	cfg := trace.NewSpanStartConfig(opts...)
+	s.SetAttributes(cfg.Attributes()...)
+	r.spans = append(r.spans, &s)
+
+	return trace.ContextWithSpan(ctx, &s), &s
+}
+
+// recordedSpan records the attributes, status and end of a span.
+type recordedSpan struct {
+	trace.Span
+	name   string
+	attrs  map[attribute.Key]attribute.Value
+	failed bool
+	ended  bool
+}
+
+func (s *recordedSpan) SetAttributes(kv ...attribute.KeyValue) {
+	for _, a := range kv {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *recordedSpan) SetStatus(code codes.Code, _ string) {
+	s.failed = code == codes.Error
+}
+
+func (s *recordedSpan) End(...trace.SpanEndOption) {
+	s.ended = true
+}
+
+func Test_Policy(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	now := time.Now()
+	inTenMinutes := network.RoundNumber(now.Add(10 * time.Minute))
+
+	tests := map[string]struct {
+		policy tlock.Policy
+		round  uint64
+		armor  bool
+		valid  bool
+	}{
+		"zero value":       {policy: tlock.Policy{}, round: 1, valid: true},
+		"allowed chain":    {policy: tlock.Policy{AllowedChains: []string{network.ChainHash()}}, round: inTenMinutes, valid: true},
+		"other chain":      {policy: tlock.Policy{AllowedChains: []string{testnetChainHash}}, round: inTenMinutes},
+		"armored":          {policy: tlock.Policy{RequireArmor: true}, round: inTenMinutes, armor: true, valid: true},
+		"not armored":      {policy: tlock.Policy{RequireArmor: true}, round: inTenMinutes},
+		"future round":     {policy: tlock.Policy{ForbidPastRounds: true}, round: inTenMinutes, valid: true},
+		"past round":       {policy: tlock.Policy{ForbidPastRounds: true}, round: 1},
+		"within bounds":    {policy: tlock.Policy{MinDuration: time.Minute, MaxDuration: time.Hour}, round: inTenMinutes, valid: true},
+		"below minimum":    {policy: tlock.Policy{MinDuration: time.Hour}, round: inTenMinutes},
+		"above maximum":    {policy: tlock.Policy{MaxDuration: time.Minute}, round: inTenMinutes},
+		"maximum only set": {policy: tlock.Policy{MaxDuration: time.Hour}, round: inTenMinutes, valid: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var cipherData bytes.Buffer
+			var dst io.Writer = &cipherData
+			if test.armor {
+				dst = armor.NewWriter(dst)
+			}
+
+			err := tlock.New(network, tlock.WithPolicy(test.policy)).Encrypt(dst, bytes.NewReader(dataFile), test.round)
+			switch {
+			case test.valid && err != nil:
+				t.Fatalf("encrypt error %s", err)
+			case !test.valid && !errors.Is(err, tlock.ErrPolicy):
+				t.Fatalf("expecting error %v; got %v", tlock.ErrPolicy, err)
+			case !test.valid && cipherData.Len() != 0:
+				t.Fatal("expecting nothing to be written")
+			}
+		})
+	}
+}
+
+func Test_SignatureCache(t *testing.T) {
+	chain := fakenet.NewChain(3 * time.Second)
+	chain.Unlock()
+	network := &countingNetwork{Network: chain}
+
+	encrypt := func(roundNumber uint64) []byte {
+		var cipherData bytes.Buffer
+		if err := tlock.New(network).Encrypt(&cipherData, bytes.NewReader(dataFile), roundNumber); err != nil {
+			t.Fatalf("encrypt error %s", err)
+		}
+		return cipherData.Bytes()
+	}
+	first, second := encrypt(10), encrypt(11)
+
+	cache := tlock.NewSignatureCache(1)
+	tl := tlock.New(network, tlock.WithSignatureCache(cache))
+
+	for _, encrypted := range [][]byte{first, first, first, second, first} {
+		if err := tl.Decrypt(io.Discard, bytes.NewReader(encrypted)); err != nil {
+			t.Fatalf("decrypt error %s", err)
+		}
+	}
+
+	if network.calls != 3 {
+		t.Fatalf("expecting 3 signatures retrieved; got %d", network.calls)
+	}
+
+	want := tlock.CacheStats{Entries: 1, Size: 1, Hits: 2, Misses: 3, Evictions: 2}
+	if stats := cache.Stats(); stats != want {
+		t.Fatalf("expecting stats %+v; got %+v", want, stats)
+	}
+}
+
+// countingNetwork counts the signatures retrieved from the network.
+type countingNetwork struct {
+	tlock.Network
+	mu    sync.Mutex
+	calls int
+}
+
+func (n *countingNetwork) Signature(roundNumber uint64) ([]byte, error) {
+	n.mu.Lock()
+	n.calls++
+	n.mu.Unlock()
+
+	return n.Network.Signature(roundNumber)
+}
+
+func Test_BeaconSource(t *testing.T) {
+	network := fakenet.NewChainFromSeed(3*time.Second, "beacon source")
+	signer := fakenet.NewChainFromSeed(3*time.Second, "beacon source")
+	signer.Unlock()
+	future := network.RoundNumber(time.Now()) + 100
+
+	var cipherData bytes.Buffer
+	if err := tlock.New(network).Encrypt(&cipherData, bytes.NewReader(dataFile), future); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	if err := tlock.New(network).Decrypt(io.Discard, bytes.NewReader(cipherData.Bytes())); !errors.Is(err, tlock.ErrTooEarly) {
+		t.Fatalf("expecting error %s; got %v", tlock.ErrTooEarly, err)
+	}
+
+	var rounds []uint64
+	source := networks.BeaconFunc(func(ctx context.Context, roundNumber uint64) ([]byte, error) {
+		rounds = append(rounds, roundNumber)
+		return signer.Signature(roundNumber)
+	})
+
+	var plainData bytes.Buffer
+	if err := tlock.New(network, tlock.WithBeaconSource(source)).Decrypt(&plainData, bytes.NewReader(cipherData.Bytes())); err != nil {
+		t.Fatalf("decrypt error %s", err)
+	}
+	if !bytes.Equal(plainData.Bytes(), dataFile) {
+		t.Fatalf("unexpected plain data")
+	}
+	if len(rounds) != 1 || rounds[0] != future {
+		t.Fatalf("expecting the signature of round %d from the source; got %v", future, rounds)
+	}
+
+	// The signatures of the source are verified against the network.
+	forged := fakenet.NewChainFromSeed(3*time.Second, "forged source")
+	forged.Unlock()
+	source = networks.BeaconFunc(func(ctx context.Context, roundNumber uint64) ([]byte, error) {
+		return forged.Signature(roundNumber)
+	})
+	if err := tlock.New(network, tlock.WithBeaconSource(source)).Decrypt(io.Discard, bytes.NewReader(cipherData.Bytes())); err == nil {
+		t.Fatal("expecting a forged signature to be rejected")
+	}
+}
+
+func Test_DecryptBatch(t *testing.T) {
+	chain := fakenet.NewChain(3 * time.Second)
+	network := &countingNetwork{Network: chain}
+	future := chain.RoundNumber(time.Now()) + 100
+
+	rounds := []uint64{2, 1, future, 0, 1, 2, 1}
+	items := make([]tlock.BatchItem, len(rounds))
+	outputs := make([]bytes.Buffer, len(rounds))
+	for i, roundNumber := range rounds {
+		var cipherData bytes.Buffer
+		switch roundNumber {
+		case 0:
+			cipherData.WriteString("not encrypted")
+		default:
+			if err := tlock.New(network).Encrypt(&cipherData, bytes.NewReader(dataFile), roundNumber); err != nil {
+				t.Fatalf("encrypt error %s", err)
+			}
+		}
+		items[i] = tlock.BatchItem{Src: &cipherData, Dst: &outputs[i]}
+	}
+
+	results := tlock.New(network).DecryptBatch(context.Background(), items)
+
+	for i, r := range results {
+		switch rounds[i] {
+		case 0:
+			if r.Err == nil {
+				t.Fatalf("item %d: expecting an error for invalid data", i)
+			}
+		case future:
+			if !errors.Is(r.Err, tlock.ErrTooEarly) || r.Header.RoundNumber != future {
+				t.Fatalf("item %d: expecting error %v for round %d; got %v for round %d", i, tlock.ErrTooEarly, future, r.Err, r.Header.RoundNumber)
+			}
+		default:
+			if r.Err != nil || r.Header.RoundNumber != rounds[i] {
+				t.Fatalf("item %d: unexpected result %+v", i, r)
+			}
+			if !bytes.Equal(outputs[i].Bytes(), dataFile) {
+				t.Fatalf("item %d: decrypted data is invalid", i)
+			}
+		}
+	}
+
+	if network.calls != 3 {
+		t.Fatalf("expecting 3 signatures retrieved; got %d", network.calls)
+	}
+}
+
+func Test_EncryptRelative(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+	now := time.Now()
+
+	tl := tlock.New(network, tlock.WithClock(fixedClock(now)))
+
+	var cipherData bytes.Buffer
+	roundNumber, err := tl.EncryptRelative(&cipherData, bytes.NewReader(dataFile), 10)
+	if err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	if want := network.RoundNumber(now) + 10; roundNumber != want {
+		t.Fatalf("expecting round %d; got %d", want, roundNumber)
+	}
+
+	header, err := tlock.ReadHeader(bytes.NewReader(cipherData.Bytes()))
+	if err != nil || header.RoundNumber != roundNumber {
+		t.Fatalf("expecting the data to be locked to round %d; got %+v, %v", roundNumber, header, err)
+	}
+
+	if _, err := tl.EncryptRelative(io.Discard, bytes.NewReader(dataFile), 0); err == nil {
+		t.Fatal("expecting an error for zero rounds")
+	}
+
+	if _, err := tlock.New(stubNetwork{}).EncryptRelative(io.Discard, bytes.NewReader(dataFile), 10); err == nil {
+		t.Fatal("expecting an error for a network without rounds")
+	}
+}
+
+func Test_Segments(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	future := network.RoundNumber(time.Now()) + 100
+
+	chapters := [][]byte{dataFile, []byte("chapter two"), {}, []byte("chapter four")}
+	rounds := []uint64{1, 2, future, future + 10}
+
+	segments := make([]tlock.Segment, len(chapters))
+	for i := range chapters {
+		segments[i] = tlock.Segment{RoundNumber: rounds[i], Src: bytes.NewReader(chapters[i])}
+	}
+
+	var cipherData bytes.Buffer
+	w := armor.NewWriter(&cipherData)
+	if err := tlock.New(network, tlock.WithChunkSize(64)).EncryptSegments(w, segments); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("armor error %s", err)
+	}
+
+	var plainData bytes.Buffer
+	progress, err := tlock.New(network).DecryptSegments(&plainData, bytes.NewReader(cipherData.Bytes()))
+	if err != nil {
+		t.Fatalf("decrypt error %s", err)
+	}
+	if progress.Decrypted != 2 || len(progress.Locked) != 2 || progress.Locked[0].RoundNumber != future || progress.Locked[1].RoundNumber != future+10 {
+		t.Fatalf("unexpected progress %+v", progress)
+	}
+	if want := append(append([]byte{}, chapters[0]...), chapters[1]...); !bytes.Equal(plainData.Bytes(), want) {
+		t.Fatalf("decrypted data is invalid")
+	}
+
+	network.Unlock()
+
+	plainData.Reset()
+	progress, err = tlock.New(network).DecryptSegments(&plainData, bytes.NewReader(cipherData.Bytes()))
+	if err != nil {
+		t.Fatalf("decrypt error %s", err)
+	}
+	if progress.Decrypted != 4 || len(progress.Locked) != 0 {
+		t.Fatalf("unexpected progress %+v", progress)
+	}
+	if want := bytes.Join(chapters, nil); !bytes.Equal(plainData.Bytes(), want) {
+		t.Fatalf("decrypted data is invalid")
+	}
+
+	var plain bytes.Buffer
+	if err := tlock.New(network).EncryptSegments(&plain, segments[:1]); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	truncated := plain.Bytes()[:plain.Len()-1]
+	if _, err := tlock.New(network).DecryptSegments(io.Discard, bytes.NewReader(truncated)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expecting error %v; got %v", io.ErrUnexpectedEOF, err)
+	}
+
+	segments[1], segments[2] = segments[2], segments[1]
+	if err := tlock.New(network).EncryptSegments(io.Discard, segments); err == nil {
+		t.Fatalf("expecting an error for decreasing rounds")
+	}
+}
+
+func Test_Journal(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	path := filepath.Join(t.TempDir(), "journal.tle")
+
+	now := time.Now()
+	entries := []struct {
+		time    time.Time
+		data    string
+		embargo time.Duration
+	}{
+		{now.Add(-time.Minute), "disclosed", 10 * time.Second},
+		{now, "embargoed", time.Hour},
+		{now.Add(-time.Hour), "also disclosed", time.Minute},
+	}
+
+	for _, e := range entries {
+		journal, err := tlock.New(network).OpenJournal(path, e.embargo)
+		if err != nil {
+			t.Fatalf("open error %s", err)
+		}
+		if _, err := journal.Append(e.time, []byte(e.data)); err != nil {
+			t.Fatalf("append error %s", err)
+		}
+		if err := journal.Close(); err != nil {
+			t.Fatalf("close error %s", err)
+		}
+	}
+
+	read := func() ([]tlock.JournalRecord, []tlock.Header) {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("open error %s", err)
+		}
+		defer f.Close()
+
+		jr := tlock.New(network).ReadJournal(f)
+
+		var records []tlock.JournalRecord
+		for {
+			r, err := jr.Next(context.Background())
+			if errors.Is(err, io.EOF) {
+				return records, jr.Locked()
+			}
+			if err != nil {
+				t.Fatalf("read error %s", err)
+			}
+			records = append(records, r)
+		}
+	}
+
+	records, locked := read()
+	if len(records) != 2 || len(locked) != 1 {
+		t.Fatalf("expecting 2 records and 1 locked; got %d and %d", len(records), len(locked))
+	}
+	if string(records[1].Data) != "also disclosed" || !records[1].Time.Equal(entries[2].time) {
+		t.Fatalf("unexpected record %+v", records[1])
+	}
+	if want := network.RoundNumber(now.Add(time.Hour)); locked[0].RoundNumber != want {
+		t.Fatalf("expecting round %d; got %d", want, locked[0].RoundNumber)
+	}
+
+	network.Unlock()
+
+	if records, locked = read(); len(records) != 3 || len(locked) != 0 || string(records[1].Data) != "embargoed" {
+		t.Fatalf("expecting every record once unlocked; got %d and %d", len(records), len(locked))
+	}
+
+	if _, err := tlock.New(network).OpenJournal(path, -time.Second); err == nil {
+		t.Fatalf("expecting an error for a negative embargo")
+	}
+}
+
+func Test_Draw(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	roundNumber := network.RoundNumber(time.Now())
+
+	commitments := [][]byte{[]byte("alice"), []byte("bob"), []byte("carol"), []byte("dave")}
+
+	d, err := tlock.New(network).Draw(context.Background(), roundNumber, commitments)
+	if err != nil {
+		t.Fatalf("draw error %s", err)
+	}
+	if len(d.Order) != len(commitments) || len(d.Winners(2)) != 2 || len(d.Winners(10)) != len(commitments) {
+		t.Fatalf("unexpected order %v", d.Order)
+	}
+
+	if err := tlock.VerifyDraw(network, d, commitments); err != nil {
+		t.Fatalf("verify error %s", err)
+	}
+
+	// The order doesn't depend on the order the commitments are listed in.
+	reversed := [][]byte{commitments[3], commitments[2], commitments[1], commitments[0]}
+	for i, index := range tlock.DrawOrder(tlock.Randomness(d.Signature), reversed) {
+		if string(reversed[index]) != string(commitments[d.Order[i]]) {
+			t.Fatalf("expecting the same order for reversed commitments")
+		}
+	}
+
+	forged := d
+	forged.Order = []int{d.Order[1], d.Order[0], d.Order[2], d.Order[3]}
+	if err := tlock.VerifyDraw(network, forged, commitments); err == nil {
+		t.Fatal("expecting an error for a forged order")
+	}
+
+	forged = d
+	forged.Signature, _ = network.Signature(roundNumber - 1)
+	forged.Order = tlock.DrawOrder(tlock.Randomness(forged.Signature), commitments)
+	if err := tlock.VerifyDraw(network, forged, commitments); err == nil {
+		t.Fatal("expecting an error for the signature of another round")
+	}
+
+	if _, err := tlock.New(network).Draw(context.Background(), roundNumber+100, commitments); !errors.Is(err, tlock.ErrTooEarly) {
+		t.Fatalf("expecting ErrTooEarly; got %v", err)
+	}
+}
+
+func Test_UnlockCode(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	roundNumber := network.RoundNumber(time.Now()) + 100
+
+	encrypt := func() []byte {
+		var b bytes.Buffer
+		if err := tlock.New(network).Encrypt(&b, strings.NewReader("sealed"), roundNumber); err != nil {
+			t.Fatalf("encrypt error %s", err)
+		}
+		return b.Bytes()
+	}
+
+	first := encrypt()
+	code, err := tlock.UnlockCode(bytes.NewReader(first))
+	if err != nil {
+		t.Fatalf("unlock code error %s", err)
+	}
+	if len(code) != len("K4QF-7XNM-2RBD") || strings.Count(code, "-") != 2 {
+		t.Fatalf("unexpected unlock code %q", code)
+	}
+
+	var armored bytes.Buffer
+	w := armor.NewWriter(&armored)
+	w.Write(first)
+	w.Close()
+
+	if armoredCode, err := tlock.UnlockCode(&armored); err != nil || armoredCode != code {
+		t.Fatalf("expecting the armored data to have code %s; got %s, %v", code, armoredCode, err)
+	}
+
+	if other, err := tlock.UnlockCode(bytes.NewReader(encrypt())); err != nil || other == code {
+		t.Fatalf("expecting another encryption to have another code; got %s, %v", other, err)
+	}
+}
+
+func Test_ConvergentEncryption(t *testing.T) {
+	for _, network := range []*fakenet.Chain{fakenet.NewChain(3 * time.Second), fakenet.NewChainOnG1(3 * time.Second)} {
+		network.Unlock()
+		roundNumber := network.RoundNumber(time.Now()) + 100
+
+		encrypt := func(key string, data string, opts ...tlock.Option) []byte {
+			var b bytes.Buffer
+			opts = append(opts, tlock.WithConvergentKey([]byte(key)))
+			if err := tlock.New(network, opts...).Encrypt(&b, strings.NewReader(data), roundNumber); err != nil {
+				t.Fatalf("encrypt error %s", err)
+			}
+			return b.Bytes()
+		}
+
+		first := encrypt("tenant", "same file")
+		if !bytes.Equal(first, encrypt("tenant", "same file")) {
+			t.Fatalf("%s: expecting identical encrypted data", network.SchemeID())
+		}
+
+		for name, other := range map[string][]byte{
+			"key":        encrypt("other tenant", "same file"),
+			"data":       encrypt("tenant", "other file"),
+			"chunk size": encrypt("tenant", "same file", tlock.WithChunkSize(1024)),
+		} {
+			if bytes.Equal(first, other) {
+				t.Fatalf("%s: expecting another %s to change the encrypted data", network.SchemeID(), name)
+			}
+		}
+
+		var plain bytes.Buffer
+		if err := tlock.New(network).Decrypt(&plain, bytes.NewReader(first)); err != nil {
+			t.Fatalf("%s: decrypt error %s", network.SchemeID(), err)
+		}
+		if plain.String() != "same file" {
+			t.Fatalf("%s: unexpected plain data %q", network.SchemeID(), plain.String())
+		}
+	}
+
+	network := fakenet.NewChain(3 * time.Second)
+	err := tlock.New(network, tlock.WithConvergentKey([]byte("tenant"))).Encrypt(io.Discard, io.LimitReader(strings.NewReader("data"), 4), 1)
+	if err == nil {
+		t.Fatal("expecting an error for a source that can't seek")
+	}
+}
+
+func Test_ContentDigest(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	encrypt := func(src io.Reader, opts ...tlock.Option) []byte {
+		var b bytes.Buffer
+		if err := tlock.New(network, opts...).Encrypt(&b, src, 10); err != nil {
+			t.Fatalf("encrypt error %s", err)
+		}
+		return b.Bytes()
+	}
+
+	plain := encrypt(bytes.NewReader(dataFile), tlock.WithContentDigest())
+	header, err := tlock.ReadHeader(bytes.NewReader(plain))
+	if err != nil {
+		t.Fatalf("read header error %s", err)
+	}
+	if sum := sha256.Sum256(dataFile); !bytes.Equal(header.Digest, sum[:]) || header.DigestKeyed {
+		t.Fatalf("expecting the SHA-256 of the data; got %x", header.Digest)
+	}
+
+	keyed := encrypt(bytes.NewReader(dataFile), tlock.WithDigestKey([]byte("secret")))
+	header, err = tlock.ReadHeader(bytes.NewReader(keyed))
+	if err != nil {
+		t.Fatalf("read header error %s", err)
+	}
+	if sum := sha256.Sum256(dataFile); bytes.Equal(header.Digest, sum[:]) || !header.DigestKeyed {
+		t.Fatalf("expecting a keyed digest; got %x", header.Digest)
+	}
+
+	// The data changes between the digest and the encryption.
+	changed := encrypt(&changingReader{data: append([]byte{}, dataFile...)}, tlock.WithContentDigest())
+
+	tests := map[string]struct {
+		data []byte
+		opts []tlock.Option
+		err  error
+	}{
+		"digest":            {data: plain},
+		"keyed digest":      {data: keyed, opts: []tlock.Option{tlock.WithDigestKey([]byte("secret"))}},
+		"keyed without key": {data: keyed},
+		"wrong key":         {data: keyed, opts: []tlock.Option{tlock.WithDigestKey([]byte("other"))}, err: tlock.ErrDigestMismatch},
+		"changed data":      {data: changed, err: tlock.ErrDigestMismatch},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var b bytes.Buffer
+			err := tlock.New(network, tt.opts...).Decrypt(&b, bytes.NewReader(tt.data))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expecting %v; got %v", tt.err, err)
+			}
+		})
+	}
+
+	if err := tlock.New(network, tlock.WithContentDigest()).Encrypt(io.Discard, io.LimitReader(bytes.NewReader(dataFile), 4), 10); err == nil {
+		t.Fatal("expecting an error for a source that can't seek")
+	}
+}
+
+// changingReader flips the first byte of the data every time it's read from
+// the start.
+type changingReader struct {
+	data   []byte
+	offset int
+}
+
+func (r *changingReader) Read(p []byte) (int, error) {
+	if r.offset == 0 && len(r.data) > 0 {
+		r.data[0] ^= 1
+	}
+	if r.offset >= len(r.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[r.offset:])
+	r.offset += n
+	return n, nil
+}
+
+func (r *changingReader) Seek(offset int64, whence int) (int64, error) {
+	r.offset = int(offset)
+	return offset, nil
+}
+
+func Test_IsEncrypted(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+
+	var binary bytes.Buffer
+	if err := tlock.New(network).Encrypt(&binary, strings.NewReader("secret"), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	var armored bytes.Buffer
+	w := armor.NewWriter(&armored, armor.WithLabel("TLOCK ENCRYPTED FILE"), armor.WithWidth(1024))
+	if err := tlock.New(network).Encrypt(w, bytes.NewReader(bytes.Repeat([]byte("secret"), 1000)), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+	w.Close()
+
+	tests := map[string]struct {
+		data      []byte
+		encrypted bool
+	}{
+		"binary":      {data: binary.Bytes(), encrypted: true},
+		"armored":     {data: armored.Bytes(), encrypted: true},
+		"blank lines": {data: append([]byte("\r\n\n"), armored.Bytes()...), encrypted: true},
+		"plain":       {data: []byte("age-encryption.org is a format")},
+		"certificate": {data: []byte("-----BEGIN CERTIFICATE-----\nMIIBszCCAVmgAwIBAgIUQ0t0\n-----END CERTIFICATE-----\n")},
+		"empty":       {},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			prefix := tt.data
+			if len(prefix) > tlock.SniffSize {
+				prefix = prefix[:tlock.SniffSize]
+			}
+			if encrypted := tlock.IsEncrypted(prefix); encrypted != tt.encrypted {
+				t.Fatalf("expecting %v; got %v", tt.encrypted, encrypted)
+			}
+		})
+	}
+}
+
+func Test_Limits(t *testing.T) {
+	network := fakenet.NewChain(3 * time.Second)
+	network.Unlock()
+
+	plain := bytes.Repeat([]byte("0123456789"), 100)
+
+	var cipherData bytes.Buffer
+	if err := tlock.New(network, tlock.WithChunkSize(100)).Encrypt(&cipherData, bytes.NewReader(plain), 10); err != nil {
+		t.Fatalf("encrypt error %s", err)
+	}
+
+	tests := map[string]struct {
+		opt   tlock.Option
+		limit string
+	}{
+		"payload size": {opt: tlock.WithMaxPayloadSize(int64(len(plain)) - 1), limit: "payload size"},
+		"chunk count":  {opt: tlock.WithMaxChunkCount(9), limit: "chunk count"},
+		"header size":  {opt: tlock.WithMaxHeaderSize(100), limit: "header size"},
+		"no limit":     {opt: tlock.WithMaxHeaderSize(0)},
+		"at the limit": {opt: tlock.WithMaxPayloadSize(int64(len(plain)))},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tl := tlock.New(network, tt.opt, tlock.WithChunkSize(100))
+
+			errs := map[string]error{
+				"decrypt": tl.Decrypt(io.Discard, bytes.NewReader(cipherData.Bytes())),
+			}
+			if tt.limit != "header size" {
+				errs["encrypt"] = tl.Encrypt(io.Discard, bytes.NewReader(plain), 10)
+			}
+
+			for op, err := range errs {
+				var le *tlock.LimitError
+				switch {
+				case tt.limit == "" && err != nil:
+					t.Fatalf("%s error %s", op, err)
+				case tt.limit != "" && !errors.As(err, &le):
+					t.Fatalf("expecting %s error %v; got %v", op, tlock.ErrLimitExceeded, err)
+				case le != nil && le.Limit != tt.limit:
+					t.Fatalf("expecting %s limit %s; got %s", op, tt.limit, le.Limit)
+				}
+			}
+		})
+	}
+}
+
+// zeroReader yields an endless stream of zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// zeroReaderAt yields zeros at any offset.
+type zeroReaderAt struct{}
+
+func (zeroReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return zeroReader{}.Read(p)
+}
+func Test_ParseDuration(t *testing.T) {
+	type test struct {
+		name     string
+		duration string
+		date     time.Time
+		expected time.Duration
+		err      error
+	}
+
+	tests := []test{
+		{name: "parseDay", duration: "1d", date: time.Now(), expected: 24 * time.Hour, err: nil},
+		{name: "parseMonth", duration: "1M", date: time.Date(2022, 01, 01, 0, 0, 0, 0, time.UTC), expected: time.Duration(31*24) * time.Hour, err: nil},
+		{name: "parseYear", duration: "1y", date: time.Date(2022, 01, 01, 0, 0, 0, 0, time.UTC), expected: time.Duration(365*24) * time.Hour, err: nil},
+		{name: "parseInvalid", duration: "1C", date: time.Now(), expected: time.Second, err: tlock.ErrInvalidDuration},
+		{name: "parseWeek", duration: "2w", date: time.Now(), expected: 14 * 24 * time.Hour, err: nil},
+		{name: "parseClock", duration: "1h30m", date: time.Now(), expected: 90 * time.Minute, err: nil},
+		{name: "parseYearMonth", duration: "1y6M", date: time.Date(2022, 01, 01, 0, 0, 0, 0, time.UTC), expected: time.Duration(546*24) * time.Hour, err: nil},
+		{name: "parseWeekDayHour", duration: "2w3d12h", date: time.Now(), expected: time.Duration(17*24+12) * time.Hour, err: nil},
+		{name: "parseDayClock", duration: "1d12h30m15s", date: time.Now(), expected: 36*time.Hour + 30*time.Minute + 15*time.Second, err: nil},
+		{name: "parseLeapYear", duration: "1y", date: time.Date(2024, 01, 01, 0, 0, 0, 0, time.UTC), expected: time.Duration(366*24) * time.Hour, err: nil},
+		{name: "parseFebruary", duration: "1M", date: time.Date(2022, 02, 01, 0, 0, 0, 0, time.UTC), expected: time.Duration(28*24) * time.Hour, err: nil},
+		{name: "parseISODate", duration: "P1Y2M3D", date: time.Date(2022, 01, 01, 0, 0, 0, 0, time.UTC), expected: time.Duration((365+31+28+3)*24) * time.Hour, err: nil},
+		{name: "parseISOWeek", duration: "P2W", date: time.Now(), expected: 14 * 24 * time.Hour, err: nil},
+		{name: "parseISOTime", duration: "PT1H30M", date: time.Now(), expected: 90 * time.Minute, err: nil},
+		{name: "parseISOFull", duration: "P1DT12H0.5S", date: time.Now(), expected: 36*time.Hour + 500*time.Millisecond, err: nil},
+		{name: "parseInvalidUnitCombined", duration: "1d1C", date: time.Now(), expected: time.Second, err: tlock.ErrInvalidDuration},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			duration, err := tlock.ParseDuration(tc.date, tc.duration)
+			if tc.err == nil && err != nil {
+				t.Fatalf("unexpected parse error: %s", err)
+			}
+
+			if tc.err != nil && !errors.Is(err, tc.err) {
+				t.Fatalf("expecting parsing error '%s'; got %v", tlock.ErrInvalidDuration, err)
+			}
+
+			if duration != tc.expected {
+				t.Fatalf("expecting duration %s; go %s", tc.expected, duration)
+			}
+
+		})
+	}
+}
+
+// fixedNetwork calculates rounds from a fixed genesis time and period.
+type fixedNetwork struct {
+	genesis time.Time
+	period  time.Duration
+}
+
+func (n fixedNetwork) RoundNumber(t time.Time) uint64 {
+	return uint64(t.Sub(n.genesis)/n.period) + 1
+}
+
+func Test_ParseRound(t *testing.T) {
+	network := fixedNetwork{
+		genesis: time.Date(2022, 01, 01, 0, 0, 0, 0, time.UTC),
+		period:  3 * time.Second,
+	}
+	now := time.Date(2022, 01, 01, 0, 0, 30, 0, time.UTC)
+
+	type test struct {
+		name     string
+		spec     string
+		expected uint64
+		err      error
+	}
+
+	tests := []test{
+		{name: "absolute", spec: "1234567", expected: 1234567},
+		{name: "relative", spec: "+10", expected: 21},
+		{name: "time", spec: "time:2022-01-01T00:01Z", expected: 21},
+		{name: "timeSeconds", spec: "time:2022-01-01T00:01:03Z", expected: 22},
+		{name: "duration", spec: "dur:30s", expected: 21},
+		{name: "invalidNumber", spec: "12a", err: tlock.ErrInvalidRound},
+		{name: "invalidRelative", spec: "+-1", err: tlock.ErrInvalidRound},
+		{name: "zeroRelative", spec: "+0", err: tlock.ErrInvalidRound},
+		{name: "overflowRelative", spec: "+18446744073709551615", err: tlock.ErrInvalidRound},
+		{name: "invalidTime", spec: "time:tomorrow", err: tlock.ErrInvalidRound},
+		{name: "invalidDuration", spec: "dur:1C", err: tlock.ErrInvalidDuration},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			roundNumber, err := tlock.ParseRound(tc.spec, now, network)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("expecting parsing error '%s'; got %v", tc.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected parse error: %s", err)
+			}
+
+			if roundNumber != tc.expected {
+				t.Fatalf("expecting round %d; got %d", tc.expected, roundNumber)
+			}
+		})
+	}
+}
+
+func Test_ParseDurationErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"d",
+		"1",
+		"1.5d",
+		"1d12",
+		"P",
+		"PT",
+		"P1H",
+		"P1D2Y",
+		"PT1Y",
+		"P1.5Y",
+		"P1DT",
+		"1d 12h",
+		"1h1.5d",
+		"12hd",
+		"1d1d",
+		"1h30m1h",
+		"-1h",
+		"-1d",
+		"+1h",
+	}
+
+	for _, duration := range tests {
+		t.Run(duration, func(t *testing.T) {
+			if _, err := tlock.ParseDuration(time.Now(), duration); err == nil {
+				t.Fatalf("expecting parse error for %q", duration)
+			}
+		})
+	}
+}
+
+func Test_AddDuration(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatalf("load location: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		duration string
+		start    time.Time
+		expected time.Time
+	}{
+		{name: "mixed", duration: "1d12h", start: time.Date(2022, 01, 01, 0, 0, 0, 0, time.UTC), expected: time.Date(2022, 01, 02, 12, 0, 0, 0, time.UTC)},
+		{name: "monthEnd", duration: "1M", start: time.Date(2022, 01, 31, 0, 0, 0, 0, time.UTC), expected: time.Date(2022, 03, 03, 0, 0, 0, 0, time.UTC)},
+		{name: "yearMonth", duration: "1y1M", start: time.Date(2023, 01, 29, 0, 0, 0, 0, time.UTC), expected: time.Date(2024, 02, 29, 0, 0, 0, 0, time.UTC)},
+		{name: "daylightSaving", duration: "1d", start: time.Date(2025, 03, 29, 12, 0, 0, 0, paris), expected: time.Date(2025, 03, 30, 12, 0, 0, 0, paris)},
+		{name: "iso", duration: "P1MT1H", start: time.Date(2022, 02, 01, 0, 0, 0, 0, time.UTC), expected: time.Date(2022, 03, 01, 1, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			target, err := tlock.AddDuration(tc.start, tc.duration)
+			if err != nil {
+				t.Fatalf("unexpected parse error: %s", err)
+			}
+
+			if !target.Equal(tc.expected) {
+				t.Fatalf("expecting %s; got %s", tc.expected, target)
+			}
+		})
+	}
+
+	if _, err := tlock.AddDuration(time.Now(), "1d1C"); !errors.Is(err, tlock.ErrInvalidDuration) || !strings.Contains(err.Error(), `"C"`) {
+		t.Fatalf("expecting the invalid unit to be reported; got %v", err)
+	}
+}
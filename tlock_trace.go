@@ -0,0 +1,74 @@
+package tlock
+
+import (
+	"context"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the spans created by the package.
+const tracerName = "github.com/drand/tlock"
+
+// These keys name the attributes recorded on the spans.
+const (
+	attrChainHash = attribute.Key("tlock.chain_hash")
+	attrRound     = attribute.Key("tlock.round")
+	attrPlain     = attribute.Key("tlock.plain_bytes")
+	attrEncrypted = attribute.Key("tlock.encrypted_bytes")
+)
+
+// WithTracerProvider sets the OpenTelemetry tracer provider used to record
+// spans for encryption and decryption, with the chain hash, round and number
+// of bytes processed, and for unwrapping the data encryption key, which
+// includes retrieving the signature from the network. The default is the
+// global tracer provider, which does nothing unless the application
+// registered one.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(t *Tlock) {
+		t.tracerProvider = tp
+	}
+}
+
+// startSpan starts a span named after the operation.
+func (t Tlock) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tp := t.tracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+
+	return tp.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records the error of the operation, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// roundAttr returns the attribute recording a round number.
+func roundAttr(roundNumber uint64) attribute.KeyValue {
+	return attrRound.Int64(int64(roundNumber))
+}
+
+// =============================================================================
+
+// byteCounter counts the bytes written to the underlying writer, so they can
+// be recorded on a span.
+type byteCounter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements the io.Writer interface.
+func (bc *byteCounter) Write(p []byte) (int, error) {
+	n, err := bc.w.Write(p)
+	bc.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,150 @@
+package tlock_test
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/drand/tlock"
+	"github.com/drand/tlock/armor"
+	"github.com/drand/tlock/internal/codec"
+)
+
+var updateVectors = flag.Bool("update-vectors", false, "regenerate the test vectors of the deterministic mode")
+
+// vectorsDir holds data encrypted in the deterministic mode, which has to
+// stay byte for byte the same across versions.
+const vectorsDir = "test_artifacts/vectors"
+
+// vectorSeed is the seed the test vectors are encrypted with.
+var vectorSeed = []byte("tlock test vectors")
+
+// Test_DeterministicVectors encrypts the same data with the same seed and
+// options as the test vectors and checks that the output didn't change, so
+// the header serialization and the payload framing stay stable. The vectors
+// are regenerated with -update-vectors.
+func Test_DeterministicVectors(t *testing.T) {
+	data := bytes.Repeat([]byte("time lock test vector\n"), 8)
+	message := []byte("commit: 42")
+
+	tests := []struct {
+		name    string
+		plain   []byte
+		encrypt func(dst io.Writer, opts ...tlock.Option) error
+	}{
+		{"default", data, func(dst io.Writer, opts ...tlock.Option) error {
+			return tlock.New(corpusChain(), opts...).Encrypt(dst, bytes.NewReader(data), corpusRound)
+		}},
+		{"options", data, func(dst io.Writer, opts ...tlock.Option) error {
+			opts = append(opts,
+				tlock.WithAEAD(tlock.AES256GCM),
+				tlock.WithChunkSize(corpusChunk),
+				tlock.WithAAD([]byte("invoice-42")),
+				tlock.WithContentType("text"),
+				tlock.WithExtension("owner", "ops"),
+				tlock.WithExtension("env", "test"),
+				tlock.WithContentDigest(),
+			)
+			return tlock.New(corpusChain(), opts...).WithFileMode(0600).Encrypt(dst, bytes.NewReader(data), corpusRound)
+		}},
+		{"compact", message, func(dst io.Writer, opts ...tlock.Option) error {
+			opts = append(opts, tlock.WithCompact())
+			return tlock.New(corpusChain(), opts...).EncryptFrom(dst, bytes.NewReader(message), int64(len(message)), corpusRound)
+		}},
+		{"armored", data, func(dst io.Writer, opts ...tlock.Option) error {
+			w := armor.NewWriter(dst)
+			if err := tlock.New(corpusChain(), opts...).Encrypt(w, bytes.NewReader(data), corpusRound); err != nil {
+				return err
+			}
+			return w.Close()
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var first, second bytes.Buffer
+			if err := tt.encrypt(&first, tlock.WithDeterministicSeed(vectorSeed)); err != nil {
+				t.Fatalf("encrypt error %s", err)
+			}
+			if err := tt.encrypt(&second, tlock.WithDeterministicSeed(vectorSeed)); err != nil {
+				t.Fatalf("encrypt error %s", err)
+			}
+			if !bytes.Equal(first.Bytes(), second.Bytes()) {
+				t.Fatalf("expecting identical outputs for the same seed")
+			}
+
+			var other bytes.Buffer
+			if err := tt.encrypt(&other, tlock.WithDeterministicSeed([]byte("another seed"))); err != nil {
+				t.Fatalf("encrypt error %s", err)
+			}
+			if bytes.Equal(first.Bytes(), other.Bytes()) {
+				t.Fatalf("expecting different outputs for different seeds")
+			}
+
+			name := filepath.Join(vectorsDir, tt.name+".tle")
+			if *updateVectors {
+				if err := os.MkdirAll(vectorsDir, 0755); err != nil {
+					t.Fatalf("mkdir error %s", err)
+				}
+				if err := os.WriteFile(name, first.Bytes(), 0644); err != nil {
+					t.Fatalf("write error %s", err)
+				}
+			}
+
+			want, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatalf("read error %s", err)
+			}
+			if !bytes.Equal(first.Bytes(), want) {
+				t.Fatalf("expecting the output of %s", name)
+			}
+
+			opts := []tlock.Option{tlock.WithStrictParsing(true)}
+			if tt.name == "options" {
+				opts = append(opts, tlock.WithAAD([]byte("invoice-42")))
+			}
+			var plainData bytes.Buffer
+			if err := tlock.New(corpusChain(), opts...).Decrypt(&plainData, bytes.NewReader(want)); err != nil {
+				t.Fatalf("decrypt error %s", err)
+			}
+			if !bytes.Equal(plainData.Bytes(), tt.plain) {
+				t.Fatalf("expecting %q; got %q", tt.plain, plainData.Bytes())
+			}
+		})
+	}
+}
+
+// Test_CanonicalHeader checks that the header of encrypted data is written in
+// its canonical form, which parsing and writing again leaves unchanged.
+func Test_CanonicalHeader(t *testing.T) {
+	for _, name := range []string{"default", "options", "compact"} {
+		b, err := os.ReadFile(filepath.Join(vectorsDir, name+".tle"))
+		if err != nil {
+			t.Fatalf("read error %s", err)
+		}
+
+		hdr, _, err := codec.ParseHeader(bufio.NewReader(bytes.NewReader(b)), tlock.DefaultMaxHeaderSize)
+		if err != nil {
+			t.Fatalf("%s: parse header error %s", name, err)
+		}
+
+		var marshaled bytes.Buffer
+		if err := hdr.Marshal(&marshaled); err != nil {
+			t.Fatalf("%s: marshal header error %s", name, err)
+		}
+		if !bytes.Equal(marshaled.Bytes(), b[:headerEnd(b)]) {
+			t.Fatalf("%s: expecting the header to be written back unchanged; got\n%s", name, marshaled.Bytes())
+		}
+	}
+}
+
+func Test_DeterministicSeedWithConvergentKey(t *testing.T) {
+	tl := tlock.New(corpusChain(), tlock.WithDeterministicSeed(vectorSeed), tlock.WithConvergentKey([]byte("key")))
+	if err := tl.Encrypt(io.Discard, bytes.NewReader([]byte("commit: 42")), corpusRound); err == nil {
+		t.Fatalf("expecting an error")
+	}
+}
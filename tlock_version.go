@@ -0,0 +1,65 @@
+package tlock
+
+// These constants define the versions of the format of encrypted data. The
+// version is recorded in the header, and data without one predates
+// versioning and uses the first version. Decrypting data of a newer version
+// fails with ErrUnsupportedVersion instead of misreading it. The versions
+// are:
+//
+//	1: data locked to chains of SchemeUnchained
+//	2: data locked to chains of SchemeUnchainedG1, SchemeUnchainedOnG1, or
+//	   SchemeBN254UnchainedOnG1, recording the scheme
+//	3: data with a compact payload
+//	4: data recording a content digest or endpoint hints
+const (
+	MinFormatVersion = 1
+	FormatVersion    = 4
+)
+
+// metadataVersion is the format version required to decrypt data recording a
+// content digest or endpoint hints, which older versions reject as unknown
+// arguments when parsing strictly.
+const metadataVersion = 4
+
+// Capabilities describes what this version of tlock supports, so embedders
+// can report it or check it before handing data over.
+type Capabilities struct {
+	// MinFormatVersion and FormatVersion bound the format versions that can
+	// be decrypted. Data is encrypted with the lowest version supporting its
+	// features, so older versions of tlock can decrypt it whenever possible.
+	MinFormatVersion int
+	FormatVersion    int
+
+	// Schemes lists the drand schemes of the chains data can be locked to.
+	Schemes []string
+
+	// AEADs lists the payload algorithms that can be used.
+	AEADs []AEAD
+
+	// MaxChunkSize is the largest payload chunk size.
+	MaxChunkSize int
+
+	// MaxExtensions and MaxExtensionSize bound the extension area of the
+	// header.
+	MaxExtensions    int
+	MaxExtensionSize int
+}
+
+// Supported returns the capabilities of this version of tlock.
+func Supported() Capabilities {
+	return Capabilities{
+		MinFormatVersion: MinFormatVersion,
+		FormatVersion:    FormatVersion,
+		Schemes:          []string{SchemeUnchained, SchemeUnchainedG1, SchemeUnchainedOnG1, SchemeBN254UnchainedOnG1},
+		AEADs:            []AEAD{ChaCha20Poly1305, AES256GCM},
+		MaxChunkSize:     MaxChunkSize,
+		MaxExtensions:    MaxExtensions,
+		MaxExtensionSize: MaxExtensionSize,
+	}
+}
+
+// SupportsVersion reports whether data of the format version can be
+// decrypted.
+func (c Capabilities) SupportsVersion(version int) bool {
+	return version >= c.MinFormatVersion && version <= c.FormatVersion
+}
@@ -0,0 +1,121 @@
+package tlock
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/drand/tlock/networks"
+)
+
+// WarningCode identifies the condition a Warning reports, so callers can
+// handle some of them or silence others.
+type WarningCode string
+
+// These constants define the conditions reported as warnings. None of them
+// stops an operation.
+const (
+	// WarnRoundSoon reports an encryption for a round reached in less than
+	// RoundSoonThreshold, or already reached, which doesn't keep the data
+	// locked for long.
+	WarnRoundSoon WarningCode = "round-soon"
+
+	// WarnSlowNetwork reports a signature that took longer than
+	// SlowNetworkThreshold to retrieve.
+	WarnSlowNetwork WarningCode = "slow-network"
+
+	// WarnLegacyFormat reports data that predates versioning, written by
+	// older versions of tlock.
+	WarnLegacyFormat WarningCode = "legacy-format"
+
+	// WarnDigestNotVerified reports a content digest that isn't verified,
+	// because it's keyed and the tlock has no key or because the decryption
+	// was resumed.
+	WarnDigestNotVerified WarningCode = "digest-not-verified"
+)
+
+// These constants define when the timing warnings are reported.
+const (
+	RoundSoonThreshold   = 30 * time.Second
+	SlowNetworkThreshold = 5 * time.Second
+)
+
+// Warning describes a condition that doesn't stop an operation but that the
+// user may want to know about.
+type Warning struct {
+	Code    WarningCode
+	Message string
+}
+
+// String implements the fmt.Stringer interface.
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s", w.Code, w.Message)
+}
+
+// WithWarnings sets a function that is called with the warnings of the
+// operations, so user interfaces can display them instead of the debug
+// output. Without it, warnings are written to the logger. The function is
+// called synchronously and shouldn't block.
+func WithWarnings(fn func(Warning)) Option {
+	return func(t *Tlock) {
+		t.warnings = fn
+	}
+}
+
+// warn reports a warning to the warning function, or to the logger if there
+// is none.
+func (t Tlock) warn(code WarningCode, format string, v ...interface{}) {
+	w := Warning{Code: code, Message: fmt.Sprintf(format, v...)}
+
+	if t.warnings == nil {
+		t.logf("warning: %s", w)
+		return
+	}
+
+	t.warnings(w)
+}
+
+// warnRoundSoon warns when the round is reached too soon for the data to be
+// locked for long, if the network can tell when the round is reached.
+func (t Tlock) warnRoundSoon(roundNumber uint64) {
+	rt, ok := t.network.(networks.RoundTimer)
+	if !ok {
+		return
+	}
+
+	remaining := rt.RoundTime(roundNumber).Sub(t.clock.Now()).Round(time.Second)
+	switch {
+	case remaining <= 0:
+		t.warn(WarnRoundSoon, "round %d is already reached, so the data can be decrypted right away", roundNumber)
+	case remaining < RoundSoonThreshold:
+		t.warn(WarnRoundSoon, "round %d is reached in %s, so the data can be decrypted almost right away", roundNumber, remaining)
+	}
+}
+
+// warnSlowNetwork warns when retrieving the signature of the round took
+// longer than SlowNetworkThreshold.
+func (t Tlock) warnSlowNetwork(roundNumber uint64, elapsed time.Duration) {
+	if elapsed > SlowNetworkThreshold {
+		t.warn(WarnSlowNetwork, "retrieving the signature of round %d took %s", roundNumber, elapsed.Round(time.Millisecond))
+	}
+}
+
+// warnLegacyFormat warns when the tlock stanza doesn't record a format
+// version.
+func (t Tlock) warnLegacyFormat(stanzas []*age.Stanza) {
+	for _, s := range stanzas {
+		if s.Type != "tlock" {
+			continue
+		}
+
+		for _, arg := range s.Args {
+			if strings.HasPrefix(arg, "v=") {
+				return
+			}
+		}
+
+		t.warn(WarnLegacyFormat, "the data was written before format versions were recorded")
+		return
+	}
+}
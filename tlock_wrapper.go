@@ -0,0 +1,179 @@
+package tlock
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"filippo.io/age"
+	"github.com/drand/tlock/internal/codec"
+)
+
+// RecipientWrapper wraps the data encryption key (DEK) of the payload into
+// the stanzas of the header, and unwraps it back from them. The time lock
+// encryption of a Tlock is the first implementation. Other recipients, such
+// as a KMS, age X25519 keys or passphrases, implement it to reuse the header
+// and payload encryption with EncryptWithWrapper and DecryptWithWrapper.
+type RecipientWrapper interface {
+	// WrapDEK returns the stanzas recording the DEK.
+	WrapDEK(ctx context.Context, dek []byte) ([]*age.Stanza, error)
+
+	// UnwrapDEK returns the DEK recorded in the stanzas. It fails with an
+	// error matching age.ErrIncorrectIdentity if none of the stanzas is
+	// meant for it.
+	UnwrapDEK(ctx context.Context, stanzas []*age.Stanza) ([]byte, error)
+}
+
+// EncryptWithWrapper encrypts the source to the destination with the DEK
+// wrapped by the wrapper. The payload uses ChaCha20Poly1305 and the default
+// chunk size, so the data follows the age format.
+func EncryptWithWrapper(ctx context.Context, w RecipientWrapper, dst io.Writer, src io.Reader) error {
+	dek := make([]byte, codec.FileKeySize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return fmt.Errorf("generate dek: %w", err)
+	}
+
+	stanzas, err := w.WrapDEK(ctx, dek)
+	if err != nil {
+		return fmt.Errorf("wrap dek: %w", err)
+	}
+
+	if err := writeHeader(dst, stanzas, dek); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+
+	nonce := make([]byte, codec.PayloadNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("generate nonce: %w", err)
+	}
+
+	if _, err := dst.Write(nonce); err != nil {
+		return fmt.Errorf("write nonce: %w", err)
+	}
+
+	aead, err := payloadAEAD(ChaCha20Poly1305, dek, nonce)
+	if err != nil {
+		return err
+	}
+
+	sw := newStreamWriter(aead, nil, dst, DefaultChunkSize)
+	if _, err := io.Copy(sw, contextReader{ctx: ctx, r: src}); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	if err := sw.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+
+	return nil
+}
+
+// DecryptWithWrapper decrypts data written by EncryptWithWrapper, or by age
+// for the recipients of the wrapper, with the DEK unwrapped by the wrapper.
+// Armored data is decoded.
+func DecryptWithWrapper(ctx context.Context, w RecipientWrapper, dst io.Writer, src io.Reader) error {
+	br, text, _ := dearmor(src)
+
+	hdr, raw, err := parseHeader(br, DefaultMaxHeaderSize)
+	if err != nil {
+		return fmt.Errorf("parse header: %w", text.check(err))
+	}
+
+	dek, err := w.UnwrapDEK(ctx, hdr.Stanzas)
+	if err != nil {
+		return fmt.Errorf("unwrap dek: %w", err)
+	}
+
+	mac, err := codec.HeaderMAC(dek, raw)
+	if err != nil {
+		return err
+	}
+
+	if !codec.Equal(mac, hdr.MAC) {
+		return ErrHeaderMACMismatch
+	}
+
+	nonce := make([]byte, codec.PayloadNonceSize)
+	if _, err := io.ReadFull(br, nonce); err != nil {
+		return fmt.Errorf("read nonce: %w", text.check(err))
+	}
+
+	aead, err := payloadAEAD(ChaCha20Poly1305, dek, nonce)
+	if err != nil {
+		return err
+	}
+
+	r := newStreamReader(aead, nil, br, DefaultChunkSize)
+	if err := r.copyTo(ctx, dst, nil); err != nil {
+		return fmt.Errorf("write: %w", text.check(err))
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// AgeWrapper adapts age recipients and identities, such as X25519 keys or
+// scrypt passphrases, to the RecipientWrapper interface. The DEK is wrapped
+// for every recipient and unwrapped by the first identity that matches a
+// stanza.
+type AgeWrapper struct {
+	Recipients []age.Recipient
+	Identities []age.Identity
+}
+
+// WrapDEK implements the RecipientWrapper interface.
+func (w AgeWrapper) WrapDEK(ctx context.Context, dek []byte) ([]*age.Stanza, error) {
+	if len(w.Recipients) == 0 {
+		return nil, errors.New("no recipients")
+	}
+
+	var stanzas []*age.Stanza
+	for _, r := range w.Recipients {
+		s, err := r.Wrap(dek)
+		if err != nil {
+			return nil, err
+		}
+		stanzas = append(stanzas, s...)
+	}
+
+	return stanzas, nil
+}
+
+// UnwrapDEK implements the RecipientWrapper interface.
+func (w AgeWrapper) UnwrapDEK(ctx context.Context, stanzas []*age.Stanza) ([]byte, error) {
+	for _, id := range w.Identities {
+		dek, err := id.Unwrap(stanzas)
+		if errors.Is(err, age.ErrIncorrectIdentity) {
+			continue
+		}
+		return dek, err
+	}
+
+	return nil, age.ErrIncorrectIdentity
+}
+
+// =============================================================================
+
+// timelockWrapper is the RecipientWrapper of a Tlock, which locks the DEK to
+// a round of its network with identity based encryption.
+type timelockWrapper struct {
+	recipient *tleRecipient
+	identity  *tleIdentity
+}
+
+// WrapDEK implements the RecipientWrapper interface.
+func (w timelockWrapper) WrapDEK(ctx context.Context, dek []byte) ([]*age.Stanza, error) {
+	return w.recipient.Wrap(dek)
+}
+
+// UnwrapDEK implements the RecipientWrapper interface. The signature of the
+// round is retrieved with the context.
+func (w timelockWrapper) UnwrapDEK(ctx context.Context, stanzas []*age.Stanza) ([]byte, error) {
+	identity := *w.identity
+	identity.ctx = ctx
+
+	return identity.Unwrap(stanzas)
+}